@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/blytz.live.remake/backend/internal/config"
+	"github.com/blytz.live.remake/backend/internal/database"
+	"github.com/blytz.live.remake/backend/internal/ledger"
+)
+
+func main() {
+	fmt.Println("🔎 Checking ledger invariants...")
+
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Failed to load config: ", err)
+	}
+
+	// Connect to database
+	db, err := database.NewConnection(cfg.DatabaseURL())
+	if err != nil {
+		log.Fatal("Failed to connect to database: ", err)
+	}
+
+	report, err := ledger.NewService(db).CheckInvariants(context.Background())
+	if err != nil {
+		log.Fatal("Failed to check ledger: ", err)
+	}
+
+	fmt.Printf("📊 Checked %d transactions across %d accounts\n", report.Transactions, report.Accounts)
+	if !report.OK() {
+		for _, violation := range report.Violations {
+			fmt.Println("❌ " + violation)
+		}
+		os.Exit(1)
+	}
+
+	fmt.Println("✅ Ledger is balanced")
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/config"
+	"github.com/blytz.live.remake/backend/internal/database"
+	"github.com/blytz.live.remake/backend/internal/payments"
+	"github.com/blytz.live.remake/backend/internal/reconciliation"
+)
+
+func main() {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	fromFlag := flag.String("from", today.AddDate(0, 0, -1).Format("2006-01-02"), "first day to reconcile (YYYY-MM-DD, UTC)")
+	toFlag := flag.String("to", "", "day after the last day to reconcile (YYYY-MM-DD, UTC); defaults to the day after -from")
+	flag.Parse()
+
+	from, err := time.Parse("2006-01-02", *fromFlag)
+	if err != nil {
+		log.Fatal("Invalid -from date: ", err)
+	}
+	to := from.AddDate(0, 0, 1)
+	if *toFlag != "" {
+		if to, err = time.Parse("2006-01-02", *toFlag); err != nil {
+			log.Fatal("Invalid -to date: ", err)
+		}
+	}
+
+	fmt.Printf("🔎 Reconciling payments from %s to %s...\n", from.Format("2006-01-02"), to.Format("2006-01-02"))
+
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Failed to load config: ", err)
+	}
+
+	// Connect to database
+	db, err := database.NewConnection(cfg.DatabaseURL())
+	if err != nil {
+		log.Fatal("Failed to connect to database: ", err)
+	}
+
+	var gateway payments.PaymentGateway
+	switch cfg.PaymentGateway {
+	case "fake":
+		gateway = payments.NewFakeGateway()
+	default:
+		gateway = payments.NewStripeGateway(cfg.StripeSecretKey, cfg.StripeWebhookSecret)
+	}
+
+	run, err := reconciliation.NewService(db, gateway).Reconcile(context.Background(), from, to)
+	if err != nil {
+		log.Fatal("Failed to reconcile: ", err)
+	}
+	if run.Status == reconciliation.StatusFailed {
+		fmt.Println("❌ " + *run.Error)
+		os.Exit(1)
+	}
+
+	fmt.Printf("📊 %s: %d matched, %d missing, %d mismatched, %d orphaned\n",
+		run.Gateway, run.Matched, run.Missing, run.Mismatched, run.Orphaned)
+	if len(run.Items) > 0 {
+		for _, item := range run.Items {
+			fmt.Printf("❌ %s: %s\n", item.Problem, item.Detail)
+		}
+		os.Exit(1)
+	}
+
+	fmt.Println("✅ Payments reconciled")
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/blytz.live.remake/backend/internal/addresses"
@@ -13,12 +15,21 @@ import (
 	"github.com/blytz.live.remake/backend/internal/catalog"
 	"github.com/blytz.live.remake/backend/internal/common"
 	"github.com/blytz.live.remake/backend/internal/config"
+	"github.com/blytz.live.remake/backend/internal/credits"
 	"github.com/blytz.live.remake/backend/internal/database"
+	"github.com/blytz.live.remake/backend/internal/fees"
+	"github.com/blytz.live.remake/backend/internal/invoices"
+	"github.com/blytz.live.remake/backend/internal/ledger"
+	"github.com/blytz.live.remake/backend/internal/livekit"
 	"github.com/blytz.live.remake/backend/internal/middleware"
 	"github.com/blytz.live.remake/backend/internal/models"
 	"github.com/blytz.live.remake/backend/internal/orders"
 	"github.com/blytz.live.remake/backend/internal/payments"
+	"github.com/blytz.live.remake/backend/internal/payouts"
 	"github.com/blytz.live.remake/backend/internal/products"
+	"github.com/blytz.live.remake/backend/internal/reconciliation"
+	"github.com/blytz.live.remake/backend/internal/subscriptions"
+	"github.com/blytz.live.remake/backend/pkg/mail"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
@@ -64,6 +75,8 @@ func main() {
 				&models.CartItem{},
 				&models.Order{},
 				&models.OrderItem{},
+				&models.FeeRule{},
+				&models.OrderFee{},
 				&models.ProductVariant{},
 				&models.CategoryAttribute{},
 				&models.ProductCollection{},
@@ -73,16 +86,43 @@ func main() {
 				&models.Bid{},
 				&models.AutoBid{},
 				&models.AuctionWatch{},
+				&models.AuctionReminder{},
+				&models.IdempotencyKey{},
 				&models.AuctionStats{},
 				&models.LiveStream{},
+				&models.StreamRecording{},
+				&models.HighlightClip{},
+				&models.StreamIngress{},
+				&models.StreamParticipant{},
+				&models.StreamMetricSample{},
+				&models.StreamAlert{},
+				&models.StreamWebhookEvent{},
 				&models.ChatMessage{},
 				&models.Payment{},
 				&models.PaymentMethod{},
+				&models.PaymentCustomer{},
 				&models.PaymentIntent{},
 				&models.Refund{},
+				&models.RefundItem{},
+				&models.Dispute{},
+				&models.DisputeEvidence{},
+				&models.WebhookEvent{},
 				&models.Transaction{},
+				&models.LedgerAccount{},
+				&models.LedgerEntry{},
 				&models.Payout{},
+				&models.PayoutItem{},
+				&models.PayoutSettings{},
+				&models.ReconciliationRun{},
+				&models.ReconciliationItem{},
 				&models.Subscription{},
+				&models.GiftCard{},
+				&models.StoreCreditWallet{},
+				&models.CreditMovement{},
+				&models.Invoice{},
+				&models.InvoiceLine{},
+				&models.InvoiceSequence{},
+				&models.InvoiceProfile{},
 			)
 			if err != nil {
 				log.Printf("Warning: Failed to auto-migrate database: %v", err)
@@ -108,6 +148,13 @@ func main() {
 						log.Println("✅ Test category created")
 					}
 				}
+
+				// Seed the default marketplace commission on first start
+				if created, err := fees.NewService(db).SeedDefaultRule(context.Background()); err != nil {
+					log.Printf("Warning: Failed to create default fee rule: %v", err)
+				} else if created {
+					log.Println("✅ Default fee rule created")
+				}
 			}
 		}
 	}
@@ -144,7 +191,7 @@ func main() {
 	cors := cors.New(cors.Config{
 		AllowOrigins:     []string{"https://blytz.app", "http://localhost:5173", "http://localhost:3000", "http://localhost:8080"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Authorization", "Content-Type", "X-Requested-With"},
+		AllowHeaders:     []string{"Authorization", "Content-Type", "X-Requested-With", "Last-Event-ID", "Idempotency-Key"},
 		AllowCredentials: true,
 	})
 	router.Use(cors)
@@ -200,11 +247,19 @@ func main() {
 	var catalogHandler *catalog.Handler
 	var auctionHandler *auction.Handler
 	var paymentHandler *payments.Handler
+	var ledgerHandler *ledger.Handler
+	var payoutHandler *payouts.Handler
+	var feeHandler *fees.Handler
+	var reconciliationHandler *reconciliation.Handler
+	var subscriptionHandler *subscriptions.Handler
+	var creditHandler *credits.Handler
+	var invoiceHandler *invoices.Handler
 	var cartService *cart.Service
 	var orderService *orders.Service
 	var auctionService *auction.Service
 	var paymentService *payments.Service
 	var wsManager *auction.WebSocketManager
+	var livekitHandler *livekit.Handler
 	if db != nil {
 		log.Println("✅ Database available - Initializing authentication system")
 
@@ -237,30 +292,256 @@ func main() {
 		auctionService = auction.NewService(db)
 		auctionHandler = auction.NewHandler(auctionService)
 
+		auctionService.SetDefaultReminderLead(cfg.ReminderLeadMins)
+		if cfg.SMTPHost != "" {
+			mailer := mail.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPFrom)
+			auctionService.SetReminderNotifier(auction.NewEmailReminderNotifier(db, mailer, cfg.AppURL))
+		} else {
+			log.Println("Warning: SMTP_HOST is not set; show reminders are only logged")
+		}
+
+		// Send "notify me" reminders as shows approach their start time
+		go func() {
+			ticker := time.NewTicker(time.Minute)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				if _, err := auctionService.DispatchDueReminders(context.Background(), now); err != nil {
+					log.Printf("Warning: Failed to dispatch auction reminders: %v", err)
+				}
+			}
+		}()
+
 		// Initialize WebSocket manager for auctions
 		wsManager = auction.NewWebSocketManager(db, auctionService)
 
 		// Set WebSocket manager in auction service for notifications
 		auctionService.SetWebSocketManager(wsManager)
 
-		// Initialize payment service
-		paymentService = payments.NewService(db, cfg.StripeSecretKey)
+		// Initialize payment service with the configured payment gateway
+		var paymentGateway payments.PaymentGateway
+		switch cfg.PaymentGateway {
+		case "fake":
+			fakeGateway := payments.NewFakeGateway()
+			if cfg.FakeWebhookSecret != "" {
+				fakeGateway.SetWebhookSecret(cfg.FakeWebhookSecret)
+			}
+			paymentGateway = fakeGateway
+		default:
+			paymentGateway = payments.NewStripeGateway(cfg.StripeSecretKey, cfg.StripeWebhookSecret)
+		}
+		log.Printf("✅ Payment gateway: %s", paymentGateway.Name())
+
+		paymentService = payments.NewService(db, paymentGateway)
 		paymentHandler = payments.NewHandler(paymentService)
 
+		// Payments and refunds post balanced entries to the double-entry ledger
+		ledgerService := ledger.NewService(db)
+		ledgerHandler = ledger.NewHandler(ledgerService)
+		paymentService.SetLedger(ledgerService)
+
+		// Fee rules are charged on each order when it is paid
+		feeService := fees.NewService(db)
+		feeService.SetLedger(ledgerService)
+		feeHandler = fees.NewHandler(feeService)
+		orderService.SetFeeAssessor(feeService)
+
+		// Pay sellers their delivered orders' earnings once the holding period is over
+		var payoutProvider payouts.Provider
+		switch cfg.PayoutProvider {
+		case "fake":
+			payoutProvider = payouts.NewFakeProvider()
+		default:
+			payoutProvider = payouts.NewStripeProvider(cfg.StripeSecretKey)
+		}
+		payoutService := payouts.NewService(db, payoutProvider, time.Duration(cfg.PayoutHoldDays)*24*time.Hour)
+		payoutService.SetLedger(ledgerService)
+		payoutHandler = payouts.NewHandler(payoutService)
+		log.Printf("✅ Payout provider: %s", payoutProvider.Name())
+
+		// Run due payout schedules and sync payouts in transit every hour
+		go func() {
+			ticker := time.NewTicker(time.Hour)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				if _, err := payoutService.RunScheduledPayouts(context.Background(), now); err != nil {
+					log.Printf("Warning: Failed to run scheduled payouts: %v", err)
+				}
+				if _, err := payoutService.SyncPayouts(context.Background()); err != nil {
+					log.Printf("Warning: Failed to sync payouts: %v", err)
+				}
+			}
+		}()
+
+		// Reconcile the previous day's payments against the gateway once it is over
+		reconciliationService := reconciliation.NewService(db, paymentGateway)
+		reconciliationHandler = reconciliation.NewHandler(reconciliationService)
+		go func() {
+			ticker := time.NewTicker(time.Hour)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				if _, err := reconciliationService.RunDaily(context.Background(), now.UTC()); err != nil {
+					log.Printf("Warning: Failed to reconcile payments: %v", err)
+				}
+			}
+		}()
+
+		// Seller plans are charged to saved payment methods and limit live auctions
+		subscriptionService := subscriptions.NewService(db, paymentService)
+		subscriptionService.SetLedger(ledgerService)
+		subscriptionHandler = subscriptions.NewHandler(subscriptionService)
+		auctionService.SetEntitlements(subscriptionService)
+
+		// Renew subscriptions whose period has ended and retry failed renewals every hour
+		go func() {
+			ticker := time.NewTicker(time.Hour)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				if _, err := subscriptionService.RenewDue(context.Background(), now); err != nil {
+					log.Printf("Warning: Failed to renew subscriptions: %v", err)
+				}
+			}
+		}()
+
+		// Orders open their payment at checkout and move on as it settles
+		orderService.SetPaymentOpener(paymentService)
+		paymentService.SetOrderPaymentHandler(orderService)
+
+		// Gift cards and store credit pay for orders at checkout; refunds can go to store credit
+		creditService := credits.NewService(db)
+		creditService.SetLedger(ledgerService)
+		creditHandler = credits.NewHandler(creditService)
+		orderService.SetCreditRedeemer(creditService)
+		paymentService.SetStoreCredit(creditService)
+
+		// Sellers invoice paid orders and issue credit notes for refunds
+		invoiceService := invoices.NewService(db)
+		invoiceHandler = invoices.NewHandler(invoiceService)
+		orderService.SetInvoicer(invoiceService)
+
+		// Expire gift cards past their expiry date every hour, recognizing their balance
+		go func() {
+			ticker := time.NewTicker(time.Hour)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				if _, err := creditService.ExpireGiftCards(context.Background(), now); err != nil {
+					log.Printf("Warning: Failed to expire gift cards: %v", err)
+				}
+			}
+		}()
+
+		// Auction winners are charged off-session to their default payment method
+		auctionService.SetWinnerOrders(orderService)
+
+		// Flag saved cards past their expiry date every hour, replacing expired defaults
+		go func() {
+			ticker := time.NewTicker(time.Hour)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				if _, err := paymentService.FlagExpiredPaymentMethods(context.Background(), now); err != nil {
+					log.Printf("Warning: Failed to flag expired payment methods: %v", err)
+				}
+			}
+		}()
+
+		// Process stored payment webhooks as they arrive and retry failed ones every minute
+		go paymentService.RunWebhookWorker(context.Background(), time.Minute)
+
+		// Expire unpaid payment intents every minute, releasing the stock their orders hold
+		go func() {
+			ticker := time.NewTicker(time.Minute)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				if _, err := paymentService.ExpirePaymentIntents(context.Background(), now); err != nil {
+					log.Printf("Warning: Failed to expire payment intents: %v", err)
+				}
+			}
+		}()
+
+		// Send again refunds left processing by a gateway timeout or a restart
+		go func() {
+			ticker := time.NewTicker(time.Minute)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				if _, err := paymentService.RetryProcessingRefunds(context.Background(), now.Add(-5*time.Minute)); err != nil {
+					log.Printf("Warning: Failed to retry processing refunds: %v", err)
+				}
+			}
+		}()
+
 		// Initialize address service
 		addressService := addresses.NewService(db)
 		addressHandler := addresses.NewHandler(addressService)
 
-		// Set Stripe webhook secret in handler context (using a global variable for now)
-		// router.Set("stripe_webhook_secret", cfg.StripeWebhookSecret)
+		// Initialize LiveKit service with the configured stream provider
+		var streamProvider livekit.StreamProvider
+		switch cfg.StreamProvider {
+		case "fake":
+			fakeProvider := livekit.NewFakeProvider()
+			if cfg.FakeStreamSecret != "" {
+				fakeProvider.SetWebhookSecret(cfg.FakeStreamSecret)
+			}
+			streamProvider = fakeProvider
+		default:
+			streamProvider = livekit.NewLiveKitProvider(cfg.LiveKitHost, cfg.LiveKitAPIKey, cfg.LiveKitAPISecret)
+		}
+		log.Printf("✅ Stream provider: %s", streamProvider.Name())
+
+		livekitService := livekit.NewService(db, streamProvider)
+		livekitHandler = livekit.NewHandler(livekitService)
+
+		// Create a stream room whenever an auction is created
+		auctionService.SetRoomProvisioner(livekitService)
+
+		// Push stream health alerts to the host over the auction socket
+		livekitService.SetAlertNotifier(wsManager)
+
+		// End live auctions whose host has left the stream for too long
+		livekitService.SetAuctionEnder(auctionService, time.Duration(cfg.StreamHostGraceSecs)*time.Second)
+
+		// Store stream recordings on the local filesystem and purge them after the retention period
+		recordingStorage := livekit.NewLocalStorage(cfg.RecordingDir, cfg.RecordingBaseURL)
+		livekitService.SetRecordingStorage(recordingStorage, time.Duration(cfg.RecordingRetention)*24*time.Hour)
 
-		// Initialize LiveKit service
-		// livekitService = livekit.NewService(db,
-		// 	getEnv("LIVEKIT_HOST", "http://localhost:7880"),
-		// 	getEnv("LIVEKIT_API_KEY", ""),
-		// 	getEnv("LIVEKIT_API_SECRET", ""),
-		// )
-		// livekitHandler = livekit.NewHandler(livekitService)
+		// Alert hosts whose stream stopped sending video, even when metrics stop arriving
+		go func() {
+			ticker := time.NewTicker(10 * time.Second)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				if _, err := livekitService.CheckSilentStreams(context.Background(), now); err != nil {
+					log.Printf("Warning: Failed to check silent streams: %v", err)
+				}
+			}
+		}()
+
+		// Each seller's plan decides how long their recordings are kept
+		livekitService.SetRecordingEntitlements(subscriptionService)
+		if strings.HasPrefix(cfg.RecordingBaseURL, "/") {
+			router.Static(cfg.RecordingBaseURL, recordingStorage.Dir())
+		}
+
+		go func() {
+			ticker := time.NewTicker(time.Hour)
+			defer ticker.Stop()
+			for range ticker.C {
+				if _, err := livekitService.PurgeExpiredRecordings(context.Background()); err != nil {
+					log.Printf("Warning: Failed to purge expired recordings: %v", err)
+				}
+			}
+		}()
+
+		// Replay responses to retried mutations sent with an Idempotency-Key header
+		idempotencyStore := middleware.NewIdempotencyStore(redisClient, db)
+		idempotent := middleware.Idempotency(idempotencyStore)
+
+		go func() {
+			ticker := time.NewTicker(time.Hour)
+			defer ticker.Stop()
+			for range ticker.C {
+				if _, err := middleware.NewDBIdempotencyStore(db).PurgeExpired(context.Background()); err != nil {
+					log.Printf("Warning: Failed to purge idempotency keys: %v", err)
+				}
+			}
+		}()
 
 		// API v1 routes
 		v1 := router.Group("/api/v1")
@@ -292,34 +573,42 @@ func main() {
 		{
 			auctionsGroup.GET("", auctionHandler.ListAuctions)
 			auctionsGroup.GET("/live", auctionHandler.GetLiveAuctions)
+			auctionsGroup.GET("/calendar", auctionHandler.GetShowCalendar)
+			auctionsGroup.GET("/sellers/:seller_id/calendar.ics", auctionHandler.GetSellerCalendarFeed)
 			auctionsGroup.GET("/:id", auctionHandler.GetAuction)
 			auctionsGroup.GET("/:id/bids", auctionHandler.GetAuctionBids)
 			auctionsGroup.GET("/:id/stats", auctionHandler.GetAuctionStats)
+			auctionsGroup.GET("/:id/events", wsManager.HandleSSE) // SSE fallback for read-only viewers
 		}
 
 		// WebSocket route for auctions
 		router.GET("/ws/auctions", wsManager.HandleWebSocket)
 
-		// LiveKit streaming routes (temporarily disabled)
-		// livekitGroup := v1.Group("/livekit")
-		// {
-		// 	livekitGroup.GET("/streams", livekitHandler.ListActiveStreams)
-		// 	livekitGroup.GET("/auctions/:auction_id/stream", livekitHandler.GetStreamInfo)
-		// 	livekitGroup.GET("/auctions/:auction_id/token/viewer", livekitHandler.GetViewerToken)
-		// 	livekitGroup.GET("/auctions/:auction_id/recording", livekitHandler.GetStreamRecording)
-		// }
+		// LiveKit streaming routes
+		livekitGroup := v1.Group("/livekit")
+		{
+			livekitGroup.GET("/streams", livekitHandler.ListActiveStreams)
+			livekitGroup.GET("/auctions/:auction_id/stream", livekitHandler.GetStreamInfo)
+			livekitGroup.GET("/auctions/:auction_id/token/viewer", livekitHandler.GetViewerToken)
+			livekitGroup.GET("/auctions/:auction_id/recording", livekitHandler.GetStreamRecording)
+			livekitGroup.GET("/auctions/:auction_id/clips", livekitHandler.ListAuctionClips)
+		}
 
 		// Payment routes
 		paymentsGroup := v1.Group("/payments")
 		{
 			paymentsGroup.GET("/methods", paymentHandler.GetPaymentMethods)
-			paymentsGroup.POST("/intents", paymentHandler.CreatePaymentIntent)
-			paymentsGroup.POST("/confirm", paymentHandler.ConfirmPayment)
 		}
 
+		// Public subscription plan catalog
+		v1.GET("/subscriptions/plans", subscriptionHandler.ListPlans)
+
 		// Webhook route for Stripe
 		router.POST("/webhooks/stripe", paymentHandler.ProcessWebhook)
 
+		// Webhook route for LiveKit room, participant, egress and ingress events
+		router.POST("/webhooks/livekit", livekitHandler.ReceiveWebhook)
+
 		// Public cart routes (with middleware)
 		cartGroup := v1.Group("/cart")
 		cartGroup.Use(cart.CartMiddleware(cartService))
@@ -360,18 +649,22 @@ func main() {
 			protectedAuctionGroup := protected.Group("/auctions")
 			{
 				protectedAuctionGroup.POST("", auctionHandler.CreateAuction)
-				protectedAuctionGroup.POST("/:id/bid", auctionHandler.PlaceBid)
+				protectedAuctionGroup.POST("/:id/bid", idempotent, auctionHandler.PlaceBid)
 				protectedAuctionGroup.POST("/:id/autobid", auctionHandler.SetAutoBid)
 				protectedAuctionGroup.POST("/:id/join", auctionHandler.JoinAuction)
 				protectedAuctionGroup.POST("/:id/leave", auctionHandler.LeaveAuction)
-				protectedAuctionGroup.PUT("/:id/start", auctionHandler.StartAuction) // Seller/admin
-				protectedAuctionGroup.PUT("/:id/end", auctionHandler.EndAuction)     // Seller/admin
+				protectedAuctionGroup.GET("/reminders", auctionHandler.ListMyReminders)
+				protectedAuctionGroup.POST("/:id/reminder", auctionHandler.SubscribeReminder)
+				protectedAuctionGroup.DELETE("/:id/reminder", auctionHandler.CancelReminder)
+				protectedAuctionGroup.PUT("/:id/schedule", auctionHandler.RescheduleAuction) // Seller/admin
+				protectedAuctionGroup.PUT("/:id/start", auctionHandler.StartAuction)         // Seller/admin
+				protectedAuctionGroup.PUT("/:id/end", auctionHandler.EndAuction)             // Seller/admin
 			}
 
 			// Order routes
 			ordersGroup := protected.Group("/orders")
 			{
-				ordersGroup.POST("", orderHandler.CreateOrder)
+				ordersGroup.POST("", idempotent, orderHandler.CreateOrder)
 				ordersGroup.GET("", orderHandler.ListOrders)
 				ordersGroup.GET("/:id", orderHandler.GetOrder)
 				ordersGroup.PUT("/:id/status", orderHandler.UpdateOrderStatus) // Admin/seller
@@ -383,34 +676,100 @@ func main() {
 			{
 				protectedPaymentGroup.GET("/methods", paymentHandler.GetUserPaymentMethods)
 				protectedPaymentGroup.POST("/methods", paymentHandler.SavePaymentMethod)
+				protectedPaymentGroup.GET("/methods/:id", paymentHandler.GetUserPaymentMethod)
+				protectedPaymentGroup.PUT("/methods/:id", paymentHandler.UpdatePaymentMethod)
+				protectedPaymentGroup.POST("/methods/:id/default", paymentHandler.SetDefaultPaymentMethod)
+				protectedPaymentGroup.DELETE("/methods/:id", paymentHandler.DeletePaymentMethod)
+				protectedPaymentGroup.POST("/intents", idempotent, paymentHandler.CreatePaymentIntent)
+				protectedPaymentGroup.POST("/confirm", paymentHandler.ConfirmPayment)
 				protectedPaymentGroup.GET("/:id", paymentHandler.GetPaymentIntent)
+				protectedPaymentGroup.POST("/:id/cancel", paymentHandler.CancelPaymentIntent)
 			}
 
+			// Ledger balances of the current user
+			protected.GET("/ledger/balances", ledgerHandler.GetMyBalances)
+
+			// Store credit of the current user and gift card balances
+			protected.GET("/store-credit", creditHandler.GetMyStoreCredit)
+			protected.GET("/store-credit/movements", creditHandler.ListMyCreditMovements)
+			protected.GET("/gift-cards/:code", creditHandler.CheckGiftCard)
+
+			// Invoices and credit notes of the current user as a buyer, and their invoice details
+			protected.GET("/invoices", invoiceHandler.ListMyInvoices)
+			protected.GET("/invoices/profile", invoiceHandler.GetProfile)
+			protected.PUT("/invoices/profile", invoiceHandler.UpdateProfile)
+			protected.GET("/invoices/:id", invoiceHandler.GetInvoice)
+			protected.GET("/invoices/:id/html", invoiceHandler.GetInvoiceHTML)
+			protected.GET("/invoices/:id/pdf", invoiceHandler.GetInvoicePDF)
+
 			// Address routes
 			addressHandler.RegisterRoutes(v1.Group("/"), authHandler)
 
-			// Protected LiveKit routes (temporarily disabled)
-			// protectedLivekitGroup := protected.Group("/livekit")
-			// protectedLivekitGroup.Use(authHandler.RequireAuth())
-			// protectedLivekitGroup.Use(authHandler.RequireSellerOrAdmin())
-			// {
-			// 	protectedLivekitGroup.POST("/auctions/:auction_id/rooms", livekitHandler.CreateAuctionRoom)
-			// 	protectedLivekitGroup.GET("/auctions/:auction_id/token/host", livekitHandler.GetHostToken)
-			// 	protectedLivekitGroup.POST("/auctions/:auction_id/start", livekitHandler.StartAuctionStream)
-			// 	protectedLivekitGroup.POST("/auctions/:auction_id/end", livekitHandler.EndAuctionStream)
-			// 	protectedLivekitGroup.POST("/auctions/:auction_id/record", livekitHandler.RecordStream)
-			// 	protectedLivekitGroup.POST("/auctions/:auction_id/metrics", livekitHandler.UpdateStreamMetrics)
-			// 	protectedLivekitGroup.GET("/auctions/:auction_id/recording-url", livekitHandler.GenerateStreamRecordingURL)
-			// }
+			// Protected LiveKit routes
+			protectedLivekitGroup := protected.Group("/livekit")
+			protectedLivekitGroup.Use(authHandler.RequireSellerOrAdmin())
+			{
+				protectedLivekitGroup.GET("/recordings", livekitHandler.ListMyRecordings)
+				protectedLivekitGroup.GET("/ingresses", livekitHandler.ListIngresses)
+				protectedLivekitGroup.POST("/ingresses", livekitHandler.CreateIngress)
+				protectedLivekitGroup.POST("/ingresses/:id/rotate", livekitHandler.RotateIngressKey)
+				protectedLivekitGroup.PUT("/ingresses/:id/auction", livekitHandler.AttachIngress)
+				protectedLivekitGroup.DELETE("/ingresses/:id", livekitHandler.RevokeIngress)
+				protectedLivekitGroup.POST("/auctions/:auction_id/rooms", livekitHandler.CreateAuctionRoom)
+				protectedLivekitGroup.GET("/auctions/:auction_id/token/host", livekitHandler.GetHostToken)
+				protectedLivekitGroup.POST("/auctions/:auction_id/start", livekitHandler.StartAuctionStream)
+				protectedLivekitGroup.POST("/auctions/:auction_id/end", livekitHandler.EndAuctionStream)
+				protectedLivekitGroup.POST("/auctions/:auction_id/record", livekitHandler.RecordStream)
+				protectedLivekitGroup.POST("/auctions/:auction_id/metrics", livekitHandler.UpdateStreamMetrics)
+				protectedLivekitGroup.GET("/auctions/:auction_id/metrics", livekitHandler.ListStreamMetrics)
+				protectedLivekitGroup.GET("/auctions/:auction_id/quality-report", livekitHandler.GetQualityReport)
+				protectedLivekitGroup.GET("/auctions/:auction_id/recording-url", livekitHandler.GenerateStreamRecordingURL)
+				protectedLivekitGroup.POST("/auctions/:auction_id/clips", livekitHandler.CreateClip)
+				protectedLivekitGroup.POST("/auctions/:auction_id/clips/auto", livekitHandler.CreateLotClip)
+				protectedLivekitGroup.PUT("/clips/:id", livekitHandler.UpdateClip)
+				protectedLivekitGroup.POST("/clips/:id/feature", livekitHandler.FeatureClip)
+				protectedLivekitGroup.DELETE("/clips/:id", livekitHandler.DeleteClip)
+				protectedLivekitGroup.GET("/auctions/:auction_id/stage", livekitHandler.ListStage)
+				protectedLivekitGroup.POST("/auctions/:auction_id/stage/invitations", livekitHandler.InviteToStage)
+				protectedLivekitGroup.POST("/auctions/:auction_id/stage/:participant_id/approve", livekitHandler.ApproveStageRequest)
+				protectedLivekitGroup.DELETE("/auctions/:auction_id/stage/:participant_id", livekitHandler.RevokeStage)
+			}
 
 			// Admin routes
 			admin := protected.Group("/admin")
 			admin.Use(authHandler.RequireRole("admin"))
 			{
 				admin.GET("/orders/statistics", orderHandler.GetOrderStatistics)
-				admin.POST("/payments/refund", paymentHandler.RefundPayment)
+				admin.POST("/payments/refund", idempotent, paymentHandler.RefundPayment)
+				admin.GET("/refunds", paymentHandler.ListRefunds)
+				admin.POST("/refunds/:id/approve", idempotent, paymentHandler.ApproveRefund)
+				admin.POST("/refunds/:id/reject", paymentHandler.RejectRefund)
+				admin.GET("/disputes", paymentHandler.ListDisputes)
+				admin.GET("/disputes/:id", paymentHandler.GetDispute)
+				admin.POST("/disputes/:id/resolve", idempotent, paymentHandler.ResolveDispute)
+				admin.POST("/payments/intents/:id/capture", paymentHandler.CapturePayment)
 				admin.GET("/payments", paymentHandler.ListPayments)
 				admin.GET("/payments/:id", paymentHandler.GetPayment)
+				admin.GET("/webhooks", paymentHandler.ListWebhookEvents)
+				admin.POST("/webhooks/:id/replay", paymentHandler.ReplayWebhookEvent)
+				admin.GET("/ledger/accounts", ledgerHandler.ListAccounts)
+				admin.GET("/ledger/accounts/:id/entries", ledgerHandler.ListAccountEntries)
+				admin.GET("/ledger/invariants", ledgerHandler.CheckInvariants)
+				admin.GET("/payouts", payoutHandler.ListPayouts)
+				admin.GET("/fee-rules", feeHandler.ListRules)
+				admin.POST("/fee-rules", feeHandler.CreateRule)
+				admin.PUT("/fee-rules/:id", feeHandler.UpdateRule)
+				admin.DELETE("/fee-rules/:id", feeHandler.DeleteRule)
+				admin.POST("/payouts/run", payoutHandler.RunPayouts)
+				admin.GET("/reconciliations", reconciliationHandler.ListRuns)
+				admin.GET("/subscriptions", subscriptionHandler.ListSubscriptions)
+				admin.GET("/reconciliations/:id", reconciliationHandler.GetRun)
+				admin.POST("/reconciliations", reconciliationHandler.RunReconciliation)
+				admin.GET("/gift-cards", creditHandler.ListGiftCards)
+				admin.POST("/gift-cards", idempotent, creditHandler.IssueGiftCard)
+				admin.GET("/gift-cards/:id", creditHandler.GetGiftCard)
+				admin.POST("/store-credit", idempotent, creditHandler.GrantStoreCredit)
+				admin.GET("/invoices", invoiceHandler.ListInvoices)
 			}
 		}
 
@@ -420,17 +779,40 @@ func main() {
 		sellerOnly.Use(authHandler.RequireSellerOrAdmin())
 		{
 			// Additional seller-specific routes can be added here
+			sellerOnly.GET("/payouts", payoutHandler.ListMyPayouts)
+			sellerOnly.POST("/payouts", idempotent, payoutHandler.RequestPayout)
+			sellerOnly.GET("/payouts/balance", payoutHandler.GetBalance)
+			sellerOnly.GET("/payouts/settings", payoutHandler.GetSettings)
+			sellerOnly.PUT("/payouts/settings", payoutHandler.UpdateSettings)
+			sellerOnly.GET("/payouts/:id", payoutHandler.GetPayout)
+			sellerOnly.GET("/refunds", paymentHandler.ListMyRefunds)
+			sellerOnly.POST("/refunds", idempotent, paymentHandler.RequestSellerRefund)
+			sellerOnly.GET("/disputes", paymentHandler.ListMyDisputes)
+			sellerOnly.GET("/disputes/:id", paymentHandler.GetDispute)
+			sellerOnly.POST("/disputes/:id/evidence", idempotent, paymentHandler.SubmitDisputeEvidence)
+			sellerOnly.GET("/subscription", subscriptionHandler.GetMySubscription)
+			sellerOnly.POST("/subscription", idempotent, subscriptionHandler.Subscribe)
+			sellerOnly.PUT("/subscription/plan", idempotent, subscriptionHandler.ChangePlan)
+			sellerOnly.PUT("/subscription/payment-method", subscriptionHandler.UpdatePaymentMethod)
+			sellerOnly.POST("/subscription/resume", subscriptionHandler.ResumeSubscription)
+			sellerOnly.DELETE("/subscription", subscriptionHandler.CancelSubscription)
+			sellerOnly.GET("/invoices/issued", invoiceHandler.ListIssuedInvoices)
 		}
 
-		// Seller-only LiveKit routes (temporarily disabled)
-		// sellerLivekitGroup := v1.Group("/livekit")
-		// sellerLivekitGroup.Use(authHandler.RequireAuth())
-		// sellerLivekitGroup.Use(authHandler.RequireSellerOrAdmin())
-		// {
-		// 	sellerLivekitGroup.GET("/auctions/:auction_id/participants", livekitHandler.GetRoomParticipants)
-		// 	sellerLivekitGroup.DELETE("/auctions/:auction_id/participants/:participant_sid", livekitHandler.RemoveParticipant)
-		// 	sellerLivekitGroup.PUT("/auctions/:auction_id/participants/:participant_sid/mute", livekitHandler.MuteParticipant)
-		// }
+		// Stage LiveKit routes; co-hosts and guests need not be sellers, so access
+		// follows the caller's stage role in the auction
+		stageLivekitGroup := v1.Group("/livekit")
+		stageLivekitGroup.Use(authHandler.RequireAuth())
+		{
+			stageLivekitGroup.GET("/auctions/:auction_id/participants", livekitHandler.GetRoomParticipants)
+			stageLivekitGroup.DELETE("/auctions/:auction_id/participants/:identity", livekitHandler.RemoveParticipant)
+			stageLivekitGroup.PUT("/auctions/:auction_id/participants/:identity/mute", livekitHandler.MuteParticipant)
+			stageLivekitGroup.POST("/auctions/:auction_id/stage/requests", livekitHandler.RequestStage)
+			stageLivekitGroup.GET("/auctions/:auction_id/token/stage", livekitHandler.GetStageToken)
+			stageLivekitGroup.GET("/invitations", livekitHandler.ListMyInvitations)
+			stageLivekitGroup.POST("/invitations/:code/accept", livekitHandler.AcceptInvitation)
+			stageLivekitGroup.POST("/invitations/:code/decline", livekitHandler.DeclineInvitation)
+		}
 	} else {
 		log.Println("⚠️  No database available - Authentication system disabled")
 	}
@@ -0,0 +1,225 @@
+package auction
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+)
+
+// WebSocket subprotocols offered by the auction socket. Clients that do not
+// request a subprotocol get JSON.
+const (
+	SubprotocolJSON    = "blytz.auction.v1.json"
+	SubprotocolMsgPack = "blytz.auction.v1.msgpack"
+)
+
+// supportedSubprotocols lists the subprotocols in server preference order
+var supportedSubprotocols = []string{SubprotocolMsgPack, SubprotocolJSON}
+
+// wireCodec encodes and decodes auction socket frames
+type wireCodec interface {
+	Name() string
+	FrameType() int
+	Encode(message WebSocketMessage) ([]byte, error)
+	Decode(data []byte, message *WebSocketMessage) error
+}
+
+// codecForSubprotocol returns the codec negotiated for a connection
+func codecForSubprotocol(subprotocol string) wireCodec {
+	if subprotocol == SubprotocolMsgPack {
+		return msgpackCodec{}
+	}
+	return jsonCodec{}
+}
+
+// jsonCodec is the default wire format with full event payloads
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string   { return SubprotocolJSON }
+func (jsonCodec) FrameType() int { return websocket.TextMessage }
+
+func (jsonCodec) Encode(message WebSocketMessage) ([]byte, error) {
+	return json.Marshal(message)
+}
+
+func (jsonCodec) Decode(data []byte, message *WebSocketMessage) error {
+	return json.Unmarshal(data, message)
+}
+
+// msgpackHandle is shared by all MessagePack connections; it is safe for concurrent use once configured
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.WriteExt = true
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return h
+}()
+
+// msgpackCodec is the compact binary wire format. Frames use short keys and
+// slimmed payloads without nested user, product or seller objects.
+type msgpackCodec struct{}
+
+// compactMessage is the MessagePack frame envelope
+type compactMessage struct {
+	EventID   uint64      `codec:"i,omitempty"`
+	Type      string      `codec:"t"`
+	AuctionID string      `codec:"a"`
+	Data      interface{} `codec:"d,omitempty"`
+	Timestamp int64       `codec:"ts"` // unix milliseconds
+}
+
+func (msgpackCodec) Name() string   { return SubprotocolMsgPack }
+func (msgpackCodec) FrameType() int { return websocket.BinaryMessage }
+
+func (msgpackCodec) Encode(message WebSocketMessage) ([]byte, error) {
+	frame := compactMessage{
+		EventID:   message.EventID,
+		Type:      message.Type,
+		AuctionID: message.AuctionID,
+		Data:      slimPayload(message.Data),
+		Timestamp: message.Timestamp.UnixMilli(),
+	}
+
+	var data []byte
+	err := codec.NewEncoderBytes(&data, msgpackHandle).Encode(frame)
+	return data, err
+}
+
+func (msgpackCodec) Decode(data []byte, message *WebSocketMessage) error {
+	var frame compactMessage
+	if err := codec.NewDecoderBytes(data, msgpackHandle).Decode(&frame); err != nil {
+		return err
+	}
+
+	message.EventID = frame.EventID
+	message.Type = frame.Type
+	message.AuctionID = frame.AuctionID
+	message.Data = frame.Data
+	message.Timestamp = time.UnixMilli(frame.Timestamp)
+	return nil
+}
+
+// BidEvent is the slim bid payload used by the compact wire format
+type BidEvent struct {
+	ID        string `codec:"id"`
+	UserID    string `codec:"uid"`
+	Bidder    string `codec:"by,omitempty"`
+	Amount    int64  `codec:"amt"`
+	IsAutoBid bool   `codec:"auto,omitempty"`
+	BidTime   int64  `codec:"at"`
+}
+
+// AuctionEvent is the slim auction payload used by the compact wire format
+type AuctionEvent struct {
+	ID         string `codec:"id"`
+	ProductID  string `codec:"pid"`
+	Title      string `codec:"title"`
+	Status     string `codec:"st"`
+	StartPrice int64  `codec:"sp"`
+	CurrentBid *int64 `codec:"cb"`
+	BidCount   int    `codec:"bc"`
+	StartTime  int64  `codec:"start"`
+	EndTime    int64  `codec:"end"`
+	WinnerID   string `codec:"win,omitempty"`
+}
+
+// ChatEvent is the slim chat payload used by the compact wire format
+type ChatEvent struct {
+	ID          string `codec:"id"`
+	UserID      string `codec:"uid"`
+	Author      string `codec:"by,omitempty"`
+	Message     string `codec:"msg"`
+	MessageType string `codec:"mt"`
+	Timestamp   int64  `codec:"at"`
+}
+
+// slimPayload converts full model payloads into compact event payloads
+func slimPayload(data interface{}) interface{} {
+	switch v := data.(type) {
+	case models.Bid:
+		return newBidEvent(&v)
+	case *models.Bid:
+		return newBidEvent(v)
+	case models.Auction:
+		return newAuctionEvent(&v)
+	case *models.Auction:
+		return newAuctionEvent(v)
+	case *models.ChatMessage:
+		return newChatEvent(v)
+	case gin.H:
+		slim := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			slim[key] = slimPayload(value)
+		}
+		return slim
+	case time.Time:
+		return v.UnixMilli()
+	case *time.Time:
+		if v == nil {
+			return nil
+		}
+		return v.UnixMilli()
+	case time.Duration:
+		return v.Milliseconds()
+	case uuid.UUID:
+		return v.String()
+	default:
+		return data
+	}
+}
+
+func newBidEvent(bid *models.Bid) BidEvent {
+	return BidEvent{
+		ID:        bid.ID.String(),
+		UserID:    bid.UserID.String(),
+		Bidder:    displayName(&bid.User),
+		Amount:    bid.Amount,
+		IsAutoBid: bid.IsAutoBid,
+		BidTime:   bid.BidTime.UnixMilli(),
+	}
+}
+
+func newAuctionEvent(auction *models.Auction) AuctionEvent {
+	event := AuctionEvent{
+		ID:         auction.ID.String(),
+		ProductID:  auction.ProductID.String(),
+		Title:      auction.Title,
+		Status:     auction.Status,
+		StartPrice: auction.StartPrice,
+		CurrentBid: auction.CurrentBid,
+		BidCount:   auction.BidCount,
+		StartTime:  auction.StartTime.UnixMilli(),
+		EndTime:    auction.EndTime.UnixMilli(),
+	}
+	if auction.WinnerID != nil {
+		event.WinnerID = auction.WinnerID.String()
+	}
+	return event
+}
+
+func newChatEvent(message *models.ChatMessage) ChatEvent {
+	return ChatEvent{
+		ID:          message.ID.String(),
+		UserID:      message.UserID.String(),
+		Author:      displayName(&message.User),
+		Message:     message.Message,
+		MessageType: message.MessageType,
+		Timestamp:   message.Timestamp.UnixMilli(),
+	}
+}
+
+// displayName returns a public display name without exposing the user's email
+func displayName(user *models.User) string {
+	if user.FirstName != nil && *user.FirstName != "" {
+		if user.LastName != nil && *user.LastName != "" {
+			return *user.FirstName + " " + string([]rune(*user.LastName)[:1]) + "."
+		}
+		return *user.FirstName
+	}
+	return ""
+}
@@ -1,6 +1,8 @@
 package auction
 
 import (
+	"bytes"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
@@ -8,6 +10,7 @@ import (
 	"github.com/blytz.live.remake/backend/internal/models"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // Handler provides auction HTTP handlers
@@ -29,9 +32,9 @@ type CreateAuctionRequest struct {
 	Description  *string    `json:"description"`
 	StartTime    time.Time  `json:"start_time" binding:"required"`
 	EndTime      time.Time  `json:"end_time" binding:"required"`
-	StartPrice   float64    `json:"start_price" binding:"required,gt=0"`
-	ReservePrice *float64   `json:"reserve_price"`
-	BuyNowPrice  *float64   `json:"buy_now_price"`
+	StartPrice   int64      `json:"start_price" binding:"required,gt=0"`
+	ReservePrice *int64     `json:"reserve_price"`
+	BuyNowPrice  *int64     `json:"buy_now_price"`
 	AutoExtend   bool       `json:"auto_extend"`
 	ExtendTime   int        `json:"extend_time"`
 	IsFeatured   bool       `json:"is_featured"`
@@ -39,15 +42,15 @@ type CreateAuctionRequest struct {
 
 // PlaceBidRequest represents request body for placing a bid
 type PlaceBidRequest struct {
-	Amount    float64 `json:"amount" binding:"required,gt=0"`
-	IsAutoBid bool    `json:"is_auto_bid"`
+	Amount    int64 `json:"amount" binding:"required,gt=0"`
+	IsAutoBid bool  `json:"is_auto_bid"`
 }
 
 // AutoBidRequest represents request body for setting auto-bid
 type AutoBidRequest struct {
-	MaxAmount    float64 `json:"max_amount" binding:"required,gt=0"`
-	BidIncrement float64 `json:"bid_increment"`
-	IsActive     bool    `json:"is_active"`
+	MaxAmount    int64 `json:"max_amount" binding:"required,gt=0"`
+	BidIncrement int64 `json:"bid_increment"`
+	IsActive     bool  `json:"is_active"`
 }
 
 // CreateAuction creates a new auction
@@ -79,7 +82,6 @@ func (h *Handler) CreateAuction(c *gin.Context) {
 		AutoExtend:   req.AutoExtend,
 		ExtendTime:   req.ExtendTime,
 		IsFeatured:   req.IsFeatured,
-		LiveKitRoom:  "auction-" + uuid.New().String(),
 	}
 
 	if err := h.service.CreateAuction(c.Request.Context(), auction); err != nil {
@@ -206,6 +208,10 @@ func (h *Handler) StartAuction(c *gin.Context) {
 	}
 
 	if err := h.service.StartAuction(c.Request.Context(), auctionID); err != nil {
+		if errors.Is(err, ErrLiveAuctionLimit) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -231,6 +237,10 @@ func (h *Handler) EndAuction(c *gin.Context) {
 	}
 
 	if err := h.service.EndAuction(c.Request.Context(), auctionID); err != nil {
+		if errors.Is(err, ErrAuctionNotLive) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -267,11 +277,6 @@ func (h *Handler) SetAutoBid(c *gin.Context) {
 		IsActive:     req.IsActive,
 	}
 
-	// Set default bid increment if not provided
-	if req.BidIncrement == 0 {
-		autoBid.BidIncrement = 5.0
-	}
-
 	if err := h.service.SetAutoBid(c.Request.Context(), autoBid); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -373,4 +378,232 @@ func (h *Handler) GetAuctionBids(c *gin.Context) {
 		"page":  page,
 		"limit": limit,
 	})
-}
\ No newline at end of file
+}
+
+// RescheduleRequest represents request body for moving a scheduled auction
+type RescheduleRequest struct {
+	StartTime time.Time `json:"start_time" binding:"required"`
+	EndTime   time.Time `json:"end_time" binding:"required"`
+}
+
+// ReminderRequest represents request body for subscribing to a show reminder
+type ReminderRequest struct {
+	LeadMinutes int `json:"lead_minutes" binding:"min=0"`
+}
+
+// GetShowCalendar lists upcoming shows platform-wide or for one seller
+func (h *Handler) GetShowCalendar(c *gin.Context) {
+	from := time.Now()
+	if value := c.Query("from"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from time"})
+			return
+		}
+		from = parsed
+	}
+
+	to := from.AddDate(0, 0, 14)
+	if value := c.Query("to"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to time"})
+			return
+		}
+		to = parsed
+	}
+
+	var sellerID *uuid.UUID
+	if value := c.Query("seller_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid seller ID"})
+			return
+		}
+		sellerID = &id
+	}
+
+	shows, err := h.service.GetShowCalendar(c.Request.Context(), from, to, sellerID)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCalendarRange) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"shows": shows,
+		"from":  from,
+		"to":    to,
+	})
+}
+
+// GetSellerCalendarFeed serves a seller's shows as an iCalendar feed
+func (h *Handler) GetSellerCalendarFeed(c *gin.Context) {
+	sellerID, err := uuid.Parse(c.Param("seller_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid seller ID"})
+		return
+	}
+
+	seller, shows, err := h.service.GetSellerFeed(c.Request.Context(), sellerID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Seller not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var feed bytes.Buffer
+	if err := WriteCalendar(&feed, calendarName(*seller), shows); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", `inline; filename="shows.ics"`)
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", feed.Bytes())
+}
+
+// RescheduleAuction moves a scheduled auction (its seller or an admin only). Subscribers
+// are reminded again before the new start time.
+func (h *Handler) RescheduleAuction(c *gin.Context) {
+	auctionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid auction ID"})
+		return
+	}
+
+	var req RescheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Get user info from context
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	role, _ := c.Get("role")
+
+	current, err := h.service.GetAuction(c.Request.Context(), auctionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Auction not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if role != "admin" && current.SellerID != userID.(uuid.UUID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	auction, err := h.service.RescheduleAuction(c.Request.Context(), auctionID, req.StartTime, req.EndTime)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidSchedule):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrAuctionNotUpcoming):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Auction not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, auction)
+}
+
+// SubscribeReminder asks for a reminder before an auction starts
+func (h *Handler) SubscribeReminder(c *gin.Context) {
+	auctionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid auction ID"})
+		return
+	}
+
+	// Get user ID from context
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	// The body is optional; without one the default lead time is used
+	var req ReminderRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	reminder, err := h.service.SubscribeReminder(c.Request.Context(), auctionID, userID.(uuid.UUID), req.LeadMinutes)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidReminderLead), errors.Is(err, ErrAuctionNotUpcoming):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Auction not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, reminder)
+}
+
+// CancelReminder removes the caller's reminder for an auction
+func (h *Handler) CancelReminder(c *gin.Context) {
+	auctionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid auction ID"})
+		return
+	}
+
+	// Get user ID from context
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	if err := h.service.CancelReminder(c.Request.Context(), auctionID, userID.(uuid.UUID)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Reminder not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Reminder cancelled"})
+}
+
+// ListMyReminders lists the caller's reminders for upcoming and live shows
+func (h *Handler) ListMyReminders(c *gin.Context) {
+	// Get user ID from context
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	reminders, err := h.service.ListUserReminders(c.Request.Context(), userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reminders": reminders})
+}
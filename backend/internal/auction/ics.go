@@ -0,0 +1,95 @@
+package auction
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+)
+
+// icsTimeFormat is the iCalendar UTC date-time format (RFC 5545 section 3.3.5)
+const icsTimeFormat = "20060102T150405Z"
+
+// WriteCalendar writes auctions as an iCalendar (RFC 5545) feed
+func WriteCalendar(w io.Writer, name string, auctions []models.Auction) error {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Blytz Live//Show Calendar//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:" + icsEscape(name),
+	}
+
+	stamp := time.Now().UTC().Format(icsTimeFormat)
+	for _, auction := range auctions {
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			"UID:"+auction.ID.String()+"@blytz.live",
+			"DTSTAMP:"+stamp,
+			"DTSTART:"+auction.StartTime.UTC().Format(icsTimeFormat),
+			"DTEND:"+auction.EndTime.UTC().Format(icsTimeFormat),
+			"LAST-MODIFIED:"+auction.UpdatedAt.UTC().Format(icsTimeFormat),
+			"SUMMARY:"+icsEscape(auction.Title),
+		)
+		if auction.Description != nil && *auction.Description != "" {
+			lines = append(lines, "DESCRIPTION:"+icsEscape(*auction.Description))
+		}
+		status := "CONFIRMED"
+		if auction.Status == "cancelled" {
+			status = "CANCELLED"
+		}
+		lines = append(lines, "STATUS:"+status, "END:VEVENT")
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	for _, line := range lines {
+		if _, err := io.WriteString(w, icsFold(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// icsEscape escapes text values (RFC 5545 section 3.3.11)
+func icsEscape(value string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(value)
+}
+
+// icsFold folds a content line at 75 octets without splitting UTF-8 sequences and
+// terminates it with CRLF (RFC 5545 section 3.1)
+func icsFold(line string) string {
+	var b strings.Builder
+	width := 0
+	for _, r := range line {
+		size := len(string(r))
+		if width+size > 75 {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += size
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+// calendarName returns the feed name for a seller's shows
+func calendarName(seller models.User) string {
+	if seller.FirstName != nil && *seller.FirstName != "" {
+		name := *seller.FirstName
+		if seller.LastName != nil && *seller.LastName != "" {
+			name += " " + *seller.LastName
+		}
+		return fmt.Sprintf("%s's shows", name)
+	}
+	return "Blytz Live shows"
+}
@@ -0,0 +1,52 @@
+package auction
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/pkg/mail"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// EmailReminderNotifier emails "show starting soon" reminders to subscribers
+type EmailReminderNotifier struct {
+	db     *gorm.DB
+	mailer mail.Mailer
+	appURL string
+}
+
+// NewEmailReminderNotifier creates a notifier that links reminders to shows under appURL
+func NewEmailReminderNotifier(db *gorm.DB, mailer mail.Mailer, appURL string) *EmailReminderNotifier {
+	return &EmailReminderNotifier{
+		db:     db,
+		mailer: mailer,
+		appURL: strings.TrimRight(appURL, "/"),
+	}
+}
+
+// SendAuctionReminder emails the user that the auction is about to start
+func (n *EmailReminderNotifier) SendAuctionReminder(ctx context.Context, userID uuid.UUID, auction *models.Auction) error {
+	var user models.User
+	if err := n.db.WithContext(ctx).Select("id", "email", "first_name").First(&user, "id = ?", userID).Error; err != nil {
+		return err
+	}
+
+	greeting := "Hi,"
+	if user.FirstName != nil && *user.FirstName != "" {
+		greeting = fmt.Sprintf("Hi %s,", *user.FirstName)
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s\n\n", greeting)
+	fmt.Fprintf(&body, "%q starts at %s.\n\n", auction.Title, auction.StartTime.UTC().Format("Mon, 2 Jan 2006 15:04 MST"))
+	fmt.Fprintf(&body, "Join the show: %s/auctions/%s\n", n.appURL, auction.ID)
+
+	return n.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: fmt.Sprintf("Starting soon: %s", auction.Title),
+		Body:    body.String(),
+	})
+}
@@ -0,0 +1,319 @@
+package auction
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Reminder lead time limits
+const (
+	DefaultReminderLead = 15
+	MaxReminderLead     = 24 * 60
+)
+
+// Reminder delivery limits: a reminder is tried MaxReminderAttempts times,
+// ReminderRetryDelay apart, before it is given up
+const (
+	MaxReminderAttempts = 5
+	ReminderRetryDelay  = 2 * time.Minute
+)
+
+// MaxCalendarRange is the longest period a single calendar request may cover
+const MaxCalendarRange = 62 * 24 * time.Hour
+
+// ErrAuctionNotUpcoming is returned when subscribing to a reminder for, or rescheduling,
+// an auction that has already started, ended or been cancelled
+var ErrAuctionNotUpcoming = errors.New("auction is not upcoming")
+
+// ErrInvalidReminderLead is returned when a reminder lead time is out of range
+var ErrInvalidReminderLead = errors.New("invalid reminder lead time")
+
+// ErrInvalidSchedule is returned when rescheduling to a start time in the past or an end
+// time that is not after the start
+var ErrInvalidSchedule = errors.New("start time must be in the future and before the end time")
+
+// ErrInvalidCalendarRange is returned when a calendar range is empty or too long
+var ErrInvalidCalendarRange = errors.New("invalid calendar range")
+
+// ReminderNotifier delivers "show starting soon" reminders to users
+type ReminderNotifier interface {
+	SendAuctionReminder(ctx context.Context, userID uuid.UUID, auction *models.Auction) error
+}
+
+// SetReminderNotifier sets the notifier used to deliver show reminders
+func (s *Service) SetReminderNotifier(notifier ReminderNotifier) {
+	s.reminders = notifier
+}
+
+// SetDefaultReminderLead sets the lead time used when a subscriber does not choose one
+func (s *Service) SetDefaultReminderLead(minutes int) {
+	if minutes > 0 && minutes <= MaxReminderLead {
+		s.reminderLead = minutes
+	}
+}
+
+// GetShowCalendar lists the scheduled and live shows starting within [from, to),
+// platform-wide or for a single seller
+func (s *Service) GetShowCalendar(ctx context.Context, from, to time.Time, sellerID *uuid.UUID) ([]models.Auction, error) {
+	if !to.After(from) || to.Sub(from) > MaxCalendarRange {
+		return nil, ErrInvalidCalendarRange
+	}
+
+	query := s.db.WithContext(ctx).
+		Preload("Product").
+		Preload("Seller").
+		Where("status IN ? AND start_time >= ? AND start_time < ?", []string{"scheduled", "live"}, from, to)
+	if sellerID != nil {
+		query = query.Where("seller_id = ?", *sellerID)
+	}
+
+	var auctions []models.Auction
+	err := query.Order("start_time ASC").Find(&auctions).Error
+	return auctions, err
+}
+
+// GetSellerFeed loads a seller and their shows for the iCalendar feed: upcoming shows plus
+// those of the last 30 days, so calendars pick up cancellations and finished shows
+func (s *Service) GetSellerFeed(ctx context.Context, sellerID uuid.UUID) (*models.User, []models.Auction, error) {
+	var seller models.User
+	if err := s.db.WithContext(ctx).
+		Select("id", "first_name", "last_name").
+		First(&seller, "id = ? AND role IN ?", sellerID, []string{"seller", "admin"}).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var auctions []models.Auction
+	err := s.db.WithContext(ctx).
+		Where("seller_id = ? AND start_time >= ?", sellerID, time.Now().AddDate(0, 0, -30)).
+		Order("start_time ASC").
+		Limit(500).
+		Find(&auctions).Error
+	return &seller, auctions, err
+}
+
+// SubscribeReminder asks for a reminder leadMinutes before the auction starts. Subscribing
+// again changes the lead time and re-arms the reminder; 0 uses the default lead time.
+func (s *Service) SubscribeReminder(ctx context.Context, auctionID, userID uuid.UUID, leadMinutes int) (*models.AuctionReminder, error) {
+	if leadMinutes == 0 {
+		leadMinutes = s.reminderLead
+	}
+	if leadMinutes < 1 || leadMinutes > MaxReminderLead {
+		return nil, ErrInvalidReminderLead
+	}
+
+	var auction models.Auction
+	if err := s.db.WithContext(ctx).Select("id", "status", "start_time").First(&auction, "id = ?", auctionID).Error; err != nil {
+		return nil, err
+	}
+	if auction.Status != "scheduled" || !auction.StartTime.After(time.Now()) {
+		return nil, ErrAuctionNotUpcoming
+	}
+
+	reminder := models.AuctionReminder{
+		AuctionID:   auctionID,
+		UserID:      userID,
+		LeadMinutes: leadMinutes,
+		RemindAt:    auction.StartTime.Add(-time.Duration(leadMinutes) * time.Minute),
+	}
+	err := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "auction_id"}, {Name: "user_id"}},
+		DoUpdates: clause.Assignments(rearmReminder(map[string]interface{}{"lead_minutes": leadMinutes, "remind_at": reminder.RemindAt, "updated_at": time.Now()})),
+	}).Create(&reminder).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// The upsert keeps the original row, so load it for the caller
+	var saved models.AuctionReminder
+	if err := s.db.WithContext(ctx).First(&saved, "auction_id = ? AND user_id = ?", auctionID, userID).Error; err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// RescheduleAuction moves a scheduled auction to a new start and end time. Its reminders
+// are re-armed for the new start, including those already sent for the old one.
+func (s *Service) RescheduleAuction(ctx context.Context, auctionID uuid.UUID, startTime, endTime time.Time) (*models.Auction, error) {
+	if startTime.Before(time.Now()) || !endTime.After(startTime) {
+		return nil, ErrInvalidSchedule
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Auction{}).
+			Where("id = ? AND status = ?", auctionID, "scheduled").
+			Updates(map[string]interface{}{
+				"start_time": startTime,
+				"end_time":   endTime,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			var count int64
+			if err := tx.Model(&models.Auction{}).Where("id = ?", auctionID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return gorm.ErrRecordNotFound
+			}
+			return ErrAuctionNotUpcoming
+		}
+
+		var reminders []models.AuctionReminder
+		if err := tx.Where("auction_id = ?", auctionID).Find(&reminders).Error; err != nil {
+			return err
+		}
+		for i := range reminders {
+			reminder := &reminders[i]
+			if err := tx.Model(reminder).Updates(rearmReminder(map[string]interface{}{
+				"remind_at": startTime.Add(-time.Duration(reminder.LeadMinutes) * time.Minute),
+			})).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifyAuctionUpdate(ctx, auctionID)
+	return s.GetAuction(ctx, auctionID)
+}
+
+// CancelReminder removes a user's reminder for an auction
+func (s *Service) CancelReminder(ctx context.Context, auctionID, userID uuid.UUID) error {
+	result := s.db.WithContext(ctx).Unscoped().
+		Where("auction_id = ? AND user_id = ?", auctionID, userID).
+		Delete(&models.AuctionReminder{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ListUserReminders lists a user's reminders for shows that have not ended yet
+func (s *Service) ListUserReminders(ctx context.Context, userID uuid.UUID) ([]models.AuctionReminder, error) {
+	var reminders []models.AuctionReminder
+	err := s.db.WithContext(ctx).
+		Preload("Auction").
+		Joins("JOIN auctions ON auctions.id = auction_reminders.auction_id").
+		Where("auction_reminders.user_id = ? AND auctions.status IN ?", userID, []string{"scheduled", "live"}).
+		Order("auctions.start_time ASC").
+		Find(&reminders).Error
+	return reminders, err
+}
+
+// DispatchDueReminders sends the reminders that are due at now and returns how many were
+// sent. Reminders whose auction moved are re-armed for the new start time; reminders of
+// cancelled or finished auctions are dropped.
+//
+// Each reminder is claimed with a conditional update before it is sent, so concurrent
+// runs send it once. A delivery that fails is retried ReminderRetryDelay later, up to
+// MaxReminderAttempts times, and waits out of the way of the reminders due after it.
+func (s *Service) DispatchDueReminders(ctx context.Context, now time.Time) (int, error) {
+	var due []models.AuctionReminder
+	if err := s.db.WithContext(ctx).
+		Preload("Auction").
+		Where("sent_at IS NULL AND remind_at <= ? AND attempts < ? AND (retry_at IS NULL OR retry_at <= ?)", now, MaxReminderAttempts, now).
+		Order("remind_at ASC").
+		Limit(500).
+		Find(&due).Error; err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for i := range due {
+		reminder := &due[i]
+		auction := &reminder.Auction
+
+		if (auction.Status != "scheduled" && auction.Status != "live") || !auction.EndTime.After(now) {
+			if err := s.db.WithContext(ctx).Unscoped().Delete(reminder).Error; err != nil {
+				return sent, err
+			}
+			continue
+		}
+
+		remindAt := auction.StartTime.Add(-time.Duration(reminder.LeadMinutes) * time.Minute)
+		if remindAt.After(now) {
+			if err := s.db.WithContext(ctx).Model(reminder).Updates(rearmReminder(map[string]interface{}{"remind_at": remindAt})).Error; err != nil {
+				return sent, err
+			}
+			continue
+		}
+
+		// Claim the reminder; another run that got to it first has moved its attempts on
+		claim := s.db.WithContext(ctx).Model(&models.AuctionReminder{}).
+			Where("id = ? AND sent_at IS NULL AND attempts = ?", reminder.ID, reminder.Attempts).
+			Updates(map[string]interface{}{
+				"attempts": reminder.Attempts + 1,
+				"retry_at": now.Add(ReminderRetryDelay),
+			})
+		if claim.Error != nil {
+			return sent, claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			continue
+		}
+		reminder.Attempts++
+
+		if s.reminders != nil {
+			if err := s.reminders.SendAuctionReminder(ctx, reminder.UserID, auction); err != nil {
+				// Leave the reminder pending so a later run retries it
+				s.logger.Warn("Failed to send auction reminder", map[string]interface{}{
+					"auction_id": auction.ID,
+					"user_id":    reminder.UserID,
+					"attempts":   reminder.Attempts,
+					"error":      err.Error(),
+				})
+				if err := s.db.WithContext(ctx).Model(reminder).Update("last_error", err.Error()).Error; err != nil {
+					return sent, err
+				}
+				continue
+			}
+		} else {
+			// Without a delivery channel the reminder is only logged; users still see it
+			// as sent through ListUserReminders
+			s.logger.Info("Auction reminder due", map[string]interface{}{
+				"auction_id": auction.ID,
+				"user_id":    reminder.UserID,
+			})
+		}
+
+		if err := s.db.WithContext(ctx).Model(reminder).Updates(map[string]interface{}{
+			"sent_at":    now,
+			"last_error": nil,
+			"retry_at":   nil,
+		}).Error; err != nil {
+			return sent, err
+		}
+		sent++
+	}
+
+	if sent > 0 {
+		s.logger.Info("Auction reminders sent", map[string]interface{}{
+			"count": sent,
+		})
+	}
+
+	return sent, nil
+}
+
+// rearmReminder adds to updates the columns that make a reminder pending again, with its
+// delivery attempts reset
+func rearmReminder(updates map[string]interface{}) map[string]interface{} {
+	updates["sent_at"] = nil
+	updates["attempts"] = 0
+	updates["last_error"] = nil
+	updates["retry_at"] = nil
+	return updates
+}
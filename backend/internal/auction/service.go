@@ -8,23 +8,54 @@ import (
 
 	"github.com/blytz.live.remake/backend/internal/models"
 	"github.com/blytz.live.remake/backend/pkg/logging"
+	"github.com/blytz.live.remake/backend/pkg/money"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Service provides auction business logic
 type Service struct {
-	db         *gorm.DB
-	logger     *logging.Logger
-	wsManager  *WebSocketManager
+	db           *gorm.DB
+	logger       *logging.Logger
+	wsManager    *WebSocketManager
+	rooms        RoomProvisioner
+	reminders    ReminderNotifier
+	reminderLead int
+	entitlements Entitlements
+	winnerOrders WinnerOrders
 }
 
+// RoomProvisioner creates the live stream room for an auction
+type RoomProvisioner interface {
+	ProvisionAuctionRoom(ctx context.Context, auctionID uuid.UUID) error
+}
+
+// Entitlements reports what a seller's subscription plan allows
+type Entitlements interface {
+	// MaxLiveAuctions returns how many auctions the seller may have live at once; 0 for unlimited
+	MaxLiveAuctions(ctx context.Context, sellerID uuid.UUID) (int, error)
+}
+
+// WinnerOrders creates the order that charges an auction's winner for their winning bid
+type WinnerOrders interface {
+	CreateAuctionOrder(ctx context.Context, auctionID uuid.UUID) (*models.Order, error)
+}
+
+// ErrAuctionNotLive is returned when ending an auction that is not live, e.g. one that
+// was ended concurrently
+var ErrAuctionNotLive = errors.New("auction is not live")
+
+// ErrLiveAuctionLimit is returned when starting an auction would exceed the seller's plan
+var ErrLiveAuctionLimit = errors.New("seller's plan does not allow more live auctions at once")
+
 // NewService creates a new auction service
 func NewService(db *gorm.DB) *Service {
 	logger := logging.NewLogger()
 	return &Service{
-		db:     db,
-		logger: logger,
+		db:           db,
+		logger:       logger,
+		reminderLead: DefaultReminderLead,
 	}
 }
 
@@ -33,6 +64,21 @@ func (s *Service) SetWebSocketManager(wsManager *WebSocketManager) {
 	s.wsManager = wsManager
 }
 
+// SetRoomProvisioner sets the provisioner used to create stream rooms for new auctions
+func (s *Service) SetRoomProvisioner(rooms RoomProvisioner) {
+	s.rooms = rooms
+}
+
+// SetWinnerOrders sets the order service that charges winners when auctions end
+func (s *Service) SetWinnerOrders(orders WinnerOrders) {
+	s.winnerOrders = orders
+}
+
+// SetEntitlements sets the plan entitlements enforced when auctions go live
+func (s *Service) SetEntitlements(entitlements Entitlements) {
+	s.entitlements = entitlements
+}
+
 // CreateAuction creates a new auction
 func (s *Service) CreateAuction(ctx context.Context, auction *models.Auction) error {
 	if auction.StartTime.Before(time.Now()) {
@@ -43,12 +89,37 @@ func (s *Service) CreateAuction(ctx context.Context, auction *models.Auction) er
 		return errors.New("end time must be after start time")
 	}
 	
-	// Generate LiveKit room name
+	// Auctions are priced in their product's currency
+	if auction.Currency == "" {
+		var product models.Product
+		if err := s.db.WithContext(ctx).Select("currency").First(&product, "id = ?", auction.ProductID).Error; err == nil {
+			auction.Currency = product.Currency
+		}
+	}
+
+	// Assign the ID up front so the LiveKit room name matches the auction
+	if auction.ID == uuid.Nil {
+		auction.ID = uuid.New()
+	}
 	if auction.LiveKitRoom == "" {
 		auction.LiveKitRoom = fmt.Sprintf("auction-%s", auction.ID.String())
 	}
 	
-	return s.db.WithContext(ctx).Create(auction).Error
+	if err := s.db.WithContext(ctx).Create(auction).Error; err != nil {
+		return err
+	}
+
+	// A failed room does not block the auction; the host can create it later
+	if s.rooms != nil {
+		if err := s.rooms.ProvisionAuctionRoom(ctx, auction.ID); err != nil {
+			s.logger.Warn("Failed to provision stream room", map[string]interface{}{
+				"auction_id": auction.ID,
+				"error":      err.Error(),
+			})
+		}
+	}
+
+	return nil
 }
 
 // GetAuction retrieves an auction by ID
@@ -103,7 +174,7 @@ func (s *Service) ListAuctions(ctx context.Context, status string, page, limit i
 }
 
 // PlaceBid places a bid on an auction
-func (s *Service) PlaceBid(ctx context.Context, auctionID, userID uuid.UUID, amount float64, isAutoBid bool) (*models.Bid, error) {
+func (s *Service) PlaceBid(ctx context.Context, auctionID, userID uuid.UUID, amount int64, isAutoBid bool) (*models.Bid, error) {
 	// Start transaction
 	tx := s.db.WithContext(ctx).Begin()
 	defer func() {
@@ -133,15 +204,17 @@ func (s *Service) PlaceBid(ctx context.Context, auctionID, userID uuid.UUID, amo
 		return nil, errors.New("auction has ended")
 	}
 	
-	// Validate bid amount
-	minimumBid := auction.StartPrice
+	// Validate bid amount in the auction currency's minor units
+	bidAmount := money.New(amount, auction.Currency)
+	minimumBid := money.New(auction.StartPrice, auction.Currency)
 	if auction.CurrentBid != nil {
-		minimumBid = *auction.CurrentBid + 1.0 // Minimum increment of $1
+		// Minimum increment of one major unit, e.g. $1 or ¥1
+		minimumBid = money.New(*auction.CurrentBid+money.FromMajor(1, auction.Currency).Amount, auction.Currency)
 	}
 	
-	if amount < minimumBid {
+	if bidAmount.Amount < minimumBid.Amount {
 		tx.Rollback()
-		return nil, fmt.Errorf("bid amount must be at least $%.2f", minimumBid)
+		return nil, fmt.Errorf("bid amount must be at least %s", minimumBid)
 	}
 	
 	// Check if user is trying to outbid themselves
@@ -149,7 +222,7 @@ func (s *Service) PlaceBid(ctx context.Context, auctionID, userID uuid.UUID, amo
 		var lastBid models.Bid
 		if err := tx.Where("auction_id = ? AND user_id = ?", auctionID, userID).
 			Order("created_at DESC").First(&lastBid).Error; err == nil {
-			if lastBid.Amount >= amount {
+			if lastBid.Amount >= bidAmount.Amount {
 				tx.Rollback()
 				return nil, errors.New("cannot outbid yourself")
 			}
@@ -185,9 +258,11 @@ func (s *Service) PlaceBid(ctx context.Context, auctionID, userID uuid.UUID, amo
 	}
 	
 	// Auto-extend auction if bid is in last 5 minutes
+	var extendedUntil *time.Time
 	if auction.AutoExtend && time.Until(auction.EndTime) <= 5*time.Minute {
 		newEndTime := auction.EndTime.Add(time.Duration(auction.ExtendTime) * time.Second)
 		updates["end_time"] = newEndTime
+		extendedUntil = &newEndTime
 	}
 	
 	if err := tx.Model(&auction).Updates(updates).Error; err != nil {
@@ -210,6 +285,9 @@ func (s *Service) PlaceBid(ctx context.Context, auctionID, userID uuid.UUID, amo
 	// Notify WebSocket clients about the new bid
 	if s.wsManager != nil {
 		s.wsManager.NotifyBidPlaced(auctionID, bid)
+		if extendedUntil != nil {
+			s.wsManager.NotifyAuctionExtended(auctionID, *extendedUntil)
+		}
 	}
 	
 	// Process auto-bids
@@ -219,7 +297,7 @@ func (s *Service) PlaceBid(ctx context.Context, auctionID, userID uuid.UUID, amo
 }
 
 // processAutoBids processes automatic bids for an auction
-func (s *Service) processAutoBids(ctx context.Context, auctionID uuid.UUID, currentBid float64) {
+func (s *Service) processAutoBids(ctx context.Context, auctionID uuid.UUID, currentBid int64) {
 	// Get all active auto-bids for this auction
 	var autoBids []models.AutoBid
 	err := s.db.WithContext(ctx).
@@ -266,35 +344,115 @@ func (s *Service) processAutoBids(ctx context.Context, auctionID uuid.UUID, curr
 	}
 }
 
-// StartAuction starts an auction
+// StartAuction starts an auction, if the seller's plan allows another live auction
 func (s *Service) StartAuction(ctx context.Context, auctionID uuid.UUID) error {
-	return s.db.WithContext(ctx).Model(&models.Auction{}).
-		Where("id = ? AND status = ?", auctionID, "scheduled").
-		Updates(map[string]interface{}{
-			"status":     "live",
-			"start_time": time.Now(),
-		}).Error
+	var auction models.Auction
+	if err := s.db.WithContext(ctx).Select("id", "seller_id", "status").First(&auction, "id = ?", auctionID).Error; err != nil {
+		return err
+	}
+
+	limit := 0
+	if s.entitlements != nil && auction.Status == "scheduled" {
+		var err error
+		if limit, err = s.entitlements.MaxLiveAuctions(ctx, auction.SellerID); err != nil {
+			return err
+		}
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := checkLiveAuctionLimit(tx, auction.SellerID, limit); err != nil {
+			return err
+		}
+
+		return tx.Model(&models.Auction{}).
+			Where("id = ? AND status = ?", auctionID, "scheduled").
+			Updates(map[string]interface{}{
+				"status":     "live",
+				"start_time": time.Now(),
+			}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	s.notifyAuctionUpdate(ctx, auctionID)
+	return nil
+}
+
+// checkLiveAuctionLimit returns ErrLiveAuctionLimit when the seller already has limit
+// auctions live; a limit of 0 or less means no limit. The seller's auctions stay locked
+// until tx ends, so two auctions started at once cannot both pass the check.
+func checkLiveAuctionLimit(tx *gorm.DB, sellerID uuid.UUID, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+
+	var ids []uuid.UUID
+	if err := tx.Model(&models.Auction{}).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("seller_id = ?", sellerID).
+		Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+
+	var live int64
+	if err := tx.Model(&models.Auction{}).
+		Where("seller_id = ? AND status = ?", sellerID, "live").
+		Count(&live).Error; err != nil {
+		return err
+	}
+	if live >= int64(limit) {
+		return ErrLiveAuctionLimit
+	}
+	return nil
+}
+
+// notifyAuctionUpdate pushes the latest auction state to connected clients
+func (s *Service) notifyAuctionUpdate(ctx context.Context, auctionID uuid.UUID) {
+	if s.wsManager == nil {
+		return
+	}
+
+	var auction models.Auction
+	if err := s.db.WithContext(ctx).First(&auction, "id = ?", auctionID).Error; err != nil {
+		return
+	}
+	s.wsManager.NotifyAuctionUpdate(auctionID, &auction)
 }
 
 // EndAuction ends an auction and determines the winner
 func (s *Service) EndAuction(ctx context.Context, auctionID uuid.UUID) error {
-	// Get auction with bids
+	// Only one caller ends the auction: the host leaving, the scheduler and the seller
+	// may race, and the loser must not pick a winner or create an order again
+	result := s.db.WithContext(ctx).Model(&models.Auction{}).
+		Where("id = ? AND status = ?", auctionID, "live").
+		Updates(map[string]interface{}{
+			"status":   "ended",
+			"end_time": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAuctionNotLive
+	}
+
+	// No bids can be placed once the auction has ended, so the winner is final
 	var auction models.Auction
 	err := s.db.WithContext(ctx).
 		Preload("Bids", func(db *gorm.DB) *gorm.DB {
 			return db.Order("amount DESC, created_at ASC")
 		}).
 		First(&auction, "id = ?", auctionID).Error
-	
 	if err != nil {
 		return err
 	}
-	
+
 	// Determine winner
 	var winnerID *uuid.UUID
 	if len(auction.Bids) > 0 {
 		highestBid := auction.Bids[0]
-		
+
 		// Check if reserve price is met
 		if auction.ReservePrice != nil && highestBid.Amount < *auction.ReservePrice {
 			// Reserve not met, no winner
@@ -302,22 +460,39 @@ func (s *Service) EndAuction(ctx context.Context, auctionID uuid.UUID) error {
 			winnerID = &highestBid.UserID
 		}
 	}
-	
-	// Update auction
-	updates := map[string]interface{}{
-		"status":   "ended",
-		"end_time": time.Now(),
-	}
-	
+
 	if winnerID != nil {
-		updates["winner_id"] = winnerID
+		if err := s.db.WithContext(ctx).Model(&auction).Update("winner_id", winnerID).Error; err != nil {
+			return err
+		}
 	}
-	
-	return s.db.WithContext(ctx).Model(&auction).Updates(updates).Error
+
+	s.notifyAuctionUpdate(ctx, auctionID)
+
+	// The auction has ended either way; a winner whose order failed is left to support
+	if winnerID != nil && s.winnerOrders != nil {
+		if _, err := s.winnerOrders.CreateAuctionOrder(ctx, auctionID); err != nil {
+			s.logger.Error("Failed to create order for auction winner", map[string]interface{}{
+				"error":      err.Error(),
+				"auction_id": auctionID,
+				"winner_id":  *winnerID,
+			})
+		}
+	}
+	return nil
 }
 
 // SetAutoBid sets up automatic bidding for a user
 func (s *Service) SetAutoBid(ctx context.Context, autoBid *models.AutoBid) error {
+	// Default to an increment of five major units in the auction currency
+	if autoBid.BidIncrement == 0 {
+		var auction models.Auction
+		if err := s.db.WithContext(ctx).Select("currency").First(&auction, "id = ?", autoBid.AuctionID).Error; err != nil {
+			return err
+		}
+		autoBid.BidIncrement = money.FromMajor(5, auction.Currency).Amount
+	}
+
 	// Check if auto-bid already exists
 	var existing models.AutoBid
 	err := s.db.WithContext(ctx).
@@ -0,0 +1,299 @@
+package auction
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// sseHistorySize is the number of events kept per auction for Last-Event-ID resume
+	sseHistorySize = 200
+	// sseHistoryTTL is how long an idle auction's event history is kept
+	sseHistoryTTL = time.Hour
+	// sseClientBuffer is the number of events buffered per SSE client before it is dropped
+	sseClientBuffer = 64
+	// sseKeepAlive is the interval between keep-alive comments on idle streams
+	sseKeepAlive = 15 * time.Second
+)
+
+// ephemeralEvents are broadcast to subscribers but never replayed on resume
+var ephemeralEvents = map[string]bool{
+	"status_update": true,
+}
+
+// eventLog keeps the most recent events of an auction room
+type eventLog struct {
+	lastID     uint64
+	events     []WebSocketMessage
+	lastActive time.Time
+}
+
+// sseClient represents a single Server-Sent Events subscriber
+type sseClient struct {
+	events chan WebSocketMessage
+	closed bool
+}
+
+// eventHub records auction events and fans them out to SSE subscribers
+type eventHub struct {
+	mutex   sync.Mutex
+	logs    map[string]*eventLog
+	clients map[string]map[*sseClient]bool
+}
+
+// newEventHub creates an empty event hub
+func newEventHub() *eventHub {
+	return &eventHub{
+		logs:    make(map[string]*eventLog),
+		clients: make(map[string]map[*sseClient]bool),
+	}
+}
+
+// publish assigns an event ID to the message (unless it is ephemeral), stores it
+// for resume and delivers it to all SSE subscribers of the auction
+func (h *eventHub) publish(auctionID string, message WebSocketMessage) WebSocketMessage {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !ephemeralEvents[message.Type] {
+		log := h.logs[auctionID]
+		if log == nil {
+			log = &eventLog{}
+			h.logs[auctionID] = log
+		}
+		log.lastID++
+		log.lastActive = time.Now()
+		message.EventID = log.lastID
+
+		log.events = append(log.events, message)
+		if len(log.events) > sseHistorySize {
+			log.events = log.events[len(log.events)-sseHistorySize:]
+		}
+	}
+
+	for client := range h.clients[auctionID] {
+		select {
+		case client.events <- message:
+		default:
+			// Slow consumer: drop it so it reconnects and resumes with Last-Event-ID
+			h.removeClientLocked(auctionID, client)
+		}
+	}
+
+	h.pruneLocked()
+
+	return message
+}
+
+// subscribe registers a new SSE client and returns the events it missed since lastEventID.
+// The boolean result is false when the requested event is no longer in history.
+func (h *eventHub) subscribe(auctionID string, lastEventID uint64) (*sseClient, []WebSocketMessage, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	client := &sseClient{events: make(chan WebSocketMessage, sseClientBuffer)}
+	if h.clients[auctionID] == nil {
+		h.clients[auctionID] = make(map[*sseClient]bool)
+	}
+	h.clients[auctionID][client] = true
+
+	if lastEventID == 0 {
+		return client, nil, false
+	}
+
+	log := h.logs[auctionID]
+	if log == nil || len(log.events) == 0 || log.events[0].EventID > lastEventID+1 || lastEventID > log.lastID {
+		return client, nil, false
+	}
+
+	var missed []WebSocketMessage
+	for _, event := range log.events {
+		if event.EventID > lastEventID {
+			missed = append(missed, event)
+		}
+	}
+
+	return client, missed, true
+}
+
+// unsubscribe removes an SSE client
+func (h *eventHub) unsubscribe(auctionID string, client *sseClient) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.removeClientLocked(auctionID, client)
+}
+
+// subscriberCount returns the number of SSE subscribers for an auction
+func (h *eventHub) subscriberCount(auctionID string) int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return len(h.clients[auctionID])
+}
+
+// removeClientLocked removes a client; the caller must hold the mutex
+func (h *eventHub) removeClientLocked(auctionID string, client *sseClient) {
+	if client.closed {
+		return
+	}
+	client.closed = true
+	close(client.events)
+
+	delete(h.clients[auctionID], client)
+	if len(h.clients[auctionID]) == 0 {
+		delete(h.clients, auctionID)
+	}
+}
+
+// pruneLocked drops the history of auctions that have been idle for a while
+func (h *eventHub) pruneLocked() {
+	for auctionID, log := range h.logs {
+		if time.Since(log.lastActive) > sseHistoryTTL && len(h.clients[auctionID]) == 0 {
+			delete(h.logs, auctionID)
+		}
+	}
+}
+
+// HandleSSE streams auction events over Server-Sent Events for viewers that cannot
+// open a WebSocket. The stream is read-only; bids are placed through the REST API.
+func (wsm *WebSocketManager) HandleSSE(c *gin.Context) {
+	auctionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid auction ID"})
+		return
+	}
+
+	if _, err := wsm.service.GetAuction(c.Request.Context(), auctionID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Auction not found"})
+		return
+	}
+
+	// EventSource sends Last-Event-ID on reconnect; allow a query param for manual resume
+	lastEventIDStr := c.GetHeader("Last-Event-ID")
+	if lastEventIDStr == "" {
+		lastEventIDStr = c.Query("last_event_id")
+	}
+	var lastEventID uint64
+	if lastEventIDStr != "" {
+		lastEventID, err = strconv.ParseUint(lastEventIDStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Last-Event-ID"})
+			return
+		}
+	}
+
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Streaming not supported"})
+		return
+	}
+
+	client, missed, resumed := wsm.events.subscribe(auctionID.String(), lastEventID)
+	defer wsm.events.unsubscribe(auctionID.String(), client)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	// Ask EventSource to wait a few seconds before reconnecting
+	fmt.Fprint(c.Writer, "retry: 3000\n\n")
+
+	if resumed {
+		for _, event := range missed {
+			if err := writeSSEEvent(c.Writer, event); err != nil {
+				return
+			}
+		}
+	} else if err := wsm.writeInitialSSE(c.Request.Context(), c.Writer, auctionID); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	// Send periodic updates, mirroring the WebSocket handler
+	statusTicker := time.NewTicker(30 * time.Second)
+	defer statusTicker.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-client.events:
+			if !ok {
+				// Dropped for being too slow; the client will reconnect and resume
+				return
+			}
+			if err := writeSSEEvent(c.Writer, event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-statusTicker.C:
+			wsm.sendStatusUpdate(auctionID.String())
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(c.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeInitialSSE sends the current auction snapshot to a new SSE subscriber
+func (wsm *WebSocketManager) writeInitialSSE(ctx context.Context, w http.ResponseWriter, auctionID uuid.UUID) error {
+	auction, err := wsm.service.GetAuction(ctx, auctionID)
+	if err != nil {
+		return err
+	}
+
+	return writeSSEEvent(w, WebSocketMessage{
+		Type:      "initial_data",
+		AuctionID: auctionID.String(),
+		Data: gin.H{
+			"auction":       auction,
+			"user_count":    wsm.getConnectionCount(auctionID.String()),
+			"current_bid":   auction.CurrentBid,
+			"last_event_id": wsm.events.lastEventID(auctionID.String()),
+		},
+		Timestamp: time.Now(),
+	})
+}
+
+// lastEventID returns the ID of the most recent recorded event for an auction
+func (h *eventHub) lastEventID(auctionID string) uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if log := h.logs[auctionID]; log != nil {
+		return log.lastID
+	}
+	return 0
+}
+
+// writeSSEEvent writes a single event in text/event-stream format
+func writeSSEEvent(w http.ResponseWriter, message WebSocketMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	if message.EventID != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", message.EventID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", message.Type, data)
+	return err
+}
@@ -19,29 +19,59 @@ import (
 
 // WebSocketManager manages WebSocket connections for auctions
 type WebSocketManager struct {
-	connections map[string]map[*websocket.Conn]bool // auction_id -> connections
+	connections map[string]map[*wsClient]bool // auction_id -> connections
 	mutex       sync.RWMutex
 	logger      *logging.Logger
 	db          *gorm.DB
 	service     *Service
+	events      *eventHub // event history and SSE subscribers
 }
 
 // WebSocketMessage represents a WebSocket message
 type WebSocketMessage struct {
-	Type      string      `json:"type"` // bid, auction_update, chat, user_count
+	EventID   uint64      `json:"event_id,omitempty"` // sequential per auction, used for SSE resume
+	Type      string      `json:"type"`               // bid, auction_update, auction_extended, chat, status_update, stream_alert
 	AuctionID string      `json:"auction_id"`
 	Data      interface{} `json:"data"`
 	Timestamp time.Time   `json:"timestamp"`
 }
 
+// wsClient is a single WebSocket connection and the wire format it negotiated
+type wsClient struct {
+	conn     *websocket.Conn
+	codec    wireCodec
+	userID   *uuid.UUID
+	writeMux sync.Mutex // gorilla connections support one concurrent writer
+}
+
+// send encodes and writes a message using the client's codec
+func (c *wsClient) send(message WebSocketMessage) error {
+	data, err := c.codec.Encode(message)
+	if err != nil {
+		return err
+	}
+
+	c.writeMux.Lock()
+	defer c.writeMux.Unlock()
+	return c.conn.WriteMessage(c.codec.FrameType(), data)
+}
+
+// sendPrepared writes a message that was encoded once for many connections
+func (c *wsClient) sendPrepared(message *websocket.PreparedMessage) error {
+	c.writeMux.Lock()
+	defer c.writeMux.Unlock()
+	return c.conn.WritePreparedMessage(message)
+}
+
 // NewWebSocketManager creates a new WebSocket manager
 func NewWebSocketManager(db *gorm.DB, service *Service) *WebSocketManager {
 	logger := logging.NewLogger()
 	return &WebSocketManager{
-		connections: make(map[string]map[*websocket.Conn]bool),
+		connections: make(map[string]map[*wsClient]bool),
 		logger:      logger,
 		db:          db,
 		service:     service,
+		events:      newEventHub(),
 	}
 }
 
@@ -50,6 +80,7 @@ func NewWebSocketUpgrader(allowedOrigins []string) websocket.Upgrader {
 	return websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
+		Subprotocols:    supportedSubprotocols,
 		CheckOrigin: func(r *http.Request) bool {
 			origin := r.Header.Get("Origin")
 			if origin == "" {
@@ -74,6 +105,7 @@ func NewWebSocketUpgrader(allowedOrigins []string) websocket.Upgrader {
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	Subprotocols:    supportedSubprotocols,
 	CheckOrigin: func(r *http.Request) bool {
 		origin := r.Header.Get("Origin")
 		if origin == "" {
@@ -125,7 +157,7 @@ func (wsm *WebSocketManager) HandleWebSocket(c *gin.Context) {
 		}
 	}
 
-	// Upgrade HTTP connection to WebSocket
+	// Upgrade HTTP connection to WebSocket; the subprotocol selects the wire format
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Printf("Failed to upgrade WebSocket connection: %v", err)
@@ -133,15 +165,25 @@ func (wsm *WebSocketManager) HandleWebSocket(c *gin.Context) {
 	}
 	defer conn.Close()
 
+	client := &wsClient{
+		conn:   conn,
+		codec:  codecForSubprotocol(conn.Subprotocol()),
+		userID: userID,
+	}
+
 	// Add connection to the auction room
-	wsm.addConnection(auctionID.String(), conn)
-	defer wsm.removeConnection(auctionID.String(), conn)
+	wsm.addConnection(auctionID.String(), client)
+	defer wsm.removeConnection(auctionID.String(), client)
 
 	// Send initial auction state
-	wsm.sendInitialData(conn, auctionID, userID)
+	wsm.sendInitialData(client, auctionID, userID)
 
-	// Handle incoming messages
-	go wsm.handleMessages(conn, auctionID, userID)
+	// Handle incoming messages until the connection closes
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		wsm.handleMessages(client, auctionID, userID)
+	}()
 
 	// Send periodic updates
 	ticker := time.NewTicker(30 * time.Second)
@@ -152,41 +194,36 @@ func (wsm *WebSocketManager) HandleWebSocket(c *gin.Context) {
 		case <-ticker.C:
 			// Send user count and auction status
 			wsm.sendStatusUpdate(auctionID.String())
-		default:
-			// Handle WebSocket ping/pong
-			if _, _, err := conn.ReadMessage(); err != nil {
-				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-					log.Printf("WebSocket error: %v", err)
-				}
-				return
-			}
+		case <-done:
+			return
 		}
 	}
 }
 
 // addConnection adds a WebSocket connection to an auction room
-func (wsm *WebSocketManager) addConnection(auctionID string, conn *websocket.Conn) {
+func (wsm *WebSocketManager) addConnection(auctionID string, client *wsClient) {
 	wsm.mutex.Lock()
 	defer wsm.mutex.Unlock()
 
 	if wsm.connections[auctionID] == nil {
-		wsm.connections[auctionID] = make(map[*websocket.Conn]bool)
+		wsm.connections[auctionID] = make(map[*wsClient]bool)
 	}
-	wsm.connections[auctionID][conn] = true
+	wsm.connections[auctionID][client] = true
 
 	wsm.logger.Info("User joined auction room", map[string]interface{}{
 		"auction_id":  auctionID,
 		"connections": len(wsm.connections[auctionID]),
+		"protocol":    client.codec.Name(),
 	})
 }
 
 // removeConnection removes a WebSocket connection from an auction room
-func (wsm *WebSocketManager) removeConnection(auctionID string, conn *websocket.Conn) {
+func (wsm *WebSocketManager) removeConnection(auctionID string, client *wsClient) {
 	wsm.mutex.Lock()
 	defer wsm.mutex.Unlock()
 
 	if wsm.connections[auctionID] != nil {
-		delete(wsm.connections[auctionID], conn)
+		delete(wsm.connections[auctionID], client)
 		if len(wsm.connections[auctionID]) == 0 {
 			delete(wsm.connections, auctionID)
 		}
@@ -200,6 +237,9 @@ func (wsm *WebSocketManager) removeConnection(auctionID string, conn *websocket.
 
 // broadcastToAuction broadcasts a message to all connections in an auction room
 func (wsm *WebSocketManager) broadcastToAuction(auctionID string, message WebSocketMessage) {
+	// Record the event and deliver it to SSE subscribers
+	message = wsm.events.publish(auctionID, message)
+
 	wsm.mutex.RLock()
 	defer wsm.mutex.RUnlock()
 
@@ -207,14 +247,57 @@ func (wsm *WebSocketManager) broadcastToAuction(auctionID string, message WebSoc
 		return
 	}
 
-	for conn := range wsm.connections[auctionID] {
-		if err := conn.WriteJSON(message); err != nil {
+	// Encode once per wire format rather than once per connection
+	prepared := make(map[string]*websocket.PreparedMessage)
+	for client := range wsm.connections[auctionID] {
+		name := client.codec.Name()
+		if _, ok := prepared[name]; !ok {
+			data, err := client.codec.Encode(message)
+			if err != nil {
+				log.Printf("Error encoding WebSocket message: %v", err)
+				prepared[name] = nil
+				continue
+			}
+			pm, err := websocket.NewPreparedMessage(client.codec.FrameType(), data)
+			if err != nil {
+				log.Printf("Error preparing WebSocket message: %v", err)
+			}
+			prepared[name] = pm
+		}
+		if prepared[name] == nil {
+			continue
+		}
+
+		if err := client.sendPrepared(prepared[name]); err != nil {
 			log.Printf("Error sending WebSocket message: %v", err)
 			// Connection will be cleaned up on next error
 		}
 	}
 }
 
+// SendToUser delivers a private message to one user's connections in an auction room.
+// It is not recorded in the event history, so SSE clients do not receive it.
+func (wsm *WebSocketManager) SendToUser(auctionID, userID uuid.UUID, messageType string, data interface{}) {
+	message := WebSocketMessage{
+		Type:      messageType,
+		AuctionID: auctionID.String(),
+		Data:      data,
+		Timestamp: time.Now(),
+	}
+
+	wsm.mutex.RLock()
+	defer wsm.mutex.RUnlock()
+
+	for client := range wsm.connections[auctionID.String()] {
+		if client.userID == nil || *client.userID != userID {
+			continue
+		}
+		if err := client.send(message); err != nil {
+			log.Printf("Error sending WebSocket message: %v", err)
+		}
+	}
+}
+
 // NotifyBidPlaced notifies all users in an auction about a new bid
 func (wsm *WebSocketManager) NotifyBidPlaced(auctionID uuid.UUID, bid *models.Bid) {
 	// Get bid with user info
@@ -249,6 +332,21 @@ func (wsm *WebSocketManager) NotifyAuctionUpdate(auctionID uuid.UUID, auction *m
 	wsm.broadcastToAuction(auctionID.String(), message)
 }
 
+// NotifyAuctionExtended notifies users that a late bid extended the auction end time
+func (wsm *WebSocketManager) NotifyAuctionExtended(auctionID uuid.UUID, newEndTime time.Time) {
+	message := WebSocketMessage{
+		Type:      "auction_extended",
+		AuctionID: auctionID.String(),
+		Data: gin.H{
+			"end_time":  newEndTime,
+			"time_left": time.Until(newEndTime),
+		},
+		Timestamp: time.Now(),
+	}
+
+	wsm.broadcastToAuction(auctionID.String(), message)
+}
+
 // NotifyChatMessage broadcasts a chat message to all users in an auction
 func (wsm *WebSocketManager) NotifyChatMessage(auctionID uuid.UUID, message *models.ChatMessage) {
 	wsMessage := WebSocketMessage{
@@ -262,7 +360,7 @@ func (wsm *WebSocketManager) NotifyChatMessage(auctionID uuid.UUID, message *mod
 }
 
 // sendInitialData sends initial auction data to a newly connected user
-func (wsm *WebSocketManager) sendInitialData(conn *websocket.Conn, auctionID uuid.UUID, userID *uuid.UUID) {
+func (wsm *WebSocketManager) sendInitialData(client *wsClient, auctionID uuid.UUID, userID *uuid.UUID) {
 	// Get auction details
 	auction, err := wsm.service.GetAuction(context.Background(), auctionID)
 	if err != nil {
@@ -282,22 +380,28 @@ func (wsm *WebSocketManager) sendInitialData(conn *websocket.Conn, auctionID uui
 		Timestamp: time.Now(),
 	}
 
-	if err := conn.WriteJSON(initialMessage); err != nil {
+	if err := client.send(initialMessage); err != nil {
 		log.Printf("Failed to send initial data: %v", err)
 	}
 }
 
 // handleMessages handles incoming WebSocket messages
-func (wsm *WebSocketManager) handleMessages(conn *websocket.Conn, auctionID uuid.UUID, userID *uuid.UUID) {
+func (wsm *WebSocketManager) handleMessages(client *wsClient, auctionID uuid.UUID, userID *uuid.UUID) {
 	for {
-		var message WebSocketMessage
-		if err := conn.ReadJSON(&message); err != nil {
-			if !websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+		_, data, err := client.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket read error: %v", err)
 			}
 			break
 		}
 
+		var message WebSocketMessage
+		if err := client.codec.Decode(data, &message); err != nil {
+			log.Printf("Invalid WebSocket message: %v", err)
+			continue
+		}
+
 		// Handle different message types
 		switch message.Type {
 		case "ping":
@@ -307,7 +411,7 @@ func (wsm *WebSocketManager) handleMessages(conn *websocket.Conn, auctionID uuid
 				AuctionID: auctionID.String(),
 				Timestamp: time.Now(),
 			}
-			conn.WriteJSON(pongMessage)
+			client.send(pongMessage)
 
 		case "chat":
 			// Handle chat message
@@ -389,15 +493,13 @@ func (wsm *WebSocketManager) sendStatusUpdate(auctionID string) {
 	wsm.broadcastToAuction(auctionID, statusMessage)
 }
 
-// getConnectionCount returns the number of active connections for an auction
+// getConnectionCount returns the number of active WebSocket and SSE connections for an auction
 func (wsm *WebSocketManager) getConnectionCount(auctionID string) int {
 	wsm.mutex.RLock()
-	defer wsm.mutex.RUnlock()
+	count := len(wsm.connections[auctionID])
+	wsm.mutex.RUnlock()
 
-	if wsm.connections[auctionID] == nil {
-		return 0
-	}
-	return len(wsm.connections[auctionID])
+	return count + wsm.events.subscriberCount(auctionID)
 }
 
 // GetActiveConnections returns the number of active connections for all auctions
@@ -39,7 +39,8 @@ type CartResponse struct {
 	ExpiresAt      time.Time              `json:"expires_at"`
 	Items          []CartItemResponse     `json:"items"`
 	ItemCount      int                    `json:"item_count"`
-	Subtotal       float64                `json:"subtotal"`
+	Subtotal       int64                  `json:"subtotal"`
+	Currency       string                 `json:"currency"` // currency of every price in the cart
 	TotalItems     int                    `json:"total_items"`
 	CreatedAt      time.Time              `json:"created_at"`
 	UpdatedAt      time.Time              `json:"updated_at"`
@@ -52,7 +53,7 @@ type CartItemResponse struct {
 	Quantity  int                      `json:"quantity"`
 	AddedAt   time.Time                `json:"added_at"`
 	Product   ProductResponse          `json:"product"`
-	LineTotal float64                  `json:"line_total"`
+	LineTotal int64                    `json:"line_total"`
 }
 
 // ProductResponse represents product information in cart context
@@ -61,9 +62,10 @@ type ProductResponse struct {
 	Title         string     `json:"title"`
 	Description   *string    `json:"description"`
 	Condition     *string    `json:"condition"`
-	StartingPrice float64    `json:"starting_price"`
-	ReservePrice  *float64   `json:"reserve_price"`
-	BuyNowPrice   *float64   `json:"buy_now_price"`
+	StartingPrice int64      `json:"starting_price"`
+	ReservePrice  *int64     `json:"reserve_price"`
+	BuyNowPrice   *int64     `json:"buy_now_price"`
+	Currency      string     `json:"currency"`
 	Images        []string   `json:"images"`
 	Status        string     `json:"status"`
 }
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/pkg/money"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
@@ -23,6 +24,12 @@ func NewService(db *gorm.DB) *Service {
 	}
 }
 
+// WithTx returns a service that works inside tx, so cart changes commit or roll back
+// with the caller's own changes
+func (s *Service) WithTx(tx *gorm.DB) *Service {
+	return &Service{db: tx}
+}
+
 // GetOrCreateCart gets existing cart or creates new one
 func (s *Service) GetOrCreateCart(userID *uuid.UUID, token *string) (*CartResponse, error) {
 	var cart Cart
@@ -90,6 +97,21 @@ func (s *Service) AddItem(cartID uuid.UUID, req AddItemRequest) (*CartResponse,
 		return nil, errors.New("product is not available for purchase")
 	}
 
+	// A cart is paid in one currency, so every item must be priced in it
+	for _, item := range cart.Items {
+		if item.ProductID == req.ProductID {
+			continue
+		}
+		var other models.Product
+		if err := s.db.Select("currency").First(&other, "id = ?", item.ProductID).Error; err != nil {
+			return nil, fmt.Errorf("product not found: %w", err)
+		}
+		if other.Currency != product.Currency {
+			return nil, fmt.Errorf("%w: cart is priced in %s, product in %s", money.ErrCurrencyMismatch, other.Currency, product.Currency)
+		}
+		break
+	}
+
 	// Check if item already exists in cart
 	for i, item := range cart.Items {
 		if item.ProductID == req.ProductID {
@@ -236,7 +258,7 @@ func (s *Service) GetCartWithDetails(cartID uuid.UUID) (*CartResponse, error) {
 
 	// Get product details for each item
 	itemResponses := make([]CartItemResponse, len(cart.Items))
-	var subtotal float64
+	subtotal := money.Zero(money.DefaultCurrency)
 	var totalItems int
 
 	for i, item := range cart.Items {
@@ -259,11 +281,20 @@ func (s *Service) GetCartWithDetails(cartID uuid.UUID) (*CartResponse, error) {
 			StartingPrice: product.StartingPrice,
 			ReservePrice:  product.ReservePrice,
 			BuyNowPrice:   product.BuyNowPrice,
+			Currency:      product.Currency,
 			Images:        images,
 			Status:        product.Status,
 		}
 
-		lineTotal := product.StartingPrice * float64(item.Quantity)
+		// Totals are summed in minor units so they do not pick up float rounding errors
+		lineTotal := money.New(product.StartingPrice, product.Currency).Mul(int64(item.Quantity))
+		if i == 0 {
+			subtotal = money.Zero(lineTotal.Currency)
+		}
+		var err error
+		if subtotal, err = subtotal.Add(lineTotal); err != nil {
+			return nil, err
+		}
 
 		itemResponses[i] = CartItemResponse{
 			ID:        item.ID,
@@ -271,10 +302,9 @@ func (s *Service) GetCartWithDetails(cartID uuid.UUID) (*CartResponse, error) {
 			Quantity:  item.Quantity,
 			AddedAt:   item.AddedAt,
 			Product:   productResponse,
-			LineTotal: lineTotal,
+			LineTotal: lineTotal.Amount,
 		}
 
-		subtotal += lineTotal
 		totalItems += item.Quantity
 	}
 
@@ -285,7 +315,8 @@ func (s *Service) GetCartWithDetails(cartID uuid.UUID) (*CartResponse, error) {
 		ExpiresAt:  cart.ExpiresAt,
 		Items:      itemResponses,
 		ItemCount:  len(itemResponses),
-		Subtotal:   subtotal,
+		Subtotal:   subtotal.Amount,
+		Currency:   subtotal.Currency,
 		TotalItems: totalItems,
 		CreatedAt:  cart.CreatedAt,
 		UpdatedAt:  cart.UpdatedAt,
@@ -365,7 +396,7 @@ func (s *Service) cartToResponse(cart *Cart) (*CartResponse, error) {
 
 	// Get product details for each item
 	itemResponses := make([]CartItemResponse, len(cartWithItems.Items))
-	var subtotal float64
+	subtotal := money.Zero(money.DefaultCurrency)
 	var totalItems int
 
 	for i, item := range cartWithItems.Items {
@@ -389,11 +420,20 @@ func (s *Service) cartToResponse(cart *Cart) (*CartResponse, error) {
 			StartingPrice: product.StartingPrice,
 			ReservePrice:  product.ReservePrice,
 			BuyNowPrice:   product.BuyNowPrice,
+			Currency:      product.Currency,
 			Images:        images,
 			Status:        product.Status,
 		}
 
-		lineTotal := product.StartingPrice * float64(item.Quantity)
+		// Totals are summed in minor units so they do not pick up float rounding errors
+		lineTotal := money.New(product.StartingPrice, product.Currency).Mul(int64(item.Quantity))
+		if i == 0 {
+			subtotal = money.Zero(lineTotal.Currency)
+		}
+		var err error
+		if subtotal, err = subtotal.Add(lineTotal); err != nil {
+			return nil, err
+		}
 
 		itemResponses[i] = CartItemResponse{
 			ID:        item.ID,
@@ -401,10 +441,9 @@ func (s *Service) cartToResponse(cart *Cart) (*CartResponse, error) {
 			Quantity:  item.Quantity,
 			AddedAt:   item.AddedAt,
 			Product:   productResponse,
-			LineTotal: lineTotal,
+			LineTotal: lineTotal.Amount,
 		}
 
-		subtotal += lineTotal
 		totalItems += item.Quantity
 	}
 
@@ -415,7 +454,8 @@ func (s *Service) cartToResponse(cart *Cart) (*CartResponse, error) {
 		ExpiresAt:  cart.ExpiresAt,
 		Items:      itemResponses,
 		ItemCount:  len(itemResponses),
-		Subtotal:   subtotal,
+		Subtotal:   subtotal.Amount,
+		Currency:   subtotal.Currency,
 		TotalItems: totalItems,
 		CreatedAt:  cart.CreatedAt,
 		UpdatedAt:  cart.UpdatedAt,
@@ -703,13 +703,13 @@ func (h *Handler) SearchProducts(c *gin.Context) {
 	}
 	
 	if minPrice := c.Query("min_price"); minPrice != "" {
-		if price, err := strconv.ParseFloat(minPrice, 64); err == nil {
+		if price, err := strconv.ParseInt(minPrice, 10, 64); err == nil {
 			filters["min_price"] = price
 		}
 	}
 	
 	if maxPrice := c.Query("max_price"); maxPrice != "" {
-		if price, err := strconv.ParseFloat(maxPrice, 64); err == nil {
+		if price, err := strconv.ParseInt(maxPrice, 10, 64); err == nil {
 			filters["max_price"] = price
 		}
 	}
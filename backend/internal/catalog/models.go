@@ -98,9 +98,9 @@ type ProductVariant struct {
 	ProductID   uuid.UUID `gorm:"not null;references:ID" json:"product_id"`
 	Sku         string    `gorm:"uniqueIndex;not null" json:"sku"`
 	Title       string    `gorm:"not null" json:"title"`
-	Price       float64   `gorm:"not null" json:"price"`
-	ComparePrice *float64 `json:"compare_price"`
-	CostPrice   *float64  `json:"cost_price"`
+	Price       int64     `gorm:"not null" json:"price"`
+	ComparePrice *int64   `json:"compare_price"`
+	CostPrice   *int64    `json:"cost_price"`
 	Weight      *float64  `json:"weight,omitempty"`
 	Barcode     *string   `json:"barcode,omitempty"`
 	Inventory   int       `gorm:"default:0" json:"inventory"`
@@ -116,9 +116,9 @@ type ProductVariant struct {
 type ProductVariantRequest struct {
 	Title        string             `json:"title" binding:"required,min=2,max=100"`
 	Sku          string             `json:"sku" binding:"required,min=2,max=50"`
-	Price        float64            `json:"price" binding:"required,gt=0"`
-	ComparePrice *float64           `json:"compare_price,omitempty" binding:"omitempty,gt=0"`
-	CostPrice    *float64           `json:"cost_price,omitempty" binding:"omitempty,gt=0"`
+	Price        int64              `json:"price" binding:"required,gt=0"`
+	ComparePrice *int64             `json:"compare_price,omitempty" binding:"omitempty,gt=0"`
+	CostPrice    *int64             `json:"cost_price,omitempty" binding:"omitempty,gt=0"`
 	Weight       *float64           `json:"weight,omitempty" binding:"omitempty,gt=0"`
 	Barcode      *string            `json:"barcode,omitempty"`
 	Inventory    *int               `json:"inventory,omitempty" binding:"omitempty,gte=0"`
@@ -134,9 +134,9 @@ type ProductVariantResponse struct {
 	ProductID    uuid.UUID          `json:"product_id"`
 	Sku          string             `json:"sku"`
 	Title        string             `json:"title"`
-	Price        float64            `json:"price"`
-	ComparePrice *float64           `json:"compare_price"`
-	CostPrice    *float64           `json:"cost_price"`
+	Price        int64              `json:"price"`
+	ComparePrice *int64             `json:"compare_price"`
+	CostPrice    *int64             `json:"cost_price"`
 	Weight       *float64           `json:"weight"`
 	Barcode      *string            `json:"barcode"`
 	Inventory    int                `json:"inventory"`
@@ -271,14 +271,14 @@ type CategoryStats struct {
 	Name          string    `json:"name"`
 	ProductCount  int       `json:"product_count"`
 	ActiveCount   int       `json:"active_count"`
-	TotalRevenue  float64   `json:"total_revenue"`
+	TotalRevenue  int64     `json:"total_revenue"`
 }
 
 // ProductSummary represents product summary for stats
 type ProductSummary struct {
 	ID        uuid.UUID `json:"id"`
 	Title     string    `json:"title"`
-	Price     float64   `json:"price"`
+	Price     int64     `json:"price"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -1224,7 +1224,7 @@ func (s *service) GetRelatedProducts(productID uuid.UUID, limit int) ([]models.P
 	// If not enough products by category, get more by similar price range
 	if len(products) < limit {
 		remaining := limit - len(products)
-		priceRange := product.StartingPrice * 0.2 // 20% price range
+		priceRange := product.StartingPrice / 5 // 20% price range
 
 		var additionalProducts []models.Product
 		if err := s.db.Where("id NOT IN (?) AND id != ? AND status = 'active' AND starting_price BETWEEN ? AND ?", 
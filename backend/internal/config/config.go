@@ -26,6 +26,26 @@ type Config struct {
 	GeminiAPIKey        string
 	StripeSecretKey     string
 	StripeWebhookSecret string
+	PaymentGateway      string // stripe or fake
+	FakeWebhookSecret   string // signs webhooks of the fake payment gateway; random when unset
+	PayoutProvider      string // stripe (Connect) or fake
+	PayoutHoldDays      int    // days after delivery before an order's earnings can be paid out
+	StreamProvider      string // livekit or fake
+	FakeStreamSecret    string // signs webhooks of the fake stream provider; random when unset
+	LiveKitHost         string
+	LiveKitAPIKey       string
+	LiveKitAPISecret    string
+	StreamHostGraceSecs int // end a live auction when the host is away this long; 0 disables
+	RecordingDir        string
+	RecordingBaseURL    string
+	RecordingRetention  int    // days to keep stream recordings; 0 keeps them forever
+	ReminderLeadMins    int    // default minutes before a show starts to send "notify me" reminders
+	SMTPHost            string // reminders are emailed through this server; only logged when unset
+	SMTPPort            string
+	SMTPUsername        string
+	SMTPPassword        string
+	SMTPFrom            string
+	AppURL              string // public URL of the web app, used for links in emails
 }
 
 func Load() (*Config, error) {
@@ -49,6 +69,26 @@ func Load() (*Config, error) {
 		GeminiAPIKey:        getEnv("GEMINI_API_KEY", ""),
 		StripeSecretKey:     getEnv("STRIPE_SECRET_KEY", ""),
 		StripeWebhookSecret: getEnv("STRIPE_WEBHOOK_SECRET", ""),
+		PaymentGateway:      getEnv("PAYMENT_GATEWAY", "stripe"),
+		FakeWebhookSecret:   getEnv("FAKE_PAYMENT_WEBHOOK_SECRET", ""),
+		PayoutProvider:      getEnv("PAYOUT_PROVIDER", "stripe"),
+		PayoutHoldDays:      getEnvAsInt("PAYOUT_HOLD_DAYS", 7),
+		StreamProvider:      getEnv("STREAM_PROVIDER", "livekit"),
+		FakeStreamSecret:    getEnv("FAKE_STREAM_WEBHOOK_SECRET", ""),
+		LiveKitHost:         getEnv("LIVEKIT_HOST", "http://localhost:7880"),
+		LiveKitAPIKey:       getEnv("LIVEKIT_API_KEY", ""),
+		LiveKitAPISecret:    getEnv("LIVEKIT_API_SECRET", ""),
+		StreamHostGraceSecs: getEnvAsInt("STREAM_HOST_GRACE_SECONDS", 0),
+		RecordingDir:        getEnv("RECORDING_DIR", "./recordings"),
+		RecordingBaseURL:    getEnv("RECORDING_BASE_URL", "/recordings"),
+		RecordingRetention:  getEnvAsInt("RECORDING_RETENTION_DAYS", 30),
+		ReminderLeadMins:    getEnvAsInt("REMINDER_LEAD_MINUTES", 15),
+		SMTPHost:            getEnv("SMTP_HOST", ""),
+		SMTPPort:            getEnv("SMTP_PORT", "587"),
+		SMTPUsername:        getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:        getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:            getEnv("SMTP_FROM", "Blytz Live <no-reply@blytz.live>"),
+		AppURL:              getEnv("APP_URL", "http://localhost:3000"),
 	}
 
 	// Validate critical security settings in production
@@ -80,6 +120,18 @@ func Load() (*Config, error) {
 		if cfg.StripeSecretKey == "sk_test_placeholder_change_in_production" {
 			return nil, fmt.Errorf("STRIPE_SECRET_KEY must be changed from placeholder in production")
 		}
+		if cfg.PaymentGateway == "fake" {
+			return nil, fmt.Errorf("PAYMENT_GATEWAY cannot be fake in production")
+		}
+		if cfg.PayoutProvider == "fake" {
+			return nil, fmt.Errorf("PAYOUT_PROVIDER cannot be fake in production")
+		}
+		if cfg.StreamProvider == "fake" {
+			return nil, fmt.Errorf("STREAM_PROVIDER cannot be fake in production")
+		}
+		if cfg.StreamProvider == "livekit" && (cfg.LiveKitAPIKey == "" || cfg.LiveKitAPISecret == "") {
+			return nil, fmt.Errorf("LIVEKIT_API_KEY and LIVEKIT_API_SECRET must be set in production")
+		}
 	}
 
 	return cfg, nil
@@ -0,0 +1,207 @@
+package credits
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/blytz.live.remake/backend/pkg/money"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Handler provides gift card and store credit HTTP handlers
+type Handler struct {
+	service *Service
+}
+
+// NewHandler creates a new credit handler
+func NewHandler(service *Service) *Handler {
+	return &Handler{
+		service: service,
+	}
+}
+
+// GetMyStoreCredit returns the current user's store credit balances
+func (h *Handler) GetMyStoreCredit(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	wallets, err := h.service.Wallets(c.Request.Context(), userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"wallets": wallets})
+}
+
+// ListMyCreditMovements lists the current user's store credit and gift card movements
+func (h *Handler) ListMyCreditMovements(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	page, limit := pagination(c)
+	movements, total, err := h.service.ListMovements(c.Request.Context(), userID.(uuid.UUID), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, paginated(movements, total, page, limit))
+}
+
+// CheckGiftCard returns the balance of a gift card by its code
+func (h *Handler) CheckGiftCard(c *gin.Context) {
+	card, err := h.service.LookupGiftCard(c.Request.Context(), c.Param("code"))
+	if err != nil {
+		creditError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"balance":    card.Balance,
+		"currency":   card.Currency,
+		"status":     card.Status,
+		"expires_at": card.ExpiresAt,
+	})
+}
+
+// IssueGiftCard issues a gift card (admin only)
+func (h *Handler) IssueGiftCard(c *gin.Context) {
+	// Check if user is admin
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	var req IssueGiftCardRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	card, err := h.service.IssueGiftCard(c.Request.Context(), userID.(uuid.UUID), req)
+	if err != nil {
+		creditError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, card)
+}
+
+// ListGiftCards lists gift cards (admin only)
+func (h *Handler) ListGiftCards(c *gin.Context) {
+	// Check if user is admin
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	page, limit := pagination(c)
+	cards, total, err := h.service.ListGiftCards(c.Request.Context(), c.Query("status"), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, paginated(cards, total, page, limit))
+}
+
+// GetGiftCard returns a gift card with its movements (admin only)
+func (h *Handler) GetGiftCard(c *gin.Context) {
+	// Check if user is admin
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid gift card ID"})
+		return
+	}
+
+	card, movements, err := h.service.GetGiftCard(c.Request.Context(), id)
+	if err != nil {
+		creditError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"gift_card": card, "movements": movements})
+}
+
+// GrantStoreCredit gives a user store credit (admin only)
+func (h *Handler) GrantStoreCredit(c *gin.Context) {
+	// Check if user is admin
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	var req GrantStoreCreditRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	wallet, err := h.service.GrantStoreCredit(c.Request.Context(), userID.(uuid.UUID), req)
+	if err != nil {
+		creditError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, wallet)
+}
+
+// creditError writes the response for a credit service error
+func creditError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, ErrGiftCardNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidAmount), errors.Is(err, money.ErrUnknownCurrency),
+		errors.Is(err, ErrGiftCardUnusable), errors.Is(err, ErrCurrencyMismatch):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// pagination reads the page and limit query parameters
+func pagination(c *gin.Context) (int, int) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	return page, limit
+}
+
+// paginated wraps a page of results with its pagination details
+func paginated(data interface{}, total int64, page, limit int) gin.H {
+	return gin.H{
+		"data": data,
+		"pagination": gin.H{
+			"page":        page,
+			"limit":       limit,
+			"total":       total,
+			"total_pages": (total + int64(limit) - 1) / int64(limit),
+		},
+	}
+}
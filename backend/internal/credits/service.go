@@ -0,0 +1,625 @@
+package credits
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/common"
+	"github.com/blytz.live.remake/backend/internal/ledger"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/pkg/logging"
+	"github.com/blytz.live.remake/backend/pkg/money"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Gift card statuses
+const (
+	GiftCardActive  = "active"
+	GiftCardExpired = "expired"
+)
+
+// Credit movement types
+const (
+	MovementIssue   = "issue"   // a gift card was issued
+	MovementGrant   = "grant"   // store credit was given as a promotion
+	MovementRefund  = "refund"  // a refund was paid as store credit
+	MovementRedeem  = "redeem"  // credit was applied to an order
+	MovementRestore = "restore" // credit applied to an order that was not paid came back
+	MovementExpire  = "expire"  // a gift card expired with a balance left
+)
+
+var (
+	// ErrGiftCardNotFound is returned for a gift card code that does not exist
+	ErrGiftCardNotFound = errors.New("gift card not found")
+	// ErrGiftCardUnusable is returned when redeeming a gift card that has expired or has
+	// no balance left
+	ErrGiftCardUnusable = errors.New("gift card is expired or has no balance left")
+	// ErrCurrencyMismatch is returned when applying credit in another currency than the order's
+	ErrCurrencyMismatch = errors.New("credit currency does not match the order")
+	// ErrInvalidAmount is returned when issuing or granting a non-positive amount
+	ErrInvalidAmount = errors.New("amount must be positive")
+)
+
+// codeAlphabet leaves out characters that are easily confused, like 0 and O
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// IssueGiftCardRequest represents a request to issue a gift card
+type IssueGiftCardRequest struct {
+	Amount         int64      `json:"amount" binding:"required,gt=0"`
+	Currency       string     `json:"currency"` // defaults to USD
+	ExpiresAt      *time.Time `json:"expires_at"`
+	RecipientEmail *string    `json:"recipient_email" binding:"omitempty,email"`
+	Note           *string    `json:"note"`
+}
+
+// GrantStoreCreditRequest represents a request to give a user store credit
+type GrantStoreCreditRequest struct {
+	UserID      uuid.UUID `json:"user_id" binding:"required"`
+	Amount      int64     `json:"amount" binding:"required,gt=0"`
+	Currency    string    `json:"currency"` // defaults to USD
+	Description string    `json:"description"`
+}
+
+// Service issues gift cards, keeps store credit wallets and applies both to orders
+type Service struct {
+	db     *gorm.DB
+	logger *logging.Logger
+	ledger *ledger.Service
+}
+
+// NewService creates a new credit service
+func NewService(db *gorm.DB) *Service {
+	return &Service{
+		db:     db,
+		logger: logging.NewLogger(),
+	}
+}
+
+// SetLedger sets the ledger that every credit movement is posted to
+func (s *Service) SetLedger(ledgerService *ledger.Service) {
+	s.ledger = ledgerService
+}
+
+// IssueGiftCard issues a gift card with a new code. The platform funds its balance as a
+// promotion.
+func (s *Service) IssueGiftCard(ctx context.Context, issuedBy uuid.UUID, req IssueGiftCardRequest) (*models.GiftCard, error) {
+	amount, err := positiveAmount(req.Amount, req.Currency)
+	if err != nil {
+		return nil, err
+	}
+	code, err := generateCode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate gift card code: %w", err)
+	}
+
+	card := &models.GiftCard{
+		BaseModel:      common.BaseModel{ID: uuid.New()},
+		Code:           code,
+		Currency:       amount.Currency,
+		InitialBalance: amount.Amount,
+		Balance:        amount.Amount,
+		Status:         GiftCardActive,
+		ExpiresAt:      req.ExpiresAt,
+		IssuedBy:       issuedBy,
+		RecipientEmail: req.RecipientEmail,
+		Note:           req.Note,
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(card).Error; err != nil {
+			return fmt.Errorf("failed to create gift card: %w", err)
+		}
+		movement := &models.CreditMovement{
+			GiftCardID:  &card.ID,
+			UserID:      issuedBy,
+			Type:        MovementIssue,
+			Amount:      amount.Amount,
+			Currency:    amount.Currency,
+			Description: fmt.Sprintf("Gift card %s issued", maskCode(code)),
+		}
+		return s.record(ctx, tx, movement, ledger.PromotionsAccount(), ledger.GiftCardsAccount())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Gift card issued", map[string]interface{}{
+		"gift_card_id": card.ID,
+		"issued_by":    issuedBy,
+		"amount":       amount.String(),
+	})
+
+	return card, nil
+}
+
+// LookupGiftCard returns a gift card by its code
+func (s *Service) LookupGiftCard(ctx context.Context, code string) (*models.GiftCard, error) {
+	var card models.GiftCard
+	err := s.db.WithContext(ctx).Where("code = ?", normalizeCode(code)).First(&card).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrGiftCardNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &card, nil
+}
+
+// GetGiftCard returns a gift card with its movements, newest first
+func (s *Service) GetGiftCard(ctx context.Context, id uuid.UUID) (*models.GiftCard, []models.CreditMovement, error) {
+	var card models.GiftCard
+	if err := s.db.WithContext(ctx).First(&card, "id = ?", id).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var movements []models.CreditMovement
+	if err := s.db.WithContext(ctx).
+		Where("gift_card_id = ?", id).
+		Order("created_at DESC").
+		Find(&movements).Error; err != nil {
+		return nil, nil, err
+	}
+	return &card, movements, nil
+}
+
+// ListGiftCards lists gift cards, newest first, optionally by status
+func (s *Service) ListGiftCards(ctx context.Context, status string, page, limit int) ([]models.GiftCard, int64, error) {
+	query := s.db.WithContext(ctx).Model(&models.GiftCard{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var cards []models.GiftCard
+	err := query.Order("created_at DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&cards).Error
+	return cards, total, err
+}
+
+// GrantStoreCredit adds store credit to a user's wallet as a promotion
+func (s *Service) GrantStoreCredit(ctx context.Context, grantedBy uuid.UUID, req GrantStoreCreditRequest) (*models.StoreCreditWallet, error) {
+	amount, err := positiveAmount(req.Amount, req.Currency)
+	if err != nil {
+		return nil, err
+	}
+	description := req.Description
+	if description == "" {
+		description = "Store credit granted"
+	}
+
+	var wallet *models.StoreCreditWallet
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		wallet, err = s.wallet(tx, req.UserID, amount.Currency)
+		if err != nil {
+			return err
+		}
+		if err := adjustWallet(tx, wallet, amount); err != nil {
+			return err
+		}
+		movement := &models.CreditMovement{
+			WalletID:    &wallet.ID,
+			UserID:      req.UserID,
+			Type:        MovementGrant,
+			Amount:      amount.Amount,
+			Currency:    amount.Currency,
+			Description: description,
+		}
+		return s.record(ctx, tx, movement, ledger.PromotionsAccount(), ledger.StoreCreditAccount(req.UserID))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Store credit granted", map[string]interface{}{
+		"user_id":    req.UserID,
+		"granted_by": grantedBy,
+		"amount":     amount.String(),
+	})
+
+	return wallet, nil
+}
+
+// FundRefund adds the part of a refund paid as store credit to the buyer's wallet, within
+// the transaction that records the refund. The refund's own ledger posting credits the
+// buyer's store credit account.
+func (s *Service) FundRefund(ctx context.Context, tx *gorm.DB, refund *models.Refund, payment *models.Payment) error {
+	amount := money.New(refund.CreditAmount, payment.Currency)
+
+	wallet, err := s.wallet(tx, payment.UserID, amount.Currency)
+	if err != nil {
+		return err
+	}
+	if err := adjustWallet(tx, wallet, amount); err != nil {
+		return err
+	}
+
+	movement := &models.CreditMovement{
+		WalletID:    &wallet.ID,
+		UserID:      payment.UserID,
+		Type:        MovementRefund,
+		Amount:      amount.Amount,
+		Currency:    amount.Currency,
+		OrderID:     refund.OrderID,
+		RefundID:    &refund.ID,
+		Description: fmt.Sprintf("Refund %s as store credit", amount),
+	}
+	if err := tx.Create(movement).Error; err != nil {
+		return fmt.Errorf("failed to record credit movement: %w", err)
+	}
+	return nil
+}
+
+// Wallets returns a user's store credit wallets
+func (s *Service) Wallets(ctx context.Context, userID uuid.UUID) ([]models.StoreCreditWallet, error) {
+	var wallets []models.StoreCreditWallet
+	err := s.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("currency ASC").
+		Find(&wallets).Error
+	return wallets, err
+}
+
+// ListMovements lists a user's store credit movements and the gift card redemptions they
+// made, newest first
+func (s *Service) ListMovements(ctx context.Context, userID uuid.UUID, page, limit int) ([]models.CreditMovement, int64, error) {
+	query := s.db.WithContext(ctx).Model(&models.CreditMovement{}).
+		Where("user_id = ? AND type <> ?", userID, MovementIssue)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var movements []models.CreditMovement
+	err := query.Order("created_at DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&movements).Error
+	return movements, total, err
+}
+
+// RedeemForOrder applies a gift card, then the buyer's store credit, to at most amount of
+// an order within the transaction that creates it, and returns the amount applied. The
+// credit is held in the buyer's ledger account until the order is paid or released.
+func (s *Service) RedeemForOrder(ctx context.Context, tx *gorm.DB, userID, orderID uuid.UUID, amount money.Money, giftCardCode string, useStoreCredit bool) (money.Money, error) {
+	applied := money.Zero(amount.Currency)
+
+	if giftCardCode != "" {
+		var card models.GiftCard
+		if err := tx.Where("code = ?", normalizeCode(giftCardCode)).First(&card).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return applied, ErrGiftCardNotFound
+			}
+			return applied, err
+		}
+		if card.Currency != amount.Currency {
+			return applied, ErrCurrencyMismatch
+		}
+		balance := money.New(card.Balance, card.Currency)
+		if card.Status != GiftCardActive || balance.Amount <= 0 ||
+			(card.ExpiresAt != nil && !card.ExpiresAt.After(time.Now())) {
+			return applied, ErrGiftCardUnusable
+		}
+
+		take := money.New(min(balance.Amount, amount.Amount), amount.Currency)
+		if err := adjustGiftCard(tx, &card, money.New(-take.Amount, take.Currency)); err != nil {
+			return applied, err
+		}
+		movement := &models.CreditMovement{
+			GiftCardID:  &card.ID,
+			UserID:      userID,
+			Type:        MovementRedeem,
+			Amount:      -take.Amount,
+			Currency:    take.Currency,
+			OrderID:     &orderID,
+			Description: fmt.Sprintf("Gift card %s applied to order", maskCode(card.Code)),
+		}
+		if err := s.record(ctx, tx, movement, ledger.GiftCardsAccount(), ledger.BuyerAccount(userID)); err != nil {
+			return applied, err
+		}
+		applied.Amount += take.Amount
+	}
+
+	if useStoreCredit && applied.Amount < amount.Amount {
+		var wallet models.StoreCreditWallet
+		err := tx.Where("user_id = ? AND currency = ?", userID, amount.Currency).First(&wallet).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return applied, err
+		}
+		balance := money.New(wallet.Balance, amount.Currency)
+		if err == nil && balance.Amount > 0 {
+			take := money.New(min(balance.Amount, amount.Amount-applied.Amount), amount.Currency)
+			if err := adjustWallet(tx, &wallet, money.New(-take.Amount, take.Currency)); err != nil {
+				return applied, err
+			}
+			movement := &models.CreditMovement{
+				WalletID:    &wallet.ID,
+				UserID:      userID,
+				Type:        MovementRedeem,
+				Amount:      -take.Amount,
+				Currency:    take.Currency,
+				OrderID:     &orderID,
+				Description: "Store credit applied to order",
+			}
+			if err := s.record(ctx, tx, movement, ledger.StoreCreditAccount(userID), ledger.BuyerAccount(userID)); err != nil {
+				return applied, err
+			}
+			applied.Amount += take.Amount
+		}
+	}
+
+	return applied, nil
+}
+
+// SettleOrderCredit pays the credit applied to an order to its sellers once the order
+// is paid, within the transaction that marks it paid
+func (s *Service) SettleOrderCredit(ctx context.Context, tx *gorm.DB, orderID uuid.UUID) error {
+	var order models.Order
+	if err := tx.First(&order, "id = ?", orderID).Error; err != nil {
+		return fmt.Errorf("order not found: %w", err)
+	}
+	credit := money.New(order.CreditAmount, order.Currency)
+	if credit.Amount <= 0 || s.ledger == nil {
+		return nil
+	}
+
+	if _, err := s.ledger.WithTx(tx).PostCreditSettlement(ctx, orderID, order.UserID, credit); err != nil {
+		return fmt.Errorf("failed to post credit settlement: %w", err)
+	}
+	return nil
+}
+
+// RestoreOrderCredit returns the gift card balances and store credit applied to an order
+// that was released unpaid, within the transaction that releases it
+func (s *Service) RestoreOrderCredit(ctx context.Context, tx *gorm.DB, orderID uuid.UUID) error {
+	var movements []models.CreditMovement
+	if err := tx.Where("order_id = ? AND type IN ?", orderID, []string{MovementRedeem, MovementRestore}).
+		Order("created_at ASC").
+		Find(&movements).Error; err != nil {
+		return err
+	}
+	for _, movement := range movements {
+		if movement.Type == MovementRestore {
+			return nil
+		}
+	}
+
+	for _, redeemed := range movements {
+		amount := money.New(-redeemed.Amount, redeemed.Currency)
+		restore := &models.CreditMovement{
+			GiftCardID: redeemed.GiftCardID,
+			WalletID:   redeemed.WalletID,
+			UserID:     redeemed.UserID,
+			Type:       MovementRestore,
+			Amount:     amount.Amount,
+			Currency:   amount.Currency,
+			OrderID:    &orderID,
+		}
+
+		var to ledger.Account
+		if redeemed.GiftCardID != nil {
+			var card models.GiftCard
+			if err := tx.First(&card, "id = ?", *redeemed.GiftCardID).Error; err != nil {
+				return err
+			}
+			if err := adjustGiftCard(tx, &card, amount); err != nil {
+				return err
+			}
+			// A card that expired meanwhile expires again on the next run, recording breakage
+			if card.Status != GiftCardActive {
+				if err := tx.Model(&card).Update("status", GiftCardActive).Error; err != nil {
+					return err
+				}
+			}
+			restore.Description = fmt.Sprintf("Gift card %s restored from unpaid order", maskCode(card.Code))
+			to = ledger.GiftCardsAccount()
+		} else {
+			var wallet models.StoreCreditWallet
+			if err := tx.First(&wallet, "id = ?", *redeemed.WalletID).Error; err != nil {
+				return err
+			}
+			if err := adjustWallet(tx, &wallet, amount); err != nil {
+				return err
+			}
+			restore.Description = "Store credit restored from unpaid order"
+			to = ledger.StoreCreditAccount(wallet.UserID)
+		}
+
+		if err := s.record(ctx, tx, restore, ledger.BuyerAccount(redeemed.UserID), to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExpireGiftCards expires active gift cards past their expiry date. Their remaining
+// balance is recognized as breakage. It returns the number of cards expired.
+func (s *Service) ExpireGiftCards(ctx context.Context, now time.Time) (int, error) {
+	var cards []models.GiftCard
+	if err := s.db.WithContext(ctx).
+		Where("status = ? AND expires_at <= ?", GiftCardActive, now).
+		Find(&cards).Error; err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for i := range cards {
+		card := &cards[i]
+		balance := money.New(card.Balance, card.Currency)
+		err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&models.GiftCard{}).
+				Where("id = ? AND status = ? AND balance = ?", card.ID, GiftCardActive, card.Balance).
+				Updates(map[string]interface{}{"status": GiftCardExpired, "balance": 0})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 || balance.Amount <= 0 {
+				return nil
+			}
+
+			movement := &models.CreditMovement{
+				GiftCardID:  &card.ID,
+				UserID:      card.IssuedBy,
+				Type:        MovementExpire,
+				Amount:      -balance.Amount,
+				Currency:    balance.Currency,
+				Description: fmt.Sprintf("Gift card %s expired", maskCode(card.Code)),
+			}
+			return s.record(ctx, tx, movement, ledger.GiftCardsAccount(), ledger.BreakageAccount())
+		})
+		if err != nil {
+			s.logger.Error("Failed to expire gift card", map[string]interface{}{
+				"error":        err.Error(),
+				"gift_card_id": card.ID,
+			})
+			continue
+		}
+		expired++
+	}
+
+	if expired > 0 {
+		s.logger.Info("Gift cards expired", map[string]interface{}{
+			"count": expired,
+		})
+	}
+	return expired, nil
+}
+
+// record saves a credit movement and posts it to the ledger, debiting from and crediting to
+func (s *Service) record(ctx context.Context, tx *gorm.DB, movement *models.CreditMovement, from, to ledger.Account) error {
+	if movement.ID == uuid.Nil {
+		movement.ID = uuid.New()
+	}
+	if err := tx.Create(movement).Error; err != nil {
+		return fmt.Errorf("failed to record credit movement: %w", err)
+	}
+	if s.ledger != nil {
+		if _, err := s.ledger.WithTx(tx).PostCredit(ctx, movement, from, to); err != nil {
+			return fmt.Errorf("failed to post credit movement to ledger: %w", err)
+		}
+	}
+	return nil
+}
+
+// wallet returns a user's wallet in a currency, creating an empty one the first time
+func (s *Service) wallet(tx *gorm.DB, userID uuid.UUID, currency string) (*models.StoreCreditWallet, error) {
+	var wallet models.StoreCreditWallet
+	err := tx.Where("user_id = ? AND currency = ?", userID, currency).First(&wallet).Error
+	if err == nil {
+		return &wallet, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	wallet = models.StoreCreditWallet{UserID: userID, Currency: currency}
+	if err := tx.Create(&wallet).Error; err != nil {
+		return nil, fmt.Errorf("failed to create store credit wallet: %w", err)
+	}
+	return &wallet, nil
+}
+
+// adjustGiftCard changes a gift card's balance by delta. The update only applies to the
+// balance that was read, so concurrent redemptions cannot spend it twice.
+func adjustGiftCard(tx *gorm.DB, card *models.GiftCard, delta money.Money) error {
+	balance := money.New(card.Balance, card.Currency)
+	balance.Amount += delta.Amount
+	if balance.Amount < 0 {
+		return ErrGiftCardUnusable
+	}
+
+	result := tx.Model(&models.GiftCard{}).
+		Where("id = ? AND balance = ?", card.ID, card.Balance).
+		Update("balance", balance.Amount)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update gift card: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: balance changed concurrently", ErrGiftCardUnusable)
+	}
+	card.Balance = balance.Amount
+	return nil
+}
+
+// adjustWallet changes a wallet's balance by delta, like adjustGiftCard
+func adjustWallet(tx *gorm.DB, wallet *models.StoreCreditWallet, delta money.Money) error {
+	balance := money.New(wallet.Balance, wallet.Currency)
+	balance.Amount += delta.Amount
+	if balance.Amount < 0 {
+		return fmt.Errorf("store credit balance cannot go below zero")
+	}
+
+	result := tx.Model(&models.StoreCreditWallet{}).
+		Where("id = ? AND balance = ?", wallet.ID, wallet.Balance).
+		Update("balance", balance.Amount)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update store credit wallet: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("store credit balance changed concurrently")
+	}
+	wallet.Balance = balance.Amount
+	return nil
+}
+
+// positiveAmount validates an amount to issue or grant, defaulting to USD
+func positiveAmount(amount int64, currency string) (money.Money, error) {
+	if currency == "" {
+		currency = "USD"
+	}
+	currency = money.NormalizeCurrency(currency)
+	if !money.IsSupported(currency) {
+		return money.Money{}, fmt.Errorf("%w: %s", money.ErrUnknownCurrency, currency)
+	}
+	result := money.New(amount, currency)
+	if result.Amount <= 0 {
+		return money.Money{}, ErrInvalidAmount
+	}
+	return result, nil
+}
+
+// generateCode returns a random gift card code like ABCD-EFGH-JKLM-NPQR
+func generateCode() (string, error) {
+	var b strings.Builder
+	for i := 0; i < 16; i++ {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(codeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(codeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// normalizeCode formats a code as entered by a buyer, with or without dashes, like the
+// stored codes
+func normalizeCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != 16 {
+		return code
+	}
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16]
+}
+
+// maskCode hides all but the last four characters of a code in descriptions
+func maskCode(code string) string {
+	if len(code) <= 4 {
+		return code
+	}
+	return "****-" + code[len(code)-4:]
+}
@@ -0,0 +1,411 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/blytz.live.remake/backend/internal/common"
+	"github.com/blytz.live.remake/backend/internal/ledger"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/pkg/logging"
+	"github.com/blytz.live.remake/backend/pkg/money"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Fee rule types
+const (
+	TypePercentage = "percentage"
+	TypeFixed      = "fixed"
+)
+
+// Sale types a rule can be limited to
+const (
+	SaleAny        = "any"
+	SaleAuction    = "auction"
+	SaleFixedPrice = "fixed_price"
+)
+
+// DefaultRuleID identifies the marketplace commission seeded on first start
+var DefaultRuleID = uuid.MustParse("7c1f5d3e-2b8a-4e6f-9a0d-3c5b7e9f1a24")
+
+// ErrInvalidRule is returned for a fee rule whose amounts do not fit its type
+var ErrInvalidRule = errors.New("invalid fee rule")
+
+// Line is an order line fees are charged on
+type Line struct {
+	OrderItemID uuid.UUID
+	SellerID    uuid.UUID
+	CategoryID  uuid.UUID
+	SellerTier  string
+	SaleType    string
+	Total       money.Money
+}
+
+// RuleRequest represents a fee rule to create or update
+type RuleRequest struct {
+	Name        string     `json:"name" binding:"required"`
+	Kind        string     `json:"kind"`
+	Type        string     `json:"type" binding:"required,oneof=percentage fixed"`
+	Percent     float64    `json:"percent" binding:"omitempty,min=0,max=100"`
+	FixedAmount int64      `json:"fixed_amount" binding:"omitempty,min=0"`
+	Currency    string     `json:"currency"`
+	MaxAmount   int64      `json:"max_amount" binding:"omitempty,min=0"`
+	CategoryID  *uuid.UUID `json:"category_id"`
+	SellerTier  *string    `json:"seller_tier"`
+	SaleType    string     `json:"sale_type" binding:"omitempty,oneof=any auction fixed_price"`
+	Priority    int        `json:"priority"`
+	IsActive    *bool      `json:"is_active"`
+}
+
+// Service manages fee rules and charges them on paid orders
+type Service struct {
+	db     *gorm.DB
+	logger *logging.Logger
+	ledger *ledger.Service
+}
+
+// NewService creates a new fee service
+func NewService(db *gorm.DB) *Service {
+	return &Service{
+		db:     db,
+		logger: logging.NewLogger(),
+	}
+}
+
+// SetLedger sets the ledger that charged fees are posted to
+func (s *Service) SetLedger(ledgerService *ledger.Service) {
+	s.ledger = ledgerService
+}
+
+// ListRules lists fee rules, most recently created first
+func (s *Service) ListRules(ctx context.Context, activeOnly bool) ([]models.FeeRule, error) {
+	query := s.db.WithContext(ctx).Order("created_at DESC")
+	if activeOnly {
+		query = query.Where("is_active = ?", true)
+	}
+
+	var rules []models.FeeRule
+	err := query.Find(&rules).Error
+	return rules, err
+}
+
+// CreateRule creates a fee rule
+func (s *Service) CreateRule(ctx context.Context, req RuleRequest) (*models.FeeRule, error) {
+	rule := &models.FeeRule{IsActive: true}
+	if err := applyRuleRequest(rule, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.WithContext(ctx).Create(rule).Error; err != nil {
+		return nil, fmt.Errorf("failed to create fee rule: %w", err)
+	}
+	return rule, nil
+}
+
+// SeedDefaultRule creates the default 10% marketplace commission the first time the
+// marketplace starts and reports whether it did. Once any fee rule has existed, deleted
+// ones included, nothing is seeded, so removing the commission does not bring it back.
+func (s *Service) SeedDefaultRule(ctx context.Context) (bool, error) {
+	var count int64
+	if err := s.db.WithContext(ctx).Unscoped().Model(&models.FeeRule{}).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, nil
+	}
+
+	// The fixed ID stops instances starting together from each seeding a rule
+	rule := models.FeeRule{
+		BaseModel: common.BaseModel{ID: DefaultRuleID},
+		Name:      "Marketplace commission",
+		Kind:      "commission",
+		Type:      TypePercentage,
+		Percent:   10,
+		SaleType:  SaleAny,
+		IsActive:  true,
+	}
+	result := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&rule)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to create default fee rule: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// UpdateRule replaces a fee rule. Fees already charged on orders are not changed.
+func (s *Service) UpdateRule(ctx context.Context, id uuid.UUID, req RuleRequest) (*models.FeeRule, error) {
+	var rule models.FeeRule
+	if err := s.db.WithContext(ctx).First(&rule, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	if err := applyRuleRequest(&rule, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.WithContext(ctx).Save(&rule).Error; err != nil {
+		return nil, fmt.Errorf("failed to update fee rule: %w", err)
+	}
+	return &rule, nil
+}
+
+// DeleteRule deletes a fee rule
+func (s *Service) DeleteRule(ctx context.Context, id uuid.UUID) error {
+	result := s.db.WithContext(ctx).Delete(&models.FeeRule{}, "id = ?", id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// applyRuleRequest copies a request onto a rule and validates it
+func applyRuleRequest(rule *models.FeeRule, req RuleRequest) error {
+	rule.Name = req.Name
+	rule.Kind = req.Kind
+	if rule.Kind == "" {
+		rule.Kind = "commission"
+	}
+	rule.Type = req.Type
+	rule.Percent = req.Percent
+	rule.FixedAmount = req.FixedAmount
+	rule.MaxAmount = req.MaxAmount
+	rule.CategoryID = req.CategoryID
+	rule.SellerTier = req.SellerTier
+	rule.SaleType = req.SaleType
+	if rule.SaleType == "" {
+		rule.SaleType = SaleAny
+	}
+	rule.Priority = req.Priority
+	if req.IsActive != nil {
+		rule.IsActive = *req.IsActive
+	}
+
+	rule.Currency = ""
+	if req.Currency != "" {
+		rule.Currency = money.NormalizeCurrency(req.Currency)
+		if !money.IsSupported(rule.Currency) {
+			return fmt.Errorf("%w: %s", money.ErrUnknownCurrency, rule.Currency)
+		}
+	}
+
+	switch rule.Type {
+	case TypePercentage:
+		if rule.Percent <= 0 {
+			return fmt.Errorf("%w: percentage fees need a percent", ErrInvalidRule)
+		}
+	case TypeFixed:
+		if rule.FixedAmount <= 0 || rule.Currency == "" {
+			return fmt.Errorf("%w: fixed fees need an amount and currency", ErrInvalidRule)
+		}
+		if _, err := money.Parse(fmt.Sprint(rule.FixedAmount), rule.Currency); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRule, err)
+		}
+	}
+	return nil
+}
+
+// Evaluate returns the fees charged on lines under rules. For each line and kind of fee
+// the most specific matching rule applies: one scoped by category, seller tier or sale
+// type beats a general one, and Priority breaks ties.
+func Evaluate(rules []models.FeeRule, lines []Line) []models.OrderFee {
+	var fees []models.OrderFee
+	for _, line := range lines {
+		chosen := make(map[string]*models.FeeRule)
+		var kinds []string
+		for i := range rules {
+			rule := &rules[i]
+			if !matches(rule, line) {
+				continue
+			}
+			current, ok := chosen[rule.Kind]
+			if !ok {
+				kinds = append(kinds, rule.Kind)
+			}
+			if !ok || moreSpecific(rule, current) {
+				chosen[rule.Kind] = rule
+			}
+		}
+		sort.Strings(kinds)
+
+		for _, kind := range kinds {
+			rule := chosen[kind]
+			amount := charge(rule, line.Total)
+			if amount.IsZero() {
+				continue
+			}
+			fees = append(fees, models.OrderFee{
+				OrderItemID: line.OrderItemID,
+				SellerID:    line.SellerID,
+				FeeRuleID:   rule.ID,
+				Kind:        rule.Kind,
+				Description: rule.Name,
+				Amount:      amount.Amount,
+				Currency:    amount.Currency,
+			})
+		}
+	}
+	return fees
+}
+
+// matches reports whether a rule applies to a line
+func matches(rule *models.FeeRule, line Line) bool {
+	if !rule.IsActive {
+		return false
+	}
+	if rule.Currency != "" && rule.Currency != line.Total.Currency {
+		return false
+	}
+	if rule.CategoryID != nil && *rule.CategoryID != line.CategoryID {
+		return false
+	}
+	if rule.SellerTier != nil && *rule.SellerTier != line.SellerTier {
+		return false
+	}
+	return rule.SaleType == "" || rule.SaleType == SaleAny || rule.SaleType == line.SaleType
+}
+
+// moreSpecific reports whether rule a should apply instead of rule b
+func moreSpecific(a, b *models.FeeRule) bool {
+	if sa, sb := specificity(a), specificity(b); sa != sb {
+		return sa > sb
+	}
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	return a.CreatedAt.Before(b.CreatedAt)
+}
+
+func specificity(rule *models.FeeRule) int {
+	n := 0
+	if rule.CategoryID != nil {
+		n++
+	}
+	if rule.SellerTier != nil {
+		n++
+	}
+	if rule.SaleType != "" && rule.SaleType != SaleAny {
+		n++
+	}
+	return n
+}
+
+// charge computes a rule's fee on a line total, capped at the rule's maximum and never
+// more than the line itself
+func charge(rule *models.FeeRule, total money.Money) money.Money {
+	var fee money.Money
+	switch rule.Type {
+	case TypePercentage:
+		fee = total.MulRate(rule.Percent / 100)
+	case TypeFixed:
+		fee = money.New(rule.FixedAmount, total.Currency)
+	default:
+		return money.Zero(total.Currency)
+	}
+
+	if rule.MaxAmount > 0 {
+		if limit := money.New(rule.MaxAmount, total.Currency); fee.Amount > limit.Amount {
+			fee = limit
+		}
+	}
+	if fee.Amount > total.Amount {
+		fee = total
+	}
+	return fee
+}
+
+// AssessOrderFees charges the active fee rules on a paid order, records them as the
+// order's fee lines and posts them to the ledger, all within tx. An order is only
+// charged once.
+func (s *Service) AssessOrderFees(ctx context.Context, tx *gorm.DB, orderID uuid.UUID) ([]models.OrderFee, error) {
+	var existing []models.OrderFee
+	if err := tx.WithContext(ctx).Where("order_id = ?", orderID).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		return existing, nil
+	}
+
+	var order models.Order
+	if err := tx.WithContext(ctx).Preload("Items.Product").First(&order, "id = ?", orderID).Error; err != nil {
+		return nil, fmt.Errorf("order not found: %w", err)
+	}
+
+	lines, err := s.orderLines(ctx, tx, &order)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []models.FeeRule
+	if err := tx.WithContext(ctx).Where("is_active = ?", true).Find(&rules).Error; err != nil {
+		return nil, err
+	}
+
+	fees := Evaluate(rules, lines)
+	for i := range fees {
+		fee := &fees[i]
+		fee.OrderID = orderID
+		if err := tx.WithContext(ctx).Create(fee).Error; err != nil {
+			return nil, fmt.Errorf("failed to record order fee: %w", err)
+		}
+
+		if s.ledger != nil {
+			amount := money.New(fee.Amount, fee.Currency)
+			if _, err := s.ledger.WithTx(tx).PostFee(ctx, "fee:"+fee.ID.String(), fee.SellerID, amount, &orderID, fee.Description); err != nil {
+				return nil, fmt.Errorf("failed to post fee to ledger: %w", err)
+			}
+		}
+	}
+
+	if len(fees) > 0 {
+		s.logger.Info("Order fees assessed", map[string]interface{}{
+			"order_id": orderID,
+			"fees":     len(fees),
+		})
+	}
+
+	return fees, nil
+}
+
+// orderLines describes each of an order's items for rule matching
+func (s *Service) orderLines(ctx context.Context, tx *gorm.DB, order *models.Order) ([]Line, error) {
+	sellerIDs := make([]uuid.UUID, 0, len(order.Items))
+	for _, item := range order.Items {
+		sellerIDs = append(sellerIDs, item.Product.SellerID)
+	}
+
+	var sellers []models.User
+	if err := tx.WithContext(ctx).Where("id IN ?", sellerIDs).Find(&sellers).Error; err != nil {
+		return nil, err
+	}
+	tiers := make(map[uuid.UUID]string, len(sellers))
+	for _, seller := range sellers {
+		tiers[seller.ID] = seller.SellerTier
+	}
+
+	lines := make([]Line, len(order.Items))
+	for i, item := range order.Items {
+		saleType := SaleFixedPrice
+		if item.AuctionID != nil {
+			saleType = SaleAuction
+		}
+		tier := tiers[item.Product.SellerID]
+		if tier == "" {
+			tier = "standard"
+		}
+
+		lines[i] = Line{
+			OrderItemID: item.ID,
+			SellerID:    item.Product.SellerID,
+			CategoryID:  item.Product.CategoryID,
+			SellerTier:  tier,
+			SaleType:    saleType,
+			Total:       money.New(item.Total, order.Currency),
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,220 @@
+package invoices
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Handler provides invoice HTTP handlers
+type Handler struct {
+	service *Service
+}
+
+// NewHandler creates a new invoice handler
+func NewHandler(service *Service) *Handler {
+	return &Handler{
+		service: service,
+	}
+}
+
+// ListMyInvoices lists the invoices and credit notes the current user received as a buyer
+func (h *Handler) ListMyInvoices(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	buyerID := userID.(uuid.UUID)
+	h.list(c, InvoiceFilter{BuyerID: &buyerID, Type: c.Query("type")})
+}
+
+// ListIssuedInvoices lists the invoices and credit notes the current seller issued
+func (h *Handler) ListIssuedInvoices(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	sellerID := userID.(uuid.UUID)
+	h.list(c, InvoiceFilter{SellerID: &sellerID, Type: c.Query("type")})
+}
+
+// ListInvoices lists all invoices and credit notes (admin only)
+func (h *Handler) ListInvoices(c *gin.Context) {
+	// Check if user is admin
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	filter := InvoiceFilter{Type: c.Query("type")}
+	for param, target := range map[string]**uuid.UUID{"seller_id": &filter.SellerID, "buyer_id": &filter.BuyerID, "order_id": &filter.OrderID} {
+		if value := c.Query(param); value != "" {
+			id, err := uuid.Parse(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+				return
+			}
+			*target = &id
+		}
+	}
+	h.list(c, filter)
+}
+
+// GetInvoice returns an invoice or credit note with its lines
+func (h *Handler) GetInvoice(c *gin.Context) {
+	invoice, ok := h.load(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, invoice)
+}
+
+// GetInvoiceHTML returns an invoice or credit note as a printable HTML page
+func (h *Handler) GetInvoiceHTML(c *gin.Context) {
+	h.render(c, "text/html; charset=utf-8", "", WriteHTML)
+}
+
+// GetInvoicePDF returns an invoice or credit note as a PDF download
+func (h *Handler) GetInvoicePDF(c *gin.Context) {
+	h.render(c, "application/pdf", ".pdf", WritePDF)
+}
+
+// GetProfile returns the invoice details of the current user
+func (h *Handler) GetProfile(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	profile, err := h.service.GetProfile(c.Request.Context(), userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// UpdateProfile sets the legal name, tax ID and address on the current user's invoices
+func (h *Handler) UpdateProfile(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req ProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	profile, err := h.service.UpdateProfile(c.Request.Context(), userID.(uuid.UUID), req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// list writes a page of invoices matching filter
+func (h *Handler) list(c *gin.Context, filter InvoiceFilter) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	invoices, total, err := h.service.ListInvoices(c.Request.Context(), filter, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": invoices,
+		"pagination": gin.H{
+			"page":        page,
+			"limit":       limit,
+			"total":       total,
+			"total_pages": (total + int64(limit) - 1) / int64(limit),
+		},
+	})
+}
+
+// load reads the invoice in the path. Only its buyer, its seller and admins can see an
+// invoice; anyone else gets a 404 so invoice IDs reveal nothing.
+func (h *Handler) load(c *gin.Context) (*models.Invoice, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return nil, false
+	}
+
+	invoice, err := h.service.GetInvoice(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	userID, _ := c.Get("user_id")
+	role, _ := c.Get("role")
+	if role != "admin" && userID != invoice.BuyerID && userID != invoice.SellerID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+		return nil, false
+	}
+	return invoice, true
+}
+
+// render writes the invoice in the path with write. A non-empty extension sends it
+// as a download named after the invoice number.
+func (h *Handler) render(c *gin.Context, contentType, extension string,
+	write func(io.Writer, *models.Invoice, *models.Invoice) error) {
+	invoice, ok := h.load(c)
+	if !ok {
+		return
+	}
+
+	// A credit note shows the number of the invoice it corrects
+	var original *models.Invoice
+	if invoice.OriginalInvoiceID != nil {
+		var err error
+		if original, err = h.service.GetInvoice(c.Request.Context(), *invoice.OriginalInvoiceID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var document bytes.Buffer
+	if err := write(&document, invoice, original); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if extension != "" {
+		c.Header("Content-Disposition", `attachment; filename="`+invoice.Number+extension+`"`)
+	}
+	c.Data(http.StatusOK, contentType, document.Bytes())
+}
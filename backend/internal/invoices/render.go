@@ -0,0 +1,320 @@
+package invoices
+
+import (
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/pkg/money"
+)
+
+// vatCountries are the countries whose sales tax is VAT
+var vatCountries = map[string]bool{"GB": true, "DE": true, "FR": true}
+
+// document is an invoice or credit note laid out for rendering, with amounts formatted
+type document struct {
+	Title     string
+	Number    string
+	IssuedAt  string
+	OrderID   string
+	Corrects  string // number of the invoice a credit note corrects
+	Seller    partyView
+	Buyer     partyView
+	ShipTo    []string
+	Currency  string
+	Lines     []lineView
+	Subtotal  string
+	Shipping  string
+	TaxLabel  string
+	Tax       string
+	Total     string
+	Note      string // legal wording the tax treatment requires
+	Statement string
+}
+
+type partyView struct {
+	Name    string
+	Email   string
+	TaxID   string
+	Address []string
+}
+
+type lineView struct {
+	Description string
+	Quantity    string
+	UnitPrice   string
+	Amount      string
+}
+
+// layout prepares an invoice for rendering. original is the invoice a credit note
+// corrects, if known.
+func layout(invoice *models.Invoice, original *models.Invoice) document {
+	format := func(amount int64) string {
+		return money.New(amount, invoice.Currency).Decimal()
+	}
+
+	doc := document{
+		Title:     "Invoice",
+		Number:    invoice.Number,
+		IssuedAt:  invoice.IssuedAt.UTC().Format("2 January 2006"),
+		OrderID:   invoice.OrderID.String(),
+		Seller:    partyView{Name: invoice.SellerName, Email: invoice.SellerEmail, Address: addressLines(invoice.SellerAddress)},
+		Buyer:     partyView{Name: invoice.BuyerName, Email: invoice.BuyerEmail, Address: addressLines(invoice.BillingAddress)},
+		ShipTo:    addressLines(invoice.ShippingAddress),
+		Currency:  invoice.Currency,
+		Subtotal:  format(invoice.Subtotal),
+		Tax:       format(invoice.TaxAmount),
+		Total:     format(invoice.Total),
+		Statement: "Paid in full. This invoice is your receipt.",
+	}
+	if invoice.SellerTaxID != nil {
+		doc.Seller.TaxID = *invoice.SellerTaxID
+	}
+	if invoice.BuyerTaxID != nil {
+		doc.Buyer.TaxID = *invoice.BuyerTaxID
+	}
+	if invoice.ShippingCost != 0 {
+		doc.Shipping = format(invoice.ShippingCost)
+	}
+	if invoice.Type == TypeCreditNote {
+		doc.Title = "Credit note"
+		doc.Statement = "Refunded to the buyer."
+		if original != nil {
+			doc.Corrects = original.Number
+		}
+	}
+
+	doc.TaxLabel = "Tax"
+	if invoice.ShippingAddress != nil && vatCountries[invoice.ShippingAddress.Country] {
+		doc.TaxLabel = "VAT"
+	}
+	if invoice.TaxRate > 0 {
+		doc.TaxLabel += " " + strconv.FormatFloat(invoice.TaxRate*100, 'f', -1, 64) + "%"
+	}
+	if invoice.ReverseCharge {
+		doc.TaxLabel = "VAT 0%"
+		doc.Note = "Reverse charge: VAT to be accounted for by the recipient (Article 196, Council Directive 2006/112/EC)."
+	}
+
+	for _, line := range invoice.Lines {
+		doc.Lines = append(doc.Lines, lineView{
+			Description: line.Description,
+			Quantity:    strconv.Itoa(line.Quantity),
+			UnitPrice:   format(line.UnitPrice),
+			Amount:      format(line.Amount),
+		})
+	}
+	return doc
+}
+
+// addressLines formats an address as it is printed, one line per element
+func addressLines(address *models.Address) []string {
+	if address == nil {
+		return nil
+	}
+	var lines []string
+	add := func(parts ...string) {
+		line := strings.TrimSpace(strings.Join(parts, " "))
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	add(address.FirstName, address.LastName)
+	if address.Company != nil {
+		add(*address.Company)
+	}
+	add(address.AddressLine1)
+	if address.AddressLine2 != nil {
+		add(*address.AddressLine2)
+	}
+	add(address.PostalCode, address.City)
+	add(address.State)
+	add(address.Country)
+	return lines
+}
+
+var htmlTemplate = template.Must(template.New("invoice").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} {{.Number}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; color: #222; max-width: 800px; margin: 40px auto; font-size: 14px; }
+h1 { font-size: 28px; margin: 0 0 4px; }
+.meta { color: #555; margin-bottom: 32px; }
+.parties { display: flex; justify-content: space-between; gap: 24px; margin-bottom: 32px; }
+.parties div { flex: 1; }
+h2 { font-size: 12px; text-transform: uppercase; color: #777; margin: 0 0 6px; }
+table { width: 100%; border-collapse: collapse; }
+th, td { padding: 8px 4px; text-align: left; border-bottom: 1px solid #ddd; }
+.num { text-align: right; white-space: nowrap; }
+.totals td { border: none; padding: 4px; }
+.total td { font-weight: bold; border-top: 2px solid #222; }
+.statement { margin-top: 32px; color: #555; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="meta">
+No. {{.Number}} &middot; Issued {{.IssuedAt}} &middot; Order {{.OrderID}}{{if .Corrects}} &middot; Corrects invoice {{.Corrects}}{{end}}
+</div>
+<div class="parties">
+<div>
+<h2>From</h2>
+<strong>{{.Seller.Name}}</strong><br>
+{{range .Seller.Address}}{{.}}<br>{{end}}
+{{if .Seller.Email}}{{.Seller.Email}}<br>{{end}}
+{{if .Seller.TaxID}}VAT/Tax ID: {{.Seller.TaxID}}{{end}}
+</div>
+<div>
+<h2>Bill to</h2>
+<strong>{{.Buyer.Name}}</strong><br>
+{{range .Buyer.Address}}{{.}}<br>{{end}}
+{{if .Buyer.Email}}{{.Buyer.Email}}<br>{{end}}
+{{if .Buyer.TaxID}}VAT/Tax ID: {{.Buyer.TaxID}}{{end}}
+</div>
+{{if .ShipTo}}<div>
+<h2>Ship to</h2>
+{{range .ShipTo}}{{.}}<br>{{end}}
+</div>{{end}}
+</div>
+<table>
+<thead>
+<tr><th>Description</th><th class="num">Qty</th><th class="num">Unit price</th><th class="num">Amount ({{.Currency}})</th></tr>
+</thead>
+<tbody>
+{{range .Lines}}<tr><td>{{.Description}}</td><td class="num">{{.Quantity}}</td><td class="num">{{.UnitPrice}}</td><td class="num">{{.Amount}}</td></tr>
+{{end}}</tbody>
+</table>
+<table class="totals">
+<tr><td></td><td class="num">Subtotal</td><td class="num">{{.Subtotal}}</td></tr>
+{{if .Shipping}}<tr><td></td><td class="num">Shipping</td><td class="num">{{.Shipping}}</td></tr>
+{{end}}<tr><td></td><td class="num">{{.TaxLabel}}</td><td class="num">{{.Tax}}</td></tr>
+<tr class="total"><td></td><td class="num">Total {{.Currency}}</td><td class="num">{{.Total}}</td></tr>
+</table>
+{{if .Note}}<p class="statement">{{.Note}}</p>
+{{end}}<p class="statement">{{.Statement}}</p>
+</body>
+</html>
+`))
+
+// WriteHTML writes an invoice or credit note as a printable HTML page. original is the
+// invoice a credit note corrects, if known.
+func WriteHTML(w io.Writer, invoice *models.Invoice, original *models.Invoice) error {
+	return htmlTemplate.Execute(w, layout(invoice, original))
+}
+
+// WritePDF writes an invoice or credit note as a PDF document. original is the invoice
+// a credit note corrects, if known.
+func WritePDF(w io.Writer, invoice *models.Invoice, original *models.Invoice) error {
+	doc := layout(invoice, original)
+	pdf := newPDFWriter()
+
+	pdf.text(pdfMargin, doc.Title, 24, true)
+	pdf.advance(22)
+	meta := "No. " + doc.Number + "    Issued " + doc.IssuedAt
+	if doc.Corrects != "" {
+		meta += "    Corrects invoice " + doc.Corrects
+	}
+	pdf.text(pdfMargin, meta, 10, false)
+	pdf.advance(14)
+	pdf.text(pdfMargin, "Order "+doc.OrderID, 10, false)
+	pdf.advance(30)
+
+	// The parties side by side, each as a column of lines
+	columns := []struct {
+		heading string
+		lines   []string
+	}{
+		{"FROM", partyLines(doc.Seller)},
+		{"BILL TO", partyLines(doc.Buyer)},
+	}
+	if len(doc.ShipTo) > 0 {
+		columns = append(columns, struct {
+			heading string
+			lines   []string
+		}{"SHIP TO", doc.ShipTo})
+	}
+	columnWidth := (pdfPageWidth - 2*pdfMargin) / 3
+	rows := 0
+	for i, column := range columns {
+		x := pdfMargin + float64(i)*columnWidth
+		pdf.text(x, column.heading, 8, true)
+		for j, line := range column.lines {
+			pdf.textAt(x, pdf.y-float64(j+1)*13, fit(line, columnWidth-10, 10), 10, j == 0)
+		}
+		if len(column.lines) > rows {
+			rows = len(column.lines)
+		}
+	}
+	pdf.advance(float64(rows+1)*13 + 20)
+
+	// Lines table
+	right := pdfPageWidth - pdfMargin
+	header := func() {
+		pdf.text(pdfMargin, "Description", 9, true)
+		pdf.textRight(right-190, "Qty", 9, true)
+		pdf.textRight(right-100, "Unit price", 9, true)
+		pdf.textRight(right, "Amount ("+doc.Currency+")", 9, true)
+		pdf.advance(6)
+		pdf.rule(pdfMargin, right, 0.8)
+		pdf.advance(16)
+	}
+	header()
+	for _, line := range doc.Lines {
+		if pdf.y < pdfMargin+40 {
+			pdf.newPage()
+			header()
+		}
+		pdf.text(pdfMargin, fit(line.Description, right-pdfMargin-240, 10), 10, false)
+		pdf.textRight(right-190, line.Quantity, 10, false)
+		pdf.textRight(right-100, line.UnitPrice, 10, false)
+		pdf.textRight(right, line.Amount, 10, false)
+		pdf.advance(6)
+		pdf.rule(pdfMargin, right, 0.3)
+		pdf.advance(16)
+	}
+
+	// Totals
+	if pdf.y < pdfMargin+100 {
+		pdf.newPage()
+	}
+	pdf.advance(6)
+	totals := [][2]string{{"Subtotal", doc.Subtotal}}
+	if doc.Shipping != "" {
+		totals = append(totals, [2]string{"Shipping", doc.Shipping})
+	}
+	totals = append(totals, [2]string{doc.TaxLabel, doc.Tax})
+	for _, total := range totals {
+		pdf.textRight(right-100, total[0], 10, false)
+		pdf.textRight(right, total[1], 10, false)
+		pdf.advance(16)
+	}
+	pdf.rule(right-220, right, 1)
+	pdf.advance(16)
+	pdf.textRight(right-100, "Total "+doc.Currency, 11, true)
+	pdf.textRight(right, doc.Total, 11, true)
+	pdf.advance(40)
+	if doc.Note != "" {
+		pdf.text(pdfMargin, fit(doc.Note, right-pdfMargin, 10), 10, false)
+		pdf.advance(16)
+	}
+	pdf.text(pdfMargin, doc.Statement, 10, false)
+
+	_, err := pdf.WriteTo(w)
+	return err
+}
+
+// partyLines lists a party as printed: name, address, email and tax ID
+func partyLines(p partyView) []string {
+	lines := append([]string{p.Name}, p.Address...)
+	if p.Email != "" {
+		lines = append(lines, p.Email)
+	}
+	if p.TaxID != "" {
+		lines = append(lines, "VAT/Tax ID: "+p.TaxID)
+	}
+	return lines
+}
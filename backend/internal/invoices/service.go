@@ -0,0 +1,544 @@
+package invoices
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/internal/orders"
+	"github.com/blytz.live.remake/backend/pkg/logging"
+	"github.com/blytz.live.remake/backend/pkg/money"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Invoice types
+const (
+	TypeInvoice    = "invoice"
+	TypeCreditNote = "credit_note"
+)
+
+// Numbering series; each seller numbers invoices and credit notes separately
+const (
+	seriesInvoice    = "INV"
+	seriesCreditNote = "CN"
+)
+
+// InvoiceFilter narrows a list of invoices
+type InvoiceFilter struct {
+	BuyerID  *uuid.UUID
+	SellerID *uuid.UUID
+	OrderID  *uuid.UUID
+	Type     string
+}
+
+// ProfileRequest represents the invoice details a user wants on their invoices
+type ProfileRequest struct {
+	LegalName string          `json:"legal_name"`
+	TaxID     *string         `json:"tax_id"`
+	Address   *models.Address `json:"address"`
+}
+
+// Service issues invoices for paid orders and credit notes for their refunds
+type Service struct {
+	db     *gorm.DB
+	logger *logging.Logger
+}
+
+// NewService creates a new invoice service
+func NewService(db *gorm.DB) *Service {
+	return &Service{
+		db:     db,
+		logger: logging.NewLogger(),
+	}
+}
+
+// party is who an invoice is from or to, as it reads when the invoice is issued
+type party struct {
+	name    string
+	email   string
+	taxID   *string
+	address *models.Address
+}
+
+// IssueOrderInvoices issues an invoice from each seller of a paid order for their items,
+// with their share of the order's tax and shipping, within the transaction that marks
+// the order paid. An order is invoiced once.
+func (s *Service) IssueOrderInvoices(ctx context.Context, tx *gorm.DB, orderID uuid.UUID) error {
+	tx = tx.WithContext(ctx)
+
+	var issued int64
+	if err := tx.Model(&models.Invoice{}).
+		Where("order_id = ? AND type = ?", orderID, TypeInvoice).
+		Count(&issued).Error; err != nil {
+		return err
+	}
+	if issued > 0 {
+		return nil
+	}
+
+	var order models.Order
+	if err := tx.First(&order, "id = ?", orderID).Error; err != nil {
+		return fmt.Errorf("order not found: %w", err)
+	}
+	var items []models.OrderItem
+	if err := tx.Preload("Product").
+		Where("order_id = ?", orderID).
+		Order("created_at ASC").
+		Find(&items).Error; err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	// Group the items by seller, keeping the order they were bought in
+	var sellers []uuid.UUID
+	bySeller := make(map[uuid.UUID][]models.OrderItem)
+	for _, item := range items {
+		sellerID := item.Product.SellerID
+		if _, ok := bySeller[sellerID]; !ok {
+			sellers = append(sellers, sellerID)
+		}
+		bySeller[sellerID] = append(bySeller[sellerID], item)
+	}
+
+	buyer, err := s.party(tx, order.UserID)
+	if err != nil {
+		return err
+	}
+	shipTo := ""
+	if order.ShippingAddress != nil {
+		shipTo = order.ShippingAddress.Country
+	}
+	sellerParties := make([]party, len(sellers))
+	reverseCharged := make([]bool, len(sellers))
+	for i, sellerID := range sellers {
+		if sellerParties[i], err = s.party(tx, sellerID); err != nil {
+			return err
+		}
+		if sellerParties[i].address != nil {
+			reverseCharged[i] = orders.ReverseCharged(buyer.taxID, shipTo, sellerParties[i].address.Country)
+		}
+	}
+
+	// Shipping is shared in proportion to each seller's items, and tax in proportion to
+	// the items of the sellers that charged it
+	weights := make([]int64, len(sellers))
+	taxWeights := make([]int64, len(sellers))
+	for i, sellerID := range sellers {
+		for _, item := range bySeller[sellerID] {
+			weights[i] += item.Total
+		}
+		if !reverseCharged[i] {
+			taxWeights[i] = weights[i]
+		}
+	}
+	taxShares := money.New(order.TaxAmount, order.Currency).Allocate(taxWeights...)
+	shippingShares := money.New(order.ShippingCost, order.Currency).Allocate(weights...)
+
+	taxRate := 0.0
+	if order.TaxAmount > 0 && shipTo != "" {
+		taxRate = orders.TaxRate(shipTo)
+	}
+	billingAddress := addressOrNil(order.BillingAddress)
+	if billingAddress == nil {
+		billingAddress = buyer.address
+	}
+	if buyer.name == "" && billingAddress != nil {
+		buyer.name = strings.TrimSpace(billingAddress.FirstName + " " + billingAddress.LastName)
+	}
+	if buyer.name == "" {
+		buyer.name = buyer.email
+	}
+
+	now := time.Now()
+	for i, sellerID := range sellers {
+		seller := sellerParties[i]
+		if seller.name == "" {
+			seller.name = seller.email
+		}
+		number, err := s.nextNumber(tx, sellerID, seriesInvoice)
+		if err != nil {
+			return err
+		}
+
+		sellerItems := bySeller[sellerID]
+		lineWeights := make([]int64, len(sellerItems))
+		for j, item := range sellerItems {
+			lineWeights[j] = item.Total
+		}
+		lineTaxes := taxShares[i].Allocate(lineWeights...)
+
+		subtotal := money.New(weights[i], order.Currency)
+		sellerTaxRate := taxRate
+		if reverseCharged[i] {
+			sellerTaxRate = 0
+		}
+		invoice := &models.Invoice{
+			SellerID:        sellerID,
+			Number:          number,
+			Type:            TypeInvoice,
+			BuyerID:         order.UserID,
+			OrderID:         order.ID,
+			Currency:        order.Currency,
+			Subtotal:        subtotal.Amount,
+			TaxRate:         sellerTaxRate,
+			ReverseCharge:   reverseCharged[i],
+			TaxAmount:       taxShares[i].Amount,
+			ShippingCost:    shippingShares[i].Amount,
+			Total:           subtotal.Amount + taxShares[i].Amount + shippingShares[i].Amount,
+			IssuedAt:        now,
+			SellerName:      seller.name,
+			SellerEmail:     seller.email,
+			SellerTaxID:     seller.taxID,
+			SellerAddress:   seller.address,
+			BuyerName:       buyer.name,
+			BuyerEmail:      buyer.email,
+			BuyerTaxID:      buyer.taxID,
+			BillingAddress:  billingAddress,
+			ShippingAddress: addressOrNil(order.ShippingAddress),
+		}
+		for j, item := range sellerItems {
+			orderItemID, productID := item.ID, item.ProductID
+			invoice.Lines = append(invoice.Lines, models.InvoiceLine{
+				OrderItemID: &orderItemID,
+				ProductID:   &productID,
+				Description: item.Product.Title,
+				Quantity:    item.Quantity,
+				UnitPrice:   item.UnitPrice,
+				Amount:      item.Total,
+				TaxAmount:   lineTaxes[j].Amount,
+			})
+		}
+
+		if err := tx.Create(invoice).Error; err != nil {
+			return fmt.Errorf("failed to create invoice: %w", err)
+		}
+
+		s.logger.Info("Invoice issued", map[string]interface{}{
+			"invoice_id": invoice.ID,
+			"number":     invoice.Number,
+			"seller_id":  sellerID,
+			"order_id":   order.ID,
+			"total":      invoice.Total,
+		})
+	}
+	return nil
+}
+
+// IssueCreditNotes issues a credit note against each invoice a refund pays back, within
+// the transaction that records the refund. A refund of an amount pays back the invoice's
+// goods and shipping in proportion; the goods part includes tax at the invoice's rate and
+// shipping, which is not taxed, is credited as is. Orders paid before they were invoiced
+// get no credit notes.
+func (s *Service) IssueCreditNotes(ctx context.Context, tx *gorm.DB, refund *models.Refund) error {
+	if refund.OrderID == nil {
+		return nil
+	}
+	tx = tx.WithContext(ctx)
+
+	var issued int64
+	if err := tx.Model(&models.Invoice{}).
+		Where("refund_id = ? AND type = ?", refund.ID, TypeCreditNote).
+		Count(&issued).Error; err != nil {
+		return err
+	}
+	if issued > 0 {
+		return nil
+	}
+
+	var invoices []models.Invoice
+	if err := tx.Where("order_id = ? AND type = ?", *refund.OrderID, TypeInvoice).
+		Order("created_at ASC").
+		Find(&invoices).Error; err != nil {
+		return err
+	}
+	if len(invoices) == 0 {
+		return nil
+	}
+	currency := invoices[0].Currency
+
+	// The refunded lines of each invoice, with their amounts including tax
+	type refundedLine struct {
+		line  models.InvoiceLine
+		gross money.Money
+	}
+	refunded := make(map[uuid.UUID][]refundedLine, len(invoices))
+	shipping := make(map[uuid.UUID]money.Money, len(invoices))
+	if len(refund.Items) > 0 {
+		bySeller := make(map[uuid.UUID]*models.Invoice, len(invoices))
+		for i := range invoices {
+			bySeller[invoices[i].SellerID] = &invoices[i]
+		}
+		for _, item := range refund.Items {
+			var orderItem models.OrderItem
+			if err := tx.Preload("Product").First(&orderItem, "id = ?", item.OrderItemID).Error; err != nil {
+				return fmt.Errorf("order item not found: %w", err)
+			}
+			invoice, ok := bySeller[orderItem.Product.SellerID]
+			if !ok {
+				continue
+			}
+			orderItemID, productID := orderItem.ID, orderItem.ProductID
+			refunded[invoice.ID] = append(refunded[invoice.ID], refundedLine{
+				line: models.InvoiceLine{
+					OrderItemID: &orderItemID,
+					ProductID:   &productID,
+					Description: orderItem.Product.Title,
+					Quantity:    item.Quantity,
+				},
+				gross: money.New(item.Amount, currency),
+			})
+		}
+	} else {
+		// A refund of an amount is shared in proportion to the invoices' totals
+		weights := make([]int64, len(invoices))
+		for i, invoice := range invoices {
+			weights[i] = invoice.Total
+		}
+		shares := money.New(refund.Amount, currency).Allocate(weights...)
+		for i, invoice := range invoices {
+			parts := shares[i].Allocate(
+				invoice.Subtotal+invoice.TaxAmount,
+				invoice.ShippingCost,
+			)
+			if !parts[0].IsZero() {
+				refunded[invoice.ID] = append(refunded[invoice.ID], refundedLine{
+					line: models.InvoiceLine{
+						Description: fmt.Sprintf("Refund against invoice %s", invoice.Number),
+						Quantity:    1,
+					},
+					gross: parts[0],
+				})
+			}
+			shipping[invoice.ID] = parts[1]
+		}
+	}
+
+	now := time.Now()
+	for i := range invoices {
+		original := &invoices[i]
+		lines := refunded[original.ID]
+		shippingRefunded, ok := shipping[original.ID]
+		if !ok {
+			shippingRefunded = money.Zero(currency)
+		}
+		if len(lines) == 0 && shippingRefunded.IsZero() {
+			continue
+		}
+
+		number, err := s.nextNumber(tx, original.SellerID, seriesCreditNote)
+		if err != nil {
+			return err
+		}
+		originalID, refundID := original.ID, refund.ID
+		creditNote := &models.Invoice{
+			SellerID:          original.SellerID,
+			Number:            number,
+			Type:              TypeCreditNote,
+			BuyerID:           original.BuyerID,
+			OrderID:           original.OrderID,
+			RefundID:          &refundID,
+			OriginalInvoiceID: &originalID,
+			Currency:          currency,
+			TaxRate:           original.TaxRate,
+			ReverseCharge:     original.ReverseCharge,
+			ShippingCost:      shippingRefunded.Amount,
+			IssuedAt:          now,
+			SellerName:        original.SellerName,
+			SellerEmail:       original.SellerEmail,
+			SellerTaxID:       original.SellerTaxID,
+			SellerAddress:     original.SellerAddress,
+			BuyerName:         original.BuyerName,
+			BuyerEmail:        original.BuyerEmail,
+			BuyerTaxID:        original.BuyerTaxID,
+			BillingAddress:    original.BillingAddress,
+			ShippingAddress:   original.ShippingAddress,
+		}
+
+		subtotal, tax := money.Zero(currency), money.Zero(currency)
+		for _, entry := range lines {
+			net := money.New(int64(math.Round(float64(entry.gross.Amount)/(1+original.TaxRate))), currency)
+			lineTax := money.New(entry.gross.Amount-net.Amount, currency)
+			line := entry.line
+			if line.Quantity < 1 {
+				line.Quantity = 1
+			}
+			line.UnitPrice = net.Amount / int64(line.Quantity)
+			line.Amount = net.Amount
+			line.TaxAmount = lineTax.Amount
+			creditNote.Lines = append(creditNote.Lines, line)
+			subtotal.Amount += net.Amount
+			tax.Amount += lineTax.Amount
+		}
+		creditNote.Subtotal = subtotal.Amount
+		creditNote.TaxAmount = tax.Amount
+		creditNote.Total = subtotal.Amount + tax.Amount + shippingRefunded.Amount
+
+		if err := tx.Create(creditNote).Error; err != nil {
+			return fmt.Errorf("failed to create credit note: %w", err)
+		}
+
+		s.logger.Info("Credit note issued", map[string]interface{}{
+			"invoice_id":  creditNote.ID,
+			"number":      creditNote.Number,
+			"original_id": original.ID,
+			"refund_id":   refund.ID,
+			"total":       creditNote.Total,
+		})
+	}
+	return nil
+}
+
+// GetInvoice returns an invoice or credit note with its lines
+func (s *Service) GetInvoice(ctx context.Context, id uuid.UUID) (*models.Invoice, error) {
+	var invoice models.Invoice
+	if err := s.db.WithContext(ctx).
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("created_at ASC") }).
+		First(&invoice, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// ListInvoices lists invoices and credit notes, most recent first
+func (s *Service) ListInvoices(ctx context.Context, filter InvoiceFilter, page, limit int) ([]models.Invoice, int64, error) {
+	query := s.db.WithContext(ctx).Model(&models.Invoice{})
+	if filter.BuyerID != nil {
+		query = query.Where("buyer_id = ?", *filter.BuyerID)
+	}
+	if filter.SellerID != nil {
+		query = query.Where("seller_id = ?", *filter.SellerID)
+	}
+	if filter.OrderID != nil {
+		query = query.Where("order_id = ?", *filter.OrderID)
+	}
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var invoices []models.Invoice
+	err := query.Order("issued_at DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&invoices).Error
+	return invoices, total, err
+}
+
+// GetProfile returns the invoice details of a user, empty when they have set none
+func (s *Service) GetProfile(ctx context.Context, userID uuid.UUID) (*models.InvoiceProfile, error) {
+	var profile models.InvoiceProfile
+	err := s.db.WithContext(ctx).Where("user_id = ?", userID).First(&profile).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.InvoiceProfile{UserID: userID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// UpdateProfile sets the invoice details of a user. Invoices already issued keep the
+// details they were issued with.
+func (s *Service) UpdateProfile(ctx context.Context, userID uuid.UUID, req ProfileRequest) (*models.InvoiceProfile, error) {
+	profile, err := s.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	profile.LegalName = strings.TrimSpace(req.LegalName)
+	profile.TaxID = req.TaxID
+	if profile.TaxID != nil {
+		taxID := strings.ToUpper(strings.ReplaceAll(*profile.TaxID, " ", ""))
+		profile.TaxID = &taxID
+		if taxID == "" {
+			profile.TaxID = nil
+		}
+	}
+	profile.Address = req.Address
+
+	if err := s.db.WithContext(ctx).Save(profile).Error; err != nil {
+		return nil, fmt.Errorf("failed to save invoice profile: %w", err)
+	}
+	return profile, nil
+}
+
+// nextNumber takes the next number in a seller's series. The sequence row is created
+// with ON CONFLICT DO NOTHING, so a concurrent first invoice of the seller does not abort
+// tx, and the increment returns the new number while locking the row until tx ends, so
+// concurrent invoices of a seller get consecutive numbers.
+func (s *Service) nextNumber(tx *gorm.DB, sellerID uuid.UUID, series string) (string, error) {
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "seller_id"}, {Name: "series"}},
+		DoNothing: true,
+	}).Create(&models.InvoiceSequence{SellerID: sellerID, Series: series}).Error; err != nil {
+		return "", fmt.Errorf("failed to create invoice sequence: %w", err)
+	}
+
+	var sequences []models.InvoiceSequence
+	if err := tx.Model(&sequences).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "last_number"}}}).
+		Where("seller_id = ? AND series = ?", sellerID, series).
+		Update("last_number", gorm.Expr("last_number + 1")).Error; err != nil {
+		return "", fmt.Errorf("failed to take invoice number: %w", err)
+	}
+	if len(sequences) != 1 {
+		return "", fmt.Errorf("failed to take invoice number: %d sequences updated", len(sequences))
+	}
+	return fmt.Sprintf("%s-%06d", series, sequences[0].LastNumber), nil
+}
+
+// party reads how a user appears on an invoice: their invoice profile when they have
+// one, otherwise their account. The name is left empty when neither gives one.
+func (s *Service) party(tx *gorm.DB, userID uuid.UUID) (party, error) {
+	var p party
+
+	var user models.User
+	err := tx.First(&user, "id = ?", userID).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return p, err
+	}
+	if err == nil {
+		p.email = user.Email
+		var names []string
+		for _, name := range []*string{user.FirstName, user.LastName} {
+			if name != nil && *name != "" {
+				names = append(names, *name)
+			}
+		}
+		p.name = strings.Join(names, " ")
+	}
+
+	var profile models.InvoiceProfile
+	err = tx.Where("user_id = ?", userID).First(&profile).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return p, err
+	}
+	if err == nil {
+		if profile.LegalName != "" {
+			p.name = profile.LegalName
+		}
+		p.taxID = profile.TaxID
+		p.address = addressOrNil(profile.Address)
+	}
+	return p, nil
+}
+
+// addressOrNil returns nil for an address that was never filled in
+func addressOrNil(address *models.Address) *models.Address {
+	if address == nil || (address.AddressLine1 == "" && address.City == "" && address.Country == "") {
+		return nil
+	}
+	return address
+}
@@ -0,0 +1,92 @@
+package ledger
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// InvariantReport lists every way the ledger fails to balance
+type InvariantReport struct {
+	Transactions int64    `json:"transactions"`
+	Accounts     int64    `json:"accounts"`
+	Violations   []string `json:"violations"`
+}
+
+// OK reports whether the ledger passed every check
+func (r *InvariantReport) OK() bool {
+	return len(r.Violations) == 0
+}
+
+// CheckInvariants verifies that every transaction's entries sum to zero, that each
+// account's cached balance equals the sum of its entries, and that all accounts in a
+// currency sum to zero
+func (s *Service) CheckInvariants(ctx context.Context) (*InvariantReport, error) {
+	db := s.db.WithContext(ctx)
+	report := &InvariantReport{Violations: []string{}}
+
+	if err := db.Table("transactions").Where("deleted_at IS NULL").Count(&report.Transactions).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Table("ledger_accounts").Where("deleted_at IS NULL").Count(&report.Accounts).Error; err != nil {
+		return nil, err
+	}
+
+	var unbalanced []struct {
+		TransactionID uuid.UUID
+		Currency      string
+		Total         int64
+	}
+	if err := db.Table("ledger_entries").
+		Select("transaction_id, currency, SUM(amount) AS total").
+		Where("deleted_at IS NULL").
+		Group("transaction_id, currency").
+		Having("SUM(amount) <> 0").
+		Scan(&unbalanced).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range unbalanced {
+		report.Violations = append(report.Violations,
+			fmt.Sprintf("transaction %s does not balance: entries sum to %d %s", row.TransactionID, row.Total, row.Currency))
+	}
+
+	var drifted []struct {
+		ID      uuid.UUID
+		Code    string
+		Balance int64
+		Total   int64
+	}
+	if err := db.Table("ledger_accounts").
+		Select("ledger_accounts.id, ledger_accounts.code, ledger_accounts.balance, COALESCE(SUM(ledger_entries.amount), 0) AS total").
+		Joins("LEFT JOIN ledger_entries ON ledger_entries.account_id = ledger_accounts.id AND ledger_entries.deleted_at IS NULL").
+		Where("ledger_accounts.deleted_at IS NULL").
+		Group("ledger_accounts.id, ledger_accounts.code, ledger_accounts.balance").
+		Having("ledger_accounts.balance <> COALESCE(SUM(ledger_entries.amount), 0)").
+		Scan(&drifted).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range drifted {
+		report.Violations = append(report.Violations,
+			fmt.Sprintf("account %s (%s) has balance %d but its entries sum to %d", row.Code, row.ID, row.Balance, row.Total))
+	}
+
+	var currencies []struct {
+		Currency string
+		Total    int64
+	}
+	if err := db.Table("ledger_accounts").
+		Select("currency, SUM(balance) AS total").
+		Where("deleted_at IS NULL").
+		Group("currency").
+		Having("SUM(balance) <> 0").
+		Scan(&currencies).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range currencies {
+		report.Violations = append(report.Violations,
+			fmt.Sprintf("%s accounts sum to %d instead of zero", row.Currency, row.Total))
+	}
+
+	return report, nil
+}
@@ -0,0 +1,707 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/pkg/logging"
+	"github.com/blytz.live.remake/backend/pkg/money"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Account types
+const (
+	AccountAsset     = "asset"
+	AccountLiability = "liability"
+	AccountRevenue   = "revenue"
+	AccountExpense   = "expense"
+)
+
+// Transaction types
+const (
+	TypePayment = "payment"
+	TypeRefund  = "refund"
+	TypeFee     = "fee"
+	TypePayout  = "payout"
+
+	TypePayoutReversal = "payout_reversal"
+
+	TypeDispute         = "dispute"
+	TypeDisputeReversal = "dispute_reversal"
+	TypeChargeback      = "chargeback"
+
+	TypeSubscription = "subscription"
+
+	TypeCredit           = "credit"            // a gift card or store credit movement
+	TypeCreditSettlement = "credit_settlement" // credit applied to an order, paid to its sellers
+)
+
+// Parties that can bear a lost dispute
+const (
+	LiabilitySeller   = "seller"
+	LiabilityPlatform = "platform"
+)
+
+// ErrUnbalanced is returned when a posting's debits and credits do not cancel out
+var ErrUnbalanced = errors.New("ledger posting is not balanced")
+
+// Account identifies a ledger account to post to
+type Account struct {
+	Code    string
+	Type    string
+	OwnerID *uuid.UUID
+}
+
+// BuyerAccount holds funds kept for a buyer, e.g. payments made outside an order
+func BuyerAccount(userID uuid.UUID) Account {
+	return Account{Code: "buyer:" + userID.String(), Type: AccountLiability, OwnerID: &userID}
+}
+
+// SellerAccount holds what the platform owes a seller
+func SellerAccount(sellerID uuid.UUID) Account {
+	return Account{Code: "seller:" + sellerID.String(), Type: AccountLiability, OwnerID: &sellerID}
+}
+
+// PlatformFeesAccount collects the platform's commission
+func PlatformFeesAccount() Account {
+	return Account{Code: "platform:fees", Type: AccountRevenue}
+}
+
+// SubscriptionsAccount collects sellers' subscription plan charges
+func SubscriptionsAccount() Account {
+	return Account{Code: "platform:subscriptions", Type: AccountRevenue}
+}
+
+// RefundsAccount records refunds paid out; its balance is what was not recovered from sellers
+func RefundsAccount() Account {
+	return Account{Code: "platform:refunds", Type: AccountExpense}
+}
+
+// DisputesAccount holds funds the gateway withdrew while their disputes are open
+func DisputesAccount() Account {
+	return Account{Code: "platform:disputes", Type: AccountAsset}
+}
+
+// ChargebacksAccount records lost disputes the platform bears
+func ChargebacksAccount() Account {
+	return Account{Code: "platform:chargebacks", Type: AccountExpense}
+}
+
+// StoreCreditAccount holds a user's store credit
+func StoreCreditAccount(userID uuid.UUID) Account {
+	return Account{Code: "store_credit:" + userID.String(), Type: AccountLiability, OwnerID: &userID}
+}
+
+// GiftCardsAccount holds the unspent balances of all gift cards
+func GiftCardsAccount() Account {
+	return Account{Code: "platform:gift_cards", Type: AccountLiability}
+}
+
+// PromotionsAccount records gift cards and store credit the platform gives away
+func PromotionsAccount() Account {
+	return Account{Code: "platform:promotions", Type: AccountExpense}
+}
+
+// BreakageAccount collects gift card balances that expired unspent
+func BreakageAccount() Account {
+	return Account{Code: "platform:breakage", Type: AccountRevenue}
+}
+
+// ProcessorAccount holds the funds settled at a payment gateway
+func ProcessorAccount(gateway string) Account {
+	return Account{Code: "processor:" + gateway, Type: AccountAsset}
+}
+
+// Line is one entry of a posting; debits are positive and credits negative, in minor units
+type Line struct {
+	Account Account
+	Amount  int64
+}
+
+// Posting is a balanced set of lines recorded as one transaction
+type Posting struct {
+	Type        string
+	Reference   string // the source record; a reference is only ever posted once per type
+	Description string
+	Currency    string
+	UserID      uuid.UUID // the user the transaction belongs to; its Balance tracks their account
+	OrderID     *uuid.UUID
+	PaymentID   *uuid.UUID
+	GatewayRef  string
+	GatewayType string
+	Lines       []Line
+}
+
+// Service records balanced postings in the double-entry ledger
+type Service struct {
+	db     *gorm.DB
+	logger *logging.Logger
+}
+
+// NewService creates a new ledger service
+func NewService(db *gorm.DB) *Service {
+	return &Service{
+		db:     db,
+		logger: logging.NewLogger(),
+	}
+}
+
+// WithTx returns a service that posts inside tx, so a posting commits or rolls back
+// with the caller's own changes
+func (s *Service) WithTx(tx *gorm.DB) *Service {
+	return &Service{db: tx, logger: s.logger}
+}
+
+// Post records a posting atomically. Posting the same type and reference again returns
+// the transaction recorded the first time.
+func (s *Service) Post(ctx context.Context, posting Posting) (*models.Transaction, error) {
+	currency := money.NormalizeCurrency(posting.Currency)
+	var sum, debits int64
+	for _, line := range posting.Lines {
+		sum += line.Amount
+		if line.Amount > 0 {
+			debits += line.Amount
+		}
+	}
+	if sum != 0 || len(posting.Lines) < 2 {
+		return nil, fmt.Errorf("%w: %s %s sums to %d", ErrUnbalanced, posting.Type, posting.Reference, sum)
+	}
+
+	var recorded *models.Transaction
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing models.Transaction
+		err := tx.First(&existing, "type = ? AND reference = ?", posting.Type, posting.Reference).Error
+		if err == nil {
+			recorded = &existing
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		now := time.Now()
+		transaction := models.Transaction{
+			Type:        posting.Type,
+			Reference:   posting.Reference,
+			Status:      "completed",
+			Amount:      debits,
+			Currency:    currency,
+			UserID:      posting.UserID,
+			OrderID:     posting.OrderID,
+			PaymentID:   posting.PaymentID,
+			GatewayRef:  posting.GatewayRef,
+			GatewayType: posting.GatewayType,
+			Description: posting.Description,
+			Metadata:    "{}",
+			ProcessedAt: &now,
+		}
+		if err := tx.Create(&transaction).Error; err != nil {
+			return fmt.Errorf("failed to create ledger transaction: %w", err)
+		}
+
+		var userBalance *int64
+		for _, line := range posting.Lines {
+			account, err := s.account(tx, line.Account, currency)
+			if err != nil {
+				return err
+			}
+
+			entry := models.LedgerEntry{
+				TransactionID: transaction.ID,
+				AccountID:     account.ID,
+				Amount:        line.Amount,
+				Currency:      currency,
+			}
+			if err := tx.Create(&entry).Error; err != nil {
+				return fmt.Errorf("failed to create ledger entry: %w", err)
+			}
+
+			if err := tx.Model(&models.LedgerAccount{}).
+				Where("id = ?", account.ID).
+				Update("balance", gorm.Expr("balance + ?", line.Amount)).Error; err != nil {
+				return fmt.Errorf("failed to update ledger account: %w", err)
+			}
+
+			if account.OwnerID != nil && *account.OwnerID == posting.UserID {
+				balance := normalBalance(account.Type, account.Balance+line.Amount)
+				userBalance = &balance
+			}
+		}
+
+		// The running balance is the user's own account after this posting
+		if userBalance != nil {
+			transaction.Balance = *userBalance
+			if err := tx.Model(&transaction).Update("balance", transaction.Balance).Error; err != nil {
+				return err
+			}
+		}
+
+		recorded = &transaction
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return recorded, nil
+}
+
+// PostPayment credits a completed payment to the sellers of its order, split by their
+// share of the order's items. Payments outside an order are held for the buyer.
+func (s *Service) PostPayment(ctx context.Context, payment *models.Payment) (*models.Transaction, error) {
+	amount := money.New(payment.Amount, payment.Currency)
+	lines := []Line{{Account: ProcessorAccount(payment.GatewayType), Amount: amount.Amount}}
+
+	credits, err := s.payees(ctx, payment, amount)
+	if err != nil {
+		return nil, err
+	}
+	for _, credit := range credits {
+		lines = append(lines, Line{Account: credit.Account, Amount: -credit.Amount})
+	}
+
+	return s.Post(ctx, Posting{
+		Type:        TypePayment,
+		Reference:   payment.ID.String(),
+		Description: fmt.Sprintf("Payment %s", amount),
+		Currency:    amount.Currency,
+		UserID:      payment.UserID,
+		OrderID:     payment.OrderID,
+		PaymentID:   &payment.ID,
+		GatewayRef:  payment.GatewayRef,
+		GatewayType: payment.GatewayType,
+		Lines:       lines,
+	})
+}
+
+// PostRefund records a refund paid back through the gateway and, for its credit amount,
+// to the buyer's store credit, and recovers it from the sellers of the refunded items, or
+// from every account the payment was credited to in the same proportions when the refund
+// is not for specific items
+func (s *Service) PostRefund(ctx context.Context, refund *models.Refund, payment *models.Payment) (*models.Transaction, error) {
+	amount := money.New(refund.Amount, payment.Currency)
+	// Store credit stays with the platform as the buyer's credit
+	credit := money.New(refund.CreditAmount, payment.Currency)
+	lines := []Line{{Account: RefundsAccount(), Amount: amount.Amount}}
+	if card := amount.Amount - credit.Amount; card > 0 {
+		lines = append(lines, Line{Account: ProcessorAccount(payment.GatewayType), Amount: -card})
+	}
+	if credit.Amount > 0 {
+		lines = append(lines, Line{Account: StoreCreditAccount(payment.UserID), Amount: -credit.Amount})
+	}
+
+	shares, err := RefundShares(s.db.WithContext(ctx), refund, payment)
+	if err != nil {
+		return nil, err
+	}
+	debits := make([]Line, 0, len(shares))
+	for _, share := range shares {
+		if !share.Amount.IsZero() {
+			debits = append(debits, Line{Account: SellerAccount(share.SellerID), Amount: share.Amount.Amount})
+		}
+	}
+	if len(debits) == 0 {
+		debits = []Line{{Account: BuyerAccount(payment.UserID), Amount: amount.Amount}}
+	}
+	for _, debit := range debits {
+		lines = append(lines,
+			Line{Account: debit.Account, Amount: debit.Amount},
+			Line{Account: RefundsAccount(), Amount: -debit.Amount},
+		)
+	}
+
+	return s.Post(ctx, Posting{
+		Type:        TypeRefund,
+		Reference:   refund.ID.String(),
+		Description: fmt.Sprintf("Refund %s (%s)", amount, refund.Reason),
+		Currency:    amount.Currency,
+		UserID:      payment.UserID,
+		OrderID:     payment.OrderID,
+		PaymentID:   &payment.ID,
+		GatewayRef:  refund.GatewayRef,
+		GatewayType: refund.GatewayType,
+		Lines:       lines,
+	})
+}
+
+// PostFee charges a platform fee to a seller's account
+func (s *Service) PostFee(ctx context.Context, reference string, sellerID uuid.UUID, fee money.Money, orderID *uuid.UUID, description string) (*models.Transaction, error) {
+	return s.Post(ctx, Posting{
+		Type:        TypeFee,
+		Reference:   reference,
+		Description: description,
+		Currency:    fee.Currency,
+		UserID:      sellerID,
+		OrderID:     orderID,
+		GatewayType: "ledger",
+		Lines: []Line{
+			{Account: SellerAccount(sellerID), Amount: fee.Amount},
+			{Account: PlatformFeesAccount(), Amount: -fee.Amount},
+		},
+	})
+}
+
+// PostSubscription recognizes a subscription charge, which the payment credited to the
+// seller's own account, as platform revenue
+func (s *Service) PostSubscription(ctx context.Context, payment *models.Payment, description string) (*models.Transaction, error) {
+	amount := money.New(payment.Amount, payment.Currency)
+	return s.Post(ctx, Posting{
+		Type:        TypeSubscription,
+		Reference:   payment.ID.String(),
+		Description: description,
+		Currency:    amount.Currency,
+		UserID:      payment.UserID,
+		PaymentID:   &payment.ID,
+		GatewayRef:  payment.GatewayRef,
+		GatewayType: payment.GatewayType,
+		Lines: []Line{
+			{Account: BuyerAccount(payment.UserID), Amount: amount.Amount},
+			{Account: SubscriptionsAccount(), Amount: -amount.Amount},
+		},
+	})
+}
+
+// PostCredit records a gift card or store credit movement, debiting from and crediting to
+// with the movement's amount
+func (s *Service) PostCredit(ctx context.Context, movement *models.CreditMovement, from, to Account) (*models.Transaction, error) {
+	amount := money.New(movement.Amount, movement.Currency)
+	if amount.Amount < 0 {
+		amount.Amount = -amount.Amount
+	}
+	return s.Post(ctx, Posting{
+		Type:        TypeCredit,
+		Reference:   movement.ID.String(),
+		Description: movement.Description,
+		Currency:    amount.Currency,
+		UserID:      movement.UserID,
+		OrderID:     movement.OrderID,
+		GatewayType: "ledger",
+		Lines: []Line{
+			{Account: from, Amount: amount.Amount},
+			{Account: to, Amount: -amount.Amount},
+		},
+	})
+}
+
+// PostCreditSettlement pays the gift cards and store credit a buyer applied to an order,
+// which redemption held in their account, to the order's sellers
+func (s *Service) PostCreditSettlement(ctx context.Context, orderID, buyerID uuid.UUID, amount money.Money) (*models.Transaction, error) {
+	shares, err := SellerShares(s.db.WithContext(ctx), orderID, amount)
+	if err != nil {
+		return nil, err
+	}
+	lines := []Line{{Account: BuyerAccount(buyerID), Amount: amount.Amount}}
+	credited := false
+	for _, share := range shares {
+		if share.Amount.IsZero() {
+			continue
+		}
+		lines = append(lines, Line{Account: SellerAccount(share.SellerID), Amount: -share.Amount.Amount})
+		credited = true
+	}
+	if !credited {
+		return nil, fmt.Errorf("order %s has no sellers to settle credit to", orderID)
+	}
+
+	return s.Post(ctx, Posting{
+		Type:        TypeCreditSettlement,
+		Reference:   orderID.String(),
+		Description: fmt.Sprintf("Credit %s applied to order", amount),
+		Currency:    amount.Currency,
+		UserID:      buyerID,
+		OrderID:     &orderID,
+		GatewayType: "ledger",
+		Lines:       lines,
+	})
+}
+
+// PostPayout records funds transferred out of the gateway to a seller
+func (s *Service) PostPayout(ctx context.Context, payout *models.Payout) (*models.Transaction, error) {
+	amount := money.New(payout.NetAmount, payout.Currency)
+	return s.Post(ctx, Posting{
+		Type:        TypePayout,
+		Reference:   payout.ID.String(),
+		Description: fmt.Sprintf("Payout %s via %s", amount, payout.Method),
+		Currency:    amount.Currency,
+		UserID:      payout.SellerID,
+		GatewayRef:  payout.TransferRef,
+		GatewayType: payout.GatewayType,
+		Lines: []Line{
+			{Account: SellerAccount(payout.SellerID), Amount: amount.Amount},
+			{Account: ProcessorAccount(payout.GatewayType), Amount: -amount.Amount},
+		},
+	})
+}
+
+// PostDispute records the disputed funds the gateway withdraws when a dispute opens
+func (s *Service) PostDispute(ctx context.Context, dispute *models.Dispute, payment *models.Payment) (*models.Transaction, error) {
+	amount := money.New(dispute.Amount, dispute.Currency)
+	return s.Post(ctx, Posting{
+		Type:        TypeDispute,
+		Reference:   dispute.ID.String(),
+		Description: fmt.Sprintf("Dispute of %s opened (%s)", amount, dispute.Reason),
+		Currency:    amount.Currency,
+		UserID:      payment.UserID,
+		OrderID:     payment.OrderID,
+		PaymentID:   &payment.ID,
+		GatewayRef:  dispute.GatewayRef,
+		GatewayType: dispute.GatewayType,
+		Lines: []Line{
+			{Account: DisputesAccount(), Amount: amount.Amount},
+			{Account: ProcessorAccount(dispute.GatewayType), Amount: -amount.Amount},
+		},
+	})
+}
+
+// PostDisputeOutcome settles the funds held for a closed dispute. A won dispute returns
+// them to the processor; a lost one is charged to the sellers of the order, in the same
+// proportions as the payment, or to the platform.
+func (s *Service) PostDisputeOutcome(ctx context.Context, dispute *models.Dispute, payment *models.Payment) (*models.Transaction, error) {
+	amount := money.New(dispute.Amount, dispute.Currency)
+	posting := Posting{
+		Reference:   dispute.ID.String(),
+		Currency:    amount.Currency,
+		UserID:      payment.UserID,
+		OrderID:     payment.OrderID,
+		PaymentID:   &payment.ID,
+		GatewayRef:  dispute.GatewayRef,
+		GatewayType: dispute.GatewayType,
+	}
+
+	if dispute.Status == "won" {
+		posting.Type = TypeDisputeReversal
+		posting.Description = fmt.Sprintf("Dispute of %s won", amount)
+		posting.Lines = []Line{
+			{Account: ProcessorAccount(dispute.GatewayType), Amount: amount.Amount},
+			{Account: DisputesAccount(), Amount: -amount.Amount},
+		}
+		return s.Post(ctx, posting)
+	}
+
+	posting.Type = TypeChargeback
+	posting.Description = fmt.Sprintf("Dispute of %s lost", amount)
+	var debits []Line
+	if dispute.Liability != nil && *dispute.Liability == LiabilitySeller && payment.OrderID != nil {
+		shares, err := SellerShares(s.db.WithContext(ctx), *payment.OrderID, amount)
+		if err != nil {
+			return nil, err
+		}
+		for _, share := range shares {
+			if !share.Amount.IsZero() {
+				debits = append(debits, Line{Account: SellerAccount(share.SellerID), Amount: share.Amount.Amount})
+			}
+		}
+	}
+	if len(debits) == 0 {
+		debits = []Line{{Account: ChargebacksAccount(), Amount: amount.Amount}}
+	}
+	posting.Lines = append(debits, Line{Account: DisputesAccount(), Amount: -amount.Amount})
+	return s.Post(ctx, posting)
+}
+
+// Balance returns an account's balance in its normal sign, e.g. what a seller is owed
+func (s *Service) Balance(ctx context.Context, account Account, currency string) (money.Money, error) {
+	currency = money.NormalizeCurrency(currency)
+
+	var stored models.LedgerAccount
+	err := s.db.WithContext(ctx).First(&stored, "code = ? AND currency = ?", account.Code, currency).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return money.Zero(currency), nil
+	}
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	return money.New(normalBalance(stored.Type, stored.Balance), currency), nil
+}
+
+// ListAccounts lists ledger accounts, optionally only those owned by ownerID
+func (s *Service) ListAccounts(ctx context.Context, ownerID *uuid.UUID) ([]models.LedgerAccount, error) {
+	query := s.db.WithContext(ctx).Order("code ASC, currency ASC")
+	if ownerID != nil {
+		query = query.Where("owner_id = ?", *ownerID)
+	}
+
+	var accounts []models.LedgerAccount
+	err := query.Find(&accounts).Error
+	return accounts, err
+}
+
+// ListEntries lists an account's entries, newest first
+func (s *Service) ListEntries(ctx context.Context, accountID uuid.UUID, page, limit int) ([]models.LedgerEntry, int64, error) {
+	query := s.db.WithContext(ctx).Model(&models.LedgerEntry{}).Where("account_id = ?", accountID)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var entries []models.LedgerEntry
+	err := query.Order("created_at DESC").Limit(limit).Offset((page - 1) * limit).Find(&entries).Error
+	return entries, total, err
+}
+
+// account returns the stored account for a code and currency, creating it on first use
+func (s *Service) account(tx *gorm.DB, account Account, currency string) (*models.LedgerAccount, error) {
+	stored := models.LedgerAccount{
+		Code:     account.Code,
+		Currency: currency,
+		Type:     account.Type,
+		OwnerID:  account.OwnerID,
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&stored).Error; err != nil {
+		return nil, fmt.Errorf("failed to create ledger account: %w", err)
+	}
+
+	// Load into a fresh value; the generated ID above was not inserted if the account existed
+	var existing models.LedgerAccount
+	if err := tx.First(&existing, "code = ? AND currency = ?", account.Code, currency).Error; err != nil {
+		return nil, fmt.Errorf("failed to load ledger account: %w", err)
+	}
+	return &existing, nil
+}
+
+// payees splits amount between the accounts a payment is credited to: the sellers of
+// its order's items, or the buyer without an order
+func (s *Service) payees(ctx context.Context, payment *models.Payment, amount money.Money) ([]Line, error) {
+	if payment.OrderID == nil {
+		return []Line{{Account: BuyerAccount(payment.UserID), Amount: amount.Amount}}, nil
+	}
+
+	shares, err := SellerShares(s.db.WithContext(ctx), *payment.OrderID, amount)
+	if err != nil {
+		return nil, err
+	}
+	if len(shares) == 0 {
+		return []Line{{Account: BuyerAccount(payment.UserID), Amount: amount.Amount}}, nil
+	}
+
+	lines := make([]Line, 0, len(shares))
+	for _, share := range shares {
+		if share.Amount.IsZero() {
+			continue
+		}
+		lines = append(lines, Line{Account: SellerAccount(share.SellerID), Amount: share.Amount.Amount})
+	}
+	return lines, nil
+}
+
+// Share is a seller's part of an amount paid for an order
+type Share struct {
+	SellerID uuid.UUID
+	Amount   money.Money
+}
+
+// SellerShares splits amount between the sellers of an order's items in proportion to
+// their item totals. Payouts use the same split, so what a seller is paid out matches
+// what the ledger credited them.
+func SellerShares(db *gorm.DB, orderID uuid.UUID, amount money.Money) ([]Share, error) {
+	var totals []struct {
+		SellerID uuid.UUID
+		Total    int64
+	}
+	err := db.Table("order_items").
+		Select("products.seller_id AS seller_id, SUM(order_items.total) AS total").
+		Joins("JOIN products ON products.id = order_items.product_id").
+		Where("order_items.order_id = ? AND order_items.deleted_at IS NULL", orderID).
+		Group("products.seller_id").
+		Order("products.seller_id").
+		Scan(&totals).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load order sellers: %w", err)
+	}
+
+	weights := make([]int64, len(totals))
+	for i, total := range totals {
+		weights[i] = total.Total
+	}
+
+	shares := make([]Share, len(totals))
+	for i, part := range amount.Allocate(weights...) {
+		shares[i] = Share{SellerID: totals[i].SellerID, Amount: part}
+	}
+	return shares, nil
+}
+
+// AccountBalance is a ledger account with its balance in the account type's normal sign
+type AccountBalance struct {
+	models.LedgerAccount
+	Amount money.Money `json:"amount"`
+}
+
+// accountBalances pairs each account with its normal-sign balance
+func accountBalances(accounts []models.LedgerAccount) []AccountBalance {
+	balances := make([]AccountBalance, len(accounts))
+	for i, account := range accounts {
+		balances[i] = AccountBalance{
+			LedgerAccount: account,
+			Amount:        money.New(normalBalance(account.Type, account.Balance), account.Currency),
+		}
+	}
+	return balances
+}
+
+// RefundShares splits a refund between the sellers who bear it: the sellers of the
+// refunded items, or every seller of the order in proportion to their items when the
+// refund is not for specific items. Payments without an order have no shares.
+func RefundShares(db *gorm.DB, refund *models.Refund, payment *models.Payment) ([]Share, error) {
+	amount := money.New(refund.Amount, payment.Currency)
+	if len(refund.Items) == 0 {
+		if payment.OrderID == nil {
+			return nil, nil
+		}
+		return SellerShares(db, *payment.OrderID, amount)
+	}
+
+	itemIDs := make([]uuid.UUID, len(refund.Items))
+	for i, item := range refund.Items {
+		itemIDs[i] = item.OrderItemID
+	}
+	var sellers []struct {
+		ID       uuid.UUID
+		SellerID uuid.UUID
+	}
+	if err := db.Table("order_items").
+		Select("order_items.id AS id, products.seller_id AS seller_id").
+		Joins("JOIN products ON products.id = order_items.product_id").
+		Where("order_items.id IN ?", itemIDs).
+		Scan(&sellers).Error; err != nil {
+		return nil, fmt.Errorf("failed to load refunded item sellers: %w", err)
+	}
+	sellerOf := make(map[uuid.UUID]uuid.UUID, len(sellers))
+	for _, row := range sellers {
+		sellerOf[row.ID] = row.SellerID
+	}
+
+	totals := make(map[uuid.UUID]int64)
+	var order []uuid.UUID
+	for _, item := range refund.Items {
+		sellerID, ok := sellerOf[item.OrderItemID]
+		if !ok {
+			return nil, fmt.Errorf("refunded order item %s not found", item.OrderItemID)
+		}
+		if _, seen := totals[sellerID]; !seen {
+			order = append(order, sellerID)
+		}
+		totals[sellerID] += item.Amount
+	}
+
+	shares := make([]Share, len(order))
+	for i, sellerID := range order {
+		shares[i] = Share{SellerID: sellerID, Amount: money.New(totals[sellerID], amount.Currency)}
+	}
+	return shares, nil
+}
+
+// normalBalance returns a debit-positive balance in the account type's normal sign
+func normalBalance(accountType string, balance int64) int64 {
+	if accountType == AccountLiability || accountType == AccountRevenue {
+		return -balance
+	}
+	return balance
+}
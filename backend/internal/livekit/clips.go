@@ -0,0 +1,276 @@
+package livekit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Clip length limits
+const (
+	MinClipLength = 3 * time.Second
+	MaxClipLength = 2 * time.Minute
+)
+
+// Automatic lot clips cover the run-up to the winning bid and the moment after it
+const (
+	lotClipLead  = 30 * time.Second
+	lotClipTrail = 10 * time.Second
+)
+
+// ErrInvalidClipRange is returned when a clip range is empty, too short or too long
+var ErrInvalidClipRange = errors.New("invalid clip time range")
+
+// ErrNoRecordingForClip is returned when no finished recording covers a clip range
+var ErrNoRecordingForClip = errors.New("no finished recording covers the requested time range")
+
+// ErrLotNotSold is returned when creating an automatic clip for an auction without a winner
+var ErrLotNotSold = errors.New("auction has no winning bid")
+
+// CreateClip cuts a clip out of the auction's recordings by wall clock time range
+func (s *Service) CreateClip(ctx context.Context, sellerID, auctionID uuid.UUID, title string, start, end time.Time) (*models.HighlightClip, error) {
+	length := end.Sub(start)
+	if length < MinClipLength || length > MaxClipLength {
+		return nil, ErrInvalidClipRange
+	}
+
+	auction, err := s.clipAuction(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+	if auction.SellerID != sellerID {
+		return nil, ErrAuctionNotOwned
+	}
+
+	recording, err := s.recordingCovering(ctx, auctionID, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	if title == "" {
+		title = auction.Title
+	}
+	return s.saveClip(ctx, auction, recording, title, "manual", start, end)
+}
+
+// CreateLotClip creates the automatic clip of a sold lot around its winning bid. An
+// existing automatic clip is returned instead of creating another.
+func (s *Service) CreateLotClip(ctx context.Context, auctionID uuid.UUID) (*models.HighlightClip, error) {
+	var existing models.HighlightClip
+	err := s.db.WithContext(ctx).First(&existing, "auction_id = ? AND source = ?", auctionID, "auto").Error
+	if err == nil {
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	auction, err := s.clipAuction(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var winning models.Bid
+	err = s.db.WithContext(ctx).
+		Where("auction_id = ? AND is_winning = ?", auctionID, true).
+		Order("bid_time DESC").
+		First(&winning).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrLotNotSold
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the recording that was running when the lot sold and fit the clip into it
+	var recording models.StreamRecording
+	err = s.db.WithContext(ctx).
+		Where("auction_id = ? AND status = ? AND started_at <= ?", auctionID, "ready", winning.BidTime).
+		Order("started_at DESC").
+		First(&recording).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNoRecordingForClip
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	recordingEnd := recording.StartedAt.Add(time.Duration(recording.Duration) * time.Second)
+	if winning.BidTime.After(recordingEnd) {
+		return nil, ErrNoRecordingForClip
+	}
+
+	start := winning.BidTime.Add(-lotClipLead)
+	if start.Before(recording.StartedAt) {
+		start = recording.StartedAt
+	}
+	end := winning.BidTime.Add(lotClipTrail)
+	if end.After(recordingEnd) {
+		end = recordingEnd
+	}
+	if end.Sub(start) < MinClipLength {
+		return nil, ErrNoRecordingForClip
+	}
+
+	return s.saveClip(ctx, auction, &recording, auction.Title+" - sold", "auto", start, end)
+}
+
+// CreateSellerLotClip creates the automatic lot clip on behalf of the auction's seller
+func (s *Service) CreateSellerLotClip(ctx context.Context, sellerID, auctionID uuid.UUID) (*models.HighlightClip, error) {
+	if _, err := s.hostedAuction(ctx, auctionID, sellerID); err != nil {
+		return nil, err
+	}
+	return s.CreateLotClip(ctx, auctionID)
+}
+
+// ListAuctionClips returns the clips of an auction in stream order
+func (s *Service) ListAuctionClips(ctx context.Context, auctionID uuid.UUID, publishedOnly bool) ([]models.HighlightClip, error) {
+	query := s.db.WithContext(ctx).Where("auction_id = ?", auctionID)
+	if publishedOnly {
+		query = query.Where("is_published = ?", true)
+	}
+
+	var clips []models.HighlightClip
+	err := query.Order("start_time ASC").Find(&clips).Error
+	return clips, err
+}
+
+// SetClipPublished shows or hides a clip on the product page
+func (s *Service) SetClipPublished(ctx context.Context, sellerID, clipID uuid.UUID, published bool) (*models.HighlightClip, error) {
+	clip, err := s.sellerClip(ctx, sellerID, clipID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.WithContext(ctx).Model(clip).Update("is_published", published).Error; err != nil {
+		return nil, err
+	}
+	clip.IsPublished = published
+	return clip, nil
+}
+
+// FeatureClip makes a clip the product's main video
+func (s *Service) FeatureClip(ctx context.Context, sellerID, clipID uuid.UUID) error {
+	clip, err := s.sellerClip(ctx, sellerID, clipID)
+	if err != nil {
+		return err
+	}
+
+	return s.db.WithContext(ctx).
+		Model(&models.Product{}).
+		Where("id = ?", clip.ProductID).
+		Update("video_url", clip.PlaybackURL).Error
+}
+
+// DeleteClip removes a clip; the recording is kept
+func (s *Service) DeleteClip(ctx context.Context, sellerID, clipID uuid.UUID) error {
+	clip, err := s.sellerClip(ctx, sellerID, clipID)
+	if err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Delete(clip).Error
+}
+
+// createLotClipAfterRecording creates the automatic lot clip once a recording of a
+// sold auction is ready. Failures are logged; the clip can be created by hand later.
+func (s *Service) createLotClipAfterRecording(ctx context.Context, auctionID uuid.UUID) {
+	_, err := s.CreateLotClip(ctx, auctionID)
+	if err != nil && !errors.Is(err, ErrLotNotSold) && !errors.Is(err, ErrNoRecordingForClip) {
+		s.logger.Warn("Failed to create lot clip", map[string]interface{}{
+			"auction_id": auctionID,
+			"error":      err.Error(),
+		})
+	}
+}
+
+// saveClip stores a clip of recording between start and end
+func (s *Service) saveClip(ctx context.Context, auction *models.Auction, recording *models.StreamRecording, title, source string, start, end time.Time) (*models.HighlightClip, error) {
+	if recording.PlaybackURL == nil {
+		return nil, ErrNoRecordingForClip
+	}
+
+	// Offsets are kept to a tenth of a second, which is what players seek to anyway
+	startOffset := math.Round(start.Sub(recording.StartedAt).Seconds()*10) / 10
+	endOffset := math.Round(end.Sub(recording.StartedAt).Seconds()*10) / 10
+
+	clip := &models.HighlightClip{
+		AuctionID:   auction.ID,
+		ProductID:   auction.ProductID,
+		SellerID:    auction.SellerID,
+		RecordingID: recording.ID,
+		Title:       title,
+		Source:      source,
+		StartOffset: startOffset,
+		EndOffset:   endOffset,
+		Duration:    math.Round((endOffset-startOffset)*10) / 10,
+		PlaybackURL: mediaFragmentURL(*recording.PlaybackURL, startOffset, endOffset),
+		IsPublished: true,
+		StartTime:   start,
+	}
+	if err := s.db.WithContext(ctx).Create(clip).Error; err != nil {
+		return nil, fmt.Errorf("failed to save clip: %w", err)
+	}
+
+	s.logger.Info("Highlight clip created", map[string]interface{}{
+		"auction_id": auction.ID,
+		"clip_id":    clip.ID,
+		"source":     source,
+		"duration":   clip.Duration,
+	})
+
+	return clip, nil
+}
+
+// recordingCovering finds the finished recording that contains the whole time range
+func (s *Service) recordingCovering(ctx context.Context, auctionID uuid.UUID, start, end time.Time) (*models.StreamRecording, error) {
+	var recordings []models.StreamRecording
+	if err := s.db.WithContext(ctx).
+		Where("auction_id = ? AND status = ? AND started_at <= ?", auctionID, "ready", start).
+		Order("started_at DESC").
+		Find(&recordings).Error; err != nil {
+		return nil, err
+	}
+
+	for i := range recordings {
+		recordingEnd := recordings[i].StartedAt.Add(time.Duration(recordings[i].Duration) * time.Second)
+		if !end.After(recordingEnd) {
+			return &recordings[i], nil
+		}
+	}
+	return nil, ErrNoRecordingForClip
+}
+
+// clipAuction loads the auction fields a clip needs
+func (s *Service) clipAuction(ctx context.Context, auctionID uuid.UUID) (*models.Auction, error) {
+	var auction models.Auction
+	if err := s.db.WithContext(ctx).
+		Select("id", "product_id", "seller_id", "title").
+		First(&auction, "id = ?", auctionID).Error; err != nil {
+		return nil, err
+	}
+	return &auction, nil
+}
+
+// sellerClip loads a clip owned by the seller
+func (s *Service) sellerClip(ctx context.Context, sellerID, clipID uuid.UUID) (*models.HighlightClip, error) {
+	var clip models.HighlightClip
+	if err := s.db.WithContext(ctx).First(&clip, "id = ? AND seller_id = ?", clipID, sellerID).Error; err != nil {
+		return nil, err
+	}
+	return &clip, nil
+}
+
+// mediaFragmentURL limits playback of a recording to [start, end] seconds using a
+// W3C media fragment, which browsers honour for MP4 without server-side cutting
+func mediaFragmentURL(playbackURL string, start, end float64) string {
+	return fmt.Sprintf("%s#t=%s,%s", playbackURL,
+		strconv.FormatFloat(start, 'f', -1, 64),
+		strconv.FormatFloat(end, 'f', -1, 64))
+}
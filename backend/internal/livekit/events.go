@@ -0,0 +1,85 @@
+package livekit
+
+import "time"
+
+// Stream event types delivered by the provider webhook
+const (
+	EventRoomStarted       = "room_started"
+	EventRoomFinished      = "room_finished"
+	EventParticipantJoined = "participant_joined"
+	EventParticipantLeft   = "participant_left"
+	EventTrackPublished    = "track_published"
+	EventEgressStarted     = "egress_started"
+	EventEgressUpdated     = "egress_updated"
+	EventEgressEnded       = "egress_ended"
+	EventIngressStarted    = "ingress_started"
+	EventIngressEnded      = "ingress_ended"
+)
+
+// Egress statuses reported with egress events
+const (
+	EgressStarting = "starting"
+	EgressActive   = "active"
+	EgressComplete = "complete"
+	EgressFailed   = "failed"
+	EgressAborted  = "aborted"
+)
+
+// Ingress statuses reported with ingress events
+const (
+	IngressInactive   = "inactive"
+	IngressBuffering  = "buffering"
+	IngressPublishing = "publishing"
+	IngressError      = "error"
+	IngressComplete   = "complete"
+)
+
+// StreamEvent is a provider-neutral media server event
+type StreamEvent struct {
+	ID          string            `json:"id"`
+	Type        string            `json:"event"`
+	Room        string            `json:"room"`
+	Participant *EventParticipant `json:"participant,omitempty"`
+	Track       *EventTrack       `json:"track,omitempty"`
+	Egress      *EgressEvent      `json:"egress,omitempty"`
+	Ingress     *IngressEvent     `json:"ingress,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
+// EventParticipant identifies the participant of a participant or track event
+type EventParticipant struct {
+	Identity string `json:"identity"`
+	Name     string `json:"name,omitempty"`
+	// Recorder is set for egress participants, which are not counted as viewers
+	Recorder bool `json:"recorder,omitempty"`
+}
+
+// EventTrack describes a published track
+type EventTrack struct {
+	SID    string `json:"sid"`
+	Type   string `json:"type"`   // audio, video, data
+	Source string `json:"source"` // camera, microphone, screen_share
+}
+
+// EgressEvent describes the state of a recording or restream
+type EgressEvent struct {
+	ID     string       `json:"id"`
+	Status string       `json:"status"`
+	Error  string       `json:"error,omitempty"`
+	Files  []EgressFile `json:"files,omitempty"`
+}
+
+// EgressFile is a file produced by an egress
+type EgressFile struct {
+	Filename string        `json:"filename"`
+	Location string        `json:"location"`
+	Size     int64         `json:"size"`
+	Duration time.Duration `json:"duration"`
+}
+
+// IngressEvent describes the state of an encoder connection
+type IngressEvent struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
@@ -0,0 +1,377 @@
+package livekit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FakeProvider is an in-memory StreamProvider for local development and tests
+type FakeProvider struct {
+	mutex      sync.Mutex
+	rooms      map[string]*fakeRoom
+	recordings map[string]string // egress ID -> output path
+	ingresses  map[string]IngressOptions
+	// webhookSecret signs the webhooks the provider accepts
+	webhookSecret []byte
+}
+
+type fakeRoom struct {
+	room         Room
+	participants map[string]*fakeParticipant
+}
+
+type fakeParticipant struct {
+	info  ParticipantInfo
+	muted bool
+	grant *TokenGrant
+}
+
+// NewFakeProvider creates an empty in-memory provider. Its webhooks are signed with a
+// random secret until SetWebhookSecret sets a known one.
+func NewFakeProvider() *FakeProvider {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(fmt.Sprintf("failed to generate fake webhook secret: %v", err))
+	}
+	return &FakeProvider{
+		rooms:         make(map[string]*fakeRoom),
+		recordings:    make(map[string]string),
+		ingresses:     make(map[string]IngressOptions),
+		webhookSecret: secret,
+	}
+}
+
+// SetWebhookSecret sets the secret webhooks must be signed with, so local tools can
+// send webhooks to a running server
+func (p *FakeProvider) SetWebhookSecret(secret string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.webhookSecret = []byte(secret)
+}
+
+// Name returns the provider identifier
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+// URL returns a placeholder server URL
+func (p *FakeProvider) URL() string {
+	return "ws://fake-stream.local"
+}
+
+// CreateRoom creates a room, returning the existing room if it is already open
+func (p *FakeProvider) CreateRoom(ctx context.Context, options RoomOptions) (*Room, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if existing, ok := p.rooms[options.Name]; ok {
+		room := existing.room
+		return &room, nil
+	}
+
+	room := &fakeRoom{
+		room: Room{
+			Name:      options.Name,
+			SID:       "RM_" + uuid.New().String()[:12],
+			CreatedAt: time.Now(),
+		},
+		participants: make(map[string]*fakeParticipant),
+	}
+	p.rooms[options.Name] = room
+
+	result := room.room
+	return &result, nil
+}
+
+// DeleteRoom removes a room
+func (p *FakeProvider) DeleteRoom(ctx context.Context, roomName string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, ok := p.rooms[roomName]; !ok {
+		return ErrRoomNotFound
+	}
+	delete(p.rooms, roomName)
+	return nil
+}
+
+// ListParticipants returns the participants in a room ordered by join time
+func (p *FakeProvider) ListParticipants(ctx context.Context, roomName string) ([]*ParticipantInfo, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	room, ok := p.rooms[roomName]
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+
+	participants := make([]*ParticipantInfo, 0, len(room.participants))
+	for _, participant := range room.participants {
+		info := participant.info
+		participants = append(participants, &info)
+	}
+	sort.Slice(participants, func(i, j int) bool {
+		return participants[i].JoinedAt.Before(participants[j].JoinedAt)
+	})
+
+	return participants, nil
+}
+
+// RemoveParticipant removes a participant from a room
+func (p *FakeProvider) RemoveParticipant(ctx context.Context, roomName, identity string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	room, ok := p.rooms[roomName]
+	if !ok {
+		return ErrRoomNotFound
+	}
+	if _, ok := room.participants[identity]; !ok {
+		return fmt.Errorf("%w: %s", ErrParticipantNotFound, identity)
+	}
+	delete(room.participants, identity)
+	return nil
+}
+
+// MuteParticipant records the mute state of a participant
+func (p *FakeProvider) MuteParticipant(ctx context.Context, roomName, identity string, muted bool) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	room, ok := p.rooms[roomName]
+	if !ok {
+		return ErrRoomNotFound
+	}
+	participant, ok := room.participants[identity]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrParticipantNotFound, identity)
+	}
+	participant.muted = muted
+	return nil
+}
+
+// UpdateParticipantGrant records the new grant of a participant
+func (p *FakeProvider) UpdateParticipantGrant(ctx context.Context, roomName, identity string, grant TokenGrant) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	room, ok := p.rooms[roomName]
+	if !ok {
+		return ErrRoomNotFound
+	}
+	participant, ok := room.participants[identity]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrParticipantNotFound, identity)
+	}
+	participant.grant = &grant
+	participant.info.IsPublisher = grant.CanPublish
+	return nil
+}
+
+// IssueToken returns an unsigned token encoding the identity and grant
+func (p *FakeProvider) IssueToken(identity, name string, grant TokenGrant, validFor time.Duration) (string, error) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"identity": identity,
+		"name":     name,
+		"grant":    grant,
+		"exp":      time.Now().Add(validFor).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return "fake." + base64.RawURLEncoding.EncodeToString(payload), nil
+}
+
+// StartRecording records that an egress is running for a room
+func (p *FakeProvider) StartRecording(ctx context.Context, roomName, filepath string) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, ok := p.rooms[roomName]; !ok {
+		return "", ErrRoomNotFound
+	}
+
+	egressID := "EG_" + uuid.New().String()[:12]
+	p.recordings[egressID] = filepath
+	return egressID, nil
+}
+
+// StopRecording marks an egress as stopped
+func (p *FakeProvider) StopRecording(ctx context.Context, egressID string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, ok := p.recordings[egressID]; !ok {
+		return fmt.Errorf("egress %s not found", egressID)
+	}
+	delete(p.recordings, egressID)
+	return nil
+}
+
+// IsRecording reports whether an egress is still running
+func (p *FakeProvider) IsRecording(egressID string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	_, ok := p.recordings[egressID]
+	return ok
+}
+
+// CreateIngress registers an ingress with a random stream key
+func (p *FakeProvider) CreateIngress(ctx context.Context, options IngressOptions) (*Ingress, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	ingress := &Ingress{
+		ID:        "IN_" + uuid.New().String()[:12],
+		StreamKey: strings.ReplaceAll(uuid.New().String(), "-", ""),
+	}
+	switch options.InputType {
+	case IngressRTMP:
+		ingress.URL = "rtmp://fake-stream.local/x"
+	case IngressWHIP:
+		ingress.URL = "http://fake-stream.local/w"
+	default:
+		return nil, fmt.Errorf("unsupported ingress type %q", options.InputType)
+	}
+
+	p.ingresses[ingress.ID] = options
+	return ingress, nil
+}
+
+// UpdateIngress moves an ingress to another room
+func (p *FakeProvider) UpdateIngress(ctx context.Context, ingressID string, options IngressOptions) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	existing, ok := p.ingresses[ingressID]
+	if !ok {
+		return ErrIngressNotFound
+	}
+	options.InputType = existing.InputType
+	p.ingresses[ingressID] = options
+	return nil
+}
+
+// DeleteIngress removes an ingress
+func (p *FakeProvider) DeleteIngress(ctx context.Context, ingressID string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, ok := p.ingresses[ingressID]; !ok {
+		return ErrIngressNotFound
+	}
+	delete(p.ingresses, ingressID)
+	return nil
+}
+
+// IngressRoom returns the room an ingress publishes to, or "" if it does not exist
+func (p *FakeProvider) IngressRoom(ingressID string) string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.ingresses[ingressID].RoomName
+}
+
+// ParseWebhook decodes a JSON StreamEvent signed with SignWebhook
+func (p *FakeProvider) ParseWebhook(r *http.Request) (*StreamEvent, error) {
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get("Authorization"))
+	if err != nil || !hmac.Equal(signature, p.sign(body)) {
+		return nil, ErrInvalidWebhook
+	}
+
+	var event StreamEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+	}
+	return &event, nil
+}
+
+// SignWebhook returns the Authorization header value for a fake webhook body
+func (p *FakeProvider) SignWebhook(body []byte) string {
+	return hex.EncodeToString(p.sign(body))
+}
+
+func (p *FakeProvider) sign(body []byte) []byte {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	mac := hmac.New(sha256.New, p.webhookSecret)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// AddParticipant simulates a participant joining a room
+func (p *FakeProvider) AddParticipant(roomName string, info ParticipantInfo) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	room, ok := p.rooms[roomName]
+	if !ok {
+		return ErrRoomNotFound
+	}
+	if info.SID == "" {
+		info.SID = "PA_" + uuid.New().String()[:12]
+	}
+	if info.JoinedAt.IsZero() {
+		info.JoinedAt = time.Now()
+	}
+	room.participants[info.Identity] = &fakeParticipant{info: info}
+	return nil
+}
+
+// CanPublish reports whether a participant is currently allowed to publish media
+func (p *FakeProvider) CanPublish(roomName, identity string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if room, ok := p.rooms[roomName]; ok {
+		if participant, ok := room.participants[identity]; ok {
+			return participant.info.IsPublisher
+		}
+	}
+	return false
+}
+
+// HasRoom reports whether a room is open
+func (p *FakeProvider) HasRoom(roomName string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	_, ok := p.rooms[roomName]
+	return ok
+}
+
+// IsMuted reports whether a participant has been muted
+func (p *FakeProvider) IsMuted(roomName, identity string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if room, ok := p.rooms[roomName]; ok {
+		if participant, ok := room.participants[identity]; ok {
+			return participant.muted
+		}
+	}
+	return false
+}
@@ -1,12 +1,14 @@
 package livekit
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
-	"github.com/blytz.live.remake/backend/internal/models"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // Handler provides LiveKit HTTP handlers
@@ -23,17 +25,18 @@ func NewHandler(service *Service) *Handler {
 
 // StartStreamRequest represents request body for starting a stream
 type StartStreamRequest struct {
-	AuctionID   uuid.UUID `json:"auction_id" binding:"required"`
+	AuctionID    uuid.UUID `json:"auction_id" binding:"required"`
 	RecordStream bool      `json:"record_stream"`
 }
 
 // UpdateMetricsRequest represents request body for updating stream metrics
 type UpdateMetricsRequest struct {
 	ViewerCount int  `json:"viewer_count"`
-	Latency     int  `json:"latency"`     // in milliseconds
-	Bandwidth   int  `json:"bandwidth"`   // in kbps
+	Latency     int  `json:"latency"`      // in milliseconds
+	Bandwidth   int  `json:"bandwidth"`    // in kbps
 	CPUUsage    int  `json:"cpu_usage"`    // percentage
 	MemoryUsage int  `json:"memory_usage"` // percentage
+	FrameRate   *int `json:"frame_rate"`   // video frames per second
 }
 
 // CreateAuctionRoom creates a LiveKit room for an auction
@@ -44,8 +47,18 @@ func (h *Handler) CreateAuctionRoom(c *gin.Context) {
 		return
 	}
 
+	// Only the auction's seller and admins manage its stream
+	if err := h.checkAuctionOwner(c, auctionID); err != nil {
+		writeStageError(c, err)
+		return
+	}
+
 	room, err := h.service.CreateAuctionRoom(c.Request.Context(), auctionID)
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Auction not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -53,6 +66,26 @@ func (h *Handler) CreateAuctionRoom(c *gin.Context) {
 	c.JSON(http.StatusCreated, room)
 }
 
+// ReceiveWebhook handles signed event callbacks from the stream provider
+func (h *Handler) ReceiveWebhook(c *gin.Context) {
+	event, err := h.service.Provider().ParseWebhook(c.Request)
+	if err != nil {
+		if errors.Is(err, ErrInvalidWebhook) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook signature"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.HandleWebhookEvent(c.Request.Context(), event); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"received": true})
+}
+
 // GetViewerToken generates a token for viewers to join auction stream
 func (h *Handler) GetViewerToken(c *gin.Context) {
 	auctionID, err := uuid.Parse(c.Param("auction_id"))
@@ -99,7 +132,7 @@ func (h *Handler) GetHostToken(c *gin.Context) {
 
 	token, err := h.service.GenerateHostToken(c.Request.Context(), auctionID, userID.(uuid.UUID))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeStageError(c, err)
 		return
 	}
 
@@ -118,10 +151,9 @@ func (h *Handler) StartAuctionStream(c *gin.Context) {
 		return
 	}
 
-	// Check user permissions (must be seller or admin)
-	role, _ := c.Get("role")
-	if role != "seller" && role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	// Only the auction's seller and admins manage its stream
+	if err := h.checkAuctionOwner(c, auctionID); err != nil {
+		writeStageError(c, err)
 		return
 	}
 
@@ -142,10 +174,9 @@ func (h *Handler) EndAuctionStream(c *gin.Context) {
 		return
 	}
 
-	// Check user permissions (must be seller or admin)
-	role, _ := c.Get("role")
-	if role != "seller" && role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	// Only the auction's seller and admins manage its stream
+	if err := h.checkAuctionOwner(c, auctionID); err != nil {
+		writeStageError(c, err)
 		return
 	}
 
@@ -213,6 +244,12 @@ func (h *Handler) UpdateStreamMetrics(c *gin.Context) {
 		return
 	}
 
+	// Only the auction's seller and admins manage its stream
+	if err := h.checkAuctionOwner(c, auctionID); err != nil {
+		writeStageError(c, err)
+		return
+	}
+
 	var req UpdateMetricsRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -225,10 +262,15 @@ func (h *Handler) UpdateStreamMetrics(c *gin.Context) {
 		Bandwidth:   req.Bandwidth,
 		CPUUsage:    req.CPUUsage,
 		MemoryUsage: req.MemoryUsage,
+		FrameRate:   req.FrameRate,
 	}
 
 	err = h.service.UpdateStreamMetrics(c.Request.Context(), auctionID, metrics)
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -236,6 +278,70 @@ func (h *Handler) UpdateStreamMetrics(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Stream metrics updated successfully"})
 }
 
+// ListStreamMetrics returns the stored metrics samples of a stream
+func (h *Handler) ListStreamMetrics(c *gin.Context) {
+	auctionID, err := uuid.Parse(c.Param("auction_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid auction ID"})
+		return
+	}
+
+	// Only the auction's seller and admins manage its stream
+	if err := h.checkAuctionOwner(c, auctionID); err != nil {
+		writeStageError(c, err)
+		return
+	}
+
+	var since time.Time
+	if value := c.Query("since"); value != "" {
+		since, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since timestamp, expected RFC 3339"})
+			return
+		}
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "500"))
+	if limit < 1 || limit > 2000 {
+		limit = 500
+	}
+
+	samples, err := h.service.ListStreamMetrics(c.Request.Context(), auctionID, since, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"samples": samples})
+}
+
+// GetQualityReport returns the quality report of a stream
+func (h *Handler) GetQualityReport(c *gin.Context) {
+	auctionID, err := uuid.Parse(c.Param("auction_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid auction ID"})
+		return
+	}
+
+	// Only the auction's seller and admins manage its stream
+	if err := h.checkAuctionOwner(c, auctionID); err != nil {
+		writeStageError(c, err)
+		return
+	}
+
+	report, err := h.service.GetQualityReport(c.Request.Context(), auctionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
 // ListActiveStreams lists all currently active streams
 func (h *Handler) ListActiveStreams(c *gin.Context) {
 	streams, err := h.service.ListActiveStreams(c.Request.Context())
@@ -256,7 +362,7 @@ func (h *Handler) RecordStream(c *gin.Context) {
 	}
 
 	var req struct {
-		Enabled bool `json:"enabled" binding:"required"`
+		Enabled *bool `json:"enabled" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -264,20 +370,63 @@ func (h *Handler) RecordStream(c *gin.Context) {
 		return
 	}
 
-	// Check user permissions (must be seller or admin)
-	role, _ := c.Get("role")
-	if role != "seller" && role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	// Only the auction's seller and admins manage its stream
+	if err := h.checkAuctionOwner(c, auctionID); err != nil {
+		writeStageError(c, err)
 		return
 	}
 
-	err = h.service.RecordStream(c.Request.Context(), auctionID, req.Enabled)
+	recording, err := h.service.RecordStream(c.Request.Context(), auctionID, *req.Enabled)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNoActiveRecording):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrRecordingNotConfigured):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Auction not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Stream recording updated successfully",
+		"recording": recording,
+	})
+}
+
+// ListMyRecordings lists the authenticated seller's stream recordings
+func (h *Handler) ListMyRecordings(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	recordings, total, err := h.service.ListSellerRecordings(c.Request.Context(), userID.(uuid.UUID), page, limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Stream recording updated successfully"})
+	c.JSON(http.StatusOK, gin.H{
+		"recordings": recordings,
+		"total":      total,
+		"page":       page,
+		"limit":      limit,
+	})
 }
 
 // GenerateStreamRecordingURL generates a URL for recorded stream
@@ -288,22 +437,21 @@ func (h *Handler) GenerateStreamRecordingURL(c *gin.Context) {
 		return
 	}
 
-	// Check user permissions (must be seller or admin)
-	role, _ := c.Get("role")
-	if role != "seller" && role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	// Only the auction's seller and admins manage its stream
+	if err := h.checkAuctionOwner(c, auctionID); err != nil {
+		writeStageError(c, err)
 		return
 	}
 
 	recordingURL, err := h.service.GenerateStreamRecordingURL(c.Request.Context(), auctionID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"recording_url": recordingURL,
-		"auction_id":   auctionID,
+		"auction_id":    auctionID,
 	})
 }
 
@@ -315,9 +463,8 @@ func (h *Handler) GetRoomParticipants(c *gin.Context) {
 		return
 	}
 
-	// Check user permissions (must be seller or admin)
-	role, _ := c.Get("role")
-	if role != "seller" && role != "admin" {
+	// Only the host, co-hosts and admins see who is in the room
+	if !h.canModerate(c, auctionID) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
 		return
 	}
@@ -339,20 +486,19 @@ func (h *Handler) RemoveParticipant(c *gin.Context) {
 		return
 	}
 
-	participantSID := c.Param("participant_sid")
-	if participantSID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Participant SID is required"})
+	identity := c.Param("identity")
+	if identity == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Participant identity is required"})
 		return
 	}
 
-	// Check user permissions (must be seller or admin)
-	role, _ := c.Get("role")
-	if role != "seller" && role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	// Moderation follows stage roles: co-hosts cannot act on the host or other co-hosts
+	if err := h.checkModerationRights(c, auctionID, identity); err != nil {
+		writeStageError(c, err)
 		return
 	}
 
-	err = h.service.RemoveParticipant(c.Request.Context(), auctionID, participantSID)
+	err = h.service.RemoveParticipant(c.Request.Context(), auctionID, identity)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -369,32 +515,31 @@ func (h *Handler) MuteParticipant(c *gin.Context) {
 		return
 	}
 
-	participantSID := c.Param("participant_sid")
-	if participantSID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Participant SID is required"})
+	identity := c.Param("identity")
+	if identity == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Participant identity is required"})
 		return
 	}
 
 	muted := c.DefaultQuery("muted", "true")
 	isMuted := muted == "true"
 
-	// Check user permissions (must be seller or admin)
-	role, _ := c.Get("role")
-	if role != "seller" && role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	// Moderation follows stage roles: co-hosts cannot act on the host or other co-hosts
+	if err := h.checkModerationRights(c, auctionID, identity); err != nil {
+		writeStageError(c, err)
 		return
 	}
 
-	err = h.service.MuteParticipant(c.Request.Context(), auctionID, participantSID, isMuted)
+	err = h.service.MuteParticipant(c.Request.Context(), auctionID, identity, isMuted)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":       "Participant muted/unmuted successfully",
-		"participant_sid": participantSID,
-		"muted":        isMuted,
+		"message":  "Participant muted/unmuted successfully",
+		"identity": identity,
+		"muted":    isMuted,
 	})
 }
 
@@ -419,4 +564,595 @@ func (h *Handler) GetStreamRecording(c *gin.Context) {
 
 	// Redirect to recording URL
 	c.Redirect(http.StatusFound, *stream.RecordingURL)
-}
\ No newline at end of file
+}
+
+// CreateIngressRequest represents request body for creating an encoder ingress
+type CreateIngressRequest struct {
+	InputType string     `json:"input_type" binding:"required,oneof=rtmp whip"`
+	Name      string     `json:"name" binding:"max=100"`
+	AuctionID *uuid.UUID `json:"auction_id"` // omit for a reusable per-seller ingress
+}
+
+// AttachIngressRequest represents request body for attaching an ingress to an auction
+type AttachIngressRequest struct {
+	AuctionID *uuid.UUID `json:"auction_id"` // null detaches the ingress
+}
+
+// CreateIngress creates an RTMP or WHIP endpoint for OBS and other encoders
+func (h *Handler) CreateIngress(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req CreateIngressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ingress, err := h.service.CreateIngress(c.Request.Context(), userID.(uuid.UUID), req.InputType, req.Name, req.AuctionID)
+	if err != nil {
+		writeIngressError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, ingress)
+}
+
+// ListIngresses lists the authenticated seller's ingresses
+func (h *Handler) ListIngresses(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	ingresses, err := h.service.ListIngresses(c.Request.Context(), userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ingresses": ingresses})
+}
+
+// RotateIngressKey issues a new stream key for an ingress
+func (h *Handler) RotateIngressKey(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ingress ID"})
+		return
+	}
+
+	ingress, err := h.service.RotateIngressKey(c.Request.Context(), userID.(uuid.UUID), id)
+	if err != nil {
+		writeIngressError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ingress)
+}
+
+// RevokeIngress permanently disables an ingress and its stream key
+func (h *Handler) RevokeIngress(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ingress ID"})
+		return
+	}
+
+	if err := h.service.RevokeIngress(c.Request.Context(), userID.(uuid.UUID), id); err != nil {
+		writeIngressError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Ingress revoked successfully"})
+}
+
+// AttachIngress attaches an ingress to an auction, or detaches it
+func (h *Handler) AttachIngress(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ingress ID"})
+		return
+	}
+
+	var req AttachIngressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ingress, err := h.service.AttachIngress(c.Request.Context(), userID.(uuid.UUID), id, req.AuctionID)
+	if err != nil {
+		writeIngressError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ingress)
+}
+
+// writeIngressError maps ingress service errors to HTTP responses
+func writeIngressError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrIngressRevoked), errors.Is(err, ErrIngressBusy):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrAuctionNotOwned):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// InviteToStageRequest represents request body for inviting a co-host or guest
+type InviteToStageRequest struct {
+	Role   string     `json:"role" binding:"required,oneof=cohost guest"`
+	UserID *uuid.UUID `json:"user_id"` // omit for an open invitation link
+}
+
+// ApproveStageRequest represents request body for bringing a viewer on stage
+type ApproveStageRequest struct {
+	Role string `json:"role" binding:"omitempty,oneof=cohost guest"`
+}
+
+// InviteToStage invites a co-host or guest to publish in the auction room
+func (h *Handler) InviteToStage(c *gin.Context) {
+	auctionID, err := uuid.Parse(c.Param("auction_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid auction ID"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req InviteToStageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	invitation, err := h.service.InviteToStage(c.Request.Context(), auctionID, userID.(uuid.UUID), req.Role, req.UserID)
+	if err != nil {
+		writeStageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, invitation)
+}
+
+// ListStage lists the co-hosts, guests, invitations and requests of an auction
+func (h *Handler) ListStage(c *gin.Context) {
+	auctionID, err := uuid.Parse(c.Param("auction_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid auction ID"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	participants, err := h.service.ListStage(c.Request.Context(), auctionID, userID.(uuid.UUID))
+	if err != nil {
+		writeStageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"participants": participants})
+}
+
+// ApproveStageRequest brings a viewer who asked to join on stage
+func (h *Handler) ApproveStageRequest(c *gin.Context) {
+	auctionID, err := uuid.Parse(c.Param("auction_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid auction ID"})
+		return
+	}
+
+	participantID, err := uuid.Parse(c.Param("participant_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid participant ID"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req ApproveStageRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	participant, err := h.service.ApproveStageRequest(c.Request.Context(), auctionID, userID.(uuid.UUID), participantID, req.Role)
+	if err != nil {
+		writeStageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, participant)
+}
+
+// RevokeStage takes a co-host or guest off stage or withdraws an invitation
+func (h *Handler) RevokeStage(c *gin.Context) {
+	auctionID, err := uuid.Parse(c.Param("auction_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid auction ID"})
+		return
+	}
+
+	participantID, err := uuid.Parse(c.Param("participant_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid participant ID"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	if err := h.service.RevokeStage(c.Request.Context(), auctionID, userID.(uuid.UUID), participantID); err != nil {
+		writeStageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Stage access revoked successfully"})
+}
+
+// RequestStage asks the host to bring the authenticated viewer on stage
+func (h *Handler) RequestStage(c *gin.Context) {
+	auctionID, err := uuid.Parse(c.Param("auction_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid auction ID"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	participant, err := h.service.RequestStage(c.Request.Context(), auctionID, userID.(uuid.UUID))
+	if err != nil {
+		writeStageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, participant)
+}
+
+// ListMyInvitations lists the stage invitations addressed to the authenticated user
+func (h *Handler) ListMyInvitations(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	invitations, err := h.service.ListMyInvitations(c.Request.Context(), userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invitations": invitations})
+}
+
+// AcceptInvitation accepts a stage invitation and returns a stage token
+func (h *Handler) AcceptInvitation(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	participant, token, err := h.service.AcceptInvitation(c.Request.Context(), c.Param("code"), userID.(uuid.UUID))
+	if err != nil {
+		writeStageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"participant": participant,
+		"token":       token,
+	})
+}
+
+// DeclineInvitation turns down a stage invitation
+func (h *Handler) DeclineInvitation(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	if err := h.service.DeclineInvitation(c.Request.Context(), c.Param("code"), userID.(uuid.UUID)); err != nil {
+		writeStageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation declined"})
+}
+
+// GetStageToken generates a token for a co-host or guest who is on stage
+func (h *Handler) GetStageToken(c *gin.Context) {
+	auctionID, err := uuid.Parse(c.Param("auction_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid auction ID"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	token, err := h.service.GenerateStageToken(c.Request.Context(), auctionID, userID.(uuid.UUID))
+	if err != nil {
+		writeStageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":      token,
+		"auction_id": auctionID,
+		"user_id":    userID,
+	})
+}
+
+// checkModerationRights checks the authenticated user may moderate identity
+func (h *Handler) checkModerationRights(c *gin.Context, auctionID uuid.UUID, identity string) error {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return ErrInsufficientRole
+	}
+	role, _ := c.Get("role")
+	return h.service.CheckModerationRights(c.Request.Context(), auctionID, userID.(uuid.UUID), role == "admin", identity)
+}
+
+// checkAuctionOwner returns ErrAuctionNotOwned unless the authenticated user is an admin
+// or the auction's seller
+func (h *Handler) checkAuctionOwner(c *gin.Context, auctionID uuid.UUID) error {
+	if role, _ := c.Get("role"); role == "admin" {
+		return nil
+	}
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return ErrAuctionNotOwned
+	}
+	return h.service.CheckAuctionOwner(c.Request.Context(), auctionID, userID.(uuid.UUID))
+}
+
+// canModerate reports whether the authenticated user is an admin, the host or a co-host
+func (h *Handler) canModerate(c *gin.Context, auctionID uuid.UUID) bool {
+	if role, _ := c.Get("role"); role == "admin" {
+		return true
+	}
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return false
+	}
+	role, err := h.service.ParticipantRole(c.Request.Context(), auctionID, userID.(uuid.UUID).String())
+	return err == nil && (role == RoleHost || role == RoleCohost)
+}
+
+// writeStageError maps stage and moderation errors to HTTP responses
+func writeStageError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrAuctionNotOwned), errors.Is(err, ErrInsufficientRole), errors.Is(err, ErrNotOnStage):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvitationInvalid):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// CreateClipRequest represents request body for cutting a highlight clip
+type CreateClipRequest struct {
+	Title     string    `json:"title" binding:"max=200"`
+	StartTime time.Time `json:"start_time" binding:"required"`
+	EndTime   time.Time `json:"end_time" binding:"required"`
+}
+
+// UpdateClipRequest represents request body for publishing or hiding a clip
+type UpdateClipRequest struct {
+	IsPublished *bool `json:"is_published" binding:"required"`
+}
+
+// CreateClip cuts a highlight clip from an auction's recording by time range
+func (h *Handler) CreateClip(c *gin.Context) {
+	auctionID, err := uuid.Parse(c.Param("auction_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid auction ID"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req CreateClipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	clip, err := h.service.CreateClip(c.Request.Context(), userID.(uuid.UUID), auctionID, req.Title, req.StartTime, req.EndTime)
+	if err != nil {
+		writeClipError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, clip)
+}
+
+// CreateLotClip creates the automatic clip of a sold lot around its winning bid
+func (h *Handler) CreateLotClip(c *gin.Context) {
+	auctionID, err := uuid.Parse(c.Param("auction_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid auction ID"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	clip, err := h.service.CreateSellerLotClip(c.Request.Context(), userID.(uuid.UUID), auctionID)
+	if err != nil {
+		writeClipError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, clip)
+}
+
+// ListAuctionClips lists the published highlight clips of an auction
+func (h *Handler) ListAuctionClips(c *gin.Context) {
+	auctionID, err := uuid.Parse(c.Param("auction_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid auction ID"})
+		return
+	}
+
+	clips, err := h.service.ListAuctionClips(c.Request.Context(), auctionID, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"clips": clips})
+}
+
+// UpdateClip publishes or hides a clip
+func (h *Handler) UpdateClip(c *gin.Context) {
+	clipID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid clip ID"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req UpdateClipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	clip, err := h.service.SetClipPublished(c.Request.Context(), userID.(uuid.UUID), clipID, *req.IsPublished)
+	if err != nil {
+		writeClipError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, clip)
+}
+
+// FeatureClip makes a clip the product's main video
+func (h *Handler) FeatureClip(c *gin.Context) {
+	clipID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid clip ID"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	if err := h.service.FeatureClip(c.Request.Context(), userID.(uuid.UUID), clipID); err != nil {
+		writeClipError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Clip set as product video"})
+}
+
+// DeleteClip deletes a clip
+func (h *Handler) DeleteClip(c *gin.Context) {
+	clipID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid clip ID"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	if err := h.service.DeleteClip(c.Request.Context(), userID.(uuid.UUID), clipID); err != nil {
+		writeClipError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Clip deleted successfully"})
+}
+
+// writeClipError maps clip errors to HTTP responses
+func writeClipError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidClipRange):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNoRecordingForClip), errors.Is(err, ErrLotNotSold):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrAuctionNotOwned):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
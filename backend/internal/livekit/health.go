@@ -0,0 +1,478 @@
+package livekit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Stream health rules
+const (
+	RuleBitrateDrop  = "bitrate_drop"
+	RuleLatencySpike = "latency_spike"
+	RuleNoVideo      = "no_video"
+	RuleHighCPU      = "high_cpu"
+	RuleHighMemory   = "high_memory"
+)
+
+// AlertNotifier delivers stream alerts privately to the auction host
+type AlertNotifier interface {
+	SendToUser(auctionID, userID uuid.UUID, messageType string, data interface{})
+}
+
+// HealthRules are the thresholds stream metrics are checked against
+type HealthRules struct {
+	// BitrateDropPercent raises an alert when bandwidth falls this far below the
+	// average of the previous BitrateWindow samples
+	BitrateDropPercent int
+	BitrateWindow      int
+	LatencySpikeMs     int
+	NoVideoAfter       time.Duration
+	MaxCPUUsage        int
+	MaxMemoryUsage     int
+}
+
+// DefaultHealthRules returns the thresholds used unless SetHealthRules is called
+func DefaultHealthRules() HealthRules {
+	return HealthRules{
+		BitrateDropPercent: 50,
+		BitrateWindow:      5,
+		LatencySpikeMs:     1500,
+		NoVideoAfter:       10 * time.Second,
+		MaxCPUUsage:        90,
+		MaxMemoryUsage:     90,
+	}
+}
+
+// SetAlertNotifier enables pushing stream alerts to the host
+func (s *Service) SetAlertNotifier(notifier AlertNotifier) {
+	s.alertNotifier = notifier
+}
+
+// SetHealthRules replaces the stream health thresholds
+func (s *Service) SetHealthRules(rules HealthRules) {
+	s.healthRules = rules
+}
+
+// hasVideoSQL matches samples in which video was being sent. Clients that do not
+// report a frame rate are judged by bandwidth alone.
+const hasVideoSQL = "(frame_rate > 0 OR (frame_rate IS NULL AND bandwidth > 0))"
+
+// UpdateStreamMetrics stores a metrics sample, updates the stream's current values and
+// raises or resolves health alerts
+func (s *Service) UpdateStreamMetrics(ctx context.Context, auctionID uuid.UUID, metrics *StreamMetrics) error {
+	var stream models.LiveStream
+	if err := s.db.WithContext(ctx).
+		Preload("Auction", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id", "seller_id")
+		}).
+		First(&stream, "auction_id = ?", auctionID).Error; err != nil {
+		return err
+	}
+
+	sample := &models.StreamMetricSample{
+		LiveStreamID: stream.ID,
+		AuctionID:    auctionID,
+		ViewerCount:  metrics.ViewerCount,
+		Latency:      metrics.Latency,
+		Bandwidth:    metrics.Bandwidth,
+		FrameRate:    metrics.FrameRate,
+		CPUUsage:     metrics.CPUUsage,
+		MemoryUsage:  metrics.MemoryUsage,
+		RecordedAt:   time.Now(),
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&stream).Updates(map[string]interface{}{
+			"viewer_count": metrics.ViewerCount,
+			"latency":      metrics.Latency,
+			"bandwidth":    metrics.Bandwidth,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Create(sample).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.evaluateHealth(ctx, &stream, sample)
+}
+
+// healthCheck is the outcome of one rule for the latest sample
+type healthCheck struct {
+	violated  bool
+	severity  string
+	value     float64
+	threshold float64
+	message   string
+}
+
+// evaluateHealth checks a new sample against the health rules. An alert is raised the
+// first time a rule is violated and resolved once the condition clears.
+func (s *Service) evaluateHealth(ctx context.Context, stream *models.LiveStream, sample *models.StreamMetricSample) error {
+	var open []models.StreamAlert
+	if err := s.db.WithContext(ctx).
+		Where("live_stream_id = ? AND resolved_at IS NULL", stream.ID).
+		Find(&open).Error; err != nil {
+		return err
+	}
+	openByRule := make(map[string]*models.StreamAlert, len(open))
+	for i := range open {
+		openByRule[open[i].Rule] = &open[i]
+	}
+
+	checks, err := s.runHealthChecks(ctx, stream, sample, openByRule)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range []string{RuleNoVideo, RuleBitrateDrop, RuleLatencySpike, RuleHighCPU, RuleHighMemory} {
+		check := checks[rule]
+		alert, isOpen := openByRule[rule]
+
+		switch {
+		case check.violated && !isOpen:
+			if err := s.raiseAlert(ctx, stream, rule, check, sample.RecordedAt); err != nil {
+				return err
+			}
+
+		case !check.violated && isOpen:
+			now := sample.RecordedAt
+			if err := s.db.WithContext(ctx).Model(alert).Update("resolved_at", now).Error; err != nil {
+				return err
+			}
+			alert.ResolvedAt = &now
+			s.notifyHost(stream, "stream_alert_resolved", alert)
+		}
+	}
+
+	return nil
+}
+
+// raiseAlert opens an alert for a violated rule and tells the host
+func (s *Service) raiseAlert(ctx context.Context, stream *models.LiveStream, rule string, check healthCheck, at time.Time) error {
+	alert := &models.StreamAlert{
+		LiveStreamID: stream.ID,
+		AuctionID:    stream.AuctionID,
+		Rule:         rule,
+		Severity:     check.severity,
+		Message:      check.message,
+		Value:        check.value,
+		Threshold:    check.threshold,
+		TriggeredAt:  at,
+	}
+	if err := s.db.WithContext(ctx).Create(alert).Error; err != nil {
+		return err
+	}
+	s.logger.Warn("Stream health alert", map[string]interface{}{
+		"auction_id": stream.AuctionID,
+		"rule":       rule,
+		"value":      check.value,
+		"threshold":  check.threshold,
+	})
+	s.notifyHost(stream, "stream_alert", alert)
+	return nil
+}
+
+// CheckSilentStreams raises the no-video alert for live streams that have sent no video
+// for NoVideoAfter at now, including those whose encoder stopped reporting metrics
+// altogether. The next sample with video resolves it. It returns the number of alerts
+// raised.
+func (s *Service) CheckSilentStreams(ctx context.Context, now time.Time) (int, error) {
+	rules := s.healthRules
+	if rules.NoVideoAfter <= 0 {
+		return 0, nil
+	}
+
+	var streams []models.LiveStream
+	if err := s.db.WithContext(ctx).
+		Preload("Auction", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id", "seller_id")
+		}).
+		Where("status = ? AND started_at IS NOT NULL", "live").
+		Where("id NOT IN (?)", s.db.Model(&models.StreamAlert{}).
+			Select("live_stream_id").
+			Where("rule = ? AND resolved_at IS NULL", RuleNoVideo)).
+		Find(&streams).Error; err != nil {
+		return 0, err
+	}
+
+	raised := 0
+	for i := range streams {
+		stream := &streams[i]
+
+		var lastVideo models.StreamMetricSample
+		if err := s.db.WithContext(ctx).
+			Where("live_stream_id = ? AND "+hasVideoSQL, stream.ID).
+			Order("recorded_at DESC").
+			Limit(1).
+			Find(&lastVideo).Error; err != nil {
+			return raised, err
+		}
+
+		since := *stream.StartedAt
+		if lastVideo.ID != uuid.Nil && lastVideo.RecordedAt.After(since) {
+			since = lastVideo.RecordedAt
+		}
+		gap := now.Sub(since)
+		if gap < rules.NoVideoAfter {
+			continue
+		}
+
+		if err := s.raiseAlert(ctx, stream, RuleNoVideo, healthCheck{
+			violated:  true,
+			severity:  "critical",
+			value:     gap.Seconds(),
+			threshold: rules.NoVideoAfter.Seconds(),
+			message:   fmt.Sprintf("No video received for %d seconds", int(gap.Seconds())),
+		}, now); err != nil {
+			return raised, err
+		}
+		raised++
+	}
+	return raised, nil
+}
+
+// runHealthChecks evaluates every rule against the latest sample
+func (s *Service) runHealthChecks(ctx context.Context, stream *models.LiveStream, sample *models.StreamMetricSample, open map[string]*models.StreamAlert) (map[string]healthCheck, error) {
+	rules := s.healthRules
+	checks := make(map[string]healthCheck)
+
+	// No video: nothing has been sent for NoVideoAfter
+	if rules.NoVideoAfter > 0 {
+		var lastVideo models.StreamMetricSample
+		err := s.db.WithContext(ctx).
+			Where("live_stream_id = ? AND "+hasVideoSQL, stream.ID).
+			Order("recorded_at DESC").
+			Limit(1).
+			Find(&lastVideo).Error
+		if err != nil {
+			return nil, err
+		}
+
+		since := lastVideo.RecordedAt
+		if lastVideo.ID == uuid.Nil {
+			// Never had video; count from the first sample
+			var first models.StreamMetricSample
+			if err := s.db.WithContext(ctx).
+				Where("live_stream_id = ?", stream.ID).
+				Order("recorded_at ASC").
+				Limit(1).
+				Find(&first).Error; err != nil {
+				return nil, err
+			}
+			since = first.RecordedAt
+		}
+
+		gap := sample.RecordedAt.Sub(since)
+		if lastVideo.ID != sample.ID && gap >= rules.NoVideoAfter {
+			checks[RuleNoVideo] = healthCheck{
+				violated:  true,
+				severity:  "critical",
+				value:     gap.Seconds(),
+				threshold: rules.NoVideoAfter.Seconds(),
+				message:   fmt.Sprintf("No video received for %d seconds", int(gap.Seconds())),
+			}
+		}
+	}
+
+	// Bitrate drop against the recent average, or against the level that raised the
+	// open alert so a sustained drop does not lower its own baseline
+	if rules.BitrateDropPercent > 0 && rules.BitrateWindow > 0 {
+		threshold := 0.0
+		if alert, ok := open[RuleBitrateDrop]; ok {
+			threshold = alert.Threshold
+		} else {
+			var recent []int
+			if err := s.db.WithContext(ctx).
+				Model(&models.StreamMetricSample{}).
+				Where("live_stream_id = ? AND id <> ? AND bandwidth > 0", stream.ID, sample.ID).
+				Order("recorded_at DESC").
+				Limit(rules.BitrateWindow).
+				Pluck("bandwidth", &recent).Error; err != nil {
+				return nil, err
+			}
+			if len(recent) >= 2 {
+				total := 0
+				for _, bandwidth := range recent {
+					total += bandwidth
+				}
+				average := float64(total) / float64(len(recent))
+				threshold = average * float64(100-rules.BitrateDropPercent) / 100
+			}
+		}
+		if threshold > 0 && float64(sample.Bandwidth) < threshold {
+			checks[RuleBitrateDrop] = healthCheck{
+				violated:  true,
+				severity:  "warning",
+				value:     float64(sample.Bandwidth),
+				threshold: threshold,
+				message:   fmt.Sprintf("Bitrate dropped to %d kbps", sample.Bandwidth),
+			}
+		}
+	}
+
+	if rules.LatencySpikeMs > 0 && sample.Latency > rules.LatencySpikeMs {
+		checks[RuleLatencySpike] = healthCheck{
+			violated:  true,
+			severity:  "warning",
+			value:     float64(sample.Latency),
+			threshold: float64(rules.LatencySpikeMs),
+			message:   fmt.Sprintf("Latency rose to %d ms", sample.Latency),
+		}
+	}
+
+	if rules.MaxCPUUsage > 0 && sample.CPUUsage >= rules.MaxCPUUsage {
+		checks[RuleHighCPU] = healthCheck{
+			violated:  true,
+			severity:  "warning",
+			value:     float64(sample.CPUUsage),
+			threshold: float64(rules.MaxCPUUsage),
+			message:   fmt.Sprintf("Encoder CPU usage is at %d%%", sample.CPUUsage),
+		}
+	}
+
+	if rules.MaxMemoryUsage > 0 && sample.MemoryUsage >= rules.MaxMemoryUsage {
+		checks[RuleHighMemory] = healthCheck{
+			violated:  true,
+			severity:  "warning",
+			value:     float64(sample.MemoryUsage),
+			threshold: float64(rules.MaxMemoryUsage),
+			message:   fmt.Sprintf("Encoder memory usage is at %d%%", sample.MemoryUsage),
+		}
+	}
+
+	return checks, nil
+}
+
+// notifyHost pushes an alert message to the auction's host
+func (s *Service) notifyHost(stream *models.LiveStream, messageType string, alert *models.StreamAlert) {
+	if s.alertNotifier == nil || stream.Auction.SellerID == uuid.Nil {
+		return
+	}
+	s.alertNotifier.SendToUser(stream.AuctionID, stream.Auction.SellerID, messageType, alert)
+}
+
+// ListStreamMetrics returns the metrics samples of an auction stream recorded after
+// since, oldest first
+func (s *Service) ListStreamMetrics(ctx context.Context, auctionID uuid.UUID, since time.Time, limit int) ([]models.StreamMetricSample, error) {
+	var samples []models.StreamMetricSample
+	err := s.db.WithContext(ctx).
+		Where("auction_id = ? AND recorded_at > ?", auctionID, since).
+		Order("recorded_at ASC").
+		Limit(limit).
+		Find(&samples).Error
+	return samples, err
+}
+
+// QualityReport summarises the health of a stream
+type QualityReport struct {
+	AuctionID      uuid.UUID            `json:"auction_id"`
+	Status         string               `json:"status"`
+	StartedAt      *time.Time           `json:"started_at"`
+	EndedAt        *time.Time           `json:"ended_at"`
+	Duration       int                  `json:"duration"` // in seconds
+	Samples        int                  `json:"samples"`
+	PeakViewers    int                  `json:"peak_viewers"`
+	AvgBandwidth   float64              `json:"avg_bandwidth"` // in kbps
+	MinBandwidth   int                  `json:"min_bandwidth"`
+	MaxBandwidth   int                  `json:"max_bandwidth"`
+	AvgLatency     float64              `json:"avg_latency"` // in milliseconds
+	P95Latency     int                  `json:"p95_latency"`
+	MaxLatency     int                  `json:"max_latency"`
+	AvgCPUUsage    float64              `json:"avg_cpu_usage"`
+	AvgMemoryUsage float64              `json:"avg_memory_usage"`
+	AlertCounts    map[string]int       `json:"alert_counts"`
+	Alerts         []models.StreamAlert `json:"alerts"`
+	// HealthScore starts at 100 and loses 25 per critical and 10 per warning alert
+	HealthScore int `json:"health_score"`
+}
+
+// GetQualityReport builds the quality report of an auction stream. It can be called
+// while the stream is live to see the report so far.
+func (s *Service) GetQualityReport(ctx context.Context, auctionID uuid.UUID) (*QualityReport, error) {
+	var stream models.LiveStream
+	if err := s.db.WithContext(ctx).First(&stream, "auction_id = ?", auctionID).Error; err != nil {
+		return nil, err
+	}
+
+	var samples []models.StreamMetricSample
+	if err := s.db.WithContext(ctx).
+		Where("live_stream_id = ?", stream.ID).
+		Order("recorded_at ASC").
+		Find(&samples).Error; err != nil {
+		return nil, err
+	}
+
+	report := &QualityReport{
+		AuctionID:   auctionID,
+		Status:      stream.Status,
+		StartedAt:   stream.StartedAt,
+		EndedAt:     stream.EndedAt,
+		Duration:    stream.Duration,
+		Samples:     len(samples),
+		AlertCounts: map[string]int{},
+		HealthScore: 100,
+	}
+
+	if len(samples) > 0 {
+		latencies := make([]int, 0, len(samples))
+		var bandwidth, latency, cpu, memory int
+		report.MinBandwidth = samples[0].Bandwidth
+		for _, sample := range samples {
+			bandwidth += sample.Bandwidth
+			latency += sample.Latency
+			cpu += sample.CPUUsage
+			memory += sample.MemoryUsage
+			latencies = append(latencies, sample.Latency)
+
+			if sample.Bandwidth < report.MinBandwidth {
+				report.MinBandwidth = sample.Bandwidth
+			}
+			if sample.Bandwidth > report.MaxBandwidth {
+				report.MaxBandwidth = sample.Bandwidth
+			}
+			if sample.Latency > report.MaxLatency {
+				report.MaxLatency = sample.Latency
+			}
+			if sample.ViewerCount > report.PeakViewers {
+				report.PeakViewers = sample.ViewerCount
+			}
+		}
+
+		count := float64(len(samples))
+		report.AvgBandwidth = float64(bandwidth) / count
+		report.AvgLatency = float64(latency) / count
+		report.AvgCPUUsage = float64(cpu) / count
+		report.AvgMemoryUsage = float64(memory) / count
+
+		sort.Ints(latencies)
+		report.P95Latency = latencies[(len(latencies)*95+99)/100-1]
+	}
+
+	if err := s.db.WithContext(ctx).
+		Where("live_stream_id = ?", stream.ID).
+		Order("triggered_at ASC").
+		Find(&report.Alerts).Error; err != nil {
+		return nil, err
+	}
+	for _, alert := range report.Alerts {
+		report.AlertCounts[alert.Rule]++
+		if alert.Severity == "critical" {
+			report.HealthScore -= 25
+		} else {
+			report.HealthScore -= 10
+		}
+	}
+	if report.HealthScore < 0 {
+		report.HealthScore = 0
+	}
+
+	return report, nil
+}
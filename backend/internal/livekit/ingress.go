@@ -0,0 +1,369 @@
+package livekit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrIngressRevoked is returned when managing an ingress whose stream key has been revoked
+var ErrIngressRevoked = errors.New("ingress has been revoked")
+
+// ErrIngressBusy is returned when moving an ingress while an encoder is publishing to it
+var ErrIngressBusy = errors.New("ingress is currently receiving a stream")
+
+// ErrAuctionNotOwned is returned when attaching an ingress to another seller's auction
+var ErrAuctionNotOwned = errors.New("auction belongs to another seller")
+
+// SellerRoomName returns the staging room a seller's detached ingresses publish into
+func SellerRoomName(sellerID uuid.UUID) string {
+	return fmt.Sprintf("seller-%s", sellerID.String())
+}
+
+// CreateIngress provisions an RTMP or WHIP endpoint for a seller. With an auction ID the
+// ingress is created for that show; otherwise it is a reusable per-seller endpoint.
+func (s *Service) CreateIngress(ctx context.Context, sellerID uuid.UUID, inputType, name string, auctionID *uuid.UUID) (*models.StreamIngress, error) {
+	roomName := SellerRoomName(sellerID)
+	if auctionID != nil {
+		auction, err := s.sellerAuction(ctx, sellerID, *auctionID)
+		if err != nil {
+			return nil, err
+		}
+		roomName = RoomName(auction.ID)
+
+		if err := s.detachOtherIngresses(ctx, *auctionID, uuid.Nil); err != nil {
+			return nil, err
+		}
+	}
+
+	ingress, err := s.provider.CreateIngress(ctx, IngressOptions{
+		Name:                name,
+		InputType:           inputType,
+		RoomName:            roomName,
+		ParticipantIdentity: sellerID.String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ingress: %w", err)
+	}
+
+	record := &models.StreamIngress{
+		SellerID:  sellerID,
+		AuctionID: auctionID,
+		IngressID: ingress.ID,
+		Name:      name,
+		InputType: inputType,
+		URL:       ingress.URL,
+		StreamKey: ingress.StreamKey,
+		RoomName:  roomName,
+		Status:    IngressInactive,
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+		if auctionID != nil {
+			return attachStreamKey(tx, *auctionID, record.StreamKey)
+		}
+		return nil
+	})
+	if err != nil {
+		// Do not leave a working stream key behind that nothing tracks
+		_ = s.provider.DeleteIngress(ctx, ingress.ID)
+		return nil, fmt.Errorf("failed to save ingress: %w", err)
+	}
+
+	s.logger.Info("Stream ingress created", map[string]interface{}{
+		"seller_id":  sellerID,
+		"ingress_id": ingress.ID,
+		"input_type": inputType,
+		"room_name":  roomName,
+	})
+
+	return record, nil
+}
+
+// ListIngresses returns a seller's ingresses that have not been revoked
+func (s *Service) ListIngresses(ctx context.Context, sellerID uuid.UUID) ([]models.StreamIngress, error) {
+	var ingresses []models.StreamIngress
+	err := s.db.WithContext(ctx).
+		Where("seller_id = ? AND status <> ?", sellerID, "revoked").
+		Order("created_at DESC").
+		Find(&ingresses).Error
+	return ingresses, err
+}
+
+// RotateIngressKey replaces the stream key of an ingress. The provider cannot change a
+// key in place, so the endpoint is recreated with the same settings; an encoder still
+// using the old key is disconnected.
+func (s *Service) RotateIngressKey(ctx context.Context, sellerID, id uuid.UUID) (*models.StreamIngress, error) {
+	record, err := s.sellerIngress(ctx, sellerID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	ingress, err := s.provider.CreateIngress(ctx, IngressOptions{
+		Name:                record.Name,
+		InputType:           record.InputType,
+		RoomName:            record.RoomName,
+		ParticipantIdentity: sellerID.String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ingress: %w", err)
+	}
+
+	if err := s.provider.DeleteIngress(ctx, record.IngressID); err != nil && !errors.Is(err, ErrIngressNotFound) {
+		_ = s.provider.DeleteIngress(ctx, ingress.ID)
+		return nil, fmt.Errorf("failed to delete previous ingress: %w", err)
+	}
+
+	now := time.Now()
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(record).Updates(map[string]interface{}{
+			"ingress_id": ingress.ID,
+			"url":        ingress.URL,
+			"stream_key": ingress.StreamKey,
+			"status":     IngressInactive,
+			"error":      nil,
+			"rotated_at": now,
+		}).Error; err != nil {
+			return err
+		}
+		if record.AuctionID != nil {
+			return attachStreamKey(tx, *record.AuctionID, ingress.StreamKey)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	record.IngressID = ingress.ID
+	record.URL = ingress.URL
+	record.StreamKey = ingress.StreamKey
+	record.Status = IngressInactive
+	record.Error = nil
+	record.RotatedAt = &now
+
+	s.logger.Info("Stream ingress key rotated", map[string]interface{}{
+		"seller_id":  sellerID,
+		"id":         record.ID,
+		"ingress_id": ingress.ID,
+	})
+
+	return record, nil
+}
+
+// RevokeIngress permanently disables an ingress and its stream key
+func (s *Service) RevokeIngress(ctx context.Context, sellerID, id uuid.UUID) error {
+	record, err := s.sellerIngress(ctx, sellerID, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.provider.DeleteIngress(ctx, record.IngressID); err != nil && !errors.Is(err, ErrIngressNotFound) {
+		return fmt.Errorf("failed to delete ingress: %w", err)
+	}
+
+	now := time.Now()
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if record.AuctionID != nil {
+			if err := detachStreamKey(tx, *record.AuctionID); err != nil {
+				return err
+			}
+		}
+		return tx.Model(record).Updates(map[string]interface{}{
+			"status":     "revoked",
+			"auction_id": nil,
+			"revoked_at": now,
+		}).Error
+	})
+}
+
+// AttachIngress points an ingress at an auction's room, or back at the seller's
+// staging room when auctionID is nil. Any other ingress attached to the auction is
+// detached.
+func (s *Service) AttachIngress(ctx context.Context, sellerID, id uuid.UUID, auctionID *uuid.UUID) (*models.StreamIngress, error) {
+	record, err := s.sellerIngress(ctx, sellerID, id)
+	if err != nil {
+		return nil, err
+	}
+	if record.Status == IngressPublishing || record.Status == IngressBuffering {
+		return nil, ErrIngressBusy
+	}
+
+	roomName := SellerRoomName(sellerID)
+	if auctionID != nil {
+		auction, err := s.sellerAuction(ctx, sellerID, *auctionID)
+		if err != nil {
+			return nil, err
+		}
+		roomName = RoomName(auction.ID)
+
+		if err := s.detachOtherIngresses(ctx, *auctionID, record.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.provider.UpdateIngress(ctx, record.IngressID, IngressOptions{
+		Name:                record.Name,
+		RoomName:            roomName,
+		ParticipantIdentity: sellerID.String(),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to update ingress: %w", err)
+	}
+
+	previous := record.AuctionID
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if previous != nil {
+			if err := detachStreamKey(tx, *previous); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(record).Updates(map[string]interface{}{
+			"auction_id": auctionID,
+			"room_name":  roomName,
+		}).Error; err != nil {
+			return err
+		}
+		if auctionID != nil {
+			return attachStreamKey(tx, *auctionID, record.StreamKey)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	record.AuctionID = auctionID
+	record.RoomName = roomName
+	return record, nil
+}
+
+// applyIngressEvent records the encoder connection state reported by the provider and
+// marks the attached auction's stream live once media arrives
+func (s *Service) applyIngressEvent(ctx context.Context, event *StreamEvent) error {
+	var record models.StreamIngress
+	err := s.db.WithContext(ctx).First(&record, "ingress_id = ?", event.Ingress.ID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if record.Status == "revoked" {
+		return nil
+	}
+
+	status := event.Ingress.Status
+	if status == IngressComplete {
+		status = IngressInactive
+	}
+	if status == "" {
+		if event.Type == EventIngressStarted {
+			status = IngressPublishing
+		} else {
+			status = IngressInactive
+		}
+	}
+
+	updates := map[string]interface{}{"status": status}
+	switch status {
+	case IngressPublishing:
+		updates["last_connected_at"] = time.Now()
+		updates["error"] = nil
+	case IngressError:
+		updates["error"] = event.Ingress.Error
+		s.logger.Warn("Stream ingress reported an error", map[string]interface{}{
+			"ingress_id": record.IngressID,
+			"seller_id":  record.SellerID,
+			"error":      event.Ingress.Error,
+		})
+	}
+
+	if err := s.db.WithContext(ctx).Model(&record).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	if status == IngressPublishing && record.AuctionID != nil {
+		return s.markStreamLive(ctx, *record.AuctionID)
+	}
+	return nil
+}
+
+// sellerIngress loads an ingress owned by the seller that can still be managed
+func (s *Service) sellerIngress(ctx context.Context, sellerID, id uuid.UUID) (*models.StreamIngress, error) {
+	var record models.StreamIngress
+	if err := s.db.WithContext(ctx).First(&record, "id = ? AND seller_id = ?", id, sellerID).Error; err != nil {
+		return nil, err
+	}
+	if record.Status == "revoked" {
+		return nil, ErrIngressRevoked
+	}
+	return &record, nil
+}
+
+// sellerAuction loads an auction that an ingress of the seller may publish to
+func (s *Service) sellerAuction(ctx context.Context, sellerID, auctionID uuid.UUID) (*models.Auction, error) {
+	var auction models.Auction
+	if err := s.db.WithContext(ctx).Select("id", "seller_id").First(&auction, "id = ?", auctionID).Error; err != nil {
+		return nil, err
+	}
+	if auction.SellerID != sellerID {
+		return nil, ErrAuctionNotOwned
+	}
+	return &auction, nil
+}
+
+// detachOtherIngresses moves any other ingress attached to an auction back to its
+// seller's staging room, so only one encoder feeds a show
+func (s *Service) detachOtherIngresses(ctx context.Context, auctionID, exceptID uuid.UUID) error {
+	var others []models.StreamIngress
+	if err := s.db.WithContext(ctx).
+		Where("auction_id = ? AND id <> ? AND status <> ?", auctionID, exceptID, "revoked").
+		Find(&others).Error; err != nil {
+		return err
+	}
+
+	for _, other := range others {
+		if other.Status == IngressPublishing || other.Status == IngressBuffering {
+			return ErrIngressBusy
+		}
+		roomName := SellerRoomName(other.SellerID)
+		if err := s.provider.UpdateIngress(ctx, other.IngressID, IngressOptions{
+			Name:                other.Name,
+			RoomName:            roomName,
+			ParticipantIdentity: other.SellerID.String(),
+		}); err != nil && !errors.Is(err, ErrIngressNotFound) {
+			return fmt.Errorf("failed to update ingress: %w", err)
+		}
+		if err := s.db.WithContext(ctx).Model(&other).Updates(map[string]interface{}{
+			"auction_id": nil,
+			"room_name":  roomName,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attachStreamKey copies the ingress key onto the auction and its stream record
+func attachStreamKey(tx *gorm.DB, auctionID uuid.UUID, key string) error {
+	if err := tx.Model(&models.Auction{}).Where("id = ?", auctionID).Update("stream_key", key).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.LiveStream{}).Where("auction_id = ?", auctionID).Update("stream_key", key).Error
+}
+
+// detachStreamKey clears the ingress key from an auction and its stream record
+func detachStreamKey(tx *gorm.DB, auctionID uuid.UUID) error {
+	if err := tx.Model(&models.Auction{}).Where("id = ?", auctionID).Update("stream_key", nil).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.LiveStream{}).Where("auction_id = ?", auctionID).Update("stream_key", "").Error
+}
@@ -0,0 +1,449 @@
+package livekit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/livekit/protocol/auth"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/webhook"
+	"github.com/twitchtv/twirp"
+)
+
+// LiveKitProvider talks to a LiveKit server through its Twirp API
+type LiveKitProvider struct {
+	host        string
+	apiKey      string
+	apiSecret   string
+	roomService livekit.RoomService
+	egress      livekit.Egress
+	ingress     livekit.Ingress
+}
+
+// NewLiveKitProvider creates a provider for the LiveKit server at host
+func NewLiveKitProvider(host, apiKey, apiSecret string) *LiveKitProvider {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return &LiveKitProvider{
+		host:        host,
+		apiKey:      apiKey,
+		apiSecret:   apiSecret,
+		roomService: livekit.NewRoomServiceProtobufClient(toHTTPURL(host), client),
+		egress:      livekit.NewEgressProtobufClient(toHTTPURL(host), client),
+		ingress:     livekit.NewIngressProtobufClient(toHTTPURL(host), client),
+	}
+}
+
+// Name returns the provider identifier
+func (p *LiveKitProvider) Name() string {
+	return "livekit"
+}
+
+// URL returns the LiveKit server URL
+func (p *LiveKitProvider) URL() string {
+	return p.host
+}
+
+// CreateRoom creates a LiveKit room; LiveKit returns the existing room if it is already open
+func (p *LiveKitProvider) CreateRoom(ctx context.Context, options RoomOptions) (*Room, error) {
+	ctx, err := p.withAuth(ctx, auth.VideoGrant{RoomCreate: true})
+	if err != nil {
+		return nil, err
+	}
+
+	room, err := p.roomService.CreateRoom(ctx, &livekit.CreateRoomRequest{
+		Name:            options.Name,
+		EmptyTimeout:    uint32(options.EmptyTimeout.Seconds()),
+		MaxParticipants: options.MaxParticipants,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Room{
+		Name:      room.Name,
+		SID:       room.Sid,
+		CreatedAt: time.Unix(room.CreationTime, 0),
+	}, nil
+}
+
+// DeleteRoom closes a LiveKit room
+func (p *LiveKitProvider) DeleteRoom(ctx context.Context, roomName string) error {
+	ctx, err := p.withAuth(ctx, auth.VideoGrant{RoomCreate: true})
+	if err != nil {
+		return err
+	}
+
+	_, err = p.roomService.DeleteRoom(ctx, &livekit.DeleteRoomRequest{Room: roomName})
+	return mapTwirpError(err)
+}
+
+// ListParticipants returns the participants currently in a LiveKit room
+func (p *LiveKitProvider) ListParticipants(ctx context.Context, roomName string) ([]*ParticipantInfo, error) {
+	ctx, err := p.withAuth(ctx, auth.VideoGrant{RoomAdmin: true, Room: roomName})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := p.roomService.ListParticipants(ctx, &livekit.ListParticipantsRequest{Room: roomName})
+	if err != nil {
+		return nil, mapTwirpError(err)
+	}
+
+	participants := make([]*ParticipantInfo, 0, len(res.Participants))
+	for _, participant := range res.Participants {
+		participants = append(participants, &ParticipantInfo{
+			SID:         participant.Sid,
+			Identity:    participant.Identity,
+			Name:        participant.Name,
+			IsPublisher: participant.Permission != nil && participant.Permission.CanPublish,
+			JoinedAt:    time.Unix(participant.JoinedAt, 0),
+		})
+	}
+
+	return participants, nil
+}
+
+// RemoveParticipant disconnects a participant from a LiveKit room
+func (p *LiveKitProvider) RemoveParticipant(ctx context.Context, roomName, identity string) error {
+	ctx, err := p.withAuth(ctx, auth.VideoGrant{RoomAdmin: true, Room: roomName})
+	if err != nil {
+		return err
+	}
+
+	_, err = p.roomService.RemoveParticipant(ctx, &livekit.RoomParticipantIdentity{
+		Room:     roomName,
+		Identity: identity,
+	})
+	return mapTwirpError(err)
+}
+
+// MuteParticipant mutes or unmutes every track a participant has published
+func (p *LiveKitProvider) MuteParticipant(ctx context.Context, roomName, identity string, muted bool) error {
+	ctx, err := p.withAuth(ctx, auth.VideoGrant{RoomAdmin: true, Room: roomName})
+	if err != nil {
+		return err
+	}
+
+	participant, err := p.roomService.GetParticipant(ctx, &livekit.RoomParticipantIdentity{
+		Room:     roomName,
+		Identity: identity,
+	})
+	if err != nil {
+		return mapTwirpError(err)
+	}
+
+	for _, track := range participant.Tracks {
+		_, err := p.roomService.MutePublishedTrack(ctx, &livekit.MuteRoomTrackRequest{
+			Room:     roomName,
+			Identity: identity,
+			TrackSid: track.Sid,
+			Muted:    muted,
+		})
+		if err != nil {
+			return mapTwirpError(err)
+		}
+	}
+
+	return nil
+}
+
+// UpdateParticipantGrant changes the publish permissions of a connected participant
+func (p *LiveKitProvider) UpdateParticipantGrant(ctx context.Context, roomName, identity string, grant TokenGrant) error {
+	ctx, err := p.withAuth(ctx, auth.VideoGrant{RoomAdmin: true, Room: roomName})
+	if err != nil {
+		return err
+	}
+
+	permission := &livekit.ParticipantPermission{
+		CanSubscribe:   grant.CanSubscribe,
+		CanPublish:     grant.CanPublish,
+		CanPublishData: grant.CanPublishData,
+		Hidden:         grant.Hidden,
+	}
+	for _, source := range grant.PublishSources {
+		if value, ok := livekit.TrackSource_value[strings.ToUpper(source)]; ok {
+			permission.CanPublishSources = append(permission.CanPublishSources, livekit.TrackSource(value))
+		}
+	}
+
+	_, err = p.roomService.UpdateParticipant(ctx, &livekit.UpdateParticipantRequest{
+		Room:       roomName,
+		Identity:   identity,
+		Permission: permission,
+	})
+	return mapTwirpError(err)
+}
+
+// IssueToken signs a LiveKit access token
+func (p *LiveKitProvider) IssueToken(identity, name string, grant TokenGrant, validFor time.Duration) (string, error) {
+	videoGrant := &auth.VideoGrant{
+		RoomJoin:  true,
+		Room:      grant.Room,
+		RoomAdmin: grant.RoomAdmin,
+		Hidden:    grant.Hidden,
+	}
+	videoGrant.SetCanPublish(grant.CanPublish)
+	videoGrant.SetCanPublishData(grant.CanPublishData)
+	videoGrant.SetCanSubscribe(grant.CanSubscribe)
+	if len(grant.PublishSources) > 0 {
+		videoGrant.CanPublishSources = grant.PublishSources
+	}
+
+	at := auth.NewAccessToken(p.apiKey, p.apiSecret)
+	at.SetIdentity(identity)
+	if name != "" {
+		at.SetName(name)
+	}
+	at.SetVideoGrant(videoGrant)
+	at.SetValidFor(validFor)
+
+	return at.ToJWT()
+}
+
+// StartRecording starts a room composite egress writing an MP4 file
+func (p *LiveKitProvider) StartRecording(ctx context.Context, roomName, filepath string) (string, error) {
+	ctx, err := p.withAuth(ctx, auth.VideoGrant{RoomRecord: true})
+	if err != nil {
+		return "", err
+	}
+
+	info, err := p.egress.StartRoomCompositeEgress(ctx, &livekit.RoomCompositeEgressRequest{
+		RoomName: roomName,
+		Layout:   "speaker",
+		FileOutputs: []*livekit.EncodedFileOutput{
+			{
+				FileType: livekit.EncodedFileType_MP4,
+				Filepath: filepath,
+			},
+		},
+	})
+	if err != nil {
+		return "", mapTwirpError(err)
+	}
+
+	return info.EgressId, nil
+}
+
+// StopRecording stops a running egress
+func (p *LiveKitProvider) StopRecording(ctx context.Context, egressID string) error {
+	ctx, err := p.withAuth(ctx, auth.VideoGrant{RoomRecord: true})
+	if err != nil {
+		return err
+	}
+
+	_, err = p.egress.StopEgress(ctx, &livekit.StopEgressRequest{EgressId: egressID})
+	return err
+}
+
+// CreateIngress creates an RTMP or WHIP ingress publishing as the given participant
+func (p *LiveKitProvider) CreateIngress(ctx context.Context, options IngressOptions) (*Ingress, error) {
+	inputType, err := ingressInput(options.InputType)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, err = p.withAuth(ctx, auth.VideoGrant{IngressAdmin: true})
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := p.ingress.CreateIngress(ctx, &livekit.CreateIngressRequest{
+		InputType:           inputType,
+		Name:                options.Name,
+		RoomName:            options.RoomName,
+		ParticipantIdentity: options.ParticipantIdentity,
+		ParticipantName:     options.ParticipantName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Ingress{
+		ID:        info.IngressId,
+		URL:       info.Url,
+		StreamKey: info.StreamKey,
+	}, nil
+}
+
+// UpdateIngress changes the room and participant of an idle ingress
+func (p *LiveKitProvider) UpdateIngress(ctx context.Context, ingressID string, options IngressOptions) error {
+	ctx, err := p.withAuth(ctx, auth.VideoGrant{IngressAdmin: true})
+	if err != nil {
+		return err
+	}
+
+	_, err = p.ingress.UpdateIngress(ctx, &livekit.UpdateIngressRequest{
+		IngressId:           ingressID,
+		Name:                options.Name,
+		RoomName:            options.RoomName,
+		ParticipantIdentity: options.ParticipantIdentity,
+		ParticipantName:     options.ParticipantName,
+	})
+	return mapIngressError(err)
+}
+
+// DeleteIngress removes an ingress and disconnects its encoder
+func (p *LiveKitProvider) DeleteIngress(ctx context.Context, ingressID string) error {
+	ctx, err := p.withAuth(ctx, auth.VideoGrant{IngressAdmin: true})
+	if err != nil {
+		return err
+	}
+
+	_, err = p.ingress.DeleteIngress(ctx, &livekit.DeleteIngressRequest{IngressId: ingressID})
+	return mapIngressError(err)
+}
+
+// ParseWebhook verifies a LiveKit webhook signed with the API key and secret
+func (p *LiveKitProvider) ParseWebhook(r *http.Request) (*StreamEvent, error) {
+	event, err := webhook.ReceiveWebhookEvent(r, auth.NewSimpleKeyProvider(p.apiKey, p.apiSecret))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+	}
+
+	streamEvent := &StreamEvent{
+		ID:        event.Id,
+		Type:      event.Event,
+		CreatedAt: time.Unix(event.CreatedAt, 0),
+	}
+	if event.Room != nil {
+		streamEvent.Room = event.Room.Name
+	}
+	if event.Participant != nil {
+		streamEvent.Participant = &EventParticipant{
+			Identity: event.Participant.Identity,
+			Name:     event.Participant.Name,
+			Recorder: event.Participant.Kind == livekit.ParticipantInfo_EGRESS ||
+				(event.Participant.Permission != nil && event.Participant.Permission.Recorder),
+		}
+	}
+	if event.Track != nil {
+		streamEvent.Track = &EventTrack{
+			SID:    event.Track.Sid,
+			Type:   strings.ToLower(event.Track.Type.String()),
+			Source: strings.ToLower(event.Track.Source.String()),
+		}
+	}
+	if event.EgressInfo != nil {
+		egress := &EgressEvent{
+			ID:     event.EgressInfo.EgressId,
+			Status: egressStatus(event.EgressInfo.Status),
+			Error:  event.EgressInfo.Error,
+		}
+		for _, file := range event.EgressInfo.FileResults {
+			egress.Files = append(egress.Files, EgressFile{
+				Filename: file.Filename,
+				Location: file.Location,
+				Size:     file.Size,
+				Duration: time.Duration(file.Duration),
+			})
+		}
+		if streamEvent.Room == "" {
+			streamEvent.Room = event.EgressInfo.RoomName
+		}
+		streamEvent.Egress = egress
+	}
+
+	if event.IngressInfo != nil {
+		ingress := &IngressEvent{ID: event.IngressInfo.IngressId}
+		if state := event.IngressInfo.State; state != nil {
+			ingress.Status = ingressStatus(state.Status)
+			ingress.Error = state.Error
+		}
+		if streamEvent.Room == "" {
+			streamEvent.Room = event.IngressInfo.RoomName
+		}
+		streamEvent.Ingress = ingress
+	}
+
+	return streamEvent, nil
+}
+
+// ingressInput maps provider-neutral ingress types to LiveKit input types
+func ingressInput(inputType string) (livekit.IngressInput, error) {
+	switch inputType {
+	case IngressRTMP:
+		return livekit.IngressInput_RTMP_INPUT, nil
+	case IngressWHIP:
+		return livekit.IngressInput_WHIP_INPUT, nil
+	default:
+		return 0, fmt.Errorf("unsupported ingress type %q", inputType)
+	}
+}
+
+// ingressStatus maps LiveKit ingress states to provider-neutral values
+func ingressStatus(status livekit.IngressState_Status) string {
+	switch status {
+	case livekit.IngressState_ENDPOINT_BUFFERING:
+		return IngressBuffering
+	case livekit.IngressState_ENDPOINT_PUBLISHING:
+		return IngressPublishing
+	case livekit.IngressState_ENDPOINT_ERROR:
+		return IngressError
+	case livekit.IngressState_ENDPOINT_COMPLETE:
+		return IngressComplete
+	default:
+		return IngressInactive
+	}
+}
+
+// egressStatus maps LiveKit egress statuses to provider-neutral values
+func egressStatus(status livekit.EgressStatus) string {
+	switch status {
+	case livekit.EgressStatus_EGRESS_STARTING:
+		return EgressStarting
+	case livekit.EgressStatus_EGRESS_COMPLETE, livekit.EgressStatus_EGRESS_LIMIT_REACHED:
+		return EgressComplete
+	case livekit.EgressStatus_EGRESS_FAILED:
+		return EgressFailed
+	case livekit.EgressStatus_EGRESS_ABORTED:
+		return EgressAborted
+	default:
+		return EgressActive
+	}
+}
+
+// withAuth attaches a short-lived server API token to the request context
+func (p *LiveKitProvider) withAuth(ctx context.Context, grant auth.VideoGrant) (context.Context, error) {
+	at := auth.NewAccessToken(p.apiKey, p.apiSecret)
+	at.SetVideoGrant(&grant)
+	at.SetValidFor(time.Minute)
+
+	token, err := at.ToJWT()
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign LiveKit API token: %w", err)
+	}
+
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer "+token)
+	return twirp.WithHTTPRequestHeaders(ctx, header)
+}
+
+// mapTwirpError converts LiveKit not-found responses to ErrRoomNotFound
+func mapTwirpError(err error) error {
+	var twirpErr twirp.Error
+	if errors.As(err, &twirpErr) && twirpErr.Code() == twirp.NotFound {
+		return fmt.Errorf("%w: %s", ErrRoomNotFound, twirpErr.Msg())
+	}
+	return err
+}
+
+// mapIngressError converts LiveKit not-found responses to ErrIngressNotFound
+func mapIngressError(err error) error {
+	var twirpErr twirp.Error
+	if errors.As(err, &twirpErr) && twirpErr.Code() == twirp.NotFound {
+		return fmt.Errorf("%w: %s", ErrIngressNotFound, twirpErr.Msg())
+	}
+	return err
+}
+
+// toHTTPURL converts a ws(s):// LiveKit URL to the http(s) form used by the API
+func toHTTPURL(url string) string {
+	if strings.HasPrefix(url, "ws") {
+		return strings.Replace(url, "ws", "http", 1)
+	}
+	return url
+}
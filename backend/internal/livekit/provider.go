@@ -0,0 +1,111 @@
+package livekit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrRoomNotFound is returned by a provider when the requested room does not exist
+var ErrRoomNotFound = errors.New("room not found")
+
+// ErrParticipantNotFound is returned by a provider when the participant is not in the room
+var ErrParticipantNotFound = errors.New("participant not found")
+
+// ErrIngressNotFound is returned by a provider when the requested ingress does not exist
+var ErrIngressNotFound = errors.New("ingress not found")
+
+// ErrInvalidWebhook is returned when a webhook request is unsigned or its signature does not match
+var ErrInvalidWebhook = errors.New("invalid webhook signature")
+
+// StreamProvider abstracts the media server used for live auction streams so the
+// streaming service can be exercised without a running LiveKit server
+type StreamProvider interface {
+	// Name returns the provider identifier, e.g. "livekit" or "fake"
+	Name() string
+	// URL returns the base URL clients connect to
+	URL() string
+	// CreateRoom creates a room, returning the existing room if it is already open
+	CreateRoom(ctx context.Context, options RoomOptions) (*Room, error)
+	// DeleteRoom closes a room and disconnects all participants
+	DeleteRoom(ctx context.Context, roomName string) error
+	// ListParticipants returns the participants currently in a room
+	ListParticipants(ctx context.Context, roomName string) ([]*ParticipantInfo, error)
+	// RemoveParticipant disconnects a participant from a room
+	RemoveParticipant(ctx context.Context, roomName, identity string) error
+	// MuteParticipant mutes or unmutes all tracks published by a participant
+	MuteParticipant(ctx context.Context, roomName, identity string, muted bool) error
+	// UpdateParticipantGrant changes what a connected participant may publish
+	UpdateParticipantGrant(ctx context.Context, roomName, identity string, grant TokenGrant) error
+	// IssueToken creates an access token for joining a room
+	IssueToken(identity, name string, grant TokenGrant, validFor time.Duration) (string, error)
+	// ParseWebhook verifies the signature of a webhook request and decodes its event
+	ParseWebhook(r *http.Request) (*StreamEvent, error)
+	// StartRecording starts a composite recording of a room to filepath and returns the egress ID
+	StartRecording(ctx context.Context, roomName, filepath string) (string, error)
+	// StopRecording stops an egress; the final state is reported by webhook
+	StopRecording(ctx context.Context, egressID string) error
+	// CreateIngress creates an RTMP or WHIP endpoint that publishes into a room
+	CreateIngress(ctx context.Context, options IngressOptions) (*Ingress, error)
+	// UpdateIngress moves an idle ingress to another room or participant
+	UpdateIngress(ctx context.Context, ingressID string, options IngressOptions) error
+	// DeleteIngress removes an ingress; its stream key stops working immediately
+	DeleteIngress(ctx context.Context, ingressID string) error
+}
+
+// RoomOptions describes a room to create
+type RoomOptions struct {
+	Name            string
+	EmptyTimeout    time.Duration
+	MaxParticipants uint32
+}
+
+// Room represents a media server room
+type Room struct {
+	Name      string    `json:"name"`
+	SID       string    `json:"sid"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TokenGrant describes what the holder of an access token may do in a room
+type TokenGrant struct {
+	Room           string
+	CanPublish     bool
+	CanPublishData bool
+	CanSubscribe   bool
+	RoomAdmin      bool
+	Hidden         bool
+	// PublishSources limits publishing to these track sources, e.g. camera or
+	// microphone; empty allows every source
+	PublishSources []string
+}
+
+// Track sources a participant may be allowed to publish
+const (
+	SourceCamera      = "camera"
+	SourceMicrophone  = "microphone"
+	SourceScreenShare = "screen_share"
+)
+
+// Ingress input types
+const (
+	IngressRTMP = "rtmp"
+	IngressWHIP = "whip"
+)
+
+// IngressOptions describes an ingress endpoint to create or update
+type IngressOptions struct {
+	Name                string
+	InputType           string // rtmp or whip
+	RoomName            string
+	ParticipantIdentity string
+	ParticipantName     string
+}
+
+// Ingress is an endpoint an external encoder such as OBS publishes to
+type Ingress struct {
+	ID        string
+	URL       string
+	StreamKey string
+}
@@ -0,0 +1,333 @@
+package livekit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrRecordingNotConfigured is returned when recording is requested without a storage backend
+var ErrRecordingNotConfigured = errors.New("recording storage is not configured")
+
+// ErrNoActiveRecording is returned when stopping a stream that is not being recorded
+var ErrNoActiveRecording = errors.New("stream is not being recorded")
+
+// RecordingEntitlements reports how long a seller's subscription plan keeps recordings
+type RecordingEntitlements interface {
+	RecordingRetention(ctx context.Context, sellerID uuid.UUID) (time.Duration, error)
+}
+
+// SetRecordingStorage configures where recordings are written and how long they are kept.
+// A zero retention keeps recordings forever.
+func (s *Service) SetRecordingStorage(storage RecordingStorage, retention time.Duration) {
+	s.storage = storage
+	s.retention = retention
+}
+
+// SetRecordingEntitlements makes each seller's plan decide how long their recordings are
+// kept, instead of the storage's retention
+func (s *Service) SetRecordingEntitlements(entitlements RecordingEntitlements) {
+	s.entitlements = entitlements
+}
+
+// recordingRetention returns how long a seller's recordings are kept; zero keeps them forever
+func (s *Service) recordingRetention(ctx context.Context, sellerID uuid.UUID) (time.Duration, error) {
+	if s.entitlements == nil {
+		return s.retention, nil
+	}
+	return s.entitlements.RecordingRetention(ctx, sellerID)
+}
+
+// RecordStream starts or stops recording a stream. The new recording is returned when started.
+func (s *Service) RecordStream(ctx context.Context, auctionID uuid.UUID, enabled bool) (*models.StreamRecording, error) {
+	if enabled {
+		return s.StartRecording(ctx, auctionID)
+	}
+	return nil, s.StopRecording(ctx, auctionID)
+}
+
+// StartRecording starts a room composite egress for the auction stream. Each call
+// creates a new recording segment; an already running recording is returned as is.
+func (s *Service) StartRecording(ctx context.Context, auctionID uuid.UUID) (*models.StreamRecording, error) {
+	if s.storage == nil {
+		return nil, ErrRecordingNotConfigured
+	}
+
+	var auction models.Auction
+	if err := s.db.WithContext(ctx).First(&auction, "id = ?", auctionID).Error; err != nil {
+		return nil, err
+	}
+
+	var active models.StreamRecording
+	err := s.db.WithContext(ctx).
+		Where("auction_id = ? AND status = ?", auctionID, "recording").
+		First(&active).Error
+	if err == nil {
+		return &active, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	startedAt := time.Now()
+	egressID, err := s.provider.StartRecording(ctx, RoomName(auction.ID), s.storage.OutputPath(auctionID, startedAt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start recording: %w", err)
+	}
+
+	recording := &models.StreamRecording{
+		AuctionID: auctionID,
+		SellerID:  auction.SellerID,
+		EgressID:  egressID,
+		Storage:   s.storage.Name(),
+		Status:    "recording",
+		StartedAt: startedAt,
+	}
+	if err := s.db.WithContext(ctx).Create(recording).Error; err != nil {
+		return nil, fmt.Errorf("failed to save recording: %w", err)
+	}
+
+	if err := s.db.WithContext(ctx).
+		Model(&models.LiveStream{}).
+		Where("auction_id = ?", auctionID).
+		Update("is_recording", true).Error; err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Stream recording started", map[string]interface{}{
+		"auction_id": auctionID,
+		"egress_id":  egressID,
+		"storage":    s.storage.Name(),
+	})
+
+	return recording, nil
+}
+
+// StopRecording stops the running recording of an auction stream. The recording
+// becomes ready once the provider reports the egress has finished.
+func (s *Service) StopRecording(ctx context.Context, auctionID uuid.UUID) error {
+	var recordings []models.StreamRecording
+	if err := s.db.WithContext(ctx).
+		Where("auction_id = ? AND status = ?", auctionID, "recording").
+		Find(&recordings).Error; err != nil {
+		return err
+	}
+	if len(recordings) == 0 {
+		return ErrNoActiveRecording
+	}
+
+	for _, recording := range recordings {
+		if err := s.provider.StopRecording(ctx, recording.EgressID); err != nil {
+			return fmt.Errorf("failed to stop recording: %w", err)
+		}
+		if err := s.db.WithContext(ctx).Model(&recording).Update("status", "stopping").Error; err != nil {
+			return err
+		}
+	}
+
+	return s.db.WithContext(ctx).
+		Model(&models.LiveStream{}).
+		Where("auction_id = ?", auctionID).
+		Update("is_recording", false).Error
+}
+
+// GenerateStreamRecordingURL returns the playback URL of the latest finished recording
+func (s *Service) GenerateStreamRecordingURL(ctx context.Context, auctionID uuid.UUID) (string, error) {
+	var recording models.StreamRecording
+	err := s.db.WithContext(ctx).
+		Where("auction_id = ? AND status = ?", auctionID, "ready").
+		Order("started_at DESC").
+		First(&recording).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", fmt.Errorf("no recording available for this stream")
+		}
+		return "", err
+	}
+
+	if recording.PlaybackURL == nil {
+		return "", fmt.Errorf("recording has no playback URL")
+	}
+	return *recording.PlaybackURL, nil
+}
+
+// ListSellerRecordings returns a seller's recordings, newest first
+func (s *Service) ListSellerRecordings(ctx context.Context, sellerID uuid.UUID, page, limit int) ([]models.StreamRecording, int64, error) {
+	var recordings []models.StreamRecording
+	var total int64
+
+	query := s.db.WithContext(ctx).
+		Model(&models.StreamRecording{}).
+		Where("seller_id = ? AND status <> ?", sellerID, "expired")
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * limit
+	err := query.
+		Preload("Auction", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id", "title", "status", "start_time", "end_time")
+		}).
+		Order("started_at DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&recordings).Error
+
+	return recordings, total, err
+}
+
+// PurgeExpiredRecordings deletes recordings past their retention period from storage
+// and marks them expired. It returns the number of recordings purged.
+func (s *Service) PurgeExpiredRecordings(ctx context.Context) (int, error) {
+	if s.storage == nil {
+		return 0, nil
+	}
+
+	var expired []models.StreamRecording
+	if err := s.db.WithContext(ctx).
+		Where("status = ? AND expires_at IS NOT NULL AND expires_at <= ?", "ready", time.Now()).
+		Find(&expired).Error; err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, recording := range expired {
+		if recording.Location != "" {
+			if err := s.storage.Delete(ctx, recording.Location); err != nil {
+				s.logger.Error("Failed to delete expired recording", map[string]interface{}{
+					"recording_id": recording.ID,
+					"location":     recording.Location,
+					"error":        err.Error(),
+				})
+				continue
+			}
+		}
+
+		if err := s.db.WithContext(ctx).Model(&recording).Updates(map[string]interface{}{
+			"status":       "expired",
+			"playback_url": nil,
+		}).Error; err != nil {
+			return purged, err
+		}
+
+		// Clips play from the recording, so they go with it
+		if err := s.db.WithContext(ctx).
+			Model(&models.HighlightClip{}).
+			Where("recording_id = ?", recording.ID).
+			Update("is_published", false).Error; err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	if purged > 0 {
+		s.logger.Info("Expired recordings purged", map[string]interface{}{
+			"count": purged,
+		})
+	}
+
+	return purged, nil
+}
+
+// applyEgressEvent updates the recording and stream from an egress event
+func (s *Service) applyEgressEvent(ctx context.Context, auctionID uuid.UUID, event *StreamEvent) error {
+	if event.Egress == nil {
+		return nil
+	}
+
+	var recording models.StreamRecording
+	err := s.db.WithContext(ctx).First(&recording, "egress_id = ?", event.Egress.ID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Egress started outside this service, e.g. from the LiveKit dashboard
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{}
+	switch event.Egress.Status {
+	case EgressComplete:
+		now := time.Now()
+		updates["status"] = "ready"
+		updates["ended_at"] = now
+		retention, err := s.recordingRetention(ctx, recording.SellerID)
+		if err != nil {
+			return err
+		}
+		if retention > 0 {
+			updates["expires_at"] = now.Add(retention)
+		}
+
+		var duration time.Duration
+		var size int64
+		for _, file := range event.Egress.Files {
+			duration += file.Duration
+			size += file.Size
+			if recording.Location == "" && file.Location != "" {
+				recording.Location = file.Location
+			}
+		}
+		updates["duration"] = int(duration.Seconds())
+		updates["size"] = size
+
+		if recording.Location != "" {
+			playbackURL := recording.Location
+			if s.storage != nil {
+				playbackURL = s.storage.PlaybackURL(recording.Location)
+			}
+			updates["location"] = recording.Location
+			updates["playback_url"] = playbackURL
+
+			if err := s.db.WithContext(ctx).
+				Model(&models.LiveStream{}).
+				Where("auction_id = ?", auctionID).
+				Update("recording_url", playbackURL).Error; err != nil {
+				return err
+			}
+		}
+
+	case EgressFailed, EgressAborted:
+		now := time.Now()
+		updates["status"] = "failed"
+		updates["ended_at"] = now
+		updates["error"] = event.Egress.Error
+
+		s.logger.Warn("Stream recording failed", map[string]interface{}{
+			"auction_id": auctionID,
+			"egress_id":  event.Egress.ID,
+			"error":      event.Egress.Error,
+		})
+
+	default:
+		return nil
+	}
+
+	if err := s.db.WithContext(ctx).Model(&recording).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	if event.Egress.Status == EgressComplete {
+		s.createLotClipAfterRecording(ctx, auctionID)
+	}
+
+	// The stream is only recording while a recording is still running
+	var running int64
+	if err := s.db.WithContext(ctx).
+		Model(&models.StreamRecording{}).
+		Where("auction_id = ? AND status = ?", auctionID, "recording").
+		Count(&running).Error; err != nil {
+		return err
+	}
+
+	return s.db.WithContext(ctx).
+		Model(&models.LiveStream{}).
+		Where("auction_id = ?", auctionID).
+		Update("is_recording", running > 0).Error
+}
@@ -2,240 +2,256 @@ package livekit
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/blytz.live.remake/backend/internal/models"
 	"github.com/blytz.live.remake/backend/pkg/logging"
 	"github.com/google/uuid"
-	"github.com/livekit/protocol/auth"
-	"github.com/livekit/protocol/livekit"
-	livekitSdk "github.com/livekit/server-sdk-go"
 	"gorm.io/gorm"
 )
 
 // Service provides LiveKit live streaming services
 type Service struct {
-	db        *gorm.DB
-	logger    logging.Logger
-	host      string
-	apiKey    string
-	apiSecret string
-	roomConf  *livekit.RoomConfiguration
+	db              *gorm.DB
+	logger          *logging.Logger
+	provider        StreamProvider
+	presence        *roomPresence
+	auctionEnder    AuctionEnder
+	hostGracePeriod time.Duration
+	storage         RecordingStorage
+	retention       time.Duration
+	entitlements    RecordingEntitlements
+	alertNotifier   AlertNotifier
+	healthRules     HealthRules
 }
 
-// NewService creates a new LiveKit service
-func NewService(db *gorm.DB, host, apiKey, apiSecret string) *Service {
-	logger := logging.NewLogger("livekit-service")
-	
+// NewService creates a new LiveKit service backed by the given stream provider
+func NewService(db *gorm.DB, provider StreamProvider) *Service {
 	return &Service{
-		db:        db,
-		logger:    logger,
-		host:      host,
-		apiKey:    apiKey,
-		apiSecret: apiSecret,
-		roomConf: &livekit.RoomConfiguration{
-			MaxParticipants: 1000, // Max viewers per auction
-		},
+		db:          db,
+		logger:      logging.NewLogger(),
+		provider:    provider,
+		presence:    newRoomPresence(),
+		healthRules: DefaultHealthRules(),
 	}
 }
 
-// CreateAuctionRoom creates a LiveKit room for an auction
+// Provider returns the stream provider used by the service
+func (s *Service) Provider() StreamProvider {
+	return s.provider
+}
+
+// RoomName returns the LiveKit room name for an auction. Rooms are always named from
+// the auction ID; models.Auction.LiveKitRoom holds the same name for display.
+func RoomName(auctionID uuid.UUID) string {
+	return fmt.Sprintf("auction-%s", auctionID.String())
+}
+
+// auctionIDFromRoom returns the auction an auction room was named for
+func auctionIDFromRoom(roomName string) (uuid.UUID, bool) {
+	id, ok := strings.CutPrefix(roomName, "auction-")
+	if !ok {
+		return uuid.Nil, false
+	}
+	auctionID, err := uuid.Parse(id)
+	return auctionID, err == nil
+}
+
+// CreateAuctionRoom creates a LiveKit room for an auction. It is safe to call more than
+// once; an existing room and stream record are reused.
 func (s *Service) CreateAuctionRoom(ctx context.Context, auctionID uuid.UUID) (*LiveKitRoom, error) {
-	roomName := fmt.Sprintf("auction-%s", auctionID.String())
-	
-	// Connect to LiveKit server
-	roomClient := livekitSdk.NewRoomServiceClient(s.host, s.apiKey, s.apiSecret)
-	
-	// Create room
-	room, err := roomClient.CreateRoom(ctx, &livekit.CreateRoomRequest{
-		Name:             roomName,
-		EmptyTimeout:      300, // 5 minutes
-		MaxParticipants:   1000,
-		EnabledCodecs: []*livekit.Codec{
-			{
-				Mime:     "video/vp8",
-				Disabled: false,
-			},
-			{
-				Mime:     "video/h264",
-				Disabled: false,
-			},
-			{
-				Mime:     "audio/opus",
-				Disabled: false,
-			},
-		},
+	var auction models.Auction
+	if err := s.db.WithContext(ctx).Select("id").First(&auction, "id = ?", auctionID).Error; err != nil {
+		return nil, err
+	}
+
+	roomName := RoomName(auctionID)
+
+	room, err := s.provider.CreateRoom(ctx, RoomOptions{
+		Name:            roomName,
+		EmptyTimeout:    5 * time.Minute,
+		MaxParticipants: 1000, // Max viewers per auction
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create LiveKit room: %w", err)
 	}
-	
-	// Create room record in database
-	liveKitRoom := &models.LiveStream{
-		AuctionID:   auctionID,
-		StreamURL:    fmt.Sprintf("%s/room/%s", s.host, roomName),
-		StreamKey:    uuid.New().String(),
-		Status:       "waiting",
-		ViewerCount:  0,
-		IsRecording:  true,
-		ThumbnailURL: nil,
-	}
-	
-	if err := s.db.WithContext(ctx).Create(liveKitRoom).Error; err != nil {
-		return nil, fmt.Errorf("failed to save live stream record: %w", err)
+
+	// Create room record in database unless one already exists
+	var liveStream models.LiveStream
+	err = s.db.WithContext(ctx).First(&liveStream, "auction_id = ?", auctionID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		playbackURL := fmt.Sprintf("%s/playback?room=%s", s.provider.URL(), roomName)
+		liveStream = models.LiveStream{
+			AuctionID:   auctionID,
+			StreamURL:   s.provider.URL(),
+			StreamKey:   uuid.New().String(),
+			PlaybackURL: &playbackURL,
+			Status:      "waiting",
+		}
+		if err := s.db.WithContext(ctx).Create(&liveStream).Error; err != nil {
+			return nil, fmt.Errorf("failed to save live stream record: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load live stream record: %w", err)
 	}
-	
-	// Generate room data
+
 	roomData := &LiveKitRoom{
-		RoomName:    room.Name,
-		RoomID:      room.Sid,
-		StreamURL:    liveKitRoom.StreamURL,
-		StreamKey:    liveKitRoom.StreamKey,
-		PlaybackURL:  fmt.Sprintf("%s/playback?room=%s", s.host, roomName),
-		Status:       liveKitRoom.Status,
-		CreatedAt:    room.CreationTime,
-		DatabaseID:   liveKitRoom.ID,
+		RoomName:   room.Name,
+		RoomID:     room.SID,
+		StreamURL:  liveStream.StreamURL,
+		StreamKey:  liveStream.StreamKey,
+		Status:     liveStream.Status,
+		CreatedAt:  room.CreatedAt,
+		DatabaseID: liveStream.ID,
+	}
+	if liveStream.PlaybackURL != nil {
+		roomData.PlaybackURL = *liveStream.PlaybackURL
 	}
-	
+
 	s.logger.Info("LiveKit room created", map[string]interface{}{
 		"auction_id": auctionID,
-		"room_name":  roomName,
-		"room_id":   room.Sid,
+		"room_name":  room.Name,
+		"room_id":    room.SID,
+		"provider":   s.provider.Name(),
 	})
-	
+
 	return roomData, nil
 }
 
+// ProvisionAuctionRoom creates the stream room for a newly created auction
+func (s *Service) ProvisionAuctionRoom(ctx context.Context, auctionID uuid.UUID) error {
+	_, err := s.CreateAuctionRoom(ctx, auctionID)
+	return err
+}
+
 // GenerateViewerToken generates a token for viewers to join auction stream
 func (s *Service) GenerateViewerToken(ctx context.Context, auctionID uuid.UUID, userID *uuid.UUID) (string, error) {
-	roomName := fmt.Sprintf("auction-%s", auctionID.String())
-	
-	// Set token permissions
-	grant := &auth.VideoGrant{
-		RoomJoin: true,
-		Room:      roomName,
-		// Viewers can only subscribe, not publish
-		CanPublish:   false,
+	// Viewers can only subscribe; signed-in viewers may also send data messages
+	grant := TokenGrant{
+		Room:         RoomName(auctionID),
 		CanSubscribe: true,
 	}
-	
-	// Add user identity if provided
-	identity := ""
+
+	// LiveKit requires an identity, so anonymous viewers get a random one
+	identity := "viewer-" + uuid.New().String()
 	if userID != nil {
 		identity = userID.String()
-		grant.CanPublish = true // Allow publishing chat messages
+		grant = viewerGrant(RoomName(auctionID))
 	}
-	
-	// Generate token
-	at := auth.NewAccessToken(s.apiKey, s.apiSecret)
-	at.SetIdentity(identity)
-	at.SetVideoGrant(grant)
-	at.SetValidFor(2 * time.Hour) // 2 hour validity
-	
-	token, err := at.ToJWT()
+
+	token, err := s.provider.IssueToken(identity, "", grant, 2*time.Hour) // 2 hour validity
 	if err != nil {
 		return "", fmt.Errorf("failed to generate viewer token: %w", err)
 	}
-	
+
 	return token, nil
 }
 
-// GenerateHostToken generates a token for auction host/seller
+// GenerateHostToken generates a token for auction host/seller. Only the auction's
+// seller is the host; co-hosts and guests use GenerateStageToken.
 func (s *Service) GenerateHostToken(ctx context.Context, auctionID, userID uuid.UUID) (string, error) {
-	roomName := fmt.Sprintf("auction-%s", auctionID.String())
-	
-	// Set token permissions for host
-	grant := &auth.VideoGrant{
-		RoomJoin:     true,
-		Room:         roomName,
-		CanPublish:   true,   // Can stream video/audio
-		CanSubscribe: true,   // Can see viewers
-		RoomAdmin:    true,    // Can manage room
+	if _, err := s.hostedAuction(ctx, auctionID, userID); err != nil {
+		return "", err
+	}
+
+	grant := TokenGrant{
+		Room:           RoomName(auctionID),
+		CanPublish:     true, // Can stream video/audio
+		CanPublishData: true,
+		CanSubscribe:   true, // Can see viewers
+		RoomAdmin:      true, // Can manage room
 	}
-	
-	// Generate token
-	at := auth.NewAccessToken(s.apiKey, s.apiSecret)
-	at.SetIdentity(userID.String())
-	at.SetName("auction-host")
-	at.SetVideoGrant(grant)
-	at.SetValidFor(4 * time.Hour) // 4 hour validity for auction
-	
-	token, err := at.ToJWT()
+
+	token, err := s.provider.IssueToken(userID.String(), "auction-host", grant, 4*time.Hour) // 4 hour validity for auction
 	if err != nil {
 		return "", fmt.Errorf("failed to generate host token: %w", err)
 	}
-	
+
 	return token, nil
 }
 
 // StartAuctionStream starts the auction stream
 func (s *Service) StartAuctionStream(ctx context.Context, auctionID uuid.UUID) error {
-	roomName := fmt.Sprintf("auction-%s", auctionID.String())
-	
+	roomName := RoomName(auctionID)
+
 	// Update database record
 	updates := map[string]interface{}{
-		"status":    "live",
+		"status":     "live",
 		"started_at": time.Now(),
 	}
-	
+
 	err := s.db.WithContext(ctx).
 		Model(&models.LiveStream{}).
 		Where("auction_id = ?", auctionID).
 		Updates(updates).Error
-	
+
 	if err != nil {
 		return fmt.Errorf("failed to update live stream status: %w", err)
 	}
-	
+
 	s.logger.Info("Auction stream started", map[string]interface{}{
 		"auction_id": auctionID,
 		"room_name":  roomName,
 	})
-	
+
 	return nil
 }
 
 // EndAuctionStream ends the auction stream
 func (s *Service) EndAuctionStream(ctx context.Context, auctionID uuid.UUID) error {
-	roomName := fmt.Sprintf("auction-%s", auctionID.String())
-	
+	roomName := RoomName(auctionID)
+
 	// Get stream record
 	var stream models.LiveStream
 	err := s.db.WithContext(ctx).First(&stream, "auction_id = ?", auctionID).Error
 	if err != nil {
 		return fmt.Errorf("stream not found: %w", err)
 	}
-	
+
 	// Calculate duration
 	duration := 0
 	if stream.StartedAt != nil {
 		duration = int(time.Since(*stream.StartedAt).Seconds())
 	}
-	
+
 	// Update database record
 	updates := map[string]interface{}{
-		"status":    "ended",
-		"ended_at":  time.Now(),
-		"duration":  duration,
+		"status":   "ended",
+		"ended_at": time.Now(),
+		"duration": duration,
 	}
-	
+
 	err = s.db.WithContext(ctx).Model(&stream).Updates(updates).Error
 	if err != nil {
 		return fmt.Errorf("failed to update live stream status: %w", err)
 	}
-	
-	// Optional: Delete LiveKit room to free resources
-	// roomClient := livekitSdk.NewRoomServiceClient(s.host, s.apiKey, s.apiSecret)
-	// roomClient.DeleteRoom(ctx, &livekit.DeleteRoomRequest{Room: roomName})
-	
+
+	// Finish any running recording before the room goes away
+	if err := s.StopRecording(ctx, auctionID); err != nil && !errors.Is(err, ErrNoActiveRecording) {
+		s.logger.Warn("Failed to stop stream recording", map[string]interface{}{
+			"auction_id": auctionID,
+			"error":      err.Error(),
+		})
+	}
+
+	// Close the room to free media server resources
+	if err := s.provider.DeleteRoom(ctx, roomName); err != nil && !errors.Is(err, ErrRoomNotFound) {
+		s.logger.Warn("Failed to close LiveKit room", map[string]interface{}{
+			"auction_id": auctionID,
+			"room_name":  roomName,
+			"error":      err.Error(),
+		})
+	}
+
 	s.logger.Info("Auction stream ended", map[string]interface{}{
 		"auction_id": auctionID,
 		"room_name":  roomName,
 		"duration":   duration,
 	})
-	
+
 	return nil
 }
 
@@ -246,7 +262,7 @@ func (s *Service) GetStreamInfo(ctx context.Context, auctionID uuid.UUID) (*mode
 		Preload("Auction").
 		Preload("Auction.Product").
 		First(&stream, "auction_id = ?", auctionID).Error
-	
+
 	return &stream, err
 }
 
@@ -256,23 +272,7 @@ func (s *Service) UpdateViewerCount(ctx context.Context, auctionID uuid.UUID, vi
 		Model(&models.LiveStream{}).
 		Where("auction_id = ?", auctionID).
 		Update("viewer_count", viewerCount).Error
-	
-	return err
-}
 
-// UpdateStreamMetrics updates stream performance metrics
-func (s *Service) UpdateStreamMetrics(ctx context.Context, auctionID uuid.UUID, metrics *StreamMetrics) error {
-	updates := map[string]interface{}{
-		"viewer_count": metrics.ViewerCount,
-		"latency":     metrics.Latency,
-		"bandwidth":   metrics.Bandwidth,
-	}
-	
-	err := s.db.WithContext(ctx).
-		Model(&models.LiveStream{}).
-		Where("auction_id = ?", auctionID).
-		Updates(updates).Error
-	
 	return err
 }
 
@@ -285,97 +285,51 @@ func (s *Service) ListActiveStreams(ctx context.Context) ([]models.LiveStream, e
 		Preload("Auction.Seller").
 		Where("status = ?", "live").
 		Find(&streams).Error
-	
-	return streams, err
-}
-
-// RecordStream enables recording for a stream
-func (s *Service) RecordStream(ctx context.Context, auctionID uuid.UUID, enabled bool) error {
-	updates := map[string]interface{}{
-		"is_recording": enabled,
-	}
-	
-	err := s.db.WithContext(ctx).
-		Model(&models.LiveStream{}).
-		Where("auction_id = ?", auctionID).
-		Updates(updates).Error
-	
-	return err
-}
 
-// GenerateStreamRecordingURL generates a URL for recorded stream
-func (s *Service) GenerateStreamRecordingURL(ctx context.Context, auctionID uuid.UUID) (string, error) {
-	var stream models.LiveStream
-	err := s.db.WithContext(ctx).First(&stream, "auction_id = ?", auctionID).Error
-	if err != nil {
-		return "", fmt.Errorf("stream not found: %w", err)
-	}
-	
-	if !stream.IsRecording {
-		return "", fmt.Errorf("recording not enabled for this stream")
-	}
-	
-	// Generate recording URL (this would integrate with your recording storage)
-	recordingURL := fmt.Sprintf("%s/recordings/%s.mp4", s.host, auctionID.String())
-	
-	// Update stream with recording URL
-	s.db.WithContext(ctx).
-		Model(&stream).
-		Update("recording_url", recordingURL)
-	
-	return recordingURL, nil
+	return streams, err
 }
 
 // LiveKitRoom represents LiveKit room information
 type LiveKitRoom struct {
 	RoomName    string    `json:"room_name"`
 	RoomID      string    `json:"room_id"`
-	StreamURL    string    `json:"stream_url"`
-	StreamKey    string    `json:"stream_key"`
-	PlaybackURL  string    `json:"playback_url"`
-	Status       string    `json:"status"`
-	CreatedAt    time.Time `json:"created_at"`
-	DatabaseID   uuid.UUID `json:"database_id"`
+	StreamURL   string    `json:"stream_url"`
+	StreamKey   string    `json:"stream_key"`
+	PlaybackURL string    `json:"playback_url"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+	DatabaseID  uuid.UUID `json:"database_id"`
 }
 
 // StreamMetrics represents stream performance metrics
 type StreamMetrics struct {
 	ViewerCount int  `json:"viewer_count"`
-	Latency     int  `json:"latency"`     // in milliseconds
-	Bandwidth   int  `json:"bandwidth"`   // in kbps
+	Latency     int  `json:"latency"`      // in milliseconds
+	Bandwidth   int  `json:"bandwidth"`    // in kbps
 	CPUUsage    int  `json:"cpu_usage"`    // percentage
 	MemoryUsage int  `json:"memory_usage"` // percentage
+	FrameRate   *int `json:"frame_rate"`   // video frames per second; unset when the client does not report it
 }
 
 // GetRoomParticipants gets current participants in a room
 func (s *Service) GetRoomParticipants(ctx context.Context, auctionID uuid.UUID) ([]*ParticipantInfo, error) {
-	roomName := fmt.Sprintf("auction-%s", auctionID.String())
-	
-	roomClient := livekitSdk.NewRoomServiceClient(s.host, s.apiKey, s.apiSecret)
-	
-	// Get room participants
-	participants, err := roomClient.ListParticipants(ctx, &livekit.ListParticipantsRequest{
-		Room: roomName,
-	})
-	
+	participants, err := s.provider.ListParticipants(ctx, RoomName(auctionID))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get room participants: %w", err)
 	}
-	
-	// Convert to our format
-	var participantInfos []*ParticipantInfo
-	for _, p := range participants.Participants {
-		info := &ParticipantInfo{
-			SID:       p.Sid,
-			Identity:  p.Identity,
-			Name:      p.Name,
-			IsPublisher: p.Permission != nil && p.Permission.CanPublish,
-			JoinedAt:   time.Unix(p.JoinedAt/1000, 0),
+
+	roles, err := s.stageRoles(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+	for _, participant := range participants {
+		participant.Role = RoleViewer
+		if role, ok := roles[participant.Identity]; ok {
+			participant.Role = role
 		}
-		participantInfos = append(participantInfos, info)
 	}
-	
-	return participantInfos, nil
+
+	return participants, nil
 }
 
 // ParticipantInfo represents a room participant
@@ -384,49 +338,29 @@ type ParticipantInfo struct {
 	Identity    string    `json:"identity"`
 	Name        string    `json:"name"`
 	IsPublisher bool      `json:"is_publisher"`
+	Role        string    `json:"role"` // host, cohost, guest, viewer
 	JoinedAt    time.Time `json:"joined_at"`
 }
 
 // RemoveParticipant removes a participant from a room
-func (s *Service) RemoveParticipant(ctx context.Context, auctionID uuid.UUID, participantSID string) error {
-	roomName := fmt.Sprintf("auction-%s", auctionID.String())
-	
-	roomClient := livekitSdk.NewRoomServiceClient(s.host, s.apiKey, s.apiSecret)
-	
-	// Remove participant
-	err := roomClient.RemoveParticipant(ctx, &livekit.RoomParticipantIdentity{
-		Room:        roomName,
-		Identity:     participantSID,
-	})
-	
-	if err != nil {
+func (s *Service) RemoveParticipant(ctx context.Context, auctionID uuid.UUID, identity string) error {
+	if err := s.provider.RemoveParticipant(ctx, RoomName(auctionID), identity); err != nil {
 		return fmt.Errorf("failed to remove participant: %w", err)
 	}
-	
+
 	s.logger.Info("Participant removed from auction room", map[string]interface{}{
-		"auction_id":      auctionID,
-		"participant_sid": participantSID,
+		"auction_id": auctionID,
+		"identity":   identity,
 	})
-	
+
 	return nil
 }
 
 // MuteParticipant mutes/unmutes a participant
-func (s *Service) MuteParticipant(ctx context.Context, auctionID uuid.UUID, participantSID string, muted bool) error {
-	roomName := fmt.Sprintf("auction-%s", auctionID.String())
-	
-	roomClient := livekitSdk.NewRoomServiceClient(s.host, s.apiKey, s.apiSecret)
-	
-	// Mute participant
-	_, err := roomClient.MutePublishedTrack(ctx, &livekit.MuteRoomTrackRequest{
-		Room:     roomName,
-		Identity:  participantSID,
-		Muted:    muted,
-	})
-	
-	if err != nil {
+func (s *Service) MuteParticipant(ctx context.Context, auctionID uuid.UUID, identity string, muted bool) error {
+	if err := s.provider.MuteParticipant(ctx, RoomName(auctionID), identity, muted); err != nil {
 		return fmt.Errorf("failed to mute participant: %w", err)
 	}
-	
+
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,417 @@
+package livekit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Stage roles. The auction seller is always the host; everyone else in the room is a
+// viewer unless they hold an active StreamParticipant record.
+const (
+	RoleHost   = "host"
+	RoleCohost = "cohost"
+	RoleGuest  = "guest"
+	RoleViewer = "viewer"
+)
+
+// ErrInsufficientRole is returned when a participant may not moderate another
+var ErrInsufficientRole = errors.New("insufficient role to moderate this participant")
+
+// ErrInvitationInvalid is returned for unknown, used or expired invitation codes
+var ErrInvitationInvalid = errors.New("invitation is invalid or has expired")
+
+// ErrNotOnStage is returned when requesting a stage token without an active stage role
+var ErrNotOnStage = errors.New("user is not on stage for this auction")
+
+// roleRank orders roles by moderation power
+var roleRank = map[string]int{
+	RoleViewer: 0,
+	RoleGuest:  1,
+	RoleCohost: 2,
+	RoleHost:   3,
+}
+
+// stageGrant returns the publish rights of a stage role. Co-hosts may share their
+// screen; guests are limited to camera and microphone. Neither gets room admin,
+// moderation goes through the API so roles are enforced.
+func stageGrant(roomName, role string) TokenGrant {
+	grant := TokenGrant{
+		Room:           roomName,
+		CanPublish:     true,
+		CanPublishData: true,
+		CanSubscribe:   true,
+		PublishSources: []string{SourceCamera, SourceMicrophone},
+	}
+	if role == RoleCohost {
+		grant.PublishSources = append(grant.PublishSources, SourceScreenShare)
+	}
+	return grant
+}
+
+// viewerGrant returns the rights of a signed-in viewer
+func viewerGrant(roomName string) TokenGrant {
+	return TokenGrant{
+		Room:           roomName,
+		CanPublishData: true,
+		CanSubscribe:   true,
+	}
+}
+
+// InviteToStage creates an invitation to join the auction stage as co-host or guest.
+// Without a user ID anyone holding the invitation code can accept it.
+func (s *Service) InviteToStage(ctx context.Context, auctionID, hostID uuid.UUID, role string, userID *uuid.UUID) (*models.StreamParticipant, error) {
+	auction, err := s.hostedAuction(ctx, auctionID, hostID)
+	if err != nil {
+		return nil, err
+	}
+	if userID != nil && *userID == auction.SellerID {
+		return nil, fmt.Errorf("the host is already on stage")
+	}
+
+	code := strings.ReplaceAll(uuid.New().String(), "-", "")
+	expiresAt := auction.EndTime
+	if expiresAt.Before(time.Now()) {
+		expiresAt = time.Now().Add(24 * time.Hour)
+	}
+
+	participant := &models.StreamParticipant{
+		AuctionID:  auctionID,
+		UserID:     userID,
+		Role:       role,
+		Status:     "invited",
+		InviteCode: &code,
+		InvitedBy:  &hostID,
+		ExpiresAt:  &expiresAt,
+	}
+	if err := s.db.WithContext(ctx).Create(participant).Error; err != nil {
+		return nil, fmt.Errorf("failed to create invitation: %w", err)
+	}
+
+	s.logger.Info("Stage invitation created", map[string]interface{}{
+		"auction_id": auctionID,
+		"role":       role,
+		"user_id":    userID,
+	})
+
+	return participant, nil
+}
+
+// RequestStage records a viewer's request to be brought on stage as a guest
+func (s *Service) RequestStage(ctx context.Context, auctionID, userID uuid.UUID) (*models.StreamParticipant, error) {
+	var auction models.Auction
+	if err := s.db.WithContext(ctx).Select("id", "seller_id").First(&auction, "id = ?", auctionID).Error; err != nil {
+		return nil, err
+	}
+	if auction.SellerID == userID {
+		return nil, fmt.Errorf("the host is already on stage")
+	}
+
+	var existing models.StreamParticipant
+	err := s.db.WithContext(ctx).
+		Where("auction_id = ? AND user_id = ? AND status IN ?", auctionID, userID, []string{"requested", "invited", "active"}).
+		First(&existing).Error
+	if err == nil {
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	participant := &models.StreamParticipant{
+		AuctionID: auctionID,
+		UserID:    &userID,
+		Role:      RoleGuest,
+		Status:    "requested",
+	}
+	if err := s.db.WithContext(ctx).Create(participant).Error; err != nil {
+		return nil, fmt.Errorf("failed to create stage request: %w", err)
+	}
+	return participant, nil
+}
+
+// AcceptInvitation puts the user on stage and returns a token carrying the stage grant
+func (s *Service) AcceptInvitation(ctx context.Context, code string, userID uuid.UUID) (*models.StreamParticipant, string, error) {
+	participant, err := s.pendingInvitation(ctx, code, userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	if err := s.db.WithContext(ctx).Model(participant).Updates(map[string]interface{}{
+		"user_id":     userID,
+		"status":      "active",
+		"invite_code": nil,
+		"accepted_at": now,
+	}).Error; err != nil {
+		return nil, "", err
+	}
+	participant.UserID = &userID
+	participant.Status = "active"
+	participant.InviteCode = nil
+	participant.AcceptedAt = &now
+
+	// A viewer already in the room is upgraded without reconnecting
+	s.updateLiveGrant(ctx, participant.AuctionID, userID, stageGrant(RoomName(participant.AuctionID), participant.Role))
+
+	token, err := s.GenerateStageToken(ctx, participant.AuctionID, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	return participant, token, nil
+}
+
+// DeclineInvitation turns down a stage invitation
+func (s *Service) DeclineInvitation(ctx context.Context, code string, userID uuid.UUID) error {
+	participant, err := s.pendingInvitation(ctx, code, userID)
+	if err != nil {
+		return err
+	}
+
+	return s.db.WithContext(ctx).Model(participant).Updates(map[string]interface{}{
+		"user_id":     userID,
+		"status":      "declined",
+		"invite_code": nil,
+	}).Error
+}
+
+// ApproveStageRequest brings a viewer who asked to join on stage
+func (s *Service) ApproveStageRequest(ctx context.Context, auctionID, hostID, participantID uuid.UUID, role string) (*models.StreamParticipant, error) {
+	if _, err := s.hostedAuction(ctx, auctionID, hostID); err != nil {
+		return nil, err
+	}
+
+	var participant models.StreamParticipant
+	if err := s.db.WithContext(ctx).
+		First(&participant, "id = ? AND auction_id = ? AND status = ?", participantID, auctionID, "requested").Error; err != nil {
+		return nil, err
+	}
+
+	if role == "" {
+		role = participant.Role
+	}
+	now := time.Now()
+	if err := s.db.WithContext(ctx).Model(&participant).Updates(map[string]interface{}{
+		"role":        role,
+		"status":      "active",
+		"invited_by":  hostID,
+		"accepted_at": now,
+	}).Error; err != nil {
+		return nil, err
+	}
+	participant.Role = role
+	participant.Status = "active"
+	participant.InvitedBy = &hostID
+	participant.AcceptedAt = &now
+
+	s.updateLiveGrant(ctx, auctionID, *participant.UserID, stageGrant(RoomName(auctionID), role))
+
+	return &participant, nil
+}
+
+// RevokeStage takes a co-host or guest off stage, or withdraws a pending invitation.
+// A connected participant keeps watching with viewer rights.
+func (s *Service) RevokeStage(ctx context.Context, auctionID, hostID, participantID uuid.UUID) error {
+	if _, err := s.hostedAuction(ctx, auctionID, hostID); err != nil {
+		return err
+	}
+
+	var participant models.StreamParticipant
+	if err := s.db.WithContext(ctx).
+		First(&participant, "id = ? AND auction_id = ? AND status IN ?", participantID, auctionID, []string{"requested", "invited", "active"}).Error; err != nil {
+		return err
+	}
+
+	wasActive := participant.Status == "active"
+	if err := s.db.WithContext(ctx).Model(&participant).Updates(map[string]interface{}{
+		"status":      "revoked",
+		"invite_code": nil,
+		"revoked_at":  time.Now(),
+	}).Error; err != nil {
+		return err
+	}
+
+	if wasActive && participant.UserID != nil {
+		s.updateLiveGrant(ctx, auctionID, *participant.UserID, viewerGrant(RoomName(auctionID)))
+	}
+
+	s.logger.Info("Stage participant revoked", map[string]interface{}{
+		"auction_id": auctionID,
+		"user_id":    participant.UserID,
+		"role":       participant.Role,
+	})
+
+	return nil
+}
+
+// ListStage returns the stage participants, invitations and requests of an auction
+func (s *Service) ListStage(ctx context.Context, auctionID, hostID uuid.UUID) ([]models.StreamParticipant, error) {
+	if _, err := s.hostedAuction(ctx, auctionID, hostID); err != nil {
+		return nil, err
+	}
+
+	var participants []models.StreamParticipant
+	err := s.db.WithContext(ctx).
+		Preload("User", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id", "first_name", "last_name", "avatar_url")
+		}).
+		Where("auction_id = ? AND status IN ?", auctionID, []string{"requested", "invited", "active"}).
+		Order("created_at ASC").
+		Find(&participants).Error
+	return participants, err
+}
+
+// ListMyInvitations returns the pending invitations addressed to a user
+func (s *Service) ListMyInvitations(ctx context.Context, userID uuid.UUID) ([]models.StreamParticipant, error) {
+	var invitations []models.StreamParticipant
+	err := s.db.WithContext(ctx).
+		Preload("Auction", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id", "title", "status", "start_time", "end_time", "seller_id")
+		}).
+		Where("user_id = ? AND status = ? AND (expires_at IS NULL OR expires_at > ?)", userID, "invited", time.Now()).
+		Order("created_at DESC").
+		Find(&invitations).Error
+	return invitations, err
+}
+
+// GenerateStageToken issues a token with the publish rights of the user's stage role
+func (s *Service) GenerateStageToken(ctx context.Context, auctionID, userID uuid.UUID) (string, error) {
+	var participant models.StreamParticipant
+	err := s.db.WithContext(ctx).
+		Where("auction_id = ? AND user_id = ? AND status = ?", auctionID, userID, "active").
+		First(&participant).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrNotOnStage
+	}
+	if err != nil {
+		return "", err
+	}
+
+	validFor := 4 * time.Hour
+	if participant.Role == RoleGuest {
+		validFor = time.Hour
+	}
+
+	token, err := s.provider.IssueToken(userID.String(), "auction-"+participant.Role, stageGrant(RoomName(auctionID), participant.Role), validFor)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate stage token: %w", err)
+	}
+	return token, nil
+}
+
+// ParticipantRole returns the stage role of a room identity
+func (s *Service) ParticipantRole(ctx context.Context, auctionID uuid.UUID, identity string) (string, error) {
+	roles, err := s.stageRoles(ctx, auctionID)
+	if err != nil {
+		return "", err
+	}
+	if role, ok := roles[identity]; ok {
+		return role, nil
+	}
+	return RoleViewer, nil
+}
+
+// CheckModerationRights verifies the actor may remove or mute the target identity.
+// Admins may moderate anyone, the host anyone but themselves, and co-hosts only
+// guests and viewers.
+func (s *Service) CheckModerationRights(ctx context.Context, auctionID, actorID uuid.UUID, isAdmin bool, identity string) error {
+	if isAdmin {
+		return nil
+	}
+
+	roles, err := s.stageRoles(ctx, auctionID)
+	if err != nil {
+		return err
+	}
+
+	actorRole, ok := roles[actorID.String()]
+	if !ok || roleRank[actorRole] < roleRank[RoleCohost] {
+		return ErrInsufficientRole
+	}
+
+	targetRole, ok := roles[identity]
+	if !ok {
+		targetRole = RoleViewer
+	}
+	if roleRank[targetRole] >= roleRank[actorRole] {
+		return ErrInsufficientRole
+	}
+	return nil
+}
+
+// stageRoles maps the identities of the host and active stage participants to their roles
+func (s *Service) stageRoles(ctx context.Context, auctionID uuid.UUID) (map[string]string, error) {
+	var auction models.Auction
+	if err := s.db.WithContext(ctx).Select("id", "seller_id").First(&auction, "id = ?", auctionID).Error; err != nil {
+		return nil, err
+	}
+
+	var participants []models.StreamParticipant
+	if err := s.db.WithContext(ctx).
+		Where("auction_id = ? AND status = ? AND user_id IS NOT NULL", auctionID, "active").
+		Find(&participants).Error; err != nil {
+		return nil, err
+	}
+
+	roles := map[string]string{auction.SellerID.String(): RoleHost}
+	for _, participant := range participants {
+		roles[participant.UserID.String()] = participant.Role
+	}
+	return roles, nil
+}
+
+// CheckAuctionOwner returns ErrAuctionNotOwned unless userID is the auction's seller
+func (s *Service) CheckAuctionOwner(ctx context.Context, auctionID, userID uuid.UUID) error {
+	_, err := s.hostedAuction(ctx, auctionID, userID)
+	return err
+}
+
+// hostedAuction loads an auction and checks that hostID is its seller
+func (s *Service) hostedAuction(ctx context.Context, auctionID, hostID uuid.UUID) (*models.Auction, error) {
+	var auction models.Auction
+	if err := s.db.WithContext(ctx).Select("id", "seller_id", "end_time").First(&auction, "id = ?", auctionID).Error; err != nil {
+		return nil, err
+	}
+	if auction.SellerID != hostID {
+		return nil, ErrAuctionNotOwned
+	}
+	return &auction, nil
+}
+
+// pendingInvitation loads an unexpired invitation the user may answer
+func (s *Service) pendingInvitation(ctx context.Context, code string, userID uuid.UUID) (*models.StreamParticipant, error) {
+	var participant models.StreamParticipant
+	err := s.db.WithContext(ctx).First(&participant, "invite_code = ? AND status = ?", code, "invited").Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvitationInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if participant.ExpiresAt != nil && participant.ExpiresAt.Before(time.Now()) {
+		return nil, ErrInvitationInvalid
+	}
+	if participant.UserID != nil && *participant.UserID != userID {
+		return nil, ErrInvitationInvalid
+	}
+	return &participant, nil
+}
+
+// updateLiveGrant applies a new grant to a participant if they are connected
+func (s *Service) updateLiveGrant(ctx context.Context, auctionID, userID uuid.UUID, grant TokenGrant) {
+	err := s.provider.UpdateParticipantGrant(ctx, RoomName(auctionID), userID.String(), grant)
+	if err != nil && !errors.Is(err, ErrParticipantNotFound) && !errors.Is(err, ErrRoomNotFound) {
+		s.logger.Warn("Failed to update participant permissions", map[string]interface{}{
+			"auction_id": auctionID,
+			"user_id":    userID,
+			"error":      err.Error(),
+		})
+	}
+}
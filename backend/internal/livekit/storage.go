@@ -0,0 +1,78 @@
+package livekit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RecordingStorage decides where recordings are written and how they are played back
+type RecordingStorage interface {
+	// Name returns the storage backend identifier, e.g. "local"
+	Name() string
+	// OutputPath returns the path the egress writes a new recording to
+	OutputPath(auctionID uuid.UUID, startedAt time.Time) string
+	// PlaybackURL returns the URL a finished recording can be played from
+	PlaybackURL(location string) string
+	// Delete removes a recording from storage
+	Delete(ctx context.Context, location string) error
+}
+
+// LocalStorage keeps recordings on a filesystem shared with the egress service and
+// serves them from baseURL. It is intended for development.
+type LocalStorage struct {
+	dir     string
+	baseURL string
+}
+
+// NewLocalStorage creates a local filesystem storage rooted at dir
+func NewLocalStorage(dir, baseURL string) *LocalStorage {
+	return &LocalStorage{
+		dir:     dir,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// Name returns the storage backend identifier
+func (s *LocalStorage) Name() string {
+	return "local"
+}
+
+// Dir returns the directory recordings are stored in
+func (s *LocalStorage) Dir() string {
+	return s.dir
+}
+
+// OutputPath returns <dir>/<auction_id>/<timestamp>.mp4
+func (s *LocalStorage) OutputPath(auctionID uuid.UUID, startedAt time.Time) string {
+	return filepath.Join(s.dir, auctionID.String(), startedAt.UTC().Format("20060102T150405")+".mp4")
+}
+
+// PlaybackURL maps a file inside the storage directory to its public URL
+func (s *LocalStorage) PlaybackURL(location string) string {
+	rel, err := filepath.Rel(s.dir, location)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		// Not one of ours (e.g. egress uploaded elsewhere); play it from where it is
+		return location
+	}
+	return s.baseURL + "/" + filepath.ToSlash(rel)
+}
+
+// Delete removes a recording file; missing files are not an error
+func (s *LocalStorage) Delete(ctx context.Context, location string) error {
+	rel, err := filepath.Rel(s.dir, location)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("recording %s is outside the storage directory", location)
+	}
+
+	if err := os.Remove(location); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package livekit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AuctionEnder ends an auction, e.g. when its host has left the stream
+type AuctionEnder interface {
+	EndAuction(ctx context.Context, auctionID uuid.UUID) error
+}
+
+// roomPresence tracks who has been seen in a room and pending host-away timers
+type roomPresence struct {
+	mutex       sync.Mutex
+	viewersSeen map[uuid.UUID]map[string]bool
+	hostTimers  map[uuid.UUID]*time.Timer
+}
+
+func newRoomPresence() *roomPresence {
+	return &roomPresence{
+		viewersSeen: make(map[uuid.UUID]map[string]bool),
+		hostTimers:  make(map[uuid.UUID]*time.Timer),
+	}
+}
+
+// SetAuctionEnder enables ending auctions automatically when the host stays away
+// for longer than gracePeriod. A zero grace period disables auto-ending.
+func (s *Service) SetAuctionEnder(ender AuctionEnder, gracePeriod time.Duration) {
+	s.auctionEnder = ender
+	s.hostGracePeriod = gracePeriod
+}
+
+// HandleWebhookEvent applies a media server event to the stream and auction state.
+// Providers redeliver events they are unsure arrived, so an event whose ID was already
+// applied is skipped.
+func (s *Service) HandleWebhookEvent(ctx context.Context, event *StreamEvent) error {
+	if event.ID == "" {
+		return s.applyWebhookEvent(ctx, event)
+	}
+
+	record := models.StreamWebhookEvent{Provider: s.provider.Name(), EventID: event.ID, Type: event.Type}
+	result := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		s.logger.Info("Ignoring duplicate webhook", map[string]interface{}{
+			"event":    event.Type,
+			"event_id": event.ID,
+		})
+		return nil
+	}
+
+	if err := s.applyWebhookEvent(ctx, event); err != nil {
+		// Forget the event so the provider's retry applies it
+		s.db.WithContext(ctx).Unscoped().Delete(&record)
+		return err
+	}
+	return nil
+}
+
+// applyWebhookEvent applies a media server event. Events for rooms that do not belong
+// to an auction are ignored.
+func (s *Service) applyWebhookEvent(ctx context.Context, event *StreamEvent) error {
+	// Ingresses are tracked by ID since a detached ingress publishes to a staging room
+	if event.Ingress != nil && (event.Type == EventIngressStarted || event.Type == EventIngressEnded) {
+		return s.applyIngressEvent(ctx, event)
+	}
+
+	auctionID, ok := auctionIDFromRoom(event.Room)
+	if !ok {
+		return nil
+	}
+
+	var auction models.Auction
+	err := s.db.WithContext(ctx).First(&auction, "id = ?", auctionID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		s.logger.Info("Ignoring webhook for unknown room", map[string]interface{}{
+			"event": event.Type,
+			"room":  event.Room,
+		})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	isHost := event.Participant != nil && event.Participant.Identity == auction.SellerID.String()
+
+	switch event.Type {
+	case EventRoomStarted:
+		return s.markStreamLive(ctx, auction.ID)
+
+	case EventRoomFinished:
+		s.presence.mutex.Lock()
+		delete(s.presence.viewersSeen, auction.ID)
+		s.presence.mutex.Unlock()
+		return s.markStreamEnded(ctx, auction.ID)
+
+	case EventParticipantJoined:
+		if event.Participant == nil || event.Participant.Recorder {
+			return nil
+		}
+		if isHost {
+			s.cancelHostTimer(auction.ID)
+			return nil
+		}
+		return s.viewerJoined(ctx, auction.ID, event.Participant.Identity)
+
+	case EventParticipantLeft:
+		if event.Participant == nil || event.Participant.Recorder {
+			return nil
+		}
+		if isHost {
+			s.startHostTimer(&auction)
+			return nil
+		}
+		return s.db.WithContext(ctx).
+			Model(&models.LiveStream{}).
+			Where("auction_id = ?", auction.ID).
+			Update("viewer_count", gorm.Expr("CASE WHEN viewer_count > 0 THEN viewer_count - 1 ELSE 0 END")).Error
+
+	case EventTrackPublished:
+		// The stream is on air once the host publishes media
+		if isHost {
+			s.cancelHostTimer(auction.ID)
+			return s.markStreamLive(ctx, auction.ID)
+		}
+		return nil
+
+	case EventEgressStarted, EventEgressUpdated, EventEgressEnded:
+		return s.applyEgressEvent(ctx, auction.ID, event)
+	}
+
+	return nil
+}
+
+// markStreamLive sets the stream live, keeping the first start time
+func (s *Service) markStreamLive(ctx context.Context, auctionID uuid.UUID) error {
+	now := time.Now()
+	if err := s.db.WithContext(ctx).
+		Model(&models.LiveStream{}).
+		Where("auction_id = ? AND started_at IS NULL", auctionID).
+		Update("started_at", now).Error; err != nil {
+		return err
+	}
+
+	return s.db.WithContext(ctx).
+		Model(&models.LiveStream{}).
+		Where("auction_id = ? AND status <> ?", auctionID, "ended").
+		Update("status", "live").Error
+}
+
+// markStreamEnded closes the stream and records its duration
+func (s *Service) markStreamEnded(ctx context.Context, auctionID uuid.UUID) error {
+	var stream models.LiveStream
+	err := s.db.WithContext(ctx).First(&stream, "auction_id = ?", auctionID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	duration := 0
+	if stream.StartedAt != nil {
+		duration = int(now.Sub(*stream.StartedAt).Seconds())
+	}
+
+	return s.db.WithContext(ctx).Model(&stream).Updates(map[string]interface{}{
+		"status":       "ended",
+		"ended_at":     now,
+		"duration":     duration,
+		"viewer_count": 0,
+	}).Error
+}
+
+// viewerJoined increments the live viewer count and updates peak and unique viewer stats
+func (s *Service) viewerJoined(ctx context.Context, auctionID uuid.UUID, identity string) error {
+	s.presence.mutex.Lock()
+	if s.presence.viewersSeen[auctionID] == nil {
+		s.presence.viewersSeen[auctionID] = make(map[string]bool)
+	}
+	firstVisit := !s.presence.viewersSeen[auctionID][identity]
+	s.presence.viewersSeen[auctionID][identity] = true
+	s.presence.mutex.Unlock()
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.LiveStream{}).
+			Where("auction_id = ?", auctionID).
+			Update("viewer_count", gorm.Expr("viewer_count + 1")).Error; err != nil {
+			return err
+		}
+
+		var stream models.LiveStream
+		if err := tx.Select("viewer_count").First(&stream, "auction_id = ?", auctionID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		var stats models.AuctionStats
+		if err := tx.Where(models.AuctionStats{AuctionID: auctionID}).FirstOrCreate(&stats).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{}
+		if stream.ViewerCount > stats.PeakViewers {
+			updates["peak_viewers"] = stream.ViewerCount
+		}
+		if firstVisit {
+			updates["unique_viewers"] = gorm.Expr("unique_viewers + 1")
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		return tx.Model(&stats).Updates(updates).Error
+	})
+}
+
+// startHostTimer schedules the auction to end if the host does not return within the grace period
+func (s *Service) startHostTimer(auction *models.Auction) {
+	if s.auctionEnder == nil || s.hostGracePeriod <= 0 || auction.Status != "live" {
+		return
+	}
+
+	auctionID := auction.ID
+
+	s.presence.mutex.Lock()
+	defer s.presence.mutex.Unlock()
+
+	if timer, ok := s.presence.hostTimers[auctionID]; ok {
+		timer.Stop()
+	}
+	s.presence.hostTimers[auctionID] = time.AfterFunc(s.hostGracePeriod, func() {
+		s.presence.mutex.Lock()
+		delete(s.presence.hostTimers, auctionID)
+		s.presence.mutex.Unlock()
+
+		ctx := context.Background()
+
+		// The auction may have been ended manually in the meantime
+		var current models.Auction
+		if err := s.db.WithContext(ctx).Select("id", "status").First(&current, "id = ?", auctionID).Error; err != nil || current.Status != "live" {
+			return
+		}
+
+		if err := s.auctionEnder.EndAuction(ctx, auctionID); err != nil {
+			s.logger.Error("Failed to auto-end auction after host left", map[string]interface{}{
+				"auction_id": auctionID,
+				"error":      err.Error(),
+			})
+			return
+		}
+
+		s.logger.Info("Auction ended after host left the stream", map[string]interface{}{
+			"auction_id":   auctionID,
+			"grace_period": s.hostGracePeriod.String(),
+		})
+	})
+
+	s.logger.Info("Host left the stream", map[string]interface{}{
+		"auction_id":   auctionID,
+		"grace_period": s.hostGracePeriod.String(),
+	})
+}
+
+// cancelHostTimer stops a pending auto-end because the host is back
+func (s *Service) cancelHostTimer(auctionID uuid.UUID) {
+	s.presence.mutex.Lock()
+	defer s.presence.mutex.Unlock()
+
+	if timer, ok := s.presence.hostTimers[auctionID]; ok {
+		timer.Stop()
+		delete(s.presence.hostTimers, auctionID)
+	}
+}
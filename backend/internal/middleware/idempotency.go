@@ -0,0 +1,387 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// IdempotencyHeader is the request header carrying the client's idempotency key
+const IdempotencyHeader = "Idempotency-Key"
+
+// IdempotencyTTL is how long a key's response is kept for replay
+const IdempotencyTTL = 24 * time.Hour
+
+// maxIdempotencyKeyLength bounds the keys clients may send
+const maxIdempotencyKeyLength = 255
+
+// Idempotency record states
+const (
+	idempotencyInProgress = "in_progress"
+	idempotencyCompleted  = "completed"
+)
+
+// IdempotencyRecord is the stored state of one idempotency key
+type IdempotencyRecord struct {
+	RequestHash  string `json:"request_hash"`
+	Status       string `json:"status"`
+	ResponseCode int    `json:"response_code,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+	ResponseBody []byte `json:"response_body,omitempty"`
+}
+
+// IdempotencyStore keeps idempotency records per scope (the user) and key
+type IdempotencyStore interface {
+	// Reserve claims a key for a new request. When the key is already known it returns
+	// the existing record and false.
+	Reserve(ctx context.Context, scope, key, requestHash string) (*IdempotencyRecord, bool, error)
+	// Complete stores the response for a reserved key
+	Complete(ctx context.Context, scope, key string, record *IdempotencyRecord) error
+	// Release forgets a reserved key so the request can be retried
+	Release(ctx context.Context, scope, key string) error
+}
+
+// Idempotency makes a mutation safe to retry. The first response to each key is stored
+// and replayed for retries with the same body; reusing a key for a different request is
+// rejected. Requests without the header are passed through. It must run after the
+// authentication middleware so keys are scoped to the user.
+func Idempotency(store IdempotencyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency key is too long"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := idempotencyScope(c)
+		requestHash := hashIdempotentRequest(c.Request.Method, c.Request.URL.Path, body)
+
+		ctx := c.Request.Context()
+		record, reserved, err := store.Reserve(ctx, scope, key, requestHash)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Idempotency store unavailable"})
+			c.Abort()
+			return
+		}
+
+		if !reserved {
+			switch {
+			case record.RequestHash != requestHash:
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency key was already used for a different request"})
+			case record.Status != idempotencyCompleted:
+				c.JSON(http.StatusConflict, gin.H{"error": "A request with this idempotency key is still in progress"})
+			default:
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(record.ResponseCode, record.ContentType, record.ResponseBody)
+			}
+			c.Abort()
+			return
+		}
+
+		// Store calls use a fresh context because the request may already be cancelled
+		storeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		// A handler that panics releases the key before the panic reaches the recovery
+		// middleware, so the retry is not stuck behind an in-progress key
+		defer func() {
+			if r := recover(); r != nil {
+				if err := store.Release(storeCtx, scope, key); err != nil {
+					log.Printf("Warning: Failed to release idempotency key: %v", err)
+				}
+				panic(r)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		// Server errors are not stored so the client can retry them
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			if err := store.Release(storeCtx, scope, key); err != nil {
+				log.Printf("Warning: Failed to release idempotency key: %v", err)
+			}
+			return
+		}
+
+		if err := store.Complete(storeCtx, scope, key, &IdempotencyRecord{
+			RequestHash:  requestHash,
+			Status:       idempotencyCompleted,
+			ResponseCode: status,
+			ContentType:  recorder.Header().Get("Content-Type"),
+			ResponseBody: recorder.body.Bytes(),
+		}); err != nil {
+			log.Printf("Warning: Failed to store idempotent response: %v", err)
+		}
+	}
+}
+
+// responseRecorder copies the response body while it is written to the client
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+// idempotencyScope returns the user the key belongs to, or the client IP for anonymous requests
+func idempotencyScope(c *gin.Context) string {
+	if userID, exists := c.Get("user_id"); exists {
+		if id, ok := userID.(uuid.UUID); ok {
+			return id.String()
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// hashIdempotentRequest fingerprints a request so a reused key can be told apart
+func hashIdempotentRequest(method, path string, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", method, path)
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// NewIdempotencyStore returns a Redis-backed store that falls back to the database when
+// Redis is not configured or fails
+func NewIdempotencyStore(client *redis.Client, db *gorm.DB) IdempotencyStore {
+	dbStore := NewDBIdempotencyStore(db)
+	if client == nil {
+		return dbStore
+	}
+	return &fallbackIdempotencyStore{
+		primary:  NewRedisIdempotencyStore(client),
+		fallback: dbStore,
+		reserved: make(map[string]IdempotencyStore),
+	}
+}
+
+// RedisIdempotencyStore keeps idempotency records in Redis
+type RedisIdempotencyStore struct {
+	client *redis.Client
+}
+
+// NewRedisIdempotencyStore creates a Redis idempotency store
+func NewRedisIdempotencyStore(client *redis.Client) *RedisIdempotencyStore {
+	return &RedisIdempotencyStore{client: client}
+}
+
+func redisIdempotencyKey(scope, key string) string {
+	return "idempotency:" + scope + ":" + key
+}
+
+// Reserve claims the key with SETNX
+func (s *RedisIdempotencyStore) Reserve(ctx context.Context, scope, key, requestHash string) (*IdempotencyRecord, bool, error) {
+	record := &IdempotencyRecord{RequestHash: requestHash, Status: idempotencyInProgress}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, false, err
+	}
+
+	redisKey := redisIdempotencyKey(scope, key)
+	reserved, err := s.client.SetNX(ctx, redisKey, data, IdempotencyTTL).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	if reserved {
+		return record, true, nil
+	}
+
+	existing, err := s.client.Get(ctx, redisKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		// The key expired between the two calls; claim it again
+		return s.Reserve(ctx, scope, key, requestHash)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var stored IdempotencyRecord
+	if err := json.Unmarshal(existing, &stored); err != nil {
+		return nil, false, err
+	}
+	return &stored, false, nil
+}
+
+// Complete stores the response, keeping the key's remaining lifetime. It fails with
+// redis.Nil when the key was not reserved in Redis.
+func (s *RedisIdempotencyStore) Complete(ctx context.Context, scope, key string, record *IdempotencyRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.client.SetArgs(ctx, redisIdempotencyKey(scope, key), data, redis.SetArgs{
+		Mode:    "XX",
+		KeepTTL: true,
+	}).Err()
+}
+
+// Release deletes the key
+func (s *RedisIdempotencyStore) Release(ctx context.Context, scope, key string) error {
+	return s.client.Del(ctx, redisIdempotencyKey(scope, key)).Err()
+}
+
+// DBIdempotencyStore keeps idempotency records in the idempotency_keys table
+type DBIdempotencyStore struct {
+	db *gorm.DB
+}
+
+// NewDBIdempotencyStore creates a database idempotency store
+func NewDBIdempotencyStore(db *gorm.DB) *DBIdempotencyStore {
+	return &DBIdempotencyStore{db: db}
+}
+
+// Reserve claims the key by inserting its row; the unique index decides concurrent claims
+func (s *DBIdempotencyStore) Reserve(ctx context.Context, scope, key, requestHash string) (*IdempotencyRecord, bool, error) {
+	row := models.IdempotencyKey{
+		Scope:       scope,
+		Key:         key,
+		RequestHash: requestHash,
+		Status:      idempotencyInProgress,
+		ExpiresAt:   time.Now().Add(IdempotencyTTL),
+	}
+	if err := s.db.WithContext(ctx).Create(&row).Error; err == nil {
+		return &IdempotencyRecord{RequestHash: requestHash, Status: idempotencyInProgress}, true, nil
+	}
+
+	var existing models.IdempotencyKey
+	if err := s.db.WithContext(ctx).First(&existing, "scope = ? AND key = ?", scope, key).Error; err != nil {
+		return nil, false, err
+	}
+
+	// Expired keys are forgotten and claimed again
+	if existing.ExpiresAt.Before(time.Now()) {
+		if err := s.db.WithContext(ctx).Unscoped().Delete(&existing).Error; err != nil {
+			return nil, false, err
+		}
+		return s.Reserve(ctx, scope, key, requestHash)
+	}
+
+	return &IdempotencyRecord{
+		RequestHash:  existing.RequestHash,
+		Status:       existing.Status,
+		ResponseCode: existing.ResponseCode,
+		ContentType:  existing.ContentType,
+		ResponseBody: []byte(existing.ResponseBody),
+	}, false, nil
+}
+
+// Complete stores the response on the key's row
+func (s *DBIdempotencyStore) Complete(ctx context.Context, scope, key string, record *IdempotencyRecord) error {
+	return s.db.WithContext(ctx).
+		Model(&models.IdempotencyKey{}).
+		Where("scope = ? AND key = ?", scope, key).
+		Updates(map[string]interface{}{
+			"status":        record.Status,
+			"response_code": record.ResponseCode,
+			"content_type":  record.ContentType,
+			"response_body": string(record.ResponseBody),
+		}).Error
+}
+
+// Release deletes the key's row
+func (s *DBIdempotencyStore) Release(ctx context.Context, scope, key string) error {
+	return s.db.WithContext(ctx).Unscoped().
+		Where("scope = ? AND key = ?", scope, key).
+		Delete(&models.IdempotencyKey{}).Error
+}
+
+// PurgeExpired deletes keys past their replay window
+func (s *DBIdempotencyStore) PurgeExpired(ctx context.Context) (int64, error) {
+	result := s.db.WithContext(ctx).Unscoped().
+		Where("expires_at < ?", time.Now()).
+		Delete(&models.IdempotencyKey{})
+	return result.RowsAffected, result.Error
+}
+
+// fallbackIdempotencyStore uses the database when Redis is unavailable. A key reserved in
+// one store is completed or released in the same store, even if Redis recovers or fails
+// in the meantime.
+type fallbackIdempotencyStore struct {
+	primary  IdempotencyStore
+	fallback IdempotencyStore
+
+	mutex    sync.Mutex
+	reserved map[string]IdempotencyStore // store that reserved each in-flight key
+}
+
+func (s *fallbackIdempotencyStore) Reserve(ctx context.Context, scope, key, requestHash string) (*IdempotencyRecord, bool, error) {
+	store := s.primary
+	record, reserved, err := s.primary.Reserve(ctx, scope, key, requestHash)
+	if err != nil {
+		log.Printf("Warning: Redis idempotency store failed, using database: %v", err)
+		store = s.fallback
+		record, reserved, err = s.fallback.Reserve(ctx, scope, key, requestHash)
+	}
+	if err == nil && reserved {
+		s.mutex.Lock()
+		s.reserved[redisIdempotencyKey(scope, key)] = store
+		s.mutex.Unlock()
+	}
+	return record, reserved, err
+}
+
+// reservedIn returns and forgets the store that reserved a key
+func (s *fallbackIdempotencyStore) reservedIn(scope, key string) (IdempotencyStore, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id := redisIdempotencyKey(scope, key)
+	store, ok := s.reserved[id]
+	delete(s.reserved, id)
+	return store, ok
+}
+
+func (s *fallbackIdempotencyStore) Complete(ctx context.Context, scope, key string, record *IdempotencyRecord) error {
+	store, ok := s.reservedIn(scope, key)
+	if !ok {
+		return fmt.Errorf("idempotency key %q was not reserved", key)
+	}
+	return store.Complete(ctx, scope, key, record)
+}
+
+func (s *fallbackIdempotencyStore) Release(ctx context.Context, scope, key string) error {
+	store, ok := s.reservedIn(scope, key)
+	if !ok {
+		return nil
+	}
+	return store.Release(ctx, scope, key)
+}
@@ -19,15 +19,16 @@ type Auction struct {
 	StartTime    time.Time  `gorm:"not null" json:"start_time"`
 	EndTime      time.Time  `gorm:"not null" json:"end_time"`
 	Status       string     `gorm:"default:'scheduled'" json:"status"` // scheduled, live, ended, cancelled
-	StartPrice   float64    `gorm:"not null" json:"start_price"`
-	ReservePrice *float64   `json:"reserve_price"`
-	BuyNowPrice  *float64   `json:"buy_now_price"`
-	CurrentBid   *float64   `json:"current_bid"`
+	StartPrice   int64      `gorm:"not null" json:"start_price"`
+	ReservePrice *int64     `json:"reserve_price"`
+	BuyNowPrice  *int64     `json:"buy_now_price"`
+	CurrentBid   *int64     `json:"current_bid"`
+	Currency     string     `gorm:"size:3;not null;default:'USD'" json:"currency"` // the product's currency
 	BidCount     int        `gorm:"default:0" json:"bid_count"`
 	WinnerID     *uuid.UUID `gorm:"references:ID" json:"winner_id"`
 	Winner       *User      `gorm:"foreignKey:WinnerID" json:"winner,omitempty"`
 	LiveKitRoom  string     `gorm:"uniqueIndex;not null" json:"livekit_room"`
-	StreamKey    *string    `json:"stream_key"`
+	StreamKey    *string    `json:"-"` // key of the attached StreamIngress; never exposed publicly
 	AutoExtend   bool       `gorm:"default:true" json:"auto_extend"` // Auto-extend if bid in last 5 minutes
 	ExtendTime   int        `gorm:"default:300" json:"extend_time"`  // Extend time in seconds
 	IsFeatured   bool       `gorm:"default:false" json:"is_featured"`
@@ -41,7 +42,7 @@ type Bid struct {
 	Auction   Auction   `gorm:"foreignKey:AuctionID" json:"auction,omitempty"`
 	UserID    uuid.UUID `gorm:"not null;references:ID;index" json:"user_id"`
 	User      User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
-	Amount    float64   `gorm:"not null" json:"amount"`
+	Amount    int64     `gorm:"not null" json:"amount"`
 	IsAutoBid bool      `gorm:"default:false" json:"is_auto_bid"`
 	IsWinning bool      `gorm:"default:false" json:"is_winning"`
 	BidTime   time.Time `gorm:"autoCreateTime" json:"bid_time"`
@@ -54,10 +55,10 @@ type AutoBid struct {
 	Auction      Auction   `gorm:"foreignKey:AuctionID" json:"auction,omitempty"`
 	UserID       uuid.UUID `gorm:"not null;references:ID;index" json:"user_id"`
 	User         User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
-	MaxAmount    float64   `gorm:"not null" json:"max_amount"`
+	MaxAmount    int64     `gorm:"not null" json:"max_amount"`
 	IsActive     bool      `gorm:"default:true" json:"is_active"`
-	CurrentBid   *float64  `json:"current_bid"`
-	BidIncrement float64   `gorm:"not null;default:5.0" json:"bid_increment"`
+	CurrentBid   *int64    `json:"current_bid"`
+	BidIncrement int64     `gorm:"not null" json:"bid_increment"`
 	LastBidTime  *time.Time `json:"last_bid_time"`
 }
 
@@ -74,6 +75,21 @@ type AuctionWatch struct {
 	NotificationSettings string `gorm:"type:jsonb" json:"notification_settings"` // JSON: {"outbid": true, "ending_soon": true}
 }
 
+// AuctionReminder is a user's "notify me" subscription for a scheduled auction. It is
+// separate from AuctionWatch, which tracks the live audience.
+type AuctionReminder struct {
+	common.BaseModel
+	AuctionID   uuid.UUID  `gorm:"not null;uniqueIndex:idx_auction_reminder_user" json:"auction_id"`
+	Auction     Auction    `gorm:"foreignKey:AuctionID" json:"auction,omitempty"`
+	UserID      uuid.UUID  `gorm:"not null;uniqueIndex:idx_auction_reminder_user;index" json:"user_id"`
+	LeadMinutes int        `gorm:"not null" json:"lead_minutes"`    // minutes before start to send the reminder
+	RemindAt    time.Time  `gorm:"not null;index" json:"remind_at"` // start time minus lead; kept in step with the auction
+	SentAt      *time.Time `gorm:"index" json:"sent_at,omitempty"`
+	Attempts    int        `gorm:"not null;default:0" json:"attempts"` // deliveries tried since the reminder was armed
+	LastError   *string    `json:"last_error,omitempty"`
+	RetryAt     *time.Time `gorm:"index" json:"-"` // a claimed or failed delivery is not tried again before then
+}
+
 // AuctionStats represents auction statistics
 type AuctionStats struct {
 	common.BaseModel
@@ -94,7 +110,7 @@ type LiveStream struct {
 	AuctionID     uuid.UUID  `gorm:"not null;uniqueIndex;references:ID" json:"auction_id"`
 	Auction       Auction    `gorm:"foreignKey:AuctionID" json:"auction,omitempty"`
 	StreamURL     string     `gorm:"not null" json:"stream_url"`
-	StreamKey     string     `gorm:"not null" json:"stream_key"`
+	StreamKey     string     `gorm:"not null" json:"-"` // key of the attached StreamIngress, empty without one
 	PlaybackURL   *string    `json:"playback_url"`
 	Status        string     `gorm:"default:'waiting'" json:"status"` // waiting, live, ended, error
 	StartedAt     *time.Time `json:"started_at"`
@@ -108,6 +124,121 @@ type LiveStream struct {
 	IsRecording   bool       `gorm:"default:false" json:"is_recording"`
 }
 
+// StreamRecording represents one recorded segment of a live stream. A new
+// segment is created each time recording is started for the stream.
+type StreamRecording struct {
+	common.BaseModel
+	AuctionID   uuid.UUID  `gorm:"not null;references:ID;index" json:"auction_id"`
+	Auction     Auction    `gorm:"foreignKey:AuctionID" json:"auction,omitempty"`
+	SellerID    uuid.UUID  `gorm:"not null;references:ID;index" json:"seller_id"`
+	EgressID    string     `gorm:"not null;uniqueIndex" json:"egress_id"`
+	Storage     string     `gorm:"not null" json:"storage"`                // storage backend, e.g. local
+	Status      string     `gorm:"default:'recording';index" json:"status"` // recording, stopping, ready, failed, expired
+	Location    string     `json:"location"`
+	PlaybackURL *string    `json:"playback_url"`
+	Duration    int        `gorm:"default:0" json:"duration"` // in seconds
+	Size        int64      `gorm:"default:0" json:"size"`     // in bytes
+	Error       *string    `json:"error,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	EndedAt     *time.Time `json:"ended_at"`
+	ExpiresAt   *time.Time `gorm:"index" json:"expires_at"`
+}
+
+// HighlightClip is a short excerpt of a stream recording, e.g. the moment a lot sold.
+// Clips are served from the recording with a media fragment rather than transcoded.
+type HighlightClip struct {
+	common.BaseModel
+	AuctionID   uuid.UUID        `gorm:"not null;references:ID;index" json:"auction_id"`
+	ProductID   uuid.UUID        `gorm:"not null;references:ID;index" json:"product_id"`
+	SellerID    uuid.UUID        `gorm:"not null;references:ID;index" json:"seller_id"`
+	RecordingID uuid.UUID        `gorm:"not null;references:ID" json:"recording_id"`
+	Recording   *StreamRecording `gorm:"foreignKey:RecordingID" json:"-"`
+	Title       string           `gorm:"not null" json:"title"`
+	Source      string           `gorm:"not null" json:"source"` // manual, auto
+	StartOffset float64          `json:"start_offset"`           // seconds into the recording
+	EndOffset   float64          `json:"end_offset"`
+	Duration    float64          `json:"duration"` // in seconds
+	PlaybackURL string           `gorm:"not null" json:"playback_url"`
+	IsPublished bool             `gorm:"default:true" json:"is_published"`
+	StartTime   time.Time        `json:"start_time"` // wall clock time the clip starts at
+}
+
+// StreamIngress is an RTMP or WHIP endpoint a seller publishes to from an encoder
+// such as OBS. It belongs to the seller and can be attached to one auction at a time;
+// while detached it publishes into the seller's own staging room.
+type StreamIngress struct {
+	common.BaseModel
+	SellerID        uuid.UUID  `gorm:"not null;references:ID;index" json:"seller_id"`
+	AuctionID       *uuid.UUID `gorm:"references:ID;index" json:"auction_id"`
+	Auction         *Auction   `gorm:"foreignKey:AuctionID" json:"auction,omitempty"`
+	IngressID       string     `gorm:"not null;uniqueIndex" json:"ingress_id"`
+	Name            string     `json:"name"`
+	InputType       string     `gorm:"not null" json:"input_type"` // rtmp, whip
+	URL             string     `gorm:"not null" json:"url"`
+	StreamKey       string     `gorm:"not null" json:"stream_key"`
+	RoomName        string     `gorm:"not null" json:"room_name"`
+	Status          string     `gorm:"default:'inactive';index" json:"status"` // inactive, buffering, publishing, error, revoked
+	Error           *string    `json:"error,omitempty"`
+	LastConnectedAt *time.Time `json:"last_connected_at"`
+	RotatedAt       *time.Time `json:"rotated_at"`
+	RevokedAt       *time.Time `json:"revoked_at"`
+}
+
+// StreamParticipant gives a user a role on an auction's stage besides its seller, who
+// is always the host. Invitations come from the host; viewers can also ask to join.
+type StreamParticipant struct {
+	common.BaseModel
+	AuctionID  uuid.UUID  `gorm:"not null;references:ID;index" json:"auction_id"`
+	Auction    Auction    `gorm:"foreignKey:AuctionID" json:"auction,omitempty"`
+	UserID     *uuid.UUID `gorm:"references:ID;index" json:"user_id"` // empty until an open invitation is accepted
+	User       *User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Role       string     `gorm:"not null" json:"role"`                     // cohost, guest
+	Status     string     `gorm:"default:'invited';index" json:"status"`    // requested, invited, active, declined, revoked
+	InviteCode *string    `gorm:"uniqueIndex" json:"invite_code,omitempty"` // shared with the invitee; cleared once used
+	InvitedBy  *uuid.UUID `gorm:"references:ID" json:"invited_by"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// StreamMetricSample is one health report of a live stream
+type StreamMetricSample struct {
+	common.BaseModel
+	LiveStreamID uuid.UUID `gorm:"not null;references:ID;index" json:"live_stream_id"`
+	AuctionID    uuid.UUID `gorm:"not null;index" json:"auction_id"`
+	ViewerCount  int       `json:"viewer_count"`
+	Latency      int       `json:"latency"`      // in milliseconds
+	Bandwidth    int       `json:"bandwidth"`    // in kbps
+	FrameRate    *int      `json:"frame_rate"`   // video frames per second, nil if not reported
+	CPUUsage     int       `json:"cpu_usage"`    // percentage
+	MemoryUsage  int       `json:"memory_usage"` // percentage
+	RecordedAt   time.Time `gorm:"not null;index" json:"recorded_at"`
+}
+
+// StreamAlert is a health rule violation raised for a live stream. An alert stays
+// open until the condition clears.
+type StreamAlert struct {
+	common.BaseModel
+	LiveStreamID uuid.UUID  `gorm:"not null;references:ID;index" json:"live_stream_id"`
+	AuctionID    uuid.UUID  `gorm:"not null;index" json:"auction_id"`
+	Rule         string     `gorm:"not null" json:"rule"`     // bitrate_drop, latency_spike, no_video, high_cpu, high_memory
+	Severity     string     `gorm:"not null" json:"severity"` // warning, critical
+	Message      string     `json:"message"`
+	Value        float64    `json:"value"`
+	Threshold    float64    `json:"threshold"`
+	TriggeredAt  time.Time  `gorm:"not null" json:"triggered_at"`
+	ResolvedAt   *time.Time `json:"resolved_at"`
+}
+
+// StreamWebhookEvent records a media server webhook that was applied, so a redelivered
+// event is not applied twice
+type StreamWebhookEvent struct {
+	common.BaseModel
+	Provider string `gorm:"not null;uniqueIndex:idx_stream_webhook_provider_event" json:"provider"`
+	EventID  string `gorm:"not null;uniqueIndex:idx_stream_webhook_provider_event" json:"event_id"`
+	Type     string `gorm:"not null" json:"type"`
+}
+
 // ChatMessage represents a chat message during a live auction
 type ChatMessage struct {
 	common.BaseModel
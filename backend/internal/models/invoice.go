@@ -0,0 +1,71 @@
+package models
+
+import (
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/common"
+	"github.com/google/uuid"
+)
+
+// Invoice is a seller's invoice for their part of a paid order, or a credit note for a
+// refund of it. The parties, lines and tax are copied when it is issued so it never
+// changes afterwards.
+type Invoice struct {
+	common.BaseModel
+	SellerID          uuid.UUID     `gorm:"not null;uniqueIndex:idx_invoices_seller_number" json:"seller_id"`
+	Number            string        `gorm:"not null;uniqueIndex:idx_invoices_seller_number" json:"number"` // sequential per seller, e.g. INV-000042
+	Type              string        `gorm:"not null;default:'invoice';index" json:"type"`                  // invoice, credit_note
+	BuyerID           uuid.UUID     `gorm:"not null;index" json:"buyer_id"`
+	OrderID           uuid.UUID     `gorm:"not null;index" json:"order_id"`
+	RefundID          *uuid.UUID    `gorm:"index" json:"refund_id"`           // the refund a credit note is for
+	OriginalInvoiceID *uuid.UUID    `gorm:"index" json:"original_invoice_id"` // the invoice a credit note corrects
+	Currency          string        `gorm:"size:3;not null" json:"currency"`
+	Subtotal          int64         `gorm:"not null" json:"subtotal"`
+	TaxRate           float64       `gorm:"not null;default:0" json:"tax_rate"` // e.g. 0.19 for 19% VAT
+	ReverseCharge     bool          `gorm:"not null;default:false" json:"reverse_charge"` // VAT is accounted for by the buyer
+	TaxAmount         int64         `gorm:"not null;default:0" json:"tax_amount"`
+	ShippingCost      int64         `gorm:"not null;default:0" json:"shipping_cost"`
+	Total             int64         `gorm:"not null" json:"total"`
+	IssuedAt          time.Time     `gorm:"not null" json:"issued_at"`
+	SellerName        string        `gorm:"not null" json:"seller_name"`
+	SellerEmail       string        `json:"seller_email"`
+	SellerTaxID       *string       `json:"seller_tax_id"`
+	SellerAddress     *Address      `gorm:"embedded;embeddedPrefix:seller_" json:"seller_address"`
+	BuyerName         string        `gorm:"not null" json:"buyer_name"`
+	BuyerEmail        string        `json:"buyer_email"`
+	BuyerTaxID        *string       `json:"buyer_tax_id"`
+	BillingAddress    *Address      `gorm:"embedded;embeddedPrefix:billing_" json:"billing_address"`
+	ShippingAddress   *Address      `gorm:"embedded;embeddedPrefix:shipping_" json:"shipping_address"`
+	Lines             []InvoiceLine `gorm:"foreignKey:InvoiceID" json:"lines,omitempty"`
+}
+
+// InvoiceLine is one line of an invoice. Amounts are before tax.
+type InvoiceLine struct {
+	common.BaseModel
+	InvoiceID   uuid.UUID  `gorm:"not null;index" json:"invoice_id"`
+	OrderItemID *uuid.UUID `json:"order_item_id"`
+	ProductID   *uuid.UUID `json:"product_id"`
+	Description string     `gorm:"not null" json:"description"`
+	Quantity    int        `gorm:"not null;default:1" json:"quantity"`
+	UnitPrice   int64      `gorm:"not null" json:"unit_price"`
+	Amount      int64      `gorm:"not null" json:"amount"`
+	TaxAmount   int64      `gorm:"not null;default:0" json:"tax_amount"`
+}
+
+// InvoiceSequence holds the last number a seller issued in a series of documents
+type InvoiceSequence struct {
+	common.BaseModel
+	SellerID   uuid.UUID `gorm:"not null;uniqueIndex:idx_invoice_sequence" json:"seller_id"`
+	Series     string    `gorm:"not null;uniqueIndex:idx_invoice_sequence" json:"series"` // INV, CN
+	LastNumber int64     `gorm:"not null;default:0" json:"last_number"`
+}
+
+// InvoiceProfile holds the legal name, tax ID and address a user wants on their invoices,
+// as the seller issuing them or the buyer receiving them
+type InvoiceProfile struct {
+	common.BaseModel
+	UserID    uuid.UUID `gorm:"not null;uniqueIndex" json:"user_id"`
+	LegalName string    `json:"legal_name"`
+	TaxID     *string   `json:"tax_id"` // e.g. an EU VAT number
+	Address   *Address  `gorm:"embedded;embeddedPrefix:address_" json:"address"`
+}
@@ -13,6 +13,7 @@ type User struct {
 	Email         string  `gorm:"uniqueIndex;not null" json:"email"`
 	PasswordHash  string  `gorm:"not null" json:"-"`
 	Role          string  `gorm:"not null;default:'buyer'" json:"role"` // 'buyer', 'seller', 'admin'
+	SellerTier    string  `gorm:"not null;default:'standard'" json:"seller_tier"` // fee tier of a seller, e.g. 'standard', 'pro'
 	FirstName     *string `json:"first_name"`
 	LastName      *string `json:"last_name"`
 	AvatarURL     *string `json:"avatar_url"`
@@ -45,9 +46,10 @@ type Product struct {
 	Title         string    `gorm:"not null" json:"title"`
 	Description   *string   `json:"description"`
 	Condition     *string   `json:"condition"` // 'new', 'like_new', 'good', 'fair'
-	StartingPrice float64   `gorm:"not null" json:"starting_price"`
-	ReservePrice  *float64  `json:"reserve_price"`
-	BuyNowPrice   *float64  `json:"buy_now_price"`
+	StartingPrice int64     `gorm:"not null" json:"starting_price"`
+	ReservePrice  *int64    `json:"reserve_price"`
+	BuyNowPrice   *int64    `json:"buy_now_price"`
+	Currency      string    `gorm:"size:3;not null;default:'USD'" json:"currency"` // ISO 4217 code of the prices
 	Images        *string   `gorm:"type:jsonb" json:"images"`       // JSON array of image URLs
 	VideoURL      *string   `json:"video_url"`
 	Specifications *string  `gorm:"type:jsonb" json:"specifications"` // JSON object
@@ -80,18 +82,53 @@ type Review struct {
 type Order struct {
 	common.BaseModel
 	UserID          uuid.UUID  `gorm:"not null;references:ID" json:"user_id"`
-	Status          string     `gorm:"not null;default:'pending'" json:"status"` // pending, processing, shipped, delivered, cancelled
-	TotalAmount     float64    `gorm:"not null" json:"total_amount"`
-	Subtotal        float64    `gorm:"not null" json:"subtotal"`
-	TaxAmount       float64    `gorm:"default:0" json:"tax_amount"`
-	ShippingCost    float64    `gorm:"default:0" json:"shipping_cost"`
-	DiscountAmount  float64    `gorm:"default:0" json:"discount_amount"`
+	Status          string     `gorm:"not null;default:'pending'" json:"status"` // pending, processing, shipped, delivered, cancelled, refunded
+	TotalAmount     int64      `gorm:"not null" json:"total_amount"`
+	Currency        string     `gorm:"size:3;not null;default:'USD'" json:"currency"`
+	Subtotal        int64      `gorm:"not null" json:"subtotal"`
+	TaxAmount       int64      `gorm:"default:0" json:"tax_amount"`
+	ShippingCost    int64      `gorm:"default:0" json:"shipping_cost"`
+	DiscountAmount  int64      `gorm:"default:0" json:"discount_amount"`
+	CreditAmount    int64      `gorm:"default:0" json:"credit_amount"` // paid with gift cards and store credit; the rest is paid by card
 	ShippingAddress *Address   `gorm:"embedded;embeddedPrefix:shipping_" json:"shipping_address"`
 	BillingAddress  *Address   `gorm:"embedded;embeddedPrefix:billing_" json:"billing_address"`
 	PaymentID       *uuid.UUID `gorm:"references:ID" json:"payment_id"`
 	TrackingNumber  *string    `json:"tracking_number"`
 	Notes           *string    `json:"notes"`
+	DeliveredAt     *time.Time `json:"delivered_at"`
 	Items          []OrderItem `gorm:"foreignKey:OrderID" json:"items,omitempty"`
+	Fees           []OrderFee  `gorm:"foreignKey:OrderID" json:"fees,omitempty"`
+}
+
+// FeeRule is a marketplace fee charged on each order line. Rules of the same kind
+// compete and only the most specific matching rule applies; different kinds add up.
+type FeeRule struct {
+	common.BaseModel
+	Name        string     `gorm:"not null" json:"name"`
+	Kind        string     `gorm:"not null;default:'commission'" json:"kind"` // e.g. 'commission', 'processing'
+	Type        string     `gorm:"not null" json:"type"`                      // 'percentage', 'fixed'
+	Percent     float64    `gorm:"default:0" json:"percent"`                  // of the line total, e.g. 10 for 10%
+	FixedAmount int64      `gorm:"default:0" json:"fixed_amount"`             // per line, in Currency
+	Currency    string     `gorm:"size:3" json:"currency"`                    // required for fixed fees; empty matches any
+	MaxAmount   int64      `gorm:"default:0" json:"max_amount"`               // cap per line; 0 for none
+	CategoryID  *uuid.UUID `gorm:"index" json:"category_id"`
+	SellerTier  *string    `json:"seller_tier"`
+	SaleType    string     `gorm:"not null;default:'any'" json:"sale_type"` // 'any', 'auction', 'fixed_price'
+	Priority    int        `gorm:"default:0" json:"priority"`               // breaks ties between equally specific rules
+	IsActive    bool       `gorm:"default:true" json:"is_active"`
+}
+
+// OrderFee is a fee charged on an order line when the order was paid
+type OrderFee struct {
+	common.BaseModel
+	OrderID     uuid.UUID `gorm:"not null;index" json:"order_id"`
+	OrderItemID uuid.UUID `gorm:"not null" json:"order_item_id"`
+	SellerID    uuid.UUID `gorm:"not null;index" json:"seller_id"`
+	FeeRuleID   uuid.UUID `gorm:"not null" json:"fee_rule_id"`
+	Kind        string    `gorm:"not null" json:"kind"`
+	Description string    `gorm:"not null" json:"description"`
+	Amount      int64     `gorm:"not null" json:"amount"`
+	Currency    string    `gorm:"size:3;not null" json:"currency"`
 }
 
 // OrderItem represents items in an order
@@ -100,8 +137,9 @@ type OrderItem struct {
 	OrderID     uuid.UUID `gorm:"not null;references:ID" json:"order_id"`
 	ProductID   uuid.UUID `gorm:"not null;references:ID" json:"product_id"`
 	Quantity    int        `gorm:"not null" json:"quantity"`
-	UnitPrice   float64    `gorm:"not null" json:"unit_price"`
-	Total       float64    `gorm:"not null" json:"total"`
+	UnitPrice   int64      `gorm:"not null" json:"unit_price"`
+	Total       int64      `gorm:"not null" json:"total"`
+	AuctionID   *uuid.UUID `gorm:"uniqueIndex:idx_order_items_auction" json:"auction_id,omitempty"` // set when the item was won at auction; an auction has one order
 	Product     Product    `gorm:"foreignKey:ProductID" json:"product,omitempty"`
 }
 
@@ -145,9 +183,9 @@ type ProductVariant struct {
 	ProductID    uuid.UUID  `gorm:"not null;references:ID" json:"product_id"`
 	Sku          string     `gorm:"uniqueIndex;not null" json:"sku"`
 	Title        string     `gorm:"not null" json:"title"`
-	Price         float64    `gorm:"not null" json:"price"`
-	ComparePrice *float64   `json:"compare_price"`
-	CostPrice    *float64   `json:"cost_price"`
+	Price         int64      `gorm:"not null" json:"price"`
+	ComparePrice *int64     `json:"compare_price"`
+	CostPrice    *int64     `json:"cost_price"`
 	Weight       *float64   `json:"weight,omitempty"`
 	Barcode      *string    `json:"barcode,omitempty"`
 	Inventory    int        `gorm:"default:0" json:"inventory"`
@@ -212,4 +250,19 @@ type StockMovement struct {
 	WarehouseID  *uuid.UUID      `json:"warehouse_id,omitempty"`
 	Product      Product         `gorm:"foreignKey:ProductID" json:"product,omitempty"`
 	Variant      *ProductVariant `gorm:"foreignKey:VariantID" json:"variant,omitempty"`
-}
\ No newline at end of file
+}
+
+// IdempotencyKey stores the first response to a mutation sent with an Idempotency-Key
+// header so that client retries replay it instead of repeating the mutation. Redis holds
+// the same record when available; this table is the fallback.
+type IdempotencyKey struct {
+	common.BaseModel
+	Scope        string    `gorm:"not null;uniqueIndex:idx_idempotency_scope_key" json:"scope"` // user ID, or the client IP for anonymous requests
+	Key          string    `gorm:"not null;uniqueIndex:idx_idempotency_scope_key" json:"key"`
+	RequestHash  string    `gorm:"not null" json:"request_hash"`
+	Status       string    `gorm:"not null" json:"status"` // in_progress, completed
+	ResponseCode int       `json:"response_code"`
+	ContentType  string    `json:"content_type"`
+	ResponseBody string    `gorm:"type:text" json:"response_body"`
+	ExpiresAt    time.Time `gorm:"not null;index" json:"expires_at"`
+}
@@ -10,19 +10,19 @@ import (
 // Payment represents a payment transaction
 type Payment struct {
 	common.BaseModel
-	OrderID        uuid.UUID  `gorm:"not null;references:ID" json:"order_id"`
-	Order          Order      `gorm:"foreignKey:OrderID" json:"order,omitempty"`
+	OrderID        *uuid.UUID `gorm:"references:ID" json:"order_id"` // unset for payments made outside checkout
+	Order          *Order     `gorm:"foreignKey:OrderID" json:"order,omitempty"`
 	UserID         uuid.UUID  `gorm:"not null;references:ID" json:"user_id"`
 	User           User       `gorm:"foreignKey:UserID" json:"user,omitempty"`
 	PaymentMethod  string     `gorm:"not null" json:"payment_method"` // stripe, paypal, credit_card
-	Amount         float64    `gorm:"not null" json:"amount"`
+	Amount         int64      `gorm:"not null" json:"amount"`
 	Currency       string     `gorm:"not null;default:'USD'" json:"currency"`
-	Status         string     `gorm:"default:'pending'" json:"status"` // pending, processing, completed, failed, refunded, cancelled
+	Status         string     `gorm:"default:'pending'" json:"status"` // pending, processing, completed, partially_refunded, refunded, disputed, charged_back, failed, cancelled
 	TransactionID  string     `gorm:"uniqueIndex" json:"transaction_id"`
 	GatewayRef     string     `json:"gateway_ref"` // stripe_payment_id, paypal_id, etc.
 	GatewayType    string     `gorm:"not null" json:"gateway_type"` // stripe, paypal, apple_pay, google_pay
 	FailureReason  *string    `json:"failure_reason"`
-	RefundedAmount float64    `gorm:"default:0" json:"refunded_amount"`
+	RefundedAmount int64      `gorm:"default:0" json:"refunded_amount"`
 	RefundReason   *string    `json:"refund_reason"`
 	ProcessedAt    *time.Time `json:"processed_at"`
 	ExpiresAt      *time.Time `json:"expires_at"`
@@ -32,7 +32,7 @@ type Payment struct {
 // PaymentMethod represents a user's saved payment method
 type PaymentMethod struct {
 	common.BaseModel
-	UserID      uuid.UUID  `gorm:"not null;references:ID" json:"user_id"`
+	UserID      uuid.UUID  `gorm:"not null;references:ID;uniqueIndex:idx_payment_methods_user_default,where:is_default = true AND deleted_at IS NULL" json:"user_id"` // at most one default per user
 	User        User       `gorm:"foreignKey:UserID" json:"user,omitempty"`
 	Type        string     `gorm:"not null" json:"type"` // credit_card, debit_card, paypal, bank_account
 	Provider    string     `gorm:"not null" json:"provider"` // stripe, paypal, etc.
@@ -42,6 +42,8 @@ type PaymentMethod struct {
 	Brand       *string    `json:"brand"` // visa, mastercard, etc.
 	ExpiryMonth *int       `json:"expiry_month"`
 	ExpiryYear  *int       `json:"expiry_year"`
+	ExpiresAt   *time.Time `gorm:"index" json:"expires_at"` // start of the month after the expiry date
+	IsExpired   bool       `gorm:"default:false" json:"is_expired"` // expired methods are never charged
 	Name        *string    `json:"name"`
 	Email       *string    `json:"email"`
 	Phone       *string    `json:"phone"`
@@ -50,18 +52,27 @@ type PaymentMethod struct {
 	Metadata    string     `gorm:"type:jsonb" json:"metadata"`
 }
 
+// PaymentCustomer is a user's customer at a payment gateway, which their saved payment
+// methods are attached to
+type PaymentCustomer struct {
+	common.BaseModel
+	UserID      uuid.UUID `gorm:"not null;uniqueIndex:idx_payment_customers_user_gateway" json:"user_id"`
+	Gateway     string    `gorm:"not null;uniqueIndex:idx_payment_customers_user_gateway" json:"gateway"` // stripe, fake
+	CustomerRef string    `gorm:"not null" json:"customer_ref"` // gateway customer ID
+}
+
 // PaymentIntent represents a payment intent for processing
 type PaymentIntent struct {
 	common.BaseModel
 	UserID       uuid.UUID  `gorm:"not null;references:ID" json:"user_id"`
 	User         User       `gorm:"foreignKey:UserID" json:"user,omitempty"`
-	Amount       float64    `gorm:"not null" json:"amount"`
+	Amount       int64      `gorm:"not null" json:"amount"`
 	Currency     string     `gorm:"not null;default:'USD'" json:"currency"`
 	Status       string     `gorm:"default:'requires_payment_method'" json:"status"` // requires_payment_method, requires_confirmation, requires_action, processing, succeeded, canceled
-	ClientSecret string     `gorm:"not null;json:"client_secret"`
-	GatewayRef   string     `gorm:"not null;json:"gateway_ref"` // stripe_payment_intent_id
-	GatewayType  string     `gorm:"not null;json:"gateway_type"` // stripe, paypal, etc.
-	PaymentMethods []string `gorm:"type:jsonb" json:"payment_methods"` // card, ideal, sepa_debit, etc.
+	ClientSecret string     `gorm:"not null" json:"client_secret"`
+	GatewayRef   string     `gorm:"not null;index" json:"gateway_ref"` // gateway payment intent ID
+	GatewayType  string     `gorm:"not null" json:"gateway_type"` // stripe, fake
+	PaymentMethods []string `gorm:"type:jsonb;serializer:json" json:"payment_methods"` // card, ideal, sepa_debit, etc.
 	ExpiresAt    time.Time  `gorm:"not null" json:"expires_at"`
 	OrderID      *uuid.UUID `gorm:"references:ID" json:"order_id"`
 	Order        *Order     `gorm:"foreignKey:OrderID" json:"order,omitempty"`
@@ -73,24 +84,102 @@ type Refund struct {
 	common.BaseModel
 	PaymentID     uuid.UUID `gorm:"not null;references:ID" json:"payment_id"`
 	Payment       Payment   `gorm:"foreignKey:PaymentID" json:"payment,omitempty"`
-	Amount        float64   `gorm:"not null" json:"amount"`
+	OrderID       *uuid.UUID `gorm:"index" json:"order_id"`
+	Amount        int64     `gorm:"not null" json:"amount"`
 	Reason        string    `gorm:"not null" json:"reason"` // duplicate, fraudulent, requested_by_customer
-	Status        string    `gorm:"default:'pending'" json:"status"` // pending, succeeded, failed, cancelled
+	Status        string    `gorm:"default:'pending'" json:"status"` // pending_approval, processing, pending, succeeded, failed, rejected
 	GatewayRef    string    `json:"gateway_ref"` // stripe_refund_id
 	GatewayType   string    `gorm:"not null" json:"gateway_type"`
-	ProcessedBy   uuid.UUID `gorm:"not null;references:ID" json:"processed_by"`
-	Processor     User      `gorm:"foreignKey:ProcessedBy" json:"processor,omitempty"`
+	Restock       bool      `gorm:"default:false" json:"restock"` // return the refunded items to stock
+	RequestedBy   uuid.UUID `gorm:"not null;references:ID" json:"requested_by"`
+	ProcessedBy   *uuid.UUID `gorm:"references:ID" json:"processed_by"` // who approved and sent the refund
+	Processor     *User     `gorm:"foreignKey:ProcessedBy" json:"processor,omitempty"`
 	Notes         *string   `json:"notes"`
 	ProcessedAt   *time.Time `json:"processed_at"`
+	Method        string    `gorm:"not null;default:'original'" json:"method"` // original (back to the card), store_credit
+	CreditAmount  int64     `gorm:"default:0" json:"credit_amount"` // part paid to the buyer's store credit: all of a store credit refund, else the part gift cards and store credit paid
 	Metadata      string    `gorm:"type:jsonb" json:"metadata"`
+	Items         []RefundItem `gorm:"foreignKey:RefundID" json:"items,omitempty"`
+}
+
+// RefundItem is the part of a refund returned for one order item
+type RefundItem struct {
+	common.BaseModel
+	RefundID    uuid.UUID `gorm:"not null;index" json:"refund_id"`
+	OrderItemID uuid.UUID `gorm:"not null;index" json:"order_item_id"`
+	Quantity    int       `gorm:"not null" json:"quantity"`
+	Amount      int64     `gorm:"not null" json:"amount"`
+}
+
+// Dispute is a chargeback a buyer's bank opened against a payment through the gateway
+type Dispute struct {
+	common.BaseModel
+	PaymentID           uuid.UUID         `gorm:"not null;index" json:"payment_id"`
+	OrderID             *uuid.UUID        `gorm:"index" json:"order_id"`
+	GatewayRef          string            `gorm:"not null;uniqueIndex" json:"gateway_ref"` // stripe_dispute_id
+	GatewayType         string            `gorm:"not null" json:"gateway_type"`
+	Amount              int64             `gorm:"not null" json:"amount"`
+	Currency            string            `gorm:"size:3;not null" json:"currency"`
+	Reason              string            `json:"reason"` // fraudulent, product_not_received, ...
+	Status              string            `gorm:"not null;default:'needs_response'" json:"status"` // needs_response, under_review, won, lost
+	EvidenceDueBy       *time.Time        `json:"evidence_due_by"`
+	EvidenceSubmittedAt *time.Time        `json:"evidence_submitted_at"`
+	Liability           *string           `json:"liability"` // seller, platform; who bears a lost dispute
+	ResolvedBy          *uuid.UUID        `json:"resolved_by"`
+	ResolvedAt          *time.Time        `json:"resolved_at"` // set once an admin has settled the outcome
+	Notes               *string           `json:"notes"`
+	Evidence            []DisputeEvidence `gorm:"foreignKey:DisputeID" json:"evidence,omitempty"`
+}
+
+// DisputeEvidence is evidence a seller submitted to contest a dispute
+type DisputeEvidence struct {
+	common.BaseModel
+	DisputeID      uuid.UUID `gorm:"not null;index" json:"dispute_id"`
+	SubmittedBy    uuid.UUID `gorm:"not null" json:"submitted_by"`
+	TrackingNumber *string   `json:"tracking_number"`
+	Carrier        *string   `json:"carrier"`
+	Message        string    `gorm:"type:text" json:"message"`
+	Photos         *string   `gorm:"type:jsonb" json:"photos"` // JSON array of photo URLs
+}
+
+// ReconciliationRun compares the payments, refunds and disputes recorded over a period
+// with the balance transactions the gateway settled
+type ReconciliationRun struct {
+	common.BaseModel
+	Gateway     string               `gorm:"not null" json:"gateway"`
+	PeriodStart time.Time            `gorm:"not null;index" json:"period_start"`
+	PeriodEnd   time.Time            `gorm:"not null" json:"period_end"`
+	Status      string               `gorm:"not null" json:"status"` // completed, failed
+	Matched     int                  `json:"matched"`
+	Missing     int                  `json:"missing"`
+	Mismatched  int                  `json:"mismatched"`
+	Orphaned    int                  `json:"orphaned"`
+	Error       *string              `json:"error,omitempty"`
+	Items       []ReconciliationItem `gorm:"foreignKey:RunID" json:"items,omitempty"`
 }
 
-// Transaction represents a generic financial transaction
+// ReconciliationItem is a discrepancy found by a reconciliation run
+type ReconciliationItem struct {
+	common.BaseModel
+	RunID         uuid.UUID  `gorm:"not null;index" json:"run_id"`
+	Kind          string     `gorm:"not null" json:"kind"`    // payment, refund, dispute
+	Problem       string     `gorm:"not null" json:"problem"` // missing, mismatched, orphaned
+	GatewayRef    string     `gorm:"index" json:"gateway_ref"`
+	LocalID       *uuid.UUID `json:"local_id"`
+	LocalAmount   *int64     `json:"local_amount"`
+	GatewayAmount *int64     `json:"gateway_amount"`
+	Currency      string     `json:"currency"`
+	Detail        string     `json:"detail"`
+}
+
+// Transaction represents a generic financial transaction. Each one is a ledger journal
+// entry whose LedgerEntries sum to zero.
 type Transaction struct {
 	common.BaseModel
-	Type         string     `gorm:"not null" json:"type"` // payment, refund, payout, fee
+	Type         string     `gorm:"not null;uniqueIndex:idx_transaction_reference" json:"type"` // payment, refund, payout, fee
+	Reference    string     `gorm:"not null;uniqueIndex:idx_transaction_reference" json:"reference"` // source record, so a posting is never made twice
 	Status       string     `gorm:"not null;default:'pending'" json:"status"`
-	Amount       float64    `gorm:"not null" json:"amount"`
+	Amount       int64      `gorm:"not null" json:"amount"`
 	Currency     string     `gorm:"not null;default:'USD'" json:"currency"`
 	UserID       uuid.UUID  `gorm:"not null;references:ID" json:"user_id"`
 	User         User       `gorm:"foreignKey:UserID" json:"user,omitempty"`
@@ -102,8 +191,29 @@ type Transaction struct {
 	GatewayType  string     `gorm:"not null" json:"gateway_type"`
 	Description  string     `gorm:"not null" json:"description"`
 	Metadata     string     `gorm:"type:jsonb" json:"metadata"`
-	Balance      float64    `gorm:"not null;default:0" json:"balance"` // Running balance
+	Balance      int64      `gorm:"not null;default:0" json:"balance"` // Running balance of the user's ledger account after posting
 	ProcessedAt  *time.Time `json:"processed_at"`
+	Entries      []LedgerEntry `gorm:"foreignKey:TransactionID" json:"entries,omitempty"`
+}
+
+// LedgerAccount is a double-entry ledger account. Balance caches the sum of its entries.
+type LedgerAccount struct {
+	common.BaseModel
+	Code     string     `gorm:"not null;uniqueIndex:idx_ledger_account_code" json:"code"` // e.g. seller:<id>, platform:fees
+	Currency string     `gorm:"size:3;not null;uniqueIndex:idx_ledger_account_code" json:"currency"`
+	Type     string     `gorm:"not null" json:"type"` // asset, liability, revenue, expense
+	OwnerID  *uuid.UUID `gorm:"index" json:"owner_id"`
+	Balance  int64      `gorm:"not null;default:0" json:"balance"` // minor units, debits positive
+}
+
+// LedgerEntry is one side of a ledger transaction
+type LedgerEntry struct {
+	common.BaseModel
+	TransactionID uuid.UUID     `gorm:"not null;index" json:"transaction_id"`
+	AccountID     uuid.UUID     `gorm:"not null;index" json:"account_id"`
+	Account       LedgerAccount `gorm:"foreignKey:AccountID" json:"account,omitempty"`
+	Amount        int64         `gorm:"not null" json:"amount"` // minor units; debits positive, credits negative
+	Currency      string        `gorm:"size:3;not null" json:"currency"`
 }
 
 // Payout represents a payout to a seller
@@ -111,22 +221,54 @@ type Payout struct {
 	common.BaseModel
 	SellerID     uuid.UUID `gorm:"not null;references:ID" json:"seller_id"`
 	Seller       User      `gorm:"foreignKey:SellerID" json:"seller,omitempty"`
-	Amount       float64   `gorm:"not null" json:"amount"`
+	Amount       int64     `gorm:"not null" json:"amount"`
 	Currency     string    `gorm:"not null;default:'USD'" json:"currency"`
 	Status       string    `gorm:"not null;default:'pending'" json:"status"` // pending, in_transit, paid, failed, cancelled
 	GatewayRef   string    `json:"gateway_ref"`
+	TransferRef  string    `json:"transfer_ref"` // transfer of the funds to the seller's account; once set the payout counts as sent
 	GatewayType  string    `gorm:"not null" json:"gateway_type"`
 	Method       string    `gorm:"not null" json:"method"` // bank_transfer, stripe_connect, paypal
 	Destination  string    `gorm:"not null" json:"destination"` // Bank account or email
-	Fee          float64   `gorm:"default:0" json:"fee"`
-	NetAmount    float64   `gorm:"not null" json:"net_amount"`
+	Fee          int64     `gorm:"default:0" json:"fee"` // Platform commission withheld
+	RefundedAmount int64   `gorm:"default:0" json:"refunded_amount"` // Seller's share of refunds withheld
+	Adjustment   int64     `gorm:"default:0" json:"adjustment"` // Refunds and chargebacks on earlier payouts' orders since they were paid out
+	NetAmount    int64     `gorm:"not null" json:"net_amount"`
 	OrderIDs     string    `gorm:"type:jsonb" json:"order_ids"` // Array of order UUIDs being paid out
 	ProcessedBy  uuid.UUID `gorm:"not null;references:ID" json:"processed_by"`
 	Processor    User      `gorm:"foreignKey:ProcessedBy" json:"processor,omitempty"`
 	Notes        *string   `json:"notes"`
 	ScheduledAt  *time.Time `json:"scheduled_at"`
 	ProcessedAt  *time.Time `json:"processed_at"`
+	PaidAt       *time.Time `json:"paid_at"`
+	FailureReason *string   `json:"failure_reason"`
 	Metadata     string    `gorm:"type:jsonb" json:"metadata"`
+	Items        []PayoutItem `gorm:"foreignKey:PayoutID" json:"items,omitempty"`
+}
+
+// PayoutItem is one order's earnings included in a payout. An order's earnings are only
+// ever paid to a seller once; the item is removed again if the payout fails before its
+// funds are transferred.
+type PayoutItem struct {
+	common.BaseModel
+	PayoutID   uuid.UUID `gorm:"not null;index" json:"payout_id"`
+	OrderID    uuid.UUID `gorm:"not null;uniqueIndex:idx_payout_item_order_seller" json:"order_id"`
+	SellerID   uuid.UUID `gorm:"not null;uniqueIndex:idx_payout_item_order_seller" json:"seller_id"`
+	Gross      int64     `gorm:"not null" json:"gross"`
+	Commission int64     `gorm:"not null;default:0" json:"commission"`
+	Refunded   int64     `gorm:"not null;default:0" json:"refunded"`
+	Net        int64     `gorm:"not null" json:"net"`
+}
+
+// PayoutSettings holds where and how often a seller is paid out
+type PayoutSettings struct {
+	common.BaseModel
+	SellerID      uuid.UUID  `gorm:"not null;uniqueIndex" json:"seller_id"`
+	Schedule      string     `gorm:"not null;default:'weekly'" json:"schedule"` // daily, weekly, manual
+	WeeklyAnchor  int        `gorm:"not null;default:1" json:"weekly_anchor"`   // weekday of weekly payouts, 0 is Sunday
+	Method        string     `gorm:"not null" json:"method"`                    // stripe_connect
+	Destination   string     `gorm:"not null" json:"destination"`               // Connected account ID
+	MinimumAmount int64      `gorm:"not null;default:0" json:"minimum_amount"`
+	LastRunAt     *time.Time `json:"last_run_at"`
 }
 
 // Subscription represents a user subscription
@@ -136,19 +278,75 @@ type Subscription struct {
 	User            User       `gorm:"foreignKey:UserID" json:"user,omitempty"`
 	PlanID          string     `gorm:"not null" json:"plan_id"`
 	PlanName        string     `gorm:"not null" json:"plan_name"`
-	Status          string     `gorm:"not null;default:'active'" json:"status"` // active, past_due, canceled, unpaid
+	Status          string     `gorm:"not null;default:'active'" json:"status"` // trialing, active, past_due, canceled, unpaid
+	PendingPlanID   *string    `json:"pending_plan_id"` // plan taking over at the next renewal, e.g. after a downgrade
 	CurrentPeriodStart time.Time `gorm:"not null" json:"current_period_start"`
-	CurrentPeriodEnd   time.Time `gorm:"not null" json:"current_period_end"`
+	CurrentPeriodEnd   time.Time `gorm:"not null;index" json:"current_period_end"`
 	CancelAtPeriodEnd bool       `gorm:"default:false" json:"cancel_at_period_end"`
-	GatewayRef      string     `gorm:"not null" json:"gateway_ref"`
+	PaymentMethodRef string     `json:"-"` // tokenized method renewals are charged to
+	FailedAttempts  int        `gorm:"default:0" json:"failed_attempts"` // failed renewal charges since the last success
+	NextRetryAt     *time.Time `gorm:"index" json:"next_retry_at"`
+	GatewayRef      string     `gorm:"not null" json:"gateway_ref"` // gateway reference of the latest charge
 	GatewayType     string     `gorm:"not null" json:"gateway_type"`
 	TrialStart      *time.Time `json:"trial_start"`
 	TrialEnd        *time.Time `json:"trial_end"`
 	CanceledAt      *time.Time `json:"canceled_at"`
 	EndedAt         *time.Time `json:"ended_at"`
-	Amount          float64    `gorm:"not null" json:"amount"`
+	Amount          int64      `gorm:"not null" json:"amount"`
 	Currency        string     `gorm:"not null;default:'USD'" json:"currency"`
 	Interval        string     `gorm:"not null" json:"interval"` // month, year
 	IntervalCount   int        `gorm:"not null;default:1" json:"interval_count"`
 	Metadata        string     `gorm:"type:jsonb" json:"metadata"`
-}
\ No newline at end of file
+}
+
+// WebhookEvent is a payment gateway webhook delivery. Events are stored before they are
+// processed so redeliveries are recognised by event ID and failures can be retried.
+type WebhookEvent struct {
+	common.BaseModel
+	Gateway       string     `gorm:"not null;uniqueIndex:idx_webhook_gateway_event" json:"gateway"`
+	EventID       string     `gorm:"not null;uniqueIndex:idx_webhook_gateway_event" json:"event_id"`
+	Type          string     `gorm:"not null;index" json:"type"`
+	Payload       string     `gorm:"type:text;not null" json:"payload"`              // the verified event as JSON
+	Status        string     `gorm:"not null;default:'pending';index" json:"status"` // pending, processing, processed, failed
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	LastError     *string    `json:"last_error"`
+	NextAttemptAt *time.Time `gorm:"index" json:"next_attempt_at"` // unset once processed or out of retries
+	ProcessedAt   *time.Time `json:"processed_at"`
+}
+
+// GiftCard is a prepaid code redeemable at checkout until its balance runs out or it expires
+type GiftCard struct {
+	common.BaseModel
+	Code           string     `gorm:"not null;uniqueIndex" json:"code"`
+	Currency       string     `gorm:"size:3;not null;default:'USD'" json:"currency"`
+	InitialBalance int64      `gorm:"not null" json:"initial_balance"`
+	Balance        int64      `gorm:"not null" json:"balance"`
+	Status         string     `gorm:"not null;default:'active';index" json:"status"` // active, expired
+	ExpiresAt      *time.Time `gorm:"index" json:"expires_at"`
+	IssuedBy       uuid.UUID  `gorm:"not null;references:ID" json:"issued_by"`
+	RecipientEmail *string    `json:"recipient_email"`
+	Note           *string    `json:"note"`
+}
+
+// StoreCreditWallet is a user's store credit balance in one currency
+type StoreCreditWallet struct {
+	common.BaseModel
+	UserID   uuid.UUID `gorm:"not null;uniqueIndex:idx_store_credit_wallet" json:"user_id"`
+	Currency string    `gorm:"size:3;not null;uniqueIndex:idx_store_credit_wallet" json:"currency"`
+	Balance  int64     `gorm:"not null;default:0" json:"balance"`
+}
+
+// CreditMovement is a change to a gift card's or store credit wallet's balance. Each one
+// is posted to the ledger.
+type CreditMovement struct {
+	common.BaseModel
+	GiftCardID  *uuid.UUID `gorm:"index" json:"gift_card_id"`
+	WalletID    *uuid.UUID `gorm:"index" json:"wallet_id"`
+	UserID      uuid.UUID  `gorm:"not null;index" json:"user_id"` // the wallet owner, or who issued or redeemed the gift card
+	Type        string     `gorm:"not null" json:"type"`          // issue, grant, refund, redeem, restore, expire
+	Amount      int64      `gorm:"not null" json:"amount"`        // positive when the balance grows
+	Currency    string     `gorm:"size:3;not null" json:"currency"`
+	OrderID     *uuid.UUID `gorm:"index" json:"order_id"`
+	RefundID    *uuid.UUID `json:"refund_id"`
+	Description string     `json:"description"`
+}
@@ -0,0 +1,170 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/blytz.live.remake/backend/internal/addresses"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/pkg/money"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrAuctionNotWon is returned when creating an order for an auction that has not ended
+// with a winner
+var ErrAuctionNotWon = errors.New("auction has not ended with a winner")
+
+// CreateAuctionOrder creates the order for an ended auction's winning bid and charges it
+// off-session to the winner's default payment method. Tax and shipping are charged to
+// the winner's default shipping address when they have one. When the charge fails the
+// order stays pending with a payment intent the winner completes themselves. Ending an
+// auction twice returns the order created the first time.
+func (s *Service) CreateAuctionOrder(ctx context.Context, auctionID uuid.UUID) (*models.Order, error) {
+	var auction models.Auction
+	if err := s.db.WithContext(ctx).First(&auction, "id = ?", auctionID).Error; err != nil {
+		return nil, fmt.Errorf("auction not found: %w", err)
+	}
+	if auction.Status != "ended" || auction.WinnerID == nil {
+		return nil, ErrAuctionNotWon
+	}
+	winnerID := *auction.WinnerID
+
+	existing, err := s.auctionOrder(ctx, auctionID)
+	if existing != nil || err != nil {
+		return existing, err
+	}
+
+	var winningBid models.Bid
+	if err := s.db.WithContext(ctx).
+		Where("auction_id = ? AND user_id = ?", auctionID, winnerID).
+		Order("amount DESC").
+		First(&winningBid).Error; err != nil {
+		return nil, fmt.Errorf("winning bid not found: %w", err)
+	}
+
+	var shippingAddress *models.Address
+	var address addresses.Address
+	err = s.db.WithContext(ctx).
+		Where("user_id = ? AND type = ? AND is_default = ?", winnerID, "shipping", true).
+		First(&address).Error
+	if err == nil {
+		shippingAddress = &models.Address{
+			FirstName:    address.FirstName,
+			LastName:     address.LastName,
+			Company:      address.Company,
+			AddressLine1: address.AddressLine1,
+			AddressLine2: address.AddressLine2,
+			City:         address.City,
+			State:        address.State,
+			PostalCode:   address.PostalCode,
+			Country:      address.Country,
+			Phone:        address.Phone,
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to get shipping address: %w", err)
+	}
+
+	// Calculate totals in the auction's currency, in minor units
+	subtotal := money.New(winningBid.Amount, auction.Currency)
+	taxAmount := money.Zero(subtotal.Currency)
+	shippingCost := money.Zero(subtotal.Currency)
+	if shippingAddress != nil {
+		reverseCharged, err := s.reverseChargedSellers(ctx, winnerID, shippingAddress.Country, []uuid.UUID{auction.SellerID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to check reverse charge: %w", err)
+		}
+		if !reverseCharged[auction.SellerID] {
+			taxAmount = s.calculateTax(subtotal, Address(*shippingAddress))
+		}
+		shippingCost, err = s.calculateShippingCost(Address(*shippingAddress), 1, subtotal.Currency)
+		if err != nil {
+			return nil, err
+		}
+	}
+	totalAmount := subtotal.Amount + taxAmount.Amount + shippingCost.Amount
+
+	order := models.Order{
+		UserID:          winnerID,
+		Status:          "pending",
+		TotalAmount:     totalAmount,
+		Currency:        subtotal.Currency,
+		Subtotal:        subtotal.Amount,
+		TaxAmount:       taxAmount.Amount,
+		ShippingCost:    shippingCost.Amount,
+		ShippingAddress: shippingAddress,
+		Notes:           stringPtr(fmt.Sprintf("Won at auction: %s", auction.Title)),
+	}
+	order.ID = uuid.New()
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&order).Error; err != nil {
+			return fmt.Errorf("failed to create order: %w", err)
+		}
+
+		item := models.OrderItem{
+			OrderID:   order.ID,
+			ProductID: auction.ProductID,
+			Quantity:  1,
+			UnitPrice: subtotal.Amount,
+			Total:     subtotal.Amount,
+			AuctionID: &auctionID,
+		}
+		item.ID = uuid.New()
+		if err := tx.Create(&item).Error; err != nil {
+			return fmt.Errorf("failed to create order item: %w", err)
+		}
+		order.Items = []models.OrderItem{item}
+
+		if err := s.reserveStock(tx, auction.ProductID, 1); err != nil {
+			return fmt.Errorf("failed to reserve stock: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		// The unique auction_id index stops a concurrent call creating a second order;
+		// the order it created is returned instead
+		if existing, findErr := s.auctionOrder(ctx, auctionID); findErr == nil && existing != nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+
+	if s.payments == nil {
+		return &order, nil
+	}
+
+	// The winner is not around to confirm the payment, so their default method is
+	// charged; when that fails they pay for the order themselves
+	if _, err := s.payments.ChargeOrderDefaultPaymentMethod(ctx, winnerID, order.ID); err != nil {
+		if _, openErr := s.payments.CreateOrderPaymentIntent(ctx, winnerID, order.ID, "card"); openErr != nil {
+			return nil, fmt.Errorf("failed to charge auction winner: %v (and failed to open payment: %w)", err, openErr)
+		}
+		return &order, nil
+	}
+
+	// Paying the order moved it to processing
+	if err := s.db.WithContext(ctx).First(&order, "id = ?", order.ID).Error; err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// auctionOrder returns the order created for an auction, or nil when there is none yet
+func (s *Service) auctionOrder(ctx context.Context, auctionID uuid.UUID) (*models.Order, error) {
+	var existing models.OrderItem
+	err := s.db.WithContext(ctx).Where("auction_id = ?", auctionID).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var order models.Order
+	if err := s.db.WithContext(ctx).Preload("Items").First(&order, "id = ?", existing.OrderID).Error; err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
@@ -0,0 +1,274 @@
+package orders
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/pkg/money"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PaymentOpener opens the gateway payments that pay for orders
+type PaymentOpener interface {
+	// CreateOrderPaymentIntent opens the payment a buyer completes to pay for an order
+	CreateOrderPaymentIntent(ctx context.Context, userID, orderID uuid.UUID, paymentMethod string) (*models.PaymentIntent, error)
+	// ChargeOrderDefaultPaymentMethod pays an order off-session with the buyer's default
+	// payment method
+	ChargeOrderDefaultPaymentMethod(ctx context.Context, userID, orderID uuid.UUID) (*models.Payment, error)
+	// RecordCreditPayment records the payment of an order paid in full by gift cards and
+	// store credit, within the transaction that marks it paid
+	RecordCreditPayment(ctx context.Context, tx *gorm.DB, order *models.Order) (*models.Payment, error)
+}
+
+// CheckoutPayment is the payment intent the client completes to pay for a new order
+type CheckoutPayment struct {
+	PaymentIntentID uuid.UUID `json:"payment_intent_id"`
+	ClientSecret    string    `json:"client_secret"`
+	Amount          int64     `json:"amount"`
+	Currency        string    `json:"currency"`
+	Status          string    `json:"status"`
+	ExpiresAt       time.Time `json:"expires_at"`
+}
+
+// FeeAssessor charges marketplace fees on an order once it is paid. It runs in the
+// transaction that marks the order paid.
+type FeeAssessor interface {
+	AssessOrderFees(ctx context.Context, tx *gorm.DB, orderID uuid.UUID) ([]models.OrderFee, error)
+}
+
+// CreditRedeemer applies gift cards and store credit to orders. Each method runs in the
+// order's transaction.
+type CreditRedeemer interface {
+	// RedeemForOrder applies a gift card, then the buyer's store credit, to at most amount
+	// of a new order and returns the amount applied
+	RedeemForOrder(ctx context.Context, tx *gorm.DB, userID, orderID uuid.UUID, amount money.Money, giftCardCode string, useStoreCredit bool) (money.Money, error)
+	// SettleOrderCredit pays the credit applied to an order to its sellers once it is paid
+	SettleOrderCredit(ctx context.Context, tx *gorm.DB, orderID uuid.UUID) error
+	// RestoreOrderCredit returns the credit applied to an order released unpaid
+	RestoreOrderCredit(ctx context.Context, tx *gorm.DB, orderID uuid.UUID) error
+}
+
+// Invoicer issues the sellers' invoices for paid orders and credit notes for their
+// refunds. Each method runs in the transaction that records the payment or refund.
+type Invoicer interface {
+	IssueOrderInvoices(ctx context.Context, tx *gorm.DB, orderID uuid.UUID) error
+	IssueCreditNotes(ctx context.Context, tx *gorm.DB, refund *models.Refund) error
+}
+
+// SetFeeAssessor sets the fee engine that charges paid orders
+func (s *Service) SetFeeAssessor(fees FeeAssessor) {
+	s.fees = fees
+}
+
+// SetPaymentOpener sets the payment service used to open a payment for every new order
+func (s *Service) SetPaymentOpener(payments PaymentOpener) {
+	s.payments = payments
+}
+
+// SetCreditRedeemer sets the service that applies gift cards and store credit at checkout
+func (s *Service) SetCreditRedeemer(credits CreditRedeemer) {
+	s.credits = credits
+}
+
+// SetInvoicer sets the service that issues invoices and credit notes for orders
+func (s *Service) SetInvoicer(invoicer Invoicer) {
+	s.invoicer = invoicer
+}
+
+// openPayment opens the payment for a newly created order. When that fails the order is
+// released again so its stock is not held by an order nobody can pay.
+func (s *Service) openPayment(userID, orderID uuid.UUID, paymentMethod string) (*CheckoutPayment, error) {
+	ctx := context.Background()
+
+	intent, err := s.payments.CreateOrderPaymentIntent(ctx, userID, orderID, paymentMethod)
+	if err != nil {
+		if releaseErr := s.ReleaseOrder(ctx, orderID, "payment_unavailable"); releaseErr != nil {
+			return nil, fmt.Errorf("failed to open payment: %v (and failed to release order: %w)", err, releaseErr)
+		}
+		return nil, fmt.Errorf("failed to open payment: %w", err)
+	}
+
+	return &CheckoutPayment{
+		PaymentIntentID: intent.ID,
+		ClientSecret:    intent.ClientSecret,
+		Amount:          intent.Amount,
+		Currency:        intent.Currency,
+		Status:          intent.Status,
+		ExpiresAt:       intent.ExpiresAt,
+	}, nil
+}
+
+// MarkOrderPaid records a completed payment on its order and moves a pending order to
+// processing. An order cancelled before its payment completed keeps its status but
+// records the payment, so it can be refunded.
+func (s *Service) MarkOrderPaid(ctx context.Context, orderID, paymentID uuid.UUID) error {
+	return s.markPaid(ctx, orderID, &paymentID)
+}
+
+// payWithCredit marks a new order paid in full by gift cards and store credit. When that
+// fails the order is released again, returning the credit.
+func (s *Service) payWithCredit(orderID uuid.UUID) error {
+	ctx := context.Background()
+
+	if err := s.markPaid(ctx, orderID, nil); err != nil {
+		if releaseErr := s.ReleaseOrder(ctx, orderID, "payment_unavailable"); releaseErr != nil {
+			return fmt.Errorf("failed to pay order with credit: %v (and failed to release order: %w)", err, releaseErr)
+		}
+		return fmt.Errorf("failed to pay order with credit: %w", err)
+	}
+	return nil
+}
+
+// markPaid moves a pending order to processing, recording the payment when part of it
+// was paid by card. Only the call that moves the order out of pending charges its fees,
+// settles its credit and issues its invoices.
+func (s *Service) markPaid(ctx context.Context, orderID uuid.UUID, paymentID *uuid.UUID) error {
+	var order models.Order
+	if err := s.db.WithContext(ctx).First(&order, "id = ?", orderID).Error; err != nil {
+		return fmt.Errorf("order not found: %w", err)
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{
+			"status":     "processing",
+			"updated_at": time.Now(),
+		}
+		if paymentID != nil {
+			updates["payment_id"] = *paymentID
+		}
+		result := tx.Model(&models.Order{}).
+			Where("id = ? AND status = ?", orderID, "pending").
+			Updates(updates)
+		if result.Error != nil {
+			return fmt.Errorf("failed to update order: %w", result.Error)
+		}
+
+		if result.RowsAffected == 0 {
+			// Paid already or cancelled meanwhile; a new card payment is still recorded
+			if paymentID == nil {
+				return nil
+			}
+			if err := tx.Model(&models.Order{}).
+				Where("id = ? AND (payment_id IS NULL OR payment_id <> ?)", orderID, *paymentID).
+				Updates(map[string]interface{}{"payment_id": *paymentID, "updated_at": time.Now()}).Error; err != nil {
+				return fmt.Errorf("failed to update order: %w", err)
+			}
+			return nil
+		}
+
+		// Orders paid without a card still get a payment for their refunds to be made against
+		if paymentID == nil && s.payments != nil {
+			payment, err := s.payments.RecordCreditPayment(ctx, tx, &order)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&models.Order{}).Where("id = ?", orderID).Update("payment_id", payment.ID).Error; err != nil {
+				return fmt.Errorf("failed to update order: %w", err)
+			}
+		}
+
+		if s.fees != nil {
+			if _, err := s.fees.AssessOrderFees(ctx, tx, orderID); err != nil {
+				return fmt.Errorf("failed to assess order fees: %w", err)
+			}
+		}
+		if s.credits != nil {
+			if err := s.credits.SettleOrderCredit(ctx, tx, orderID); err != nil {
+				return fmt.Errorf("failed to settle order credit: %w", err)
+			}
+		}
+		if s.invoicer != nil {
+			if err := s.invoicer.IssueOrderInvoices(ctx, tx, orderID); err != nil {
+				return fmt.Errorf("failed to issue invoices: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// ReleaseOrder cancels a pending order whose payment failed or expired, releases its
+// reserved stock and returns the credit applied to it. Orders that are no longer pending are left alone.
+func (s *Service) ReleaseOrder(ctx context.Context, orderID uuid.UUID, reason string) error {
+	tx := s.db.WithContext(ctx).Begin()
+
+	// Only a pending order is cancelled, so concurrent releases give stock back once
+	result := tx.Model(&models.Order{}).
+		Where("id = ? AND status = ?", orderID, "pending").
+		Updates(map[string]interface{}{
+			"status":     "cancelled",
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to cancel order: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return nil
+	}
+
+	if err := s.releaseStockReservations(tx, orderID, reason); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to release stock: %w", err)
+	}
+
+	if s.credits != nil {
+		if err := s.credits.RestoreOrderCredit(ctx, tx, orderID); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to restore order credit: %w", err)
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// ApplyOrderRefund updates an order for a refund within the refund's transaction. Refunded
+// items marked for restock return to available stock, the refund gets its credit notes
+// and an order refunded in full is marked refunded.
+func (s *Service) ApplyOrderRefund(ctx context.Context, tx *gorm.DB, refund *models.Refund, fullyRefunded bool) error {
+	if refund.OrderID == nil {
+		return nil
+	}
+
+	var order models.Order
+	if err := tx.WithContext(ctx).First(&order, "id = ?", *refund.OrderID).Error; err != nil {
+		return fmt.Errorf("order not found: %w", err)
+	}
+
+	// A cancelled order already gave its stock back
+	if refund.Restock && order.Status != "pending" && order.Status != "cancelled" {
+		for _, item := range refund.Items {
+			var orderItem models.OrderItem
+			if err := tx.WithContext(ctx).First(&orderItem, "id = ?", item.OrderItemID).Error; err != nil {
+				return fmt.Errorf("order item not found: %w", err)
+			}
+			notes := fmt.Sprintf("Restocked %d units from refund", item.Quantity)
+			if err := releaseStock(tx.WithContext(ctx), orderItem.ProductID, item.Quantity, "refund:"+refund.ID.String(), notes); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.invoicer != nil {
+		if err := s.invoicer.IssueCreditNotes(ctx, tx, refund); err != nil {
+			return fmt.Errorf("failed to issue credit notes: %w", err)
+		}
+	}
+
+	if fullyRefunded && order.Status != "cancelled" && order.Status != "refunded" {
+		if err := tx.WithContext(ctx).Model(&models.Order{}).Where("id = ?", order.ID).Updates(map[string]interface{}{
+			"status":     "refunded",
+			"updated_at": time.Now(),
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update order: %w", err)
+		}
+	}
+	return nil
+}
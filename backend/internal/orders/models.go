@@ -12,16 +12,19 @@ type Order struct {
 	ID              uuid.UUID  `json:"id"`
 	UserID          uuid.UUID  `json:"user_id"`
 	Status          string     `json:"status"` // pending, processing, shipped, delivered, cancelled
-	TotalAmount     float64    `json:"total_amount"`
-	Subtotal        float64    `json:"subtotal"`
-	TaxAmount       float64    `json:"tax_amount"`
-	ShippingCost    float64    `json:"shipping_cost"`
-	DiscountAmount  float64    `json:"discount_amount"`
-	ShippingAddress *Address   `json:"shipping_address"`
-	BillingAddress  *Address   `json:"billing_address"`
+	TotalAmount     int64      `json:"total_amount"`
+	Currency        string     `json:"currency"`
+	Subtotal        int64      `json:"subtotal"`
+	TaxAmount       int64      `json:"tax_amount"`
+	ShippingCost    int64      `json:"shipping_cost"`
+	DiscountAmount  int64      `json:"discount_amount"`
+	CreditAmount    int64      `json:"credit_amount"`
+	ShippingAddress *Address   `gorm:"embedded;embeddedPrefix:shipping_" json:"shipping_address"`
+	BillingAddress  *Address   `gorm:"embedded;embeddedPrefix:billing_" json:"billing_address"`
 	PaymentID       *uuid.UUID `json:"payment_id"`
 	TrackingNumber  *string    `json:"tracking_number"`
 	Notes           *string    `json:"notes"`
+	DeliveredAt     *time.Time `json:"delivered_at"`
 	Items          []OrderItem `json:"items"`
 	CreatedAt      time.Time   `json:"created_at"`
 	UpdatedAt      time.Time   `json:"updated_at"`
@@ -33,8 +36,8 @@ type OrderItem struct {
 	OrderID     uuid.UUID  `json:"order_id"`
 	ProductID   uuid.UUID  `json:"product_id"`
 	Quantity    int        `json:"quantity"`
-	UnitPrice   float64    `json:"unit_price"`
-	Total       float64    `json:"total"`
+	UnitPrice   int64      `json:"unit_price"`
+	Total       int64      `json:"total"`
 	Product     models.Product `json:"product"`  // GORM will preload this
 	CreatedAt   time.Time   `json:"created_at"`
 }
@@ -59,9 +62,10 @@ type ProductResponse struct {
 	Title         string     `json:"title"`
 	Description   *string    `json:"description"`
 	Condition     *string    `json:"condition"`
-	StartingPrice float64    `json:"starting_price"`
-	ReservePrice  *float64   `json:"reserve_price"`
-	BuyNowPrice   *float64   `json:"buy_now_price"`
+	StartingPrice int64      `json:"starting_price"`
+	ReservePrice  *int64     `json:"reserve_price"`
+	BuyNowPrice   *int64     `json:"buy_now_price"`
+	Currency      string     `json:"currency"`
 	Images        []string   `json:"images"`
 	Status        string     `json:"status"`
 }
@@ -73,6 +77,8 @@ type OrderCreateRequest struct {
 	BillingAddress   Address    `json:"billing_address"`
 	PaymentMethod    string     `json:"payment_method" binding:"required"`
 	Notes            *string    `json:"notes"`
+	GiftCardCode     string     `json:"gift_card_code"`   // redeemed first
+	UseStoreCredit   bool       `json:"use_store_credit"` // applied after the gift card; a card pays the rest
 }
 
 // OrderItemResponse represents order item in response context
@@ -81,8 +87,8 @@ type OrderItemResponse struct {
 	OrderID     uuid.UUID                `json:"order_id"`
 	ProductID   uuid.UUID                `json:"product_id"`
 	Quantity    int                      `json:"quantity"`
-	UnitPrice   float64                  `json:"unit_price"`
-	Total       float64                  `json:"total"`
+	UnitPrice   int64                    `json:"unit_price"`
+	Total       int64                    `json:"total"`
 	Product     ProductResponse          `json:"product"`
 	CreatedAt   time.Time                `json:"created_at"`
 }
@@ -92,21 +98,26 @@ type OrderResponse struct {
 	ID              uuid.UUID           `json:"id"`
 	UserID          uuid.UUID           `json:"user_id"`
 	Status          string              `json:"status"`
-	TotalAmount     float64             `json:"total_amount"`
-	Subtotal        float64             `json:"subtotal"`
-	TaxAmount       float64             `json:"tax_amount"`
-	ShippingCost    float64             `json:"shipping_cost"`
-	DiscountAmount  float64             `json:"discount_amount"`
+	TotalAmount     int64               `json:"total_amount"`
+	Currency        string              `json:"currency"`
+	Subtotal        int64               `json:"subtotal"`
+	TaxAmount       int64               `json:"tax_amount"`
+	ShippingCost    int64               `json:"shipping_cost"`
+	DiscountAmount  int64               `json:"discount_amount"`
+	CreditAmount    int64               `json:"credit_amount"` // paid with gift cards and store credit
 	ShippingAddress *Address            `json:"shipping_address"`
 	BillingAddress  *Address            `json:"billing_address"`
 	PaymentID       *uuid.UUID          `json:"payment_id"`
 	TrackingNumber  *string             `json:"tracking_number"`
 	Notes           *string             `json:"notes"`
+	DeliveredAt     *time.Time          `json:"delivered_at,omitempty"`
 	Items          []OrderItemResponse `json:"items"`
+	Fees           []models.OrderFee   `json:"fees,omitempty"` // marketplace fees charged when the order was paid
 	ItemCount      int                  `json:"item_count"`
 	TotalQuantity   int                  `json:"total_quantity"`
 	CreatedAt      time.Time            `json:"created_at"`
 	UpdatedAt      time.Time            `json:"updated_at"`
+	Payment        *CheckoutPayment     `json:"payment,omitempty"` // set when the order is created
 }
 
 // OrderListRequest represents order list query parameters
@@ -126,9 +137,9 @@ type UpdateOrderStatusRequest struct {
 // OrderStatistics represents order statistics
 type OrderStatistics struct {
 	TotalOrders      int     `json:"total_orders"`
-	TotalRevenue     float64 `json:"total_revenue"`
+	TotalRevenue     int64   `json:"total_revenue"`
 	TotalItems       int     `json:"total_items"`
-	AverageOrderValue float64 `json:"average_order_value"`
+	AverageOrderValue int64   `json:"average_order_value"`
 	PendingOrders    int     `json:"pending_orders"`
 	ProcessingOrders int     `json:"processing_orders"`
 	ShippedOrders    int     `json:"shipped_orders"`
@@ -139,7 +150,7 @@ type OrderStatistics struct {
 // OrderSummary represents order summary for seller
 type OrderSummary struct {
 	OrderID     uuid.UUID `json:"order_id"`
-	TotalAmount  float64   `json:"total_amount"`
+	TotalAmount  int64     `json:"total_amount"`
 	Status       string     `json:"status"`
 	ItemCount    int        `json:"item_count"`
 	CustomerName string     `json:"customer_name"`
@@ -1,6 +1,7 @@
 package orders
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/blytz.live.remake/backend/internal/cart"
 	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/pkg/money"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
@@ -24,6 +26,10 @@ func stringPtr(s string) *string {
 type Service struct {
 	db          *gorm.DB
 	cartService *cart.Service
+	payments    PaymentOpener
+	fees        FeeAssessor
+	credits     CreditRedeemer
+	invoicer    Invoicer
 }
 
 // NewService creates a new order service
@@ -48,7 +54,8 @@ func (s *Service) CreateOrder(userID uuid.UUID, req OrderCreateRequest) (*OrderR
 	}
 
 	// Validate all products are still available and prices haven't changed
-	for _, item := range cart.Items {
+	sellerIDs := make([]uuid.UUID, len(cart.Items))
+	for i, item := range cart.Items {
 		var product models.Product
 		if err := s.db.First(&product, item.ProductID).Error; err != nil {
 			return nil, fmt.Errorf("product %s not found: %w", item.ProductID, err)
@@ -57,19 +64,39 @@ func (s *Service) CreateOrder(userID uuid.UUID, req OrderCreateRequest) (*OrderR
 		if product.Status != "active" {
 			return nil, fmt.Errorf("product %s is no longer available", product.Title)
 		}
+		sellerIDs[i] = product.SellerID
+
+		if product.Currency != cart.Currency {
+			return nil, fmt.Errorf("%w: product %s is priced in %s, the cart in %s", money.ErrCurrencyMismatch, product.Title, product.Currency, cart.Currency)
+		}
 
 		// Check if price has changed significantly (more than 5%)
-		priceDifference := (product.StartingPrice - item.Product.StartingPrice) / item.Product.StartingPrice
+		priceDifference := float64(product.StartingPrice-item.Product.StartingPrice) / float64(item.Product.StartingPrice)
 		if priceDifference > 0.05 || priceDifference < -0.05 {
 			return nil, fmt.Errorf("price for product %s has changed", product.Title)
 		}
 	}
 
-	// Calculate totals
-	subtotal := cart.Subtotal
-	taxAmount := s.calculateTax(subtotal, req.ShippingAddress)
-	shippingCost := s.calculateShippingCost(req.ShippingAddress, cart.TotalItems)
-	totalAmount := subtotal + taxAmount + shippingCost
+	// Calculate totals in the cart's currency, in minor units
+	subtotal := money.New(cart.Subtotal, cart.Currency)
+
+	// Sales reverse charged to a VAT-registered business buyer carry no VAT
+	reverseCharged, err := s.reverseChargedSellers(context.Background(), userID, req.ShippingAddress.Country, sellerIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check reverse charge: %w", err)
+	}
+	taxable := subtotal
+	for i, item := range cart.Items {
+		if reverseCharged[sellerIDs[i]] {
+			taxable.Amount -= item.LineTotal
+		}
+	}
+	taxAmount := s.calculateTax(taxable, req.ShippingAddress)
+	shippingCost, err := s.calculateShippingCost(req.ShippingAddress, cart.TotalItems, subtotal.Currency)
+	if err != nil {
+		return nil, err
+	}
+	totalAmount := subtotal.Amount + taxAmount.Amount + shippingCost.Amount
 
 	// Create order
 	order := Order{
@@ -77,9 +104,10 @@ func (s *Service) CreateOrder(userID uuid.UUID, req OrderCreateRequest) (*OrderR
 		UserID:          userID,
 		Status:          "pending",
 		TotalAmount:     totalAmount,
-		Subtotal:        subtotal,
-		TaxAmount:       taxAmount,
-		ShippingCost:    shippingCost,
+		Currency:        subtotal.Currency,
+		Subtotal:        subtotal.Amount,
+		TaxAmount:       taxAmount.Amount,
+		ShippingCost:    shippingCost.Amount,
 		DiscountAmount:  0,
 		ShippingAddress: &req.ShippingAddress,
 		BillingAddress:  &req.BillingAddress,
@@ -91,6 +119,16 @@ func (s *Service) CreateOrder(userID uuid.UUID, req OrderCreateRequest) (*OrderR
 	// Start transaction
 	tx := s.db.Begin()
 
+	// Gift cards and store credit pay what they cover; a card pays the rest
+	if s.credits != nil && (req.GiftCardCode != "" || req.UseStoreCredit) {
+		credit, err := s.credits.RedeemForOrder(context.Background(), tx, userID, order.ID, money.New(totalAmount, subtotal.Currency), req.GiftCardCode, req.UseStoreCredit)
+		if err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to apply credit: %w", err)
+		}
+		order.CreditAmount = credit.Amount
+	}
+
 	// Save order
 	if err := tx.Create(&order).Error; err != nil {
 		tx.Rollback()
@@ -127,7 +165,7 @@ func (s *Service) CreateOrder(userID uuid.UUID, req OrderCreateRequest) (*OrderR
 	}
 
 	// Clear cart
-	if err := s.cartService.ClearCart(req.CartID); err != nil {
+	if err := s.cartService.WithTx(tx).ClearCart(req.CartID); err != nil {
 		tx.Rollback()
 		return nil, fmt.Errorf("failed to clear cart: %w", err)
 	}
@@ -137,8 +175,28 @@ func (s *Service) CreateOrder(userID uuid.UUID, req OrderCreateRequest) (*OrderR
 		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
+	// Open the payment the buyer completes to confirm the order, unless credit paid it all
+	var payment *CheckoutPayment
+	if order.CreditAmount > 0 && order.CreditAmount >= totalAmount {
+		if err := s.payWithCredit(order.ID); err != nil {
+			return nil, err
+		}
+		order.Status = "processing"
+	} else if s.payments != nil {
+		payment, err = s.openPayment(userID, order.ID, req.PaymentMethod)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Convert to response
-	return s.orderToResponse(&order, orderItems)
+	response, err := s.orderToResponse(&order, orderItems)
+	if err != nil {
+		return nil, err
+	}
+	response.Payment = payment
+
+	return response, nil
 }
 
 // GetOrder gets order by ID with user validation
@@ -245,6 +303,11 @@ func (s *Service) UpdateOrderStatus(orderID uuid.UUID, req UpdateOrderStatusRequ
 		updates["notes"] = req.Notes
 	}
 
+	// Delivery starts the holding period before the seller can be paid out
+	if req.Status == "delivered" {
+		updates["delivered_at"] = time.Now()
+	}
+
 	if err := s.db.Model(&order).Updates(updates).Error; err != nil {
 		return nil, fmt.Errorf("failed to update order: %w", err)
 	}
@@ -288,7 +351,7 @@ func (s *Service) CancelOrder(orderID, userID uuid.UUID) error {
 	}
 
 	// Release stock reservations
-	if err := s.releaseStockReservations(tx, orderID); err != nil {
+	if err := s.releaseStockReservations(tx, orderID, "order_cancellation"); err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to release stock: %w", err)
 	}
@@ -306,7 +369,7 @@ func (s *Service) GetOrderStatistics() (*OrderStatistics, error) {
 	var stats OrderStatistics
 
 	// Get total orders and revenue
-	var totalRevenue float64
+	var totalRevenue int64
 	if err := s.db.Model(&Order{}).
 		Select("COUNT(*) as total_orders, COALESCE(SUM(total_amount), 0) as total_revenue").
 		Where("status NOT IN (?)", []string{"cancelled"}).
@@ -326,7 +389,7 @@ func (s *Service) GetOrderStatistics() (*OrderStatistics, error) {
 
 	// Calculate average order value
 	if stats.TotalOrders > 0 {
-		stats.AverageOrderValue = stats.TotalRevenue / float64(stats.TotalOrders)
+		stats.AverageOrderValue = stats.TotalRevenue / int64(stats.TotalOrders)
 	}
 
 	// Get status counts
@@ -362,13 +425,18 @@ func (s *Service) GetOrderStatistics() (*OrderStatistics, error) {
 
 // Helper functions
 
-// calculateTax calculates tax based on address (simplified)
-func (s *Service) calculateTax(subtotal float64, address Address) float64 {
+// calculateTax calculates tax based on address (simplified), rounded to the minor unit
+func (s *Service) calculateTax(subtotal money.Money, address Address) money.Money {
+	return subtotal.MulRate(TaxRate(address.Country))
+}
+
+// TaxRate returns the tax rate charged on orders shipped to a country (simplified)
+func TaxRate(country string) float64 {
 	// Simplified tax calculation - in production, use tax service API
 	taxRate := 0.08 // 8% default tax rate
 
 	// Different tax rates based on location (simplified)
-	switch address.Country {
+	switch country {
 	case "US":
 		// Could be different by state in production
 		taxRate = 0.08
@@ -382,30 +450,57 @@ func (s *Service) calculateTax(subtotal float64, address Address) float64 {
 		taxRate = 0.10 // Default international rate
 	}
 
-	return subtotal * taxRate
+	return taxRate
+}
+
+// shippingRates are the flat shipping rates in each currency orders can ship in, in
+// minor units
+type shippingRates struct {
+	Domestic          int64 // to the US
+	DomesticBulk      int64 // to the US, more than 5 items
+	Canada            int64
+	International     int64
+	InternationalBulk int64 // more than 3 items
+}
+
+var shippingRatesByCurrency = map[string]shippingRates{
+	"USD": {Domestic: 599, DomesticBulk: 1299, Canada: 1599, International: 2599, InternationalBulk: 4599},
+	"EUR": {Domestic: 549, DomesticBulk: 1199, Canada: 1499, International: 2399, InternationalBulk: 4199},
+	"GBP": {Domestic: 479, DomesticBulk: 1049, Canada: 1299, International: 2049, InternationalBulk: 3649},
+	"CAD": {Domestic: 819, DomesticBulk: 1779, Canada: 2189, International: 3559, InternationalBulk: 6299},
+	"AUD": {Domestic: 919, DomesticBulk: 1989, Canada: 2449, International: 3979, InternationalBulk: 7049},
+	"JPY": {Domestic: 900, DomesticBulk: 1950, Canada: 2400, International: 3900, InternationalBulk: 6900},
 }
 
-// calculateShippingCost calculates shipping cost (simplified)
-func (s *Service) calculateShippingCost(address Address, totalItems int) float64 {
-	// Simplified shipping cost calculation
-	baseCost := 5.99
+// ErrShippingCurrencyUnsupported is returned for orders in a currency without shipping
+// rates
+var ErrShippingCurrencyUnsupported = errors.New("shipping is not available in this currency")
+
+// calculateShippingCost calculates shipping cost (simplified) from the flat rates of the
+// order's currency
+func (s *Service) calculateShippingCost(address Address, totalItems int, currency string) (money.Money, error) {
+	rates, ok := shippingRatesByCurrency[money.NormalizeCurrency(currency)]
+	if !ok {
+		return money.Money{}, fmt.Errorf("%w: %s", ErrShippingCurrencyUnsupported, currency)
+	}
 
 	// Different rates based on location
+	baseCost := rates.Domestic
 	switch address.Country {
 	case "US":
 		if totalItems > 5 {
-			baseCost = 12.99
+			baseCost = rates.DomesticBulk
 		}
 	case "CA":
-		baseCost = 15.99
+		baseCost = rates.Canada
 	default: // International
-		baseCost = 25.99
+		baseCost = rates.International
 		if totalItems > 3 {
-			baseCost = 45.99
+			baseCost = rates.InternationalBulk
 		}
 	}
 
-	return baseCost
+	return money.New(baseCost, currency), nil
 }
 
 // reserveStock reserves stock for order
@@ -454,7 +549,7 @@ func (s *Service) reserveStock(tx *gorm.DB, productID uuid.UUID, quantity int) e
 }
 
 // releaseStockReservations releases stock for cancelled order
-func (s *Service) releaseStockReservations(tx *gorm.DB, orderID uuid.UUID) error {
+func (s *Service) releaseStockReservations(tx *gorm.DB, orderID uuid.UUID, reference string) error {
 	// Get order items
 	var items []OrderItem
 	if err := tx.Where("order_id = ?", orderID).Find(&items).Error; err != nil {
@@ -463,45 +558,53 @@ func (s *Service) releaseStockReservations(tx *gorm.DB, orderID uuid.UUID) error
 
 	// Release stock for each item
 	for _, item := range items {
-		var stock models.InventoryStock
-		if err := tx.Where("product_id = ?", item.ProductID).First(&stock).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				continue
-			}
-			return fmt.Errorf("failed to get stock: %w", err)
+		notes := fmt.Sprintf("Released %d units from cancelled order", item.Quantity)
+		if err := releaseStock(tx, item.ProductID, item.Quantity, reference, notes); err != nil {
+			return err
 		}
+	}
 
-		// Calculate new values
-		newReserved := stock.Reserved - item.Quantity
-		newAvailable := stock.Quantity - newReserved
+	return nil
+}
 
-		if newReserved < 0 {
-			newReserved = 0
+// releaseStock returns quantity reserved units of a product to available stock
+func releaseStock(tx *gorm.DB, productID uuid.UUID, quantity int, reference, notes string) error {
+	var stock models.InventoryStock
+	if err := tx.Where("product_id = ?", productID).First(&stock).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
 		}
+		return fmt.Errorf("failed to get stock: %w", err)
+	}
 
-		// Update stock
-		if err := tx.Model(&stock).
-			Updates(map[string]interface{}{
-				"reserved":  newReserved,
-				"available": newAvailable,
-			}).Error; err != nil {
-			return fmt.Errorf("failed to release stock: %w", err)
-		}
+	// Calculate new values
+	newReserved := stock.Reserved - quantity
+	if newReserved < 0 {
+		newReserved = 0
+	}
+	newAvailable := stock.Quantity - newReserved
 
-		// Record stock movement
-		movement := models.StockMovement{
-			ProductID:    item.ProductID,
-			MovementType: "release",
-			Quantity:     item.Quantity,
-			Reference:    stringPtr("order_cancellation"),
-			Notes:        stringPtr(fmt.Sprintf("Released %d units from cancelled order", item.Quantity)),
-		}
+	// Update stock
+	if err := tx.Model(&stock).
+		Updates(map[string]interface{}{
+			"reserved":  newReserved,
+			"available": newAvailable,
+		}).Error; err != nil {
+		return fmt.Errorf("failed to release stock: %w", err)
+	}
 
-		if err := tx.Create(&movement).Error; err != nil {
-			return fmt.Errorf("failed to record stock movement: %w", err)
-		}
+	// Record stock movement
+	movement := models.StockMovement{
+		ProductID:    productID,
+		MovementType: "release",
+		Quantity:     quantity,
+		Reference:    stringPtr(reference),
+		Notes:        stringPtr(notes),
 	}
 
+	if err := tx.Create(&movement).Error; err != nil {
+		return fmt.Errorf("failed to record stock movement: %w", err)
+	}
 	return nil
 }
 
@@ -513,6 +616,7 @@ func (s *Service) isValidStatusTransition(currentStatus, newStatus string) bool
 		"shipped":    {"delivered"},
 		"delivered":  {}, // Terminal state
 		"cancelled":  {}, // Terminal state
+		"refunded":   {}, // Terminal state, set by refunding the whole order
 	}
 
 	allowedStatuses, exists := validTransitions[currentStatus]
@@ -531,6 +635,11 @@ func (s *Service) isValidStatusTransition(currentStatus, newStatus string) bool
 
 // orderToResponse converts order to response
 func (s *Service) orderToResponse(order *Order, items []OrderItem) (*OrderResponse, error) {
+	var fees []models.OrderFee
+	if err := s.db.Where("order_id = ?", order.ID).Order("created_at ASC").Find(&fees).Error; err != nil {
+		return nil, fmt.Errorf("failed to get order fees: %w", err)
+	}
+
 	// Convert items to responses
 	itemResponses := make([]OrderItemResponse, len(items))
 	var totalQuantity int
@@ -550,6 +659,7 @@ func (s *Service) orderToResponse(order *Order, items []OrderItem) (*OrderRespon
 			StartingPrice: item.Product.StartingPrice,
 			ReservePrice:  item.Product.ReservePrice,
 			BuyNowPrice:   item.Product.BuyNowPrice,
+			Currency:      item.Product.Currency,
 			Images:        images,
 			Status:        item.Product.Status,
 		}
@@ -573,16 +683,20 @@ func (s *Service) orderToResponse(order *Order, items []OrderItem) (*OrderRespon
 		UserID:          order.UserID,
 		Status:          order.Status,
 		TotalAmount:     order.TotalAmount,
+		Currency:        order.Currency,
 		Subtotal:        order.Subtotal,
 		TaxAmount:       order.TaxAmount,
 		ShippingCost:    order.ShippingCost,
 		DiscountAmount:  order.DiscountAmount,
+		CreditAmount:    order.CreditAmount,
 		ShippingAddress: order.ShippingAddress,
 		BillingAddress:  order.BillingAddress,
 		PaymentID:       order.PaymentID,
 		TrackingNumber:  order.TrackingNumber,
 		Notes:           order.Notes,
+		DeliveredAt:     order.DeliveredAt,
 		Items:           itemResponses,
+		Fees:            fees,
 		ItemCount:       len(itemResponses),
 		TotalQuantity:   totalQuantity,
 		CreatedAt:       order.CreatedAt,
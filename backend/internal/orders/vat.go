@@ -0,0 +1,88 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// euMemberStates are the EU member states by ISO country code, with the prefix of their
+// VAT numbers where it differs
+var euMemberStates = map[string]string{
+	"AT": "AT", "BE": "BE", "BG": "BG", "CY": "CY", "CZ": "CZ", "DE": "DE", "DK": "DK",
+	"EE": "EE", "ES": "ES", "FI": "FI", "FR": "FR", "GR": "EL", "HR": "HR", "HU": "HU",
+	"IE": "IE", "IT": "IT", "LT": "LT", "LU": "LU", "LV": "LV", "MT": "MT", "NL": "NL",
+	"PL": "PL", "PT": "PT", "RO": "RO", "SE": "SE", "SI": "SI", "SK": "SK",
+}
+
+// ValidEUVATID reports whether taxID is a well-formed VAT number of the EU member state
+// country: its prefix followed by 2 to 12 letters and digits. The number is not checked
+// against VIES.
+func ValidEUVATID(taxID, country string) bool {
+	prefix, ok := euMemberStates[country]
+	if !ok {
+		return false
+	}
+	taxID = strings.ToUpper(strings.ReplaceAll(taxID, " ", ""))
+	number, ok := strings.CutPrefix(taxID, prefix)
+	if !ok || len(number) < 2 || len(number) > 12 {
+		return false
+	}
+	for _, r := range number {
+		if (r < '0' || r > '9') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// ReverseCharged reports whether VAT on goods a seller in sellerCountry ships to
+// shipTo is reverse charged: the buyer gave a valid VAT number of the member state the
+// goods ship to and the seller is established in another member state. The buyer then
+// accounts for the VAT, so none is charged.
+func ReverseCharged(buyerTaxID *string, shipTo, sellerCountry string) bool {
+	if buyerTaxID == nil || shipTo == sellerCountry {
+		return false
+	}
+	if _, ok := euMemberStates[sellerCountry]; !ok {
+		return false
+	}
+	return ValidEUVATID(*buyerTaxID, shipTo)
+}
+
+// reverseChargedSellers returns which of the sellers sell to the buyer under the reverse
+// charge when the goods ship to shipTo. Sellers without an address on their invoice
+// profile are taken to charge VAT.
+func (s *Service) reverseChargedSellers(ctx context.Context, buyerID uuid.UUID, shipTo string, sellerIDs []uuid.UUID) (map[uuid.UUID]bool, error) {
+	reverseCharged := make(map[uuid.UUID]bool)
+	if _, ok := euMemberStates[shipTo]; !ok {
+		return reverseCharged, nil
+	}
+
+	var buyer models.InvoiceProfile
+	err := s.db.WithContext(ctx).Where("user_id = ?", buyerID).First(&buyer).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return reverseCharged, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if buyer.TaxID == nil || !ValidEUVATID(*buyer.TaxID, shipTo) {
+		return reverseCharged, nil
+	}
+
+	var sellers []models.InvoiceProfile
+	if err := s.db.WithContext(ctx).Where("user_id IN ?", sellerIDs).Find(&sellers).Error; err != nil {
+		return nil, err
+	}
+	for _, seller := range sellers {
+		if seller.Address != nil && ReverseCharged(buyer.TaxID, shipTo, seller.Address.Country) {
+			reverseCharged[seller.UserID] = true
+		}
+	}
+	return reverseCharged, nil
+}
@@ -0,0 +1,411 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/ledger"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Dispute statuses, as reported by the gateway
+const (
+	DisputeNeedsResponse = "needs_response"
+	DisputeUnderReview   = "under_review"
+	DisputeWon           = "won"
+	DisputeLost          = "lost"
+)
+
+var (
+	// ErrDisputeNotAllowed is returned when a seller acts on a dispute of an order they
+	// sold nothing in
+	ErrDisputeNotAllowed = errors.New("dispute not allowed")
+	// ErrDisputeClosed is returned when submitting evidence to a dispute that no longer
+	// needs a response
+	ErrDisputeClosed = errors.New("dispute is not accepting evidence")
+	// ErrEvidenceOverdue is returned when submitting evidence after it was due
+	ErrEvidenceOverdue = errors.New("dispute evidence is overdue")
+	// ErrDisputeResolved is returned when resolving a dispute a second time
+	ErrDisputeResolved = errors.New("dispute is already resolved")
+	// ErrInvalidDisputeOutcome is returned for an outcome that contradicts the gateway's,
+	// or a missing one while the gateway has not closed the dispute
+	ErrInvalidDisputeOutcome = errors.New("invalid dispute outcome")
+)
+
+// DisputeEvidenceRequest is the evidence a seller submits to contest a dispute
+type DisputeEvidenceRequest struct {
+	TrackingNumber *string  `json:"tracking_number,omitempty"`
+	Carrier        *string  `json:"carrier,omitempty"`
+	Message        string   `json:"message" binding:"required"`
+	Photos         []string `json:"photos,omitempty" binding:"omitempty,dive,url"`
+}
+
+// ResolveDisputeRequest settles a dispute. The outcome defaults to the one the gateway
+// reported; a lost dispute is charged to the order's sellers unless the platform bears it.
+type ResolveDisputeRequest struct {
+	Outcome   string  `json:"outcome" binding:"omitempty,oneof=won lost"`
+	Liability string  `json:"liability" binding:"omitempty,oneof=seller platform"`
+	Notes     *string `json:"notes,omitempty"`
+}
+
+// DisputeFilter narrows a list of disputes
+type DisputeFilter struct {
+	Status   string
+	SellerID *uuid.UUID // disputes of orders the seller sold in
+	OpenOnly bool       // disputes not yet resolved
+}
+
+// handleDisputeEvent records a dispute opened, updated or closed at the gateway. The
+// first event for a dispute opens it: the payment is marked disputed and the withdrawn
+// funds are posted to the ledger.
+func (s *Service) handleDisputeEvent(ctx context.Context, event *GatewayEvent) error {
+	if event.DisputeRef == "" {
+		return fmt.Errorf("dispute event %s has no dispute", event.ID)
+	}
+
+	var dispute models.Dispute
+	err := s.db.WithContext(ctx).First(&dispute, "gateway_ref = ?", event.DisputeRef).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return s.openDispute(ctx, event)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Once an admin has settled a dispute its outcome stands
+	if dispute.ResolvedAt != nil {
+		return nil
+	}
+	updates := map[string]interface{}{"updated_at": time.Now()}
+	if event.Status != "" {
+		updates["status"] = disputeStatus(event.Status)
+	}
+	if event.EvidenceDueBy > 0 {
+		updates["evidence_due_by"] = time.Unix(event.EvidenceDueBy, 0)
+	}
+	if err := s.db.WithContext(ctx).Model(&dispute).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update dispute: %w", err)
+	}
+
+	s.logger.Info("Dispute updated", map[string]interface{}{
+		"dispute_id": dispute.ID,
+		"status":     event.Status,
+	})
+	return nil
+}
+
+// openDispute records a new dispute against the payment it was opened on
+func (s *Service) openDispute(ctx context.Context, event *GatewayEvent) error {
+	var payment models.Payment
+	err := s.db.WithContext(ctx).First(&payment, "gateway_ref = ?", event.IntentRef).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		s.logger.Warn("Dispute for unknown payment", map[string]interface{}{
+			"event_id":    event.ID,
+			"dispute_ref": event.DisputeRef,
+			"gateway_ref": event.IntentRef,
+		})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	dispute := &models.Dispute{
+		PaymentID:   payment.ID,
+		OrderID:     payment.OrderID,
+		GatewayRef:  event.DisputeRef,
+		GatewayType: payment.GatewayType,
+		Amount:      event.Amount,
+		Currency:    payment.Currency,
+		Reason:      event.Reason,
+		Status:      DisputeNeedsResponse,
+	}
+	if event.Status != "" {
+		dispute.Status = disputeStatus(event.Status)
+	}
+	if event.EvidenceDueBy > 0 {
+		dueBy := time.Unix(event.EvidenceDueBy, 0)
+		dispute.EvidenceDueBy = &dueBy
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(dispute).Error; err != nil {
+			return fmt.Errorf("failed to save dispute: %w", err)
+		}
+		if err := tx.Model(&payment).Update("status", "disputed").Error; err != nil {
+			return fmt.Errorf("failed to update payment: %w", err)
+		}
+
+		if s.ledger != nil {
+			if _, err := s.ledger.WithTx(tx).PostDispute(ctx, dispute, &payment); err != nil {
+				return fmt.Errorf("failed to post dispute to ledger: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.logger.Warn("Payment dispute created", map[string]interface{}{
+		"dispute_id":  dispute.ID,
+		"payment_id":  payment.ID,
+		"gateway_ref": dispute.GatewayRef,
+		"amount":      dispute.Amount,
+		"reason":      dispute.Reason,
+	})
+	return nil
+}
+
+// SubmitDisputeEvidence sends a seller's evidence for a dispute to the gateway. Sellers
+// may only contest disputes of orders they sold in; sellerID is nil for admins.
+func (s *Service) SubmitDisputeEvidence(ctx context.Context, disputeID uuid.UUID, submittedBy uuid.UUID, sellerID *uuid.UUID, req DisputeEvidenceRequest) (*models.Dispute, error) {
+	dispute, err := s.GetDispute(ctx, disputeID)
+	if err != nil {
+		return nil, err
+	}
+	if sellerID != nil {
+		allowed, err := s.IsDisputeSeller(ctx, dispute, *sellerID)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, ErrDisputeNotAllowed
+		}
+	}
+	if dispute.Status != DisputeNeedsResponse || dispute.ResolvedAt != nil {
+		return nil, ErrDisputeClosed
+	}
+	now := time.Now()
+	if dispute.EvidenceDueBy != nil && now.After(*dispute.EvidenceDueBy) {
+		return nil, ErrEvidenceOverdue
+	}
+
+	evidence := models.DisputeEvidence{
+		DisputeID:      dispute.ID,
+		SubmittedBy:    submittedBy,
+		TrackingNumber: req.TrackingNumber,
+		Carrier:        req.Carrier,
+		Message:        req.Message,
+	}
+	params := DisputeEvidenceParams{Text: req.Message}
+	if req.TrackingNumber != nil {
+		params.TrackingNumber = *req.TrackingNumber
+	}
+	if req.Carrier != nil {
+		params.Carrier = *req.Carrier
+	}
+	if len(req.Photos) > 0 {
+		photosJSON, err := json.Marshal(req.Photos)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal photos: %w", err)
+		}
+		photos := string(photosJSON)
+		evidence.Photos = &photos
+		params.Text += "\n\nPhotos:\n" + strings.Join(req.Photos, "\n")
+	}
+
+	if err := s.gateway.SubmitDisputeEvidence(ctx, dispute.GatewayRef, params); err != nil {
+		return nil, fmt.Errorf("failed to submit dispute evidence: %w", err)
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&evidence).Error; err != nil {
+			return fmt.Errorf("failed to save dispute evidence: %w", err)
+		}
+		return tx.Model(dispute).Updates(map[string]interface{}{
+			"status":                DisputeUnderReview,
+			"evidence_submitted_at": now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	dispute.Status = DisputeUnderReview
+	dispute.EvidenceSubmittedAt = &now
+	dispute.Evidence = append(dispute.Evidence, evidence)
+
+	s.logger.Info("Dispute evidence submitted", map[string]interface{}{
+		"dispute_id":   dispute.ID,
+		"submitted_by": submittedBy,
+	})
+	return dispute, nil
+}
+
+// ResolveDispute settles a dispute's outcome, posts it to the ledger and releases the
+// hold on the sellers' payouts
+func (s *Service) ResolveDispute(ctx context.Context, disputeID, resolvedBy uuid.UUID, req ResolveDisputeRequest) (*models.Dispute, error) {
+	dispute, err := s.GetDispute(ctx, disputeID)
+	if err != nil {
+		return nil, err
+	}
+	if dispute.ResolvedAt != nil {
+		return nil, ErrDisputeResolved
+	}
+
+	outcome := req.Outcome
+	if dispute.Status == DisputeWon || dispute.Status == DisputeLost {
+		if outcome != "" && outcome != dispute.Status {
+			return nil, fmt.Errorf("%w: the gateway closed the dispute as %s", ErrInvalidDisputeOutcome, dispute.Status)
+		}
+		outcome = dispute.Status
+	}
+	if outcome == "" {
+		return nil, fmt.Errorf("%w: the dispute is still open at the gateway", ErrInvalidDisputeOutcome)
+	}
+
+	now := time.Now()
+	dispute.Status = outcome
+	dispute.ResolvedBy = &resolvedBy
+	dispute.ResolvedAt = &now
+	if req.Notes != nil {
+		dispute.Notes = req.Notes
+	}
+	dispute.Liability = nil
+	if outcome == DisputeLost {
+		liability := req.Liability
+		if liability == "" {
+			liability = ledger.LiabilitySeller
+		}
+		dispute.Liability = &liability
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Dispute{}).
+			Where("id = ? AND resolved_at IS NULL", dispute.ID).
+			Updates(map[string]interface{}{
+				"status":      dispute.Status,
+				"liability":   dispute.Liability,
+				"resolved_by": resolvedBy,
+				"resolved_at": now,
+				"notes":       dispute.Notes,
+				"updated_at":  now,
+			})
+		if result.Error != nil {
+			return fmt.Errorf("failed to resolve dispute: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrDisputeResolved
+		}
+
+		var payment models.Payment
+		if err := tx.First(&payment, "id = ?", dispute.PaymentID).Error; err != nil {
+			return fmt.Errorf("payment not found: %w", err)
+		}
+		status := "charged_back"
+		if outcome == DisputeWon {
+			status = refundedStatus(&payment)
+		}
+		if err := tx.Model(&payment).Update("status", status).Error; err != nil {
+			return fmt.Errorf("failed to update payment: %w", err)
+		}
+
+		if s.ledger != nil {
+			if _, err := s.ledger.WithTx(tx).PostDisputeOutcome(ctx, dispute, &payment); err != nil {
+				return fmt.Errorf("failed to post dispute outcome to ledger: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Dispute resolved", map[string]interface{}{
+		"dispute_id":  dispute.ID,
+		"outcome":     outcome,
+		"resolved_by": resolvedBy,
+	})
+	return dispute, nil
+}
+
+// GetDispute retrieves a dispute with its evidence
+func (s *Service) GetDispute(ctx context.Context, id uuid.UUID) (*models.Dispute, error) {
+	var dispute models.Dispute
+	if err := s.db.WithContext(ctx).
+		Preload("Evidence", func(db *gorm.DB) *gorm.DB { return db.Order("created_at ASC") }).
+		First(&dispute, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &dispute, nil
+}
+
+// ListDisputes lists disputes, most recent first
+func (s *Service) ListDisputes(ctx context.Context, filter DisputeFilter, page, limit int) ([]models.Dispute, int64, error) {
+	query := s.db.WithContext(ctx).Model(&models.Dispute{})
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.OpenOnly {
+		query = query.Where("resolved_at IS NULL")
+	}
+	if filter.SellerID != nil {
+		query = query.Where("order_id IN (?)", s.sellerOrders(*filter.SellerID))
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var disputes []models.Dispute
+	err := query.Preload("Evidence").
+		Order("created_at DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&disputes).Error
+	return disputes, total, err
+}
+
+// IsDisputeSeller reports whether the seller sold items in the disputed order
+func (s *Service) IsDisputeSeller(ctx context.Context, dispute *models.Dispute, sellerID uuid.UUID) (bool, error) {
+	if dispute.OrderID == nil {
+		return false, nil
+	}
+	var count int64
+	err := s.db.WithContext(ctx).Table("(?) AS seller_orders", s.sellerOrders(sellerID)).
+		Where("order_id = ?", *dispute.OrderID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// sellerOrders selects the IDs of orders a seller sold items in
+func (s *Service) sellerOrders(sellerID uuid.UUID) *gorm.DB {
+	return s.db.Table("order_items").
+		Select("order_items.order_id").
+		Joins("JOIN products ON products.id = order_items.product_id").
+		Where("products.seller_id = ? AND order_items.deleted_at IS NULL", sellerID)
+}
+
+// refundedStatus is a payment's status from what has been refunded of it
+func refundedStatus(payment *models.Payment) string {
+	refunded := payment.RefundedAmount
+	switch {
+	case refunded <= 0:
+		return "completed"
+	case refunded >= payment.Amount:
+		return "refunded"
+	default:
+		return "partially_refunded"
+	}
+}
+
+// disputeStatus maps gateway dispute statuses onto ours; warning statuses are inquiries
+// that can still become disputes
+func disputeStatus(status string) string {
+	switch status {
+	case "warning_needs_response":
+		return DisputeNeedsResponse
+	case "warning_under_review":
+		return DisputeUnderReview
+	case "warning_closed":
+		return DisputeWon
+	}
+	return status
+}
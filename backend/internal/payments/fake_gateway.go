@@ -0,0 +1,463 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Payment method references understood by the fake gateway. They match Stripe's test
+// tokens so the same client code works against both gateways.
+const (
+	FakeCardVisa       = "pm_card_visa"
+	FakeCardMastercard = "pm_card_mastercard"
+	FakeCardDeclined   = "pm_card_chargeDeclined"
+)
+
+// FakeGateway is an in-memory PaymentGateway for local development and tests
+type FakeGateway struct {
+	mutex    sync.Mutex
+	intents  map[string]*fakeIntent
+	refunds  map[string]*GatewayRefund
+	evidence map[string]DisputeEvidenceParams
+	balance  []GatewayBalanceTransaction
+	detached map[string]bool
+	// customers holds the customer of each user; attached the methods attached to each
+	// customer, keyed by customer and method reference
+	customers map[string]string
+	attached  map[string]bool
+	// webhookSecret signs the webhooks the gateway accepts
+	webhookSecret []byte
+}
+
+type fakeIntent struct {
+	intent        GatewayIntent
+	currency      string
+	methodRef     string
+	manualCapture bool
+	refunded      int64
+}
+
+// NewFakeGateway creates an empty in-memory gateway. Its webhooks are signed with a
+// random secret until SetWebhookSecret sets a known one.
+func NewFakeGateway() *FakeGateway {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(fmt.Sprintf("failed to generate fake webhook secret: %v", err))
+	}
+	return &FakeGateway{
+		intents:       make(map[string]*fakeIntent),
+		refunds:       make(map[string]*GatewayRefund),
+		evidence:      make(map[string]DisputeEvidenceParams),
+		detached:      make(map[string]bool),
+		customers:     make(map[string]string),
+		attached:      make(map[string]bool),
+		webhookSecret: secret,
+	}
+}
+
+// SetWebhookSecret sets the secret webhooks must be signed with, so local tools can
+// send webhooks to a running server
+func (g *FakeGateway) SetWebhookSecret(secret string) {
+	g.webhookSecret = []byte(secret)
+}
+
+// Name returns the gateway identifier
+func (g *FakeGateway) Name() string {
+	return "fake"
+}
+
+// CreateIntent creates an intent awaiting confirmation. Off-session intents are charged
+// straight away and, as with Stripe, only to a method attached to the intent's customer.
+func (g *FakeGateway) CreateIntent(ctx context.Context, params IntentParams) (*GatewayIntent, error) {
+	if params.Amount <= 0 {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if params.OffSession && !g.attached[attachmentKey(params.CustomerRef, params.PaymentMethodRef)] {
+		return nil, fmt.Errorf("%w: payment method %s is not attached to customer %q", ErrInvalidGatewayState, params.PaymentMethodRef, params.CustomerRef)
+	}
+
+	ref := "pi_fake_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	stored := &fakeIntent{
+		intent: GatewayIntent{
+			Ref:          ref,
+			ClientSecret: ref + "_secret_" + uuid.New().String()[:8],
+			Status:       IntentRequiresPaymentMethod,
+			Amount:       params.Amount,
+		},
+		currency:      strings.ToUpper(params.Currency),
+		methodRef:     params.PaymentMethodRef,
+		manualCapture: params.ManualCapture,
+	}
+	if params.PaymentMethodRef != "" {
+		stored.intent.Status = IntentRequiresConfirmation
+	}
+	g.intents[ref] = stored
+
+	if params.OffSession {
+		if err := g.charge(stored); err != nil {
+			return nil, err
+		}
+	}
+
+	intent := stored.intent
+	return &intent, nil
+}
+
+// GetIntent returns the intent's current state
+func (g *FakeGateway) GetIntent(ctx context.Context, ref string) (*GatewayIntent, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	stored, ok := g.intents[ref]
+	if !ok {
+		return nil, ErrGatewayObjectNotFound
+	}
+	intent := stored.intent
+	return &intent, nil
+}
+
+// ConfirmIntent charges the intent's payment method, or paymentMethodRef when set
+func (g *FakeGateway) ConfirmIntent(ctx context.Context, ref, paymentMethodRef string) (*GatewayIntent, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	stored, ok := g.intents[ref]
+	if !ok {
+		return nil, ErrGatewayObjectNotFound
+	}
+	if stored.intent.Status != IntentRequiresPaymentMethod && stored.intent.Status != IntentRequiresConfirmation {
+		return nil, ErrInvalidGatewayState
+	}
+
+	if paymentMethodRef != "" {
+		stored.methodRef = paymentMethodRef
+	}
+	if err := g.charge(stored); err != nil {
+		return nil, err
+	}
+
+	intent := stored.intent
+	return &intent, nil
+}
+
+// charge charges the intent's payment method. FakeCardDeclined and detached methods are
+// declined; any other method succeeds, or is authorized for intents with manual capture.
+func (g *FakeGateway) charge(stored *fakeIntent) error {
+	if stored.methodRef == "" {
+		stored.methodRef = FakeCardVisa
+	}
+
+	if stored.methodRef == FakeCardDeclined || g.detached[stored.methodRef] {
+		stored.intent.Status = IntentRequiresPaymentMethod
+		stored.intent.FailureReason = "Your card was declined."
+		return fmt.Errorf("%w: %s", ErrPaymentDeclined, stored.intent.FailureReason)
+	}
+
+	stored.intent.FailureReason = ""
+	if stored.manualCapture {
+		stored.intent.Status = IntentRequiresCapture
+	} else {
+		stored.intent.Status = IntentSucceeded
+		stored.intent.AmountReceived = stored.intent.Amount
+		g.settle(BalancePayment, stored.intent.Ref, stored.intent.Amount, stored.currency)
+	}
+	return nil
+}
+
+// CaptureIntent captures an authorized intent
+func (g *FakeGateway) CaptureIntent(ctx context.Context, ref string, amount int64) (*GatewayIntent, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	stored, ok := g.intents[ref]
+	if !ok {
+		return nil, ErrGatewayObjectNotFound
+	}
+	if stored.intent.Status != IntentRequiresCapture {
+		return nil, ErrInvalidGatewayState
+	}
+	if amount <= 0 {
+		amount = stored.intent.Amount
+	}
+	if amount > stored.intent.Amount {
+		return nil, fmt.Errorf("%w: capture exceeds authorized amount", ErrInvalidGatewayState)
+	}
+
+	stored.intent.Status = IntentSucceeded
+	stored.intent.AmountReceived = amount
+	g.settle(BalancePayment, ref, amount, stored.currency)
+
+	intent := stored.intent
+	return &intent, nil
+}
+
+// CancelIntent cancels an intent that has not succeeded
+func (g *FakeGateway) CancelIntent(ctx context.Context, ref string) (*GatewayIntent, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	stored, ok := g.intents[ref]
+	if !ok {
+		return nil, ErrGatewayObjectNotFound
+	}
+	if stored.intent.Status == IntentSucceeded || stored.intent.Status == IntentCanceled {
+		return nil, ErrInvalidGatewayState
+	}
+
+	stored.intent.Status = IntentCanceled
+
+	intent := stored.intent
+	return &intent, nil
+}
+
+// Refund refunds part or all of a succeeded intent
+func (g *FakeGateway) Refund(ctx context.Context, params RefundParams) (*GatewayRefund, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	// Like Stripe's idempotency keys, a refund sent again returns the first one
+	if refundID := params.Metadata["refund_id"]; refundID != "" {
+		for _, existing := range g.refunds {
+			if existing.Metadata["refund_id"] == refundID {
+				result := *existing
+				return &result, nil
+			}
+		}
+	}
+
+	stored, ok := g.intents[params.IntentRef]
+	if !ok {
+		return nil, ErrGatewayObjectNotFound
+	}
+	if stored.intent.Status != IntentSucceeded {
+		return nil, ErrInvalidGatewayState
+	}
+	if params.Amount <= 0 || stored.refunded+params.Amount > stored.intent.AmountReceived {
+		return nil, fmt.Errorf("%w: refund exceeds captured amount", ErrInvalidGatewayState)
+	}
+
+	stored.refunded += params.Amount
+	refund := &GatewayRefund{
+		Ref:      "re_fake_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Status:   "succeeded",
+		Metadata: params.Metadata,
+	}
+	g.refunds[refund.Ref] = refund
+	g.settle(BalanceRefund, refund.Ref, -params.Amount, stored.currency)
+
+	result := *refund
+	return &result, nil
+}
+
+// GetPaymentMethod describes one of the fake card tokens
+func (g *FakeGateway) GetPaymentMethod(ctx context.Context, ref string) (*GatewayPaymentMethod, error) {
+	method := &GatewayPaymentMethod{
+		Ref:         ref,
+		Type:        "card",
+		ExpiryMonth: 12,
+		ExpiryYear:  2034,
+	}
+
+	switch ref {
+	case FakeCardVisa:
+		method.Brand, method.Last4 = "visa", "4242"
+	case FakeCardMastercard:
+		method.Brand, method.Last4 = "mastercard", "4444"
+	case FakeCardDeclined:
+		method.Brand, method.Last4 = "visa", "0002"
+	default:
+		return nil, ErrGatewayObjectNotFound
+	}
+	return method, nil
+}
+
+// CreateCustomer creates a customer; a user has a single customer however often it is called
+func (g *FakeGateway) CreateCustomer(ctx context.Context, params CustomerParams) (string, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if ref, ok := g.customers[params.UserID]; ok {
+		return ref, nil
+	}
+	ref := "cus_fake_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	g.customers[params.UserID] = ref
+	return ref, nil
+}
+
+// AttachPaymentMethod attaches one of the fake card tokens to a customer of the gateway
+func (g *FakeGateway) AttachPaymentMethod(ctx context.Context, ref, customerRef string) error {
+	if _, err := g.GetPaymentMethod(ctx, ref); err != nil {
+		return err
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	known := false
+	for _, customer := range g.customers {
+		known = known || customer == customerRef
+	}
+	if !known {
+		return fmt.Errorf("%w: customer %q", ErrGatewayObjectNotFound, customerRef)
+	}
+	g.attached[attachmentKey(customerRef, ref)] = true
+	delete(g.detached, ref)
+	return nil
+}
+
+// IsAttached reports whether a payment method is attached to a customer
+func (g *FakeGateway) IsAttached(ref, customerRef string) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.attached[attachmentKey(customerRef, ref)]
+}
+
+func attachmentKey(customerRef, ref string) string {
+	return customerRef + "/" + ref
+}
+
+// DetachPaymentMethod detaches one of the fake card tokens from its customers; later
+// charges to it are declined. Like Stripe it fails for a method that is not attached.
+func (g *FakeGateway) DetachPaymentMethod(ctx context.Context, ref string) error {
+	if _, err := g.GetPaymentMethod(ctx, ref); err != nil {
+		return err
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	detached := false
+	for key := range g.attached {
+		if strings.HasSuffix(key, "/"+ref) {
+			delete(g.attached, key)
+			detached = true
+		}
+	}
+	if !detached {
+		return fmt.Errorf("%w: payment method %s is not attached to a customer", ErrInvalidGatewayState, ref)
+	}
+	g.detached[ref] = true
+	return nil
+}
+
+// IsDetached reports whether a payment method was detached
+func (g *FakeGateway) IsDetached(ref string) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.detached[ref]
+}
+
+// SubmitDisputeEvidence records the evidence submitted for a dispute
+func (g *FakeGateway) SubmitDisputeEvidence(ctx context.Context, ref string, evidence DisputeEvidenceParams) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if _, ok := g.evidence[ref]; ok {
+		return fmt.Errorf("%w: evidence already submitted", ErrInvalidGatewayState)
+	}
+	g.evidence[ref] = evidence
+	return nil
+}
+
+// SubmittedEvidence returns the evidence submitted for a dispute
+func (g *FakeGateway) SubmittedEvidence(ref string) (DisputeEvidenceParams, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	evidence, ok := g.evidence[ref]
+	return evidence, ok
+}
+
+// ListBalanceTransactions returns the payments and refunds settled between from and to,
+// and any transactions added with AddBalanceTransaction
+func (g *FakeGateway) ListBalanceTransactions(ctx context.Context, from, to time.Time) ([]GatewayBalanceTransaction, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	var transactions []GatewayBalanceTransaction
+	for _, transaction := range g.balance {
+		if !transaction.CreatedAt.Before(from) && transaction.CreatedAt.Before(to) {
+			transactions = append(transactions, transaction)
+		}
+	}
+	return transactions, nil
+}
+
+// AddBalanceTransaction adds a transaction to the balance, e.g. a dispute withdrawal or
+// a charge made outside the platform
+func (g *FakeGateway) AddBalanceTransaction(transaction GatewayBalanceTransaction) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if transaction.Ref == "" {
+		transaction.Ref = "txn_fake_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	}
+	if transaction.CreatedAt.IsZero() {
+		transaction.CreatedAt = time.Now()
+	}
+	g.balance = append(g.balance, transaction)
+}
+
+// settle records a balance transaction; the caller holds the mutex
+func (g *FakeGateway) settle(kind, sourceRef string, amount int64, currency string) {
+	if currency == "" {
+		currency = "USD"
+	}
+	g.balance = append(g.balance, GatewayBalanceTransaction{
+		Ref:       "txn_fake_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Kind:      kind,
+		SourceRef: sourceRef,
+		Amount:    amount,
+		Currency:  currency,
+		CreatedAt: time.Now(),
+	})
+}
+
+// ParseWebhook decodes a JSON GatewayEvent signed with SignWebhook
+func (g *FakeGateway) ParseWebhook(r *http.Request) (*GatewayEvent, error) {
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get("Fake-Signature"))
+	if err != nil || !hmac.Equal(signature, g.sign(body)) {
+		return nil, ErrInvalidWebhook
+	}
+
+	var event GatewayEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+	}
+	return &event, nil
+}
+
+// SignWebhook returns the Fake-Signature header value for a fake webhook body
+func (g *FakeGateway) SignWebhook(body []byte) string {
+	return hex.EncodeToString(g.sign(body))
+}
+
+func (g *FakeGateway) sign(body []byte) []byte {
+	mac := hmac.New(sha256.New, g.webhookSecret)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,171 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrPaymentDeclined is returned by a gateway when the payment method was declined
+var ErrPaymentDeclined = errors.New("payment declined")
+
+// ErrGatewayObjectNotFound is returned by a gateway when the referenced intent,
+// refund or payment method does not exist
+var ErrGatewayObjectNotFound = errors.New("gateway object not found")
+
+// ErrInvalidGatewayState is returned when an operation is not allowed in the intent's
+// current state, e.g. capturing an intent that was never authorized
+var ErrInvalidGatewayState = errors.New("operation not allowed in current payment state")
+
+// ErrInvalidWebhook is returned when a webhook request is unsigned or its signature does not match
+var ErrInvalidWebhook = errors.New("invalid webhook signature")
+
+// Payment intent statuses, shared by every gateway
+const (
+	IntentRequiresPaymentMethod = "requires_payment_method"
+	IntentRequiresConfirmation  = "requires_confirmation"
+	IntentRequiresAction        = "requires_action"
+	IntentProcessing            = "processing"
+	IntentRequiresCapture       = "requires_capture"
+	IntentSucceeded             = "succeeded"
+	IntentCanceled              = "canceled"
+)
+
+// Gateway webhook event types
+const (
+	EventPaymentSucceeded = "payment_intent.succeeded"
+	EventPaymentFailed    = "payment_intent.payment_failed"
+	EventPaymentCanceled  = "payment_intent.canceled"
+	EventDisputeCreated   = "charge.dispute.created"
+	EventDisputeUpdated   = "charge.dispute.updated"
+	EventDisputeClosed    = "charge.dispute.closed"
+	EventRefundUpdated    = "charge.refund.updated"
+)
+
+// PaymentGateway abstracts the payment processor so payments can be exercised without
+// a Stripe account. Amounts are in the currency's smallest unit.
+type PaymentGateway interface {
+	// Name returns the gateway identifier stored with payments, e.g. "stripe" or "fake"
+	Name() string
+	// CreateIntent creates a payment intent the client completes with its client secret
+	CreateIntent(ctx context.Context, params IntentParams) (*GatewayIntent, error)
+	// GetIntent returns the current state of an intent
+	GetIntent(ctx context.Context, ref string) (*GatewayIntent, error)
+	// ConfirmIntent confirms an intent server-side, optionally with a payment method
+	ConfirmIntent(ctx context.Context, ref, paymentMethodRef string) (*GatewayIntent, error)
+	// CaptureIntent captures an authorized intent; amount 0 captures the full amount
+	CaptureIntent(ctx context.Context, ref string, amount int64) (*GatewayIntent, error)
+	// CancelIntent cancels an intent that has not succeeded
+	CancelIntent(ctx context.Context, ref string) (*GatewayIntent, error)
+	// Refund refunds part or all of a succeeded intent
+	Refund(ctx context.Context, params RefundParams) (*GatewayRefund, error)
+	// GetPaymentMethod returns the details of a tokenized payment method
+	GetPaymentMethod(ctx context.Context, ref string) (*GatewayPaymentMethod, error)
+	// CreateCustomer creates the gateway customer saved payment methods are attached to
+	CreateCustomer(ctx context.Context, params CustomerParams) (string, error)
+	// AttachPaymentMethod attaches a payment method to a customer so it can be charged later
+	AttachPaymentMethod(ctx context.Context, ref, customerRef string) error
+	// DetachPaymentMethod detaches a saved payment method so it can no longer be charged
+	DetachPaymentMethod(ctx context.Context, ref string) error
+	// SubmitDisputeEvidence sends evidence contesting a dispute to the card network
+	SubmitDisputeEvidence(ctx context.Context, ref string, evidence DisputeEvidenceParams) error
+	// ListBalanceTransactions returns the funds the gateway settled between from and to
+	ListBalanceTransactions(ctx context.Context, from, to time.Time) ([]GatewayBalanceTransaction, error)
+	// ParseWebhook verifies the signature of a webhook request and decodes its event
+	ParseWebhook(r *http.Request) (*GatewayEvent, error)
+}
+
+// IntentParams describes a payment intent to create
+type IntentParams struct {
+	Amount           int64
+	Currency         string
+	PaymentMethodRef string // optional; attaches the method so the intent can be confirmed server-side
+	CustomerRef      string // the customer PaymentMethodRef is attached to; required off-session
+	ManualCapture    bool   // authorize only; funds are taken by CaptureIntent
+	OffSession       bool   // charge PaymentMethodRef now, without the customer present
+	Metadata         map[string]string
+}
+
+// CustomerParams describes the gateway customer of a user
+type CustomerParams struct {
+	UserID   string
+	Email    string
+	Metadata map[string]string
+}
+
+// GatewayIntent is a gateway's view of a payment intent
+type GatewayIntent struct {
+	Ref            string
+	ClientSecret   string
+	Status         string
+	Amount         int64
+	AmountReceived int64
+	FailureReason  string
+}
+
+// RefundParams describes a refund to create
+type RefundParams struct {
+	IntentRef string
+	Amount    int64
+	Reason    string
+	Metadata  map[string]string
+}
+
+// GatewayRefund is a gateway's view of a refund
+type GatewayRefund struct {
+	Ref      string
+	Status   string // pending, succeeded, failed, canceled
+	Metadata map[string]string
+}
+
+// DisputeEvidenceParams is the evidence submitted to contest a dispute
+type DisputeEvidenceParams struct {
+	TrackingNumber string
+	Carrier        string
+	Text           string // the seller's explanation and links to their photos
+}
+
+// Kinds of gateway balance transactions
+const (
+	BalancePayment = "payment"
+	BalanceRefund  = "refund"
+	BalanceDispute = "dispute"
+	BalanceOther   = "other" // payouts, transfers and fees, which are not reconciled
+)
+
+// GatewayBalanceTransaction is a movement of funds in the gateway balance. SourceRef is
+// the object it settles: the intent for payments, the refund or the dispute.
+type GatewayBalanceTransaction struct {
+	Ref       string
+	Kind      string
+	SourceRef string
+	Amount    int64 // gross; negative for funds leaving the balance
+	Fee       int64
+	Currency  string
+	CreatedAt time.Time
+}
+
+// GatewayPaymentMethod describes a tokenized payment method
+type GatewayPaymentMethod struct {
+	Ref         string
+	Type        string
+	Brand       string
+	Last4       string
+	ExpiryMonth int
+	ExpiryYear  int
+}
+
+// GatewayEvent is a verified webhook event
+type GatewayEvent struct {
+	ID            string `json:"id"`
+	Type          string `json:"type"`
+	IntentRef     string `json:"intent_ref"`
+	Status        string `json:"status,omitempty"` // intent, dispute or refund status
+	FailureReason string `json:"failure_reason,omitempty"`
+	Amount        int64  `json:"amount,omitempty"` // disputed amount for dispute events
+	Reason        string `json:"reason,omitempty"` // dispute reason for dispute events
+	DisputeRef    string `json:"dispute_ref,omitempty"`
+	RefundRef     string `json:"refund_ref,omitempty"`
+	EvidenceDueBy int64  `json:"evidence_due_by,omitempty"` // unix time evidence is due for dispute events
+}
@@ -1,10 +1,13 @@
 package payments
 
 import (
+	"errors"
+	"io"
 	"net/http"
 	"strconv"
 
 	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/pkg/money"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
@@ -29,8 +32,8 @@ func (h *Handler) GetDB() *gorm.DB {
 
 // CreatePaymentIntentRequest represents request body for creating payment intent
 type CreatePaymentIntentRequest struct {
-	Amount   float64            `json:"amount" binding:"required,gt=0"`
-	Currency string             `json:"currency" binding:"required"`
+	Amount   int64              `json:"amount" binding:"omitempty,gt=0"` // ignored for orders, which pay their total
+	Currency string             `json:"currency"`
 	OrderID  *uuid.UUID         `json:"order_id,omitempty"`
 	Metadata map[string]string  `json:"metadata,omitempty"`
 }
@@ -38,14 +41,30 @@ type CreatePaymentIntentRequest struct {
 // ConfirmPaymentRequest represents request body for confirming payment
 type ConfirmPaymentRequest struct {
 	PaymentIntentID uuid.UUID `json:"payment_intent_id" binding:"required"`
+	PaymentMethodID string    `json:"payment_method_id,omitempty"` // confirm server-side with this method
 }
 
-// RefundPaymentRequest represents request body for refunding payment
+// CapturePaymentRequest represents request body for capturing an authorized payment
+type CapturePaymentRequest struct {
+	Amount int64 `json:"amount" binding:"gte=0"` // 0 captures the full amount
+}
+
+// RefundPaymentRequest represents request body for refunding payment. Either items or
+// an amount is given.
 type RefundPaymentRequest struct {
-	PaymentID uuid.UUID `json:"payment_id" binding:"required"`
-	Amount    float64   `json:"amount" binding:"required,gt=0"`
-	Reason    string    `json:"reason" binding:"required,oneof=duplicate fraudulent requested_by_customer"`
-	Notes     *string   `json:"notes,omitempty"`
+	PaymentID uuid.UUID           `json:"payment_id" binding:"required"`
+	Amount    int64               `json:"amount" binding:"omitempty,gt=0"`
+	Items     []RefundItemRequest `json:"items" binding:"omitempty,dive"`
+	Reason    string              `json:"reason" binding:"required,oneof=duplicate fraudulent requested_by_customer"`
+	Restock   bool                `json:"restock"`
+	Notes     *string             `json:"notes,omitempty"`
+	// StoreCredit refunds to the buyer's store credit instead of the card
+	StoreCredit bool `json:"store_credit"`
+}
+
+// ReviewRefundRequest represents request body for rejecting a refund request
+type ReviewRefundRequest struct {
+	Notes *string `json:"notes,omitempty"`
 }
 
 // SavePaymentMethodRequest represents request body for saving payment method
@@ -69,6 +88,31 @@ func (h *Handler) CreatePaymentIntent(c *gin.Context) {
 		return
 	}
 
+	// Order payments are opened for the order's total
+	if req.OrderID != nil {
+		paymentIntent, err := h.service.CreateOrderPaymentIntent(c.Request.Context(), userID.(uuid.UUID), *req.OrderID, "card")
+		if err != nil {
+			if errors.Is(err, ErrOrderNotPayable) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, paymentIntent)
+		return
+	}
+
+	if req.Amount == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount is required"})
+		return
+	}
+
 	// Set default currency if not provided
 	if req.Currency == "" {
 		req.Currency = "USD"
@@ -84,6 +128,10 @@ func (h *Handler) CreatePaymentIntent(c *gin.Context) {
 	)
 
 	if err != nil {
+		if errors.Is(err, money.ErrUnknownCurrency) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -91,7 +139,7 @@ func (h *Handler) CreatePaymentIntent(c *gin.Context) {
 	c.JSON(http.StatusCreated, paymentIntent)
 }
 
-// ConfirmPayment confirms and processes a payment
+// ConfirmPayment confirms and processes one of the current user's payment intents
 func (h *Handler) ConfirmPayment(c *gin.Context) {
 	var req ConfirmPaymentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -99,8 +147,94 @@ func (h *Handler) ConfirmPayment(c *gin.Context) {
 		return
 	}
 
-	payment, err := h.service.ConfirmPayment(c.Request.Context(), req.PaymentIntentID)
+	// Get user ID from context
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	// Only the user paying can confirm their payment intent
+	paymentIntent, err := h.service.GetPaymentIntent(c.Request.Context(), req.PaymentIntentID)
+	if err != nil || paymentIntent.UserID != userID.(uuid.UUID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment intent not found"})
+		return
+	}
+
+	payment, err := h.service.ConfirmPayment(c.Request.Context(), req.PaymentIntentID, req.PaymentMethodID)
+	if err != nil {
+		if errors.Is(err, ErrPaymentDeclined) {
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, payment)
+}
+
+// CancelPaymentIntent cancels one of the current user's unpaid payment intents
+func (h *Handler) CancelPaymentIntent(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment intent ID"})
+		return
+	}
+
+	// Get user ID from context
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	paymentIntent, err := h.service.GetPaymentIntent(c.Request.Context(), id)
+	if err != nil || paymentIntent.UserID != userID.(uuid.UUID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment intent not found"})
+		return
+	}
+
+	paymentIntent, err = h.service.CancelPaymentIntent(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrInvalidGatewayState) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, paymentIntent)
+}
+
+// CapturePayment captures an authorized payment intent (admin only)
+func (h *Handler) CapturePayment(c *gin.Context) {
+	// Check if user is admin
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment intent ID"})
+		return
+	}
+
+	var req CapturePaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	payment, err := h.service.CapturePayment(c.Request.Context(), id, req.Amount)
 	if err != nil {
+		if errors.Is(err, ErrInvalidGatewayState) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -116,15 +250,27 @@ func (h *Handler) GetPaymentIntent(c *gin.Context) {
 		return
 	}
 
+	// Get user ID from context
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
 	paymentIntent, err := h.service.GetPaymentIntent(c.Request.Context(), id)
 	if err != nil {
-		if err.Error() == "record not found" {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Payment intent not found"})
 			return
 		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	// Only the paying user may see the intent and its client secret
+	if paymentIntent.UserID != userID.(uuid.UUID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment intent not found"})
+		return
+	}
 
 	c.JSON(http.StatusOK, paymentIntent)
 }
@@ -170,13 +316,122 @@ func (h *Handler) SavePaymentMethod(c *gin.Context) {
 	)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		h.paymentMethodError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusCreated, paymentMethod)
 }
 
+// GetUserPaymentMethod gets one of the current user's saved payment methods
+func (h *Handler) GetUserPaymentMethod(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment method ID"})
+		return
+	}
+
+	paymentMethod, err := h.service.GetPaymentMethod(c.Request.Context(), userID.(uuid.UUID), id)
+	if err != nil {
+		h.paymentMethodError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, paymentMethod)
+}
+
+// UpdatePaymentMethod updates the billing details of one of the current user's payment methods
+func (h *Handler) UpdatePaymentMethod(c *gin.Context) {
+	var req UpdatePaymentMethodRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment method ID"})
+		return
+	}
+
+	paymentMethod, err := h.service.UpdatePaymentMethod(c.Request.Context(), userID.(uuid.UUID), id, req)
+	if err != nil {
+		h.paymentMethodError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, paymentMethod)
+}
+
+// SetDefaultPaymentMethod makes one of the current user's payment methods their default
+func (h *Handler) SetDefaultPaymentMethod(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment method ID"})
+		return
+	}
+
+	paymentMethod, err := h.service.SetDefaultPaymentMethod(c.Request.Context(), userID.(uuid.UUID), id)
+	if err != nil {
+		h.paymentMethodError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, paymentMethod)
+}
+
+// DeletePaymentMethod detaches and deletes one of the current user's payment methods
+func (h *Handler) DeletePaymentMethod(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment method ID"})
+		return
+	}
+
+	if err := h.service.DeletePaymentMethod(c.Request.Context(), userID.(uuid.UUID), id); err != nil {
+		h.paymentMethodError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Payment method deleted"})
+}
+
+// paymentMethodError maps payment method errors to responses
+func (h *Handler) paymentMethodError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment method not found"})
+	case errors.Is(err, ErrGatewayObjectNotFound), errors.Is(err, ErrPaymentMethodExpired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
 // GetPaymentMethods gets available payment methods
 func (h *Handler) GetPaymentMethods(c *gin.Context) {
 	paymentMethods, err := h.service.GetPaymentMethods(c.Request.Context())
@@ -195,6 +450,10 @@ func (h *Handler) RefundPayment(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if req.Amount == 0 && len(req.Items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount or items is required"})
+		return
+	}
 
 	// Get user ID from context (must be admin)
 	processedBy, exists := c.Get("user_id")
@@ -210,68 +469,455 @@ func (h *Handler) RefundPayment(c *gin.Context) {
 		return
 	}
 
-	refund, err := h.service.RefundPayment(
-		c.Request.Context(),
-		req.PaymentID,
-		req.Amount,
-		req.Reason,
-		processedBy.(uuid.UUID),
-	)
+	refund, err := h.service.RequestRefund(c.Request.Context(), RefundRequest{
+		PaymentID:   req.PaymentID,
+		Amount:      req.Amount,
+		Items:       req.Items,
+		Reason:      req.Reason,
+		Restock:     req.Restock,
+		Notes:       req.Notes,
+		RequestedBy: processedBy.(uuid.UUID),
+		StoreCredit: req.StoreCredit,
+	})
+	if err != nil {
+		h.refundError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, refund)
+}
+
+// RequestSellerRefund requests a refund of the current seller's items on an order.
+// An admin approves it before it is sent.
+func (h *Handler) RequestSellerRefund(c *gin.Context) {
+	var req RefundPaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "items is required"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	sellerID := userID.(uuid.UUID)
+	refund, err := h.service.RequestRefund(c.Request.Context(), RefundRequest{
+		PaymentID:   req.PaymentID,
+		Items:       req.Items,
+		Reason:      req.Reason,
+		Restock:     req.Restock,
+		Notes:       req.Notes,
+		RequestedBy: sellerID,
+		SellerID:    &sellerID,
+		StoreCredit: req.StoreCredit,
+	})
+	if err != nil {
+		h.refundError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, refund)
+}
+
+// ListMyRefunds lists the refunds the current seller has requested
+func (h *Handler) ListMyRefunds(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	requestedBy := userID.(uuid.UUID)
+	h.listRefunds(c, RefundFilter{Status: c.Query("status"), RequestedBy: &requestedBy})
+}
+
+// ListRefunds lists refunds, optionally by status (admin only)
+func (h *Handler) ListRefunds(c *gin.Context) {
+	// Check if user is admin
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
 
+	filter := RefundFilter{Status: c.Query("status")}
+	if raw := c.Query("payment_id"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+			return
+		}
+		filter.PaymentID = &id
+	}
+
+	h.listRefunds(c, filter)
+}
+
+// ApproveRefund sends a seller's refund request (admin only)
+func (h *Handler) ApproveRefund(c *gin.Context) {
+	// Check if user is admin
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid refund ID"})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	refund, err := h.service.ApproveRefund(c.Request.Context(), id, userID.(uuid.UUID))
+	if err != nil {
+		h.refundError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, refund)
+}
+
+// RejectRefund declines a seller's refund request (admin only)
+func (h *Handler) RejectRefund(c *gin.Context) {
+	// Check if user is admin
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid refund ID"})
+		return
+	}
+
+	var req ReviewRefundRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	refund, err := h.service.RejectRefund(c.Request.Context(), id, userID.(uuid.UUID), req.Notes)
+	if err != nil {
+		h.refundError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, refund)
+}
+
+func (h *Handler) listRefunds(c *gin.Context, filter RefundFilter) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	refunds, total, err := h.service.ListRefunds(c.Request.Context(), filter, page, limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Add notes if provided
-	if req.Notes != nil {
-		refund.Notes = req.Notes
-		h.service.db.WithContext(c.Request.Context()).Save(refund)
+	c.JSON(http.StatusOK, gin.H{
+		"data": refunds,
+		"pagination": gin.H{
+			"page":        page,
+			"limit":       limit,
+			"total":       total,
+			"total_pages": (total + int64(limit) - 1) / int64(limit),
+		},
+	})
+}
+
+// refundError maps refund errors to responses
+func (h *Handler) refundError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrRefundNotAllowed):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrRefundNotPending):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrRefundExceedsPayment), errors.Is(err, ErrInvalidRefundItem), errors.Is(err, ErrStoreCreditUnavailable), errors.Is(err, ErrPaymentNotRefundable):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 	}
+}
 
-	c.JSON(http.StatusCreated, refund)
+// ListMyDisputes lists disputes of orders the current seller sold in
+func (h *Handler) ListMyDisputes(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	sellerID := userID.(uuid.UUID)
+	h.listDisputes(c, DisputeFilter{Status: c.Query("status"), SellerID: &sellerID, OpenOnly: c.Query("open") == "true"})
 }
 
-// ProcessWebhook processes Stripe webhooks
-func (h *Handler) ProcessWebhook(c *gin.Context) {
-	// Get webhook endpoint secret from environment (temporarily hardcode for demo)
-	endpointSecret := "whsec_your_webhook_secret" // This should come from config
-	
-	// In development, skip webhook signature verification
-	if gin.Mode() == gin.DebugMode {
-		c.JSON(http.StatusOK, gin.H{"status": "received (debug mode - verification skipped)"})
+// ListDisputes lists all disputes, optionally by status (admin only)
+func (h *Handler) ListDisputes(c *gin.Context) {
+	// Check if user is admin
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
 		return
 	}
-	
-	if endpointSecret == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Webhook endpoint secret not configured"})
+
+	h.listDisputes(c, DisputeFilter{Status: c.Query("status"), OpenOnly: c.Query("open") == "true"})
+}
+
+// GetDispute returns a dispute with its evidence. Sellers only see disputes of orders
+// they sold in.
+func (h *Handler) GetDispute(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dispute ID"})
 		return
 	}
 
-	// Read the request body
-	body, err := c.GetRawData()
+	dispute, err := h.service.GetDispute(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dispute not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Get Stripe signature header
-	signatureHeader := c.GetHeader("Stripe-Signature")
-	if signatureHeader == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Stripe signature header missing"})
+	role, _ := c.Get("role")
+	if role != "admin" {
+		userID, _ := c.Get("user_id")
+		allowed, err := h.service.IsDisputeSeller(c.Request.Context(), dispute, userID.(uuid.UUID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dispute not found"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, dispute)
+}
+
+// SubmitDisputeEvidence submits evidence contesting a dispute of the current seller's order
+func (h *Handler) SubmitDisputeEvidence(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dispute ID"})
 		return
 	}
 
-	// Process webhook
-	err = h.service.ProcessWebhook(c.Request.Context(), body, signatureHeader, endpointSecret)
+	var req DisputeEvidenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	submittedBy := userID.(uuid.UUID)
+	sellerID := &submittedBy
+	if role, _ := c.Get("role"); role == "admin" {
+		sellerID = nil
+	}
+
+	dispute, err := h.service.SubmitDisputeEvidence(c.Request.Context(), id, submittedBy, sellerID, req)
 	if err != nil {
+		h.disputeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dispute)
+}
+
+// ResolveDispute settles a dispute and posts its outcome to the ledger (admin only)
+func (h *Handler) ResolveDispute(c *gin.Context) {
+	// Check if user is admin
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dispute ID"})
+		return
+	}
+
+	var req ResolveDisputeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	userID, _ := c.Get("user_id")
+	dispute, err := h.service.ResolveDispute(c.Request.Context(), id, userID.(uuid.UUID), req)
+	if err != nil {
+		h.disputeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dispute)
+}
+
+func (h *Handler) listDisputes(c *gin.Context, filter DisputeFilter) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	disputes, total, err := h.service.ListDisputes(c.Request.Context(), filter, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": disputes,
+		"pagination": gin.H{
+			"page":        page,
+			"limit":       limit,
+			"total":       total,
+			"total_pages": (total + int64(limit) - 1) / int64(limit),
+		},
+	})
+}
+
+// disputeError maps dispute errors to responses
+func (h *Handler) disputeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, ErrDisputeNotAllowed):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dispute not found"})
+	case errors.Is(err, ErrDisputeClosed), errors.Is(err, ErrEvidenceOverdue), errors.Is(err, ErrDisputeResolved):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidDisputeOutcome):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// ProcessWebhook processes payment gateway webhooks
+func (h *Handler) ProcessWebhook(c *gin.Context) {
+	event, err := h.service.ParseWebhook(c.Request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Events are stored and acknowledged straight away; the webhook worker processes them
+	_, duplicate, err := h.service.RecordWebhook(c.Request.Context(), event)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if duplicate {
+		c.JSON(http.StatusOK, gin.H{"status": "duplicate"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"status": "received"})
 }
 
+// ListWebhookEvents lists stored gateway webhook events (admin only)
+func (h *Handler) ListWebhookEvents(c *gin.Context) {
+	// Check if user is admin
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	events, total, err := h.service.ListWebhookEvents(c.Request.Context(), c.Query("status"), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": events,
+		"pagination": gin.H{
+			"page":        page,
+			"limit":       limit,
+			"total":       total,
+			"total_pages": (total + int64(limit) - 1) / int64(limit),
+		},
+	})
+}
+
+// ReplayWebhookEvent processes a failed webhook event again (admin only)
+func (h *Handler) ReplayWebhookEvent(c *gin.Context) {
+	// Check if user is admin
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook event ID"})
+		return
+	}
+
+	event, err := h.service.ReplayWebhookEvent(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrWebhookNotReplayable) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook event not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, event)
+}
+
 // ListPayments gets paginated list of payments (admin only)
 func (h *Handler) ListPayments(c *gin.Context) {
 	// Check if user is admin
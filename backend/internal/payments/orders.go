@@ -0,0 +1,223 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PaymentIntentTTL is how long a payment intent stays open before it expires
+const PaymentIntentTTL = 30 * time.Minute
+
+// ErrOrderNotPayable is returned when opening a payment for an order that is not
+// pending or does not belong to the buyer
+var ErrOrderNotPayable = errors.New("order is not awaiting payment")
+
+// GatewayCredit is the gateway type of the payments recorded for orders paid in full by
+// gift cards and store credit, which never reach a gateway
+const GatewayCredit = "credit"
+
+// openIntentStatuses are the intent statuses a buyer can still complete
+var openIntentStatuses = []string{IntentRequiresPaymentMethod, IntentRequiresConfirmation, IntentRequiresAction}
+
+// OrderPaymentHandler moves orders along as their payments settle. The order service
+// implements it; payments only reports the outcome.
+type OrderPaymentHandler interface {
+	// MarkOrderPaid records the payment on a pending order and starts processing it
+	MarkOrderPaid(ctx context.Context, orderID, paymentID uuid.UUID) error
+	// ReleaseOrder cancels a pending order whose payment failed or expired and
+	// releases its reserved stock
+	ReleaseOrder(ctx context.Context, orderID uuid.UUID, reason string) error
+	// ApplyOrderRefund restocks refunded items and marks a fully refunded order, within
+	// the transaction that records the refund
+	ApplyOrderRefund(ctx context.Context, tx *gorm.DB, refund *models.Refund, fullyRefunded bool) error
+}
+
+// SetOrderPaymentHandler sets the handler notified when order payments settle
+func (s *Service) SetOrderPaymentHandler(handler OrderPaymentHandler) {
+	s.orders = handler
+}
+
+// CreateOrderPaymentIntent opens a payment intent for the amount due on an order. An intent
+// already open for the order is returned instead of creating a second one.
+func (s *Service) CreateOrderPaymentIntent(ctx context.Context, userID, orderID uuid.UUID, paymentMethod string) (*models.PaymentIntent, error) {
+	var order models.Order
+	if err := s.db.WithContext(ctx).First(&order, "id = ?", orderID).Error; err != nil {
+		return nil, fmt.Errorf("order not found: %w", err)
+	}
+	if order.UserID != userID || order.Status != "pending" {
+		return nil, ErrOrderNotPayable
+	}
+
+	var existing models.PaymentIntent
+	err := s.db.WithContext(ctx).
+		Where("order_id = ? AND status IN ? AND expires_at > ?", orderID, openIntentStatuses, time.Now()).
+		Order("created_at DESC").
+		First(&existing).Error
+	if err == nil {
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if paymentMethod == "" {
+		paymentMethod = "card"
+	}
+	return s.createIntent(ctx, userID, &orderID, amountDue(&order), order.Currency, paymentMethod, nil, "")
+}
+
+// ChargeOrderDefaultPaymentMethod pays a pending order off-session with the buyer's
+// default payment method, e.g. for an auction they won. It returns the completed
+// payment; a declined charge returns an error wrapping ErrPaymentDeclined.
+func (s *Service) ChargeOrderDefaultPaymentMethod(ctx context.Context, userID, orderID uuid.UUID) (*models.Payment, error) {
+	var order models.Order
+	if err := s.db.WithContext(ctx).First(&order, "id = ?", orderID).Error; err != nil {
+		return nil, fmt.Errorf("order not found: %w", err)
+	}
+	if order.UserID != userID || order.Status != "pending" {
+		return nil, ErrOrderNotPayable
+	}
+
+	paymentMethod, err := s.DefaultPaymentMethod(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	paymentIntent, err := s.createIntent(ctx, userID, &orderID, amountDue(&order), order.Currency, paymentMethod.Type, nil, paymentMethod.MethodRef)
+	if err != nil {
+		return nil, err
+	}
+	return s.ConfirmPayment(ctx, paymentIntent.ID, "")
+}
+
+// RecordCreditPayment records the payment of an order paid in full by gift cards and
+// store credit, within the transaction that marks it paid. Nothing was paid by card, so
+// its amount is zero; the order's refunds are made against it.
+func (s *Service) RecordCreditPayment(ctx context.Context, tx *gorm.DB, order *models.Order) (*models.Payment, error) {
+	transactionID := "credit_" + order.ID.String()
+
+	var existing models.Payment
+	err := tx.WithContext(ctx).First(&existing, "transaction_id = ?", transactionID).Error
+	if err == nil {
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	payment := &models.Payment{
+		OrderID:       &order.ID,
+		UserID:        order.UserID,
+		PaymentMethod: "store_credit",
+		Amount:        0,
+		Currency:      order.Currency,
+		Status:        "completed",
+		TransactionID: transactionID,
+		GatewayType:   GatewayCredit,
+		ProcessedAt:   &[]time.Time{time.Now()}[0],
+		Metadata:      "{}",
+	}
+	if err := tx.WithContext(ctx).Create(payment).Error; err != nil {
+		return nil, fmt.Errorf("failed to create credit payment: %w", err)
+	}
+	return payment, nil
+}
+
+// amountDue is what the buyer pays by card for an order: its total less the gift cards
+// and store credit applied to it
+func amountDue(order *models.Order) int64 {
+	return order.TotalAmount - order.CreditAmount
+}
+
+// ExpirePaymentIntents cancels intents still open after their expiry and releases the
+// stock held by their orders. It returns the number of intents expired.
+func (s *Service) ExpirePaymentIntents(ctx context.Context, now time.Time) (int, error) {
+	var expired []models.PaymentIntent
+	if err := s.db.WithContext(ctx).
+		Where("status IN ? AND expires_at <= ?", openIntentStatuses, now).
+		Find(&expired).Error; err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := range expired {
+		paymentIntent := &expired[i]
+
+		// The intent may have been paid since it was last synced; only unpaid ones expire
+		intent, err := s.gateway.CancelIntent(ctx, paymentIntent.GatewayRef)
+		if errors.Is(err, ErrInvalidGatewayState) {
+			intent, err = s.gateway.GetIntent(ctx, paymentIntent.GatewayRef)
+			if err == nil && intent.Status == IntentSucceeded {
+				if err := s.updateIntentStatus(ctx, paymentIntent, intent.Status); err != nil {
+					return count, err
+				}
+				if _, err := s.recordPayment(ctx, paymentIntent); err != nil {
+					return count, err
+				}
+				continue
+			}
+		}
+		if err != nil && !errors.Is(err, ErrGatewayObjectNotFound) {
+			s.logger.Warn("Failed to cancel expired payment intent", map[string]interface{}{
+				"payment_intent_id": paymentIntent.ID,
+				"gateway_ref":       paymentIntent.GatewayRef,
+				"error":             err.Error(),
+			})
+			continue
+		}
+
+		status := IntentCanceled
+		if intent != nil {
+			status = intent.Status
+		}
+		if err := s.updateIntentStatus(ctx, paymentIntent, status); err != nil {
+			return count, err
+		}
+		if status != IntentCanceled {
+			// Still processing at the gateway; its webhook settles it
+			continue
+		}
+		if err := s.releaseOrder(ctx, paymentIntent, "payment_expired"); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	if count > 0 {
+		s.logger.Info("Expired payment intents", map[string]interface{}{
+			"count": count,
+		})
+	}
+
+	return count, nil
+}
+
+// settleOrder reports a completed payment to the intent's order
+func (s *Service) settleOrder(ctx context.Context, paymentIntent *models.PaymentIntent, payment *models.Payment) error {
+	if paymentIntent.OrderID == nil || s.orders == nil {
+		return nil
+	}
+
+	if err := s.orders.MarkOrderPaid(ctx, *paymentIntent.OrderID, payment.ID); err != nil {
+		return fmt.Errorf("failed to mark order paid: %w", err)
+	}
+	return nil
+}
+
+// releaseOrder reports a payment that will not complete to the intent's order
+func (s *Service) releaseOrder(ctx context.Context, paymentIntent *models.PaymentIntent, reason string) error {
+	if paymentIntent.OrderID == nil || s.orders == nil {
+		return nil
+	}
+
+	if err := s.orders.ReleaseOrder(ctx, *paymentIntent.OrderID, reason); err != nil {
+		return fmt.Errorf("failed to release order: %w", err)
+	}
+	return nil
+}
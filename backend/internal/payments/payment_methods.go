@@ -0,0 +1,366 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrPaymentMethodExpired is returned when saving, or making default, a card past its
+// expiry date
+var ErrPaymentMethodExpired = errors.New("payment method has expired")
+
+// ErrNoDefaultPaymentMethod is returned when charging the default method of a user
+// without a usable one
+var ErrNoDefaultPaymentMethod = errors.New("no default payment method")
+
+// UpdatePaymentMethodRequest represents the billing details of a saved payment method.
+// Fields left out are unchanged.
+type UpdatePaymentMethodRequest struct {
+	Name    *string         `json:"name"`
+	Email   *string         `json:"email" binding:"omitempty,email"`
+	Phone   *string         `json:"phone"`
+	Address *models.Address `json:"address"`
+}
+
+// expiresAt returns when a card expiring in the given month stops working: cards are
+// valid through the last day of their expiry month
+func expiresAt(month, year int) time.Time {
+	return time.Date(year, time.Month(month)+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// customer returns the user's customer at the gateway, creating it on first use
+func (s *Service) customer(ctx context.Context, userID uuid.UUID) (string, error) {
+	var stored models.PaymentCustomer
+	err := s.db.WithContext(ctx).
+		Where("user_id = ? AND gateway = ?", userID, s.gateway.Name()).
+		First(&stored).Error
+	if err == nil {
+		return stored.CustomerRef, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", err
+	}
+
+	// The email only labels the customer in the gateway's dashboard
+	var user models.User
+	if err := s.db.WithContext(ctx).Select("email").First(&user, "id = ?", userID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", err
+	}
+	ref, err := s.gateway.CreateCustomer(ctx, CustomerParams{UserID: userID.String(), Email: user.Email})
+	if err != nil {
+		return "", fmt.Errorf("failed to create gateway customer: %w", err)
+	}
+
+	// A concurrent request may have stored the customer first; the stored one wins
+	stored = models.PaymentCustomer{UserID: userID, Gateway: s.gateway.Name(), CustomerRef: ref}
+	if err := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&stored).Error; err != nil {
+		return "", err
+	}
+	if err := s.db.WithContext(ctx).
+		Where("user_id = ? AND gateway = ?", userID, s.gateway.Name()).
+		First(&stored).Error; err != nil {
+		return "", err
+	}
+	return stored.CustomerRef, nil
+}
+
+// SavePaymentMethod saves a payment method for a user. A user's first method becomes
+// their default; saving the same method again returns the saved one. The method is
+// attached to the user's gateway customer so it can be charged off-session.
+func (s *Service) SavePaymentMethod(ctx context.Context, userID uuid.UUID, paymentMethodID string, isDefault bool) (*models.PaymentMethod, error) {
+	// Look up the card details so the method can be shown without exposing the token
+	method, err := s.gateway.GetPaymentMethod(ctx, paymentMethodID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve payment method: %w", err)
+	}
+
+	paymentMethod := &models.PaymentMethod{
+		UserID:     userID,
+		Type:       method.Type,
+		Provider:   s.gateway.Name(),
+		MethodRef:  method.Ref,
+		IsVerified: true,
+	}
+	if method.Brand != "" {
+		paymentMethod.Brand = &method.Brand
+	}
+	if method.Last4 != "" {
+		paymentMethod.Last4 = &method.Last4
+	}
+	if method.ExpiryMonth != 0 {
+		expiry := expiresAt(method.ExpiryMonth, method.ExpiryYear)
+		if !time.Now().Before(expiry) {
+			return nil, ErrPaymentMethodExpired
+		}
+		paymentMethod.ExpiryMonth = &method.ExpiryMonth
+		paymentMethod.ExpiryYear = &method.ExpiryYear
+		paymentMethod.ExpiresAt = &expiry
+	}
+
+	customerRef, err := s.customer(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save payment method: %w", err)
+	}
+	if err := s.gateway.AttachPaymentMethod(ctx, method.Ref, customerRef); err != nil {
+		return nil, fmt.Errorf("failed to attach payment method: %w", err)
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing models.PaymentMethod
+		err := tx.Where("user_id = ? AND method_ref = ?", userID, method.Ref).First(&existing).Error
+		if err == nil {
+			paymentMethod = &existing
+			if isDefault && !existing.IsDefault {
+				return s.makeDefault(tx, paymentMethod)
+			}
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		var defaults int64
+		if err := tx.Model(&models.PaymentMethod{}).
+			Where("user_id = ? AND is_default = ?", userID, true).
+			Count(&defaults).Error; err != nil {
+			return err
+		}
+
+		// Only one method per user is the default, so the previous one is unset first
+		if isDefault && defaults > 0 {
+			if err := tx.Model(&models.PaymentMethod{}).
+				Where("user_id = ? AND is_default = ?", userID, true).
+				Update("is_default", false).Error; err != nil {
+				return err
+			}
+		}
+		paymentMethod.IsDefault = isDefault || defaults == 0
+
+		return tx.Create(paymentMethod).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save payment method: %w", err)
+	}
+
+	s.logger.Info("Payment method saved", map[string]interface{}{
+		"payment_method_id": paymentMethod.ID,
+		"user_id":           userID,
+		"gateway_ref":       paymentMethodID,
+		"is_default":        paymentMethod.IsDefault,
+	})
+
+	return paymentMethod, nil
+}
+
+// GetUserPaymentMethods gets all payment methods for a user, default first
+func (s *Service) GetUserPaymentMethods(ctx context.Context, userID uuid.UUID) ([]models.PaymentMethod, error) {
+	var paymentMethods []models.PaymentMethod
+	err := s.db.WithContext(ctx).
+		Where("user_id = ? AND is_verified = ?", userID, true).
+		Order("is_default DESC, created_at DESC").
+		Find(&paymentMethods).Error
+
+	return paymentMethods, err
+}
+
+// GetPaymentMethod returns one of a user's saved payment methods
+func (s *Service) GetPaymentMethod(ctx context.Context, userID, id uuid.UUID) (*models.PaymentMethod, error) {
+	var paymentMethod models.PaymentMethod
+	if err := s.db.WithContext(ctx).
+		Where("id = ? AND user_id = ?", id, userID).
+		First(&paymentMethod).Error; err != nil {
+		return nil, err
+	}
+	return &paymentMethod, nil
+}
+
+// DefaultPaymentMethod returns the user's default payment method if it can be charged
+func (s *Service) DefaultPaymentMethod(ctx context.Context, userID uuid.UUID) (*models.PaymentMethod, error) {
+	var paymentMethod models.PaymentMethod
+	err := s.db.WithContext(ctx).
+		Where("user_id = ? AND is_default = ? AND is_expired = ? AND is_verified = ?", userID, true, false, true).
+		First(&paymentMethod).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNoDefaultPaymentMethod
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &paymentMethod, nil
+}
+
+// UpdatePaymentMethod updates the billing details of a saved payment method
+func (s *Service) UpdatePaymentMethod(ctx context.Context, userID, id uuid.UUID, req UpdatePaymentMethodRequest) (*models.PaymentMethod, error) {
+	paymentMethod, err := s.GetPaymentMethod(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		paymentMethod.Name = req.Name
+	}
+	if req.Email != nil {
+		paymentMethod.Email = req.Email
+	}
+	if req.Phone != nil {
+		paymentMethod.Phone = req.Phone
+	}
+	if req.Address != nil {
+		paymentMethod.Address = req.Address
+	}
+
+	if err := s.db.WithContext(ctx).Save(paymentMethod).Error; err != nil {
+		return nil, fmt.Errorf("failed to update payment method: %w", err)
+	}
+	return paymentMethod, nil
+}
+
+// SetDefaultPaymentMethod makes a saved payment method the user's default
+func (s *Service) SetDefaultPaymentMethod(ctx context.Context, userID, id uuid.UUID) (*models.PaymentMethod, error) {
+	paymentMethod, err := s.GetPaymentMethod(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if paymentMethod.IsExpired {
+		return nil, ErrPaymentMethodExpired
+	}
+	if paymentMethod.IsDefault {
+		return paymentMethod, nil
+	}
+
+	if err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return s.makeDefault(tx, paymentMethod)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to set default payment method: %w", err)
+	}
+
+	s.logger.Info("Default payment method changed", map[string]interface{}{
+		"payment_method_id": paymentMethod.ID,
+		"user_id":           userID,
+	})
+
+	return paymentMethod, nil
+}
+
+// DeletePaymentMethod detaches a saved payment method at the gateway and deletes it.
+// When it was the default, the user's newest usable method becomes the default.
+func (s *Service) DeletePaymentMethod(ctx context.Context, userID, id uuid.UUID) error {
+	paymentMethod, err := s.GetPaymentMethod(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+
+	wasDefault := paymentMethod.IsDefault
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(paymentMethod).Update("is_default", false).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(paymentMethod).Error; err != nil {
+			return err
+		}
+		if wasDefault {
+			if err := s.promoteDefault(tx, userID); err != nil {
+				return err
+			}
+		}
+
+		// Detached last, so a gateway failure keeps the method saved and the user can retry
+		if err := s.gateway.DetachPaymentMethod(ctx, paymentMethod.MethodRef); err != nil && !errors.Is(err, ErrGatewayObjectNotFound) {
+			return fmt.Errorf("failed to detach payment method: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info("Payment method deleted", map[string]interface{}{
+		"payment_method_id": paymentMethod.ID,
+		"user_id":           userID,
+		"gateway_ref":       paymentMethod.MethodRef,
+	})
+
+	return nil
+}
+
+// FlagExpiredPaymentMethods flags saved cards whose expiry date has passed. An expired
+// default is replaced by the user's newest usable method. It returns the number of
+// methods flagged.
+func (s *Service) FlagExpiredPaymentMethods(ctx context.Context, now time.Time) (int, error) {
+	var expired []models.PaymentMethod
+	if err := s.db.WithContext(ctx).
+		Where("is_expired = ? AND expires_at <= ?", false, now).
+		Find(&expired).Error; err != nil {
+		return 0, err
+	}
+
+	flagged := 0
+	for i := range expired {
+		paymentMethod := &expired[i]
+		wasDefault := paymentMethod.IsDefault
+		err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(paymentMethod).Updates(map[string]interface{}{
+				"is_expired": true,
+				"is_default": false,
+			}).Error; err != nil {
+				return err
+			}
+			if wasDefault {
+				return s.promoteDefault(tx, paymentMethod.UserID)
+			}
+			return nil
+		})
+		if err != nil {
+			s.logger.Error("Failed to flag expired payment method", map[string]interface{}{
+				"error":             err.Error(),
+				"payment_method_id": paymentMethod.ID,
+			})
+			continue
+		}
+		flagged++
+	}
+
+	if flagged > 0 {
+		s.logger.Info("Expired payment methods flagged", map[string]interface{}{
+			"count": flagged,
+		})
+	}
+	return flagged, nil
+}
+
+// makeDefault makes paymentMethod the default within tx, unsetting the previous default
+// first so the one-default-per-user index holds
+func (s *Service) makeDefault(tx *gorm.DB, paymentMethod *models.PaymentMethod) error {
+	if err := tx.Model(&models.PaymentMethod{}).
+		Where("user_id = ? AND is_default = ? AND id <> ?", paymentMethod.UserID, true, paymentMethod.ID).
+		Update("is_default", false).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(paymentMethod).Update("is_default", true).Error; err != nil {
+		return err
+	}
+	paymentMethod.IsDefault = true
+	return nil
+}
+
+// promoteDefault makes the user's newest usable method their default, if they have one
+func (s *Service) promoteDefault(tx *gorm.DB, userID uuid.UUID) error {
+	var next models.PaymentMethod
+	err := tx.Where("user_id = ? AND is_expired = ? AND is_verified = ?", userID, false, true).
+		Order("created_at DESC").
+		First(&next).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.makeDefault(tx, &next)
+}
@@ -0,0 +1,732 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/pkg/money"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Refund statuses
+const (
+	RefundPendingApproval = "pending_approval"
+	RefundProcessing      = "processing" // saved before it is sent to the gateway
+	RefundPending         = "pending"
+	RefundSucceeded       = "succeeded"
+	RefundFailed          = "failed"
+	RefundRejected        = "rejected"
+)
+
+// Refund methods
+const (
+	RefundMethodOriginal    = "original"     // back to the payment method that paid
+	RefundMethodStoreCredit = "store_credit" // to the buyer's store credit wallet
+)
+
+var (
+	// ErrRefundExceedsPayment is returned when a refund is larger than the unrefunded amount
+	ErrRefundExceedsPayment = errors.New("refund exceeds the refundable amount")
+	// ErrInvalidRefundItem is returned for a refund item that is not part of the paid
+	// order or exceeds the quantity left to refund
+	ErrInvalidRefundItem = errors.New("invalid refund item")
+	// ErrRefundNotAllowed is returned when a seller refunds items they did not sell
+	ErrRefundNotAllowed = errors.New("refund not allowed")
+	// ErrRefundNotPending is returned when approving or rejecting a refund that is not
+	// awaiting approval
+	ErrRefundNotPending = errors.New("refund is not awaiting approval")
+	// ErrPaymentNotRefundable is returned when refunding a payment that is not completed
+	// or partially refunded
+	ErrPaymentNotRefundable = errors.New("cannot refund payment with status")
+	// ErrStoreCreditUnavailable is returned when refunding to store credit without a
+	// store credit service
+	ErrStoreCreditUnavailable = errors.New("refunds to store credit are not available")
+)
+
+// activeRefundStatuses are the statuses of refunds that count against what is left to
+// refund
+var activeRefundStatuses = []string{RefundPendingApproval, RefundProcessing, RefundPending, RefundSucceeded}
+
+// RefundItemRequest is a quantity of an order item to refund
+type RefundItemRequest struct {
+	OrderItemID uuid.UUID `json:"order_item_id" binding:"required"`
+	Quantity    int       `json:"quantity" binding:"required,gt=0"`
+}
+
+// RefundRequest describes a refund to make. A refund either lists the order items it
+// returns, which sets its amount, or gives an amount of the payment to refund.
+type RefundRequest struct {
+	PaymentID   uuid.UUID
+	Amount      int64
+	Items       []RefundItemRequest
+	Reason      string
+	Restock     bool
+	Notes       *string
+	RequestedBy uuid.UUID
+	// SellerID is set for refunds requested by a seller. They may only refund their own
+	// items and wait for an admin to approve them.
+	SellerID *uuid.UUID
+	// StoreCredit pays the refund to the buyer's store credit instead of the card
+	StoreCredit bool
+}
+
+// StoreCreditFunder adds the part of refunds paid as store credit to the buyer's wallet,
+// within the transaction that records the refund
+type StoreCreditFunder interface {
+	FundRefund(ctx context.Context, tx *gorm.DB, refund *models.Refund, payment *models.Payment) error
+}
+
+// SetStoreCredit sets the service that keeps store credit wallets, enabling refunds to
+// store credit
+func (s *Service) SetStoreCredit(storeCredit StoreCreditFunder) {
+	s.storeCredit = storeCredit
+}
+
+// RefundFilter narrows a list of refunds
+type RefundFilter struct {
+	Status      string
+	RequestedBy *uuid.UUID
+	PaymentID   *uuid.UUID
+}
+
+// RefundPayment refunds an amount of a payment straight away
+func (s *Service) RefundPayment(ctx context.Context, paymentID uuid.UUID, amount int64, reason string, processedBy uuid.UUID) (*models.Refund, error) {
+	return s.RequestRefund(ctx, RefundRequest{
+		PaymentID:   paymentID,
+		Amount:      amount,
+		Reason:      reason,
+		RequestedBy: processedBy,
+	})
+}
+
+// RequestRefund validates a refund against what is left to refund on the payment and
+// its items. Seller refunds are held for approval; others are sent to the gateway.
+func (s *Service) RequestRefund(ctx context.Context, req RefundRequest) (*models.Refund, error) {
+	var payment models.Payment
+	if err := s.db.WithContext(ctx).First(&payment, "id = ?", req.PaymentID).Error; err != nil {
+		return nil, fmt.Errorf("payment not found: %w", err)
+	}
+	if payment.Status != "completed" && payment.Status != "partially_refunded" {
+		return nil, fmt.Errorf("%w: %s", ErrPaymentNotRefundable, payment.Status)
+	}
+	if req.SellerID != nil && len(req.Items) == 0 {
+		return nil, fmt.Errorf("%w: sellers refund specific items", ErrInvalidRefundItem)
+	}
+	method := RefundMethodOriginal
+	if req.StoreCredit {
+		if s.storeCredit == nil {
+			return nil, ErrStoreCreditUnavailable
+		}
+		method = RefundMethodStoreCredit
+	}
+
+	refund := &models.Refund{
+		PaymentID:   payment.ID,
+		OrderID:     payment.OrderID,
+		Reason:      req.Reason,
+		Status:      RefundPendingApproval,
+		GatewayType: payment.GatewayType,
+		Restock:     req.Restock,
+		RequestedBy: req.RequestedBy,
+		Notes:       req.Notes,
+		Method:      method,
+		Metadata:    "{}",
+	}
+
+	amount := money.New(req.Amount, payment.Currency)
+	if len(req.Items) > 0 {
+		items, total, err := s.refundItems(ctx, &payment, req.Items, req.SellerID)
+		if err != nil {
+			return nil, err
+		}
+		refund.Items = items
+		amount = total
+	}
+	if amount.Amount <= 0 {
+		return nil, fmt.Errorf("%w: nothing to refund", ErrRefundExceedsPayment)
+	}
+	refund.Amount = amount.Amount
+
+	remaining, err := s.refundableAmount(s.db.WithContext(ctx), &payment, nil)
+	if err != nil {
+		return nil, err
+	}
+	if amount.Amount > remaining.Amount {
+		return nil, ErrRefundExceedsPayment
+	}
+
+	if req.SellerID != nil {
+		err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if _, err := s.reserveRefund(tx, refund, payment.ID); err != nil {
+				return err
+			}
+			if err := tx.Create(refund).Error; err != nil {
+				return fmt.Errorf("failed to save refund request: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		s.logger.Info("Refund requested", map[string]interface{}{
+			"refund_id":  refund.ID,
+			"payment_id": payment.ID,
+			"seller_id":  *req.SellerID,
+			"amount":     refund.Amount,
+		})
+		return refund, nil
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return s.prepareRefund(tx, refund, &payment, req.RequestedBy)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.sendRefund(ctx, refund, &payment); err != nil {
+		return nil, err
+	}
+	return refund, nil
+}
+
+// ApproveRefund sends a seller's refund request to the gateway
+func (s *Service) ApproveRefund(ctx context.Context, refundID, approvedBy uuid.UUID) (*models.Refund, error) {
+	var refund models.Refund
+	if err := s.db.WithContext(ctx).Preload("Items").First(&refund, "id = ?", refundID).Error; err != nil {
+		return nil, err
+	}
+	if refund.Status != RefundPendingApproval {
+		return nil, ErrRefundNotPending
+	}
+
+	var payment models.Payment
+	if err := s.db.WithContext(ctx).First(&payment, "id = ?", refund.PaymentID).Error; err != nil {
+		return nil, fmt.Errorf("payment not found: %w", err)
+	}
+	if payment.Status != "completed" && payment.Status != "partially_refunded" {
+		return nil, fmt.Errorf("%w: %s", ErrPaymentNotRefundable, payment.Status)
+	}
+	remaining, err := s.refundableAmount(s.db.WithContext(ctx), &payment, &refund.ID)
+	if err != nil {
+		return nil, err
+	}
+	if refund.Amount > remaining.Amount {
+		return nil, ErrRefundExceedsPayment
+	}
+
+	// Claim the request so it is only sent once, in the transaction that reserves it: a
+	// refund that cannot be reserved stays awaiting approval
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Refund{}).
+			Where("id = ? AND status = ?", refund.ID, RefundPendingApproval).
+			Updates(map[string]interface{}{"status": RefundProcessing, "updated_at": time.Now()})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefundNotPending
+		}
+		return s.prepareRefund(tx, &refund, &payment, approvedBy)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.sendRefund(ctx, &refund, &payment); err != nil {
+		// Put a request the gateway turned down back so it can be approved again
+		s.db.WithContext(ctx).Model(&models.Refund{}).
+			Where("id = ? AND status = ?", refund.ID, RefundFailed).
+			Update("status", RefundPendingApproval)
+		return nil, err
+	}
+	return &refund, nil
+}
+
+// RejectRefund declines a seller's refund request
+func (s *Service) RejectRefund(ctx context.Context, refundID, rejectedBy uuid.UUID, notes *string) (*models.Refund, error) {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":       RefundRejected,
+		"processed_by": rejectedBy,
+		"processed_at": now,
+		"updated_at":   now,
+	}
+	if notes != nil {
+		updates["notes"] = *notes
+	}
+
+	result := s.db.WithContext(ctx).Model(&models.Refund{}).
+		Where("id = ? AND status = ?", refundID, RefundPendingApproval).
+		Updates(updates)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	var refund models.Refund
+	if err := s.db.WithContext(ctx).Preload("Items").First(&refund, "id = ?", refundID).Error; err != nil {
+		return nil, err
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrRefundNotPending
+	}
+	return &refund, nil
+}
+
+// GetRefund retrieves a refund with its items
+func (s *Service) GetRefund(ctx context.Context, id uuid.UUID) (*models.Refund, error) {
+	var refund models.Refund
+	if err := s.db.WithContext(ctx).Preload("Items").First(&refund, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
+// ListRefunds lists refunds, most recent first
+func (s *Service) ListRefunds(ctx context.Context, filter RefundFilter, page, limit int) ([]models.Refund, int64, error) {
+	query := s.db.WithContext(ctx).Model(&models.Refund{})
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.RequestedBy != nil {
+		query = query.Where("requested_by = ?", *filter.RequestedBy)
+	}
+	if filter.PaymentID != nil {
+		query = query.Where("payment_id = ?", *filter.PaymentID)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var refunds []models.Refund
+	err := query.Preload("Items").
+		Order("created_at DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&refunds).Error
+	return refunds, total, err
+}
+
+// prepareRefund reserves a refund against its payment within tx and saves it as
+// processing, split into the card part and the rest, paid with gift cards or store
+// credit, that goes back to the buyer's store credit. The card is refunded first.
+//
+// The refund is saved as processing before the gateway is called, so a crash or timeout
+// in between leaves a record that RetryProcessingRefunds sends again, with the same
+// idempotency key, instead of money refunded that the platform knows nothing about.
+func (s *Service) prepareRefund(tx *gorm.DB, refund *models.Refund, payment *models.Payment, processedBy uuid.UUID) error {
+	if refund.ID == uuid.Nil {
+		refund.ID = uuid.New()
+	}
+
+	current, err := s.reserveRefund(tx, refund, payment.ID)
+	if err != nil {
+		return err
+	}
+	*payment = *current
+
+	// Card refunds in flight are not in the refunded amount yet, so they are taken off
+	// what is left of the card payment here
+	amount := money.New(refund.Amount, payment.Currency)
+	cardLeft, err := s.cardRefundable(tx, payment, refund.ID)
+	if err != nil {
+		return err
+	}
+	card := money.New(min(amount.Amount, max(cardLeft.Amount, 0)), amount.Currency)
+	credit := money.New(amount.Amount-card.Amount, amount.Currency)
+	if refund.Method == RefundMethodStoreCredit {
+		credit = amount
+	}
+	refund.CreditAmount = credit.Amount
+	if credit.Amount > 0 && s.storeCredit == nil {
+		return ErrStoreCreditUnavailable
+	}
+
+	refund.Status = RefundProcessing
+	refund.ProcessedBy = &processedBy
+	if err := tx.Save(refund).Error; err != nil {
+		return fmt.Errorf("failed to save refund record: %w", err)
+	}
+	return nil
+}
+
+// sendRefund sends the card part of a processing refund to the gateway and completes the
+// refund with the gateway's answer. A refund the gateway turns down, or that no longer
+// fits in what is left to refund on its payment, is marked failed; one that cannot be
+// sent, e.g. because the gateway timed out, stays processing.
+func (s *Service) sendRefund(ctx context.Context, refund *models.Refund, payment *models.Payment) error {
+	card := refund.Amount -
+		refund.CreditAmount
+
+	if err := s.checkRefund(ctx, refund, payment, card); err != nil {
+		if errors.Is(err, ErrRefundExceedsPayment) || errors.Is(err, ErrPaymentNotRefundable) {
+			if err := s.updateRefund(ctx, refund, payment, RefundProcessing, &GatewayRefund{Status: RefundFailed}); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+
+	// Refunds to store credit stay on the platform and succeed straight away
+	refundObj := &GatewayRefund{Status: RefundSucceeded}
+	if card > 0 {
+		var err error
+		refundObj, err = s.gateway.Refund(ctx, RefundParams{
+			IntentRef: payment.GatewayRef,
+			Amount:    card,
+			Reason:    refund.Reason,
+			Metadata: map[string]string{
+				"refund_id": refund.ID.String(),
+			},
+		})
+		if err != nil {
+			s.logger.Error("Failed to create gateway refund", map[string]interface{}{
+				"error":      err.Error(),
+				"refund_id":  refund.ID,
+				"payment_id": payment.ID,
+				"amount":     refund.Amount,
+			})
+			if errors.Is(err, ErrInvalidGatewayState) || errors.Is(err, ErrGatewayObjectNotFound) || errors.Is(err, ErrPaymentDeclined) {
+				if err := s.updateRefund(ctx, refund, payment, RefundProcessing, &GatewayRefund{Status: RefundFailed}); err != nil {
+					return err
+				}
+			}
+			return fmt.Errorf("failed to process refund: %w", err)
+		}
+	}
+
+	return s.updateRefund(ctx, refund, payment, RefundProcessing, refundObj)
+}
+
+// updateRefund moves a refund in the from status to the gateway's status for it. A
+// refund that succeeds is applied in the same transaction: the payment's new refunded
+// amount, its store credit, its ledger posting and its effect on the order. Refunds the
+// gateway is still processing are applied once it reports them succeeded. A refund no
+// longer in the from status was updated concurrently and is left alone.
+func (s *Service) updateRefund(ctx context.Context, refund *models.Refund, payment *models.Payment, from string, refundObj *GatewayRefund) error {
+	now := time.Now()
+	status := refundObj.Status
+	if status == "canceled" {
+		status = RefundFailed
+	}
+	updates := map[string]interface{}{
+		"status":       status,
+		"processed_at": now,
+		"updated_at":   now,
+	}
+	if refundObj.Ref != "" {
+		updates["gateway_ref"] = refundObj.Ref
+	}
+	if refundObj.Metadata != nil {
+		updates["metadata"] = s.mapToJSON(refundObj.Metadata)
+	}
+
+	updated := true
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Refund{}).
+			Where("id = ? AND status = ?", refund.ID, from).
+			Updates(updates)
+		if result.Error != nil {
+			return fmt.Errorf("failed to save refund record: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			updated = false
+			return nil
+		}
+		if err := tx.Preload("Items").First(refund, "id = ?", refund.ID).Error; err != nil {
+			return err
+		}
+		if status != RefundSucceeded {
+			return nil
+		}
+		return s.applyRefund(ctx, tx, refund, payment)
+	})
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return s.db.WithContext(ctx).Preload("Items").First(refund, "id = ?", refund.ID).Error
+	}
+
+	s.logger.Info("Refund processed", map[string]interface{}{
+		"refund_id":   refund.ID,
+		"payment_id":  payment.ID,
+		"gateway_ref": refund.GatewayRef,
+		"amount":      refund.Amount,
+		"status":      refund.Status,
+	})
+	return nil
+}
+
+// applyRefund records the effects of a succeeded refund
+func (s *Service) applyRefund(ctx context.Context, tx *gorm.DB, refund *models.Refund, payment *models.Payment) error {
+	// The payment's refunded amount is what was refunded of the card payment; it is
+	// fully refunded once the credit applied to its order is refunded too
+	current, err := lockPayment(tx, payment.ID)
+	if err != nil {
+		return err
+	}
+	card := refund.Amount -
+		refund.CreditAmount
+	refunded := current.RefundedAmount + card
+	current.RefundedAmount = refunded
+	left, err := s.unrefundedAmount(tx, current)
+	if err != nil {
+		return err
+	}
+	fullyRefunded := left.Amount <= 0
+	current.Status = "partially_refunded"
+	if fullyRefunded {
+		current.Status = "refunded"
+	}
+	if err := tx.Save(current).Error; err != nil {
+		return fmt.Errorf("failed to update payment: %w", err)
+	}
+	*payment = *current
+
+	if refund.CreditAmount > 0 {
+		if s.storeCredit == nil {
+			return ErrStoreCreditUnavailable
+		}
+		if err := s.storeCredit.FundRefund(ctx, tx, refund, payment); err != nil {
+			return fmt.Errorf("failed to fund store credit: %w", err)
+		}
+	}
+	if s.ledger != nil {
+		if _, err := s.ledger.WithTx(tx).PostRefund(ctx, refund, payment); err != nil {
+			return fmt.Errorf("failed to post refund to ledger: %w", err)
+		}
+	}
+	if s.orders != nil {
+		if err := s.orders.ApplyOrderRefund(ctx, tx, refund, fullyRefunded); err != nil {
+			return fmt.Errorf("failed to apply refund to order: %w", err)
+		}
+	}
+	return nil
+}
+
+// handleRefundUpdated applies charge.refund.updated webhooks to refunds the gateway was
+// still processing
+func (s *Service) handleRefundUpdated(ctx context.Context, event *GatewayEvent) error {
+	if event.Status == RefundPending {
+		return nil
+	}
+
+	var refund models.Refund
+	if err := s.db.WithContext(ctx).First(&refund, "gateway_ref = ? AND status = ?", event.RefundRef, RefundPending).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to find refund: %w", err)
+	}
+	var payment models.Payment
+	if err := s.db.WithContext(ctx).First(&payment, "id = ?", refund.PaymentID).Error; err != nil {
+		return fmt.Errorf("payment not found: %w", err)
+	}
+
+	return s.updateRefund(ctx, &refund, &payment, RefundPending, &GatewayRefund{Status: event.Status})
+}
+
+// lockPayment loads a payment for update, so changes to what is refunded of it are made
+// one at a time
+func lockPayment(tx *gorm.DB, paymentID uuid.UUID) (*models.Payment, error) {
+	var payment models.Payment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, "id = ?", paymentID).Error; err != nil {
+		return nil, fmt.Errorf("payment not found: %w", err)
+	}
+	return &payment, nil
+}
+
+// reserveRefund locks the refund's payment and checks the refund against what is left to
+// refund, so concurrent refunds cannot together exceed it
+func (s *Service) reserveRefund(tx *gorm.DB, refund *models.Refund, paymentID uuid.UUID) (*models.Payment, error) {
+	payment, err := lockPayment(tx, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if payment.Status != "completed" && payment.Status != "partially_refunded" {
+		return nil, fmt.Errorf("%w: %s", ErrPaymentNotRefundable, payment.Status)
+	}
+	remaining, err := s.refundableAmount(tx, payment, &refund.ID)
+	if err != nil {
+		return nil, err
+	}
+	if refund.Amount > remaining.Amount {
+		return nil, ErrRefundExceedsPayment
+	}
+	return payment, nil
+}
+
+// checkRefund checks a processing refund against its payment again before it is sent:
+// the payment must still be refundable, and the refund and its card part must fit in
+// what is left of it
+func (s *Service) checkRefund(ctx context.Context, refund *models.Refund, payment *models.Payment, card int64) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		current, err := s.reserveRefund(tx, refund, payment.ID)
+		if err != nil {
+			return err
+		}
+		*payment = *current
+
+		cardLeft, err := s.cardRefundable(tx, payment, refund.ID)
+		if err != nil {
+			return err
+		}
+		if card > cardLeft.Amount {
+			return ErrRefundExceedsPayment
+		}
+		return nil
+	})
+}
+
+// cardRefundable is what is left of a card payment after its refunds, including card
+// refunds the gateway has not completed yet
+func (s *Service) cardRefundable(db *gorm.DB, payment *models.Payment, excluding uuid.UUID) (money.Money, error) {
+	var inFlight []models.Refund
+	if err := db.Where("payment_id = ? AND status IN ? AND id <> ?", payment.ID, []string{RefundProcessing, RefundPending}, excluding).
+		Find(&inFlight).Error; err != nil {
+		return money.Money{}, err
+	}
+	left := payment.Amount -
+		payment.RefundedAmount
+	for _, refund := range inFlight {
+		left -= refund.Amount -
+			refund.CreditAmount
+	}
+	return money.New(left, payment.Currency), nil
+}
+
+// RetryProcessingRefunds sends again the refunds left processing since before, e.g. by a
+// gateway timeout or a restart, and returns how many were completed. The gateway
+// recognizes refunds it already made by their idempotency key.
+func (s *Service) RetryProcessingRefunds(ctx context.Context, before time.Time) (int, error) {
+	var refunds []models.Refund
+	if err := s.db.WithContext(ctx).
+		Where("status = ? AND updated_at < ?", RefundProcessing, before).
+		Order("updated_at ASC").
+		Limit(100).
+		Find(&refunds).Error; err != nil {
+		return 0, err
+	}
+
+	completed := 0
+	for i := range refunds {
+		refund := &refunds[i]
+		var payment models.Payment
+		if err := s.db.WithContext(ctx).First(&payment, "id = ?", refund.PaymentID).Error; err != nil {
+			return completed, fmt.Errorf("payment not found: %w", err)
+		}
+		if err := s.sendRefund(ctx, refund, &payment); err != nil {
+			continue
+		}
+		completed++
+	}
+	return completed, nil
+}
+
+// refundableAmount is what is left to refund on a payment after refunds already sent
+// and those awaiting approval, leaving out the refund being approved
+func (s *Service) refundableAmount(db *gorm.DB, payment *models.Payment, excluding *uuid.UUID) (money.Money, error) {
+	return s.remainingAmount(db, payment, activeRefundStatuses, excluding)
+}
+
+// unrefundedAmount is what is left to refund on a payment after its succeeded refunds
+func (s *Service) unrefundedAmount(db *gorm.DB, payment *models.Payment) (money.Money, error) {
+	return s.remainingAmount(db, payment, []string{RefundSucceeded}, nil)
+}
+
+// remainingAmount is what was paid for a payment's order, by card and with gift cards
+// and store credit, less its refunds in the given statuses. Refunds are therefore capped
+// at the order's total.
+func (s *Service) remainingAmount(db *gorm.DB, payment *models.Payment, statuses []string, excluding *uuid.UUID) (money.Money, error) {
+	remaining := payment.Amount
+	if payment.OrderID != nil {
+		var order models.Order
+		if err := db.Select("id", "credit_amount", "currency").First(&order, "id = ?", *payment.OrderID).Error; err != nil {
+			return money.Money{}, fmt.Errorf("order not found: %w", err)
+		}
+		remaining += order.CreditAmount
+	}
+
+	query := db.Model(&models.Refund{}).
+		Where("payment_id = ? AND status IN ?", payment.ID, statuses)
+	if excluding != nil {
+		query = query.Where("id <> ?", *excluding)
+	}
+	var refunds []models.Refund
+	if err := query.Find(&refunds).Error; err != nil {
+		return money.Money{}, err
+	}
+	for _, refund := range refunds {
+		remaining -= refund.Amount
+	}
+	return money.New(remaining, payment.Currency), nil
+}
+
+// refundItems prices the requested items of the payment's order. Each item can be
+// refunded up to the quantity and value not already refunded; refunding the last units
+// returns whatever value is left.
+func (s *Service) refundItems(ctx context.Context, payment *models.Payment, requested []RefundItemRequest, sellerID *uuid.UUID) ([]models.RefundItem, money.Money, error) {
+	total := money.Zero(payment.Currency)
+	if payment.OrderID == nil {
+		return nil, total, fmt.Errorf("%w: payment is not for an order", ErrInvalidRefundItem)
+	}
+
+	items := make([]models.RefundItem, 0, len(requested))
+	seen := make(map[uuid.UUID]bool, len(requested))
+	for _, req := range requested {
+		if seen[req.OrderItemID] || req.Quantity <= 0 {
+			return nil, total, fmt.Errorf("%w: %s", ErrInvalidRefundItem, req.OrderItemID)
+		}
+		seen[req.OrderItemID] = true
+
+		var orderItem models.OrderItem
+		if err := s.db.WithContext(ctx).Preload("Product").
+			First(&orderItem, "id = ? AND order_id = ?", req.OrderItemID, *payment.OrderID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, total, fmt.Errorf("%w: %s is not part of the order", ErrInvalidRefundItem, req.OrderItemID)
+			}
+			return nil, total, err
+		}
+		if sellerID != nil && orderItem.Product.SellerID != *sellerID {
+			return nil, total, ErrRefundNotAllowed
+		}
+
+		var refunded struct {
+			Quantity int
+			Amount   int64
+		}
+		if err := s.db.WithContext(ctx).Table("refund_items").
+			Select("COALESCE(SUM(refund_items.quantity), 0) AS quantity, COALESCE(SUM(refund_items.amount), 0) AS amount").
+			Joins("JOIN refunds ON refunds.id = refund_items.refund_id").
+			Where("refund_items.order_item_id = ? AND refunds.status IN ? AND refund_items.deleted_at IS NULL", orderItem.ID, activeRefundStatuses).
+			Scan(&refunded).Error; err != nil {
+			return nil, total, err
+		}
+
+		leftQuantity := orderItem.Quantity - refunded.Quantity
+		if req.Quantity > leftQuantity {
+			return nil, total, fmt.Errorf("%w: only %d of %s left to refund", ErrInvalidRefundItem, leftQuantity, orderItem.ID)
+		}
+		leftValue := orderItem.Total - refunded.Amount
+		amount := money.New(orderItem.UnitPrice, payment.Currency).Mul(int64(req.Quantity)).Amount
+		if req.Quantity == leftQuantity || amount > leftValue {
+			amount = leftValue
+		}
+
+		items = append(items, models.RefundItem{
+			OrderItemID: orderItem.ID,
+			Quantity:    req.Quantity,
+			Amount:      amount,
+		})
+		total.Amount += amount
+	}
+	return items, total, nil
+}
@@ -3,407 +3,399 @@ package payments
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"time"
 
+	"github.com/blytz.live.remake/backend/internal/ledger"
 	"github.com/blytz.live.remake/backend/internal/models"
 	"github.com/blytz.live.remake/backend/pkg/logging"
+	"github.com/blytz.live.remake/backend/pkg/money"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
-	"github.com/stripe/stripe-go/v76"
-	"github.com/stripe/stripe-go/v76/paymentintent"
-	"github.com/stripe/stripe-go/v76/refund"
-	"github.com/stripe/stripe-go/v76/webhook"
 )
 
 // Service provides payment processing services
 type Service struct {
-	db     *gorm.DB
-	logger *logging.Logger
-	apiKey string
+	db          *gorm.DB
+	logger      *logging.Logger
+	gateway     PaymentGateway
+	orders      OrderPaymentHandler
+	ledger      *ledger.Service
+	storeCredit StoreCreditFunder
+	webhookWake chan struct{}
 }
 
-// NewService creates a new payment service
-func NewService(db *gorm.DB, apiKey string) *Service {
-	logger := logging.NewLogger()
-	
-	// Initialize Stripe
-	stripe.Key = apiKey
-	
+// NewService creates a new payment service backed by the given payment gateway
+func NewService(db *gorm.DB, gateway PaymentGateway) *Service {
 	return &Service{
-		db:     db,
-		logger: logger,
-		apiKey: apiKey,
+		db:          db,
+		logger:      logging.NewLogger(),
+		gateway:     gateway,
+		webhookWake: make(chan struct{}, 1),
 	}
 }
 
+// SetLedger sets the ledger that payments and refunds are posted to
+func (s *Service) SetLedger(ledgerService *ledger.Service) {
+	s.ledger = ledgerService
+}
+
+// Gateway returns the payment gateway used by the service
+func (s *Service) Gateway() PaymentGateway {
+	return s.gateway
+}
+
 // CreatePaymentIntent creates a new payment intent
-func (s *Service) CreatePaymentIntent(ctx context.Context, userID uuid.UUID, amount float64, currency string, metadata map[string]string) (*models.PaymentIntent, error) {
-	// Convert amount to cents (Stripe uses smallest currency unit)
-	amountCents := int64(amount * 100)
-	
-	// Create payment intent with Stripe
-	params := &stripe.PaymentIntentParams{
-		Amount:   stripe.Int64(amountCents),
-		Currency: stripe.String(currency),
-		AutomaticPaymentMethods: &stripe.PaymentIntentAutomaticPaymentMethodsParams{
-			Enabled: stripe.Bool(true),
-		},
-	}
-	
+func (s *Service) CreatePaymentIntent(ctx context.Context, userID uuid.UUID, amount int64, currency string, metadata map[string]string) (*models.PaymentIntent, error) {
+	return s.createIntent(ctx, userID, nil, amount, currency, "card", metadata, "")
+}
+
+// createIntent creates a gateway payment intent and stores it, bound to orderID when set.
+// With offSessionMethodRef the gateway charges that saved method straight away.
+func (s *Service) createIntent(ctx context.Context, userID uuid.UUID, orderID *uuid.UUID, amount int64, currency, paymentMethod string, metadata map[string]string, offSessionMethodRef string) (*models.PaymentIntent, error) {
+	currency = money.NormalizeCurrency(currency)
+	if !money.IsSupported(currency) {
+		return nil, fmt.Errorf("%w: %s", money.ErrUnknownCurrency, currency)
+	}
+	price := money.New(amount, currency)
+
 	// Set expiration to 30 minutes
-	expiresAt := time.Now().Add(30 * time.Minute)
-	
-	// Use metadata for expiration
-	if params.Metadata == nil {
-		params.Metadata = make(map[string]string)
-	}
+	expiresAt := time.Now().Add(PaymentIntentTTL)
+
+	// The gateway keeps the expiry alongside the caller's metadata
+	gatewayMetadata := make(map[string]string, len(metadata)+1)
 	for k, v := range metadata {
-		params.Metadata[k] = v
+		gatewayMetadata[k] = v
+	}
+	gatewayMetadata["expires_at"] = expiresAt.Format(time.RFC3339)
+	if orderID != nil {
+		gatewayMetadata["order_id"] = orderID.String()
 	}
-	params.Metadata["expires_at"] = expiresAt.Format(time.RFC3339)
-	
-	pi, err := paymentintent.New(params)
+
+	params := IntentParams{
+		Amount:           price.Amount,
+		Currency:         currency,
+		PaymentMethodRef: offSessionMethodRef,
+		OffSession:       offSessionMethodRef != "",
+		Metadata:         gatewayMetadata,
+	}
+	// Saved methods are attached to the user's customer and can only be charged through it
+	if params.OffSession {
+		customerRef, err := s.customer(ctx, userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create payment intent: %w", err)
+		}
+		params.CustomerRef = customerRef
+	}
+
+	intent, err := s.gateway.CreateIntent(ctx, params)
 	if err != nil {
-		s.logger.Error("Failed to create Stripe payment intent", map[string]interface{}{
-			"error":  err.Error(),
-			"amount": amount,
+		s.logger.Error("Failed to create gateway payment intent", map[string]interface{}{
+			"error":   err.Error(),
+			"amount":  amount,
 			"user_id": userID,
+			"gateway": s.gateway.Name(),
 		})
 		return nil, fmt.Errorf("failed to create payment intent: %w", err)
 	}
-	
+
 	// Save to database
 	paymentIntent := &models.PaymentIntent{
-		UserID:       userID,
-		Amount:       amount,
-		Currency:     currency,
-		Status:       string(pi.Status),
-		ClientSecret: pi.ClientSecret,
-		GatewayRef:   pi.ID,
-		GatewayType:  "stripe",
-		PaymentMethods: []string{"card"},
-		ExpiresAt:    expiresAt,
-		Metadata:     s.mapToJSON(metadata),
-	}
-	
+		UserID:         userID,
+		Amount:         price.Amount,
+		Currency:       currency,
+		Status:         intent.Status,
+		ClientSecret:   intent.ClientSecret,
+		GatewayRef:     intent.Ref,
+		GatewayType:    s.gateway.Name(),
+		PaymentMethods: []string{paymentMethod},
+		ExpiresAt:      expiresAt,
+		OrderID:        orderID,
+		Metadata:       s.mapToJSON(metadata),
+	}
+
 	if err := s.db.WithContext(ctx).Create(paymentIntent).Error; err != nil {
 		return nil, fmt.Errorf("failed to save payment intent: %w", err)
 	}
-	
+
 	s.logger.Info("Payment intent created", map[string]interface{}{
 		"payment_intent_id": paymentIntent.ID,
-		"stripe_id":        pi.ID,
-		"amount":           amount,
-		"user_id":          userID,
+		"gateway_ref":       intent.Ref,
+		"amount":            amount,
+		"user_id":           userID,
+		"order_id":          orderID,
 	})
-	
+
 	return paymentIntent, nil
 }
 
-// ConfirmPayment confirms and processes a payment
-func (s *Service) ConfirmPayment(ctx context.Context, paymentIntentID uuid.UUID) (*models.Payment, error) {
+// ConfirmPayment confirms and processes a payment. With a payment method, or when the
+// intent is waiting for confirmation, the intent is confirmed server-side; otherwise the
+// client has already confirmed it and the gateway's state is read back.
+func (s *Service) ConfirmPayment(ctx context.Context, paymentIntentID uuid.UUID, paymentMethodRef string) (*models.Payment, error) {
 	// Get payment intent from database
 	var paymentIntent models.PaymentIntent
 	if err := s.db.WithContext(ctx).First(&paymentIntent, "id = ?", paymentIntentID).Error; err != nil {
 		return nil, fmt.Errorf("payment intent not found: %w", err)
 	}
-	
-	// Get status from Stripe
-	pi, err := paymentintent.Get(paymentIntent.GatewayRef, nil)
+
+	var intent *GatewayIntent
+	var err error
+	if paymentMethodRef != "" || paymentIntent.Status == IntentRequiresConfirmation {
+		intent, err = s.gateway.ConfirmIntent(ctx, paymentIntent.GatewayRef, paymentMethodRef)
+	} else {
+		intent, err = s.gateway.GetIntent(ctx, paymentIntent.GatewayRef)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve payment intent from Stripe: %w", err)
+		return nil, fmt.Errorf("failed to confirm payment intent: %w", err)
 	}
-	
+
 	// Update payment intent status
-	paymentIntent.Status = string(pi.Status)
-	s.db.WithContext(ctx).Save(&paymentIntent)
-	
-	if pi.Status != stripe.PaymentIntentStatusSucceeded {
-		return nil, fmt.Errorf("payment not successful: %s", pi.Status)
-	}
-	
-	// Create payment record
-	payment := &models.Payment{
-		UserID:        paymentIntent.UserID,
-		PaymentMethod: "stripe",
-		Amount:        paymentIntent.Amount,
-		Currency:      paymentIntent.Currency,
-		Status:        "completed",
-		TransactionID: pi.ID,
-		GatewayRef:    pi.ID,
-		GatewayType:   "stripe",
-		ProcessedAt:   &[]time.Time{time.Now()}[0],
-		Metadata:      paymentIntent.Metadata,
-	}
-	
-	if paymentIntent.OrderID != nil {
-		payment.OrderID = *paymentIntent.OrderID
+	if err := s.updateIntentStatus(ctx, &paymentIntent, intent.Status); err != nil {
+		return nil, err
 	}
-	
-	if paymentIntent.OrderID != nil {
-		payment.OrderID = *paymentIntent.OrderID
+
+	if intent.Status != IntentSucceeded {
+		return nil, fmt.Errorf("payment not successful: %s", intent.Status)
 	}
-	
-	if err := s.db.WithContext(ctx).Create(payment).Error; err != nil {
-		return nil, fmt.Errorf("failed to create payment record: %w", err)
+
+	payment, err := s.recordPayment(ctx, &paymentIntent)
+	if err != nil {
+		return nil, err
 	}
-	
+
 	s.logger.Info("Payment confirmed", map[string]interface{}{
 		"payment_id":        payment.ID,
 		"payment_intent_id": paymentIntentID,
-		"stripe_id":         pi.ID,
-		"amount":           payment.Amount,
+		"gateway_ref":       intent.Ref,
+		"amount":            payment.Amount,
 	})
-	
+
 	return payment, nil
 }
 
-// RefundPayment processes a refund
-func (s *Service) RefundPayment(ctx context.Context, paymentID uuid.UUID, amount float64, reason string, processedBy uuid.UUID) (*models.Refund, error) {
-	// Get payment from database
-	var payment models.Payment
-	if err := s.db.WithContext(ctx).First(&payment, "id = ?", paymentID).Error; err != nil {
-		return nil, fmt.Errorf("payment not found: %w", err)
-	}
-	
-	if payment.Status != "completed" {
-		return nil, fmt.Errorf("cannot refund payment with status: %s", payment.Status)
-	}
-	
-	// Convert amount to cents
-	amountCents := int64(amount * 100)
-	
-	// Create refund with Stripe
-	params := &stripe.RefundParams{
-		PaymentIntent: stripe.String(payment.GatewayRef),
-		Amount:       stripe.Int64(amountCents),
-		Reason:       stripe.String(reason),
-		Metadata: map[string]string{
-			"refund_id": uuid.New().String(),
-		},
-	}
-	
-	refundObj, err := refund.New(params)
+// ChargePaymentMethod charges a user's saved payment method off-session, e.g. for a
+// subscription, and returns the completed payment. A declined charge returns an error
+// wrapping ErrPaymentDeclined.
+func (s *Service) ChargePaymentMethod(ctx context.Context, userID uuid.UUID, amount int64, currency, paymentMethodRef string, metadata map[string]string) (*models.Payment, error) {
+	if paymentMethodRef == "" {
+		return nil, fmt.Errorf("%w: no payment method", ErrPaymentDeclined)
+	}
+
+	paymentIntent, err := s.createIntent(ctx, userID, nil, amount, currency, "card", metadata, paymentMethodRef)
 	if err != nil {
-		s.logger.Error("Failed to create Stripe refund", map[string]interface{}{
-			"error":      err.Error(),
-			"payment_id": paymentID,
-			"amount":     amount,
-		})
-		return nil, fmt.Errorf("failed to process refund: %w", err)
-	}
-	
-	// Create refund record
-	refundRecord := &models.Refund{
-		PaymentID:   paymentID,
-		Amount:      amount,
-		Reason:      reason,
-		Status:      string(refundObj.Status),
-		GatewayRef:  refundObj.ID,
-		GatewayType: "stripe",
-		ProcessedBy: processedBy,
-		ProcessedAt: &[]time.Time{time.Now()}[0],
-		Metadata:    s.mapToJSON(refundObj.Metadata),
-	}
-	
-	if err := s.db.WithContext(ctx).Create(refundRecord).Error; err != nil {
-		return nil, fmt.Errorf("failed to save refund record: %w", err)
-	}
-	
-	// Update payment refund amount
-	payment.RefundedAmount += amount
-	s.db.WithContext(ctx).Save(&payment)
-	
-	s.logger.Info("Refund processed", map[string]interface{}{
-		"refund_id":  refundRecord.ID,
-		"payment_id": paymentID,
-		"stripe_id":  refundObj.ID,
-		"amount":     amount,
-	})
-	
-	return refundRecord, nil
+		return nil, err
+	}
+	return s.ConfirmPayment(ctx, paymentIntent.ID, "")
 }
 
-// SavePaymentMethod saves a payment method for a user
-func (s *Service) SavePaymentMethod(ctx context.Context, userID uuid.UUID, paymentMethodID string, isDefault bool) (*models.PaymentMethod, error) {
-	// Get payment method details from Stripe
-	// Note: In a real implementation, you would retrieve payment method details from Stripe API
-	// For now, we'll create a basic record
-	
-	paymentMethod := &models.PaymentMethod{
-		UserID:    userID,
-		Type:      "card",
-		Provider:  "stripe",
-		MethodRef: paymentMethodID,
-		IsDefault: isDefault,
-		IsVerified: true,
-	}
-	
-	// If setting as default, unset other defaults
-	if isDefault {
-		s.db.WithContext(ctx).Model(&models.PaymentMethod{}).
-			Where("user_id = ? AND is_default = ?", userID, true).
-			Update("is_default", false)
-	}
-	
-	if err := s.db.WithContext(ctx).Create(paymentMethod).Error; err != nil {
-		return nil, fmt.Errorf("failed to save payment method: %w", err)
-	}
-	
-	s.logger.Info("Payment method saved", map[string]interface{}{
-		"payment_method_id": paymentMethod.ID,
-		"user_id":          userID,
-		"stripe_id":        paymentMethodID,
-	})
-	
-	return paymentMethod, nil
-}
+// CapturePayment captures an authorized payment intent; amount 0 captures it in full
+func (s *Service) CapturePayment(ctx context.Context, paymentIntentID uuid.UUID, amount int64) (*models.Payment, error) {
+	var paymentIntent models.PaymentIntent
+	if err := s.db.WithContext(ctx).First(&paymentIntent, "id = ?", paymentIntentID).Error; err != nil {
+		return nil, fmt.Errorf("payment intent not found: %w", err)
+	}
 
-// GetUserPaymentMethods gets all payment methods for a user
-func (s *Service) GetUserPaymentMethods(ctx context.Context, userID uuid.UUID) ([]models.PaymentMethod, error) {
-	var paymentMethods []models.PaymentMethod
-	err := s.db.WithContext(ctx).
-		Where("user_id = ? AND is_verified = ?", userID, true).
-		Order("is_default DESC, created_at DESC").
-		Find(&paymentMethods).Error
-	
-	return paymentMethods, err
+	intent, err := s.gateway.CaptureIntent(ctx, paymentIntent.GatewayRef, amount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture payment intent: %w", err)
+	}
+
+	if err := s.updateIntentStatus(ctx, &paymentIntent, intent.Status); err != nil {
+		return nil, err
+	}
+
+	// Partial captures settle only the captured amount
+	paymentIntent.Amount = intent.AmountReceived
+	return s.recordPayment(ctx, &paymentIntent)
 }
 
-// ProcessWebhook processes Stripe webhook events
-func (s *Service) ProcessWebhook(ctx context.Context, payload []byte, signatureHeader string, endpointSecret string) error {
-	event, err := webhook.ConstructEvent(payload, signatureHeader, endpointSecret)
+// CancelPaymentIntent cancels a payment intent that has not been paid
+func (s *Service) CancelPaymentIntent(ctx context.Context, paymentIntentID uuid.UUID) (*models.PaymentIntent, error) {
+	var paymentIntent models.PaymentIntent
+	if err := s.db.WithContext(ctx).First(&paymentIntent, "id = ?", paymentIntentID).Error; err != nil {
+		return nil, err
+	}
+
+	intent, err := s.gateway.CancelIntent(ctx, paymentIntent.GatewayRef)
 	if err != nil {
-		return fmt.Errorf("webhook signature verification failed: %w", err)
+		return nil, fmt.Errorf("failed to cancel payment intent: %w", err)
+	}
+
+	if err := s.updateIntentStatus(ctx, &paymentIntent, intent.Status); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Payment intent canceled", map[string]interface{}{
+		"payment_intent_id": paymentIntent.ID,
+		"gateway_ref":       intent.Ref,
+	})
+
+	if err := s.releaseOrder(ctx, &paymentIntent, "payment_canceled"); err != nil {
+		return nil, err
 	}
-	
+
+	return &paymentIntent, nil
+}
+
+// ParseWebhook verifies a gateway webhook request and decodes its event
+func (s *Service) ParseWebhook(r *http.Request) (*GatewayEvent, error) {
+	return s.gateway.ParseWebhook(r)
+}
+
+// ProcessWebhook applies a verified gateway webhook event
+func (s *Service) ProcessWebhook(ctx context.Context, event *GatewayEvent) error {
 	switch event.Type {
-	case "payment_intent.succeeded":
+	case EventPaymentSucceeded:
 		return s.handlePaymentSucceeded(ctx, event)
-	case "payment_intent.payment_failed":
-		return s.handlePaymentFailed(ctx, event)
-	case "payment_intent.canceled":
-		return s.handlePaymentCanceled(ctx, event)
-	case "charge.dispute.created":
-		return s.handleDisputeCreated(ctx, event)
+	case EventPaymentFailed, EventPaymentCanceled:
+		return s.handlePaymentStatus(ctx, event)
+	case EventDisputeCreated, EventDisputeUpdated, EventDisputeClosed:
+		return s.handleDisputeEvent(ctx, event)
+	case EventRefundUpdated:
+		return s.handleRefundUpdated(ctx, event)
 	default:
 		s.logger.Info("Unhandled webhook event", map[string]interface{}{
 			"type": event.Type,
 		})
 	}
-	
+
 	return nil
 }
 
 // handlePaymentSucceeded handles payment_intent.succeeded webhook
-func (s *Service) handlePaymentSucceeded(ctx context.Context, event stripe.Event) error {
-	var paymentIntent stripe.PaymentIntent
-	if err := json.Unmarshal(event.Data.Raw, &paymentIntent); err != nil {
-		return fmt.Errorf("failed to parse payment intent: %w", err)
-	}
-	
+func (s *Service) handlePaymentSucceeded(ctx context.Context, event *GatewayEvent) error {
 	// Update payment intent in database
 	var dbPaymentIntent models.PaymentIntent
 	err := s.db.WithContext(ctx).
-		Where("gateway_ref = ?", paymentIntent.ID).
+		Where("gateway_ref = ?", event.IntentRef).
 		First(&dbPaymentIntent).Error
-	
+
 	if err != nil {
-		if err == gorm.ErrRecordNotFound {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
 			// Payment intent not found in our database, might be from external source
 			s.logger.Warn("Payment intent not found in database", map[string]interface{}{
-				"stripe_id": paymentIntent.ID,
+				"gateway_ref": event.IntentRef,
 			})
 			return nil
 		}
 		return fmt.Errorf("failed to find payment intent: %w", err)
 	}
-	
+
 	// Update status
-	dbPaymentIntent.Status = string(paymentIntent.Status)
-	s.db.WithContext(ctx).Save(&dbPaymentIntent)
-	
+	if err := s.updateIntentStatus(ctx, &dbPaymentIntent, event.Status); err != nil {
+		return err
+	}
+
 	// Create payment record if status is succeeded
-	if paymentIntent.Status == stripe.PaymentIntentStatusSucceeded {
-		payment := &models.Payment{
-			UserID:        dbPaymentIntent.UserID,
-			PaymentMethod: "stripe",
-			Amount:        dbPaymentIntent.Amount,
-			Currency:      dbPaymentIntent.Currency,
-			Status:        "completed",
-			TransactionID: paymentIntent.ID,
-			GatewayRef:    paymentIntent.ID,
-			GatewayType:   "stripe",
-			ProcessedAt:   &[]time.Time{time.Now()}[0],
-			Metadata:      dbPaymentIntent.Metadata,
-		}
-		
-		if dbPaymentIntent.OrderID != nil {
-			payment.OrderID = *dbPaymentIntent.OrderID
+	if event.Status == IntentSucceeded {
+		payment, err := s.recordPayment(ctx, &dbPaymentIntent)
+		if err != nil {
+			return err
 		}
-		
-		if err := s.db.WithContext(ctx).Create(payment).Error; err != nil {
-			return fmt.Errorf("failed to create payment record: %w", err)
-		}
-		
+
 		s.logger.Info("Payment completed via webhook", map[string]interface{}{
 			"payment_id":        payment.ID,
 			"payment_intent_id": dbPaymentIntent.ID,
-			"stripe_id":         paymentIntent.ID,
+			"gateway_ref":       event.IntentRef,
 		})
 	}
-	
+
 	return nil
 }
 
-// handlePaymentFailed handles payment_intent.payment_failed webhook
-func (s *Service) handlePaymentFailed(ctx context.Context, event stripe.Event) error {
-	var paymentIntent stripe.PaymentIntent
-	if err := json.Unmarshal(event.Data.Raw, &paymentIntent); err != nil {
-		return fmt.Errorf("failed to parse payment intent: %w", err)
+// handlePaymentStatus handles payment_intent.payment_failed and payment_intent.canceled
+// webhooks. A failed attempt leaves the intent open for the buyer to try another card, so
+// only a cancellation gives the order's stock back; unpaid intents are released when
+// they expire.
+func (s *Service) handlePaymentStatus(ctx context.Context, event *GatewayEvent) error {
+	var dbPaymentIntent models.PaymentIntent
+	err := s.db.WithContext(ctx).
+		Where("gateway_ref = ?", event.IntentRef).
+		First(&dbPaymentIntent).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to find payment intent: %w", err)
 	}
-	
+
 	// Update payment intent in database
-	return s.db.WithContext(ctx).
-		Model(&models.PaymentIntent{}).
-		Where("gateway_ref = ?", paymentIntent.ID).
-		Updates(map[string]interface{}{
-			"status": string(paymentIntent.Status),
-		}).Error
+	if err := s.updateIntentStatus(ctx, &dbPaymentIntent, event.Status); err != nil {
+		return err
+	}
+
+	if event.Type != EventPaymentCanceled {
+		return nil
+	}
+	return s.releaseOrder(ctx, &dbPaymentIntent, "payment_canceled")
 }
 
-// handlePaymentCanceled handles payment_intent.canceled webhook
-func (s *Service) handlePaymentCanceled(ctx context.Context, event stripe.Event) error {
-	var paymentIntent stripe.PaymentIntent
-	if err := json.Unmarshal(event.Data.Raw, &paymentIntent); err != nil {
-		return fmt.Errorf("failed to parse payment intent: %w", err)
+// updateIntentStatus stores a payment intent's gateway status
+func (s *Service) updateIntentStatus(ctx context.Context, paymentIntent *models.PaymentIntent, status string) error {
+	if paymentIntent.Status == status {
+		return nil
 	}
-	
-	// Update payment intent in database
-	return s.db.WithContext(ctx).
-		Model(&models.PaymentIntent{}).
-		Where("gateway_ref = ?", paymentIntent.ID).
-		Updates(map[string]interface{}{
-			"status": string(paymentIntent.Status),
-		}).Error
+	if err := s.db.WithContext(ctx).Model(paymentIntent).Update("status", status).Error; err != nil {
+		return fmt.Errorf("failed to update payment intent status: %w", err)
+	}
+	return nil
 }
 
-// handleDisputeCreated handles charge.dispute.created webhook
-func (s *Service) handleDisputeCreated(ctx context.Context, event stripe.Event) error {
-	// Log the dispute for review
-	s.logger.Warn("Payment dispute created", map[string]interface{}{
-		"event_id": event.ID,
-		"type":     event.Type,
+// recordPayment creates the completed payment for a succeeded intent. Confirmation and the
+// gateway's webhook both report success, so an existing payment is returned instead of
+// creating a second one.
+func (s *Service) recordPayment(ctx context.Context, paymentIntent *models.PaymentIntent) (*models.Payment, error) {
+	var existing models.Payment
+	err := s.db.WithContext(ctx).First(&existing, "transaction_id = ?", paymentIntent.GatewayRef).Error
+	if err == nil {
+		// Settling the order again is a no-op, and completes it if the first attempt failed
+		if err := s.settleOrder(ctx, paymentIntent, &existing); err != nil {
+			return nil, err
+		}
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	payment := &models.Payment{
+		OrderID:       paymentIntent.OrderID,
+		UserID:        paymentIntent.UserID,
+		PaymentMethod: paymentIntent.GatewayType,
+		Amount:        paymentIntent.Amount,
+		Currency:      paymentIntent.Currency,
+		Status:        "completed",
+		TransactionID: paymentIntent.GatewayRef,
+		GatewayRef:    paymentIntent.GatewayRef,
+		GatewayType:   paymentIntent.GatewayType,
+		ProcessedAt:   &[]time.Time{time.Now()}[0],
+		Metadata:      paymentIntent.Metadata,
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(payment).Error; err != nil {
+			return fmt.Errorf("failed to create payment record: %w", err)
+		}
+
+		if s.ledger != nil {
+			if _, err := s.ledger.WithTx(tx).PostPayment(ctx, payment); err != nil {
+				return fmt.Errorf("failed to post payment to ledger: %w", err)
+			}
+		}
+		return nil
 	})
-	
-	// In a real implementation, you would:
-	// 1. Find the associated payment
-	// 2. Update its status to 'disputed'
-	// 3. Notify the merchant/admin
-	// 4. Create a dispute record in the database
-	
-	return nil
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.settleOrder(ctx, paymentIntent, payment); err != nil {
+		return nil, err
+	}
+
+	return payment, nil
 }
 
 // mapToJSON converts a map to JSON string
@@ -411,7 +403,7 @@ func (s *Service) mapToJSON(data map[string]string) string {
 	if data == nil {
 		return "{}"
 	}
-	
+
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		s.logger.Error("Failed to marshal metadata", map[string]interface{}{
@@ -420,7 +412,7 @@ func (s *Service) mapToJSON(data map[string]string) string {
 		})
 		return "{}"
 	}
-	
+
 	return string(jsonData)
 }
 
@@ -439,4 +431,4 @@ func (s *Service) GetPaymentMethods(ctx context.Context) ([]string, error) {
 		"apple_pay",
 		"google_pay",
 	}, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,387 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v76"
+	"github.com/stripe/stripe-go/v76/client"
+	"github.com/stripe/stripe-go/v76/webhook"
+)
+
+// StripeGateway is the PaymentGateway backed by the Stripe API
+type StripeGateway struct {
+	api           *client.API
+	webhookSecret string
+}
+
+// NewStripeGateway creates a Stripe gateway. It uses its own API client rather than the
+// package-level stripe.Key so several gateways can coexist.
+func NewStripeGateway(secretKey, webhookSecret string) *StripeGateway {
+	return &StripeGateway{
+		api:           client.New(secretKey, nil),
+		webhookSecret: webhookSecret,
+	}
+}
+
+// Name returns the gateway identifier
+func (g *StripeGateway) Name() string {
+	return "stripe"
+}
+
+// CreateIntent creates a Stripe payment intent
+func (g *StripeGateway) CreateIntent(ctx context.Context, params IntentParams) (*GatewayIntent, error) {
+	stripeParams := &stripe.PaymentIntentParams{
+		Amount:   stripe.Int64(params.Amount),
+		Currency: stripe.String(strings.ToLower(params.Currency)),
+		AutomaticPaymentMethods: &stripe.PaymentIntentAutomaticPaymentMethodsParams{
+			Enabled: stripe.Bool(true),
+		},
+	}
+	stripeParams.Context = ctx
+	if params.PaymentMethodRef != "" {
+		stripeParams.PaymentMethod = stripe.String(params.PaymentMethodRef)
+	}
+	if params.CustomerRef != "" {
+		stripeParams.Customer = stripe.String(params.CustomerRef)
+	}
+	if params.OffSession {
+		// Charged now without the customer, so there is nobody to follow a redirect
+		stripeParams.OffSession = stripe.Bool(true)
+		stripeParams.Confirm = stripe.Bool(true)
+		stripeParams.AutomaticPaymentMethods.AllowRedirects = stripe.String(string(stripe.PaymentIntentAutomaticPaymentMethodsAllowRedirectsNever))
+	}
+	if params.ManualCapture {
+		stripeParams.CaptureMethod = stripe.String(string(stripe.PaymentIntentCaptureMethodManual))
+	}
+	for k, v := range params.Metadata {
+		stripeParams.AddMetadata(k, v)
+	}
+
+	pi, err := g.api.PaymentIntents.New(stripeParams)
+	if err != nil {
+		return nil, mapStripeError(err)
+	}
+	return stripeIntent(pi), nil
+}
+
+// GetIntent retrieves a Stripe payment intent
+func (g *StripeGateway) GetIntent(ctx context.Context, ref string) (*GatewayIntent, error) {
+	params := &stripe.PaymentIntentParams{}
+	params.Context = ctx
+
+	pi, err := g.api.PaymentIntents.Get(ref, params)
+	if err != nil {
+		return nil, mapStripeError(err)
+	}
+	return stripeIntent(pi), nil
+}
+
+// ConfirmIntent confirms a Stripe payment intent
+func (g *StripeGateway) ConfirmIntent(ctx context.Context, ref, paymentMethodRef string) (*GatewayIntent, error) {
+	params := &stripe.PaymentIntentConfirmParams{}
+	params.Context = ctx
+	if paymentMethodRef != "" {
+		params.PaymentMethod = stripe.String(paymentMethodRef)
+	}
+
+	pi, err := g.api.PaymentIntents.Confirm(ref, params)
+	if err != nil {
+		return nil, mapStripeError(err)
+	}
+	return stripeIntent(pi), nil
+}
+
+// CaptureIntent captures an authorized Stripe payment intent
+func (g *StripeGateway) CaptureIntent(ctx context.Context, ref string, amount int64) (*GatewayIntent, error) {
+	params := &stripe.PaymentIntentCaptureParams{}
+	params.Context = ctx
+	if amount > 0 {
+		params.AmountToCapture = stripe.Int64(amount)
+	}
+
+	pi, err := g.api.PaymentIntents.Capture(ref, params)
+	if err != nil {
+		return nil, mapStripeError(err)
+	}
+	return stripeIntent(pi), nil
+}
+
+// CancelIntent cancels a Stripe payment intent
+func (g *StripeGateway) CancelIntent(ctx context.Context, ref string) (*GatewayIntent, error) {
+	params := &stripe.PaymentIntentCancelParams{}
+	params.Context = ctx
+
+	pi, err := g.api.PaymentIntents.Cancel(ref, params)
+	if err != nil {
+		return nil, mapStripeError(err)
+	}
+	return stripeIntent(pi), nil
+}
+
+// Refund creates a Stripe refund
+func (g *StripeGateway) Refund(ctx context.Context, params RefundParams) (*GatewayRefund, error) {
+	stripeParams := &stripe.RefundParams{
+		PaymentIntent: stripe.String(params.IntentRef),
+		Amount:        stripe.Int64(params.Amount),
+	}
+	stripeParams.Context = ctx
+	if params.Reason != "" {
+		stripeParams.Reason = stripe.String(params.Reason)
+	}
+	for k, v := range params.Metadata {
+		stripeParams.AddMetadata(k, v)
+	}
+	// A refund sent again after a timeout returns the refund Stripe already made
+	if refundID := params.Metadata["refund_id"]; refundID != "" {
+		stripeParams.SetIdempotencyKey("refund:" + refundID)
+	}
+
+	refund, err := g.api.Refunds.New(stripeParams)
+	if err != nil {
+		return nil, mapStripeError(err)
+	}
+	return &GatewayRefund{
+		Ref:      refund.ID,
+		Status:   string(refund.Status),
+		Metadata: refund.Metadata,
+	}, nil
+}
+
+// GetPaymentMethod retrieves a Stripe payment method
+func (g *StripeGateway) GetPaymentMethod(ctx context.Context, ref string) (*GatewayPaymentMethod, error) {
+	params := &stripe.PaymentMethodParams{}
+	params.Context = ctx
+
+	pm, err := g.api.PaymentMethods.Get(ref, params)
+	if err != nil {
+		return nil, mapStripeError(err)
+	}
+
+	method := &GatewayPaymentMethod{
+		Ref:  pm.ID,
+		Type: string(pm.Type),
+	}
+	if pm.Card != nil {
+		method.Brand = string(pm.Card.Brand)
+		method.Last4 = pm.Card.Last4
+		method.ExpiryMonth = int(pm.Card.ExpMonth)
+		method.ExpiryYear = int(pm.Card.ExpYear)
+	}
+	return method, nil
+}
+
+// CreateCustomer creates a Stripe customer. The user ID is the idempotency key, so a
+// retried request does not create a second customer.
+func (g *StripeGateway) CreateCustomer(ctx context.Context, params CustomerParams) (string, error) {
+	stripeParams := &stripe.CustomerParams{}
+	stripeParams.Context = ctx
+	stripeParams.SetIdempotencyKey("customer:" + params.UserID)
+	if params.Email != "" {
+		stripeParams.Email = stripe.String(params.Email)
+	}
+	stripeParams.AddMetadata("user_id", params.UserID)
+	for k, v := range params.Metadata {
+		stripeParams.AddMetadata(k, v)
+	}
+
+	customer, err := g.api.Customers.New(stripeParams)
+	if err != nil {
+		return "", mapStripeError(err)
+	}
+	return customer.ID, nil
+}
+
+// AttachPaymentMethod attaches a Stripe payment method to a customer
+func (g *StripeGateway) AttachPaymentMethod(ctx context.Context, ref, customerRef string) error {
+	params := &stripe.PaymentMethodAttachParams{
+		Customer: stripe.String(customerRef),
+	}
+	params.Context = ctx
+
+	if _, err := g.api.PaymentMethods.Attach(ref, params); err != nil {
+		return mapStripeError(err)
+	}
+	return nil
+}
+
+// DetachPaymentMethod detaches a Stripe payment method from its customer
+func (g *StripeGateway) DetachPaymentMethod(ctx context.Context, ref string) error {
+	params := &stripe.PaymentMethodDetachParams{}
+	params.Context = ctx
+
+	if _, err := g.api.PaymentMethods.Detach(ref, params); err != nil {
+		return mapStripeError(err)
+	}
+	return nil
+}
+
+// SubmitDisputeEvidence updates a Stripe dispute's evidence and submits it
+func (g *StripeGateway) SubmitDisputeEvidence(ctx context.Context, ref string, evidence DisputeEvidenceParams) error {
+	params := &stripe.DisputeParams{
+		Evidence: &stripe.DisputeEvidenceParams{},
+		Submit:   stripe.Bool(true),
+	}
+	params.Context = ctx
+	if evidence.TrackingNumber != "" {
+		params.Evidence.ShippingTrackingNumber = stripe.String(evidence.TrackingNumber)
+	}
+	if evidence.Carrier != "" {
+		params.Evidence.ShippingCarrier = stripe.String(evidence.Carrier)
+	}
+	if evidence.Text != "" {
+		params.Evidence.UncategorizedText = stripe.String(evidence.Text)
+	}
+
+	if _, err := g.api.Disputes.Update(ref, params); err != nil {
+		return mapStripeError(err)
+	}
+	return nil
+}
+
+// ListBalanceTransactions lists Stripe balance transactions created between from and to,
+// resolving charges to the payment intents they settled
+func (g *StripeGateway) ListBalanceTransactions(ctx context.Context, from, to time.Time) ([]GatewayBalanceTransaction, error) {
+	params := &stripe.BalanceTransactionListParams{
+		CreatedRange: &stripe.RangeQueryParams{
+			GreaterThanOrEqual: from.Unix(),
+			LesserThan:         to.Unix(),
+		},
+	}
+	params.Context = ctx
+	params.AddExpand("data.source")
+
+	var transactions []GatewayBalanceTransaction
+	iter := g.api.BalanceTransactions.List(params)
+	for iter.Next() {
+		bt := iter.BalanceTransaction()
+		transaction := GatewayBalanceTransaction{
+			Ref:       bt.ID,
+			Kind:      BalanceOther,
+			Amount:    bt.Amount,
+			Fee:       bt.Fee,
+			Currency:  strings.ToUpper(string(bt.Currency)),
+			CreatedAt: time.Unix(bt.Created, 0),
+		}
+		if bt.Source != nil {
+			transaction.SourceRef = bt.Source.ID
+			switch bt.Source.Type {
+			case stripe.BalanceTransactionSourceTypeCharge:
+				transaction.Kind = BalancePayment
+				if bt.Source.Charge != nil && bt.Source.Charge.PaymentIntent != nil {
+					transaction.SourceRef = bt.Source.Charge.PaymentIntent.ID
+				}
+			case stripe.BalanceTransactionSourceTypeRefund:
+				transaction.Kind = BalanceRefund
+			case stripe.BalanceTransactionSourceTypeDispute:
+				transaction.Kind = BalanceDispute
+			}
+		}
+		transactions = append(transactions, transaction)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, mapStripeError(err)
+	}
+	return transactions, nil
+}
+
+// ParseWebhook verifies a Stripe-Signature header and decodes the event
+func (g *StripeGateway) ParseWebhook(r *http.Request) (*GatewayEvent, error) {
+	defer r.Body.Close()
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if g.webhookSecret == "" {
+		return nil, fmt.Errorf("%w: webhook secret not configured", ErrInvalidWebhook)
+	}
+	event, err := webhook.ConstructEvent(payload, r.Header.Get("Stripe-Signature"), g.webhookSecret)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+	}
+
+	gatewayEvent := &GatewayEvent{
+		ID:   event.ID,
+		Type: string(event.Type),
+	}
+
+	switch gatewayEvent.Type {
+	case EventPaymentSucceeded, EventPaymentFailed, EventPaymentCanceled:
+		var pi stripe.PaymentIntent
+		if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+			return nil, fmt.Errorf("failed to parse payment intent: %w", err)
+		}
+		intent := stripeIntent(&pi)
+		gatewayEvent.IntentRef = intent.Ref
+		gatewayEvent.Status = intent.Status
+		gatewayEvent.FailureReason = intent.FailureReason
+	case EventDisputeCreated, EventDisputeUpdated, EventDisputeClosed:
+		var dispute stripe.Dispute
+		if err := json.Unmarshal(event.Data.Raw, &dispute); err != nil {
+			return nil, fmt.Errorf("failed to parse dispute: %w", err)
+		}
+		if dispute.PaymentIntent != nil {
+			gatewayEvent.IntentRef = dispute.PaymentIntent.ID
+		}
+		gatewayEvent.DisputeRef = dispute.ID
+		gatewayEvent.Status = string(dispute.Status)
+		gatewayEvent.Amount = dispute.Amount
+		gatewayEvent.Reason = string(dispute.Reason)
+		if dispute.EvidenceDetails != nil {
+			gatewayEvent.EvidenceDueBy = dispute.EvidenceDetails.DueBy
+		}
+	case EventRefundUpdated:
+		var refund stripe.Refund
+		if err := json.Unmarshal(event.Data.Raw, &refund); err != nil {
+			return nil, fmt.Errorf("failed to parse refund: %w", err)
+		}
+		if refund.PaymentIntent != nil {
+			gatewayEvent.IntentRef = refund.PaymentIntent.ID
+		}
+		gatewayEvent.RefundRef = refund.ID
+		gatewayEvent.Status = string(refund.Status)
+		gatewayEvent.Amount = refund.Amount
+	}
+
+	return gatewayEvent, nil
+}
+
+// stripeIntent converts a Stripe payment intent
+func stripeIntent(pi *stripe.PaymentIntent) *GatewayIntent {
+	intent := &GatewayIntent{
+		Ref:            pi.ID,
+		ClientSecret:   pi.ClientSecret,
+		Status:         string(pi.Status),
+		Amount:         pi.Amount,
+		AmountReceived: pi.AmountReceived,
+	}
+	if pi.LastPaymentError != nil {
+		intent.FailureReason = pi.LastPaymentError.Msg
+	}
+	return intent
+}
+
+// mapStripeError maps Stripe API errors onto the gateway errors
+func mapStripeError(err error) error {
+	var stripeErr *stripe.Error
+	if !errors.As(err, &stripeErr) {
+		return err
+	}
+
+	switch {
+	case stripeErr.Type == stripe.ErrorTypeCard:
+		return fmt.Errorf("%w: %s", ErrPaymentDeclined, stripeErr.Msg)
+	case stripeErr.Code == stripe.ErrorCodeResourceMissing:
+		return fmt.Errorf("%w: %s", ErrGatewayObjectNotFound, stripeErr.Msg)
+	case stripeErr.Code == stripe.ErrorCodePaymentIntentUnexpectedState, stripeErr.Code == stripe.ErrorCodeChargeAlreadyRefunded:
+		return fmt.Errorf("%w: %s", ErrInvalidGatewayState, stripeErr.Msg)
+	}
+	return err
+}
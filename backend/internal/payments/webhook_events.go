@@ -0,0 +1,233 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm/clause"
+)
+
+// Webhook event statuses
+const (
+	WebhookPending    = "pending"
+	WebhookProcessing = "processing"
+	WebhookProcessed  = "processed"
+	WebhookFailed     = "failed"
+)
+
+// MaxWebhookAttempts is how often a failing event is retried before it waits for a replay
+const MaxWebhookAttempts = 8
+
+// webhookRetryBase is the delay before the first retry; it doubles with every attempt
+const webhookRetryBase = 30 * time.Second
+
+// webhookStaleAfter is how long an event may stay in processing before it is assumed to
+// belong to a worker that died
+const webhookStaleAfter = 10 * time.Minute
+
+// ErrWebhookNotReplayable is returned when replaying an event that is not failed
+var ErrWebhookNotReplayable = errors.New("only failed webhook events can be replayed")
+
+// RecordWebhook stores a verified webhook event for processing. Redeliveries of an event
+// already stored return the stored record and true.
+func (s *Service) RecordWebhook(ctx context.Context, event *GatewayEvent) (*models.WebhookEvent, bool, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to encode webhook event: %w", err)
+	}
+
+	now := time.Now()
+	record := models.WebhookEvent{
+		Gateway:       s.gateway.Name(),
+		EventID:       event.ID,
+		Type:          event.Type,
+		Payload:       string(payload),
+		Status:        WebhookPending,
+		NextAttemptAt: &now,
+	}
+	result := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+	if result.Error != nil {
+		return nil, false, fmt.Errorf("failed to store webhook event: %w", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		var existing models.WebhookEvent
+		if err := s.db.WithContext(ctx).
+			First(&existing, "gateway = ? AND event_id = ?", record.Gateway, record.EventID).Error; err != nil {
+			return nil, false, err
+		}
+		s.logger.Info("Duplicate webhook event ignored", map[string]interface{}{
+			"event_id": event.ID,
+			"type":     event.Type,
+			"status":   existing.Status,
+		})
+		return &existing, true, nil
+	}
+
+	s.wakeWebhookWorker()
+	return &record, false, nil
+}
+
+// ProcessPendingWebhooks processes the stored events that are due at now and returns how
+// many were processed successfully
+func (s *Service) ProcessPendingWebhooks(ctx context.Context, now time.Time) (int, error) {
+	var due []models.WebhookEvent
+	err := s.db.WithContext(ctx).
+		Where("(status IN ? AND attempts < ? AND next_attempt_at <= ?) OR (status = ? AND updated_at < ?)",
+			[]string{WebhookPending, WebhookFailed}, MaxWebhookAttempts, now,
+			WebhookProcessing, now.Add(-webhookStaleAfter)).
+		Order("created_at ASC").
+		Limit(100).
+		Find(&due).Error
+	if err != nil {
+		return 0, err
+	}
+
+	processed := 0
+	for i := range due {
+		ok, err := s.processWebhookEvent(ctx, &due[i])
+		if err != nil {
+			return processed, err
+		}
+		if ok {
+			processed++
+		}
+	}
+	return processed, nil
+}
+
+// RunWebhookWorker processes stored webhook events as they arrive and retries failures
+// every interval until ctx is cancelled
+func (s *Service) RunWebhookWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.ProcessPendingWebhooks(ctx, time.Now()); err != nil {
+			s.logger.Error("Failed to process webhook events", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.webhookWake:
+		}
+	}
+}
+
+// ListWebhookEvents lists stored webhook events, newest first
+func (s *Service) ListWebhookEvents(ctx context.Context, status string, page, limit int) ([]models.WebhookEvent, int64, error) {
+	query := s.db.WithContext(ctx).Model(&models.WebhookEvent{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var events []models.WebhookEvent
+	err := query.Order("created_at DESC").Limit(limit).Offset((page - 1) * limit).Find(&events).Error
+	return events, total, err
+}
+
+// ReplayWebhookEvent processes a failed event again immediately
+func (s *Service) ReplayWebhookEvent(ctx context.Context, id uuid.UUID) (*models.WebhookEvent, error) {
+	var record models.WebhookEvent
+	if err := s.db.WithContext(ctx).First(&record, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	if record.Status != WebhookFailed {
+		return nil, ErrWebhookNotReplayable
+	}
+
+	if _, err := s.processWebhookEvent(ctx, &record); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Webhook event replayed", map[string]interface{}{
+		"webhook_event_id": record.ID,
+		"event_id":         record.EventID,
+		"status":           record.Status,
+	})
+
+	return &record, nil
+}
+
+// processWebhookEvent claims and processes one stored event. It reports whether the event
+// was processed; a handler failure is recorded on the event and is not returned.
+func (s *Service) processWebhookEvent(ctx context.Context, record *models.WebhookEvent) (bool, error) {
+	// Claim the event so concurrent workers do not process it twice
+	claim := s.db.WithContext(ctx).
+		Model(&models.WebhookEvent{}).
+		Where("id = ? AND status = ? AND attempts = ?", record.ID, record.Status, record.Attempts).
+		Updates(map[string]interface{}{
+			"status":   WebhookProcessing,
+			"attempts": record.Attempts + 1,
+		})
+	if claim.Error != nil {
+		return false, claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		return false, nil
+	}
+	record.Attempts++
+
+	var event GatewayEvent
+	handlerErr := json.Unmarshal([]byte(record.Payload), &event)
+	if handlerErr == nil {
+		handlerErr = s.ProcessWebhook(ctx, &event)
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{}
+	if handlerErr == nil {
+		record.Status = WebhookProcessed
+		record.LastError = nil
+		record.NextAttemptAt = nil
+		record.ProcessedAt = &now
+		updates["processed_at"] = now
+	} else {
+		message := handlerErr.Error()
+		record.Status = WebhookFailed
+		record.LastError = &message
+		record.NextAttemptAt = nil
+		if record.Attempts < MaxWebhookAttempts {
+			next := now.Add(webhookRetryBase << (record.Attempts - 1))
+			record.NextAttemptAt = &next
+		}
+
+		s.logger.Warn("Webhook event processing failed", map[string]interface{}{
+			"webhook_event_id": record.ID,
+			"event_id":         record.EventID,
+			"type":             record.Type,
+			"attempts":         record.Attempts,
+			"error":            message,
+		})
+	}
+	updates["status"] = record.Status
+	updates["last_error"] = record.LastError
+	updates["next_attempt_at"] = record.NextAttemptAt
+
+	if err := s.db.WithContext(ctx).Model(&models.WebhookEvent{}).Where("id = ?", record.ID).Updates(updates).Error; err != nil {
+		return false, err
+	}
+	return handlerErr == nil, nil
+}
+
+// wakeWebhookWorker tells a running worker that a new event is waiting
+func (s *Service) wakeWebhookWorker() {
+	select {
+	case s.webhookWake <- struct{}{}:
+	default:
+	}
+}
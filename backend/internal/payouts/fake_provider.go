@@ -0,0 +1,146 @@
+package payouts
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FakeDestinationFailing is a destination the fake provider accepts payouts for and then
+// fails them, like a closed bank account
+const FakeDestinationFailing = "acct_fake_closed"
+
+// FakeProvider is an in-memory Provider for local development and tests. Payouts are
+// in transit until Settle is called.
+type FakeProvider struct {
+	mutex     sync.Mutex
+	payouts   map[string]*fakePayout
+	transfers map[string]string // transfer references by payout reference
+	failing   bool
+}
+
+type fakePayout struct {
+	payout      ProviderPayout
+	reference   string
+	destination string
+	amount      int64
+}
+
+// NewFakeProvider creates an empty in-memory provider
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{
+		payouts:   make(map[string]*fakePayout),
+		transfers: make(map[string]string),
+	}
+}
+
+// Name returns the provider identifier
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+// Transfer records a transfer; a repeated reference returns the first transfer
+func (p *FakeProvider) Transfer(ctx context.Context, params PayoutParams) (string, error) {
+	if params.Amount <= 0 {
+		return "", fmt.Errorf("amount must be positive")
+	}
+	if !strings.HasPrefix(params.Destination, "acct_") {
+		return "", fmt.Errorf("invalid destination account: %q", params.Destination)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if ref, ok := p.transfers[params.Reference]; ok {
+		return ref, nil
+	}
+	ref := "tr_fake_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	p.transfers[params.Reference] = ref
+	return ref, nil
+}
+
+// FailPayouts makes CreatePayout fail after the transfer, like an outage between the
+// two requests, until it is called with false
+func (p *FakeProvider) FailPayouts(failing bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.failing = failing
+}
+
+// Transfers returns the number of transfers made
+func (p *FakeProvider) Transfers() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.transfers)
+}
+
+// CreatePayout records a payout in transit; a repeated reference returns the first payout
+func (p *FakeProvider) CreatePayout(ctx context.Context, params PayoutParams) (*ProviderPayout, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, ok := p.transfers[params.Reference]; !ok {
+		return nil, fmt.Errorf("no funds transferred for payout %s", params.Reference)
+	}
+	if p.failing {
+		return nil, fmt.Errorf("payout service unavailable")
+	}
+
+	for _, existing := range p.payouts {
+		if existing.reference == params.Reference {
+			payout := existing.payout
+			return &payout, nil
+		}
+	}
+
+	arrival := time.Now().Add(48 * time.Hour)
+	stored := &fakePayout{
+		payout: ProviderPayout{
+			Ref:         "po_fake_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+			Status:      StatusInTransit,
+			ArrivalDate: &arrival,
+		},
+		reference:   params.Reference,
+		destination: params.Destination,
+		amount:      params.Amount,
+	}
+	p.payouts[stored.payout.Ref] = stored
+
+	payout := stored.payout
+	return &payout, nil
+}
+
+// GetPayout returns a payout's current state
+func (p *FakeProvider) GetPayout(ctx context.Context, destination, ref string) (*ProviderPayout, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	stored, ok := p.payouts[ref]
+	if !ok || stored.destination != destination {
+		return nil, fmt.Errorf("%w: %s", ErrPayoutNotFound, ref)
+	}
+	payout := stored.payout
+	return &payout, nil
+}
+
+// Settle completes every payout in transit; those sent to FakeDestinationFailing fail
+func (p *FakeProvider) Settle() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, stored := range p.payouts {
+		if stored.payout.Status != StatusInTransit {
+			continue
+		}
+		if stored.destination == FakeDestinationFailing {
+			stored.payout.Status = StatusFailed
+			stored.payout.FailureReason = "account_closed"
+			continue
+		}
+		stored.payout.Status = StatusPaid
+	}
+}
@@ -0,0 +1,58 @@
+package payouts
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrPayoutNotFound is returned by a provider when the referenced payout does not exist
+var ErrPayoutNotFound = errors.New("provider payout not found")
+
+// Payout statuses, shared by every provider
+const (
+	StatusPending   = "pending"
+	StatusInTransit = "in_transit"
+	StatusPaid      = "paid"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// Payout schedules
+const (
+	ScheduleDaily  = "daily"
+	ScheduleWeekly = "weekly"
+	ScheduleManual = "manual"
+)
+
+// Provider sends payouts to sellers' connected accounts so payouts can be exercised
+// without a Stripe account. Amounts are in the currency's smallest unit.
+type Provider interface {
+	// Name returns the provider identifier stored with payouts, e.g. "stripe" or "fake"
+	Name() string
+	// Transfer moves amount to the destination account and returns the transfer's
+	// reference. Reference identifies the payout so a retried request is not sent twice.
+	Transfer(ctx context.Context, params PayoutParams) (string, error)
+	// CreatePayout pays amount out of the destination account once Transfer moved it
+	// there. A retried request with the same reference returns the first payout.
+	CreatePayout(ctx context.Context, params PayoutParams) (*ProviderPayout, error)
+	// GetPayout returns the current state of a payout sent to destination
+	GetPayout(ctx context.Context, destination, ref string) (*ProviderPayout, error)
+}
+
+// PayoutParams describes a payout to send
+type PayoutParams struct {
+	Reference   string
+	Destination string
+	Amount      int64
+	Currency    string
+	Metadata    map[string]string
+}
+
+// ProviderPayout is a provider's view of a payout
+type ProviderPayout struct {
+	Ref           string
+	Status        string
+	FailureReason string
+	ArrivalDate   *time.Time
+}
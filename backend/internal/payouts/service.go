@@ -0,0 +1,809 @@
+package payouts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/ledger"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/pkg/logging"
+	"github.com/blytz.live.remake/backend/pkg/money"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrPayoutSettingsRequired is returned when a seller has not set up where to be paid
+var ErrPayoutSettingsRequired = errors.New("payout settings are required")
+
+// ErrNothingToPayOut is returned when a seller has no earnings past their holding period
+var ErrNothingToPayOut = errors.New("no earnings available for payout")
+
+// ErrPayoutsOnHold is returned while a dispute is open on one of the seller's orders
+var ErrPayoutsOnHold = errors.New("payouts are on hold while a dispute is open")
+
+// ErrBelowMinimum is returned when available earnings are below the seller's minimum payout
+var ErrBelowMinimum = errors.New("available earnings are below the minimum payout")
+
+// Earning is what a seller earned on one delivered order
+type Earning struct {
+	OrderID     uuid.UUID   `json:"order_id"`
+	Gross       money.Money `json:"gross"`
+	Commission  money.Money `json:"commission"`
+	Refunded    money.Money `json:"refunded"` // refunds and lost disputes the seller bears
+	Net         money.Money `json:"net"`
+	DeliveredAt time.Time   `json:"delivered_at"`
+	AvailableAt time.Time   `json:"available_at"`
+}
+
+// Balance sums a seller's earnings in one currency
+type Balance struct {
+	Currency  string      `json:"currency"`
+	Available money.Money `json:"available"`  // past the holding period, paid out on the next run
+	Held      money.Money `json:"held"`       // delivered but still within the holding period
+	InTransit money.Money `json:"in_transit"` // sent and not yet paid
+	// Refunds and chargebacks on orders already paid out, taken off the next payout.
+	// Included in Available.
+	Adjustments money.Money `json:"adjustments"`
+}
+
+// UpdateSettingsRequest represents a seller's payout settings
+type UpdateSettingsRequest struct {
+	Schedule      string `json:"schedule" binding:"required,oneof=daily weekly manual"`
+	WeeklyAnchor  *int   `json:"weekly_anchor" binding:"omitempty,min=0,max=6"`
+	Destination   string `json:"destination" binding:"required,startswith=acct_"`
+	MinimumAmount int64  `json:"minimum_amount" binding:"omitempty,min=0"`
+}
+
+// Service computes seller earnings and pays them out through a payout provider
+type Service struct {
+	db         *gorm.DB
+	logger     *logging.Logger
+	provider   Provider
+	ledger     *ledger.Service
+	holdPeriod time.Duration
+}
+
+// NewService creates a payout service. Earnings are held for holdPeriod after delivery.
+func NewService(db *gorm.DB, provider Provider, holdPeriod time.Duration) *Service {
+	return &Service{
+		db:         db,
+		logger:     logging.NewLogger(),
+		provider:   provider,
+		holdPeriod: holdPeriod,
+	}
+}
+
+// SetLedger sets the ledger that payouts are posted to
+func (s *Service) SetLedger(ledgerService *ledger.Service) {
+	s.ledger = ledgerService
+}
+
+// GetSettings returns a seller's payout settings
+func (s *Service) GetSettings(ctx context.Context, sellerID uuid.UUID) (*models.PayoutSettings, error) {
+	var settings models.PayoutSettings
+	if err := s.db.WithContext(ctx).First(&settings, "seller_id = ?", sellerID).Error; err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// UpdateSettings creates or replaces a seller's payout settings
+func (s *Service) UpdateSettings(ctx context.Context, sellerID uuid.UUID, req UpdateSettingsRequest) (*models.PayoutSettings, error) {
+	settings, err := s.GetSettings(ctx, sellerID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		settings = &models.PayoutSettings{SellerID: sellerID, WeeklyAnchor: int(time.Monday)}
+	} else if err != nil {
+		return nil, err
+	}
+
+	settings.Schedule = req.Schedule
+	settings.Method = "stripe_connect"
+	settings.Destination = req.Destination
+	settings.MinimumAmount = req.MinimumAmount
+	if req.WeeklyAnchor != nil {
+		settings.WeeklyAnchor = *req.WeeklyAnchor
+	}
+
+	if err := s.db.WithContext(ctx).Save(settings).Error; err != nil {
+		return nil, fmt.Errorf("failed to save payout settings: %w", err)
+	}
+	return settings, nil
+}
+
+// Earnings returns a seller's earnings on delivered orders that have not been paid out,
+// including delivered orders refunded since. Those delivered within the holding period
+// are returned as held.
+func (s *Service) Earnings(ctx context.Context, sellerID uuid.UUID, now time.Time) (available, held []Earning, err error) {
+	var orders []models.Order
+	err = s.db.WithContext(ctx).
+		Where("status IN ? AND delivered_at IS NOT NULL", []string{"delivered", "refunded"}).
+		Where("id IN (?)", s.db.Table("order_items").
+			Select("order_items.order_id").
+			Joins("JOIN products ON products.id = order_items.product_id").
+			Where("products.seller_id = ? AND order_items.deleted_at IS NULL", sellerID)).
+		Where("id NOT IN (?)", s.db.Model(&models.PayoutItem{}).
+			Select("order_id").
+			Where("seller_id = ?", sellerID)).
+		Order("delivered_at ASC").
+		Find(&orders).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range orders {
+		earning, err := s.earning(ctx, &orders[i], sellerID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if earning.AvailableAt.After(now) {
+			held = append(held, *earning)
+		} else {
+			available = append(available, *earning)
+		}
+	}
+	return available, held, nil
+}
+
+// earning computes a seller's part of an order: their share of what was paid, less
+// their share of refunds and the fees charged on their items when the order was paid
+func (s *Service) earning(ctx context.Context, order *models.Order, sellerID uuid.UUID) (*Earning, error) {
+	total := money.New(order.TotalAmount, order.Currency)
+	var payment *models.Payment
+	if order.PaymentID != nil {
+		var found models.Payment
+		if err := s.db.WithContext(ctx).First(&found, "id = ?", *order.PaymentID).Error; err == nil {
+			payment = &found
+			// Gift cards and store credit paid the part of the order the card did not
+			total = money.New(payment.Amount, payment.Currency)
+			total.Amount += order.CreditAmount
+		}
+	}
+
+	gross, err := s.sellerShare(ctx, order.ID, sellerID, total)
+	if err != nil {
+		return nil, err
+	}
+	refunded, err := s.sellerRefunds(ctx, payment, sellerID, gross.Currency)
+	if err != nil {
+		return nil, err
+	}
+	chargedBack, err := s.sellerChargebacks(ctx, payment, order.ID, sellerID, gross.Currency)
+	if err != nil {
+		return nil, err
+	}
+	refunded.Amount += chargedBack.Amount
+
+	var fees []models.OrderFee
+	if err := s.db.WithContext(ctx).Where("order_id = ? AND seller_id = ?", order.ID, sellerID).Find(&fees).Error; err != nil {
+		return nil, err
+	}
+	commission := money.Zero(gross.Currency)
+	for _, fee := range fees {
+		commission.Amount += fee.Amount
+	}
+
+	net := money.New(gross.Amount-refunded.Amount-commission.Amount, gross.Currency)
+
+	return &Earning{
+		OrderID:     order.ID,
+		Gross:       gross,
+		Commission:  commission,
+		Refunded:    refunded,
+		Net:         net,
+		DeliveredAt: *order.DeliveredAt,
+		AvailableAt: order.DeliveredAt.Add(s.holdPeriod),
+	}, nil
+}
+
+// sellerShare returns the seller's part of amount, split the way the ledger splits it
+func (s *Service) sellerShare(ctx context.Context, orderID, sellerID uuid.UUID, amount money.Money) (money.Money, error) {
+	shares, err := ledger.SellerShares(s.db.WithContext(ctx), orderID, amount)
+	if err != nil {
+		return money.Money{}, err
+	}
+	for _, share := range shares {
+		if share.SellerID == sellerID {
+			return share.Amount, nil
+		}
+	}
+	return money.Zero(amount.Currency), nil
+}
+
+// sellerRefunds returns the seller's part of the refunds sent on a payment, split the way
+// the ledger splits them
+func (s *Service) sellerRefunds(ctx context.Context, payment *models.Payment, sellerID uuid.UUID, currency string) (money.Money, error) {
+	refunded := money.Zero(currency)
+	if payment == nil {
+		return refunded, nil
+	}
+
+	var refunds []models.Refund
+	if err := s.db.WithContext(ctx).Preload("Items").
+		Where("payment_id = ? AND status IN ?", payment.ID, []string{"pending", "succeeded"}).
+		Find(&refunds).Error; err != nil {
+		return refunded, err
+	}
+	for i := range refunds {
+		shares, err := ledger.RefundShares(s.db.WithContext(ctx), &refunds[i], payment)
+		if err != nil {
+			return refunded, err
+		}
+		for _, share := range shares {
+			if share.SellerID == sellerID {
+				refunded.Amount += share.Amount.Amount
+			}
+		}
+	}
+	return refunded, nil
+}
+
+// sellerChargebacks returns the seller's part of the disputes lost on a payment that
+// sellers bear
+func (s *Service) sellerChargebacks(ctx context.Context, payment *models.Payment, orderID, sellerID uuid.UUID, currency string) (money.Money, error) {
+	chargedBack := money.Zero(currency)
+	if payment == nil {
+		return chargedBack, nil
+	}
+
+	var disputes []models.Dispute
+	if err := s.db.WithContext(ctx).
+		Where("payment_id = ? AND status = ? AND liability = ? AND resolved_at IS NOT NULL", payment.ID, "lost", ledger.LiabilitySeller).
+		Find(&disputes).Error; err != nil {
+		return chargedBack, err
+	}
+	for _, dispute := range disputes {
+		share, err := s.sellerShare(ctx, orderID, sellerID, money.New(dispute.Amount, dispute.Currency))
+		if err != nil {
+			return chargedBack, err
+		}
+		chargedBack.Amount += share.Amount
+	}
+	return chargedBack, nil
+}
+
+// carriedBalance returns, per currency, what a seller's paid-out orders have lost to
+// refunds and lost disputes since they were paid out, less what later payouts already
+// withheld for them. It is negative while the seller owes it back.
+func (s *Service) carriedBalance(ctx context.Context, sellerID uuid.UUID) (map[string]money.Money, error) {
+	var items []models.PayoutItem
+	err := s.db.WithContext(ctx).
+		Where("seller_id = ?", sellerID).
+		Where("order_id IN (?) OR order_id IN (?)",
+			s.db.Model(&models.Refund{}).Select("order_id").Where("order_id IS NOT NULL"),
+			s.db.Model(&models.Dispute{}).Select("order_id").Where("status = ?", "lost")).
+		Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+
+	carried := make(map[string]money.Money)
+	for _, item := range items {
+		var order models.Order
+		if err := s.db.WithContext(ctx).First(&order, "id = ?", item.OrderID).Error; err != nil {
+			return nil, err
+		}
+		earning, err := s.earning(ctx, &order, sellerID)
+		if err != nil {
+			return nil, err
+		}
+		currency := earning.Net.Currency
+		balance, ok := carried[currency]
+		if !ok {
+			balance = money.Zero(currency)
+		}
+		balance.Amount += earning.Net.Amount - item.Net
+		carried[currency] = balance
+	}
+
+	// A payout that failed before its transfer withheld nothing
+	var payouts []models.Payout
+	if err := s.db.WithContext(ctx).
+		Where("seller_id = ? AND adjustment <> 0", sellerID).
+		Where("NOT (status = ? AND (transfer_ref = '' OR transfer_ref IS NULL))", StatusFailed).
+		Find(&payouts).Error; err != nil {
+		return nil, err
+	}
+	for _, payout := range payouts {
+		balance, ok := carried[payout.Currency]
+		if !ok {
+			balance = money.Zero(payout.Currency)
+		}
+		balance.Amount -= payout.Adjustment
+		carried[payout.Currency] = balance
+	}
+	return carried, nil
+}
+
+// OnHold reports whether a seller's payouts are held by an unresolved dispute on one of
+// their orders
+func (s *Service) OnHold(ctx context.Context, sellerID uuid.UUID) (bool, error) {
+	var count int64
+	err := s.db.WithContext(ctx).Model(&models.Dispute{}).
+		Where("resolved_at IS NULL").
+		Where("order_id IN (?)", s.db.Table("order_items").
+			Select("order_items.order_id").
+			Joins("JOIN products ON products.id = order_items.product_id").
+			Where("products.seller_id = ? AND order_items.deleted_at IS NULL", sellerID)).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// GetBalance sums a seller's available, held and in-transit earnings per currency
+func (s *Service) GetBalance(ctx context.Context, sellerID uuid.UUID, now time.Time) ([]Balance, error) {
+	available, held, err := s.Earnings(ctx, sellerID, now)
+	if err != nil {
+		return nil, err
+	}
+	carried, err := s.carriedBalance(ctx, sellerID)
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make(map[string]*Balance)
+	balance := func(currency string) *Balance {
+		if b, ok := balances[currency]; ok {
+			return b
+		}
+		b := &Balance{Currency: currency, Available: money.Zero(currency), Held: money.Zero(currency), InTransit: money.Zero(currency), Adjustments: money.Zero(currency)}
+		balances[currency] = b
+		return b
+	}
+	for _, earning := range available {
+		b := balance(earning.Net.Currency)
+		b.Available.Amount += earning.Net.Amount
+	}
+	for _, earning := range held {
+		b := balance(earning.Net.Currency)
+		b.Held.Amount += earning.Net.Amount
+	}
+	for currency, adjustment := range carried {
+		if adjustment.Amount == 0 {
+			continue
+		}
+		b := balance(currency)
+		b.Adjustments.Amount += adjustment.Amount
+		b.Available.Amount += adjustment.Amount
+	}
+
+	var inTransit []models.Payout
+	if err := s.db.WithContext(ctx).
+		Where("seller_id = ? AND status IN ?", sellerID, []string{StatusPending, StatusInTransit}).
+		Find(&inTransit).Error; err != nil {
+		return nil, err
+	}
+	for _, payout := range inTransit {
+		amount := money.New(payout.NetAmount, payout.Currency)
+		b := balance(amount.Currency)
+		b.InTransit.Amount += amount.Amount
+	}
+
+	result := make([]Balance, 0, len(balances))
+	for _, b := range balances {
+		result = append(result, *b)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Currency < result[j].Currency })
+	return result, nil
+}
+
+// CreatePayouts pays out a seller's available earnings, one payout per currency. Refunds
+// and chargebacks on orders paid out earlier are taken off; while they exceed the new
+// earnings nothing is paid and the difference is carried to the next run.
+func (s *Service) CreatePayouts(ctx context.Context, sellerID, processedBy uuid.UUID, now time.Time) ([]models.Payout, error) {
+	settings, err := s.GetSettings(ctx, sellerID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPayoutSettingsRequired
+	}
+	if err != nil {
+		return nil, err
+	}
+	onHold, err := s.OnHold(ctx, sellerID)
+	if err != nil {
+		return nil, err
+	}
+	if onHold {
+		return nil, ErrPayoutsOnHold
+	}
+
+	available, _, err := s.Earnings(ctx, sellerID, now)
+	if err != nil {
+		return nil, err
+	}
+	carried, err := s.carriedBalance(ctx, sellerID)
+	if err != nil {
+		return nil, err
+	}
+
+	byCurrency := make(map[string][]Earning)
+	var currencies []string
+	for _, earning := range available {
+		currency := earning.Net.Currency
+		if _, ok := byCurrency[currency]; !ok {
+			currencies = append(currencies, currency)
+		}
+		byCurrency[currency] = append(byCurrency[currency], earning)
+	}
+	sort.Strings(currencies)
+
+	var created []models.Payout
+	belowMinimum := false
+	for _, currency := range currencies {
+		earnings := byCurrency[currency]
+		net := money.Zero(currency)
+		for _, earning := range earnings {
+			net.Amount += earning.Net.Amount
+		}
+		adjustment := money.Zero(currency)
+		if c, ok := carried[currency]; ok {
+			adjustment = c
+		}
+		net.Amount += adjustment.Amount
+		if net.Amount <= 0 {
+			continue
+		}
+		if net.Amount < settings.MinimumAmount {
+			belowMinimum = true
+			continue
+		}
+
+		payout, err := s.createPayout(ctx, settings, processedBy, earnings, adjustment, now)
+		if err != nil {
+			return created, err
+		}
+		created = append(created, *payout)
+	}
+
+	if len(created) == 0 {
+		if belowMinimum {
+			return nil, ErrBelowMinimum
+		}
+		return nil, ErrNothingToPayOut
+	}
+	return created, nil
+}
+
+// createPayout records a payout for earnings in one currency, adjusted by the balance
+// carried from earlier payouts, and sends it to the provider
+func (s *Service) createPayout(ctx context.Context, settings *models.PayoutSettings, processedBy uuid.UUID, earnings []Earning, adjustment money.Money, now time.Time) (*models.Payout, error) {
+	currency := earnings[0].Net.Currency
+	gross, commission, refunded, net := money.Zero(currency), money.Zero(currency), money.Zero(currency), money.Zero(currency)
+	orderIDs := make([]uuid.UUID, len(earnings))
+	for i, earning := range earnings {
+		gross.Amount += earning.Gross.Amount
+		commission.Amount += earning.Commission.Amount
+		refunded.Amount += earning.Refunded.Amount
+		net.Amount += earning.Net.Amount
+		orderIDs[i] = earning.OrderID
+	}
+	net.Amount += adjustment.Amount
+	encodedOrderIDs, _ := json.Marshal(orderIDs)
+
+	payout := &models.Payout{
+		SellerID:       settings.SellerID,
+		Amount:         gross.Amount,
+		Currency:       currency,
+		Status:         StatusPending,
+		GatewayType:    s.provider.Name(),
+		Method:         settings.Method,
+		Destination:    settings.Destination,
+		Fee:            commission.Amount,
+		RefundedAmount: refunded.Amount,
+		Adjustment:     adjustment.Amount,
+		NetAmount:      net.Amount,
+		OrderIDs:       string(encodedOrderIDs),
+		ProcessedBy:    processedBy,
+		ScheduledAt:    &now,
+		Metadata:       "{}",
+	}
+
+	// The unique order and seller index stops two runs paying the same order
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(payout).Error; err != nil {
+			return fmt.Errorf("failed to create payout: %w", err)
+		}
+
+		for _, earning := range earnings {
+			item := models.PayoutItem{
+				PayoutID:   payout.ID,
+				OrderID:    earning.OrderID,
+				SellerID:   settings.SellerID,
+				Gross:      earning.Gross.Amount,
+				Commission: earning.Commission.Amount,
+				Refunded:   earning.Refunded.Amount,
+				Net:        earning.Net.Amount,
+			}
+			if err := tx.Create(&item).Error; err != nil {
+				return fmt.Errorf("failed to claim order %s for payout: %w", earning.OrderID, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The transfer is recorded before the payout is requested: once the funds have left,
+	// the payout counts as sent and its orders are never released to be paid again
+	transferRef, err := s.provider.Transfer(ctx, payoutParams(payout))
+	if err != nil {
+		s.logger.Error("Failed to transfer payout", map[string]interface{}{
+			"payout_id": payout.ID,
+			"seller_id": settings.SellerID,
+			"error":     err.Error(),
+		})
+		if failErr := s.failPayout(ctx, payout, err.Error()); failErr != nil {
+			return nil, failErr
+		}
+		return payout, nil
+	}
+
+	payout.TransferRef = transferRef
+	payout.ProcessedAt = &now
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(payout).Updates(map[string]interface{}{
+			"transfer_ref": payout.TransferRef,
+			"processed_at": payout.ProcessedAt,
+		}).Error; err != nil {
+			return err
+		}
+
+		if s.ledger != nil {
+			if _, err := s.ledger.WithTx(tx).PostPayout(ctx, payout); err != nil {
+				return fmt.Errorf("failed to post payout to ledger: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.sendPayout(ctx, payout); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Payout sent", map[string]interface{}{
+		"payout_id":    payout.ID,
+		"seller_id":    settings.SellerID,
+		"transfer_ref": payout.TransferRef,
+		"gateway_ref":  payout.GatewayRef,
+		"amount":       net.String(),
+		"orders":       len(earnings),
+	})
+
+	return payout, nil
+}
+
+// sendPayout pays a transferred payout out to the seller. A payout the provider does not
+// accept stays pending and is retried by SyncPayouts; its funds are already with the
+// seller's account, so it is never transferred again.
+func (s *Service) sendPayout(ctx context.Context, payout *models.Payout) error {
+	sent, err := s.provider.CreatePayout(ctx, payoutParams(payout))
+	if err != nil {
+		s.logger.Error("Failed to send payout", map[string]interface{}{
+			"payout_id":    payout.ID,
+			"seller_id":    payout.SellerID,
+			"transfer_ref": payout.TransferRef,
+			"error":        err.Error(),
+		})
+		reason := err.Error()
+		payout.FailureReason = &reason
+		return s.db.WithContext(ctx).Model(payout).Update("failure_reason", payout.FailureReason).Error
+	}
+
+	payout.GatewayRef = sent.Ref
+	payout.FailureReason = nil
+	if err := s.db.WithContext(ctx).Model(payout).Updates(map[string]interface{}{
+		"gateway_ref":    payout.GatewayRef,
+		"failure_reason": nil,
+	}).Error; err != nil {
+		return err
+	}
+	return s.applyStatus(ctx, payout, sent)
+}
+
+// payoutParams describes a payout to the provider. The payout ID is the reference for
+// both the transfer and the payout, so retries reuse the same idempotency keys.
+func payoutParams(payout *models.Payout) PayoutParams {
+	return PayoutParams{
+		Reference:   payout.ID.String(),
+		Destination: payout.Destination,
+		Amount:      payout.NetAmount,
+		Currency:    payout.Currency,
+		Metadata: map[string]string{
+			"payout_id": payout.ID.String(),
+			"seller_id": payout.SellerID.String(),
+		},
+	}
+}
+
+// RunScheduledPayouts pays out every seller whose daily or weekly payout is due. Sellers
+// with nothing to pay out are skipped. It returns the number of payouts created.
+func (s *Service) RunScheduledPayouts(ctx context.Context, now time.Time) (int, error) {
+	var due []models.PayoutSettings
+	if err := s.db.WithContext(ctx).
+		Where("schedule IN ?", []string{ScheduleDaily, ScheduleWeekly}).
+		Find(&due).Error; err != nil {
+		return 0, err
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	count := 0
+	for i := range due {
+		settings := &due[i]
+		if settings.LastRunAt != nil && !settings.LastRunAt.Before(today) {
+			continue
+		}
+		if settings.Schedule == ScheduleWeekly && int(now.Weekday()) != settings.WeeklyAnchor {
+			continue
+		}
+
+		created, err := s.CreatePayouts(ctx, settings.SellerID, settings.SellerID, now)
+		if err != nil && !errors.Is(err, ErrNothingToPayOut) && !errors.Is(err, ErrBelowMinimum) && !errors.Is(err, ErrPayoutsOnHold) {
+			s.logger.Error("Scheduled payout failed", map[string]interface{}{
+				"seller_id": settings.SellerID,
+				"error":     err.Error(),
+			})
+			continue
+		}
+		count += len(created)
+
+		if err := s.db.WithContext(ctx).Model(settings).Update("last_run_at", now).Error; err != nil {
+			return count, err
+		}
+	}
+
+	if count > 0 {
+		s.logger.Info("Scheduled payouts created", map[string]interface{}{
+			"count": count,
+		})
+	}
+
+	return count, nil
+}
+
+// SyncPayouts retries transferred payouts the provider did not accept, then fetches the
+// status of payouts in transit from the provider and records any that were paid or
+// failed. It returns the number of payouts updated.
+func (s *Service) SyncPayouts(ctx context.Context) (int, error) {
+	var unsent []models.Payout
+	if err := s.db.WithContext(ctx).
+		Where("status = ? AND transfer_ref <> '' AND (gateway_ref = '' OR gateway_ref IS NULL)", StatusPending).
+		Find(&unsent).Error; err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := range unsent {
+		payout := &unsent[i]
+		if err := s.sendPayout(ctx, payout); err != nil {
+			return count, err
+		}
+		if payout.GatewayRef != "" {
+			count++
+		}
+	}
+
+	var inTransit []models.Payout
+	if err := s.db.WithContext(ctx).
+		Where("status = ? AND gateway_ref <> ''", StatusInTransit).
+		Find(&inTransit).Error; err != nil {
+		return count, err
+	}
+
+	for i := range inTransit {
+		payout := &inTransit[i]
+		sent, err := s.provider.GetPayout(ctx, payout.Destination, payout.GatewayRef)
+		if err != nil {
+			s.logger.Warn("Failed to fetch payout status", map[string]interface{}{
+				"payout_id":   payout.ID,
+				"gateway_ref": payout.GatewayRef,
+				"error":       err.Error(),
+			})
+			continue
+		}
+		if sent.Status == payout.Status {
+			continue
+		}
+
+		if err := s.applyStatus(ctx, payout, sent); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// applyStatus records the provider's status on a payout
+func (s *Service) applyStatus(ctx context.Context, payout *models.Payout, sent *ProviderPayout) error {
+	switch sent.Status {
+	case StatusFailed, StatusCancelled:
+		reason := sent.FailureReason
+		if reason == "" {
+			reason = sent.Status
+		}
+		return s.failPayout(ctx, payout, reason)
+	case StatusPaid:
+		now := time.Now()
+		payout.Status = StatusPaid
+		payout.PaidAt = &now
+		return s.db.WithContext(ctx).Model(payout).Updates(map[string]interface{}{
+			"status":  payout.Status,
+			"paid_at": payout.PaidAt,
+		}).Error
+	default:
+		payout.Status = StatusInTransit
+		return s.db.WithContext(ctx).Model(payout).Update("status", payout.Status).Error
+	}
+}
+
+// failPayout marks a payout failed. A payout whose funds were never transferred releases
+// its orders so the next payout includes them again. Once transferred, the funds stay in
+// the seller's connected account when the payout to their bank fails, so the orders
+// remain paid out and are not transferred a second time.
+func (s *Service) failPayout(ctx context.Context, payout *models.Payout, reason string) error {
+	transferred := payout.TransferRef != ""
+	payout.Status = StatusFailed
+	payout.FailureReason = &reason
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(payout).Updates(map[string]interface{}{
+			"status":         payout.Status,
+			"failure_reason": payout.FailureReason,
+		}).Error; err != nil {
+			return err
+		}
+
+		if transferred {
+			return nil
+		}
+		if err := tx.Unscoped().Where("payout_id = ?", payout.ID).Delete(&models.PayoutItem{}).Error; err != nil {
+			return fmt.Errorf("failed to release payout orders: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.logger.Warn("Payout failed", map[string]interface{}{
+		"payout_id":   payout.ID,
+		"seller_id":   payout.SellerID,
+		"transferred": transferred,
+		"reason":      reason,
+	})
+	return nil
+}
+
+// ListPayouts lists payouts newest first, optionally only a seller's or in a status
+func (s *Service) ListPayouts(ctx context.Context, sellerID *uuid.UUID, status string, page, limit int) ([]models.Payout, int64, error) {
+	query := s.db.WithContext(ctx).Model(&models.Payout{})
+	if sellerID != nil {
+		query = query.Where("seller_id = ?", *sellerID)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var payouts []models.Payout
+	err := query.Order("created_at DESC").Limit(limit).Offset((page - 1) * limit).Find(&payouts).Error
+	return payouts, total, err
+}
+
+// GetPayout returns a payout with the orders it covers
+func (s *Service) GetPayout(ctx context.Context, id uuid.UUID) (*models.Payout, error) {
+	var payout models.Payout
+	if err := s.db.WithContext(ctx).Preload("Items").First(&payout, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &payout, nil
+}
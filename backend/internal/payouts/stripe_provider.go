@@ -0,0 +1,112 @@
+package payouts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v76"
+	"github.com/stripe/stripe-go/v76/client"
+)
+
+// StripeProvider pays sellers through Stripe Connect: funds are transferred to the
+// seller's connected account and then paid out from it to their bank
+type StripeProvider struct {
+	api *client.API
+}
+
+// NewStripeProvider creates a Stripe Connect provider with its own API client
+func NewStripeProvider(secretKey string) *StripeProvider {
+	return &StripeProvider{
+		api: client.New(secretKey, nil),
+	}
+}
+
+// Name returns the provider identifier
+func (p *StripeProvider) Name() string {
+	return "stripe"
+}
+
+// Transfer moves the amount to the connected account. The idempotency key is derived
+// from the reference, so a retry is safe.
+func (p *StripeProvider) Transfer(ctx context.Context, params PayoutParams) (string, error) {
+	transferParams := &stripe.TransferParams{
+		Amount:        stripe.Int64(params.Amount),
+		Currency:      stripe.String(strings.ToLower(params.Currency)),
+		Destination:   stripe.String(params.Destination),
+		TransferGroup: stripe.String(params.Reference),
+		Metadata:      params.Metadata,
+	}
+	transferParams.Context = ctx
+	transferParams.SetIdempotencyKey(params.Reference + ":transfer")
+	transfer, err := p.api.Transfers.New(transferParams)
+	if err != nil {
+		return "", mapStripeError(err)
+	}
+	return transfer.ID, nil
+}
+
+// CreatePayout pays the transferred amount out of the connected account to the seller's
+// bank. The idempotency key is derived from the reference, so a retry is safe.
+func (p *StripeProvider) CreatePayout(ctx context.Context, params PayoutParams) (*ProviderPayout, error) {
+	payoutParams := &stripe.PayoutParams{
+		Amount:   stripe.Int64(params.Amount),
+		Currency: stripe.String(strings.ToLower(params.Currency)),
+		Metadata: params.Metadata,
+	}
+	payoutParams.Context = ctx
+	payoutParams.SetStripeAccount(params.Destination)
+	payoutParams.SetIdempotencyKey(params.Reference + ":payout")
+	po, err := p.api.Payouts.New(payoutParams)
+	if err != nil {
+		return nil, mapStripeError(err)
+	}
+	return stripePayout(po), nil
+}
+
+// GetPayout retrieves a payout from the connected account
+func (p *StripeProvider) GetPayout(ctx context.Context, destination, ref string) (*ProviderPayout, error) {
+	params := &stripe.PayoutParams{}
+	params.Context = ctx
+	params.SetStripeAccount(destination)
+
+	po, err := p.api.Payouts.Get(ref, params)
+	if err != nil {
+		return nil, mapStripeError(err)
+	}
+	return stripePayout(po), nil
+}
+
+// stripePayout maps a Stripe payout onto the provider payout; Stripe's "canceled" and
+// "pending" become our own spelling
+func stripePayout(po *stripe.Payout) *ProviderPayout {
+	status := string(po.Status)
+	switch po.Status {
+	case stripe.PayoutStatusCanceled:
+		status = StatusCancelled
+	case stripe.PayoutStatusPending:
+		status = StatusInTransit
+	}
+
+	payout := &ProviderPayout{
+		Ref:           po.ID,
+		Status:        status,
+		FailureReason: po.FailureMessage,
+	}
+	if po.ArrivalDate > 0 {
+		arrival := time.Unix(po.ArrivalDate, 0)
+		payout.ArrivalDate = &arrival
+	}
+	return payout
+}
+
+// mapStripeError maps Stripe API errors onto the provider errors
+func mapStripeError(err error) error {
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) && stripeErr.Code == stripe.ErrorCodeResourceMissing {
+		return fmt.Errorf("%w: %s", ErrPayoutNotFound, stripeErr.Msg)
+	}
+	return err
+}
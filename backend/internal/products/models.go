@@ -12,9 +12,10 @@ type ProductCreateRequest struct {
 	Title         string     `json:"title" binding:"required,min=3,max=200"`
 	Description   *string    `json:"description,omitempty"`
 	Condition     *string    `json:"condition,omitempty" binding:"omitempty,oneof=new like_new good fair"`
-	StartingPrice float64    `json:"starting_price" binding:"required,gt=0"`
-	ReservePrice  *float64   `json:"reserve_price,omitempty" binding:"omitempty,gt=0"`
-	BuyNowPrice   *float64   `json:"buy_now_price,omitempty" binding:"omitempty,gt=0"`
+	StartingPrice int64      `json:"starting_price" binding:"required,gt=0"`
+	ReservePrice  *int64     `json:"reserve_price,omitempty" binding:"omitempty,gt=0"`
+	BuyNowPrice   *int64     `json:"buy_now_price,omitempty" binding:"omitempty,gt=0"`
+	Currency      string     `json:"currency,omitempty" binding:"omitempty,len=3"` // ISO 4217, defaults to USD
 	Images        []string   `json:"images,omitempty"`
 	VideoURL      *string    `json:"video_url,omitempty"`
 	Specifications map[string]interface{} `json:"specifications,omitempty"`
@@ -27,9 +28,10 @@ type ProductUpdateRequest struct {
 	Title         *string     `json:"title,omitempty" binding:"omitempty,min=3,max=200"`
 	Description   *string    `json:"description,omitempty"`
 	Condition     *string    `json:"condition,omitempty" binding:"omitempty,oneof=new like_new good fair"`
-	StartingPrice *float64   `json:"starting_price,omitempty" binding:"omitempty,gt=0"`
-	ReservePrice  *float64   `json:"reserve_price,omitempty" binding:"omitempty,gt=0"`
-	BuyNowPrice   *float64   `json:"buy_now_price,omitempty" binding:"omitempty,gt=0"`
+	StartingPrice *int64     `json:"starting_price,omitempty" binding:"omitempty,gt=0"`
+	ReservePrice  *int64     `json:"reserve_price,omitempty" binding:"omitempty,gt=0"`
+	BuyNowPrice   *int64     `json:"buy_now_price,omitempty" binding:"omitempty,gt=0"`
+	Currency      *string    `json:"currency,omitempty" binding:"omitempty,len=3"`
 	Images        []string   `json:"images,omitempty"`
 	VideoURL      *string    `json:"video_url,omitempty"`
 	Specifications map[string]interface{} `json:"specifications,omitempty"`
@@ -48,11 +50,13 @@ type ProductResponse struct {
 	Title         string                     `json:"title"`
 	Description   *string                    `json:"description"`
 	Condition     *string                    `json:"condition"`
-	StartingPrice float64                    `json:"starting_price"`
-	ReservePrice  *float64                   `json:"reserve_price"`
-	BuyNowPrice   *float64                   `json:"buy_now_price"`
+	StartingPrice int64                      `json:"starting_price"`
+	ReservePrice  *int64                     `json:"reserve_price"`
+	BuyNowPrice   *int64                     `json:"buy_now_price"`
+	Currency      string                     `json:"currency"`
 	Images        []string                   `json:"images"`
 	VideoURL      *string                    `json:"video_url"`
+	VideoCandidates []VideoCandidate         `json:"video_candidates,omitempty"`
 	Specifications map[string]interface{}    `json:"specifications"`
 	ShippingInfo  map[string]interface{}     `json:"shipping_info"`
 	Status        string                     `json:"status"`
@@ -62,14 +66,24 @@ type ProductResponse struct {
 	UpdatedAt     time.Time                  `json:"updated_at"`
 }
 
+// VideoCandidate is a video that can be shown on the product page: the product's own
+// video or a highlight clip from an auction of the product
+type VideoCandidate struct {
+	URL      string     `json:"url"`
+	Source   string     `json:"source"` // product, clip
+	ClipID   *uuid.UUID `json:"clip_id,omitempty"`
+	Title    string     `json:"title,omitempty"`
+	Duration float64    `json:"duration,omitempty"` // in seconds
+}
+
 // ProductListRequest represents product list query parameters
 type ProductListRequest struct {
 	CategoryID    string    `form:"category_id"`
 	SellerID      string    `form:"seller_id"`
 	Status        string    `form:"status" binding:"omitempty,oneof=draft active sold cancelled"`
 	Condition     string    `form:"condition" binding:"omitempty,oneof=new like_new good fair"`
-	MinPrice      float64   `form:"min_price" binding:"omitempty,gte=0"`
-	MaxPrice      float64   `form:"max_price" binding:"omitempty,gte=0"`
+	MinPrice      int64     `form:"min_price" binding:"omitempty,gte=0"`
+	MaxPrice      int64     `form:"max_price" binding:"omitempty,gte=0"`
 	Featured      bool      `form:"featured"`
 	SortBy        string    `form:"sort_by" binding:"omitempty,oneof=created_at updated_at title starting_price view_count"`
 	SortDirection string    `form:"sort_direction" binding:"omitempty,oneof=asc desc"`
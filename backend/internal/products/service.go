@@ -7,6 +7,7 @@ import (
 
 	"github.com/blytz.live.remake/backend/internal/common"
 	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/pkg/money"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
@@ -31,6 +32,11 @@ func (s *Service) CreateProduct(sellerID uuid.UUID, req ProductCreateRequest) (*
 		return nil, fmt.Errorf("category not found: %w", err)
 	}
 
+	currency := money.NormalizeCurrency(req.Currency)
+	if err := validatePrices(currency, &req.StartingPrice, req.ReservePrice, req.BuyNowPrice); err != nil {
+		return nil, err
+	}
+
 	// Validate price relationships
 	if req.ReservePrice != nil && *req.ReservePrice < req.StartingPrice {
 		return nil, fmt.Errorf("reserve price cannot be lower than starting price")
@@ -79,6 +85,7 @@ func (s *Service) CreateProduct(sellerID uuid.UUID, req ProductCreateRequest) (*
 		StartingPrice: req.StartingPrice,
 		ReservePrice:  req.ReservePrice,
 		BuyNowPrice:   req.BuyNowPrice,
+		Currency:      currency,
 		VideoURL:      req.VideoURL,
 		Status:        "draft", // Default status
 		Featured:      false,
@@ -122,7 +129,40 @@ func (s *Service) GetProductByID(productID, requestorID uuid.UUID) (*ProductResp
 		product.ViewCount++
 	}
 
-	return s.toProductResponse(&product, true, true), nil
+	resp := s.toProductResponse(&product, true, true)
+	resp.VideoCandidates = s.videoCandidates(&product)
+	return resp, nil
+}
+
+// videoCandidates lists the product video followed by published auction highlight clips
+func (s *Service) videoCandidates(product *models.Product) []VideoCandidate {
+	var candidates []VideoCandidate
+	if product.VideoURL != nil && *product.VideoURL != "" {
+		candidates = append(candidates, VideoCandidate{URL: *product.VideoURL, Source: "product"})
+	}
+
+	var clips []models.HighlightClip
+	if err := s.db.Where("product_id = ? AND is_published = ?", product.ID, true).
+		Order("created_at DESC").
+		Limit(10).
+		Find(&clips).Error; err != nil {
+		return candidates
+	}
+
+	for i := range clips {
+		// A featured clip is already the product video
+		if product.VideoURL != nil && *product.VideoURL == clips[i].PlaybackURL {
+			continue
+		}
+		candidates = append(candidates, VideoCandidate{
+			URL:      clips[i].PlaybackURL,
+			Source:   "clip",
+			ClipID:   &clips[i].ID,
+			Title:    clips[i].Title,
+			Duration: clips[i].Duration,
+		})
+	}
+	return candidates
 }
 
 // UpdateProduct updates an existing product
@@ -178,6 +218,21 @@ func (s *Service) UpdateProduct(productID, sellerID uuid.UUID, req ProductUpdate
 		updates["buy_now_price"] = *req.BuyNowPrice
 		product.BuyNowPrice = req.BuyNowPrice
 	}
+	if req.Currency != nil {
+		currency := money.NormalizeCurrency(*req.Currency)
+		if currency != product.Currency {
+			// Carts hold items in a single currency, so the product must leave them first
+			var inCarts int64
+			if err := s.db.Model(&models.CartItem{}).Where("product_id = ?", productID).Count(&inCarts).Error; err != nil {
+				return nil, err
+			}
+			if inCarts > 0 {
+				return nil, fmt.Errorf("cannot change the currency of a product that is in shopping carts")
+			}
+		}
+		updates["currency"] = currency
+		product.Currency = currency
+	}
 	if req.Status != nil {
 		updates["status"] = *req.Status
 		product.Status = *req.Status
@@ -224,6 +279,10 @@ func (s *Service) UpdateProduct(productID, sellerID uuid.UUID, req ProductUpdate
 		product.ShippingInfo = &shippingStr
 	}
 
+	if err := validatePrices(product.Currency, &product.StartingPrice, product.ReservePrice, product.BuyNowPrice); err != nil {
+		return nil, err
+	}
+
 	// Validate price relationships if they were updated
 	if req.StartingPrice != nil || req.ReservePrice != nil {
 		startPrice := product.StartingPrice
@@ -422,6 +481,7 @@ func (s *Service) toProductResponse(product *models.Product, includeSeller bool,
 		StartingPrice: product.StartingPrice,
 		ReservePrice:  product.ReservePrice,
 		BuyNowPrice:   product.BuyNowPrice,
+		Currency:      product.Currency,
 		VideoURL:      product.VideoURL,
 		Status:        product.Status,
 		Featured:      product.Featured,
@@ -537,4 +597,18 @@ func (s *Service) GetHotProducts() ([]ProductResponse, error) {
 	}
 
 	return responses, nil
-}
\ No newline at end of file
+}
+
+// validatePrices checks that the currency is supported and that no price, in the
+// currency's minor units, is negative
+func validatePrices(currency string, prices ...*int64) error {
+	if !money.IsSupported(currency) {
+		return fmt.Errorf("%w: %s", money.ErrUnknownCurrency, currency)
+	}
+	for _, price := range prices {
+		if price != nil && money.New(*price, currency).IsNegative() {
+			return fmt.Errorf("%w: %s is negative", money.ErrInvalidAmount, money.New(*price, currency))
+		}
+	}
+	return nil
+}
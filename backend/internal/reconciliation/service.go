@@ -0,0 +1,341 @@
+package reconciliation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/internal/payments"
+	"github.com/blytz.live.remake/backend/pkg/logging"
+	"github.com/blytz.live.remake/backend/pkg/money"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Run statuses
+const (
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+// Discrepancies a run reports
+const (
+	ProblemMissing    = "missing"    // recorded locally but not settled by the gateway
+	ProblemMismatched = "mismatched" // settled for a different amount or currency
+	ProblemOrphaned   = "orphaned"   // settled by the gateway but not recorded locally
+)
+
+// SettlementWindow is how far outside a run's period gateway transactions are still
+// matched, since the gateway may settle a record a little before or after it is stored
+const SettlementWindow = 24 * time.Hour
+
+// ErrInvalidPeriod is returned for a period that does not end after it starts
+var ErrInvalidPeriod = errors.New("reconciliation period must end after it starts")
+
+// Service reconciles local payment records with the gateway's balance transactions
+type Service struct {
+	db      *gorm.DB
+	logger  *logging.Logger
+	gateway payments.PaymentGateway
+}
+
+// NewService creates a new reconciliation service for the gateway
+func NewService(db *gorm.DB, gateway payments.PaymentGateway) *Service {
+	return &Service{
+		db:      db,
+		logger:  logging.NewLogger(),
+		gateway: gateway,
+	}
+}
+
+// settled sums the gateway transactions of one source object
+type settled struct {
+	kind      string
+	sourceRef string
+	amount    int64
+	currency  string
+	createdAt time.Time
+	matched   bool
+}
+
+// local is a payment, refund or dispute as the gateway should have settled it
+type local struct {
+	kind       string
+	id         uuid.UUID
+	gatewayRef string
+	amount     money.Money // signed like the gateway: refunds and disputes are negative
+}
+
+// Reconcile matches the payments, refunds and disputes recorded between from and to with
+// the gateway's balance transactions by gateway reference, and stores a run listing
+// every missing, mismatched and orphaned record
+func (s *Service) Reconcile(ctx context.Context, from, to time.Time) (*models.ReconciliationRun, error) {
+	if !to.After(from) {
+		return nil, ErrInvalidPeriod
+	}
+
+	run := &models.ReconciliationRun{
+		Gateway:     s.gateway.Name(),
+		PeriodStart: from,
+		PeriodEnd:   to,
+		Status:      StatusCompleted,
+	}
+
+	if err := s.reconcile(ctx, run); err != nil {
+		message := err.Error()
+		run.Status = StatusFailed
+		run.Error = &message
+		run.Items = nil
+		s.logger.Error("Reconciliation failed", map[string]interface{}{
+			"gateway": run.Gateway,
+			"from":    from,
+			"to":      to,
+			"error":   message,
+		})
+	}
+
+	if err := s.db.WithContext(ctx).Create(run).Error; err != nil {
+		return nil, fmt.Errorf("failed to save reconciliation run: %w", err)
+	}
+
+	s.logger.Info("Reconciliation completed", map[string]interface{}{
+		"run_id":     run.ID,
+		"gateway":    run.Gateway,
+		"status":     run.Status,
+		"matched":    run.Matched,
+		"missing":    run.Missing,
+		"mismatched": run.Mismatched,
+		"orphaned":   run.Orphaned,
+	})
+	return run, nil
+}
+
+func (s *Service) reconcile(ctx context.Context, run *models.ReconciliationRun) error {
+	transactions, err := s.gateway.ListBalanceTransactions(ctx, run.PeriodStart.Add(-SettlementWindow), run.PeriodEnd.Add(SettlementWindow))
+	if err != nil {
+		return fmt.Errorf("failed to list gateway balance transactions: %w", err)
+	}
+
+	// A refund or dispute can move funds more than once, e.g. a dispute withdrawn and
+	// later reinstated, so transactions are compared per source
+	bySource := make(map[string]*settled)
+	var sources []*settled
+	for _, transaction := range transactions {
+		if transaction.Kind == payments.BalanceOther || transaction.SourceRef == "" {
+			continue
+		}
+		key := transaction.Kind + ":" + transaction.SourceRef
+		entry, ok := bySource[key]
+		if !ok {
+			entry = &settled{kind: transaction.Kind, sourceRef: transaction.SourceRef, currency: transaction.Currency, createdAt: transaction.CreatedAt}
+			bySource[key] = entry
+			sources = append(sources, entry)
+		}
+		entry.amount += transaction.Amount
+		if transaction.CreatedAt.Before(entry.createdAt) {
+			entry.createdAt = transaction.CreatedAt
+		}
+	}
+
+	records, err := s.localRecords(ctx, run.PeriodStart, run.PeriodEnd)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		id := record.id
+		localAmount := record.amount.Amount
+		entry, ok := bySource[record.kind+":"+record.gatewayRef]
+		if !ok {
+			run.Missing++
+			run.Items = append(run.Items, models.ReconciliationItem{
+				Kind:        record.kind,
+				Problem:     ProblemMissing,
+				GatewayRef:  record.gatewayRef,
+				LocalID:     &id,
+				LocalAmount: &localAmount,
+				Currency:    record.amount.Currency,
+				Detail:      fmt.Sprintf("%s %s of %s not settled by the gateway", record.kind, record.gatewayRef, record.amount),
+			})
+			continue
+		}
+		entry.matched = true
+
+		gatewayAmount := money.New(entry.amount, entry.currency)
+		if gatewayAmount.Currency != record.amount.Currency || gatewayAmount.Amount != record.amount.Amount {
+			settledAmount := gatewayAmount.Amount
+			run.Mismatched++
+			run.Items = append(run.Items, models.ReconciliationItem{
+				Kind:          record.kind,
+				Problem:       ProblemMismatched,
+				GatewayRef:    record.gatewayRef,
+				LocalID:       &id,
+				LocalAmount:   &localAmount,
+				GatewayAmount: &settledAmount,
+				Currency:      record.amount.Currency,
+				Detail:        fmt.Sprintf("%s %s recorded as %s but settled as %s", record.kind, record.gatewayRef, record.amount, gatewayAmount),
+			})
+			continue
+		}
+		run.Matched++
+	}
+
+	for _, entry := range sources {
+		if entry.matched || entry.createdAt.Before(run.PeriodStart) || !entry.createdAt.Before(run.PeriodEnd) {
+			continue
+		}
+
+		// Records stored outside the period are matched by the run covering them
+		exists, err := s.recorded(ctx, entry.kind, entry.sourceRef)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		settledAmount := entry.amount
+		run.Orphaned++
+		run.Items = append(run.Items, models.ReconciliationItem{
+			Kind:          entry.kind,
+			Problem:       ProblemOrphaned,
+			GatewayRef:    entry.sourceRef,
+			GatewayAmount: &settledAmount,
+			Currency:      entry.currency,
+			Detail:        fmt.Sprintf("%s %s of %s settled by the gateway but not recorded", entry.kind, entry.sourceRef, money.New(entry.amount, entry.currency)),
+		})
+	}
+	return nil
+}
+
+// localRecords loads the payments, refunds and disputes recorded at the gateway during
+// the period
+func (s *Service) localRecords(ctx context.Context, from, to time.Time) ([]local, error) {
+	gateway := s.gateway.Name()
+	var records []local
+
+	var paid []models.Payment
+	if err := s.db.WithContext(ctx).
+		Where("gateway_type = ? AND processed_at >= ? AND processed_at < ?", gateway, from, to).
+		Where("status NOT IN ?", []string{"pending", "processing", "failed", "cancelled"}).
+		Order("processed_at ASC").
+		Find(&paid).Error; err != nil {
+		return nil, fmt.Errorf("failed to load payments: %w", err)
+	}
+	for _, payment := range paid {
+		records = append(records, local{
+			kind:       payments.BalancePayment,
+			id:         payment.ID,
+			gatewayRef: payment.GatewayRef,
+			amount:     money.New(payment.Amount, payment.Currency),
+		})
+	}
+
+	var refunds []models.Refund
+	if err := s.db.WithContext(ctx).Preload("Payment").
+		Where("gateway_type = ? AND processed_at >= ? AND processed_at < ?", gateway, from, to).
+		Where("status IN ?", []string{payments.RefundPending, payments.RefundSucceeded}).
+		// Refunds to store credit never reach the gateway
+		Where("method <> ?", payments.RefundMethodStoreCredit).
+		Order("processed_at ASC").
+		Find(&refunds).Error; err != nil {
+		return nil, fmt.Errorf("failed to load refunds: %w", err)
+	}
+	for _, refund := range refunds {
+		amount := money.New(refund.Amount, refund.Payment.Currency)
+		records = append(records, local{
+			kind:       payments.BalanceRefund,
+			id:         refund.ID,
+			gatewayRef: refund.GatewayRef,
+			amount:     money.New(-amount.Amount, amount.Currency),
+		})
+	}
+
+	var disputes []models.Dispute
+	if err := s.db.WithContext(ctx).
+		Where("gateway_type = ? AND created_at >= ? AND created_at < ?", gateway, from, to).
+		Order("created_at ASC").
+		Find(&disputes).Error; err != nil {
+		return nil, fmt.Errorf("failed to load disputes: %w", err)
+	}
+	for _, dispute := range disputes {
+		// A won dispute's funds are withdrawn and then reinstated
+		amount := money.New(dispute.Amount, dispute.Currency)
+		if dispute.Status == payments.DisputeWon {
+			amount = money.Zero(dispute.Currency)
+		}
+		records = append(records, local{
+			kind:       payments.BalanceDispute,
+			id:         dispute.ID,
+			gatewayRef: dispute.GatewayRef,
+			amount:     money.New(-amount.Amount, amount.Currency),
+		})
+	}
+
+	return records, nil
+}
+
+// recorded reports whether a local record exists for a gateway source at any date
+func (s *Service) recorded(ctx context.Context, kind, gatewayRef string) (bool, error) {
+	var model interface{}
+	switch kind {
+	case payments.BalancePayment:
+		model = &models.Payment{}
+	case payments.BalanceRefund:
+		model = &models.Refund{}
+	case payments.BalanceDispute:
+		model = &models.Dispute{}
+	default:
+		return false, nil
+	}
+
+	var count int64
+	err := s.db.WithContext(ctx).Model(model).Where("gateway_ref = ?", gatewayRef).Count(&count).Error
+	return count > 0, err
+}
+
+// RunDaily reconciles the previous day, unless it has been reconciled already
+func (s *Service) RunDaily(ctx context.Context, now time.Time) (*models.ReconciliationRun, error) {
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	from := to.AddDate(0, 0, -1)
+
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&models.ReconciliationRun{}).
+		Where("gateway = ? AND period_start = ? AND period_end = ? AND status = ?", s.gateway.Name(), from, to, StatusCompleted).
+		Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, nil
+	}
+	return s.Reconcile(ctx, from, to)
+}
+
+// GetRun retrieves a reconciliation run with its discrepancies
+func (s *Service) GetRun(ctx context.Context, id uuid.UUID) (*models.ReconciliationRun, error) {
+	var run models.ReconciliationRun
+	if err := s.db.WithContext(ctx).
+		Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("problem ASC, kind ASC") }).
+		First(&run, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// ListRuns lists reconciliation runs, most recent period first
+func (s *Service) ListRuns(ctx context.Context, page, limit int) ([]models.ReconciliationRun, int64, error) {
+	var total int64
+	if err := s.db.WithContext(ctx).Model(&models.ReconciliationRun{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var runs []models.ReconciliationRun
+	err := s.db.WithContext(ctx).
+		Order("period_start DESC, created_at DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&runs).Error
+	return runs, total, err
+}
@@ -0,0 +1,201 @@
+package subscriptions
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Handler provides subscription HTTP handlers
+type Handler struct {
+	service *Service
+}
+
+// NewHandler creates a new subscription handler
+func NewHandler(service *Service) *Handler {
+	return &Handler{
+		service: service,
+	}
+}
+
+// ListPlans lists the plans sellers can subscribe to
+func (h *Handler) ListPlans(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"plans": Plans()})
+}
+
+// GetMySubscription returns the current seller's plan and subscription
+func (h *Handler) GetMySubscription(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	entitlements, err := h.service.Entitlements(c.Request.Context(), userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, entitlements)
+}
+
+// Subscribe subscribes the current seller to a paid plan
+func (h *Handler) Subscribe(c *gin.Context) {
+	var req SubscribeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	subscription, err := h.service.Subscribe(c.Request.Context(), userID.(uuid.UUID), req)
+	if err != nil {
+		subscriptionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, subscription)
+}
+
+// ChangePlan moves the current seller's subscription to another plan
+func (h *Handler) ChangePlan(c *gin.Context) {
+	var req ChangePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	subscription, err := h.service.ChangePlan(c.Request.Context(), userID.(uuid.UUID), req)
+	if err != nil {
+		subscriptionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, subscription)
+}
+
+// UpdatePaymentMethod charges the current seller's renewals to another saved method
+func (h *Handler) UpdatePaymentMethod(c *gin.Context) {
+	var req PaymentMethodRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	subscription, err := h.service.UpdatePaymentMethod(c.Request.Context(), userID.(uuid.UUID), req)
+	if err != nil {
+		subscriptionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, subscription)
+}
+
+// CancelSubscription cancels the current seller's subscription at the end of its period,
+// or now with ?immediately=true
+func (h *Handler) CancelSubscription(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	immediately := c.Query("immediately") == "true"
+	subscription, err := h.service.Cancel(c.Request.Context(), userID.(uuid.UUID), immediately)
+	if err != nil {
+		subscriptionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, subscription)
+}
+
+// ResumeSubscription undoes a cancellation at the end of the period
+func (h *Handler) ResumeSubscription(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	subscription, err := h.service.Resume(c.Request.Context(), userID.(uuid.UUID))
+	if err != nil {
+		subscriptionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, subscription)
+}
+
+// ListSubscriptions lists all subscriptions, optionally by status (admin only)
+func (h *Handler) ListSubscriptions(c *gin.Context) {
+	// Check if user is admin
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	subscriptions, total, err := h.service.ListSubscriptions(c.Request.Context(), c.Query("status"), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": subscriptions,
+		"pagination": gin.H{
+			"page":        page,
+			"limit":       limit,
+			"total":       total,
+			"total_pages": (total + int64(limit) - 1) / int64(limit),
+		},
+	})
+}
+
+// subscriptionError maps subscription service errors to HTTP responses
+func subscriptionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrUnknownPlan), errors.Is(err, ErrPaidPlanRequired), errors.Is(err, ErrPaymentMethodRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrPaymentFailed):
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNoSubscription):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrAlreadySubscribed), errors.Is(err, ErrSubscriptionPastDue):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,118 @@
+package subscriptions
+
+import "time"
+
+// Plan identifiers
+const (
+	PlanFree       = "free"
+	PlanPro        = "pro"
+	PlanEnterprise = "enterprise"
+)
+
+// Features are what a plan entitles a seller to
+type Features struct {
+	MaxLiveAuctions        int    `json:"max_live_auctions"`        // auctions live at once; 0 for unlimited
+	SellerTier             string `json:"seller_tier"`              // fee tier; fee rules scoped to it set the plan's commission
+	RecordingRetentionDays int    `json:"recording_retention_days"` // how long stream recordings are kept
+}
+
+// Plan is a seller subscription plan. Paid plans renew every IntervalCount Intervals.
+type Plan struct {
+	ID            string   `json:"id"`
+	Name          string   `json:"name"`
+	Price         int64    `json:"price"`
+	Currency      string   `json:"currency"`
+	Interval      string   `json:"interval"` // month, year
+	IntervalCount int      `json:"interval_count"`
+	TrialDays     int      `json:"trial_days"` // free days before the first charge, once per seller
+	Features      Features `json:"features"`
+}
+
+// IsFree reports whether the plan is free. Sellers without a subscription are on the
+// free plan.
+func (p Plan) IsFree() bool {
+	return p.Price == 0
+}
+
+// RecordingRetention returns how long the plan keeps stream recordings
+func (p Plan) RecordingRetention() time.Duration {
+	return time.Duration(p.Features.RecordingRetentionDays) * 24 * time.Hour
+}
+
+// periodEnd returns the end of a billing period starting at start
+func (p Plan) periodEnd(start time.Time) time.Time {
+	count := p.IntervalCount
+	if count < 1 {
+		count = 1
+	}
+	if p.Interval == "year" {
+		return start.AddDate(count, 0, 0)
+	}
+	return start.AddDate(0, count, 0)
+}
+
+var plans = []Plan{
+	{
+		ID:            PlanFree,
+		Name:          "Free",
+		Currency:      "USD",
+		Interval:      "month",
+		IntervalCount: 1,
+		Features: Features{
+			MaxLiveAuctions:        1,
+			SellerTier:             "standard",
+			RecordingRetentionDays: 7,
+		},
+	},
+	{
+		ID:            PlanPro,
+		Name:          "Pro",
+		Price:         2900,
+		Currency:      "USD",
+		Interval:      "month",
+		IntervalCount: 1,
+		TrialDays:     14,
+		Features: Features{
+			MaxLiveAuctions:        3,
+			SellerTier:             "pro",
+			RecordingRetentionDays: 90,
+		},
+	},
+	{
+		ID:            PlanEnterprise,
+		Name:          "Enterprise",
+		Price:         19900,
+		Currency:      "USD",
+		Interval:      "month",
+		IntervalCount: 1,
+		TrialDays:     14,
+		Features: Features{
+			MaxLiveAuctions:        0,
+			SellerTier:             "enterprise",
+			RecordingRetentionDays: 365,
+		},
+	},
+}
+
+// Plans lists the available plans, cheapest first
+func Plans() []Plan {
+	result := make([]Plan, len(plans))
+	copy(result, plans)
+	return result
+}
+
+// GetPlan returns a plan by ID
+func GetPlan(id string) (Plan, bool) {
+	for _, plan := range plans {
+		if plan.ID == id {
+			return plan, true
+		}
+	}
+	return Plan{}, false
+}
+
+// freePlan is the plan of sellers without a subscription
+func freePlan() Plan {
+	plan, _ := GetPlan(PlanFree)
+	return plan
+}
@@ -0,0 +1,611 @@
+package subscriptions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/common"
+	"github.com/blytz.live.remake/backend/internal/ledger"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/pkg/logging"
+	"github.com/blytz.live.remake/backend/pkg/money"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Subscription statuses
+const (
+	StatusTrialing = "trialing"
+	StatusActive   = "active"
+	StatusPastDue  = "past_due"
+	StatusCanceled = "canceled"
+	StatusUnpaid   = "unpaid"
+)
+
+// currentStatuses are the statuses of a subscription whose plan the seller still has
+var currentStatuses = []string{StatusTrialing, StatusActive, StatusPastDue}
+
+// DunningSchedule is how long after each failed renewal charge it is retried. Once every
+// retry has failed the subscription becomes unpaid and the seller is back on the free plan.
+var DunningSchedule = []time.Duration{24 * time.Hour, 3 * 24 * time.Hour, 5 * 24 * time.Hour}
+
+// ErrUnknownPlan is returned for a plan that is not offered
+var ErrUnknownPlan = errors.New("unknown subscription plan")
+
+// ErrPaidPlanRequired is returned when subscribing or switching to the free plan, which
+// sellers are on without a subscription
+var ErrPaidPlanRequired = errors.New("the free plan needs no subscription; cancel to return to it")
+
+// ErrAlreadySubscribed is returned when subscribing with a subscription already current
+var ErrAlreadySubscribed = errors.New("seller already has a subscription")
+
+// ErrNoSubscription is returned when the seller has no current subscription
+var ErrNoSubscription = errors.New("seller has no subscription")
+
+// ErrPaymentMethodRequired is returned when the seller has no saved payment method to charge
+var ErrPaymentMethodRequired = errors.New("a saved payment method is required")
+
+// ErrPaymentFailed is returned when a subscription charge is declined or fails
+var ErrPaymentFailed = errors.New("subscription payment failed")
+
+// ErrSubscriptionPastDue is returned when changing plans while a renewal is unpaid
+var ErrSubscriptionPastDue = errors.New("subscription has an unpaid renewal")
+
+// Charger charges a user's saved payment method
+type Charger interface {
+	ChargePaymentMethod(ctx context.Context, userID uuid.UUID, amount int64, currency, paymentMethodRef string, metadata map[string]string) (*models.Payment, error)
+}
+
+// SubscribeRequest represents a request to subscribe to a paid plan
+type SubscribeRequest struct {
+	PlanID          string     `json:"plan_id" binding:"required"`
+	PaymentMethodID *uuid.UUID `json:"payment_method_id"` // a saved method; the default one when unset
+}
+
+// ChangePlanRequest represents a request to move a subscription to another paid plan
+type ChangePlanRequest struct {
+	PlanID string `json:"plan_id" binding:"required"`
+}
+
+// PaymentMethodRequest represents a request to charge renewals to another saved method
+type PaymentMethodRequest struct {
+	PaymentMethodID uuid.UUID `json:"payment_method_id" binding:"required"`
+}
+
+// Entitlements is the plan a seller is on and the subscription that provides it, if any
+type Entitlements struct {
+	Plan         Plan                 `json:"plan"`
+	Subscription *models.Subscription `json:"subscription"`
+}
+
+// Service manages seller subscriptions, their renewals and the entitlements they grant
+type Service struct {
+	db      *gorm.DB
+	logger  *logging.Logger
+	charger Charger
+	ledger  *ledger.Service
+}
+
+// NewService creates a new subscription service charging through charger
+func NewService(db *gorm.DB, charger Charger) *Service {
+	return &Service{
+		db:      db,
+		logger:  logging.NewLogger(),
+		charger: charger,
+	}
+}
+
+// SetLedger sets the ledger that subscription charges are recognized in
+func (s *Service) SetLedger(ledgerService *ledger.Service) {
+	s.ledger = ledgerService
+}
+
+// Subscribe subscribes a seller to a paid plan. Sellers who have never had a trial start
+// with the plan's trial and are first charged when it ends; everyone else pays for the
+// first period now.
+func (s *Service) Subscribe(ctx context.Context, sellerID uuid.UUID, req SubscribeRequest) (*models.Subscription, error) {
+	plan, ok := GetPlan(req.PlanID)
+	if !ok {
+		return nil, ErrUnknownPlan
+	}
+	if plan.IsFree() {
+		return nil, ErrPaidPlanRequired
+	}
+
+	if _, err := s.current(ctx, sellerID); err == nil {
+		return nil, ErrAlreadySubscribed
+	} else if !errors.Is(err, ErrNoSubscription) {
+		return nil, err
+	}
+
+	method, err := s.paymentMethod(ctx, sellerID, req.PaymentMethodID)
+	if err != nil {
+		return nil, err
+	}
+
+	var trials int64
+	if err := s.db.WithContext(ctx).Model(&models.Subscription{}).
+		Where("user_id = ? AND trial_start IS NOT NULL", sellerID).
+		Count(&trials).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	subscription := &models.Subscription{
+		BaseModel:          common.BaseModel{ID: uuid.New()},
+		UserID:             sellerID,
+		Status:             StatusActive,
+		CurrentPeriodStart: now,
+		PaymentMethodRef:   method.MethodRef,
+		GatewayType:        method.Provider,
+		Metadata:           "{}",
+	}
+	applyPlan(subscription, plan)
+
+	if plan.TrialDays > 0 && trials == 0 {
+		trialEnd := now.AddDate(0, 0, plan.TrialDays)
+		subscription.Status = StatusTrialing
+		subscription.TrialStart = &now
+		subscription.TrialEnd = &trialEnd
+		subscription.CurrentPeriodEnd = trialEnd
+	} else {
+		subscription.CurrentPeriodEnd = plan.periodEnd(now)
+		payment, err := s.charge(ctx, subscription, plan, money.New(plan.Price, plan.Currency), "signup")
+		if err != nil {
+			return nil, err
+		}
+		subscription.GatewayRef = payment.GatewayRef
+		subscription.GatewayType = payment.GatewayType
+	}
+
+	if err := s.save(ctx, subscription, true); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Subscription started", map[string]interface{}{
+		"subscription_id": subscription.ID,
+		"seller_id":       sellerID,
+		"plan_id":         plan.ID,
+		"status":          subscription.Status,
+	})
+
+	return subscription, nil
+}
+
+// ChangePlan moves a seller's subscription to another paid plan. Upgrades take effect
+// now, charging the price difference for the rest of a paid period; downgrades take
+// effect at the next renewal, so the seller keeps what they paid for.
+func (s *Service) ChangePlan(ctx context.Context, sellerID uuid.UUID, req ChangePlanRequest) (*models.Subscription, error) {
+	plan, ok := GetPlan(req.PlanID)
+	if !ok {
+		return nil, ErrUnknownPlan
+	}
+	if plan.IsFree() {
+		return nil, ErrPaidPlanRequired
+	}
+
+	subscription, err := s.current(ctx, sellerID)
+	if err != nil {
+		return nil, err
+	}
+	if subscription.Status == StatusPastDue {
+		return nil, ErrSubscriptionPastDue
+	}
+
+	if plan.ID == subscription.PlanID {
+		subscription.PendingPlanID = nil
+		if err := s.save(ctx, subscription, false); err != nil {
+			return nil, err
+		}
+		return subscription, nil
+	}
+
+	if plan.Price <= subscription.Amount {
+		subscription.PendingPlanID = &plan.ID
+		if err := s.save(ctx, subscription, false); err != nil {
+			return nil, err
+		}
+
+		s.logger.Info("Subscription downgrade scheduled", map[string]interface{}{
+			"subscription_id": subscription.ID,
+			"plan_id":         plan.ID,
+			"effective_at":    subscription.CurrentPeriodEnd,
+		})
+		return subscription, nil
+	}
+
+	// Trials upgrade for free; the new plan's price applies from the first charge
+	if subscription.Status == StatusActive {
+		due := prorate(subscription, plan, time.Now())
+		if due.Amount > 0 {
+			payment, err := s.charge(ctx, subscription, plan, due, "upgrade")
+			if err != nil {
+				return nil, err
+			}
+			subscription.GatewayRef = payment.GatewayRef
+			subscription.GatewayType = payment.GatewayType
+		}
+	}
+
+	previous := subscription.PlanID
+	applyPlan(subscription, plan)
+	subscription.PendingPlanID = nil
+	if err := s.save(ctx, subscription, true); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Subscription upgraded", map[string]interface{}{
+		"subscription_id": subscription.ID,
+		"from_plan_id":    previous,
+		"plan_id":         plan.ID,
+	})
+
+	return subscription, nil
+}
+
+// Cancel cancels a seller's subscription at the end of the current period, or now when
+// immediately is set or a renewal is unpaid. Nothing already paid is refunded.
+func (s *Service) Cancel(ctx context.Context, sellerID uuid.UUID, immediately bool) (*models.Subscription, error) {
+	subscription, err := s.current(ctx, sellerID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	subscription.CanceledAt = &now
+	if immediately || subscription.Status == StatusPastDue {
+		if err := s.end(ctx, subscription, StatusCanceled, now); err != nil {
+			return nil, err
+		}
+	} else {
+		subscription.CancelAtPeriodEnd = true
+		if err := s.save(ctx, subscription, false); err != nil {
+			return nil, err
+		}
+	}
+
+	s.logger.Info("Subscription canceled", map[string]interface{}{
+		"subscription_id": subscription.ID,
+		"seller_id":       sellerID,
+		"at_period_end":   subscription.CancelAtPeriodEnd,
+	})
+
+	return subscription, nil
+}
+
+// Resume keeps a subscription canceled at the end of its period renewing
+func (s *Service) Resume(ctx context.Context, sellerID uuid.UUID) (*models.Subscription, error) {
+	subscription, err := s.current(ctx, sellerID)
+	if err != nil {
+		return nil, err
+	}
+	if !subscription.CancelAtPeriodEnd {
+		return subscription, nil
+	}
+
+	subscription.CancelAtPeriodEnd = false
+	subscription.CanceledAt = nil
+	if err := s.save(ctx, subscription, false); err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+// UpdatePaymentMethod charges future renewals to another of the seller's saved methods.
+// An unpaid renewal is retried with it on the next run.
+func (s *Service) UpdatePaymentMethod(ctx context.Context, sellerID uuid.UUID, req PaymentMethodRequest) (*models.Subscription, error) {
+	subscription, err := s.current(ctx, sellerID)
+	if err != nil {
+		return nil, err
+	}
+
+	method, err := s.paymentMethod(ctx, sellerID, &req.PaymentMethodID)
+	if err != nil {
+		return nil, err
+	}
+
+	subscription.PaymentMethodRef = method.MethodRef
+	if subscription.Status == StatusPastDue {
+		now := time.Now()
+		subscription.NextRetryAt = &now
+	}
+	if err := s.save(ctx, subscription, false); err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+// RenewDue renews the subscriptions whose period has ended and retries failed renewals
+// that are due, ending canceled subscriptions instead. It returns how many it processed.
+func (s *Service) RenewDue(ctx context.Context, now time.Time) (int, error) {
+	var due []models.Subscription
+	if err := s.db.WithContext(ctx).
+		Where("(status IN ? AND current_period_end <= ?) OR (status = ? AND next_retry_at <= ?)",
+			[]string{StatusTrialing, StatusActive}, now, StatusPastDue, now).
+		Order("current_period_end ASC").
+		Find(&due).Error; err != nil {
+		return 0, err
+	}
+
+	processed := 0
+	for i := range due {
+		if err := s.renew(ctx, &due[i], now); err != nil {
+			s.logger.Error("Failed to renew subscription", map[string]interface{}{
+				"subscription_id": due[i].ID,
+				"error":           err.Error(),
+			})
+			continue
+		}
+		processed++
+	}
+	return processed, nil
+}
+
+// renew charges a subscription for its next period, switching to a scheduled plan first
+func (s *Service) renew(ctx context.Context, subscription *models.Subscription, now time.Time) error {
+	if subscription.CancelAtPeriodEnd {
+		return s.end(ctx, subscription, StatusCanceled, subscription.CurrentPeriodEnd)
+	}
+
+	plan := subscribedPlan(subscription)
+	if subscription.PendingPlanID != nil {
+		if pending, ok := GetPlan(*subscription.PendingPlanID); ok {
+			plan = pending
+		}
+	}
+
+	payment, err := s.charge(ctx, subscription, plan, money.New(plan.Price, plan.Currency), "renewal")
+	if err != nil {
+		return s.dun(ctx, subscription, now, err)
+	}
+
+	// Periods follow on from each other unless the renewal was so late the next one is over
+	start := subscription.CurrentPeriodEnd
+	if !plan.periodEnd(start).After(now) {
+		start = now
+	}
+	applyPlan(subscription, plan)
+	subscription.PendingPlanID = nil
+	subscription.Status = StatusActive
+	subscription.CurrentPeriodStart = start
+	subscription.CurrentPeriodEnd = plan.periodEnd(start)
+	subscription.FailedAttempts = 0
+	subscription.NextRetryAt = nil
+	subscription.GatewayRef = payment.GatewayRef
+	subscription.GatewayType = payment.GatewayType
+	if err := s.save(ctx, subscription, true); err != nil {
+		return err
+	}
+
+	s.logger.Info("Subscription renewed", map[string]interface{}{
+		"subscription_id": subscription.ID,
+		"plan_id":         plan.ID,
+		"period_end":      subscription.CurrentPeriodEnd,
+	})
+	return nil
+}
+
+// dun records a failed renewal charge and schedules its retry; once every retry has
+// failed the subscription ends unpaid
+func (s *Service) dun(ctx context.Context, subscription *models.Subscription, now time.Time, cause error) error {
+	subscription.FailedAttempts++
+	if subscription.FailedAttempts > len(DunningSchedule) {
+		s.logger.Warn("Subscription unpaid after final retry", map[string]interface{}{
+			"subscription_id": subscription.ID,
+			"seller_id":       subscription.UserID,
+			"error":           cause.Error(),
+		})
+		return s.end(ctx, subscription, StatusUnpaid, now)
+	}
+
+	retryAt := now.Add(DunningSchedule[subscription.FailedAttempts-1])
+	subscription.Status = StatusPastDue
+	subscription.NextRetryAt = &retryAt
+	if err := s.save(ctx, subscription, false); err != nil {
+		return err
+	}
+
+	s.logger.Warn("Subscription renewal failed", map[string]interface{}{
+		"subscription_id": subscription.ID,
+		"seller_id":       subscription.UserID,
+		"attempt":         subscription.FailedAttempts,
+		"retry_at":        retryAt,
+		"error":           cause.Error(),
+	})
+	return nil
+}
+
+// end closes a subscription, returning the seller to the free plan
+func (s *Service) end(ctx context.Context, subscription *models.Subscription, status string, at time.Time) error {
+	subscription.Status = status
+	subscription.EndedAt = &at
+	subscription.NextRetryAt = nil
+	subscription.PendingPlanID = nil
+	return s.save(ctx, subscription, true)
+}
+
+// charge charges the subscription's payment method and recognizes the charge as
+// subscription revenue
+func (s *Service) charge(ctx context.Context, subscription *models.Subscription, plan Plan, amount money.Money, reason string) (*models.Payment, error) {
+	payment, err := s.charger.ChargePaymentMethod(ctx, subscription.UserID, amount.Amount, amount.Currency, subscription.PaymentMethodRef, map[string]string{
+		"subscription_id": subscription.ID.String(),
+		"plan_id":         plan.ID,
+		"reason":          reason,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPaymentFailed, err)
+	}
+
+	// The charge has been taken, so a ledger failure is reported rather than undoing it
+	if s.ledger != nil {
+		if _, err := s.ledger.PostSubscription(ctx, payment, fmt.Sprintf("%s plan %s (%s)", plan.Name, amount, reason)); err != nil {
+			s.logger.Error("Failed to post subscription charge to ledger", map[string]interface{}{
+				"subscription_id": subscription.ID,
+				"payment_id":      payment.ID,
+				"error":           err.Error(),
+			})
+		}
+	}
+	return payment, nil
+}
+
+// save stores a subscription, and when the seller's plan may have changed, sets their
+// fee tier to the one the plan gives
+func (s *Service) save(ctx context.Context, subscription *models.Subscription, syncTier bool) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(subscription).Error; err != nil {
+			return fmt.Errorf("failed to save subscription: %w", err)
+		}
+		if !syncTier {
+			return nil
+		}
+
+		plan := freePlan()
+		for _, status := range currentStatuses {
+			if subscription.Status == status {
+				if subscribed, ok := GetPlan(subscription.PlanID); ok {
+					plan = subscribed
+				}
+			}
+		}
+		return tx.Model(&models.User{}).
+			Where("id = ?", subscription.UserID).
+			Update("seller_tier", plan.Features.SellerTier).Error
+	})
+}
+
+// current returns the seller's current subscription
+func (s *Service) current(ctx context.Context, sellerID uuid.UUID) (*models.Subscription, error) {
+	var subscription models.Subscription
+	err := s.db.WithContext(ctx).
+		Where("user_id = ? AND status IN ?", sellerID, currentStatuses).
+		Order("created_at DESC").
+		First(&subscription).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNoSubscription
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// paymentMethod returns one of the user's unexpired saved methods, or their default one
+func (s *Service) paymentMethod(ctx context.Context, userID uuid.UUID, id *uuid.UUID) (*models.PaymentMethod, error) {
+	query := s.db.WithContext(ctx).Where("user_id = ? AND is_verified = ? AND is_expired = ?", userID, true, false)
+	if id != nil {
+		query = query.Where("id = ?", *id)
+	} else {
+		query = query.Order("is_default DESC, created_at DESC")
+	}
+
+	var method models.PaymentMethod
+	if err := query.First(&method).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPaymentMethodRequired
+		}
+		return nil, err
+	}
+	return &method, nil
+}
+
+// Entitlements returns the plan a seller is on: their current subscription's, or the
+// free plan. Past due subscriptions keep their plan while the renewal is retried.
+func (s *Service) Entitlements(ctx context.Context, sellerID uuid.UUID) (*Entitlements, error) {
+	subscription, err := s.current(ctx, sellerID)
+	if errors.Is(err, ErrNoSubscription) {
+		return &Entitlements{Plan: freePlan()}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plan, ok := GetPlan(subscription.PlanID)
+	if !ok {
+		plan = freePlan()
+	}
+	return &Entitlements{Plan: plan, Subscription: subscription}, nil
+}
+
+// MaxLiveAuctions returns how many auctions the seller may have live at once; 0 for
+// unlimited
+func (s *Service) MaxLiveAuctions(ctx context.Context, sellerID uuid.UUID) (int, error) {
+	entitlements, err := s.Entitlements(ctx, sellerID)
+	if err != nil {
+		return 0, err
+	}
+	return entitlements.Plan.Features.MaxLiveAuctions, nil
+}
+
+// RecordingRetention returns how long the seller's stream recordings are kept
+func (s *Service) RecordingRetention(ctx context.Context, sellerID uuid.UUID) (time.Duration, error) {
+	entitlements, err := s.Entitlements(ctx, sellerID)
+	if err != nil {
+		return 0, err
+	}
+	return entitlements.Plan.RecordingRetention(), nil
+}
+
+// ListSubscriptions lists subscriptions, optionally by status, most recent first
+func (s *Service) ListSubscriptions(ctx context.Context, status string, page, limit int) ([]models.Subscription, int64, error) {
+	query := s.db.WithContext(ctx).Model(&models.Subscription{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var subscriptions []models.Subscription
+	err := query.
+		Order("created_at DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&subscriptions).Error
+	return subscriptions, total, err
+}
+
+// applyPlan sets a subscription's plan and price
+func applyPlan(subscription *models.Subscription, plan Plan) {
+	subscription.PlanID = plan.ID
+	subscription.PlanName = plan.Name
+	subscription.Amount = plan.Price
+	subscription.Currency = plan.Currency
+	subscription.Interval = plan.Interval
+	subscription.IntervalCount = plan.IntervalCount
+}
+
+// subscribedPlan returns the plan as the subscription was sold, which is what renews
+// even if the plan's catalog price has since changed
+func subscribedPlan(subscription *models.Subscription) Plan {
+	plan, _ := GetPlan(subscription.PlanID)
+	plan.ID = subscription.PlanID
+	plan.Name = subscription.PlanName
+	plan.Price = subscription.Amount
+	plan.Currency = subscription.Currency
+	plan.Interval = subscription.Interval
+	plan.IntervalCount = subscription.IntervalCount
+	return plan
+}
+
+// prorate returns the price difference of moving to plan for the rest of the current period
+func prorate(subscription *models.Subscription, plan Plan, now time.Time) money.Money {
+	period := subscription.CurrentPeriodEnd.Sub(subscription.CurrentPeriodStart)
+	remaining := subscription.CurrentPeriodEnd.Sub(now)
+	if period <= 0 || remaining <= 0 {
+		return money.Zero(plan.Currency)
+	}
+	if remaining > period {
+		remaining = period
+	}
+
+	difference := plan.Price - subscription.Amount
+	if difference <= 0 {
+		return money.Zero(plan.Currency)
+	}
+	return money.New(difference, plan.Currency).MulRate(remaining.Seconds() / period.Seconds())
+}
@@ -0,0 +1,277 @@
+// Package money represents amounts as integer minor units of an ISO 4217 currency.
+//
+// Models store amounts as int64 minor units next to a currency column, and their JSON
+// carries the same minor units. Services wrap them with New to do arithmetic, rounding
+// and gateway conversion on Money. API responses that report computed totals, such as
+// payout summaries and ledger balances, use Money's JSON encoding.
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is used where an amount has no currency of its own
+const DefaultCurrency = "USD"
+
+// ErrUnknownCurrency is returned for a currency code that is not supported
+var ErrUnknownCurrency = errors.New("unknown currency")
+
+// ErrCurrencyMismatch is returned when combining amounts in different currencies
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+// ErrInvalidAmount is returned when an amount cannot be parsed or has more decimals
+// than its currency allows
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// exponents holds the number of minor unit digits of each supported ISO 4217 currency
+var exponents = map[string]int{
+	"AUD": 2,
+	"BHD": 3,
+	"BRL": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"CNY": 2,
+	"DKK": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"HKD": 2,
+	"IDR": 2,
+	"INR": 2,
+	"JOD": 3,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"MXN": 2,
+	"MYR": 2,
+	"NOK": 2,
+	"NZD": 2,
+	"OMR": 3,
+	"PHP": 2,
+	"PLN": 2,
+	"SEK": 2,
+	"SGD": 2,
+	"THB": 2,
+	"TWD": 2,
+	"USD": 2,
+	"VND": 0,
+	"ZAR": 2,
+}
+
+// Money is an amount in a currency's smallest unit, e.g. cents for USD and yen for JPY
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// New returns an amount of minor units in currency
+func New(minor int64, currency string) Money {
+	return Money{Amount: minor, Currency: NormalizeCurrency(currency)}
+}
+
+// Zero returns a zero amount in currency
+func Zero(currency string) Money {
+	return New(0, currency)
+}
+
+// FromMajor converts an amount in major units, such as a fixed price or increment, to Money.
+// The amount is rounded half away from zero to the currency's minor unit.
+func FromMajor(amount float64, currency string) Money {
+	currency = NormalizeCurrency(currency)
+	scale := math.Pow10(Exponent(currency))
+	return Money{Amount: int64(math.Round(amount * scale)), Currency: currency}
+}
+
+// Parse parses a decimal string such as "12.34" in currency. Amounts with more decimals
+// than the currency has minor unit digits are rejected instead of rounded.
+func Parse(amount, currency string) (Money, error) {
+	currency = NormalizeCurrency(currency)
+	if !IsSupported(currency) {
+		return Money{}, fmt.Errorf("%w: %s", ErrUnknownCurrency, currency)
+	}
+
+	value, ok := new(big.Rat).SetString(strings.TrimSpace(amount))
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, amount)
+	}
+	value.Mul(value, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(Exponent(currency))), nil)))
+	if !value.IsInt() || !value.Num().IsInt64() {
+		return Money{}, fmt.Errorf("%w: %q in %s", ErrInvalidAmount, amount, currency)
+	}
+
+	return Money{Amount: value.Num().Int64(), Currency: currency}, nil
+}
+
+// NormalizeCurrency upper-cases a currency code, defaulting to DefaultCurrency
+func NormalizeCurrency(currency string) string {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		return DefaultCurrency
+	}
+	return currency
+}
+
+// IsSupported reports whether currency is a supported ISO 4217 code
+func IsSupported(currency string) bool {
+	_, ok := exponents[NormalizeCurrency(currency)]
+	return ok
+}
+
+// Exponent returns the number of minor unit digits of currency. Unknown currencies
+// use two, the most common exponent.
+func Exponent(currency string) int {
+	if exp, ok := exponents[NormalizeCurrency(currency)]; ok {
+		return exp
+	}
+	return 2
+}
+
+// IsZero reports whether the amount is zero
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// IsNegative reports whether the amount is below zero
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+// Add returns m + other; both must be in the same currency
+func (m Money) Add(other Money) (Money, error) {
+	if err := m.sameCurrency(other); err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+// Sub returns m - other; both must be in the same currency
+func (m Money) Sub(other Money) (Money, error) {
+	if err := m.sameCurrency(other); err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: m.Amount - other.Amount, Currency: m.Currency}, nil
+}
+
+// Mul returns m multiplied by a whole quantity
+func (m Money) Mul(quantity int64) Money {
+	return Money{Amount: m.Amount * quantity, Currency: m.Currency}
+}
+
+// MulRate returns m multiplied by rate, rounded half away from zero to the minor unit.
+// It is meant for tax and fee rates such as 0.08.
+func (m Money) MulRate(rate float64) Money {
+	return Money{Amount: int64(math.Round(float64(m.Amount) * rate)), Currency: m.Currency}
+}
+
+// Allocate splits m into parts proportional to weights without losing minor units;
+// the remainder goes to the first parts
+func (m Money) Allocate(weights ...int64) []Money {
+	parts := make([]Money, len(weights))
+	var total int64
+	for _, w := range weights {
+		total += w
+	}
+	if total == 0 {
+		for i := range parts {
+			parts[i] = Zero(m.Currency)
+		}
+		return parts
+	}
+
+	remainder := m.Amount
+	for i, w := range weights {
+		share := m.Amount * w / total
+		parts[i] = Money{Amount: share, Currency: m.Currency}
+		remainder -= share
+	}
+	step := int64(1)
+	if remainder < 0 {
+		step = -1
+	}
+	for i := 0; remainder != 0; i = (i + 1) % len(parts) {
+		if weights[i] == 0 {
+			continue
+		}
+		parts[i].Amount += step
+		remainder -= step
+	}
+	return parts
+}
+
+// Cmp compares m and other: -1 when m is less, 0 when equal and +1 when greater
+func (m Money) Cmp(other Money) (int, error) {
+	if err := m.sameCurrency(other); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.Amount < other.Amount:
+		return -1, nil
+	case m.Amount > other.Amount:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// String formats the amount with its currency, e.g. "12.34 USD" or "1200 JPY"
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// Decimal formats the amount in major units without a currency, e.g. "12.34"
+func (m Money) Decimal() string {
+	exp := Exponent(m.Currency)
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	digits := strconv.FormatInt(amount, 10)
+	if exp == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+// MarshalJSON encodes the amount in minor units with its currency and a display string
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount   int64  `json:"amount"`
+		Currency string `json:"currency"`
+		Display  string `json:"display"`
+	}{m.Amount, m.Currency, m.Decimal()})
+}
+
+// UnmarshalJSON decodes {"amount": minor units, "currency": code}
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Amount   int64  `json:"amount"`
+		Currency string `json:"currency"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	currency := NormalizeCurrency(raw.Currency)
+	if !IsSupported(currency) {
+		return fmt.Errorf("%w: %s", ErrUnknownCurrency, currency)
+	}
+	*m = Money{Amount: raw.Amount, Currency: currency}
+	return nil
+}
+
+func (m Money) sameCurrency(other Money) error {
+	if m.Currency != other.Currency {
+		return fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/auction"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/pkg/mail"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingReminderNotifier records the reminders it is asked to send
+type recordingReminderNotifier struct {
+	sent []uuid.UUID
+}
+
+func (n *recordingReminderNotifier) SendAuctionReminder(ctx context.Context, userID uuid.UUID, auction *models.Auction) error {
+	n.sent = append(n.sent, userID)
+	return nil
+}
+
+// failingReminderNotifier fails to deliver reminders to some users and counts the tries
+type failingReminderNotifier struct {
+	failing map[uuid.UUID]bool
+	tries   map[uuid.UUID]int
+}
+
+func (n *failingReminderNotifier) SendAuctionReminder(ctx context.Context, userID uuid.UUID, auction *models.Auction) error {
+	n.tries[userID]++
+	if n.failing[userID] {
+		return errors.New("mailbox unavailable")
+	}
+	return nil
+}
+
+// recordingMailer records the messages it is asked to send
+type recordingMailer struct {
+	messages []mail.Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg mail.Message) error {
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func TestShowCalendarAndReminders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupAuctionTestDB(t)
+	ctx := context.Background()
+
+	service := auction.NewService(db)
+	notifier := &recordingReminderNotifier{}
+	service.SetReminderNotifier(notifier)
+
+	now := time.Now()
+	item := createTestAuction(t, db)
+	require.NoError(t, db.Model(item).Updates(map[string]interface{}{
+		"status":     "scheduled",
+		"start_time": now.Add(time.Hour),
+		"end_time":   now.Add(2 * time.Hour),
+	}).Error)
+
+	// Upcoming shows appear on the platform and seller calendars
+	shows, err := service.GetShowCalendar(ctx, now, now.Add(24*time.Hour), &item.SellerID)
+	require.NoError(t, err)
+	require.Len(t, shows, 1)
+	assert.Equal(t, item.ID, shows[0].ID)
+
+	_, err = service.GetShowCalendar(ctx, now, now.Add(90*24*time.Hour), nil)
+	assert.ErrorIs(t, err, auction.ErrInvalidCalendarRange)
+
+	viewer := uuid.New()
+	reminder, err := service.SubscribeReminder(ctx, item.ID, viewer, 30)
+	require.NoError(t, err)
+	assert.WithinDuration(t, now.Add(30*time.Minute), reminder.RemindAt, time.Second)
+
+	_, err = service.SubscribeReminder(ctx, item.ID, viewer, auction.MaxReminderLead+1)
+	assert.ErrorIs(t, err, auction.ErrInvalidReminderLead)
+
+	sent, err := service.DispatchDueReminders(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+
+	// Moving the show re-arms the reminder for the new start time
+	require.NoError(t, db.Model(item).Updates(map[string]interface{}{
+		"start_time": now.Add(3 * time.Hour),
+		"end_time":   now.Add(4 * time.Hour),
+	}).Error)
+	sent, err = service.DispatchDueReminders(ctx, now.Add(31*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+
+	sent, err = service.DispatchDueReminders(ctx, now.Add(151*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, []uuid.UUID{viewer}, notifier.sent)
+
+	// A sent reminder is not sent twice
+	sent, err = service.DispatchDueReminders(ctx, now.Add(152*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+
+	reminders, err := service.ListUserReminders(ctx, viewer)
+	require.NoError(t, err)
+	require.Len(t, reminders, 1)
+	assert.NotNil(t, reminders[0].SentAt)
+
+	// Rescheduling the show re-arms the sent reminder for the new start time
+	_, err = service.RescheduleAuction(ctx, item.ID, now.Add(-time.Hour), now.Add(time.Hour))
+	assert.ErrorIs(t, err, auction.ErrInvalidSchedule)
+	moved, err := service.RescheduleAuction(ctx, item.ID, now.Add(5*time.Hour), now.Add(6*time.Hour))
+	require.NoError(t, err)
+	assert.WithinDuration(t, now.Add(5*time.Hour), moved.StartTime, time.Second)
+	reminders, err = service.ListUserReminders(ctx, viewer)
+	require.NoError(t, err)
+	require.Len(t, reminders, 1)
+	assert.Nil(t, reminders[0].SentAt)
+	assert.WithinDuration(t, now.Add(270*time.Minute), reminders[0].RemindAt, time.Second)
+
+	sent, err = service.DispatchDueReminders(ctx, now.Add(269*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+	sent, err = service.DispatchDueReminders(ctx, now.Add(271*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, []uuid.UUID{viewer, viewer}, notifier.sent)
+
+	require.NoError(t, service.CancelReminder(ctx, item.ID, viewer))
+	assert.Error(t, service.CancelReminder(ctx, item.ID, viewer))
+
+	// The seller's shows are published as an iCalendar feed
+	router := gin.New()
+	router.GET("/auctions/sellers/:seller_id/calendar.ics", auction.NewHandler(service).GetSellerCalendarFeed)
+
+	req, _ := http.NewRequest(http.MethodGet, "/auctions/sellers/"+item.SellerID.String()+"/calendar.ics", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/calendar")
+	assert.Contains(t, w.Body.String(), "BEGIN:VEVENT\r\n")
+	assert.Contains(t, w.Body.String(), "UID:"+item.ID.String()+"@blytz.live\r\n")
+	assert.Contains(t, w.Body.String(), "SUMMARY:Vintage Watch Drop\r\n")
+}
+
+func TestReminderDeliveryRetries(t *testing.T) {
+	db := setupAuctionTestDB(t)
+	ctx := context.Background()
+
+	service := auction.NewService(db)
+	unreachable, viewer := uuid.New(), uuid.New()
+	notifier := &failingReminderNotifier{failing: map[uuid.UUID]bool{unreachable: true}, tries: map[uuid.UUID]int{}}
+	service.SetReminderNotifier(notifier)
+
+	now := time.Now()
+	item := createTestAuction(t, db)
+	require.NoError(t, db.Model(item).Updates(map[string]interface{}{
+		"status":     "scheduled",
+		"start_time": now.Add(time.Hour),
+		"end_time":   now.Add(2 * time.Hour),
+	}).Error)
+	for _, user := range []uuid.UUID{unreachable, viewer} {
+		_, err := service.SubscribeReminder(ctx, item.ID, user, 30)
+		require.NoError(t, err)
+	}
+
+	// A failed delivery does not hold up the others and waits before it is tried again
+	at := now.Add(31 * time.Minute)
+	sent, err := service.DispatchDueReminders(ctx, at)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	sent, err = service.DispatchDueReminders(ctx, at.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+	assert.Equal(t, map[uuid.UUID]int{unreachable: 1, viewer: 1}, notifier.tries)
+
+	var failed models.AuctionReminder
+	require.NoError(t, db.First(&failed, "user_id = ?", unreachable).Error)
+	assert.Equal(t, 1, failed.Attempts)
+	require.NotNil(t, failed.LastError)
+	assert.Equal(t, "mailbox unavailable", *failed.LastError)
+	assert.Nil(t, failed.SentAt)
+
+	// It is given up after the last attempt
+	for i := 1; i <= auction.MaxReminderAttempts; i++ {
+		_, err = service.DispatchDueReminders(ctx, at.Add(time.Duration(i)*auction.ReminderRetryDelay))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, auction.MaxReminderAttempts, notifier.tries[unreachable])
+
+	// Subscribing again re-arms it with fresh attempts
+	notifier.failing[unreachable] = false
+	_, err = service.SubscribeReminder(ctx, item.ID, unreachable, 20)
+	require.NoError(t, err)
+	sent, err = service.DispatchDueReminders(ctx, now.Add(41*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	require.NoError(t, db.First(&failed, "user_id = ?", unreachable).Error)
+	assert.NotNil(t, failed.SentAt)
+	assert.Nil(t, failed.LastError)
+}
+
+func TestEmailReminderNotifier(t *testing.T) {
+	db := setupAuctionTestDB(t)
+	ctx := context.Background()
+
+	item := createTestAuction(t, db)
+	var user models.User
+	require.NoError(t, db.First(&user, "id = ?", item.SellerID).Error)
+
+	mailer := &recordingMailer{}
+	notifier := auction.NewEmailReminderNotifier(db, mailer, "https://blytz.live/")
+	require.NoError(t, notifier.SendAuctionReminder(ctx, user.ID, item))
+	require.Len(t, mailer.messages, 1)
+	assert.Equal(t, user.Email, mailer.messages[0].To)
+	assert.Equal(t, "Starting soon: Vintage Watch Drop", mailer.messages[0].Subject)
+	assert.Contains(t, mailer.messages[0].Body, "https://blytz.live/auctions/"+item.ID.String())
+
+	// Reminders for unknown users fail so the dispatcher retries them
+	assert.Error(t, notifier.SendAuctionReminder(ctx, uuid.New(), item))
+	assert.Len(t, mailer.messages, 1)
+}
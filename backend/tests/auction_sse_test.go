@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/auction"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupAuctionTestDB creates an in-memory SQLite database with auction tables
+func setupAuctionTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	// A single connection keeps every query on the same in-memory database
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+
+	require.NoError(t, db.AutoMigrate(
+		&models.User{},
+		&models.Category{},
+		&models.Product{},
+		&models.Auction{},
+		&models.Bid{},
+		&models.AuctionReminder{},
+		&models.ChatMessage{},
+		&models.AuctionStats{},
+		&models.LiveStream{},
+		&models.StreamRecording{},
+		&models.HighlightClip{},
+		&models.StreamIngress{},
+		&models.StreamParticipant{},
+		&models.StreamMetricSample{},
+		&models.StreamAlert{},
+		&models.StreamWebhookEvent{},
+	))
+
+	return db
+}
+
+// createTestAuction creates a seller, product and live auction
+func createTestAuction(t *testing.T, db *gorm.DB) *models.Auction {
+	seller := createTestUser(db)
+	category := createTestCategory(db)
+
+	product := models.Product{
+		SellerID:      seller.ID,
+		CategoryID:    category.ID,
+		Title:         "Vintage Watch",
+		StartingPrice: 5000,
+		Status:        "active",
+	}
+	require.NoError(t, db.Create(&product).Error)
+
+	auctionID := uuid.New()
+	item := models.Auction{
+		ProductID:   product.ID,
+		SellerID:    seller.ID,
+		Title:       "Vintage Watch Drop",
+		StartTime:   time.Now(),
+		EndTime:     time.Now().Add(time.Hour),
+		Status:      "live",
+		StartPrice:  5000,
+		LiveKitRoom: "auction-" + auctionID.String(),
+	}
+	item.ID = auctionID
+	require.NoError(t, db.Create(&item).Error)
+
+	return &item
+}
+
+func TestAuctionSSEResume(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupAuctionTestDB(t)
+	item := createTestAuction(t, db)
+
+	service := auction.NewService(db)
+	wsManager := auction.NewWebSocketManager(db, service)
+	service.SetWebSocketManager(wsManager)
+
+	router := gin.New()
+	router.GET("/auctions/:id/events", wsManager.HandleSSE)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// Publish two events before the viewer reconnects
+	for _, text := range []string{"first", "second"} {
+		wsManager.NotifyChatMessage(item.ID, &models.ChatMessage{
+			AuctionID: item.ID,
+			UserID:    item.SellerID,
+			Message:   text,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/auctions/"+item.ID.String()+"/events", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "1")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// Only the event after Last-Event-ID should be replayed
+	var ids []string
+	var events []string
+	reader := bufio.NewReader(resp.Body)
+	for len(events) < 1 {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			ids = append(ids, strings.TrimPrefix(line, "id: "))
+		case strings.HasPrefix(line, "data: "):
+			events = append(events, strings.TrimPrefix(line, "data: "))
+		}
+	}
+
+	assert.Equal(t, []string{"2"}, ids)
+	assert.Contains(t, events[0], `"second"`)
+	assert.Contains(t, events[0], `"type":"chat"`)
+}
+
+func TestAuctionSSEInvalidAuction(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupAuctionTestDB(t)
+
+	service := auction.NewService(db)
+	wsManager := auction.NewWebSocketManager(db, service)
+
+	router := gin.New()
+	router.GET("/auctions/:id/events", wsManager.HandleSSE)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/auctions/not-a-uuid/events", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/auctions/"+uuid.New().String()+"/events", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/auction"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
+)
+
+// dialAuctionSocket opens an auction WebSocket requesting the given subprotocols
+func dialAuctionSocket(t *testing.T, server *httptest.Server, auctionID string, subprotocols []string) *websocket.Conn {
+	dialer := websocket.Dialer{Subprotocols: subprotocols}
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/auctions?auction_id=" + auctionID
+
+	conn, _, err := dialer.Dial(url, nil)
+	require.NoError(t, err)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	return conn
+}
+
+func TestAuctionWebSocketSubprotocols(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupAuctionTestDB(t)
+	item := createTestAuction(t, db)
+
+	service := auction.NewService(db)
+	wsManager := auction.NewWebSocketManager(db, service)
+	service.SetWebSocketManager(wsManager)
+
+	router := gin.New()
+	router.GET("/ws/auctions", wsManager.HandleWebSocket)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	t.Run("defaults to JSON", func(t *testing.T) {
+		conn := dialAuctionSocket(t, server, item.ID.String(), nil)
+		defer conn.Close()
+
+		assert.Equal(t, "", conn.Subprotocol())
+
+		frameType, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, websocket.TextMessage, frameType)
+
+		var message map[string]interface{}
+		require.NoError(t, json.Unmarshal(data, &message))
+		assert.Equal(t, "initial_data", message["type"])
+	})
+
+	t.Run("negotiates MessagePack", func(t *testing.T) {
+		conn := dialAuctionSocket(t, server, item.ID.String(), []string{auction.SubprotocolMsgPack, auction.SubprotocolJSON})
+		defer conn.Close()
+
+		assert.Equal(t, auction.SubprotocolMsgPack, conn.Subprotocol())
+
+		frameType, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, websocket.BinaryMessage, frameType)
+
+		var message map[string]interface{}
+		handle := &codec.MsgpackHandle{}
+		require.NoError(t, codec.NewDecoderBytes(data, handle).Decode(&message))
+		assert.Equal(t, "initial_data", string(asBytes(message["t"])))
+		assert.Equal(t, item.ID.String(), string(asBytes(message["a"])))
+
+		// Slim payloads carry the auction without nested product or seller objects
+		payload, ok := message["d"].(map[interface{}]interface{})
+		require.True(t, ok)
+		slimAuction, ok := payload["auction"].(map[interface{}]interface{})
+		require.True(t, ok)
+		assert.Equal(t, item.ID.String(), string(asBytes(slimAuction["id"])))
+		assert.NotContains(t, slimAuction, "product")
+	})
+}
+
+// asBytes normalises MessagePack strings, which decode as []byte or string depending on the handle
+func asBytes(value interface{}) []byte {
+	switch v := value.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/cart"
+	"github.com/blytz.live.remake/backend/internal/common"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/internal/orders"
+	"github.com/blytz.live.remake/backend/internal/payments"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// createReservedOrder creates a pending order holding quantity units of a product's stock
+func createReservedOrder(t *testing.T, db *gorm.DB, buyerID, productID uuid.UUID, quantity int, total int64) uuid.UUID {
+	order := models.Order{
+		BaseModel:   common.BaseModel{ID: uuid.New()},
+		UserID:      buyerID,
+		Status:      "pending",
+		TotalAmount: total,
+		Subtotal:    total,
+	}
+	require.NoError(t, db.Create(&order).Error)
+	require.NoError(t, db.Create(&models.OrderItem{
+		OrderID:   order.ID,
+		ProductID: productID,
+		Quantity:  quantity,
+		UnitPrice: total / int64(quantity),
+		Total:     total,
+	}).Error)
+	require.NoError(t, db.Model(&models.InventoryStock{}).
+		Where("product_id = ?", productID).
+		Updates(map[string]interface{}{
+			"reserved":  gorm.Expr("reserved + ?", quantity),
+			"available": gorm.Expr("available - ?", quantity),
+		}).Error)
+	return order.ID
+}
+
+func TestCheckoutPaymentFlow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupPaymentTestDB(t)
+	require.NoError(t, db.AutoMigrate(
+		&models.Product{},
+		&models.OrderItem{},
+		&models.InventoryStock{},
+		&models.StockMovement{},
+	))
+	ctx := context.Background()
+
+	gateway := payments.NewFakeGateway()
+	paymentService := payments.NewService(db, gateway)
+	orderService := orders.NewService(db, cart.NewService(db))
+	orderService.SetPaymentOpener(paymentService)
+	paymentService.SetOrderPaymentHandler(orderService)
+
+	router := gin.New()
+	router.POST("/webhooks/payments", payments.NewHandler(paymentService).ProcessWebhook)
+
+	buyer := uuid.New()
+	product := models.Product{
+		SellerID:      uuid.New(),
+		CategoryID:    uuid.New(),
+		Title:         "Booster box",
+		StartingPrice: 4000,
+		Status:        "active",
+	}
+	require.NoError(t, db.Create(&product).Error)
+	require.NoError(t, db.Create(&models.InventoryStock{ProductID: product.ID, Quantity: 10, Available: 10}).Error)
+
+	stock := func() models.InventoryStock {
+		var s models.InventoryStock
+		require.NoError(t, db.First(&s, "product_id = ?", product.ID).Error)
+		return s
+	}
+	order := func(id uuid.UUID) models.Order {
+		var o models.Order
+		require.NoError(t, db.First(&o, "id = ?", id).Error)
+		return o
+	}
+
+	// A paid order moves to processing and is linked to its payment
+	paidID := createReservedOrder(t, db, buyer, product.ID, 2, 8000)
+	intent, err := paymentService.CreateOrderPaymentIntent(ctx, buyer, paidID, "card")
+	require.NoError(t, err)
+	assert.Equal(t, int64(8000), intent.Amount)
+	require.NotNil(t, intent.OrderID)
+
+	// Asking again returns the intent that is already open
+	again, err := paymentService.CreateOrderPaymentIntent(ctx, buyer, paidID, "card")
+	require.NoError(t, err)
+	assert.Equal(t, intent.ID, again.ID)
+
+	// Other buyers cannot pay for the order
+	_, err = paymentService.CreateOrderPaymentIntent(ctx, uuid.New(), paidID, "card")
+	assert.ErrorIs(t, err, payments.ErrOrderNotPayable)
+
+	payment, err := paymentService.ConfirmPayment(ctx, intent.ID, payments.FakeCardVisa)
+	require.NoError(t, err)
+	require.NotNil(t, payment.OrderID)
+	assert.Equal(t, paidID, *payment.OrderID)
+	paid := order(paidID)
+	assert.Equal(t, "processing", paid.Status)
+	require.NotNil(t, paid.PaymentID)
+	assert.Equal(t, payment.ID, *paid.PaymentID)
+	assert.Equal(t, 2, stock().Reserved)
+
+	// A payment failure reported by webhook leaves the order open for another card
+	failedID := createReservedOrder(t, db, buyer, product.ID, 3, 12000)
+	failedIntent, err := paymentService.CreateOrderPaymentIntent(ctx, buyer, failedID, "card")
+	require.NoError(t, err)
+	assert.Equal(t, 5, stock().Reserved)
+	require.Equal(t, http.StatusOK, sendPaymentWebhook(t, router, gateway, payments.GatewayEvent{
+		ID:        "evt_failed",
+		Type:      payments.EventPaymentFailed,
+		IntentRef: failedIntent.GatewayRef,
+		Status:    payments.IntentRequiresPaymentMethod,
+	}))
+	_, err = paymentService.ProcessPendingWebhooks(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, "pending", order(failedID).Status)
+	assert.Equal(t, 5, stock().Reserved)
+
+	// Cancelling the intent cancels the order and releases its stock
+	require.Equal(t, http.StatusOK, sendPaymentWebhook(t, router, gateway, payments.GatewayEvent{
+		ID:        "evt_canceled",
+		Type:      payments.EventPaymentCanceled,
+		IntentRef: failedIntent.GatewayRef,
+		Status:    payments.IntentCanceled,
+	}))
+	_, err = paymentService.ProcessPendingWebhooks(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, "cancelled", order(failedID).Status)
+	assert.Equal(t, 2, stock().Reserved)
+	assert.Equal(t, 8, stock().Available)
+
+	// An unpaid intent expires, releasing its order; paid orders are untouched
+	expiringID := createReservedOrder(t, db, buyer, product.ID, 1, 4000)
+	_, err = paymentService.CreateOrderPaymentIntent(ctx, buyer, expiringID, "card")
+	require.NoError(t, err)
+	expired, err := paymentService.ExpirePaymentIntents(ctx, time.Now().Add(payments.PaymentIntentTTL+time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	assert.Equal(t, "cancelled", order(expiringID).Status)
+	assert.Equal(t, "processing", order(paidID).Status)
+	assert.Equal(t, 2, stock().Reserved)
+
+	var releases int64
+	require.NoError(t, db.Model(&models.StockMovement{}).Where("movement_type = ?", "release").Count(&releases).Error)
+	assert.Equal(t, int64(2), releases)
+}
@@ -0,0 +1,233 @@
+package tests
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/cart"
+	"github.com/blytz.live.remake/backend/internal/credits"
+	"github.com/blytz.live.remake/backend/internal/ledger"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/internal/orders"
+	"github.com/blytz.live.remake/backend/internal/payments"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGiftCardsAndStoreCredit(t *testing.T) {
+	db := setupPaymentTestDB(t)
+	require.NoError(t, db.AutoMigrate(
+		&models.Product{},
+		&models.OrderItem{},
+		&models.InventoryStock{},
+		&models.StockMovement{},
+		&models.Cart{},
+		&models.CartItem{},
+		&models.OrderFee{},
+		&models.GiftCard{},
+		&models.StoreCreditWallet{},
+		&models.CreditMovement{},
+		&models.Transaction{},
+		&models.LedgerAccount{},
+		&models.LedgerEntry{},
+	))
+	ctx := context.Background()
+
+	ledgerService := ledger.NewService(db)
+	paymentService := payments.NewService(db, payments.NewFakeGateway())
+	paymentService.SetLedger(ledgerService)
+	cartService := cart.NewService(db)
+	orderService := orders.NewService(db, cartService)
+	orderService.SetPaymentOpener(paymentService)
+	paymentService.SetOrderPaymentHandler(orderService)
+	creditService := credits.NewService(db)
+	creditService.SetLedger(ledgerService)
+	orderService.SetCreditRedeemer(creditService)
+	paymentService.SetStoreCredit(creditService)
+
+	admin := uuid.New()
+	buyer := uuid.New()
+	seller := uuid.New()
+	product := models.Product{
+		SellerID:      seller,
+		CategoryID:    uuid.New(),
+		Title:         "Sealed booster box",
+		StartingPrice: 4000,
+		Status:        "active",
+	}
+	require.NoError(t, db.Create(&product).Error)
+	require.NoError(t, db.Create(&models.InventoryStock{ProductID: product.ID, Quantity: 10, Available: 10}).Error)
+
+	// Each checkout buys one box for 40 + 3.20 tax + 5.99 shipping
+	const total int64 = 4919
+	checkout := func(giftCardCode string, useStoreCredit bool) (*orders.OrderResponse, error) {
+		userCart, err := cartService.GetOrCreateCart(&buyer, nil)
+		require.NoError(t, err)
+		_, err = cartService.AddItem(userCart.ID, cart.AddItemRequest{ProductID: product.ID, Quantity: 1})
+		require.NoError(t, err)
+		address := orders.Address{AddressLine1: "1 Main St", City: "Springfield", PostalCode: "62701", Country: "US"}
+		return orderService.CreateOrder(buyer, orders.OrderCreateRequest{
+			CartID:          userCart.ID,
+			ShippingAddress: address,
+			BillingAddress:  address,
+			PaymentMethod:   "card",
+			GiftCardCode:    giftCardCode,
+			UseStoreCredit:  useStoreCredit,
+		})
+	}
+	walletBalance := func() int64 {
+		wallets, err := creditService.Wallets(ctx, buyer)
+		require.NoError(t, err)
+		require.Len(t, wallets, 1)
+		return wallets[0].Balance
+	}
+
+	card, err := creditService.IssueGiftCard(ctx, admin, credits.IssueGiftCardRequest{Amount: 3000})
+	require.NoError(t, err)
+	assert.Len(t, card.Code, 19)
+	assert.Equal(t, "USD", card.Currency)
+	_, err = creditService.IssueGiftCard(ctx, admin, credits.IssueGiftCardRequest{Amount: 1000, Currency: "XYZ"})
+	assert.Error(t, err)
+
+	_, err = creditService.GrantStoreCredit(ctx, admin, credits.GrantStoreCreditRequest{UserID: buyer, Amount: 2000})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2000), walletBalance())
+
+	// The gift card, entered without dashes, pays part of the order and a card the rest
+	giftCardOrder, err := checkout(strings.ToLower(strings.ReplaceAll(card.Code, "-", "")), false)
+	require.NoError(t, err)
+	assert.Equal(t, total, giftCardOrder.TotalAmount)
+	assert.Equal(t, int64(3000), giftCardOrder.CreditAmount)
+	require.NotNil(t, giftCardOrder.Payment)
+	assert.Equal(t, int64(1919), giftCardOrder.Payment.Amount)
+	payment, err := paymentService.ConfirmPayment(ctx, giftCardOrder.Payment.PaymentIntentID, payments.FakeCardVisa)
+	require.NoError(t, err)
+	var paid models.Order
+	require.NoError(t, db.First(&paid, "id = ?", giftCardOrder.ID).Error)
+	assert.Equal(t, "processing", paid.Status)
+
+	used, err := creditService.LookupGiftCard(ctx, card.Code)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), used.Balance)
+
+	// A spent gift card cannot be used again, and nothing else is applied
+	_, err = checkout(card.Code, true)
+	assert.ErrorIs(t, err, credits.ErrGiftCardUnusable)
+	assert.Equal(t, int64(2000), walletBalance())
+	_, err = checkout("NOPE-NOPE-NOPE-NOPE", false)
+	assert.ErrorIs(t, err, credits.ErrGiftCardNotFound)
+
+	// Store credit applied to an order released unpaid is returned, once
+	released, err := checkout("", true)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2000), released.CreditAmount)
+	assert.Equal(t, int64(2919), released.Payment.Amount)
+	assert.Equal(t, int64(0), walletBalance())
+	require.NoError(t, orderService.ReleaseOrder(ctx, released.ID, "payment_expired"))
+	require.NoError(t, orderService.ReleaseOrder(ctx, released.ID, "payment_expired"))
+	require.NoError(t, creditService.RestoreOrderCredit(ctx, db, released.ID))
+	assert.Equal(t, int64(2000), walletBalance())
+
+	// Enough store credit pays for the whole order without a card
+	_, err = creditService.GrantStoreCredit(ctx, admin, credits.GrantStoreCreditRequest{UserID: buyer, Amount: 4000})
+	require.NoError(t, err)
+	creditOrder, err := checkout("", true)
+	require.NoError(t, err)
+	assert.Nil(t, creditOrder.Payment)
+	assert.Equal(t, "processing", creditOrder.Status)
+	assert.Equal(t, total, creditOrder.CreditAmount)
+	assert.Equal(t, int64(1081), walletBalance())
+
+	// A refund to store credit skips the gateway and grows the wallet
+	refund, err := paymentService.RequestRefund(ctx, payments.RefundRequest{
+		PaymentID:   payment.ID,
+		Amount:      1000,
+		Reason:      "requested_by_customer",
+		RequestedBy: admin,
+		StoreCredit: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, payments.RefundSucceeded, refund.Status)
+	assert.Equal(t, payments.RefundMethodStoreCredit, refund.Method)
+	assert.Empty(t, refund.GatewayRef)
+	assert.Equal(t, int64(2081), walletBalance())
+
+	movements, count, err := creditService.ListMovements(ctx, buyer, 1, 20)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), count)
+	assert.Equal(t, credits.MovementRefund, movements[0].Type)
+
+	// Refunds are capped at the order total. The card is refunded first, as the refund to
+	// store credit above left its payment untouched, and the rest goes to store credit.
+	_, err = paymentService.RequestRefund(ctx, payments.RefundRequest{
+		PaymentID:   payment.ID,
+		Amount:      3920,
+		Reason:      "requested_by_customer",
+		RequestedBy: admin,
+	})
+	assert.ErrorIs(t, err, payments.ErrRefundExceedsPayment)
+	refund, err = paymentService.RequestRefund(ctx, payments.RefundRequest{
+		PaymentID:   payment.ID,
+		Amount:      3919,
+		Reason:      "requested_by_customer",
+		RequestedBy: admin,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, payments.RefundSucceeded, refund.Status)
+	assert.NotEmpty(t, refund.GatewayRef)
+	assert.Equal(t, int64(2000), refund.CreditAmount)
+	assert.Equal(t, int64(4081), walletBalance())
+	var refunded models.Payment
+	require.NoError(t, db.First(&refunded, "id = ?", payment.ID).Error)
+	assert.Equal(t, "refunded", refunded.Status)
+	assert.Equal(t, int64(1919), refunded.RefundedAmount)
+
+	// An order paid in full with credit has a payment to refund to store credit
+	var creditPaid models.Order
+	require.NoError(t, db.First(&creditPaid, "id = ?", creditOrder.ID).Error)
+	require.NotNil(t, creditPaid.PaymentID)
+	refund, err = paymentService.RequestRefund(ctx, payments.RefundRequest{
+		PaymentID:   *creditPaid.PaymentID,
+		Amount:      2000,
+		Reason:      "requested_by_customer",
+		RequestedBy: admin,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, payments.RefundSucceeded, refund.Status)
+	assert.Empty(t, refund.GatewayRef)
+	assert.Equal(t, int64(2000), refund.CreditAmount)
+	assert.Equal(t, int64(6081), walletBalance())
+
+	// Expired gift cards lose their balance to breakage
+	expiresAt := time.Now().Add(time.Hour)
+	expiring, err := creditService.IssueGiftCard(ctx, admin, credits.IssueGiftCardRequest{Amount: 2500, ExpiresAt: &expiresAt})
+	require.NoError(t, err)
+	expired, err := creditService.ExpireGiftCards(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, expired)
+	expired, err = creditService.ExpireGiftCards(ctx, expiresAt)
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	expiredCard, _, err := creditService.GetGiftCard(ctx, expiring.ID)
+	require.NoError(t, err)
+	assert.Equal(t, credits.GiftCardExpired, expiredCard.Status)
+	assert.Equal(t, int64(0), expiredCard.Balance)
+
+	// The ledger mirrors every balance
+	balance := func(account ledger.Account) int64 {
+		b, err := ledgerService.Balance(ctx, account, "USD")
+		require.NoError(t, err)
+		return b.Amount
+	}
+	assert.Equal(t, int64(6081), balance(ledger.StoreCreditAccount(buyer)))
+	assert.Equal(t, int64(0), balance(ledger.GiftCardsAccount()))
+	assert.Equal(t, int64(0), balance(ledger.BuyerAccount(buyer)))
+	assert.Equal(t, int64(2500), balance(ledger.BreakageAccount()))
+	assert.Equal(t, int64(11500), balance(ledger.PromotionsAccount()))
+	report, err := ledgerService.CheckInvariants(ctx)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), report.Violations)
+}
@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/cart"
+	"github.com/blytz.live.remake/backend/internal/ledger"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/internal/orders"
+	"github.com/blytz.live.remake/backend/internal/payments"
+	"github.com/blytz.live.remake/backend/internal/payouts"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisputeWorkflow(t *testing.T) {
+	db := setupPaymentTestDB(t)
+	require.NoError(t, db.AutoMigrate(
+		&models.Product{},
+		&models.OrderItem{},
+		&models.OrderFee{},
+		&models.Transaction{},
+		&models.LedgerAccount{},
+		&models.LedgerEntry{},
+		&models.Payout{},
+		&models.PayoutItem{},
+		&models.PayoutSettings{},
+	))
+	ctx := context.Background()
+	now := time.Now()
+
+	ledgerService := ledger.NewService(db)
+	gateway := payments.NewFakeGateway()
+	paymentService := payments.NewService(db, gateway)
+	paymentService.SetLedger(ledgerService)
+	paymentService.SetOrderPaymentHandler(orders.NewService(db, cart.NewService(db)))
+	payoutService := payouts.NewService(db, payouts.NewFakeProvider(), 7*24*time.Hour)
+	payoutService.SetLedger(ledgerService)
+	balance := func(account ledger.Account) int64 {
+		b, err := ledgerService.Balance(ctx, account, "USD")
+		require.NoError(t, err)
+		return b.Amount
+	}
+
+	seller := uuid.New()
+	_, payment := createDeliveredOrder(t, db, paymentService, seller, 10000, now.Add(-10*24*time.Hour))
+	_, err := payoutService.UpdateSettings(ctx, seller, payouts.UpdateSettingsRequest{Schedule: payouts.ScheduleManual, Destination: "acct_seller"})
+	require.NoError(t, err)
+
+	// A chargeback opens a dispute, withdraws the funds and holds the seller's payouts
+	opened := payments.GatewayEvent{
+		ID:            "evt_dispute_1",
+		Type:          payments.EventDisputeCreated,
+		IntentRef:     payment.GatewayRef,
+		DisputeRef:    "dp_fake_1",
+		Status:        payments.DisputeNeedsResponse,
+		Amount:        10000,
+		Reason:        "product_not_received",
+		EvidenceDueBy: now.Add(7 * 24 * time.Hour).Unix(),
+	}
+	require.NoError(t, paymentService.ProcessWebhook(ctx, &opened))
+	require.NoError(t, paymentService.ProcessWebhook(ctx, &opened))
+
+	disputes, total, err := paymentService.ListDisputes(ctx, payments.DisputeFilter{SellerID: &seller, OpenOnly: true}, 1, 20)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), total)
+	dispute := disputes[0]
+	assert.Equal(t, int64(10000), dispute.Amount)
+	require.NotNil(t, dispute.EvidenceDueBy)
+
+	var disputed models.Payment
+	require.NoError(t, db.First(&disputed, "id = ?", payment.ID).Error)
+	assert.Equal(t, "disputed", disputed.Status)
+	assert.Equal(t, int64(10000), balance(ledger.DisputesAccount()))
+	_, err = payoutService.CreatePayouts(ctx, seller, seller, now)
+	assert.ErrorIs(t, err, payouts.ErrPayoutsOnHold)
+
+	// Only the order's sellers contest it, once
+	outsider := uuid.New()
+	evidence := payments.DisputeEvidenceRequest{
+		TrackingNumber: stringPtr("1Z999AA10123456784"),
+		Carrier:        stringPtr("UPS"),
+		Message:        "Delivered to the buyer's front door",
+		Photos:         []string{"https://cdn.example.com/proof.jpg"},
+	}
+	_, err = paymentService.SubmitDisputeEvidence(ctx, dispute.ID, outsider, &outsider, evidence)
+	assert.ErrorIs(t, err, payments.ErrDisputeNotAllowed)
+	contested, err := paymentService.SubmitDisputeEvidence(ctx, dispute.ID, seller, &seller, evidence)
+	require.NoError(t, err)
+	assert.Equal(t, payments.DisputeUnderReview, contested.Status)
+	require.Len(t, contested.Evidence, 1)
+	submitted, ok := gateway.SubmittedEvidence("dp_fake_1")
+	require.True(t, ok)
+	assert.Equal(t, "1Z999AA10123456784", submitted.TrackingNumber)
+	assert.Contains(t, submitted.Text, "https://cdn.example.com/proof.jpg")
+	_, err = paymentService.SubmitDisputeEvidence(ctx, dispute.ID, seller, &seller, evidence)
+	assert.ErrorIs(t, err, payments.ErrDisputeClosed)
+
+	// Disputes under review cannot be settled without an outcome
+	_, err = paymentService.ResolveDispute(ctx, dispute.ID, uuid.New(), payments.ResolveDisputeRequest{})
+	assert.ErrorIs(t, err, payments.ErrInvalidDisputeOutcome)
+
+	// Once the gateway closes it, the outcome must match
+	closed := opened
+	closed.ID, closed.Type, closed.Status = "evt_dispute_2", payments.EventDisputeClosed, payments.DisputeLost
+	require.NoError(t, paymentService.ProcessWebhook(ctx, &closed))
+	_, err = paymentService.ResolveDispute(ctx, dispute.ID, uuid.New(), payments.ResolveDisputeRequest{Outcome: payments.DisputeWon})
+	assert.ErrorIs(t, err, payments.ErrInvalidDisputeOutcome)
+
+	// A lost dispute is charged to the seller and releases the hold
+	resolved, err := paymentService.ResolveDispute(ctx, dispute.ID, uuid.New(), payments.ResolveDisputeRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, payments.DisputeLost, resolved.Status)
+	require.NotNil(t, resolved.Liability)
+	assert.Equal(t, ledger.LiabilitySeller, *resolved.Liability)
+	_, err = paymentService.ResolveDispute(ctx, dispute.ID, uuid.New(), payments.ResolveDisputeRequest{})
+	assert.ErrorIs(t, err, payments.ErrDisputeResolved)
+
+	require.NoError(t, db.First(&disputed, "id = ?", payment.ID).Error)
+	assert.Equal(t, "charged_back", disputed.Status)
+	assert.Equal(t, int64(0), balance(ledger.DisputesAccount()))
+	assert.Equal(t, int64(0), balance(ledger.SellerAccount(seller)))
+
+	onHold, err := payoutService.OnHold(ctx, seller)
+	require.NoError(t, err)
+	assert.False(t, onHold)
+	_, err = payoutService.CreatePayouts(ctx, seller, seller, now)
+	assert.ErrorIs(t, err, payouts.ErrNothingToPayOut)
+
+	report, err := ledgerService.CheckInvariants(ctx)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), report.Violations)
+}
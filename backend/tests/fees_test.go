@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/common"
+	"github.com/blytz.live.remake/backend/internal/fees"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/pkg/money"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeeRuleEvaluation(t *testing.T) {
+	cards := uuid.New()
+	pro := "pro"
+	created := time.Now()
+	rule := func(r models.FeeRule) models.FeeRule {
+		r.BaseModel = common.BaseModel{ID: uuid.New(), CreatedAt: created}
+		r.IsActive = true
+		if r.Kind == "" {
+			r.Kind = "commission"
+		}
+		if r.SaleType == "" {
+			r.SaleType = fees.SaleAny
+		}
+		return r
+	}
+
+	rules := []models.FeeRule{
+		rule(models.FeeRule{Name: "Standard", Type: fees.TypePercentage, Percent: 10}),
+		rule(models.FeeRule{Name: "Cards", Type: fees.TypePercentage, Percent: 8, CategoryID: &cards}),
+		rule(models.FeeRule{Name: "Pro sellers", Type: fees.TypePercentage, Percent: 5, SellerTier: &pro}),
+		rule(models.FeeRule{Name: "Pro cards at auction", Type: fees.TypePercentage, Percent: 4, SellerTier: &pro, CategoryID: &cards, SaleType: fees.SaleAuction}),
+		rule(models.FeeRule{Name: "Auctions", Type: fees.TypePercentage, Percent: 12, SaleType: fees.SaleAuction, MaxAmount: 5000}),
+		rule(models.FeeRule{Name: "Processing", Kind: "processing", Type: fees.TypeFixed, FixedAmount: 30, Currency: "USD"}),
+		rule(models.FeeRule{Name: "Retired", Type: fees.TypePercentage, Percent: 50, CategoryID: &cards, SellerTier: &pro}),
+	}
+	rules[6].IsActive = false
+
+	line := func(category uuid.UUID, tier, saleType string, total int64, currency string) fees.Line {
+		return fees.Line{OrderItemID: uuid.New(), SellerID: uuid.New(), CategoryID: category, SellerTier: tier, SaleType: saleType, Total: money.New(total, currency)}
+	}
+	charged := func(l fees.Line) map[string]string {
+		result := make(map[string]string)
+		for _, fee := range fees.Evaluate(rules, []fees.Line{l}) {
+			result[fee.Kind] = fee.Description + " " + money.New(fee.Amount, fee.Currency).String()
+		}
+		return result
+	}
+
+	// Kinds add up; within a kind the most specific rule wins
+	assert.Equal(t, map[string]string{"commission": "Standard 10.00 USD", "processing": "Processing 0.30 USD"},
+		charged(line(uuid.New(), "standard", fees.SaleFixedPrice, 10000, "USD")))
+	assert.Equal(t, "Cards 8.00 USD", charged(line(cards, "standard", fees.SaleFixedPrice, 10000, "USD"))["commission"])
+	assert.Equal(t, "Pro sellers 5.00 USD", charged(line(uuid.New(), pro, fees.SaleFixedPrice, 10000, "USD"))["commission"])
+	assert.Equal(t, "Pro cards at auction 4.00 USD", charged(line(cards, pro, fees.SaleAuction, 10000, "USD"))["commission"])
+
+	// Capped rules stop at their maximum
+	assert.Equal(t, "Auctions 50.00 USD", charged(line(uuid.New(), "standard", fees.SaleAuction, 100000, "USD"))["commission"])
+
+	// Fixed fees only apply in their own currency
+	assert.Equal(t, map[string]string{"commission": "Standard 120 JPY"},
+		charged(line(uuid.New(), "standard", fees.SaleFixedPrice, 1200, "JPY")))
+}
+
+func TestSeedDefaultFeeRule(t *testing.T) {
+	db := setupPaymentTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.FeeRule{}))
+	ctx := context.Background()
+	service := fees.NewService(db)
+
+	created, err := service.SeedDefaultRule(ctx)
+	require.NoError(t, err)
+	assert.True(t, created)
+	rules, err := service.ListRules(ctx, true)
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, 10.0, rules[0].Percent)
+
+	// Deleting the commission does not bring it back on the next start
+	require.NoError(t, service.DeleteRule(ctx, fees.DefaultRuleID))
+	created, err = service.SeedDefaultRule(ctx)
+	require.NoError(t, err)
+	assert.False(t, created)
+	rules, err = service.ListRules(ctx, false)
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+}
@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/blytz.live.remake/backend/internal/middleware"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	require.NoError(t, db.AutoMigrate(&models.IdempotencyKey{}))
+
+	created := 0
+	failing := true
+	panicking := true
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.Use(func(c *gin.Context) {
+		c.Set("user_id", uuid.MustParse(c.GetHeader("X-User")))
+		c.Next()
+	})
+	idempotent := middleware.Idempotency(middleware.NewDBIdempotencyStore(db))
+	router.POST("/orders", idempotent, func(c *gin.Context) {
+		created++
+		c.JSON(http.StatusCreated, gin.H{"order": created})
+	})
+	router.POST("/flaky", idempotent, func(c *gin.Context) {
+		if failing {
+			failing = false
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "try again"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+	router.POST("/panicky", idempotent, func(c *gin.Context) {
+		if panicking {
+			panicking = false
+			panic("handler bug")
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	// With Redis unreachable, keys are reserved and completed in the database
+	unreachable := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	defer unreachable.Close()
+	router.POST("/fallback", middleware.Idempotency(middleware.NewIdempotencyStore(unreachable, db)), func(c *gin.Context) {
+		created++
+		c.JSON(http.StatusCreated, gin.H{"order": created})
+	})
+
+	user := uuid.New().String()
+	send := func(path, userID, key, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("X-User", userID)
+		if key != "" {
+			req.Header.Set(middleware.IdempotencyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := send("/orders", user, "key-1", `{"cart":"a"}`)
+	require.Equal(t, http.StatusCreated, first.Code)
+
+	// A retry replays the stored response without running the handler again
+	retry := send("/orders", user, "key-1", `{"cart":"a"}`)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, 1, created)
+
+	// Reusing the key for a different request is rejected
+	assert.Equal(t, http.StatusUnprocessableEntity, send("/orders", user, "key-1", `{"cart":"b"}`).Code)
+	assert.Equal(t, 1, created)
+
+	// Keys are scoped per user, and requests without a key are not deduplicated
+	assert.Equal(t, http.StatusCreated, send("/orders", uuid.New().String(), "key-1", `{"cart":"a"}`).Code)
+	assert.Equal(t, http.StatusCreated, send("/orders", user, "", `{"cart":"a"}`).Code)
+	assert.Equal(t, 3, created)
+
+	// Server errors are not stored, so the retry runs the handler again
+	assert.Equal(t, http.StatusInternalServerError, send("/flaky", user, "key-2", `{}`).Code)
+	assert.Equal(t, http.StatusOK, send("/flaky", user, "key-2", `{}`).Code)
+
+	// A handler that panics releases its key, so the retry runs
+	assert.Equal(t, http.StatusInternalServerError, send("/panicky", user, "key-3", `{}`).Code)
+	assert.Equal(t, http.StatusOK, send("/panicky", user, "key-3", `{}`).Code)
+
+	stored := send("/fallback", user, "key-4", `{}`)
+	require.Equal(t, http.StatusCreated, stored.Code)
+	replayed := send("/fallback", user, "key-4", `{}`)
+	assert.Equal(t, "true", replayed.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, stored.Body.String(), replayed.Body.String())
+}
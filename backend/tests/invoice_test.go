@@ -0,0 +1,252 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/blytz.live.remake/backend/internal/cart"
+	"github.com/blytz.live.remake/backend/internal/invoices"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/internal/orders"
+	"github.com/blytz.live.remake/backend/internal/payments"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvoicesAndCreditNotes(t *testing.T) {
+	db := setupPaymentTestDB(t)
+	require.NoError(t, db.AutoMigrate(
+		&models.Product{},
+		&models.OrderItem{},
+		&models.InventoryStock{},
+		&models.StockMovement{},
+		&models.Cart{},
+		&models.CartItem{},
+		&models.OrderFee{},
+		&models.Invoice{},
+		&models.InvoiceLine{},
+		&models.InvoiceSequence{},
+		&models.InvoiceProfile{},
+	))
+	ctx := context.Background()
+
+	paymentService := payments.NewService(db, payments.NewFakeGateway())
+	cartService := cart.NewService(db)
+	orderService := orders.NewService(db, cartService)
+	orderService.SetPaymentOpener(paymentService)
+	paymentService.SetOrderPaymentHandler(orderService)
+	invoiceService := invoices.NewService(db)
+	orderService.SetInvoicer(invoiceService)
+
+	buyer := models.User{Email: "anna@example.de", PasswordHash: "x", Role: "buyer"}
+	sellerA := models.User{Email: "cards@example.com", PasswordHash: "x", Role: "seller"}
+	sellerB := models.User{Email: "comics@example.com", PasswordHash: "x", Role: "seller"}
+	for _, user := range []*models.User{&buyer, &sellerA, &sellerB} {
+		require.NoError(t, db.Create(user).Error)
+	}
+
+	// The buyer invoices a VAT-registered business; seller A has a legal name and VAT number
+	buyerTaxID := "de 123 456 789"
+	profile, err := invoiceService.UpdateProfile(ctx, buyer.ID, invoices.ProfileRequest{LegalName: "Anna Schmidt GmbH", TaxID: &buyerTaxID})
+	require.NoError(t, err)
+	assert.Equal(t, "DE123456789", *profile.TaxID)
+	sellerTaxID := "GB123456789"
+	_, err = invoiceService.UpdateProfile(ctx, sellerA.ID, invoices.ProfileRequest{LegalName: "Card Shop Ltd", TaxID: &sellerTaxID})
+	require.NoError(t, err)
+
+	newProduct := func(sellerID uuid.UUID, title string, price int64) models.Product {
+		product := models.Product{SellerID: sellerID, CategoryID: uuid.New(), Title: title, StartingPrice: price, Status: "active"}
+		require.NoError(t, db.Create(&product).Error)
+		require.NoError(t, db.Create(&models.InventoryStock{ProductID: product.ID, Quantity: 10, Available: 10}).Error)
+		return product
+	}
+	boosterBox := newProduct(sellerA.ID, "Sealed booster box", 4000)
+	comic := newProduct(sellerB.ID, "First issue comic", 1000)
+
+	checkout := func(items map[uuid.UUID]int) *models.Payment {
+		userCart, err := cartService.GetOrCreateCart(&buyer.ID, nil)
+		require.NoError(t, err)
+		for productID, quantity := range items {
+			_, err = cartService.AddItem(userCart.ID, cart.AddItemRequest{ProductID: productID, Quantity: quantity})
+			require.NoError(t, err)
+		}
+		address := orders.Address{FirstName: "Anna", LastName: "Schmidt", AddressLine1: "Hauptstr. 1", City: "Berlin", PostalCode: "10115", Country: "DE"}
+		order, err := orderService.CreateOrder(buyer.ID, orders.OrderCreateRequest{
+			CartID:          userCart.ID,
+			ShippingAddress: address,
+			BillingAddress:  address,
+			PaymentMethod:   "card",
+		})
+		require.NoError(t, err)
+
+		// Nothing is invoiced until the order is paid
+		list, _, err := invoiceService.ListInvoices(ctx, invoices.InvoiceFilter{OrderID: &order.ID}, 1, 20)
+		require.NoError(t, err)
+		assert.Empty(t, list)
+
+		payment, err := paymentService.ConfirmPayment(ctx, order.Payment.PaymentIntentID, payments.FakeCardVisa)
+		require.NoError(t, err)
+		return payment
+	}
+	invoicesOf := func(orderID uuid.UUID, invoiceType string) []models.Invoice {
+		list, _, err := invoiceService.ListInvoices(ctx, invoices.InvoiceFilter{OrderID: &orderID, Type: invoiceType}, 1, 20)
+		require.NoError(t, err)
+		return list
+	}
+
+	// 40 + 2 x 10 with 19% German VAT and international shipping, invoiced by each seller
+	payment := checkout(map[uuid.UUID]int{boosterBox.ID: 1, comic.ID: 2})
+	var order models.Order
+	require.NoError(t, db.First(&order, "id = ?", *payment.OrderID).Error)
+	assert.Equal(t, int64(1140), order.TaxAmount)
+
+	issued := invoicesOf(order.ID, invoices.TypeInvoice)
+	require.Len(t, issued, 2)
+	bySeller := make(map[uuid.UUID]models.Invoice)
+	var tax, shipping, total int64
+	for _, invoice := range issued {
+		bySeller[invoice.SellerID] = invoice
+		assert.Equal(t, "INV-000001", invoice.Number)
+		assert.Equal(t, 0.19, invoice.TaxRate)
+		assert.Equal(t, "Anna Schmidt GmbH", invoice.BuyerName)
+		assert.Equal(t, "DE123456789", *invoice.BuyerTaxID)
+		require.NotNil(t, invoice.ShippingAddress)
+		assert.Equal(t, "Berlin", invoice.ShippingAddress.City)
+		tax += invoice.TaxAmount
+		shipping += invoice.ShippingCost
+		total += invoice.Total
+	}
+	assert.Equal(t, order.TaxAmount, tax)
+	assert.Equal(t, order.ShippingCost, shipping)
+	assert.Equal(t, order.TotalAmount, total)
+
+	cardShop := bySeller[sellerA.ID]
+	assert.Equal(t, "Card Shop Ltd", cardShop.SellerName)
+	assert.Equal(t, int64(4000), cardShop.Subtotal)
+	assert.Equal(t, int64(760), cardShop.TaxAmount)
+	comicShop := bySeller[sellerB.ID]
+	assert.Equal(t, "comics@example.com", comicShop.SellerName)
+	assert.Nil(t, comicShop.SellerTaxID)
+	assert.Equal(t, int64(2000), comicShop.Subtotal)
+	assert.Equal(t, int64(380), comicShop.TaxAmount)
+
+	// Marking the order paid again issues nothing more
+	require.NoError(t, orderService.MarkOrderPaid(ctx, order.ID, payment.ID))
+	assert.Len(t, invoicesOf(order.ID, invoices.TypeInvoice), 2)
+
+	// Each seller numbers their invoices in sequence
+	second := checkout(map[uuid.UUID]int{boosterBox.ID: 1})
+	secondInvoices := invoicesOf(*second.OrderID, invoices.TypeInvoice)
+	require.Len(t, secondInvoices, 1)
+	assert.Equal(t, "INV-000002", secondInvoices[0].Number)
+
+	// Refunding a comic credits its price as gross of VAT against seller B's invoice
+	var comicItem models.OrderItem
+	require.NoError(t, db.First(&comicItem, "order_id = ? AND product_id = ?", order.ID, comic.ID).Error)
+	_, err = paymentService.RequestRefund(ctx, payments.RefundRequest{
+		PaymentID:   payment.ID,
+		Items:       []payments.RefundItemRequest{{OrderItemID: comicItem.ID, Quantity: 1}},
+		Reason:      "requested_by_customer",
+		RequestedBy: buyer.ID,
+	})
+	require.NoError(t, err)
+	creditNotes := invoicesOf(order.ID, invoices.TypeCreditNote)
+	require.Len(t, creditNotes, 1)
+	creditNote, err := invoiceService.GetInvoice(ctx, creditNotes[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "CN-000001", creditNote.Number)
+	assert.Equal(t, sellerB.ID, creditNote.SellerID)
+	assert.Equal(t, comicShop.ID, *creditNote.OriginalInvoiceID)
+	assert.Equal(t, int64(840), creditNote.Subtotal)
+	assert.Equal(t, int64(160), creditNote.TaxAmount)
+	assert.Equal(t, int64(1000), creditNote.Total)
+	require.Len(t, creditNote.Lines, 1)
+	assert.Equal(t, "First issue comic", creditNote.Lines[0].Description)
+
+	// An amount refund is shared across both sellers' invoices
+	_, err = paymentService.RequestRefund(ctx, payments.RefundRequest{
+		PaymentID:   payment.ID,
+		Amount:      1500,
+		Reason:      "requested_by_customer",
+		RequestedBy: buyer.ID,
+	})
+	require.NoError(t, err)
+	creditNotes = invoicesOf(order.ID, invoices.TypeCreditNote)
+	require.Len(t, creditNotes, 3)
+	var credited int64
+	numbers := make(map[uuid.UUID][]string)
+	for _, note := range creditNotes {
+		numbers[note.SellerID] = append(numbers[note.SellerID], note.Number)
+		if note.ID != creditNote.ID {
+			credited += note.Total
+
+			// The shipping part is credited without VAT
+			assert.Greater(t, note.ShippingCost, int64(0))
+			assert.InDelta(t, float64(note.Subtotal)*0.19, float64(note.TaxAmount), 1)
+			assert.Equal(t, note.Total, note.Subtotal+note.TaxAmount+note.ShippingCost)
+		}
+	}
+	assert.Equal(t, int64(1500), credited)
+	assert.Equal(t, []string{"CN-000001"}, numbers[sellerA.ID])
+	assert.ElementsMatch(t, []string{"CN-000001", "CN-000002"}, numbers[sellerB.ID])
+
+	// The buyer sees their documents; each seller sees only their own
+	_, count, err := invoiceService.ListInvoices(ctx, invoices.InvoiceFilter{BuyerID: &buyer.ID}, 1, 20)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), count)
+	_, count, err = invoiceService.ListInvoices(ctx, invoices.InvoiceFilter{SellerID: &sellerB.ID}, 1, 20)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	// Renderings
+	invoice, err := invoiceService.GetInvoice(ctx, cardShop.ID)
+	require.NoError(t, err)
+	var html bytes.Buffer
+	require.NoError(t, invoices.WriteHTML(&html, invoice, nil))
+	assert.Contains(t, html.String(), "INV-000001")
+	assert.Contains(t, html.String(), "VAT 19%")
+	assert.Contains(t, html.String(), "DE123456789")
+	assert.Contains(t, html.String(), "Sealed booster box")
+
+	html.Reset()
+	require.NoError(t, invoices.WriteHTML(&html, creditNote, &comicShop))
+	assert.Contains(t, html.String(), "Credit note")
+	assert.Contains(t, html.String(), "Corrects invoice INV-000001")
+
+	var pdf bytes.Buffer
+	require.NoError(t, invoices.WritePDF(&pdf, invoice, nil))
+	assert.True(t, strings.HasPrefix(pdf.String(), "%PDF-"))
+	assert.Contains(t, pdf.String(), "(No. INV-000001 ")
+	assert.True(t, strings.HasSuffix(pdf.String(), "%%EOF\n"))
+
+	// A seller established in another member state sells to the VAT-registered buyer
+	// under the reverse charge: no VAT is charged and the invoice says why
+	sellerC := models.User{Email: "stamps@example.fr", PasswordHash: "x", Role: "seller"}
+	require.NoError(t, db.Create(&sellerC).Error)
+	_, err = invoiceService.UpdateProfile(ctx, sellerC.ID, invoices.ProfileRequest{
+		LegalName: "Timbres SARL",
+		Address:   &models.Address{AddressLine1: "1 rue de Rivoli", City: "Paris", PostalCode: "75001", Country: "FR"},
+	})
+	require.NoError(t, err)
+	stamp := newProduct(sellerC.ID, "Rare stamp", 3000)
+	// Their sequence was already created by a concurrent first invoice, which does not
+	// stop this one from taking the next number
+	require.NoError(t, db.Create(&models.InvoiceSequence{SellerID: sellerC.ID, Series: "INV"}).Error)
+	reverse := checkout(map[uuid.UUID]int{stamp.ID: 1})
+	var reverseOrder models.Order
+	require.NoError(t, db.First(&reverseOrder, "id = ?", *reverse.OrderID).Error)
+	assert.Equal(t, int64(0), reverseOrder.TaxAmount)
+	reverseInvoices := invoicesOf(reverseOrder.ID, invoices.TypeInvoice)
+	require.Len(t, reverseInvoices, 1)
+	assert.Equal(t, "INV-000001", reverseInvoices[0].Number)
+	assert.True(t, reverseInvoices[0].ReverseCharge)
+	assert.Equal(t, 0.0, reverseInvoices[0].TaxRate)
+	assert.Equal(t, int64(0), reverseInvoices[0].TaxAmount)
+	html.Reset()
+	require.NoError(t, invoices.WriteHTML(&html, &reverseInvoices[0], nil))
+	assert.Contains(t, html.String(), "VAT 0%")
+	assert.Contains(t, html.String(), "Reverse charge")
+}
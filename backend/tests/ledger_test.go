@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/blytz.live.remake/backend/internal/common"
+	"github.com/blytz.live.remake/backend/internal/ledger"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/internal/payments"
+	"github.com/blytz.live.remake/backend/pkg/money"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLedgerPostings(t *testing.T) {
+	db := setupPaymentTestDB(t)
+	require.NoError(t, db.AutoMigrate(
+		&models.Product{},
+		&models.OrderItem{},
+		&models.Transaction{},
+		&models.LedgerAccount{},
+		&models.LedgerEntry{},
+	))
+	ctx := context.Background()
+
+	ledgerService := ledger.NewService(db)
+	gateway := payments.NewFakeGateway()
+	paymentService := payments.NewService(db, gateway)
+	paymentService.SetLedger(ledgerService)
+
+	// An order with items from two sellers
+	buyer := uuid.New()
+	sellerA, sellerB := uuid.New(), uuid.New()
+	order := models.Order{BaseModel: common.BaseModel{ID: uuid.New()}, UserID: buyer, Status: "pending", TotalAmount: 10000, Subtotal: 10000}
+	require.NoError(t, db.Create(&order).Error)
+	for seller, total := range map[uuid.UUID]int64{sellerA: 7000, sellerB: 3000} {
+		product := models.Product{SellerID: seller, CategoryID: uuid.New(), Title: "Card", StartingPrice: total, Status: "active"}
+		require.NoError(t, db.Create(&product).Error)
+		require.NoError(t, db.Create(&models.OrderItem{OrderID: order.ID, ProductID: product.ID, Quantity: 1, UnitPrice: total, Total: total}).Error)
+	}
+
+	balance := func(account ledger.Account) int64 {
+		m, err := ledgerService.Balance(ctx, account, "USD")
+		require.NoError(t, err)
+		return m.Amount
+	}
+
+	// A payment is credited to each seller by their share of the order
+	intent, err := paymentService.CreateOrderPaymentIntent(ctx, buyer, order.ID, "card")
+	require.NoError(t, err)
+	payment, err := paymentService.ConfirmPayment(ctx, intent.ID, payments.FakeCardVisa)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10000), balance(ledger.ProcessorAccount("fake")))
+	assert.Equal(t, int64(7000), balance(ledger.SellerAccount(sellerA)))
+	assert.Equal(t, int64(3000), balance(ledger.SellerAccount(sellerB)))
+
+	// Posting the same payment again records nothing new
+	first, err := ledgerService.PostPayment(ctx, payment)
+	require.NoError(t, err)
+	again, err := ledgerService.PostPayment(ctx, payment)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, again.ID)
+	assert.Equal(t, int64(7000), balance(ledger.SellerAccount(sellerA)))
+
+	// A refund is recovered from the sellers in the same proportions
+	_, err = paymentService.RefundPayment(ctx, payment.ID, 1000, "requested_by_customer", uuid.New())
+	require.NoError(t, err)
+	assert.Equal(t, int64(9000), balance(ledger.ProcessorAccount("fake")))
+	assert.Equal(t, int64(6300), balance(ledger.SellerAccount(sellerA)))
+	assert.Equal(t, int64(2700), balance(ledger.SellerAccount(sellerB)))
+	assert.Equal(t, int64(0), balance(ledger.RefundsAccount()))
+
+	// Fees move from the seller to the platform and payouts leave the processor
+	fee, err := ledgerService.PostFee(ctx, "fee:"+order.ID.String(), sellerA, money.New(630, "USD"), &order.ID, "Commission")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5670), fee.Balance)
+	assert.Equal(t, int64(630), balance(ledger.PlatformFeesAccount()))
+	payout := models.Payout{BaseModel: common.BaseModel{ID: uuid.New()}, SellerID: sellerB, Amount: 2700, NetAmount: 2700, Currency: "USD", GatewayType: "fake", Method: "stripe_connect"}
+	_, err = ledgerService.PostPayout(ctx, &payout)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), balance(ledger.SellerAccount(sellerB)))
+	assert.Equal(t, int64(6300), balance(ledger.ProcessorAccount("fake")))
+
+	accounts, err := ledgerService.ListAccounts(ctx, &sellerA)
+	require.NoError(t, err)
+	require.Len(t, accounts, 1)
+	assert.Equal(t, "seller:"+sellerA.String(), accounts[0].Code)
+
+	// Unbalanced postings are rejected
+	_, err = ledgerService.Post(ctx, ledger.Posting{
+		Type:      ledger.TypeFee,
+		Reference: "unbalanced",
+		UserID:    sellerA,
+		Lines: []ledger.Line{
+			{Account: ledger.SellerAccount(sellerA), Amount: 100},
+			{Account: ledger.PlatformFeesAccount(), Amount: -90},
+		},
+	})
+	assert.ErrorIs(t, err, ledger.ErrUnbalanced)
+
+	report, err := ledgerService.CheckInvariants(ctx)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), report.Violations)
+	assert.Equal(t, int64(4), report.Transactions)
+
+	// A cached balance that drifts from its entries is reported
+	require.NoError(t, db.Model(&models.LedgerAccount{}).Where("code = ?", "platform:fees").Update("balance", -1).Error)
+	report, err = ledgerService.CheckInvariants(ctx)
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Len(t, report.Violations, 2)
+}
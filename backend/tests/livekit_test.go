@@ -0,0 +1,600 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/auction"
+	"github.com/blytz.live.remake/backend/internal/livekit"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sendStreamWebhook posts a signed fake provider webhook and returns the response code
+func sendStreamWebhook(t *testing.T, router *gin.Engine, provider *livekit.FakeProvider, event livekit.StreamEvent) int {
+	body, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest(http.MethodPost, "/webhooks/livekit", bytes.NewReader(body))
+	req.Header.Set("Authorization", provider.SignWebhook(body))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestAuctionCreationProvisionsStreamRoom(t *testing.T) {
+	db := setupAuctionTestDB(t)
+	ctx := context.Background()
+
+	provider := livekit.NewFakeProvider()
+	streamService := livekit.NewService(db, provider)
+	auctionService := auction.NewService(db)
+	auctionService.SetRoomProvisioner(streamService)
+
+	existing := createTestAuction(t, db)
+
+	item := &models.Auction{
+		ProductID:  existing.ProductID,
+		SellerID:   existing.SellerID,
+		Title:      "Evening Drop",
+		StartTime:  time.Now().Add(time.Hour),
+		EndTime:    time.Now().Add(2 * time.Hour),
+		StartPrice: 1000,
+	}
+	require.NoError(t, auctionService.CreateAuction(ctx, item))
+
+	// The room name is derived from the auction ID and matches the provider room
+	assert.Equal(t, livekit.RoomName(item.ID), item.LiveKitRoom)
+	assert.True(t, provider.HasRoom(item.LiveKitRoom))
+
+	var stream models.LiveStream
+	require.NoError(t, db.First(&stream, "auction_id = ?", item.ID).Error)
+	assert.Equal(t, "waiting", stream.Status)
+	assert.Equal(t, provider.URL(), stream.StreamURL)
+
+	// Creating the room again reuses the existing stream record
+	room, err := streamService.CreateAuctionRoom(ctx, item.ID)
+	require.NoError(t, err)
+	assert.Equal(t, stream.ID, room.DatabaseID)
+
+	// Moderation goes through the provider
+	require.NoError(t, provider.AddParticipant(item.LiveKitRoom, livekit.ParticipantInfo{Identity: "viewer-1"}))
+	require.NoError(t, streamService.MuteParticipant(ctx, item.ID, "viewer-1", true))
+	assert.True(t, provider.IsMuted(item.LiveKitRoom, "viewer-1"))
+
+	require.NoError(t, streamService.RemoveParticipant(ctx, item.ID, "viewer-1"))
+	participants, err := streamService.GetRoomParticipants(ctx, item.ID)
+	require.NoError(t, err)
+	assert.Empty(t, participants)
+
+	// Ending the stream closes the room
+	require.NoError(t, streamService.EndAuctionStream(ctx, item.ID))
+	assert.False(t, provider.HasRoom(item.LiveKitRoom))
+}
+
+func TestLiveKitWebhookUpdatesStreamState(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupAuctionTestDB(t)
+	ctx := context.Background()
+
+	provider := livekit.NewFakeProvider()
+	streamService := livekit.NewService(db, provider)
+	auctionService := auction.NewService(db)
+	streamService.SetAuctionEnder(auctionService, 50*time.Millisecond)
+
+	item := createTestAuction(t, db)
+	_, err := streamService.CreateAuctionRoom(ctx, item.ID)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.POST("/webhooks/livekit", livekit.NewHandler(streamService).ReceiveWebhook)
+
+	// Unsigned events are rejected
+	req, _ := http.NewRequest(http.MethodPost, "/webhooks/livekit", bytes.NewReader([]byte(`{"event":"room_started"}`)))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	host := &livekit.EventParticipant{Identity: item.SellerID.String()}
+	events := []livekit.StreamEvent{
+		{Type: livekit.EventRoomStarted, Room: item.LiveKitRoom},
+		{Type: livekit.EventParticipantJoined, Room: item.LiveKitRoom, Participant: host},
+		{Type: livekit.EventTrackPublished, Room: item.LiveKitRoom, Participant: host, Track: &livekit.EventTrack{SID: "TR_1", Type: "video"}},
+		{Type: livekit.EventParticipantJoined, Room: item.LiveKitRoom, Participant: &livekit.EventParticipant{Identity: "viewer-a"}},
+		{Type: livekit.EventParticipantJoined, Room: item.LiveKitRoom, Participant: &livekit.EventParticipant{Identity: "viewer-b"}},
+		{Type: livekit.EventParticipantJoined, Room: item.LiveKitRoom, Participant: &livekit.EventParticipant{Identity: "recorder", Recorder: true}},
+		{Type: livekit.EventParticipantLeft, Room: item.LiveKitRoom, Participant: &livekit.EventParticipant{Identity: "viewer-a"}},
+		{Type: livekit.EventParticipantJoined, Room: item.LiveKitRoom, Participant: &livekit.EventParticipant{Identity: "viewer-a"}},
+	}
+	for _, event := range events {
+		require.Equal(t, http.StatusOK, sendStreamWebhook(t, router, provider, event), event.Type)
+	}
+
+	var stream models.LiveStream
+	require.NoError(t, db.First(&stream, "auction_id = ?", item.ID).Error)
+	assert.Equal(t, "live", stream.Status)
+	assert.NotNil(t, stream.StartedAt)
+	assert.Equal(t, 2, stream.ViewerCount)
+
+	var stats models.AuctionStats
+	require.NoError(t, db.First(&stats, "auction_id = ?", item.ID).Error)
+	assert.Equal(t, 2, stats.PeakViewers)
+	assert.Equal(t, 2, stats.UniqueViewers)
+
+	// A redelivered event is applied once
+	joined := livekit.StreamEvent{ID: "EV_viewer_c", Type: livekit.EventParticipantJoined, Room: item.LiveKitRoom, Participant: &livekit.EventParticipant{Identity: "viewer-c"}}
+	require.Equal(t, http.StatusOK, sendStreamWebhook(t, router, provider, joined))
+	require.Equal(t, http.StatusOK, sendStreamWebhook(t, router, provider, joined))
+	require.NoError(t, db.First(&stream, "auction_id = ?", item.ID).Error)
+	assert.Equal(t, 3, stream.ViewerCount)
+
+	// Each fake provider signs with its own secret
+	assert.Equal(t, http.StatusUnauthorized, sendStreamWebhook(t, router, livekit.NewFakeProvider(), joined))
+
+	// The auction ends once the host has been away longer than the grace period
+	require.Equal(t, http.StatusOK, sendStreamWebhook(t, router, provider, livekit.StreamEvent{
+		Type: livekit.EventParticipantLeft, Room: item.LiveKitRoom, Participant: host,
+	}))
+	assert.Eventually(t, func() bool {
+		var current models.Auction
+		return db.First(&current, "id = ?", item.ID).Error == nil && current.Status == "ended"
+	}, 2*time.Second, 20*time.Millisecond)
+
+	require.Equal(t, http.StatusOK, sendStreamWebhook(t, router, provider, livekit.StreamEvent{
+		Type: livekit.EventRoomFinished, Room: item.LiveKitRoom,
+	}))
+	require.NoError(t, db.First(&stream, "auction_id = ?", item.ID).Error)
+	assert.Equal(t, "ended", stream.Status)
+	assert.Equal(t, 0, stream.ViewerCount)
+}
+
+func TestStreamRecordingLifecycle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupAuctionTestDB(t)
+	ctx := context.Background()
+
+	provider := livekit.NewFakeProvider()
+	streamService := livekit.NewService(db, provider)
+	storage := livekit.NewLocalStorage(t.TempDir(), "/recordings")
+	streamService.SetRecordingStorage(storage, time.Hour)
+
+	item := createTestAuction(t, db)
+	_, err := streamService.CreateAuctionRoom(ctx, item.ID)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.POST("/webhooks/livekit", livekit.NewHandler(streamService).ReceiveWebhook)
+
+	recording, err := streamService.StartRecording(ctx, item.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "recording", recording.Status)
+	assert.True(t, provider.IsRecording(recording.EgressID))
+
+	// Starting again returns the running recording instead of a second egress
+	again, err := streamService.StartRecording(ctx, item.ID)
+	require.NoError(t, err)
+	assert.Equal(t, recording.ID, again.ID)
+
+	require.NoError(t, streamService.StopRecording(ctx, item.ID))
+	assert.False(t, provider.IsRecording(recording.EgressID))
+	assert.ErrorIs(t, streamService.StopRecording(ctx, item.ID), livekit.ErrNoActiveRecording)
+
+	// The egress writes the file and reports completion by webhook
+	location := storage.OutputPath(item.ID, recording.StartedAt)
+	require.NoError(t, os.MkdirAll(filepath.Dir(location), 0o755))
+	require.NoError(t, os.WriteFile(location, []byte("mp4"), 0o644))
+
+	require.Equal(t, http.StatusOK, sendStreamWebhook(t, router, provider, livekit.StreamEvent{
+		Type: livekit.EventEgressEnded,
+		Room: item.LiveKitRoom,
+		Egress: &livekit.EgressEvent{
+			ID:     recording.EgressID,
+			Status: livekit.EgressComplete,
+			Files:  []livekit.EgressFile{{Location: location, Size: 2048, Duration: 90 * time.Second}},
+		},
+	}))
+
+	recordings, total, err := streamService.ListSellerRecordings(ctx, item.SellerID, 1, 20)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), total)
+	assert.Equal(t, "ready", recordings[0].Status)
+	assert.Equal(t, 90, recordings[0].Duration)
+	assert.Equal(t, int64(2048), recordings[0].Size)
+	require.NotNil(t, recordings[0].PlaybackURL)
+	assert.Equal(t, "/recordings/"+item.ID.String()+"/"+filepath.Base(location), *recordings[0].PlaybackURL)
+
+	url, err := streamService.GenerateStreamRecordingURL(ctx, item.ID)
+	require.NoError(t, err)
+	assert.Equal(t, *recordings[0].PlaybackURL, url)
+
+	// Only the auction's seller gets the recording, not any other seller
+	sellers := gin.New()
+	sellers.Use(func(c *gin.Context) {
+		c.Set("user_id", uuid.MustParse(c.GetHeader("X-User")))
+		c.Set("role", "seller")
+	})
+	sellers.GET("/auctions/:auction_id/recording-url", livekit.NewHandler(streamService).GenerateStreamRecordingURL)
+	for user, status := range map[uuid.UUID]int{uuid.New(): http.StatusForbidden, item.SellerID: http.StatusOK} {
+		req := httptest.NewRequest(http.MethodGet, "/auctions/"+item.ID.String()+"/recording-url", nil)
+		req.Header.Set("X-User", user.String())
+		w := httptest.NewRecorder()
+		sellers.ServeHTTP(w, req)
+		assert.Equal(t, status, w.Code)
+	}
+
+	// Expired recordings are removed from storage
+	require.NoError(t, db.Model(&models.StreamRecording{}).Where("id = ?", recording.ID).
+		Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	purged, err := streamService.PurgeExpiredRecordings(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assert.NoFileExists(t, location)
+
+	_, total, err = streamService.ListSellerRecordings(ctx, item.SellerID, 1, 20)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), total)
+}
+
+func TestStreamIngressLifecycle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupAuctionTestDB(t)
+	ctx := context.Background()
+
+	provider := livekit.NewFakeProvider()
+	streamService := livekit.NewService(db, provider)
+
+	item := createTestAuction(t, db)
+	_, err := streamService.CreateAuctionRoom(ctx, item.ID)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.POST("/webhooks/livekit", livekit.NewHandler(streamService).ReceiveWebhook)
+
+	// A per-seller ingress publishes to the seller's staging room until attached
+	ingress, err := streamService.CreateIngress(ctx, item.SellerID, livekit.IngressRTMP, "OBS", nil)
+	require.NoError(t, err)
+	assert.NotEmpty(t, ingress.StreamKey)
+	assert.Equal(t, livekit.SellerRoomName(item.SellerID), provider.IngressRoom(ingress.IngressID))
+
+	_, err = streamService.AttachIngress(ctx, uuid.New(), ingress.ID, &item.ID)
+	assert.Error(t, err)
+
+	ingress, err = streamService.AttachIngress(ctx, item.SellerID, ingress.ID, &item.ID)
+	require.NoError(t, err)
+	assert.Equal(t, item.LiveKitRoom, provider.IngressRoom(ingress.IngressID))
+
+	var stream models.LiveStream
+	require.NoError(t, db.First(&stream, "auction_id = ?", item.ID).Error)
+	assert.Equal(t, ingress.StreamKey, stream.StreamKey)
+
+	// The encoder connecting takes the stream live
+	require.Equal(t, http.StatusOK, sendStreamWebhook(t, router, provider, livekit.StreamEvent{
+		Type:    livekit.EventIngressStarted,
+		Room:    item.LiveKitRoom,
+		Ingress: &livekit.IngressEvent{ID: ingress.IngressID, Status: livekit.IngressPublishing},
+	}))
+
+	var current models.StreamIngress
+	require.NoError(t, db.First(&current, "id = ?", ingress.ID).Error)
+	assert.Equal(t, livekit.IngressPublishing, current.Status)
+	assert.NotNil(t, current.LastConnectedAt)
+	require.NoError(t, db.First(&stream, "auction_id = ?", item.ID).Error)
+	assert.Equal(t, "live", stream.Status)
+
+	// A publishing ingress cannot be moved
+	_, err = streamService.AttachIngress(ctx, item.SellerID, ingress.ID, nil)
+	assert.ErrorIs(t, err, livekit.ErrIngressBusy)
+
+	// Rotation replaces the provider ingress and the auction's key
+	oldIngressID, oldKey := ingress.IngressID, ingress.StreamKey
+	rotated, err := streamService.RotateIngressKey(ctx, item.SellerID, ingress.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, oldKey, rotated.StreamKey)
+	assert.Equal(t, livekit.IngressInactive, rotated.Status)
+	assert.Empty(t, provider.IngressRoom(oldIngressID))
+	assert.Equal(t, item.LiveKitRoom, provider.IngressRoom(rotated.IngressID))
+	require.NoError(t, db.First(&stream, "auction_id = ?", item.ID).Error)
+	assert.Equal(t, rotated.StreamKey, stream.StreamKey)
+
+	// Revoking disables the key and detaches it from the auction
+	require.NoError(t, streamService.RevokeIngress(ctx, item.SellerID, ingress.ID))
+	assert.Empty(t, provider.IngressRoom(rotated.IngressID))
+	require.NoError(t, db.First(&stream, "auction_id = ?", item.ID).Error)
+	assert.Empty(t, stream.StreamKey)
+
+	_, err = streamService.RotateIngressKey(ctx, item.SellerID, ingress.ID)
+	assert.ErrorIs(t, err, livekit.ErrIngressRevoked)
+
+	ingresses, err := streamService.ListIngresses(ctx, item.SellerID)
+	require.NoError(t, err)
+	assert.Empty(t, ingresses)
+}
+
+func TestStreamStageRoles(t *testing.T) {
+	db := setupAuctionTestDB(t)
+	ctx := context.Background()
+
+	provider := livekit.NewFakeProvider()
+	streamService := livekit.NewService(db, provider)
+
+	item := createTestAuction(t, db)
+	_, err := streamService.CreateAuctionRoom(ctx, item.ID)
+	require.NoError(t, err)
+
+	cohostID, guestID, viewerID := uuid.New(), uuid.New(), uuid.New()
+
+	// Only the auction's seller can host or invite
+	_, err = streamService.GenerateHostToken(ctx, item.ID, cohostID)
+	assert.ErrorIs(t, err, livekit.ErrAuctionNotOwned)
+	_, err = streamService.InviteToStage(ctx, item.ID, cohostID, livekit.RoleCohost, nil)
+	assert.ErrorIs(t, err, livekit.ErrAuctionNotOwned)
+
+	// An invitation addressed to one user cannot be accepted by another
+	invitation, err := streamService.InviteToStage(ctx, item.ID, item.SellerID, livekit.RoleCohost, &cohostID)
+	require.NoError(t, err)
+	require.NotNil(t, invitation.InviteCode)
+	_, _, err = streamService.AcceptInvitation(ctx, *invitation.InviteCode, guestID)
+	assert.ErrorIs(t, err, livekit.ErrInvitationInvalid)
+
+	invitations, err := streamService.ListMyInvitations(ctx, cohostID)
+	require.NoError(t, err)
+	assert.Len(t, invitations, 1)
+
+	participant, token, err := streamService.AcceptInvitation(ctx, *invitation.InviteCode, cohostID)
+	require.NoError(t, err)
+	assert.Equal(t, "active", participant.Status)
+	assert.NotEmpty(t, token)
+
+	// Invitation codes are single use
+	_, _, err = streamService.AcceptInvitation(ctx, *invitation.InviteCode, cohostID)
+	assert.ErrorIs(t, err, livekit.ErrInvitationInvalid)
+
+	// A connected viewer asks to come on stage and is upgraded in place
+	require.NoError(t, provider.AddParticipant(item.LiveKitRoom, livekit.ParticipantInfo{Identity: guestID.String()}))
+	request, err := streamService.RequestStage(ctx, item.ID, guestID)
+	require.NoError(t, err)
+	assert.Equal(t, "requested", request.Status)
+	_, err = streamService.GenerateStageToken(ctx, item.ID, guestID)
+	assert.ErrorIs(t, err, livekit.ErrNotOnStage)
+
+	_, err = streamService.ApproveStageRequest(ctx, item.ID, item.SellerID, request.ID, "")
+	require.NoError(t, err)
+	assert.True(t, provider.CanPublish(item.LiveKitRoom, guestID.String()))
+
+	require.NoError(t, provider.AddParticipant(item.LiveKitRoom, livekit.ParticipantInfo{Identity: viewerID.String()}))
+	participants, err := streamService.GetRoomParticipants(ctx, item.ID)
+	require.NoError(t, err)
+	roles := map[string]string{}
+	for _, p := range participants {
+		roles[p.Identity] = p.Role
+	}
+	assert.Equal(t, livekit.RoleGuest, roles[guestID.String()])
+	assert.Equal(t, livekit.RoleViewer, roles[viewerID.String()])
+
+	// Co-hosts moderate guests and viewers but not the host; guests moderate nobody
+	host := item.SellerID.String()
+	assert.NoError(t, streamService.CheckModerationRights(ctx, item.ID, cohostID, false, guestID.String()))
+	assert.NoError(t, streamService.CheckModerationRights(ctx, item.ID, cohostID, false, viewerID.String()))
+	assert.ErrorIs(t, streamService.CheckModerationRights(ctx, item.ID, cohostID, false, host), livekit.ErrInsufficientRole)
+	assert.ErrorIs(t, streamService.CheckModerationRights(ctx, item.ID, guestID, false, viewerID.String()), livekit.ErrInsufficientRole)
+	assert.NoError(t, streamService.CheckModerationRights(ctx, item.ID, item.SellerID, false, cohostID.String()))
+	assert.NoError(t, streamService.CheckModerationRights(ctx, item.ID, viewerID, true, host))
+
+	// Revoking drops the guest back to a viewer without disconnecting them
+	require.NoError(t, streamService.RevokeStage(ctx, item.ID, item.SellerID, request.ID))
+	assert.False(t, provider.CanPublish(item.LiveKitRoom, guestID.String()))
+	role, err := streamService.ParticipantRole(ctx, item.ID, guestID.String())
+	require.NoError(t, err)
+	assert.Equal(t, livekit.RoleViewer, role)
+}
+
+// hostAlerts records the alerts pushed to hosts
+type hostAlerts struct {
+	messages []string
+	rules    []string
+}
+
+func (h *hostAlerts) SendToUser(auctionID, userID uuid.UUID, messageType string, data interface{}) {
+	h.messages = append(h.messages, messageType)
+	if alert, ok := data.(*models.StreamAlert); ok {
+		h.rules = append(h.rules, alert.Rule)
+	}
+}
+
+func TestStreamHealthAlerts(t *testing.T) {
+	db := setupAuctionTestDB(t)
+	ctx := context.Background()
+
+	streamService := livekit.NewService(db, livekit.NewFakeProvider())
+	notifier := &hostAlerts{}
+	streamService.SetAlertNotifier(notifier)
+	rules := livekit.DefaultHealthRules()
+	rules.NoVideoAfter = 50 * time.Millisecond
+	streamService.SetHealthRules(rules)
+
+	item := createTestAuction(t, db)
+	_, err := streamService.CreateAuctionRoom(ctx, item.ID)
+	require.NoError(t, err)
+
+	fps := func(v int) *int { return &v }
+	report := func(bandwidth, latency int, frameRate *int) {
+		require.NoError(t, streamService.UpdateStreamMetrics(ctx, item.ID, &livekit.StreamMetrics{
+			ViewerCount: 10,
+			Bandwidth:   bandwidth,
+			Latency:     latency,
+			FrameRate:   frameRate,
+		}))
+	}
+
+	// A healthy baseline raises nothing
+	for i := 0; i < 3; i++ {
+		report(3000, 200, fps(30))
+	}
+	assert.Empty(t, notifier.messages)
+
+	// Bitrate halves and latency spikes
+	report(1000, 2000, fps(30))
+	assert.ElementsMatch(t, []string{livekit.RuleBitrateDrop, livekit.RuleLatencySpike}, notifier.rules)
+
+	// A sustained drop stays a single alert
+	report(1000, 200, fps(30))
+	assert.Equal(t, []string{"stream_alert", "stream_alert", "stream_alert_resolved"}, notifier.messages)
+
+	// Video stops for longer than the grace period
+	report(64, 200, fps(0))
+	time.Sleep(60 * time.Millisecond)
+	report(64, 200, fps(0))
+	assert.Contains(t, notifier.rules, livekit.RuleNoVideo)
+
+	// Recovery resolves the open alerts
+	report(3000, 200, fps(30))
+
+	var open int64
+	require.NoError(t, db.Model(&models.StreamAlert{}).Where("resolved_at IS NULL").Count(&open).Error)
+	assert.Equal(t, int64(0), open)
+
+	samples, err := streamService.ListStreamMetrics(ctx, item.ID, time.Time{}, 100)
+	require.NoError(t, err)
+	assert.Len(t, samples, 8)
+
+	quality, err := streamService.GetQualityReport(ctx, item.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 8, quality.Samples)
+	assert.Equal(t, 3000, quality.MaxBandwidth)
+	assert.Equal(t, 64, quality.MinBandwidth)
+	assert.Equal(t, 2000, quality.MaxLatency)
+	assert.Equal(t, 1, quality.AlertCounts[livekit.RuleNoVideo])
+	assert.Equal(t, 100-25-10-10, quality.HealthScore)
+}
+
+func TestSilentStreamWatchdog(t *testing.T) {
+	db := setupAuctionTestDB(t)
+	ctx := context.Background()
+
+	streamService := livekit.NewService(db, livekit.NewFakeProvider())
+	notifier := &hostAlerts{}
+	streamService.SetAlertNotifier(notifier)
+
+	item := createTestAuction(t, db)
+	_, err := streamService.CreateAuctionRoom(ctx, item.ID)
+	require.NoError(t, err)
+	fps := 30
+	require.NoError(t, streamService.UpdateStreamMetrics(ctx, item.ID, &livekit.StreamMetrics{Bandwidth: 3000, FrameRate: &fps}))
+	start := time.Now()
+	require.NoError(t, db.Model(&models.LiveStream{}).Where("auction_id = ?", item.ID).Updates(map[string]interface{}{
+		"status":     "live",
+		"started_at": start.Add(-time.Minute),
+	}).Error)
+
+	// Video was seen recently
+	raised, err := streamService.CheckSilentStreams(ctx, start.Add(5*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 0, raised)
+
+	// The encoder stopped reporting: the watchdog raises a single alert
+	raised, err = streamService.CheckSilentStreams(ctx, start.Add(30*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, raised)
+	assert.Equal(t, []string{livekit.RuleNoVideo}, notifier.rules)
+	raised, err = streamService.CheckSilentStreams(ctx, start.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 0, raised)
+
+	// Video coming back resolves it
+	require.NoError(t, streamService.UpdateStreamMetrics(ctx, item.ID, &livekit.StreamMetrics{Bandwidth: 3000, FrameRate: &fps}))
+	var open int64
+	require.NoError(t, db.Model(&models.StreamAlert{}).Where("resolved_at IS NULL").Count(&open).Error)
+	assert.Equal(t, int64(0), open)
+	assert.Equal(t, []string{"stream_alert", "stream_alert_resolved"}, notifier.messages)
+}
+
+func TestHighlightClips(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupAuctionTestDB(t)
+	ctx := context.Background()
+
+	provider := livekit.NewFakeProvider()
+	streamService := livekit.NewService(db, provider)
+	storage := livekit.NewLocalStorage(t.TempDir(), "/recordings")
+	streamService.SetRecordingStorage(storage, time.Hour)
+
+	item := createTestAuction(t, db)
+	_, err := streamService.CreateAuctionRoom(ctx, item.ID)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.POST("/webhooks/livekit", livekit.NewHandler(streamService).ReceiveWebhook)
+
+	recording, err := streamService.StartRecording(ctx, item.ID)
+	require.NoError(t, err)
+
+	// The lot sells a minute into the recording
+	bid := models.Bid{
+		AuctionID: item.ID,
+		UserID:    uuid.New(),
+		Amount:    12000,
+		IsWinning: true,
+		BidTime:   recording.StartedAt.Add(time.Minute),
+	}
+	require.NoError(t, db.Create(&bid).Error)
+
+	require.NoError(t, streamService.StopRecording(ctx, item.ID))
+	require.Equal(t, http.StatusOK, sendStreamWebhook(t, router, provider, livekit.StreamEvent{
+		Type: livekit.EventEgressEnded,
+		Room: item.LiveKitRoom,
+		Egress: &livekit.EgressEvent{
+			ID:     recording.EgressID,
+			Status: livekit.EgressComplete,
+			Files:  []livekit.EgressFile{{Location: storage.OutputPath(item.ID, recording.StartedAt), Size: 2048, Duration: 90 * time.Second}},
+		},
+	}))
+
+	// The finished recording produced the automatic clip around the winning bid
+	clips, err := streamService.ListAuctionClips(ctx, item.ID, true)
+	require.NoError(t, err)
+	require.Len(t, clips, 1)
+	assert.Equal(t, "auto", clips[0].Source)
+	assert.Equal(t, 30.0, clips[0].StartOffset)
+	assert.Equal(t, 70.0, clips[0].EndOffset)
+	assert.Contains(t, clips[0].PlaybackURL, "#t=30,70")
+
+	again, err := streamService.CreateSellerLotClip(ctx, item.SellerID, item.ID)
+	require.NoError(t, err)
+	assert.Equal(t, clips[0].ID, again.ID)
+
+	// Manual clips must fall inside a recording and respect the length limits
+	_, err = streamService.CreateClip(ctx, item.SellerID, item.ID, "", recording.StartedAt, recording.StartedAt.Add(time.Second))
+	assert.ErrorIs(t, err, livekit.ErrInvalidClipRange)
+	_, err = streamService.CreateClip(ctx, item.SellerID, item.ID, "", recording.StartedAt.Add(80*time.Second), recording.StartedAt.Add(100*time.Second))
+	assert.ErrorIs(t, err, livekit.ErrNoRecordingForClip)
+	_, err = streamService.CreateClip(ctx, uuid.New(), item.ID, "", recording.StartedAt, recording.StartedAt.Add(10*time.Second))
+	assert.ErrorIs(t, err, livekit.ErrAuctionNotOwned)
+
+	manual, err := streamService.CreateClip(ctx, item.SellerID, item.ID, "Opening", recording.StartedAt.Add(5*time.Second), recording.StartedAt.Add(15*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 10.0, manual.Duration)
+
+	_, err = streamService.SetClipPublished(ctx, item.SellerID, manual.ID, false)
+	require.NoError(t, err)
+	clips, err = streamService.ListAuctionClips(ctx, item.ID, true)
+	require.NoError(t, err)
+	assert.Len(t, clips, 1)
+
+	// Featuring a clip makes it the product's video
+	require.NoError(t, streamService.FeatureClip(ctx, item.SellerID, again.ID))
+	var product models.Product
+	require.NoError(t, db.First(&product, "id = ?", item.ProductID).Error)
+	require.NotNil(t, product.VideoURL)
+	assert.Equal(t, again.PlaybackURL, *product.VideoURL)
+}
@@ -37,14 +37,14 @@ func TestCategoryModel(t *testing.T) {
 func TestProductModel(t *testing.T) {
 	product := models.Product{
 		Title:        "Test Product",
-		StartingPrice: 99.99,
+		StartingPrice: 9999,
 		Status:       "draft",
 		Featured:     false,
 		ViewCount:    0,
 	}
 
 	assert.Equal(t, "Test Product", product.Title)
-	assert.Equal(t, 99.99, product.StartingPrice)
+	assert.Equal(t, int64(9999), product.StartingPrice)
 	assert.Equal(t, "draft", product.Status)
 	assert.False(t, product.Featured)
 	assert.Equal(t, 0, product.ViewCount)
@@ -0,0 +1,160 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/blytz.live.remake/backend/internal/cart"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/internal/orders"
+	"github.com/blytz.live.remake/backend/internal/payments"
+	"github.com/blytz.live.remake/backend/internal/products"
+	"github.com/blytz.live.remake/backend/pkg/money"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMoney(t *testing.T) {
+	// Float amounts are rounded to the currency's minor unit
+	assert.Equal(t, int64(1999), money.FromMajor(19.99, "usd").Amount)
+	assert.Equal(t, int64(30), money.FromMajor(0.1+0.2, "USD").Amount)
+	assert.Equal(t, int64(1200), money.FromMajor(1200, "JPY").Amount)
+	assert.Equal(t, int64(1250), money.FromMajor(1.25, "KWD").Amount)
+	assert.Equal(t, "USD", money.FromMajor(1, "").Currency)
+
+	// Parsing is exact and rejects amounts finer than the minor unit
+	m, err := money.Parse("12.34", "EUR")
+	require.NoError(t, err)
+	assert.Equal(t, money.New(1234, "EUR"), m)
+	_, err = money.Parse("12.345", "USD")
+	assert.ErrorIs(t, err, money.ErrInvalidAmount)
+	_, err = money.Parse("100.5", "JPY")
+	assert.ErrorIs(t, err, money.ErrInvalidAmount)
+	_, err = money.Parse("1", "XXX")
+	assert.ErrorIs(t, err, money.ErrUnknownCurrency)
+
+	assert.Equal(t, "0.05 USD", money.New(5, "USD").String())
+	assert.Equal(t, "-12.30", money.New(-1230, "USD").Decimal())
+	assert.Equal(t, "1200", money.New(1200, "JPY").Decimal())
+	assert.Equal(t, "1.250", money.New(1250, "BHD").Decimal())
+
+	// Arithmetic refuses to mix currencies
+	_, err = money.New(100, "USD").Add(money.New(100, "EUR"))
+	assert.ErrorIs(t, err, money.ErrCurrencyMismatch)
+	assert.Equal(t, int64(8), money.New(100, "USD").MulRate(0.075).Amount)
+
+	// Allocation never loses a minor unit
+	parts := money.New(100, "USD").Allocate(1, 1, 1)
+	assert.Equal(t, []int64{34, 33, 33}, []int64{parts[0].Amount, parts[1].Amount, parts[2].Amount})
+
+	encoded, err := json.Marshal(money.New(1999, "USD"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"amount":1999,"currency":"USD","display":"19.99"}`, string(encoded))
+	var decoded money.Money
+	require.NoError(t, json.Unmarshal([]byte(`{"amount":500,"currency":"jpy"}`), &decoded))
+	assert.Equal(t, money.New(500, "JPY"), decoded)
+}
+
+func TestPaymentIntentZeroDecimalCurrency(t *testing.T) {
+	db := setupPaymentTestDB(t)
+	ctx := context.Background()
+
+	gateway := payments.NewFakeGateway()
+	service := payments.NewService(db, gateway)
+
+	// Yen have no minor unit, so 1200 JPY is sent to the gateway as 1200, not 120000
+	intent, err := service.CreatePaymentIntent(ctx, uuid.New(), 1200, "jpy", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "JPY", intent.Currency)
+	gatewayIntent, err := gateway.GetIntent(ctx, intent.GatewayRef)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1200), gatewayIntent.Amount)
+
+	_, err = service.CreatePaymentIntent(ctx, uuid.New(), 10, "ABC", nil)
+	assert.ErrorIs(t, err, money.ErrUnknownCurrency)
+}
+
+func TestProductCurrencyLockedWhileInCarts(t *testing.T) {
+	db := setupTestDB()
+	require.NoError(t, db.AutoMigrate(&models.Cart{}, &models.CartItem{}))
+
+	seller := createTestUser(db)
+	category := createTestCategory(db)
+	productService := products.NewService(db)
+	cartService := cart.NewService(db)
+
+	product, err := productService.CreateProduct(seller.ID, products.ProductCreateRequest{
+		CategoryID:    category.ID,
+		Title:         "Signed jersey",
+		StartingPrice: 8000,
+		Currency:      "USD",
+	})
+	require.NoError(t, err)
+
+	// The currency can change freely until a shopper adds the product to a cart
+	updated, err := productService.UpdateProduct(product.ID, seller.ID, products.ProductUpdateRequest{Currency: stringPtr("eur"), Status: stringPtr("active")})
+	require.NoError(t, err)
+	assert.Equal(t, "EUR", updated.Currency)
+
+	buyer := uuid.New()
+	userCart, err := cartService.GetOrCreateCart(&buyer, nil)
+	require.NoError(t, err)
+	_, err = cartService.AddItem(userCart.ID, cart.AddItemRequest{ProductID: product.ID, Quantity: 1})
+	require.NoError(t, err)
+
+	_, err = productService.UpdateProduct(product.ID, seller.ID, products.ProductUpdateRequest{Currency: stringPtr("USD")})
+	assert.Error(t, err)
+	_, err = productService.UpdateProduct(product.ID, seller.ID, products.ProductUpdateRequest{Currency: stringPtr("EUR"), Title: stringPtr("Signed home jersey")})
+	assert.NoError(t, err)
+}
+
+func TestOrderShippingInOrderCurrency(t *testing.T) {
+	db := setupTestDB()
+	require.NoError(t, db.AutoMigrate(&models.Cart{}, &models.CartItem{}, &models.Order{}, &models.OrderItem{}, &models.InventoryStock{}, &models.StockMovement{}, &models.OrderFee{}, &models.InvoiceProfile{}))
+
+	cartService := cart.NewService(db)
+	orderService := orders.NewService(db, cartService)
+	seller := uuid.New()
+	newProduct := func(title string, price int64, currency string) models.Product {
+		product := models.Product{SellerID: seller, CategoryID: uuid.New(), Title: title, StartingPrice: price, Currency: currency, Status: "active"}
+		require.NoError(t, db.Create(&product).Error)
+		require.NoError(t, db.Create(&models.InventoryStock{ProductID: product.ID, Quantity: 10, Available: 10}).Error)
+		return product
+	}
+
+	// Each checkout empties the buyer's cart, fills it with the products and places
+	// the order
+	buyer := uuid.New()
+	fill := func(products ...models.Product) uuid.UUID {
+		userCart, err := cartService.GetOrCreateCart(&buyer, nil)
+		require.NoError(t, err)
+		require.NoError(t, cartService.ClearCart(userCart.ID))
+		for _, product := range products {
+			_, err = cartService.AddItem(userCart.ID, cart.AddItemRequest{ProductID: product.ID, Quantity: 1})
+			require.NoError(t, err)
+		}
+		return userCart.ID
+	}
+	place := func(cartID uuid.UUID) (*orders.OrderResponse, error) {
+		address := orders.Address{AddressLine1: "1 Main St", City: "Springfield", PostalCode: "62701", Country: "US"}
+		return orderService.CreateOrder(buyer, orders.OrderCreateRequest{
+			CartID:          cartID,
+			ShippingAddress: address,
+			BillingAddress:  address,
+			PaymentMethod:   "card",
+		})
+	}
+
+	// Shipping is charged at the rates of the order's currency
+	tea := newProduct("Matcha tin", 1200, "JPY")
+	order, err := place(fill(tea))
+	require.NoError(t, err)
+	assert.Equal(t, "JPY", order.Currency)
+	assert.Equal(t, int64(900), order.ShippingCost)
+
+	// Orders in a currency without shipping rates are refused
+	_, err = place(fill(newProduct("Cuckoo clock", 15000, "CHF")))
+	assert.ErrorIs(t, err, orders.ErrShippingCurrencyUnsupported)
+}
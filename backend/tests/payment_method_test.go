@@ -0,0 +1,238 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/addresses"
+	"github.com/blytz.live.remake/backend/internal/auction"
+	"github.com/blytz.live.remake/backend/internal/cart"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/internal/orders"
+	"github.com/blytz.live.remake/backend/internal/payments"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSavedPaymentMethods(t *testing.T) {
+	db := setupPaymentTestDB(t)
+	ctx := context.Background()
+
+	gateway := payments.NewFakeGateway()
+	service := payments.NewService(db, gateway)
+
+	user := models.User{Email: "cards@example.com", PasswordHash: "hash"}
+	require.NoError(t, db.Create(&user).Error)
+	defaults := func() int64 {
+		var count int64
+		require.NoError(t, db.Model(&models.PaymentMethod{}).
+			Where("user_id = ? AND is_default = ?", user.ID, true).
+			Count(&count).Error)
+		return count
+	}
+
+	// The first method becomes the default and tracks when the card expires
+	visa, err := service.SavePaymentMethod(ctx, user.ID, payments.FakeCardVisa, false)
+	require.NoError(t, err)
+	assert.True(t, visa.IsDefault)
+	require.NotNil(t, visa.ExpiresAt)
+	assert.Equal(t, time.Date(2035, 1, 1, 0, 0, 0, 0, time.UTC), visa.ExpiresAt.UTC())
+
+	// Saving the same card again returns it rather than a second copy
+	again, err := service.SavePaymentMethod(ctx, user.ID, payments.FakeCardVisa, false)
+	require.NoError(t, err)
+	assert.Equal(t, visa.ID, again.ID)
+
+	// Saved methods are attached to the user's single gateway customer
+	var customers []models.PaymentCustomer
+	require.NoError(t, db.Find(&customers, "user_id = ?", user.ID).Error)
+	require.Len(t, customers, 1)
+	assert.True(t, gateway.IsAttached(payments.FakeCardVisa, customers[0].CustomerRef))
+
+	// A new default replaces the old one
+	mastercard, err := service.SavePaymentMethod(ctx, user.ID, payments.FakeCardMastercard, true)
+	require.NoError(t, err)
+	assert.True(t, mastercard.IsDefault)
+	assert.Equal(t, int64(1), defaults())
+
+	_, err = service.SavePaymentMethod(ctx, user.ID, "pm_unknown", false)
+	assert.ErrorIs(t, err, payments.ErrGatewayObjectNotFound)
+
+	// The database allows only one default per user
+	duplicate := models.PaymentMethod{
+		UserID:    user.ID,
+		Type:      "card",
+		Provider:  "fake",
+		MethodRef: payments.FakeCardDeclined,
+		IsDefault: true,
+	}
+	assert.Error(t, db.Create(&duplicate).Error)
+
+	_, err = service.SetDefaultPaymentMethod(ctx, user.ID, visa.ID)
+	require.NoError(t, err)
+	defaultMethod, err := service.DefaultPaymentMethod(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, visa.ID, defaultMethod.ID)
+	assert.Equal(t, int64(1), defaults())
+
+	// Other users cannot see or change the methods
+	_, err = service.GetPaymentMethod(ctx, uuid.New(), visa.ID)
+	assert.Error(t, err)
+	_, err = service.SetDefaultPaymentMethod(ctx, uuid.New(), visa.ID)
+	assert.Error(t, err)
+
+	name := "Card Holder"
+	updated, err := service.UpdatePaymentMethod(ctx, user.ID, visa.ID, payments.UpdatePaymentMethodRequest{
+		Name:    &name,
+		Address: &models.Address{AddressLine1: "1 Main St", City: "Springfield", Country: "US"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, name, *updated.Name)
+	assert.Equal(t, "Springfield", updated.Address.City)
+
+	// Deleting the default detaches it and promotes the remaining method
+	require.NoError(t, service.DeletePaymentMethod(ctx, user.ID, visa.ID))
+	assert.True(t, gateway.IsDetached(payments.FakeCardVisa))
+	assert.False(t, gateway.IsAttached(payments.FakeCardVisa, customers[0].CustomerRef))
+	methods, err := service.GetUserPaymentMethods(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, methods, 1)
+	assert.Equal(t, mastercard.ID, methods[0].ID)
+	assert.True(t, methods[0].IsDefault)
+
+	// A detached card is saved again as a new method
+	readded, err := service.SavePaymentMethod(ctx, user.ID, payments.FakeCardVisa, false)
+	require.NoError(t, err)
+	assert.NotEqual(t, visa.ID, readded.ID)
+	assert.False(t, readded.IsDefault)
+
+	// Nothing expires before the cards' expiry date
+	flagged, err := service.FlagExpiredPaymentMethods(ctx, time.Date(2034, 12, 31, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, 0, flagged)
+
+	// Expired cards are flagged, lose their default and cannot be made default again
+	flagged, err = service.FlagExpiredPaymentMethods(ctx, time.Date(2035, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, 2, flagged)
+	assert.Equal(t, int64(0), defaults())
+	_, err = service.DefaultPaymentMethod(ctx, user.ID)
+	assert.ErrorIs(t, err, payments.ErrNoDefaultPaymentMethod)
+	_, err = service.SetDefaultPaymentMethod(ctx, user.ID, mastercard.ID)
+	assert.ErrorIs(t, err, payments.ErrPaymentMethodExpired)
+
+	// Flagging is done once
+	flagged, err = service.FlagExpiredPaymentMethods(ctx, time.Date(2035, 2, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, 0, flagged)
+}
+
+func TestAuctionWinChargesDefaultPaymentMethod(t *testing.T) {
+	db := setupPaymentTestDB(t)
+	require.NoError(t, db.AutoMigrate(
+		&models.Product{},
+		&models.OrderItem{},
+		&models.InventoryStock{},
+		&models.StockMovement{},
+		&models.Auction{},
+		&models.Bid{},
+		&addresses.Address{},
+	))
+	ctx := context.Background()
+
+	paymentService := payments.NewService(db, payments.NewFakeGateway())
+	orderService := orders.NewService(db, cart.NewService(db))
+	orderService.SetPaymentOpener(paymentService)
+	paymentService.SetOrderPaymentHandler(orderService)
+	auctionService := auction.NewService(db)
+	auctionService.SetWinnerOrders(orderService)
+
+	seller := uuid.New()
+	endAuction := func(winner uuid.UUID, amount int64) models.Order {
+		product := models.Product{
+			SellerID:      seller,
+			CategoryID:    uuid.New(),
+			Title:         "Graded card",
+			StartingPrice: 1000,
+			Status:        "active",
+		}
+		require.NoError(t, db.Create(&product).Error)
+		require.NoError(t, db.Create(&models.InventoryStock{ProductID: product.ID, Quantity: 1, Available: 1}).Error)
+
+		won := models.Auction{
+			ProductID:   product.ID,
+			SellerID:    seller,
+			Title:       "Graded card auction",
+			StartTime:   time.Now().Add(-time.Hour),
+			EndTime:     time.Now(),
+			Status:      "live",
+			StartPrice:  1000,
+			Currency:    "USD",
+			LiveKitRoom: "auction-" + uuid.New().String(),
+		}
+		require.NoError(t, db.Create(&won).Error)
+		require.NoError(t, db.Create(&models.Bid{AuctionID: won.ID, UserID: uuid.New(), Amount: amount - 500}).Error)
+		require.NoError(t, db.Create(&models.Bid{AuctionID: won.ID, UserID: winner, Amount: amount}).Error)
+
+		require.NoError(t, auctionService.EndAuction(ctx, won.ID))
+
+		var item models.OrderItem
+		require.NoError(t, db.First(&item, "auction_id = ?", won.ID).Error)
+		var order models.Order
+		require.NoError(t, db.First(&order, "id = ?", item.OrderID).Error)
+		assert.Equal(t, winner, order.UserID)
+
+		// Ending the auction again does not create a second order
+		again, err := orderService.CreateAuctionOrder(ctx, won.ID)
+		require.NoError(t, err)
+		assert.Equal(t, order.ID, again.ID)
+		assert.ErrorIs(t, auctionService.EndAuction(ctx, won.ID), auction.ErrAuctionNotLive)
+		assert.Error(t, db.Create(&models.OrderItem{OrderID: order.ID, ProductID: product.ID, Quantity: 1, AuctionID: &won.ID}).Error)
+		return order
+	}
+
+	// A winner with a default card and shipping address is charged the bid, tax and shipping
+	winner := uuid.New()
+	_, err := paymentService.SavePaymentMethod(ctx, winner, payments.FakeCardVisa, true)
+	require.NoError(t, err)
+	require.NoError(t, db.Create(&addresses.Address{
+		ID:           uuid.New(),
+		UserID:       winner,
+		Type:         "shipping",
+		Label:        "Home",
+		FirstName:    "Win",
+		LastName:     "Ner",
+		AddressLine1: "1 Main St",
+		City:         "Springfield",
+		State:        "IL",
+		PostalCode:   "62701",
+		Country:      "US",
+		IsDefault:    true,
+	}).Error)
+
+	paid := endAuction(winner, 10000)
+	assert.Equal(t, "processing", paid.Status)
+	assert.Equal(t, int64(10000), paid.Subtotal)
+	assert.Equal(t, int64(800), paid.TaxAmount)
+	assert.Equal(t, int64(599), paid.ShippingCost)
+	require.NotNil(t, paid.PaymentID)
+	var payment models.Payment
+	require.NoError(t, db.First(&payment, "id = ?", *paid.PaymentID).Error)
+	assert.Equal(t, "completed", payment.Status)
+	assert.Equal(t, int64(11399), payment.Amount)
+
+	// When the default card is declined the winner is left a payment to complete
+	declined := uuid.New()
+	_, err = paymentService.SavePaymentMethod(ctx, declined, payments.FakeCardDeclined, true)
+	require.NoError(t, err)
+
+	pending := endAuction(declined, 5000)
+	assert.Equal(t, "pending", pending.Status)
+	assert.Nil(t, pending.PaymentID)
+	var intent models.PaymentIntent
+	require.NoError(t, db.First(&intent, "order_id = ?", pending.ID).Error)
+	assert.Equal(t, payments.IntentRequiresPaymentMethod, intent.Status)
+	assert.Equal(t, int64(5000), intent.Amount)
+}
@@ -0,0 +1,219 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/internal/payments"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupPaymentTestDB creates an in-memory SQLite database with payment tables
+func setupPaymentTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	// A single connection keeps every query on the same in-memory database
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+
+	require.NoError(t, db.AutoMigrate(
+		&models.User{},
+		&models.Order{},
+		&models.Payment{},
+		&models.PaymentIntent{},
+		&models.PaymentMethod{},
+		&models.PaymentCustomer{},
+		&models.Refund{},
+		&models.RefundItem{},
+		&models.Dispute{},
+		&models.DisputeEvidence{},
+		&models.WebhookEvent{},
+	))
+
+	return db
+}
+
+// sendPaymentWebhook posts a signed fake gateway webhook and returns the response code
+func sendPaymentWebhook(t *testing.T, router *gin.Engine, gateway *payments.FakeGateway, event payments.GatewayEvent) int {
+	body, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest(http.MethodPost, "/webhooks/payments", bytes.NewReader(body))
+	req.Header.Set("Fake-Signature", gateway.SignWebhook(body))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestPaymentFlowWithFakeGateway(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupPaymentTestDB(t)
+	ctx := context.Background()
+
+	gateway := payments.NewFakeGateway()
+	service := payments.NewService(db, gateway)
+
+	router := gin.New()
+	router.POST("/webhooks/payments", payments.NewHandler(service).ProcessWebhook)
+	setUser := func(c *gin.Context) {
+		c.Set("user_id", uuid.MustParse(c.GetHeader("X-User")))
+	}
+	router.POST("/payments/confirm", setUser, payments.NewHandler(service).ConfirmPayment)
+	router.GET("/payments/intents/:id", setUser, payments.NewHandler(service).GetPaymentIntent)
+
+	buyer := uuid.New()
+	intent, err := service.CreatePaymentIntent(ctx, buyer, 4999, "USD", map[string]string{"source": "test"})
+	require.NoError(t, err)
+	assert.Equal(t, "fake", intent.GatewayType)
+	assert.Equal(t, payments.IntentRequiresPaymentMethod, intent.Status)
+	assert.NotEmpty(t, intent.ClientSecret)
+
+	// Only the buyer can read their intent and its client secret
+	readIntent := func(userID uuid.UUID) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, "/payments/intents/"+intent.ID.String(), nil)
+		req.Header.Set("X-User", userID.String())
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusOK, readIntent(buyer).Code)
+	stranger := readIntent(uuid.New())
+	assert.Equal(t, http.StatusNotFound, stranger.Code)
+	assert.NotContains(t, stranger.Body.String(), intent.ClientSecret)
+
+	// A declined card leaves the intent open for another attempt
+	_, err = service.ConfirmPayment(ctx, intent.ID, payments.FakeCardDeclined)
+	assert.ErrorIs(t, err, payments.ErrPaymentDeclined)
+
+	// Only the buyer can confirm their intent
+	body := []byte(`{"payment_intent_id":"` + intent.ID.String() + `","payment_method_id":"` + payments.FakeCardVisa + `"}`)
+	req, _ := http.NewRequest(http.MethodPost, "/payments/confirm", bytes.NewReader(body))
+	req.Header.Set("X-User", uuid.New().String())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	payment, err := service.ConfirmPayment(ctx, intent.ID, payments.FakeCardVisa)
+	require.NoError(t, err)
+	assert.Equal(t, "completed", payment.Status)
+	assert.Equal(t, int64(4999), payment.Amount)
+
+	// The gateway also reports success by webhook; no second payment is recorded
+	require.Equal(t, http.StatusOK, sendPaymentWebhook(t, router, gateway, payments.GatewayEvent{
+		ID:        "evt_1",
+		Type:      payments.EventPaymentSucceeded,
+		IntentRef: intent.GatewayRef,
+		Status:    payments.IntentSucceeded,
+	}))
+	_, err = service.ProcessPendingWebhooks(ctx, time.Now())
+	require.NoError(t, err)
+	var count int64
+	require.NoError(t, db.Model(&models.Payment{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+
+	// Unsigned webhooks are rejected
+	req, _ = http.NewRequest(http.MethodPost, "/webhooks/payments", bytes.NewReader([]byte(`{"type":"payment_intent.succeeded"}`)))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Refunds are limited to what was paid
+	admin := uuid.New()
+	refund, err := service.RefundPayment(ctx, payment.ID, 2000, "requested_by_customer", admin)
+	require.NoError(t, err)
+	assert.Equal(t, "succeeded", refund.Status)
+	_, err = service.RefundPayment(ctx, payment.ID, 3000, "requested_by_customer", admin)
+	assert.ErrorIs(t, err, payments.ErrRefundExceedsPayment)
+
+	// Saved methods carry the card details reported by the gateway
+	method, err := service.SavePaymentMethod(ctx, buyer, payments.FakeCardMastercard, true)
+	require.NoError(t, err)
+	require.NotNil(t, method.Last4)
+	assert.Equal(t, "4444", *method.Last4)
+
+	// Open intents can be cancelled, paid ones cannot
+	open, err := service.CreatePaymentIntent(ctx, buyer, 1000, "USD", nil)
+	require.NoError(t, err)
+	cancelled, err := service.CancelPaymentIntent(ctx, open.ID)
+	require.NoError(t, err)
+	assert.Equal(t, payments.IntentCanceled, cancelled.Status)
+	_, err = service.CancelPaymentIntent(ctx, intent.ID)
+	assert.ErrorIs(t, err, payments.ErrInvalidGatewayState)
+}
+
+func TestPaymentWebhookEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupPaymentTestDB(t)
+	ctx := context.Background()
+
+	gateway := payments.NewFakeGateway()
+	service := payments.NewService(db, gateway)
+
+	router := gin.New()
+	router.POST("/webhooks/payments", payments.NewHandler(service).ProcessWebhook)
+
+	intent, err := service.CreatePaymentIntent(ctx, uuid.New(), 2500, "USD", nil)
+	require.NoError(t, err)
+	event := payments.GatewayEvent{
+		ID:        "evt_retry",
+		Type:      payments.EventPaymentSucceeded,
+		IntentRef: intent.GatewayRef,
+		Status:    payments.IntentSucceeded,
+	}
+
+	// Redeliveries of the same event are stored once
+	require.Equal(t, http.StatusOK, sendPaymentWebhook(t, router, gateway, event))
+	require.Equal(t, http.StatusOK, sendPaymentWebhook(t, router, gateway, event))
+	var stored int64
+	require.NoError(t, db.Model(&models.WebhookEvent{}).Count(&stored).Error)
+	assert.Equal(t, int64(1), stored)
+
+	// A failing handler leaves the event failed with a retry scheduled
+	require.NoError(t, db.Migrator().DropTable(&models.Payment{}))
+	processed, err := service.ProcessPendingWebhooks(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, processed)
+
+	events, total, err := service.ListWebhookEvents(ctx, payments.WebhookFailed, 1, 20)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), total)
+	failed := events[0]
+	assert.Equal(t, 1, failed.Attempts)
+	require.NotNil(t, failed.LastError)
+	require.NotNil(t, failed.NextAttemptAt)
+
+	// The retry is not due yet
+	processed, err = service.ProcessPendingWebhooks(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, processed)
+
+	// Once the cause is fixed an admin replay processes it straight away
+	require.NoError(t, db.AutoMigrate(&models.Payment{}))
+	replayed, err := service.ReplayWebhookEvent(ctx, failed.ID)
+	require.NoError(t, err)
+	assert.Equal(t, payments.WebhookProcessed, replayed.Status)
+	assert.Equal(t, 2, replayed.Attempts)
+	assert.NotNil(t, replayed.ProcessedAt)
+
+	var count int64
+	require.NoError(t, db.Model(&models.Payment{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+
+	// Processed events cannot be replayed
+	_, err = service.ReplayWebhookEvent(ctx, failed.ID)
+	assert.ErrorIs(t, err, payments.ErrWebhookNotReplayable)
+}
@@ -0,0 +1,238 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/cart"
+	"github.com/blytz.live.remake/backend/internal/common"
+	"github.com/blytz.live.remake/backend/internal/fees"
+	"github.com/blytz.live.remake/backend/internal/ledger"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/internal/orders"
+	"github.com/blytz.live.remake/backend/internal/payments"
+	"github.com/blytz.live.remake/backend/internal/payouts"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// createDeliveredOrder creates an order for one seller's product, pays for it and marks
+// it delivered at deliveredAt
+func createDeliveredOrder(t *testing.T, db *gorm.DB, paymentService *payments.Service, sellerID uuid.UUID, total int64, deliveredAt time.Time) (uuid.UUID, *models.Payment) {
+	ctx := context.Background()
+	buyer := uuid.New()
+
+	product := models.Product{SellerID: sellerID, CategoryID: uuid.New(), Title: "Slab", StartingPrice: total, Status: "active"}
+	require.NoError(t, db.Create(&product).Error)
+	order := models.Order{BaseModel: common.BaseModel{ID: uuid.New()}, UserID: buyer, Status: "pending", TotalAmount: total, Subtotal: total}
+	require.NoError(t, db.Create(&order).Error)
+	require.NoError(t, db.Create(&models.OrderItem{OrderID: order.ID, ProductID: product.ID, Quantity: 1, UnitPrice: total, Total: total}).Error)
+
+	intent, err := paymentService.CreateOrderPaymentIntent(ctx, buyer, order.ID, "card")
+	require.NoError(t, err)
+	payment, err := paymentService.ConfirmPayment(ctx, intent.ID, payments.FakeCardVisa)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Model(&order).Updates(map[string]interface{}{
+		"status":       "delivered",
+		"delivered_at": deliveredAt,
+	}).Error)
+	return order.ID, payment
+}
+
+func TestSellerPayouts(t *testing.T) {
+	db := setupPaymentTestDB(t)
+	require.NoError(t, db.AutoMigrate(
+		&models.Product{},
+		&models.OrderItem{},
+		&models.FeeRule{},
+		&models.OrderFee{},
+		&models.Transaction{},
+		&models.LedgerAccount{},
+		&models.LedgerEntry{},
+		&models.Payout{},
+		&models.PayoutItem{},
+		&models.PayoutSettings{},
+	))
+	ctx := context.Background()
+	now := time.Now()
+
+	ledgerService := ledger.NewService(db)
+	paymentService := payments.NewService(db, payments.NewFakeGateway())
+	paymentService.SetLedger(ledgerService)
+	feeService := fees.NewService(db)
+	feeService.SetLedger(ledgerService)
+	orderService := orders.NewService(db, cart.NewService(db))
+	orderService.SetFeeAssessor(feeService)
+	paymentService.SetOrderPaymentHandler(orderService)
+	provider := payouts.NewFakeProvider()
+	service := payouts.NewService(db, provider, 7*24*time.Hour)
+	service.SetLedger(ledgerService)
+	_, err := feeService.CreateRule(ctx, fees.RuleRequest{Name: "Commission", Type: fees.TypePercentage, Percent: 10})
+	require.NoError(t, err)
+
+	seller := uuid.New()
+	_, payment := createDeliveredOrder(t, db, paymentService, seller, 10000, now.Add(-10*24*time.Hour))
+	_, err = paymentService.RefundPayment(ctx, payment.ID, 2000, "damaged", uuid.New())
+	require.NoError(t, err)
+	heldID, _ := createDeliveredOrder(t, db, paymentService, seller, 5000, now.Add(-24*time.Hour))
+
+	// Sellers must say where to be paid first
+	_, err = service.CreatePayouts(ctx, seller, seller, now)
+	assert.ErrorIs(t, err, payouts.ErrPayoutSettingsRequired)
+	_, err = service.UpdateSettings(ctx, seller, payouts.UpdateSettingsRequest{Schedule: payouts.ScheduleManual, Destination: "acct_seller"})
+	require.NoError(t, err)
+
+	// Refunds and the fees charged at payment are withheld; orders within the holding
+	// period wait
+	balances, err := service.GetBalance(ctx, seller, now)
+	require.NoError(t, err)
+	require.Len(t, balances, 1)
+	assert.Equal(t, int64(7000), balances[0].Available.Amount)
+	assert.Equal(t, int64(4500), balances[0].Held.Amount)
+
+	created, err := service.CreatePayouts(ctx, seller, seller, now)
+	require.NoError(t, err)
+	require.Len(t, created, 1)
+	payout := created[0]
+	assert.Equal(t, payouts.StatusInTransit, payout.Status)
+	assert.Equal(t, int64(10000), payout.Amount)
+	assert.Equal(t, int64(1000), payout.Fee)
+	assert.Equal(t, int64(2000), payout.RefundedAmount)
+	assert.Equal(t, int64(7000), payout.NetAmount)
+	assert.NotEmpty(t, payout.GatewayRef)
+
+	// An order is only paid out once
+	_, err = service.CreatePayouts(ctx, seller, seller, now)
+	assert.ErrorIs(t, err, payouts.ErrNothingToPayOut)
+
+	provider.Settle()
+	updated, err := service.SyncPayouts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, updated)
+	paid, err := service.GetPayout(ctx, payout.ID)
+	require.NoError(t, err)
+	assert.Equal(t, payouts.StatusPaid, paid.Status)
+	require.Len(t, paid.Items, 1)
+
+	// After the payout only the held order remains owed to the seller
+	owed, err := ledgerService.Balance(ctx, ledger.SellerAccount(seller), "USD")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4500), owed.Amount)
+
+	// A payout the provider rejects after the transfer stays pending and is retried
+	// without transferring the funds a second time
+	retried := uuid.New()
+	createDeliveredOrder(t, db, paymentService, retried, 4000, now.Add(-8*24*time.Hour))
+	_, err = service.UpdateSettings(ctx, retried, payouts.UpdateSettingsRequest{Schedule: payouts.ScheduleManual, Destination: "acct_retried"})
+	require.NoError(t, err)
+	transfers := provider.Transfers()
+	provider.FailPayouts(true)
+	created, err = service.CreatePayouts(ctx, retried, retried, now)
+	require.NoError(t, err)
+	require.Len(t, created, 1)
+	assert.Equal(t, payouts.StatusPending, created[0].Status)
+	assert.NotEmpty(t, created[0].TransferRef)
+	assert.Empty(t, created[0].GatewayRef)
+	_, err = service.CreatePayouts(ctx, retried, retried, now)
+	assert.ErrorIs(t, err, payouts.ErrNothingToPayOut)
+	updated, err = service.SyncPayouts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, updated)
+	provider.FailPayouts(false)
+	updated, err = service.SyncPayouts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, updated)
+	resent, err := service.GetPayout(ctx, created[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, payouts.StatusInTransit, resent.Status)
+	assert.NotEmpty(t, resent.GatewayRef)
+	assert.Nil(t, resent.FailureReason)
+	assert.Equal(t, transfers+1, provider.Transfers())
+
+	// Daily schedules run once a day. A payout that fails at the bank after its transfer
+	// keeps its orders, whose funds are in the seller's connected account.
+	failing := uuid.New()
+	createDeliveredOrder(t, db, paymentService, failing, 3000, now.Add(-8*24*time.Hour))
+	_, err = service.UpdateSettings(ctx, failing, payouts.UpdateSettingsRequest{Schedule: payouts.ScheduleDaily, Destination: payouts.FakeDestinationFailing})
+	require.NoError(t, err)
+	count, err := service.RunScheduledPayouts(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	count, err = service.RunScheduledPayouts(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	provider.Settle()
+	_, err = service.SyncPayouts(ctx)
+	require.NoError(t, err)
+	history, total, err := service.ListPayouts(ctx, &failing, "", 1, 20)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, payouts.StatusFailed, history[0].Status)
+	require.NotNil(t, history[0].FailureReason)
+	available, _, err := service.Earnings(ctx, failing, now)
+	require.NoError(t, err)
+	assert.Empty(t, available)
+	_, err = service.CreatePayouts(ctx, failing, failing, now)
+	assert.ErrorIs(t, err, payouts.ErrNothingToPayOut)
+
+	// A payout whose transfer fails releases its orders again
+	unpaid := uuid.New()
+	createDeliveredOrder(t, db, paymentService, unpaid, 3000, now.Add(-8*24*time.Hour))
+	_, err = service.UpdateSettings(ctx, unpaid, payouts.UpdateSettingsRequest{Schedule: payouts.ScheduleManual, Destination: "bank_not_connected"})
+	require.NoError(t, err)
+	created, err = service.CreatePayouts(ctx, unpaid, unpaid, now)
+	require.NoError(t, err)
+	require.Len(t, created, 1)
+	assert.Equal(t, payouts.StatusFailed, created[0].Status)
+	available, _, err = service.Earnings(ctx, unpaid, now)
+	require.NoError(t, err)
+	require.Len(t, available, 1)
+	assert.Equal(t, int64(2700), available[0].Net.Amount)
+
+	// The held order becomes available once its holding period is over
+	available, _, err = service.Earnings(ctx, seller, now.Add(7*24*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, available, 1)
+	assert.Equal(t, heldID, available[0].OrderID)
+
+	// A refund on an order already paid out is taken off the next payout
+	_, err = paymentService.RefundPayment(ctx, payment.ID, 1000, "late damage", uuid.New())
+	require.NoError(t, err)
+	later := now.Add(7 * 24 * time.Hour)
+	balances, err = service.GetBalance(ctx, seller, later)
+	require.NoError(t, err)
+	require.Len(t, balances, 1)
+	assert.Equal(t, int64(-1000), balances[0].Adjustments.Amount)
+	assert.Equal(t, int64(3500), balances[0].Available.Amount)
+	created, err = service.CreatePayouts(ctx, seller, seller, later)
+	require.NoError(t, err)
+	require.Len(t, created, 1)
+	assert.Equal(t, int64(-1000), created[0].Adjustment)
+	assert.Equal(t, int64(3500), created[0].NetAmount)
+	balances, err = service.GetBalance(ctx, seller, later)
+	require.NoError(t, err)
+	require.Len(t, balances, 1)
+	assert.Equal(t, int64(0), balances[0].Adjustments.Amount)
+	assert.Equal(t, int64(0), balances[0].Available.Amount)
+
+	// A refund larger than the new earnings is carried until there is enough to cover it
+	_, err = paymentService.RefundPayment(ctx, payment.ID, 4000, "returned", uuid.New())
+	require.NoError(t, err)
+	createDeliveredOrder(t, db, paymentService, seller, 2000, now.Add(-8*24*time.Hour))
+	_, err = service.CreatePayouts(ctx, seller, seller, later)
+	assert.ErrorIs(t, err, payouts.ErrNothingToPayOut)
+	balances, err = service.GetBalance(ctx, seller, later)
+	require.NoError(t, err)
+	require.Len(t, balances, 1)
+	assert.Equal(t, int64(-4000), balances[0].Adjustments.Amount)
+	assert.Equal(t, int64(-2200), balances[0].Available.Amount)
+
+	report, err := ledgerService.CheckInvariants(ctx)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), report.Violations)
+}
@@ -29,6 +29,7 @@ func setupTestDB() *gorm.DB {
 		&models.User{},
 		&models.Category{},
 		&models.Product{},
+		&models.HighlightClip{},
 	)
 	if err != nil {
 		log.Fatal("Failed to migrate test database:", err)
@@ -122,9 +123,9 @@ func TestProductFlow(t *testing.T) {
 			"title":          "Test Product",
 			"description":    stringPtr("This is a test product"),
 			"condition":      stringPtr("new"),
-			"starting_price": 100.00,
-			"reserve_price":  float64Ptr(150.00),
-			"buy_now_price":  float64Ptr(200.00),
+			"starting_price": 10000,
+			"reserve_price":  int64Ptr(15000),
+			"buy_now_price":  int64Ptr(20000),
 			"images":         []string{"http://example.com/image1.jpg", "http://example.com/image2.jpg"},
 			"specifications": map[string]interface{}{
 				"brand": "Test Brand",
@@ -160,8 +161,8 @@ func TestProductFlow(t *testing.T) {
 			t.Errorf("Expected title 'Test Product', got '%s'", product["title"])
 		}
 
-		if product["starting_price"].(float64) != 100.00 {
-			t.Errorf("Expected starting_price 100.00, got %f", product["starting_price"])
+		if product["starting_price"].(float64) != 10000 {
+			t.Errorf("Expected starting_price 10000, got %f", product["starting_price"])
 		}
 
 		// Store product ID for subsequent tests
@@ -271,9 +272,9 @@ func TestProductFlow(t *testing.T) {
 	})
 }
 
-// Helper function to create float64 pointers
-func float64Ptr(f float64) *float64 {
-	return &f
+// Helper function to create int64 pointers
+func int64Ptr(i int64) *int64 {
+	return &i
 }
 
 var productID string
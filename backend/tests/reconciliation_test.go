@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/cart"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/internal/orders"
+	"github.com/blytz.live.remake/backend/internal/payments"
+	"github.com/blytz.live.remake/backend/internal/reconciliation"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaymentReconciliation(t *testing.T) {
+	db := setupPaymentTestDB(t)
+	require.NoError(t, db.AutoMigrate(
+		&models.Product{},
+		&models.OrderItem{},
+		&models.ReconciliationRun{},
+		&models.ReconciliationItem{},
+	))
+	ctx := context.Background()
+	now := time.Now()
+
+	gateway := payments.NewFakeGateway()
+	paymentService := payments.NewService(db, gateway)
+	paymentService.SetOrderPaymentHandler(orders.NewService(db, cart.NewService(db)))
+	service := reconciliation.NewService(db, gateway)
+
+	// Two settled payments, one partly refunded
+	_, refunded := createDeliveredOrder(t, db, paymentService, uuid.New(), 4000, now)
+	_, altered := createDeliveredOrder(t, db, paymentService, uuid.New(), 2500, now)
+	_, err := paymentService.RefundPayment(ctx, refunded.ID, 1500, "requested_by_customer", uuid.New())
+	require.NoError(t, err)
+
+	from, to := now.Add(-time.Hour), now.Add(time.Hour)
+	run, err := service.Reconcile(ctx, from, to)
+	require.NoError(t, err)
+	assert.Equal(t, reconciliation.StatusCompleted, run.Status)
+	assert.Equal(t, 3, run.Matched)
+	assert.Empty(t, run.Items)
+
+	// A payment recorded for a different amount, one the gateway never settled and a
+	// charge nobody recorded
+	require.NoError(t, db.Model(&models.Payment{}).Where("id = ?", altered.ID).Update("amount", 2600).Error)
+	missing := models.Payment{
+		UserID:      uuid.New(),
+		Amount:      1200,
+		Currency:    "USD",
+		Status:      "completed",
+		GatewayRef:  "pi_missing",
+		GatewayType: gateway.Name(),
+		ProcessedAt: &now,
+	}
+	require.NoError(t, db.Create(&missing).Error)
+	gateway.AddBalanceTransaction(payments.GatewayBalanceTransaction{
+		Kind:      payments.BalancePayment,
+		SourceRef: "pi_orphaned",
+		Amount:    900,
+		Currency:  "USD",
+	})
+
+	run, err = service.Reconcile(ctx, from, to)
+	require.NoError(t, err)
+	assert.Equal(t, 2, run.Matched)
+	assert.Equal(t, 1, run.Missing)
+	assert.Equal(t, 1, run.Mismatched)
+	assert.Equal(t, 1, run.Orphaned)
+
+	stored, err := service.GetRun(ctx, run.ID)
+	require.NoError(t, err)
+	require.Len(t, stored.Items, 3)
+	problems := make(map[string]models.ReconciliationItem)
+	for _, item := range stored.Items {
+		problems[item.Problem] = item
+	}
+	assert.Equal(t, "pi_missing", problems[reconciliation.ProblemMissing].GatewayRef)
+	assert.Equal(t, altered.GatewayRef, problems[reconciliation.ProblemMismatched].GatewayRef)
+	require.NotNil(t, problems[reconciliation.ProblemMismatched].GatewayAmount)
+	assert.Equal(t, int64(2500), *problems[reconciliation.ProblemMismatched].GatewayAmount)
+	assert.Equal(t, int64(2600), *problems[reconciliation.ProblemMismatched].LocalAmount)
+	assert.Equal(t, "pi_orphaned", problems[reconciliation.ProblemOrphaned].GatewayRef)
+	assert.Equal(t, int64(900), *problems[reconciliation.ProblemOrphaned].GatewayAmount)
+
+	// Periods end after they start
+	_, err = service.Reconcile(ctx, to, from)
+	assert.ErrorIs(t, err, reconciliation.ErrInvalidPeriod)
+
+	runs, total, err := service.ListRuns(ctx, 1, 20)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	assert.Len(t, runs, 2)
+}
@@ -0,0 +1,315 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/cart"
+	"github.com/blytz.live.remake/backend/internal/common"
+	"github.com/blytz.live.remake/backend/internal/ledger"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/internal/orders"
+	"github.com/blytz.live.remake/backend/internal/payments"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefundWorkflow(t *testing.T) {
+	db := setupPaymentTestDB(t)
+	require.NoError(t, db.AutoMigrate(
+		&models.Product{},
+		&models.OrderItem{},
+		&models.OrderFee{},
+		&models.InventoryStock{},
+		&models.StockMovement{},
+		&models.Transaction{},
+		&models.LedgerAccount{},
+		&models.LedgerEntry{},
+	))
+	ctx := context.Background()
+
+	ledgerService := ledger.NewService(db)
+	service := payments.NewService(db, payments.NewFakeGateway())
+	service.SetLedger(ledgerService)
+	service.SetOrderPaymentHandler(orders.NewService(db, cart.NewService(db)))
+
+	// Seller A sells two units at 25, seller B one at 30
+	buyer, sellerA, sellerB, admin := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	productA := models.Product{SellerID: sellerA, CategoryID: uuid.New(), Title: "Booster", StartingPrice: 2500, Status: "active"}
+	productB := models.Product{SellerID: sellerB, CategoryID: uuid.New(), Title: "Binder", StartingPrice: 3000, Status: "active"}
+	require.NoError(t, db.Create(&productA).Error)
+	require.NoError(t, db.Create(&productB).Error)
+	require.NoError(t, db.Create(&models.InventoryStock{ProductID: productA.ID, Quantity: 10, Reserved: 2, Available: 8}).Error)
+
+	order := models.Order{BaseModel: common.BaseModel{ID: uuid.New()}, UserID: buyer, Status: "pending", TotalAmount: 8000, Subtotal: 8000}
+	require.NoError(t, db.Create(&order).Error)
+	itemA := models.OrderItem{OrderID: order.ID, ProductID: productA.ID, Quantity: 2, UnitPrice: 2500, Total: 5000}
+	itemB := models.OrderItem{OrderID: order.ID, ProductID: productB.ID, Quantity: 1, UnitPrice: 3000, Total: 3000}
+	require.NoError(t, db.Create(&itemA).Error)
+	require.NoError(t, db.Create(&itemB).Error)
+
+	intent, err := service.CreateOrderPaymentIntent(ctx, buyer, order.ID, "card")
+	require.NoError(t, err)
+	payment, err := service.ConfirmPayment(ctx, intent.ID, payments.FakeCardVisa)
+	require.NoError(t, err)
+	require.NoError(t, db.Model(&order).Update("status", "delivered").Error)
+
+	sellerRefund := func(sellerID uuid.UUID, item uuid.UUID, quantity int) (*models.Refund, error) {
+		return service.RequestRefund(ctx, payments.RefundRequest{
+			PaymentID:   payment.ID,
+			Items:       []payments.RefundItemRequest{{OrderItemID: item, Quantity: quantity}},
+			Reason:      "requested_by_customer",
+			Restock:     true,
+			RequestedBy: sellerID,
+			SellerID:    &sellerID,
+		})
+	}
+
+	// Sellers only refund their own items, and wait for approval
+	_, err = sellerRefund(sellerB, itemA.ID, 1)
+	assert.ErrorIs(t, err, payments.ErrRefundNotAllowed)
+	requested, err := sellerRefund(sellerA, itemA.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, payments.RefundPendingApproval, requested.Status)
+	assert.Equal(t, int64(2500), requested.Amount)
+
+	// Items awaiting approval count against what is left to refund
+	_, err = sellerRefund(sellerA, itemA.ID, 2)
+	assert.ErrorIs(t, err, payments.ErrInvalidRefundItem)
+
+	approved, err := service.ApproveRefund(ctx, requested.ID, admin)
+	require.NoError(t, err)
+	assert.Equal(t, payments.RefundSucceeded, approved.Status)
+	_, err = service.ApproveRefund(ctx, requested.ID, admin)
+	assert.ErrorIs(t, err, payments.ErrRefundNotPending)
+
+	var updated models.Payment
+	require.NoError(t, db.First(&updated, "id = ?", payment.ID).Error)
+	assert.Equal(t, "partially_refunded", updated.Status)
+	assert.Equal(t, int64(2500), updated.RefundedAmount)
+
+	// The returned unit goes back to stock
+	var stock models.InventoryStock
+	require.NoError(t, db.First(&stock, "product_id = ?", productA.ID).Error)
+	assert.Equal(t, 1, stock.Reserved)
+	assert.Equal(t, 9, stock.Available)
+	var movements int64
+	db.Model(&models.StockMovement{}).Where("reference = ?", "refund:"+requested.ID.String()).Count(&movements)
+	assert.Equal(t, int64(1), movements)
+
+	// Rejected requests free their items again
+	rejected, err := sellerRefund(sellerA, itemA.ID, 1)
+	require.NoError(t, err)
+	_, err = service.RejectRefund(ctx, rejected.ID, admin, nil)
+	require.NoError(t, err)
+	pending, total, err := service.ListRefunds(ctx, payments.RefundFilter{Status: payments.RefundPendingApproval}, 1, 20)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), total)
+	assert.Empty(t, pending)
+
+	// A request that cannot be reserved when it is approved, here because the card is
+	// used up and there is no store credit to pay the rest, stays awaiting approval and
+	// is never sent
+	blocked, err := sellerRefund(sellerA, itemA.ID, 1)
+	require.NoError(t, err)
+	require.NoError(t, db.Model(&models.Payment{}).Where("id = ?", payment.ID).Update("refunded_amount", 8000).Error)
+	_, err = service.ApproveRefund(ctx, blocked.ID, admin)
+	assert.ErrorIs(t, err, payments.ErrStoreCreditUnavailable)
+	retried, err := service.RetryProcessingRefunds(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 0, retried)
+	blocked, err = service.GetRefund(ctx, blocked.ID)
+	require.NoError(t, err)
+	assert.Equal(t, payments.RefundPendingApproval, blocked.Status)
+	require.NoError(t, db.Model(&models.Payment{}).Where("id = ?", payment.ID).Update("refunded_amount", 2500).Error)
+	_, err = service.RejectRefund(ctx, blocked.ID, admin, nil)
+	require.NoError(t, err)
+
+	// Nothing beyond the unrefunded amount can be refunded
+	_, err = service.RefundPayment(ctx, payment.ID, 6000, "requested_by_customer", admin)
+	assert.ErrorIs(t, err, payments.ErrRefundExceedsPayment)
+
+	// Refunding everything left refunds the payment and the order
+	_, err = service.RequestRefund(ctx, payments.RefundRequest{
+		PaymentID: payment.ID,
+		Items: []payments.RefundItemRequest{
+			{OrderItemID: itemA.ID, Quantity: 1},
+			{OrderItemID: itemB.ID, Quantity: 1},
+		},
+		Reason:      "requested_by_customer",
+		RequestedBy: admin,
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.First(&updated, "id = ?", payment.ID).Error)
+	assert.Equal(t, "refunded", updated.Status)
+	assert.Equal(t, int64(8000), updated.RefundedAmount)
+	var refundedOrder models.Order
+	require.NoError(t, db.First(&refundedOrder, "id = ?", order.ID).Error)
+	assert.Equal(t, "refunded", refundedOrder.Status)
+
+	// Each seller bore the refunds of their own items
+	for _, seller := range []uuid.UUID{sellerA, sellerB} {
+		owed, err := ledgerService.Balance(ctx, ledger.SellerAccount(seller), "USD")
+		require.NoError(t, err)
+		assert.True(t, owed.IsZero(), owed.String())
+	}
+	report, err := ledgerService.CheckInvariants(ctx)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), report.Violations)
+}
+
+// timeoutGateway makes its first refund but reports a timeout, like a response lost on
+// the network
+type timeoutGateway struct {
+	*payments.FakeGateway
+	timedOut bool
+}
+
+func (g *timeoutGateway) Refund(ctx context.Context, params payments.RefundParams) (*payments.GatewayRefund, error) {
+	refund, err := g.FakeGateway.Refund(ctx, params)
+	if err != nil || g.timedOut {
+		return refund, err
+	}
+	g.timedOut = true
+	return nil, errors.New("gateway timeout")
+}
+
+func TestRefundRetriedAfterGatewayTimeout(t *testing.T) {
+	db := setupPaymentTestDB(t)
+	ctx := context.Background()
+
+	gateway := &timeoutGateway{FakeGateway: payments.NewFakeGateway()}
+	service := payments.NewService(db, gateway)
+
+	buyer, admin := uuid.New(), uuid.New()
+	intent, err := service.CreatePaymentIntent(ctx, buyer, 5000, "USD", nil)
+	require.NoError(t, err)
+	payment, err := service.ConfirmPayment(ctx, intent.ID, payments.FakeCardVisa)
+	require.NoError(t, err)
+
+	// The refund is kept as processing and still counts against what is left to refund
+	_, err = service.RefundPayment(ctx, payment.ID, 2000, "requested_by_customer", admin)
+	assert.Error(t, err)
+	refunds, _, err := service.ListRefunds(ctx, payments.RefundFilter{PaymentID: &payment.ID}, 1, 20)
+	require.NoError(t, err)
+	require.Len(t, refunds, 1)
+	assert.Equal(t, payments.RefundProcessing, refunds[0].Status)
+	_, err = service.RefundPayment(ctx, payment.ID, 4000, "requested_by_customer", admin)
+	assert.ErrorIs(t, err, payments.ErrRefundExceedsPayment)
+
+	// Sending it again completes it without refunding the card twice
+	retried, err := service.RetryProcessingRefunds(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, retried)
+	refund, err := service.GetRefund(ctx, refunds[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, payments.RefundSucceeded, refund.Status)
+	assert.NotEmpty(t, refund.GatewayRef)
+
+	var updated models.Payment
+	require.NoError(t, db.First(&updated, "id = ?", payment.ID).Error)
+	assert.Equal(t, int64(2000), updated.RefundedAmount)
+	settled, err := gateway.ListBalanceTransactions(ctx, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	refunded := 0
+	for _, transaction := range settled {
+		if transaction.Kind == payments.BalanceRefund {
+			refunded++
+		}
+	}
+	assert.Equal(t, 1, refunded)
+
+	retried, err = service.RetryProcessingRefunds(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 0, retried)
+
+	// A refund left processing is checked against its payment again before it is sent,
+	// and fails once the payment can no longer be refunded
+	gateway.timedOut = false
+	_, err = service.RefundPayment(ctx, payment.ID, 1000, "requested_by_customer", admin)
+	assert.Error(t, err)
+	require.NoError(t, db.Model(&models.Payment{}).Where("id = ?", payment.ID).Update("status", "disputed").Error)
+	retried, err = service.RetryProcessingRefunds(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 0, retried)
+	failed, _, err := service.ListRefunds(ctx, payments.RefundFilter{PaymentID: &payment.ID, Status: payments.RefundFailed}, 1, 20)
+	require.NoError(t, err)
+	assert.Len(t, failed, 1)
+}
+
+// pendingRefundGateway accepts refunds the card network settles later; their outcome
+// is reported by webhook
+type pendingRefundGateway struct {
+	*payments.FakeGateway
+}
+
+func (g *pendingRefundGateway) Refund(ctx context.Context, params payments.RefundParams) (*payments.GatewayRefund, error) {
+	return &payments.GatewayRefund{
+		Ref:      "re_pending_" + params.Metadata["refund_id"],
+		Status:   payments.RefundPending,
+		Metadata: params.Metadata,
+	}, nil
+}
+
+func TestPendingRefundsSyncedByWebhook(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupPaymentTestDB(t)
+	ctx := context.Background()
+
+	gateway := &pendingRefundGateway{FakeGateway: payments.NewFakeGateway()}
+	service := payments.NewService(db, gateway)
+	router := gin.New()
+	router.POST("/webhooks/payments", payments.NewHandler(service).ProcessWebhook)
+	refundUpdated := func(id string, refund *models.Refund, status string) {
+		require.Equal(t, http.StatusOK, sendPaymentWebhook(t, router, gateway.FakeGateway, payments.GatewayEvent{
+			ID:        id,
+			Type:      payments.EventRefundUpdated,
+			RefundRef: refund.GatewayRef,
+			Status:    status,
+		}))
+		_, err := service.ProcessPendingWebhooks(ctx, time.Now())
+		require.NoError(t, err)
+	}
+	buyer, admin := uuid.New(), uuid.New()
+	intent, err := service.CreatePaymentIntent(ctx, buyer, 5000, "USD", nil)
+	require.NoError(t, err)
+	payment, err := service.ConfirmPayment(ctx, intent.ID, payments.FakeCardVisa)
+	require.NoError(t, err)
+	paymentState := func() models.Payment {
+		var current models.Payment
+		require.NoError(t, db.First(&current, "id = ?", payment.ID).Error)
+		return current
+	}
+
+	// A pending refund holds its amount but is not applied until the gateway settles it
+	first, err := service.RefundPayment(ctx, payment.ID, 2000, "requested_by_customer", admin)
+	require.NoError(t, err)
+	assert.Equal(t, payments.RefundPending, first.Status)
+	assert.Equal(t, int64(0), paymentState().RefundedAmount)
+	_, err = service.RefundPayment(ctx, payment.ID, 4000, "requested_by_customer", admin)
+	assert.ErrorIs(t, err, payments.ErrRefundExceedsPayment)
+
+	refundUpdated("evt_refund_succeeded", first, "succeeded")
+	first, err = service.GetRefund(ctx, first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, payments.RefundSucceeded, first.Status)
+	assert.Equal(t, int64(2000), paymentState().RefundedAmount)
+	assert.Equal(t, "partially_refunded", paymentState().Status)
+
+	// A refund the gateway fails frees its amount again
+	second, err := service.RefundPayment(ctx, payment.ID, 3000, "requested_by_customer", admin)
+	require.NoError(t, err)
+	refundUpdated("evt_refund_failed", second, "failed")
+	second, err = service.GetRefund(ctx, second.ID)
+	require.NoError(t, err)
+	assert.Equal(t, payments.RefundFailed, second.Status)
+	assert.Equal(t, int64(2000), paymentState().RefundedAmount)
+
+	_, err = service.RefundPayment(ctx, payment.ID, 3000, "requested_by_customer", admin)
+	require.NoError(t, err)
+}
@@ -0,0 +1,183 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/blytz.live.remake/backend/internal/auction"
+	"github.com/blytz.live.remake/backend/internal/common"
+	"github.com/blytz.live.remake/backend/internal/ledger"
+	"github.com/blytz.live.remake/backend/internal/models"
+	"github.com/blytz.live.remake/backend/internal/payments"
+	"github.com/blytz.live.remake/backend/internal/subscriptions"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSellerSubscriptions(t *testing.T) {
+	db := setupPaymentTestDB(t)
+	require.NoError(t, db.AutoMigrate(
+		&models.Subscription{},
+		&models.Auction{},
+		&models.Transaction{},
+		&models.LedgerAccount{},
+		&models.LedgerEntry{},
+	))
+	ctx := context.Background()
+
+	ledgerService := ledger.NewService(db)
+	paymentService := payments.NewService(db, payments.NewFakeGateway())
+	paymentService.SetLedger(ledgerService)
+	service := subscriptions.NewService(db, paymentService)
+	service.SetLedger(ledgerService)
+
+	newSeller := func(email string) uuid.UUID {
+		seller := models.User{Email: email, PasswordHash: "hash", Role: "seller"}
+		require.NoError(t, db.Create(&seller).Error)
+		return seller.ID
+	}
+	tier := func(sellerID uuid.UUID) string {
+		var seller models.User
+		require.NoError(t, db.First(&seller, "id = ?", sellerID).Error)
+		return seller.SellerTier
+	}
+	revenue := func() int64 {
+		balance, err := ledgerService.Balance(ctx, ledger.SubscriptionsAccount(), "USD")
+		require.NoError(t, err)
+		return balance.Amount
+	}
+
+	seller := newSeller("plans@example.com")
+
+	// Sellers without a subscription are on the free plan and need a card to subscribe
+	entitlements, err := service.Entitlements(ctx, seller)
+	require.NoError(t, err)
+	assert.Equal(t, subscriptions.PlanFree, entitlements.Plan.ID)
+	_, err = service.Subscribe(ctx, seller, subscriptions.SubscribeRequest{PlanID: subscriptions.PlanPro})
+	assert.ErrorIs(t, err, subscriptions.ErrPaymentMethodRequired)
+	_, err = paymentService.SavePaymentMethod(ctx, seller, payments.FakeCardVisa, true)
+	require.NoError(t, err)
+
+	// The first subscription starts with a free trial
+	subscription, err := service.Subscribe(ctx, seller, subscriptions.SubscribeRequest{PlanID: subscriptions.PlanPro})
+	require.NoError(t, err)
+	assert.Equal(t, subscriptions.StatusTrialing, subscription.Status)
+	require.NotNil(t, subscription.TrialEnd)
+	assert.Equal(t, "pro", tier(seller))
+	_, err = service.Subscribe(ctx, seller, subscriptions.SubscribeRequest{PlanID: subscriptions.PlanEnterprise})
+	assert.ErrorIs(t, err, subscriptions.ErrAlreadySubscribed)
+
+	// Downgrades wait for the renewal
+	subscription, err = service.ChangePlan(ctx, seller, subscriptions.ChangePlanRequest{PlanID: subscriptions.PlanEnterprise})
+	require.NoError(t, err)
+	assert.Equal(t, subscriptions.PlanEnterprise, subscription.PlanID)
+	subscription, err = service.ChangePlan(ctx, seller, subscriptions.ChangePlanRequest{PlanID: subscriptions.PlanPro})
+	require.NoError(t, err)
+	assert.Equal(t, subscriptions.PlanEnterprise, subscription.PlanID)
+	require.NotNil(t, subscription.PendingPlanID)
+	assert.Equal(t, int64(0), revenue())
+
+	// The trial ends with the first charge, at the scheduled plan's price
+	trialEnd := *subscription.TrialEnd
+	processed, err := service.RenewDue(ctx, trialEnd.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, processed)
+	subscription, err = service.Cancel(ctx, seller, false)
+	require.NoError(t, err)
+	assert.Equal(t, subscriptions.StatusActive, subscription.Status)
+	assert.Equal(t, subscriptions.PlanPro, subscription.PlanID)
+	assert.Nil(t, subscription.PendingPlanID)
+	assert.True(t, subscription.CurrentPeriodStart.Equal(trialEnd))
+	assert.Equal(t, int64(2900), revenue())
+	assert.Equal(t, "pro", tier(seller))
+	subscription, err = service.Resume(ctx, seller)
+	require.NoError(t, err)
+	assert.False(t, subscription.CancelAtPeriodEnd)
+
+	// Upgrading charges the difference for the rest of the period
+	subscription, err = service.ChangePlan(ctx, seller, subscriptions.ChangePlanRequest{PlanID: subscriptions.PlanEnterprise})
+	require.NoError(t, err)
+	assert.Equal(t, subscriptions.PlanEnterprise, subscription.PlanID)
+	assert.Equal(t, int64(2900+17000), revenue())
+	assert.Equal(t, "enterprise", tier(seller))
+
+	// A declined renewal is retried on the dunning schedule, keeping the plan meanwhile
+	declined, err := paymentService.SavePaymentMethod(ctx, seller, payments.FakeCardDeclined, false)
+	require.NoError(t, err)
+	_, err = service.UpdatePaymentMethod(ctx, seller, subscriptions.PaymentMethodRequest{PaymentMethodID: declined.ID})
+	require.NoError(t, err)
+	now := subscription.CurrentPeriodEnd.Add(time.Minute)
+	for attempt := 1; attempt <= len(subscriptions.DunningSchedule); attempt++ {
+		_, err = service.RenewDue(ctx, now)
+		require.NoError(t, err)
+		entitlements, err = service.Entitlements(ctx, seller)
+		require.NoError(t, err)
+		require.NotNil(t, entitlements.Subscription)
+		assert.Equal(t, subscriptions.StatusPastDue, entitlements.Subscription.Status)
+		assert.Equal(t, attempt, entitlements.Subscription.FailedAttempts)
+		assert.Equal(t, subscriptions.PlanEnterprise, entitlements.Plan.ID)
+		now = entitlements.Subscription.NextRetryAt.Add(time.Minute)
+	}
+	_, err = service.ChangePlan(ctx, seller, subscriptions.ChangePlanRequest{PlanID: subscriptions.PlanPro})
+	assert.ErrorIs(t, err, subscriptions.ErrSubscriptionPastDue)
+
+	// After the last retry fails the seller is back on the free plan
+	_, err = service.RenewDue(ctx, now)
+	require.NoError(t, err)
+	entitlements, err = service.Entitlements(ctx, seller)
+	require.NoError(t, err)
+	assert.Equal(t, subscriptions.PlanFree, entitlements.Plan.ID)
+	assert.Equal(t, "standard", tier(seller))
+	var unpaid models.Subscription
+	require.NoError(t, db.First(&unpaid, "id = ?", subscription.ID).Error)
+	assert.Equal(t, subscriptions.StatusUnpaid, unpaid.Status)
+
+	// Trials are once per seller; the next subscription is charged up front
+	_, err = paymentService.SavePaymentMethod(ctx, seller, payments.FakeCardMastercard, true)
+	require.NoError(t, err)
+	subscription, err = service.Subscribe(ctx, seller, subscriptions.SubscribeRequest{PlanID: subscriptions.PlanPro})
+	require.NoError(t, err)
+	assert.Equal(t, subscriptions.StatusActive, subscription.Status)
+	assert.Nil(t, subscription.TrialStart)
+
+	// Cancelling at the period end ends the subscription at the next renewal
+	_, err = service.Cancel(ctx, seller, false)
+	require.NoError(t, err)
+	_, err = service.RenewDue(ctx, subscription.CurrentPeriodEnd.Add(time.Minute))
+	require.NoError(t, err)
+	_, err = service.Cancel(ctx, seller, false)
+	assert.ErrorIs(t, err, subscriptions.ErrNoSubscription)
+	assert.Equal(t, "standard", tier(seller))
+
+	retention, err := service.RecordingRetention(ctx, seller)
+	require.NoError(t, err)
+	assert.Equal(t, 7*24*time.Hour, retention)
+
+	report, err := ledgerService.CheckInvariants(ctx)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), report.Violations)
+
+	// Free sellers can only have one auction live at a time
+	auctionService := auction.NewService(db)
+	auctionService.SetEntitlements(service)
+	var shows []models.Auction
+	for i := 0; i < 2; i++ {
+		show := models.Auction{
+			BaseModel:   common.BaseModel{ID: uuid.New()},
+			ProductID:   uuid.New(),
+			SellerID:    seller,
+			Title:       "Breaks",
+			StartTime:   time.Now().Add(time.Hour),
+			EndTime:     time.Now().Add(2 * time.Hour),
+			Status:      "scheduled",
+			StartPrice:  1,
+			LiveKitRoom: uuid.New().String(),
+		}
+		require.NoError(t, db.Create(&show).Error)
+		shows = append(shows, show)
+	}
+	require.NoError(t, auctionService.StartAuction(ctx, shows[0].ID))
+	assert.ErrorIs(t, auctionService.StartAuction(ctx, shows[1].ID), auction.ErrLiveAuctionLimit)
+}